@@ -0,0 +1,323 @@
+package libhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A LogFormat is an output format of AccessLogFilter.
+type LogFormat int
+
+const (
+	// LogFormatCombined is Apache's Combined Log Format: the Common Log Format followed by the Referer and User-Agent.
+	LogFormatCombined LogFormat = iota
+	// LogFormatCommon is the Common Log Format of Apache and most other web servers.
+	LogFormatCommon
+	// LogFormatJSON writes each entry as a JSON object of the access log's fields, on one line.
+	LogFormatJSON
+	// LogFormatLogfmt writes each entry as the access log's fields in logfmt (key=value pairs).
+	LogFormatLogfmt
+)
+
+// An AccessLogEntry describes a request handled by a server, for an access log.
+type AccessLogEntry struct {
+	Time      time.Time // when the request was received
+	Request   Request
+	Status    int
+	Bytes     int64 // bytes of the response body sent, or -1 if unknown
+	Duration  time.Duration
+	Route     string // the pattern of the Router route which handled the request, if any (see also SetOperation)
+	RequestID string // from the X-Request-ID header of the response or request, if any
+}
+
+// An AccessLogOption configures an AccessLogFilter.
+type AccessLogOption func(*accessLogOptions)
+
+type accessLogOptions struct {
+	format    LogFormat
+	fields    []string
+	fieldsSet bool     // whether fields were chosen with AccessLogFields
+	extra     []string // fields added by AccessLogField
+	derived   map[string]func(AccessLogEntry) string
+	sampler   *LogSampler
+}
+
+// AccessLogFormat sets the format of the access log. The default is LogFormatCombined.
+func AccessLogFormat(f LogFormat) AccessLogOption {
+	return func(o *accessLogOptions) {
+		o.format = f
+	}
+}
+
+// AccessLogSampler samples the entries which are written with s (see LogSampler). By default, every request is logged.
+func AccessLogSampler(s *LogSampler) AccessLogOption {
+	return func(o *accessLogOptions) {
+		o.sampler = s
+	}
+}
+
+// AccessLogFields sets the fields which are written, in order, by the JSON and logfmt formats. The Common and
+// Combined Log Formats have fixed fields, so they ignore this. The built-in fields are:
+//
+//  time          when the request was received, in RFC 3339 format
+//  remote_addr   the IP address of the client
+//  user          the user name of the request's basic authentication credentials
+//  method, uri, proto, host, referer, user_agent
+//  status        the status code of the response
+//  bytes         the size of the response body
+//  duration_ms   the time taken to handle the request, in milliseconds
+//  route         the pattern of the Router route which handled the request
+//  request_id    the request's ID (see TraceFilter)
+//
+// and the fields added by AccessLogField. By default, all the built-in fields except host, user and route are written.
+func AccessLogFields(names ...string) AccessLogOption {
+	return func(o *accessLogOptions) {
+		o.fields = names
+		o.fieldsSet = true
+	}
+}
+
+// AccessLogField defines a field, which the JSON and logfmt formats write with the value f derives from each entry
+// (such as a header, or a value from the request's context). It is added to the fields which are written, unless
+// AccessLogFields is used to choose them.
+func AccessLogField(name string, f func(AccessLogEntry) string) AccessLogOption {
+	return func(o *accessLogOptions) {
+		if o.derived == nil {
+			o.derived = map[string]func(AccessLogEntry) string{}
+		}
+		o.derived[name] = f
+		o.extra = append(o.extra, name)
+	}
+}
+
+// accessLogFields are the built-in fields; their values are strings or numbers.
+var accessLogFields = map[string]func(AccessLogEntry) interface{}{
+	"time":        func(e AccessLogEntry) interface{} { return e.Time.Format(time.RFC3339Nano) },
+	"remote_addr": func(e AccessLogEntry) interface{} { return e.remoteAddr() },
+	"user":        func(e AccessLogEntry) interface{} { return e.user() },
+	"method":      func(e AccessLogEntry) interface{} { return e.Request.Method },
+	"uri":         func(e AccessLogEntry) interface{} { return e.uri() },
+	"proto":       func(e AccessLogEntry) interface{} { return e.Request.Proto },
+	"host":        func(e AccessLogEntry) interface{} { return e.Request.Host },
+	"referer":     func(e AccessLogEntry) interface{} { return e.Request.Referer() },
+	"user_agent":  func(e AccessLogEntry) interface{} { return e.Request.UserAgent() },
+	"status":      func(e AccessLogEntry) interface{} { return e.Status },
+	"bytes":       func(e AccessLogEntry) interface{} { return e.Bytes },
+	"duration_ms": func(e AccessLogEntry) interface{} { return float64(e.Duration) / float64(time.Millisecond) },
+	"route":       func(e AccessLogEntry) interface{} { return e.Route },
+	"request_id":  func(e AccessLogEntry) interface{} { return e.RequestID },
+}
+
+var defaultAccessLogFields = []string{
+	"time", "remote_addr", "method", "uri", "proto", "status", "bytes", "duration_ms", "referer", "user_agent",
+	"request_id"}
+
+// AccessLogFilter writes an entry to w for each request a server handles, in the Combined Log Format unless another
+// is chosen with AccessLogFormat. Entries are written once the response body has been sent (or the connection has
+// failed), so that they include its size; for streamed responses (see Streamer), they are written when the service
+// returns. The values of sensitive query parameters (see RegisterSensitiveParam) are redacted.
+//
+//  svc = svc.Filter(libhttp.AccessLogFilter(os.Stdout,
+//      libhttp.AccessLogFormat(libhttp.LogFormatJSON),
+//      libhttp.AccessLogField("tenant", func(e libhttp.AccessLogEntry) string {
+//          return e.Request.Header.Get("X-Tenant")
+//      })))
+//
+// It should be the first (outermost) filter, so the entry records the response which is sent. Writes to w are
+// serialised, one line at a time.
+func AccessLogFilter(w io.Writer, opts ...AccessLogOption) Filter {
+	o := accessLogOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if !o.fieldsSet {
+		o.fields = append(append([]string(nil), defaultAccessLogFields...), o.extra...)
+	}
+	for _, name := range o.fields {
+		if _, ok := accessLogFields[name]; !ok && o.derived[name] == nil {
+			panic(fmt.Sprintf("libhttp: unknown access log field %q", name))
+		}
+	}
+	var m sync.Mutex
+	write := func(e AccessLogEntry) {
+		if !o.sampler.Sample(e.Status) {
+			return
+		}
+		line := o.format.line(e, o.fields, o.derived)
+		m.Lock()
+		defer m.Unlock()
+		w.Write(line)
+	}
+
+	return func(req Request, svc Service) Response {
+		start := time.Now()
+		rsp := svc(req)
+		e := AccessLogEntry{
+			Time:    start,
+			Request: req,
+			Status:  http.StatusInternalServerError,
+			Bytes:   -1}
+		if rsp.Response != nil {
+			e.Status = rsp.StatusCode
+			e.RequestID = rsp.Header.Get("X-Request-ID")
+		}
+		if e.RequestID == "" {
+			e.RequestID = req.Header.Get("X-Request-ID")
+		}
+		if rsp.Request != nil {
+			e.Route = routeOf(*rsp.Request)
+		}
+		if rsp.Response != nil && rsp.Body == nil {
+			e.Bytes = 0
+		}
+		if rsp.Response == nil || rsp.Body == nil || isStreamingBody(rsp) {
+			e.Duration = time.Since(start)
+			write(e)
+			return rsp
+		}
+		rsp.Body = &accessLogBody{
+			ReadCloser: rsp.Body,
+			done: func(n int64) {
+				e.Bytes = n
+				e.Duration = time.Since(start)
+				write(e)
+			}}
+		return rsp
+	}
+}
+
+// isStreamingBody returns whether the response's body is a Streamer, or the request's body being echoed, which can't be
+// wrapped without changing how the response is sent.
+func isStreamingBody(rsp Response) bool {
+	if _, ok := rsp.Body.(*streamer); ok {
+		return true
+	}
+	return rsp.Request != nil && rsp.Body == rsp.Request.Body
+}
+
+// accessLogBody counts the bytes read from a response body, and calls done with the count when it is closed.
+type accessLogBody struct {
+	io.ReadCloser
+	n    int64
+	once sync.Once
+	done func(n int64)
+}
+
+func (b *accessLogBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+func (b *accessLogBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.done(b.n) })
+	return err
+}
+
+func (e AccessLogEntry) remoteAddr() string {
+	host, _, err := net.SplitHostPort(e.Request.RemoteAddr)
+	if err != nil {
+		return e.Request.RemoteAddr
+	}
+	return host
+}
+
+func (e AccessLogEntry) user() string {
+	user, _, _ := e.Request.BasicAuth()
+	return user
+}
+
+func (e AccessLogEntry) uri() string {
+	if e.Request.URL == nil {
+		return e.Request.RequestURI
+	}
+	uri := e.Request.URL.EscapedPath()
+	if q := e.Request.URL.RawQuery; q != "" {
+		uri += "?" + redactParams(q)
+	}
+	return uri
+}
+
+// line formats an entry as a line of the access log.
+func (f LogFormat) line(e AccessLogEntry, fields []string, derived map[string]func(AccessLogEntry) string) []byte {
+	value := func(name string) interface{} {
+		if d, ok := derived[name]; ok {
+			return d(e)
+		}
+		return accessLogFields[name](e)
+	}
+	buf := &bytes.Buffer{}
+	switch f {
+	case LogFormatJSON:
+		enc := json.NewEncoder(buf)
+		enc.SetEscapeHTML(false)
+		buf.WriteByte('{')
+		for i, name := range fields {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			enc.Encode(name)
+			buf.Truncate(buf.Len() - 1) // Encode adds a newline
+			buf.WriteByte(':')
+			enc.Encode(value(name))
+			buf.Truncate(buf.Len() - 1)
+		}
+		buf.WriteByte('}')
+	case LogFormatLogfmt:
+		for i, name := range fields {
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+			buf.WriteString(name)
+			buf.WriteByte('=')
+			buf.WriteString(logfmtValue(value(name)))
+		}
+	default:
+		bytesSent := "-"
+		if e.Bytes >= 0 {
+			bytesSent = strconv.FormatInt(e.Bytes, 10)
+		}
+		fmt.Fprintf(buf, `%s - %s [%s] "%s %s %s" %d %s`, clfValue(e.remoteAddr()), clfValue(e.user()),
+			e.Time.Format("02/Jan/2006:15:04:05 -0700"), clfEscaper.Replace(e.Request.Method),
+			clfEscaper.Replace(e.uri()), clfEscaper.Replace(e.Request.Proto), e.Status, bytesSent)
+		if f != LogFormatCommon {
+			fmt.Fprintf(buf, ` "%s" "%s"`, clfEscaper.Replace(clfValue(e.Request.Referer())),
+				clfEscaper.Replace(clfValue(e.Request.UserAgent())))
+		}
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+var clfEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+// clfValue returns the value for a field of the Common Log Format, in which missing values are written as "-".
+func clfValue(v string) string {
+	if v == "" {
+		return "-"
+	}
+	return v
+}
+
+func logfmtValue(v interface{}) string {
+	s, ok := v.(string)
+	if !ok {
+		if f, ok := v.(float64); ok {
+			return strconv.FormatFloat(f, 'f', -1, 64)
+		}
+		return fmt.Sprint(v)
+	}
+	if s == "" || strings.ContainsAny(s, " =\"\\\n\r\t") {
+		return strconv.Quote(s)
+	}
+	return s
+}
@@ -0,0 +1,168 @@
+package libhttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type lockedBuffer struct {
+	m   sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.buf.String()
+}
+
+func accessLogEntry() AccessLogEntry {
+	u, _ := url.Parse("/users/1?token=secret&page=2")
+	req := Request{
+		Request: http.Request{
+			Method:     "GET",
+			URL:        u,
+			Proto:      "HTTP/1.1",
+			Host:       "example.com",
+			RemoteAddr: "192.0.2.1:5555",
+			Header: http.Header{
+				"Referer":      {"https://example.com/"},
+				"User-Agent":   {`curl/7.64 "quoted"`},
+				"X-Tenant":     {"acme"},
+				"X-Request-Id": {"abc"}}}}
+	req.SetBasicAuth("ada", "pw")
+	return AccessLogEntry{
+		Time:      time.Date(2020, 10, 1, 13, 55, 36, 0, time.FixedZone("", -7*3600)),
+		Request:   req,
+		Status:    200,
+		Bytes:     2326,
+		Duration:  1500 * time.Microsecond,
+		Route:     "/users/:id",
+		RequestID: "abc"}
+}
+
+func TestAccessLogFormats(t *testing.T) {
+	t.Parallel()
+	e := accessLogEntry()
+	tenant := map[string]func(AccessLogEntry) string{
+		"tenant": func(e AccessLogEntry) string { return e.Request.Header.Get("X-Tenant") }}
+
+	assert.Equal(t, `192.0.2.1 - ada [01/Oct/2020:13:55:36 -0700] "GET /users/1?page=2&token=REDACTED HTTP/1.1" 200 2326`+
+		"\n", string(LogFormatCommon.line(e, nil, nil)))
+	assert.Equal(t, `192.0.2.1 - ada [01/Oct/2020:13:55:36 -0700] "GET /users/1?page=2&token=REDACTED HTTP/1.1" 200 2326`+
+		` "https://example.com/" "curl/7.64 \"quoted\""`+"\n", string(LogFormatCombined.line(e, nil, nil)))
+
+	fields := []string{"method", "uri", "status", "duration_ms", "route", "user_agent", "tenant"}
+	assert.Equal(t, `{"method":"GET","uri":"/users/1?page=2&token=REDACTED","status":200,"duration_ms":1.5,`+
+		`"route":"/users/:id","user_agent":"curl/7.64 \"quoted\"","tenant":"acme"}`+"\n",
+		string(LogFormatJSON.line(e, fields, tenant)))
+	assert.Equal(t, `method=GET uri="/users/1?page=2&token=REDACTED" status=200 duration_ms=1.5 route=/users/:id `+
+		`user_agent="curl/7.64 \"quoted\"" tenant=acme`+"\n", string(LogFormatLogfmt.line(e, fields, tenant)))
+
+	e.Bytes = -1
+	e.Request.Header = http.Header{}
+	assert.Equal(t, `192.0.2.1 - - [01/Oct/2020:13:55:36 -0700] "GET /users/1?page=2&token=REDACTED HTTP/1.1" 200 - `+
+		`"-" "-"`+"\n", string(LogFormatCombined.line(e, nil, nil)))
+}
+
+func TestAccessLogFilter(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	out := &lockedBuffer{}
+	router := &Router{}
+	router.GET("/hello/:name", func(req Request) Response {
+		return req.Response("hello " + router.Params(req)["name"])
+	})
+	svc := router.Serve().Filter(ErrorFilter).Filter(AccessLogFilter(out,
+		AccessLogFormat(LogFormatJSON),
+		AccessLogField("tenant", func(e AccessLogEntry) string { return e.Request.Header.Get("X-Tenant") })))
+	s, err := Listen(svc, "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(ctx)
+	base := "http://" + s.Listener().Addr().String()
+
+	req := NewRequest(ctx, "GET", base+"/hello/world", nil)
+	req.Header.Set("X-Tenant", "acme")
+	rsp := req.Send().Response()
+	require.NoError(t, rsp.Error)
+	b, err := rsp.BodyBytes(true)
+	require.NoError(t, err)
+	NewRequest(ctx, "GET", base+"/nope", nil).Send().Response()
+	require.NoError(t, s.WaitIdle(ctx))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2)
+	var entries []map[string]interface{}
+	for _, l := range lines {
+		var v map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(l), &v), l)
+		entries = append(entries, v)
+	}
+	assert.Equal(t, "/hello/world", entries[0]["uri"])
+	assert.Equal(t, float64(200), entries[0]["status"])
+	assert.Equal(t, float64(len(b)), entries[0]["bytes"])
+	assert.Equal(t, "127.0.0.1", entries[0]["remote_addr"])
+	assert.Equal(t, "acme", entries[0]["tenant"])
+	assert.Equal(t, float64(404), entries[1]["status"])
+	assert.Equal(t, []string{"time", "remote_addr", "method", "uri", "proto", "status", "bytes", "duration_ms",
+		"referer", "user_agent", "request_id", "tenant"}, jsonKeys(t, lines[0]))
+}
+
+func TestAccessLogUnknownField(t *testing.T) {
+	t.Parallel()
+	assert.Panics(t, func() { AccessLogFilter(&bytes.Buffer{}, AccessLogFields("method", "nope")) })
+	assert.NotPanics(t, func() {
+		AccessLogFilter(&bytes.Buffer{}, AccessLogField("x", func(AccessLogEntry) string { return "" }),
+			AccessLogFields("method", "x"))
+	})
+}
+
+func TestAccessLogSampler(t *testing.T) {
+	t.Parallel()
+	out := &lockedBuffer{}
+	svc := Service(func(req Request) Response {
+		rsp := req.Response(nil)
+		if req.URL.Path == "/error" {
+			rsp.StatusCode = 500
+		}
+		return rsp
+	}).Filter(AccessLogFilter(out, AccessLogSampler(NewLogSampler(SampleRate(0), SampleStatusClass(5, 1)))))
+	ctx := context.Background()
+	for _, path := range []string{"/ok", "/error", "/ok"} {
+		svc(NewRequest(ctx, "GET", "http://example.com"+path, nil)).Body.Close() // entries are written on close
+	}
+	assert.Equal(t, 1, strings.Count(out.String(), "\n"))
+	assert.Contains(t, out.String(), `"GET /error HTTP/1.1" 500`)
+}
+
+// jsonKeys returns the keys of a JSON object, in order.
+func jsonKeys(t *testing.T, s string) []string {
+	dec := json.NewDecoder(strings.NewReader(s))
+	_, err := dec.Token()
+	require.NoError(t, err)
+	var keys []string
+	for dec.More() {
+		k, err := dec.Token()
+		require.NoError(t, err)
+		keys = append(keys, k.(string))
+		var v interface{}
+		require.NoError(t, dec.Decode(&v))
+	}
+	return keys
+}
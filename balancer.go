@@ -0,0 +1,249 @@
+package libhttp
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/monzo/terrors"
+)
+
+// A BalancePolicy decides which endpoint a LoadBalancer sends each request to.
+type BalancePolicy int
+
+const (
+	// RoundRobin sends requests to each endpoint in turn.
+	RoundRobin BalancePolicy = iota
+	// LeastPending sends requests to the endpoint with the fewest requests in flight.
+	LeastPending
+	// EWMA sends requests to the endpoint with the lowest expected latency: its moving average latency, scaled by its
+	// requests in flight. This favours faster endpoints, and reacts quickly when one slows down.
+	EWMA
+)
+
+const ewmaWeight = 0.3 // weight of each new latency in the moving average
+
+// A BalancerOption configures a LoadBalancer.
+type BalancerOption func(*LoadBalancer)
+
+// BalancerPolicy sets how the balancer chooses endpoints. The default is RoundRobin.
+func BalancerPolicy(p BalancePolicy) BalancerOption {
+	return func(lb *LoadBalancer) {
+		lb.policy = p
+	}
+}
+
+// BalancerEjection sets how endpoints are ejected when they fail: after failures consecutive failures (requests which
+// fail without a response, or with a 502, 503 or 504 status), an endpoint isn't sent requests for the passed duration.
+// The default is 5 failures and 30 seconds; zero failures disables ejection.
+func BalancerEjection(failures int, d time.Duration) BalancerOption {
+	return func(lb *LoadBalancer) {
+		lb.ejectAfter = failures
+		lb.ejectFor = d
+	}
+}
+
+// endpoint is an endpoint of a LoadBalancer, and the balancer's view of its state. Its fields are guarded by the
+// balancer's mutex.
+type endpoint struct {
+	raw          string   // as configured
+	url          *url.URL // only Scheme and Host are used
+	pending      int
+	ewma         float64 // moving average latency, in seconds; zero until a response has been seen
+	failures     int     // consecutive
+	ejectedUntil time.Time
+}
+
+// A LoadBalancer spreads client requests for a service across a set of endpoints, taking endpoints which are failing
+// out of rotation for a while. Requests for the service are addressed to its name, and the balancer's Filter sends
+// each to one of the endpoints:
+//
+//  users := libhttp.NewLoadBalancer("users", []string{"10.0.0.1:8080", "10.0.0.2:8080"},
+//      libhttp.BalancerPolicy(libhttp.EWMA))
+//  client := libhttp.NewClient(libhttp.WithClientFilters(users.Filter))
+//  rsp := libhttp.NewRequest(ctx, "GET", "http://users/v1/users/123", nil).SendVia(client).Response()
+//
+// Endpoints are host:port pairs, or URLs with a scheme and host (like https://10.0.0.1:8443) if the scheme should
+// differ from the request's. The request's Host header is set to the endpoint's. Requests for other hosts are passed
+// through, so several balancers' filters can be used together. The endpoints can be changed (as instances come and
+// go, say) with SetEndpoints.
+//
+// If all the endpoints have been ejected, requests are spread across all of them regardless: this is likelier to
+// succeed than failing every request.
+type LoadBalancer struct {
+	name       string
+	policy     BalancePolicy
+	ejectAfter int
+	ejectFor   time.Duration
+
+	m         sync.Mutex
+	endpoints []*endpoint
+	next      int // for round-robin, and to break ties
+}
+
+// NewLoadBalancer returns a LoadBalancer for the named service, with the passed endpoints. It returns an error if an
+// endpoint can't be parsed.
+func NewLoadBalancer(name string, endpoints []string, opts ...BalancerOption) (*LoadBalancer, error) {
+	lb := &LoadBalancer{
+		name:       name,
+		ejectAfter: 5,
+		ejectFor:   30 * time.Second}
+	for _, opt := range opts {
+		opt(lb)
+	}
+	if err := lb.SetEndpoints(endpoints); err != nil {
+		return nil, err
+	}
+	return lb, nil
+}
+
+// SetEndpoints replaces the balancer's endpoints. The state of endpoints which remain (their latencies and whether
+// they are ejected) is kept.
+func (lb *LoadBalancer) SetEndpoints(endpoints []string) error {
+	eps := make([]*endpoint, 0, len(endpoints))
+	for _, e := range endpoints {
+		raw := e
+		if !strings.Contains(raw, "://") {
+			raw = "//" + raw
+		}
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("invalid endpoint %q", e)
+		}
+		eps = append(eps, &endpoint{
+			raw: e,
+			url: &url.URL{
+				Scheme: u.Scheme,
+				Host:   u.Host}})
+	}
+
+	lb.m.Lock()
+	defer lb.m.Unlock()
+	for i, ep := range eps {
+		for _, old := range lb.endpoints {
+			if *old.url == *ep.url {
+				eps[i] = old
+				break
+			}
+		}
+	}
+	lb.endpoints = eps
+	return nil
+}
+
+// Endpoints returns the balancer's endpoints, including any which are ejected.
+func (lb *LoadBalancer) Endpoints() []string {
+	lb.m.Lock()
+	defer lb.m.Unlock()
+	eps := make([]string, len(lb.endpoints))
+	for i, ep := range lb.endpoints {
+		eps[i] = ep.raw
+	}
+	return eps
+}
+
+// Filter sends requests for the balancer's service to one of its endpoints.
+func (lb *LoadBalancer) Filter(req Request, svc Service) Response {
+	if req.URL == nil || req.URL.Host != lb.name {
+		return svc(req)
+	}
+	ep := lb.pick()
+	if ep == nil {
+		return Response{
+			Request: &req,
+			Error: terrors.InternalService("no_endpoints", "No endpoints for "+lb.name, map[string]string{
+				"service": lb.name})}
+	}
+
+	u := *req.URL
+	u.Host = ep.url.Host
+	if ep.url.Scheme != "" {
+		u.Scheme = ep.url.Scheme
+	}
+	req.URL = &u
+	req.Host = ep.url.Host
+	start := time.Now()
+	rsp := svc(req)
+	// Requests which the caller gave up on say nothing about the endpoint
+	lb.done(ep, time.Since(start), endpointFailed(rsp) && (req.Context == nil || req.Err() == nil))
+	return rsp
+}
+
+// pick chooses an endpoint for a request, and counts the request as pending on it.
+func (lb *LoadBalancer) pick() *endpoint {
+	lb.m.Lock()
+	defer lb.m.Unlock()
+	if len(lb.endpoints) == 0 {
+		return nil
+	}
+	now := time.Now()
+	candidates := make([]*endpoint, 0, len(lb.endpoints))
+	for _, ep := range lb.endpoints {
+		if !now.Before(ep.ejectedUntil) {
+			candidates = append(candidates, ep)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = lb.endpoints
+	}
+
+	lb.next++
+	var ep *endpoint
+	switch lb.policy {
+	case LeastPending, EWMA:
+		best := math.Inf(1)
+		for i := range candidates {
+			// Start from a different endpoint each time, so ties are broken fairly
+			c := candidates[(lb.next+i)%len(candidates)]
+			cost := float64(c.pending)
+			if lb.policy == EWMA {
+				cost = c.ewma * float64(c.pending+1)
+			}
+			// Endpoints with no latency yet all cost nothing, so fall back to pending requests between them
+			if cost < best || (cost == best && c.pending < ep.pending) {
+				best, ep = cost, c
+			}
+		}
+	default:
+		ep = candidates[lb.next%len(candidates)]
+	}
+	ep.pending++
+	return ep
+}
+
+// done records the outcome of a request to an endpoint.
+func (lb *LoadBalancer) done(ep *endpoint, latency time.Duration, failed bool) {
+	lb.m.Lock()
+	defer lb.m.Unlock()
+	ep.pending--
+	if ep.ewma == 0 {
+		ep.ewma = latency.Seconds()
+	} else {
+		ep.ewma = ep.ewma*(1-ewmaWeight) + latency.Seconds()*ewmaWeight
+	}
+	if !failed {
+		ep.failures = 0
+		return
+	}
+	ep.failures++
+	if lb.ejectAfter > 0 && ep.failures >= lb.ejectAfter {
+		ep.ejectedUntil = time.Now().Add(lb.ejectFor)
+		ep.failures = 0
+	}
+}
+
+// endpointFailed returns whether a response indicates that the endpoint which produced it is unhealthy.
+func endpointFailed(rsp Response) bool {
+	if rsp.Response == nil {
+		return rsp.Error != nil
+	}
+	switch rsp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package libhttp
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// hostRecorder is a Service which records the hosts requests are sent to, and responds with the status set for them.
+type hostRecorder struct {
+	m        sync.Mutex
+	hosts    []string
+	statuses map[string]int
+	delays   map[string]time.Duration
+}
+
+func (h *hostRecorder) serve(req Request) Response {
+	h.m.Lock()
+	h.hosts = append(h.hosts, req.URL.Scheme+"://"+req.URL.Host)
+	status, delay := h.statuses[req.URL.Host], h.delays[req.URL.Host]
+	h.m.Unlock()
+	time.Sleep(delay)
+	rsp := req.Response(nil)
+	if status != 0 {
+		rsp.StatusCode = status
+	}
+	return rsp
+}
+
+func (h *hostRecorder) reset() []string {
+	h.m.Lock()
+	defer h.m.Unlock()
+	hosts := h.hosts
+	h.hosts = nil
+	return hosts
+}
+
+func TestLoadBalancerRoundRobin(t *testing.T) {
+	t.Parallel()
+	rec := &hostRecorder{
+		statuses: map[string]int{}}
+	lb, err := NewLoadBalancer("users", []string{"a:80", "b:80", "https://c:443"},
+		BalancerEjection(2, time.Minute))
+	require.NoError(t, err)
+	svc := Service(rec.serve).Filter(lb.Filter)
+	send := func(url string) Response {
+		return svc(NewRequest(context.Background(), "GET", url, nil))
+	}
+
+	for i := 0; i < 6; i++ {
+		send("http://users/v1/users")
+	}
+	hosts := rec.reset()
+	assert.ElementsMatch(t, []string{
+		"http://a:80", "http://b:80", "https://c:443",
+		"http://a:80", "http://b:80", "https://c:443"}, hosts)
+	assert.NotEqual(t, hosts[0], hosts[1])
+
+	// Other hosts are passed through
+	send("http://orders/v1/orders")
+	assert.Equal(t, []string{"http://orders"}, rec.reset())
+
+	// Failing endpoints are ejected
+	rec.m.Lock()
+	rec.statuses["b:80"] = http.StatusServiceUnavailable
+	rec.m.Unlock()
+	for i := 0; i < 6; i++ {
+		send("http://users/v1/users")
+	}
+	rec.reset()
+	for i := 0; i < 6; i++ {
+		send("http://users/v1/users")
+	}
+	assert.NotContains(t, rec.reset(), "http://b:80")
+
+	// Endpoints can be replaced, keeping their state
+	require.NoError(t, lb.SetEndpoints([]string{"b:80", "d:80"}))
+	for i := 0; i < 4; i++ {
+		send("http://users/v1/users")
+	}
+	assert.Equal(t, []string{"http://d:80", "http://d:80", "http://d:80", "http://d:80"}, rec.reset())
+
+	// If everything is ejected, requests are sent anyway
+	require.NoError(t, lb.SetEndpoints([]string{"b:80"}))
+	assert.Equal(t, http.StatusServiceUnavailable, send("http://users/").StatusCode)
+
+	require.NoError(t, lb.SetEndpoints(nil))
+	assert.Error(t, send("http://users/").Error)
+
+	_, err = NewLoadBalancer("users", []string{"http://"})
+	assert.Error(t, err)
+}
+
+func TestLoadBalancerLeastPending(t *testing.T) {
+	t.Parallel()
+	rec := &hostRecorder{
+		delays: map[string]time.Duration{
+			"slow:80": 100 * time.Millisecond}}
+	lb, err := NewLoadBalancer("users", []string{"slow:80", "fast:80"}, BalancerPolicy(LeastPending))
+	require.NoError(t, err)
+	svc := Service(rec.serve).Filter(lb.Filter)
+
+	// While a request to the slow endpoint is in flight, the fast one is preferred
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			svc(NewRequest(context.Background(), "GET", "http://users/", nil))
+		}()
+		time.Sleep(5 * time.Millisecond)
+	}
+	wg.Wait()
+	slow := 0
+	for _, h := range rec.reset() {
+		if h == "http://slow:80" {
+			slow++
+		}
+	}
+	assert.True(t, slow <= 2, "%d requests went to the slow endpoint", slow)
+}
+
+func TestLoadBalancerEWMA(t *testing.T) {
+	t.Parallel()
+	rec := &hostRecorder{
+		delays: map[string]time.Duration{
+			"slow:80": 20 * time.Millisecond}}
+	lb, err := NewLoadBalancer("users", []string{"slow:80", "fast:80"}, BalancerPolicy(EWMA))
+	require.NoError(t, err)
+	svc := Service(rec.serve).Filter(lb.Filter)
+
+	// Once each endpoint's latency is known, requests go to the faster one
+	for i := 0; i < 10; i++ {
+		svc(NewRequest(context.Background(), "GET", "http://users/", nil))
+	}
+	hosts := rec.reset()
+	assert.ElementsMatch(t, []string{"http://slow:80", "http://fast:80"}, hosts[:2])
+	for _, h := range hosts[2:] {
+		assert.Equal(t, "http://fast:80", h)
+	}
+}
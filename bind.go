@@ -0,0 +1,213 @@
+package libhttp
+
+import (
+	"bytes"
+	"encoding"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/monzo/terrors"
+)
+
+var (
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	durationType        = reflect.TypeOf(time.Duration(0))
+)
+
+// Bind populates the struct pointed to by v from the request, according to the tags on its fields:
+//
+//  type getWidgetInput struct {
+//      ID     string   `path:"id"`
+//      Limit  int      `query:"limit" default:"10"`
+//      Tags   []string `query:"tag"`
+//      APIKey string   `header:"X-Api-Key"`
+//      Name   string   `json:"name"`
+//  }
+//
+// Fields are first set to their defaults (if they have a default tag), then the body is decoded into the struct (if
+// there is one) according to its Content-Type (form bodies are decoded as by DecodeForm), and finally fields tagged
+// with path, query or header are set from the corresponding path parameter (as extracted by the Router which
+// dispatched the request), query parameter, or header, when present.
+//
+// Values are converted to strings, booleans, numbers, time.Durations, or any type implementing
+// encoding.TextUnmarshaler, or slices of them (which receive all values of a query parameter or header). Fields of
+// embedded structs are bound too. Values which can't be converted result in bad request errors.
+//
+// Once populated, the struct is checked with Validate.
+func Bind(req Request, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return terrors.InternalService("bind_target", fmt.Sprintf("Bind target must be a pointer to a struct, not %T", v),
+			nil)
+	}
+	if err := bindDefaults(rv.Elem()); err != nil {
+		return err
+	}
+
+	if req.Body != nil {
+		b, err := req.BodyBytes(true)
+		if err != nil {
+			return terrors.WrapWithCode(err, nil, terrors.ErrBadRequest)
+		}
+		contentType := req.Header.Get("Content-Type")
+		if len(bytes.TrimSpace(b)) > 0 {
+			if mediaType(contentType) == formContentType {
+				vs, err := url.ParseQuery(string(b))
+				if err != nil {
+					return terrors.BadRequest("invalid_form", fmt.Sprintf("Malformed form body: %v", err), nil)
+				}
+				if err := bindForm(rv.Elem(), vs, ""); err != nil {
+					return err
+				}
+			} else if c := codecFor(contentType); c != (jsonCodec{}) {
+				if err := c.Unmarshal(b, v); err != nil {
+					return terrors.WrapWithCode(err, nil, terrors.ErrBadRequest)
+				}
+			} else if err := decodeJSON(b, v, decodeOptions{}); err != nil {
+				return err
+			}
+		}
+	}
+
+	var params map[string]string
+	if router := RouterForRequest(req); router != nil {
+		params = router.Params(req)
+	}
+	query := req.URL.Query()
+	err := bindValues(rv.Elem(), func(f reflect.StructField) (string, string, []string) {
+		if name, ok := f.Tag.Lookup("path"); ok {
+			if p, ok := params[name]; ok {
+				return "path", name, []string{p}
+			}
+		}
+		if name, ok := f.Tag.Lookup("query"); ok {
+			if vs, ok := query[name]; ok {
+				return "query", name, vs
+			}
+		}
+		if name, ok := f.Tag.Lookup("header"); ok {
+			if vs := req.Header.Values(name); len(vs) > 0 {
+				return "header", name, vs
+			}
+		}
+		return "", "", nil
+	})
+	if err != nil {
+		return err
+	}
+	return Validate(v)
+}
+
+// bindDefaults sets fields which have a default tag to the value of the tag.
+func bindDefaults(v reflect.Value) error {
+	return bindValues(v, func(f reflect.StructField) (string, string, []string) {
+		if d, ok := f.Tag.Lookup("default"); ok {
+			return "default", f.Name, []string{d}
+		}
+		return "", "", nil
+	})
+}
+
+// bindValues walks the fields of the struct v (including those of embedded structs), setting each field for which
+// lookup returns values. lookup also returns where the values came from and under which name, for error messages.
+func bindValues(v reflect.Value, lookup func(reflect.StructField) (in, name string, values []string)) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f, fv := t.Field(i), v.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			if err := bindValues(fv, lookup); err != nil {
+				return err
+			}
+			continue
+		}
+		if f.PkgPath != "" { // unexported
+			continue
+		}
+		in, name, values := lookup(f)
+		if len(values) == 0 {
+			continue
+		}
+		if err := setField(fv, values); err != nil {
+			if in == "default" {
+				return terrors.InternalService("bind_default", fmt.Sprintf("Invalid default for field %s: %v", name, err),
+					nil)
+			}
+			return invalidParamError(in, name, err.Error())
+		}
+	}
+	return nil
+}
+
+// setField converts the passed strings to the type of v and assigns them. Non-slice fields receive the first value.
+func setField(v reflect.Value, values []string) error {
+	if v.Kind() == reflect.Slice && !v.Type().Implements(textUnmarshalerType) &&
+		!reflect.PtrTo(v.Type()).Implements(textUnmarshalerType) {
+		s := reflect.MakeSlice(v.Type(), len(values), len(values))
+		for i, value := range values {
+			if err := setValue(s.Index(i), value); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+		return nil
+	}
+	return setValue(v, values[0])
+}
+
+// setValue converts a single string to the type of v and assigns it.
+func setValue(v reflect.Value, s string) error {
+	if v.Kind() == reflect.Ptr {
+		p := reflect.New(v.Type().Elem())
+		if err := setValue(p.Elem(), s); err != nil {
+			return err
+		}
+		v.Set(p)
+		return nil
+	}
+	if v.CanAddr() && v.Addr().Type().Implements(textUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("expected duration")
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("expected boolean")
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("expected integer")
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("expected non-negative integer")
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("expected number")
+		}
+		v.SetFloat(n)
+	default:
+		return fmt.Errorf("unsupported field type %s", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package libhttp
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type bindTestPaging struct {
+	Limit int `query:"limit" default:"10"`
+}
+
+type bindTestInput struct {
+	bindTestPaging
+	ID      int64         `path:"id"`
+	Tags    []string      `query:"tag"`
+	Verbose *bool         `query:"verbose"`
+	Timeout time.Duration `query:"timeout" default:"1s"`
+	Addr    net.IP        `header:"X-Client-Addr"`
+	APIKey  string        `header:"X-Api-Key"`
+	Name    string        `json:"name"`
+}
+
+func TestBind(t *testing.T) {
+	t.Parallel()
+
+	var got bindTestInput
+	var bindErr error
+	router := Router{}
+	router.POST("/widgets/:id", func(req Request) Response {
+		got = bindTestInput{}
+		bindErr = Bind(req, &got)
+		return req.Response(nil)
+	})
+	svc := router.Serve()
+
+	req := NewRequest(context.Background(), "POST", "/widgets/42?tag=a&tag=b&verbose=true",
+		map[string]string{"name": "sprocket"})
+	req.Header.Set("X-Api-Key", "secret")
+	req.Header.Set("X-Client-Addr", "10.0.0.1")
+	svc(req)
+	require.NoError(t, bindErr)
+	verbose := true
+	assert.Equal(t, bindTestInput{
+		bindTestPaging: bindTestPaging{Limit: 10},
+		ID:             42,
+		Tags:           []string{"a", "b"},
+		Verbose:        &verbose,
+		Timeout:        time.Second,
+		Addr:           net.ParseIP("10.0.0.1"),
+		APIKey:         "secret",
+		Name:           "sprocket"}, got)
+
+	// Query parameters override defaults, and a body is optional
+	svc(NewRequest(context.Background(), "POST", "/widgets/1?limit=5&timeout=1m", nil))
+	require.NoError(t, bindErr)
+	assert.Equal(t, 5, got.Limit)
+	assert.Equal(t, time.Minute, got.Timeout)
+	assert.Nil(t, got.Verbose)
+
+	svc(NewRequest(context.Background(), "POST", "/widgets/abc", nil))
+	require.Error(t, bindErr)
+	assert.Equal(t, "bad_request.invalid_path", bindErr.(*terrors.Error).Code)
+	assert.Contains(t, bindErr.Error(), `Invalid path parameter "id": expected integer`)
+
+	svc(NewRequest(context.Background(), "POST", "/widgets/1?limit=lots", nil))
+	require.Error(t, bindErr)
+	assert.Equal(t, "bad_request.invalid_query", bindErr.(*terrors.Error).Code)
+
+	assert.Error(t, Bind(NewRequest(context.Background(), "GET", "/", nil), got))
+}
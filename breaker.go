@@ -0,0 +1,316 @@
+package libhttp
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/monzo/slog"
+	"github.com/monzo/terrors"
+)
+
+// A BreakerState is the state of a circuit of a CircuitBreaker.
+type BreakerState int
+
+const (
+	// BreakerClosed is the normal state: requests are sent.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen is the state of a circuit which has tripped: requests fail immediately.
+	BreakerOpen
+	// BreakerHalfOpen is the state of a circuit which has been open for a while: a few requests are sent to probe
+	// whether the upstream has recovered.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("BreakerState(%d)", int(s))
+	}
+}
+
+const breakerBuckets = 10 // the error rate window is divided into this many buckets
+
+// A BreakerOption configures a CircuitBreaker.
+type BreakerOption func(*CircuitBreaker)
+
+// BreakerConsecutiveFailures trips a circuit after n consecutive failed requests. The default is 5; zero disables
+// this policy.
+func BreakerConsecutiveFailures(n int) BreakerOption {
+	return func(b *CircuitBreaker) {
+		b.consecutive = n
+	}
+}
+
+// BreakerErrorRate trips a circuit when at least rate (between 0 and 1) of the requests in the last window have
+// failed, provided there were at least minRequests of them. It is disabled by default.
+func BreakerErrorRate(rate float64, minRequests int, window time.Duration) BreakerOption {
+	return func(b *CircuitBreaker) {
+		b.errorRate = rate
+		b.minRequests = minRequests
+		b.window = window
+	}
+}
+
+// BreakerOpenDuration sets how long a tripped circuit stays open before probing the upstream. The default is 30
+// seconds.
+func BreakerOpenDuration(d time.Duration) BreakerOption {
+	return func(b *CircuitBreaker) {
+		b.openFor = d
+	}
+}
+
+// BreakerHalfOpenProbes sets how many requests are sent while a circuit is half-open (one at a time), all of which
+// must succeed for it to close. The default is 1.
+func BreakerHalfOpenProbes(n int) BreakerOption {
+	return func(b *CircuitBreaker) {
+		b.probes = n
+	}
+}
+
+// BreakerKey sets the function which decides which circuit a request belongs to. The default is the request's host,
+// giving a circuit per upstream host (or per service, for hosts which are the names of LoadBalancers).
+func BreakerKey(f func(Request) string) BreakerOption {
+	return func(b *CircuitBreaker) {
+		b.key = f
+	}
+}
+
+// BreakerIsFailure sets the function which decides whether a response counts as a failure. By default, responses
+// with errors and no HTTP response (because the connection failed, say) and those with 5xx statuses are failures.
+func BreakerIsFailure(f func(Response) bool) BreakerOption {
+	return func(b *CircuitBreaker) {
+		b.failed = f
+	}
+}
+
+// BreakerOnStateChange sets a function which is called whenever a circuit changes state (for metrics or alerting, say).
+// It is called synchronously, so it should be quick, and mustn't use the breaker. State changes are also logged.
+func BreakerOnStateChange(f func(key string, from, to BreakerState)) BreakerOption {
+	return func(b *CircuitBreaker) {
+		b.onChange = f
+	}
+}
+
+// breakerBucket counts the requests in one part of the error rate window.
+type breakerBucket struct {
+	start    time.Time
+	requests int
+	failures int
+}
+
+// circuit is the state of a key's circuit. Its fields are guarded by the breaker's mutex.
+type circuit struct {
+	state     BreakerState
+	failures  int // consecutive
+	openUntil time.Time
+	probing   bool // whether a half-open probe is in flight
+	successes int  // of half-open probes
+	buckets   [breakerBuckets]breakerBucket
+}
+
+// A CircuitBreaker stops a client sending requests to upstreams which are failing, so that callers fail fast rather
+// than queueing behind a degraded dependency (and so that the dependency gets a chance to recover). Each upstream has
+// its own circuit, which trips (opens) when requests to it keep failing (see BreakerConsecutiveFailures and
+// BreakerErrorRate). Requests to an open circuit fail immediately with an internal_service.circuit_open error; after
+// a while the circuit becomes half-open, and a few probe requests are let through, closing it if they succeed or
+// opening it again if not:
+//
+//  breaker := libhttp.NewCircuitBreaker(libhttp.BreakerErrorRate(0.5, 20, 10*time.Second))
+//  client := libhttp.NewClient(libhttp.WithClientFilters(breaker.Filter))
+//
+// When used with a RetryFilter, the breaker should be inside it (after it, in WithClientFilters) so each attempt is
+// counted, and a LoadBalancer's filter should be inside the breaker for its circuits to be per service rather than per
+// endpoint (the balancer ejects failing endpoints itself).
+type CircuitBreaker struct {
+	consecutive int
+	errorRate   float64
+	minRequests int
+	window      time.Duration
+	openFor     time.Duration
+	probes      int
+	key         func(Request) string
+	failed      func(Response) bool
+	onChange    func(key string, from, to BreakerState)
+	metrics     *breakerMetrics
+
+	m        sync.Mutex
+	circuits map[string]*circuit
+}
+
+// NewCircuitBreaker returns a CircuitBreaker, with all its circuits closed.
+func NewCircuitBreaker(opts ...BreakerOption) *CircuitBreaker {
+	b := &CircuitBreaker{
+		consecutive: 5,
+		openFor:     30 * time.Second,
+		probes:      1,
+		key: func(req Request) string {
+			if req.URL == nil {
+				return ""
+			}
+			return req.URL.Host
+		},
+		failed: func(rsp Response) bool {
+			if rsp.Response == nil {
+				return rsp.Error != nil
+			}
+			return rsp.StatusCode >= 500
+		},
+		circuits: map[string]*circuit{}}
+	for _, opt := range opts {
+		opt(b)
+	}
+	if b.probes < 1 {
+		b.probes = 1
+	}
+	return b
+}
+
+// State returns the state of the circuit for the key.
+func (b *CircuitBreaker) State(key string) BreakerState {
+	b.m.Lock()
+	defer b.m.Unlock()
+	c, ok := b.circuits[key]
+	if !ok {
+		return BreakerClosed
+	}
+	if c.state == BreakerOpen && !time.Now().Before(c.openUntil) {
+		return BreakerHalfOpen
+	}
+	return c.state
+}
+
+// Filter sends requests unless their circuit is open.
+func (b *CircuitBreaker) Filter(req Request, svc Service) Response {
+	key := b.key(req)
+	probe, ok := b.allow(key)
+	if !ok {
+		if b.metrics != nil {
+			b.metrics.rejected.Add(1, key)
+		}
+		return Response{
+			Request: &req,
+			Error: terrors.InternalService("circuit_open", "Circuit breaker is open for "+key, map[string]string{
+				"circuit": key})}
+	}
+	rsp := svc(req)
+	// Requests which the caller gave up on say nothing about the upstream
+	cancelled := req.Context != nil && req.Err() != nil
+	b.done(key, probe, b.failed(rsp) && !cancelled, cancelled)
+	return rsp
+}
+
+// allow returns whether a request may be sent on the key's circuit, and whether it is a half-open probe.
+func (b *CircuitBreaker) allow(key string) (probe, ok bool) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	c := b.circuits[key]
+	if c == nil {
+		c = &circuit{}
+		b.circuits[key] = c
+	}
+	if c.state == BreakerOpen && !time.Now().Before(c.openUntil) {
+		b.transition(key, c, BreakerHalfOpen)
+	}
+	switch c.state {
+	case BreakerOpen:
+		return false, false
+	case BreakerHalfOpen:
+		if c.probing {
+			return false, false
+		}
+		c.probing = true
+		return true, true
+	default:
+		return false, true
+	}
+}
+
+// done records the outcome of a request on the key's circuit.
+func (b *CircuitBreaker) done(key string, probe, failed, cancelled bool) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	c := b.circuits[key]
+	if probe {
+		c.probing = false
+		if c.state != BreakerHalfOpen || cancelled {
+			return
+		}
+		if failed {
+			b.transition(key, c, BreakerOpen)
+			return
+		}
+		c.successes++
+		if c.successes >= b.probes {
+			b.transition(key, c, BreakerClosed)
+		}
+		return
+	}
+	if c.state != BreakerClosed || cancelled {
+		return
+	}
+
+	if failed {
+		c.failures++
+	} else {
+		c.failures = 0
+	}
+	requests, failures := c.record(b.window, failed)
+	if (b.consecutive > 0 && c.failures >= b.consecutive) ||
+		(b.errorRate > 0 && requests >= b.minRequests && float64(failures) >= b.errorRate*float64(requests)) {
+		b.transition(key, c, BreakerOpen)
+	}
+}
+
+// record counts a request in the error rate window, returning the numbers of requests and failures in the window.
+func (c *circuit) record(window time.Duration, failed bool) (requests, failures int) {
+	if window <= 0 {
+		return 0, 0
+	}
+	now := time.Now()
+	width := window / breakerBuckets
+	bucket := &c.buckets[(now.UnixNano()/int64(width))%breakerBuckets]
+	if start := now.Truncate(width); !bucket.start.Equal(start) {
+		*bucket = breakerBucket{
+			start: start}
+	}
+	bucket.requests++
+	if failed {
+		bucket.failures++
+	}
+	for _, b := range c.buckets {
+		if now.Sub(b.start) < window {
+			requests += b.requests
+			failures += b.failures
+		}
+	}
+	return requests, failures
+}
+
+// transition moves the circuit to a new state. The breaker must be locked.
+func (b *CircuitBreaker) transition(key string, c *circuit, to BreakerState) {
+	from := c.state
+	*c = circuit{
+		state: to}
+	if to == BreakerOpen {
+		c.openUntil = time.Now().Add(b.openFor)
+	}
+	if to == BreakerOpen {
+		slog.Warn(nil, "Circuit breaker for %s is now open (was %s)", key, from)
+	} else {
+		slog.Info(nil, "Circuit breaker for %s is now %s (was %s)", key, to, from)
+	}
+	if b.metrics != nil {
+		b.metrics.state.Set(float64(to), key)
+		b.metrics.transitions.Add(1, key, to.String())
+	}
+	if b.onChange != nil {
+		b.onChange(key, from, to)
+	}
+}
@@ -0,0 +1,113 @@
+package libhttp
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreakerConsecutive(t *testing.T) {
+	t.Parallel()
+	var m sync.Mutex
+	status := http.StatusServiceUnavailable
+	calls := 0
+	var changes []string
+	breaker := NewCircuitBreaker(
+		BreakerConsecutiveFailures(3),
+		BreakerOpenDuration(50*time.Millisecond),
+		BreakerHalfOpenProbes(2),
+		BreakerOnStateChange(func(key string, from, to BreakerState) {
+			changes = append(changes, key+": "+from.String()+" -> "+to.String())
+		}))
+	svc := Service(func(req Request) Response {
+		m.Lock()
+		defer m.Unlock()
+		calls++
+		rsp := req.Response(nil)
+		rsp.StatusCode = status
+		return rsp
+	}).Filter(breaker.Filter)
+	send := func(url string) Response {
+		return svc(NewRequest(context.Background(), "GET", url, nil))
+	}
+	setStatus := func(s int) {
+		m.Lock()
+		defer m.Unlock()
+		status = s
+		calls = 0
+	}
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusServiceUnavailable, send("http://a/").StatusCode)
+	}
+	assert.Equal(t, BreakerOpen, breaker.State("a"))
+	rsp := send("http://a/")
+	assert.True(t, terrors.PrefixMatches(rsp.Error, terrors.ErrInternalService, "circuit_open"))
+	assert.Equal(t, 3, calls)
+	// Other hosts have their own circuits
+	assert.Equal(t, http.StatusServiceUnavailable, send("http://b/").StatusCode)
+	assert.Equal(t, BreakerClosed, breaker.State("b"))
+
+	// After a while, probes are sent; a failure opens the circuit again
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, BreakerHalfOpen, breaker.State("a"))
+	setStatus(http.StatusServiceUnavailable)
+	send("http://a/")
+	assert.Equal(t, BreakerOpen, breaker.State("a"))
+	assert.Error(t, send("http://a/").Error)
+	assert.Equal(t, 1, calls)
+
+	// Successful probes close it
+	time.Sleep(60 * time.Millisecond)
+	setStatus(http.StatusOK)
+	require.NoError(t, send("http://a/").Error)
+	assert.Equal(t, BreakerHalfOpen, breaker.State("a"))
+	require.NoError(t, send("http://a/").Error)
+	assert.Equal(t, BreakerClosed, breaker.State("a"))
+	assert.Equal(t, []string{
+		"a: closed -> open",
+		"a: open -> half-open",
+		"a: half-open -> open",
+		"a: open -> half-open",
+		"a: half-open -> closed"}, changes)
+}
+
+func TestCircuitBreakerErrorRate(t *testing.T) {
+	t.Parallel()
+	n := 0
+	breaker := NewCircuitBreaker(
+		BreakerConsecutiveFailures(0),
+		BreakerErrorRate(0.5, 10, time.Minute))
+	svc := Service(func(req Request) Response {
+		n++
+		rsp := req.Response(nil)
+		if n%2 == 0 {
+			rsp.StatusCode = http.StatusInternalServerError
+		}
+		return rsp
+	}).Filter(breaker.Filter)
+
+	// Alternating failures never trip the consecutive policy, but do trip the error rate once there are enough requests
+	for i := 0; i < 9; i++ {
+		svc(NewRequest(context.Background(), "GET", "http://a/", nil))
+		assert.Equal(t, BreakerClosed, breaker.State("a"), i)
+	}
+	svc(NewRequest(context.Background(), "GET", "http://a/", nil))
+	assert.Equal(t, BreakerOpen, breaker.State("a"))
+
+	// Cancelled requests don't count
+	breaker = NewCircuitBreaker(BreakerConsecutiveFailures(1))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	svc = Service(func(req Request) Response {
+		return Response{Error: req.Err()}
+	}).Filter(breaker.Filter)
+	svc(NewRequest(ctx, "GET", "http://a/", nil))
+	assert.Equal(t, BreakerClosed, breaker.State("a"))
+}
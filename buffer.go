@@ -6,10 +6,33 @@ import (
 	"sync"
 )
 
+// maxPooledBufSize is the capacity above which buffers aren't returned to bufPool, so that the occasional huge body
+// doesn't pin memory indefinitely.
+const maxPooledBufSize = 64 * 1024
+
+var bufPool = sync.Pool{
+	New: func() interface{} {
+		return &bufCloser{}
+	}}
+
 type bufCloser struct {
 	bytes.Buffer
 }
 
+// newBufCloser returns an empty buffer from the pool.
+func newBufCloser() *bufCloser {
+	return bufPool.Get().(*bufCloser)
+}
+
+// releaseBufCloser returns a buffer to the pool. Nothing may use the buffer (or slices of its contents) afterwards.
+func releaseBufCloser(b *bufCloser) {
+	if b.Cap() > maxPooledBufSize {
+		return
+	}
+	b.Reset()
+	bufPool.Put(b)
+}
+
 func (b *bufCloser) Close() error {
 	return nil // No-op
 }
@@ -56,8 +79,10 @@ func (s *streamer) Close() error {
 type doneReader struct {
 	closed     chan struct{}
 	closedOnce sync.Once
-	length     int64 // length of the underlying reader in bytes, if known. ≤0 indicates unknown
-	read       int64 // number of bytes read
+	length     int64  // length of the underlying reader in bytes, if known. ≤0 indicates unknown
+	read       int64  // number of bytes read
+	eof        bool   // the underlying reader was exhausted (and has been closed)
+	onClose    func() // if set, called once the reader has been closed
 	io.ReadCloser
 }
 
@@ -70,11 +95,20 @@ func newDoneReader(r io.ReadCloser, length int64) *doneReader {
 
 func (r *doneReader) Close() error {
 	err := r.ReadCloser.Close()
-	r.closedOnce.Do(func() { close(r.closed) })
+	r.closedOnce.Do(func() {
+		close(r.closed)
+		if r.onClose != nil {
+			r.onClose()
+		}
+	})
 	return err
 }
 
 func (r *doneReader) Read(p []byte) (int, error) {
+	if r.eof {
+		// Reading again after the end mustn't fail just because the underlying reader was closed
+		return 0, io.EOF
+	}
 	n, err := r.ReadCloser.Read(p)
 	r.read += int64(n)
 	// If we got an error reading, or the reader's length is known and is now exhausted, close
@@ -86,6 +120,7 @@ func (r *doneReader) Read(p []byte) (int, error) {
 		if err == nil {
 			err = io.EOF
 		}
+		r.eof = err == io.EOF
 	}
 	return n, err
 }
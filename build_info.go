@@ -0,0 +1,71 @@
+package libhttp
+
+import (
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// processStarted is when the process started (or near enough: when this package was initialised).
+var processStarted = time.Now()
+
+// BuildInfo describes the build of the running binary.
+type BuildInfo struct {
+	Path      string     `json:"path,omitempty"`    // the main module's path
+	Version   string     `json:"version,omitempty"` // the main module's version ("(devel)" for local builds)
+	Revision  string     `json:"vcs_revision,omitempty"`
+	Time      *time.Time `json:"vcs_time,omitempty"` // when the revision was committed
+	Modified  bool       `json:"vcs_modified,omitempty"`
+	GoVersion string     `json:"go_version"`
+	GOOS      string     `json:"goos"`
+	GOARCH    string     `json:"goarch"`
+}
+
+// ReadBuildInfo returns the build information embedded in the binary by the Go toolchain. The VCS revision and its
+// commit time are only recorded by Go 1.18 and later, when building from a checkout; Go doesn't record when the binary
+// was built, so the commit time is the best approximation of it.
+func ReadBuildInfo() BuildInfo {
+	info := BuildInfo{
+		GoVersion: runtime.Version(),
+		GOOS:      runtime.GOOS,
+		GOARCH:    runtime.GOARCH}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		info.Path = bi.Main.Path
+		info.Version = bi.Main.Version
+		readVCSInfo(bi, &info)
+	}
+	return info
+}
+
+// A DebugInfo is the response of DebugInfoService.
+type DebugInfo struct {
+	BuildInfo
+	Started       time.Time `json:"started"`
+	Uptime        string    `json:"uptime"`
+	UptimeSeconds float64   `json:"uptime_seconds"`
+}
+
+// DebugInfoService returns a Service which responds with the binary's build information (see ReadBuildInfo) and the
+// server's uptime as JSON, for fleet inventory. It is typically mounted under /debug/info:
+//
+//	router.GET("/debug/info", libhttp.DebugInfoService())
+//
+// Uptime is measured from when the server which handled the request was created, or when the process started if it
+// isn't served by a Server of this package. The build information is read once.
+func DebugInfoService() Service {
+	build := ReadBuildInfo()
+	return func(req Request) Response {
+		started := processStarted
+		if req.server != nil {
+			started = req.server.Started()
+		}
+		uptime := time.Since(started)
+		rsp := req.Response(DebugInfo{
+			BuildInfo:     build,
+			Started:       started.UTC(),
+			Uptime:        uptime.Truncate(time.Second).String(),
+			UptimeSeconds: uptime.Seconds()})
+		rsp.Header.Set("Cache-Control", "no-store")
+		return rsp
+	}
+}
@@ -0,0 +1,24 @@
+// +build go1.18
+
+package libhttp
+
+import (
+	"runtime/debug"
+	"time"
+)
+
+// readVCSInfo fills in the version control information which Go 1.18 and later record in builds.
+func readVCSInfo(bi *debug.BuildInfo, info *BuildInfo) {
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			info.Revision = s.Value
+		case "vcs.time":
+			if t, err := time.Parse(time.RFC3339, s.Value); err == nil {
+				info.Time = &t
+			}
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		}
+	}
+}
@@ -0,0 +1,10 @@
+// +build !go1.18
+
+package libhttp
+
+import (
+	"runtime/debug"
+)
+
+// readVCSInfo does nothing, as versions of Go before 1.18 don't record version control information in builds.
+func readVCSInfo(bi *debug.BuildInfo, info *BuildInfo) {}
@@ -0,0 +1,35 @@
+package libhttp
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebugInfoService(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	s, err := Listen(DebugInfoService(), "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(ctx)
+
+	time.Sleep(10 * time.Millisecond)
+	rsp := NewRequest(ctx, "GET", "http://"+s.Listener().Addr().String()+"/debug/info", nil).Send().Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, "no-store", rsp.Header.Get("Cache-Control"))
+	var info DebugInfo
+	require.NoError(t, rsp.Decode(&info))
+	assert.Equal(t, runtime.Version(), info.GoVersion)
+	assert.Equal(t, runtime.GOOS, info.GOOS)
+	assert.Equal(t, ReadBuildInfo().Path, info.Path)
+	assert.True(t, info.Started.Equal(s.Started()), "uptime is measured from when the server was created")
+	assert.True(t, info.UptimeSeconds >= 0.01)
+
+	rsp = DebugInfoService()(NewRequest(ctx, "GET", "/debug/info", nil))
+	require.NoError(t, rsp.Decode(&info))
+	assert.True(t, info.Started.Equal(processStarted))
+}
@@ -0,0 +1,193 @@
+package libhttp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/monzo/terrors"
+)
+
+// A RequestBuilder builds a request to send with a client, one part at a time:
+//
+//  var user User
+//  err := client.Get("https://api.example.com/v1/users").
+//      Query("email", email).
+//      Header("Accept-Language", "en").
+//      Decode(ctx, &user)
+//
+//  rsp := client.Post("https://api.example.com/v1/users").JSON(newUser).Send(ctx).Response()
+//
+// Errors building the request (an invalid URL, or a body which can't be encoded) are returned when it is sent. A
+// builder is for a single request: its methods modify it, and it mustn't be used again once sent.
+type RequestBuilder struct {
+	svc     Service
+	req     Request
+	timeout *time.Duration
+}
+
+// Get starts building a GET request, to send with the service.
+func (svc Service) Get(url string) *RequestBuilder {
+	return svc.Request(http.MethodGet, url)
+}
+
+// Head starts building a HEAD request, to send with the service.
+func (svc Service) Head(url string) *RequestBuilder {
+	return svc.Request(http.MethodHead, url)
+}
+
+// Post starts building a POST request, to send with the service.
+func (svc Service) Post(url string) *RequestBuilder {
+	return svc.Request(http.MethodPost, url)
+}
+
+// Put starts building a PUT request, to send with the service.
+func (svc Service) Put(url string) *RequestBuilder {
+	return svc.Request(http.MethodPut, url)
+}
+
+// Patch starts building a PATCH request, to send with the service.
+func (svc Service) Patch(url string) *RequestBuilder {
+	return svc.Request(http.MethodPatch, url)
+}
+
+// Delete starts building a DELETE request, to send with the service.
+func (svc Service) Delete(url string) *RequestBuilder {
+	return svc.Request(http.MethodDelete, url)
+}
+
+// Request starts building a request with the method, to send with the service.
+func (svc Service) Request(method, url string) *RequestBuilder {
+	return &RequestBuilder{
+		svc: svc,
+		req: NewRequest(context.Background(), method, url, nil)}
+}
+
+// Query adds a query parameter to the URL. Values which aren't strings are formatted in the manner of fmt.Sprint.
+func (b *RequestBuilder) Query(name string, value interface{}) *RequestBuilder {
+	if b.req.err != nil {
+		return b
+	}
+	q := b.req.URL.Query()
+	q.Add(name, fmt.Sprint(value))
+	b.req.URL.RawQuery = q.Encode()
+	return b
+}
+
+// Header adds a header to the request.
+func (b *RequestBuilder) Header(name, value string) *RequestBuilder {
+	if b.req.err == nil {
+		b.req.Header.Add(name, value)
+	}
+	return b
+}
+
+// Timeout limits the request to d, in place of the client's request timeout; see Request.WithTimeout.
+func (b *RequestBuilder) Timeout(d time.Duration) *RequestBuilder {
+	b.timeout = &d
+	return b
+}
+
+// Body encodes v as the body with the codec registered for the content type (see RegisterCodec), which is set as the
+// request's Content-Type.
+func (b *RequestBuilder) Body(contentType string, v interface{}) *RequestBuilder {
+	if b.req.err != nil {
+		return b
+	}
+	c := lookupCodec(contentType)
+	if c == nil {
+		b.fail(terrors.InternalService("no_codec", fmt.Sprintf("No codec is registered for %s", contentType), nil))
+		return b
+	}
+	b.req.encodeWith(c, v)
+	b.req.Header.Set("Content-Type", contentType)
+	return b
+}
+
+// JSON encodes v as the body, as JSON.
+func (b *RequestBuilder) JSON(v interface{}) *RequestBuilder {
+	if b.req.err != nil {
+		return b
+	}
+	b.req.Encode(v)
+	return b
+}
+
+// XML encodes v as the body, as XML.
+func (b *RequestBuilder) XML(v interface{}) *RequestBuilder {
+	if b.req.err != nil {
+		return b
+	}
+	b.req.EncodeXML(v)
+	return b
+}
+
+// Form encodes the values as the body, as an application/x-www-form-urlencoded form.
+func (b *RequestBuilder) Form(vs url.Values) *RequestBuilder {
+	if b.req.err != nil {
+		return b
+	}
+	b.req.EncodeForm(vs)
+	return b
+}
+
+// Reader streams the body from r as the request is sent; see Request.SetBodyReader.
+func (b *RequestBuilder) Reader(contentType string, r io.Reader, length int64) *RequestBuilder {
+	if b.req.err != nil {
+		return b
+	}
+	b.req.SetBodyReader(r, length)
+	b.req.Header.Set("Content-Type", contentType)
+	return b
+}
+
+// fail records an error building the request, unless there is one already.
+func (b *RequestBuilder) fail(err error) {
+	if b.req.err == nil {
+		b.req.err = err
+	}
+}
+
+// Build returns the request, with the context.
+func (b *RequestBuilder) Build(ctx context.Context) Request {
+	req := b.req
+	if ctx != nil {
+		req.Context = ctx
+	}
+	if b.timeout != nil {
+		req = req.WithTimeout(*b.timeout)
+	}
+	return req
+}
+
+// Send sends the request with the service. It does not block, instead returning a ResponseFuture representing the
+// asynchronous operation to produce the response. If building the request failed, the response has the error.
+func (b *RequestBuilder) Send(ctx context.Context) *ResponseFuture {
+	req := b.Build(ctx)
+	if req.err != nil {
+		done := make(chan struct{})
+		close(done)
+		return &ResponseFuture{
+			done: done,
+			r: Response{
+				Request: &req,
+				Error:   req.err}}
+	}
+	return SendVia(req, b.svc)
+}
+
+// Decode sends the request, waits for the response, and decodes its body into v (see Response.Decode), returning any
+// error sending the request or decoding the response. If v is nil, the body is discarded.
+func (b *RequestBuilder) Decode(ctx context.Context, v interface{}) error {
+	rsp := b.Send(ctx).Response()
+	if v == nil {
+		if rsp.Error == nil {
+			discardResponse(rsp)
+		}
+		return rsp.Error
+	}
+	return rsp.Decode(v)
+}
@@ -0,0 +1,87 @@
+package libhttp
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestBuilder(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	type echo struct {
+		Method      string `json:"method"`
+		Query       string `json:"query"`
+		Header      string `json:"header"`
+		ContentType string `json:"content_type"`
+		Body        string `json:"body"`
+	}
+	svc := Service(func(req Request) Response {
+		b, _ := req.BodyBytes(true)
+		return req.Response(echo{
+			Method:      req.Method,
+			Query:       req.URL.RawQuery,
+			Header:      req.Header.Get("X-Test"),
+			ContentType: req.Header.Get("Content-Type"),
+			Body:        strings.TrimSpace(string(b))})
+	})
+
+	var e echo
+	require.NoError(t, svc.Get("http://example.com/a?x=1").Query("q", "a b").Query("n", 2).Header("X-Test", "yes").
+		Decode(ctx, &e))
+	assert.Equal(t, echo{Method: "GET", Query: "n=2&q=a+b&x=1", Header: "yes"}, e)
+
+	require.NoError(t, svc.Post("http://example.com/a").JSON(map[string]int{"a": 1}).Decode(ctx, &e))
+	assert.Equal(t, "POST", e.Method)
+	assert.Equal(t, "application/json", e.ContentType)
+	assert.Equal(t, `{"a":1}`, e.Body)
+
+	require.NoError(t, svc.Put("http://example.com/a").Form(url.Values{"k": {"v"}}).Decode(ctx, &e))
+	assert.Equal(t, "k=v", e.Body)
+	assert.Equal(t, "application/x-www-form-urlencoded", e.ContentType)
+
+	require.NoError(t, svc.Patch("http://example.com/a").Body("application/vnd.api+json", map[string]int{"b": 2}).
+		Decode(ctx, &e))
+	assert.Equal(t, "application/vnd.api+json", e.ContentType)
+	assert.Equal(t, `{"b":2}`, e.Body)
+
+	require.NoError(t, svc.Request("PROPFIND", "http://example.com/a").Reader("text/plain", strings.NewReader("hi"), 2).
+		Decode(ctx, &e))
+	assert.Equal(t, "PROPFIND", e.Method)
+	assert.Equal(t, "hi", e.Body)
+
+	rsp := svc.Delete("http://example.com/a").Send(ctx).Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.MethodDelete, rsp.Request.Method)
+	require.NoError(t, svc.Head("http://example.com/a").Decode(ctx, nil))
+}
+
+func TestRequestBuilderErrors(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	sent := false
+	svc := Service(func(req Request) Response {
+		sent = true
+		return req.Response(nil)
+	})
+
+	err := svc.Get("http://[::1").Query("a", 1).Header("b", "c").JSON(1).Decode(ctx, nil)
+	require.Error(t, err)
+	err = svc.Post("http://example.com").Body("application/x-unknown", 1).Decode(ctx, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "No codec")
+	err = svc.Post("http://example.com").JSON(make(chan int)).Decode(ctx, nil)
+	require.Error(t, err)
+	assert.False(t, sent)
+
+	req := svc.Get("http://example.com").Timeout(time.Second).Build(ctx)
+	d, ok := Value(req, requestTimeoutContextKey)
+	require.True(t, ok)
+	assert.Equal(t, time.Second, d)
+}
@@ -0,0 +1,143 @@
+package libhttp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// A CertificateStore holds a set of certificates and selects between them by SNI during TLS handshakes, allowing one
+// server to terminate TLS for many domains. Certificates may be added and removed while the server is running.
+//
+// To use a store, pass the result of its TLSConfig method to ServeTLS or ListenTLS (with empty certificate and key
+// file names).
+type CertificateStore struct {
+	m        sync.RWMutex
+	byName   map[string]*tls.Certificate // keyed by lower-cased DNS name, which may be a wildcard like *.example.com
+	fallback *tls.Certificate
+}
+
+// NewCertificateStore returns an empty CertificateStore.
+func NewCertificateStore() *CertificateStore {
+	return &CertificateStore{
+		byName: make(map[string]*tls.Certificate)}
+}
+
+// Add adds a certificate to the store, which will be served to clients requesting any of the DNS names it is valid
+// for. If the certificate has no DNS names, its subject common name is used. A certificate added for a name which is
+// already present replaces the existing one.
+//
+// The first certificate added becomes the default, which is served to clients that don't send SNI or that ask for a
+// name the store doesn't know.
+func (s *CertificateStore) Add(cert tls.Certificate) error {
+	leaf := cert.Leaf
+	if leaf == nil {
+		if len(cert.Certificate) == 0 {
+			return fmt.Errorf("certificate has no data")
+		}
+		var err error
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return err
+		}
+		cert.Leaf = leaf
+	}
+	names := leaf.DNSNames
+	if len(names) == 0 && leaf.Subject.CommonName != "" {
+		names = []string{leaf.Subject.CommonName}
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("certificate has no DNS names")
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	for _, n := range names {
+		s.byName[strings.ToLower(n)] = &cert
+	}
+	if s.fallback == nil {
+		s.fallback = &cert
+	}
+	return nil
+}
+
+// AddFile loads a PEM-encoded certificate chain and key from the passed files and adds them to the store.
+func (s *CertificateStore) AddFile(certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	return s.Add(cert)
+}
+
+// SetDefault sets the certificate served when a client sends no SNI, or asks for an unknown name. Unlike Add, the
+// certificate is not associated with any of its names.
+func (s *CertificateStore) SetDefault(cert tls.Certificate) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.fallback = &cert
+}
+
+// Remove removes the certificate serving the passed name, along with all the other names it serves. If it was the
+// default certificate, the store is left without a default.
+func (s *CertificateStore) Remove(name string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	cert, ok := s.byName[strings.ToLower(name)]
+	if !ok {
+		return
+	}
+	for n, c := range s.byName {
+		if c == cert {
+			delete(s.byName, n)
+		}
+	}
+	if s.fallback == cert {
+		s.fallback = nil
+	}
+}
+
+// Names returns the names that the store currently serves certificates for.
+func (s *CertificateStore) Names() []string {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	names := make([]string, 0, len(s.byName))
+	for n := range s.byName {
+		names = append(names, n)
+	}
+	return names
+}
+
+// GetCertificate selects a certificate for the passed handshake. It is suitable for use as tls.Config.GetCertificate.
+func (s *CertificateStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	s.m.RLock()
+	defer s.m.RUnlock()
+	if name != "" {
+		if c, ok := s.byName[name]; ok {
+			return c, nil
+		}
+		// Try a wildcard for the parent domain: a.example.com → *.example.com
+		if i := strings.IndexByte(name, '.'); i > 0 {
+			if c, ok := s.byName["*"+name[i:]]; ok {
+				return c, nil
+			}
+		}
+	}
+	if s.fallback != nil {
+		return s.fallback, nil
+	}
+	return nil, fmt.Errorf("no certificate for %q", hello.ServerName)
+}
+
+// TLSConfig returns a copy of the passed configuration (or of TLSProfileIntermediate if it is nil) which selects
+// certificates from the store.
+func (s *CertificateStore) TLSConfig(base *tls.Config) *tls.Config {
+	if base == nil {
+		base = TLSProfileIntermediate()
+	}
+	cfg := base.Clone()
+	cfg.GetCertificate = s.GetCertificate
+	return cfg
+}
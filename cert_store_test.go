@@ -0,0 +1,43 @@
+package libhttp
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCertificateStore(t *testing.T) {
+	t.Parallel()
+
+	store := NewCertificateStore()
+	require.NoError(t, store.Add(keypair(t, []string{"a.example.com"})))
+	require.NoError(t, store.Add(keypair(t, []string{"*.b.example.com", "b.example.com"})))
+	assert.ElementsMatch(t, []string{"a.example.com", "*.b.example.com", "b.example.com"}, store.Names())
+
+	s, err := ListenTLS(Service(func(req Request) Response {
+		return req.Response(nil)
+	}), "localhost:0", "", "", store.TLSConfig(nil))
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+
+	servedNames := func(sni string) []string {
+		conn, err := tls.Dial("tcp", s.Listener().Addr().String(), &tls.Config{
+			ServerName:         sni,
+			InsecureSkipVerify: true})
+		require.NoError(t, err)
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].DNSNames
+	}
+	assert.Equal(t, []string{"a.example.com"}, servedNames("a.example.com"))
+	assert.Equal(t, []string{"*.b.example.com", "b.example.com"}, servedNames("b.example.com"))
+	assert.Equal(t, []string{"*.b.example.com", "b.example.com"}, servedNames("x.b.example.com"))
+	assert.Equal(t, []string{"a.example.com"}, servedNames("unknown.example.com")) // default
+
+	// Removing a certificate at runtime removes all of its names
+	store.Remove("*.b.example.com")
+	assert.Equal(t, []string{"a.example.com"}, store.Names())
+	assert.Equal(t, []string{"a.example.com"}, servedNames("b.example.com"))
+}
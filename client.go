@@ -1,9 +1,15 @@
 package libhttp
 
 import (
+	"context"
+	"errors"
+	"io"
+	"net"
 	"net/http"
+	"sync"
 	"time"
 
+	"github.com/4thel00z/libhttp/libhttpmetrics"
 	"github.com/monzo/terrors"
 )
 
@@ -22,8 +28,12 @@ var (
 
 // A ResponseFuture is a container for a Response which will materialise at some point.
 type ResponseFuture struct {
-	done <-chan struct{} // guards access to r
-	r    Response
+	done      <-chan struct{} // guards access to r
+	r         Response
+	cancel    context.CancelFunc
+	m         sync.Mutex
+	cancelled bool
+	body      io.Closer // the response's body once it has arrived, which Cancel closes
 }
 
 // WaitC returns a channel which can be waited upon until the response is available
@@ -47,8 +57,15 @@ func HttpService(rt http.RoundTripper) Service {
 		// This protects callers that forget to call Close(), or those which proxy responses upstream
 		//
 		// If the calling context is infinite (ie. returns nil for Done()), it can never signal cancellation
-		// so we bypass this as a performance optimisation
-		if httpRsp != nil && httpRsp.Body != nil && ctx.Done() != nil {
+		// so we bypass this as a performance optimisation. That includes the context of a request sent with SendVia
+		// whose original context is infinite: its ResponseFuture closes the body itself if it is cancelled.
+		var done <-chan struct{}
+		if c, ok := ctx.(sendContext); ok {
+			done = c.parent.Done()
+		} else {
+			done = ctx.Done()
+		}
+		if httpRsp != nil && httpRsp.Body != nil && done != nil {
 			body := newDoneReader(httpRsp.Body, httpRsp.ContentLength)
 			httpRsp.Body = body
 			go func() {
@@ -72,14 +89,35 @@ func BareClient(req Request) Response {
 }
 
 // SendVia round-trips the request via the passed Service. It does not block, instead returning a ResponseFuture
-// representing the asynchronous operation to produce the response.
+// representing the asynchronous operation to produce the response, which can be cancelled independently of the
+// request's context.
 func SendVia(req Request, svc Service) *ResponseFuture {
 	done := make(chan struct{}, 0)
+	req, cancel := withSendContext(req)
 	f := &ResponseFuture{
-		done: done}
+		done:   done,
+		cancel: cancel}
 	go func() {
 		defer close(done) // makes the response available to waiters
-		f.r = svc(req)
+		rsp := withoutSendContext(svc(req))
+		var body io.Closer
+		if req.Context.(sendContext).parent.Done() == nil {
+			// The context needn't be released: it isn't registered with the (infinite) parent context, so once it's
+			// unreachable it's collected. Reading the body isn't tracked at all then.
+			if rsp.Response != nil {
+				body = rsp.Body
+			}
+		} else {
+			rsp, body = cancelOnClose(rsp, cancel)
+		}
+		f.r = rsp
+		f.m.Lock()
+		cancelled := f.cancelled
+		f.body = body
+		f.m.Unlock()
+		if cancelled && body != nil {
+			body.Close()
+		}
 	}()
 	return f
 }
@@ -91,3 +129,273 @@ func SendVia(req Request, svc Service) *ResponseFuture {
 func Send(req Request) *ResponseFuture {
 	return SendVia(req, Client)
 }
+
+// A ClientOption configures a client created by NewClient.
+type ClientOption func(*clientOptions)
+
+type clientOptions struct {
+	roundTripper  http.RoundTripper
+	header        http.Header
+	timeout       time.Duration
+	transportOpts []func(*http.Transport) // options which need an *http.Transport, applied to a copy
+	h2c           bool
+	h3            http.RoundTripper
+	jar           http.CookieJar
+	redirects     Filter
+	tracing       bool
+	propagators   TracePropagators
+	deadlines     bool
+	logging       *clientLogOptions
+	metrics       *ClientMetrics
+	registry      libhttpmetrics.Registry
+	meters        libhttpmetrics.MeterProvider
+	hooks         []Hooks
+	poolMetrics   *PoolMetrics
+	filters       []Filter
+}
+
+// WithRoundTripper sets the RoundTripper which the client sends requests with, to customise the transport (TLS
+// settings, proxies, connection pooling and so on). The default is the package's RoundTripper.
+func WithRoundTripper(rt http.RoundTripper) ClientOption {
+	return func(o *clientOptions) {
+		o.roundTripper = rt
+	}
+}
+
+// WithDefaultHeader adds a header to every request the client sends, unless the request already has a value for it.
+// This is useful for things like User-Agent and API keys.
+func WithDefaultHeader(name, value string) ClientOption {
+	return func(o *clientOptions) {
+		o.header.Add(name, value)
+	}
+}
+
+// WithRequestTimeout sets a deadline for each request the client sends, which covers any filters as well as reading
+// the response body. Requests whose contexts already have an earlier deadline are unaffected, and individual requests
+// can be given a different timeout with Request.WithTimeout.
+func WithRequestTimeout(d time.Duration) ClientOption {
+	return func(o *clientOptions) {
+		o.timeout = d
+	}
+}
+
+// WithConnectTimeout limits how long the client waits to establish a connection (not including any TLS handshake).
+// Like the other transport options, it requires the client's RoundTripper to be an *http.Transport, which is copied
+// rather than modified.
+func WithConnectTimeout(d time.Duration) ClientOption {
+	return func(o *clientOptions) {
+		o.transportOpts = append(o.transportOpts, func(t *http.Transport) {
+			dial := t.DialContext
+			if dial == nil {
+				dial = (&net.Dialer{
+					KeepAlive: 30 * time.Second}).DialContext
+			}
+			t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+				ctx, cancel := context.WithTimeout(ctx, d)
+				defer cancel()
+				return dial(ctx, network, addr)
+			}
+		})
+	}
+}
+
+// WithTLSHandshakeTimeout limits how long the client waits for a TLS handshake to complete, once connected.
+func WithTLSHandshakeTimeout(d time.Duration) ClientOption {
+	return func(o *clientOptions) {
+		o.transportOpts = append(o.transportOpts, func(t *http.Transport) {
+			t.TLSHandshakeTimeout = d
+		})
+	}
+}
+
+// WithResponseHeaderTimeout limits how long the client waits for the response's headers, once the request (including
+// its body) has been written. It doesn't limit reading the response body.
+func WithResponseHeaderTimeout(d time.Duration) ClientOption {
+	return func(o *clientOptions) {
+		o.transportOpts = append(o.transportOpts, func(t *http.Transport) {
+			t.ResponseHeaderTimeout = d
+		})
+	}
+}
+
+// WithClientFilters adds filters which the client applies to every request, in the order they are passed: the first
+// sees the request first and the response last. Filters from several options are applied in the order of the options.
+func WithClientFilters(filters ...Filter) ClientOption {
+	return func(o *clientOptions) {
+		o.filters = append(o.filters, filters...)
+	}
+}
+
+// NewClient returns a client Service configured by the passed options. Like any other Service, it can be further
+// filtered, used to send requests with SendVia, or installed as the default Client:
+//
+//  libhttp.Client = libhttp.NewClient(
+//      libhttp.WithDefaultHeader("User-Agent", "billing/1.2"),
+//      libhttp.WithRequestTimeout(10*time.Second),
+//      libhttp.WithClientFilters(libhttp.ErrorFilter))
+//
+//  rsp := libhttp.NewRequest(ctx, "GET", "https://api.example.com/v1/users", nil).Send().Response()
+//
+// The timeouts interact with the request's context as follows. The context's deadline (if it has one) always applies,
+// to the request as a whole. The request timeout (WithRequestTimeout, or Request.WithTimeout) adds a deadline of its
+// own, so a request is bounded by whichever is earlier. The transport timeouts (for connecting, the TLS handshake
+// and the response header) apply to those phases individually, within the request's deadline. A request which fails
+// because of one of the client's timeouts gets a timeout error; one which fails because its context's deadline
+// passed gets the context's error.
+//
+// NewClient panics if transport options (timeouts or pool settings) are set and the RoundTripper isn't an
+// *http.Transport.
+func NewClient(opts ...ClientOption) Service {
+	o := clientOptions{
+		roundTripper: RoundTripper,
+		header:       http.Header{}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	svc := HttpService(o.transport())
+	if o.logging != nil {
+		svc = svc.Filter(countAttempt)
+	}
+	if o.metrics != nil {
+		svc = svc.Filter(o.metrics.filter)
+	}
+	if o.registry != nil {
+		svc = svc.Filter(clientMetricsFilter(o.registry)).Filter(poolMetricsFilter(newPoolRegistry(o.registry)))
+	}
+	if o.meters != nil {
+		svc = svc.Filter(clientMeterFilter(o.meters.Meter(meterScope)))
+	}
+	if len(o.hooks) > 0 {
+		svc = svc.Filter(clientHooksFilter(o.hooks))
+	}
+	if o.poolMetrics != nil {
+		svc = svc.Filter(poolMetricsFilter(o.poolMetrics))
+	}
+	if o.deadlines {
+		svc = svc.Filter(deadlinePropagationFilter)
+	}
+	for i := len(o.filters) - 1; i >= 0; i-- {
+		svc = svc.Filter(o.filters[i])
+	}
+	if o.jar != nil {
+		svc = svc.Filter(cookieJarFilter(o.jar))
+	}
+	if o.redirects != nil {
+		svc = svc.Filter(o.redirects)
+	}
+	if len(o.header) > 0 {
+		svc = svc.Filter(defaultHeaderFilter(o.header))
+	}
+	svc = svc.Filter(timeoutFilter(o.timeout))
+	if o.logging != nil {
+		svc = svc.Filter(o.logging.filter)
+	}
+	if o.tracing {
+		// Outside the timeout filter, which replaces the request's context
+		svc = svc.Filter(tracePropagationFilter(o.propagators))
+	}
+	return svc
+}
+
+// transport returns the RoundTripper, with the transport options applied.
+func (o clientOptions) transport() http.RoundTripper {
+	rt := o.roundTripper
+	if len(o.transportOpts) > 0 {
+		t, ok := rt.(*http.Transport)
+		if !ok {
+			panic("libhttp: transport options require the client's RoundTripper to be an *http.Transport")
+		}
+		t = t.Clone()
+		for _, opt := range o.transportOpts {
+			opt(t)
+		}
+		rt = timeoutTransport{t}
+	}
+	if o.h2c {
+		rt = newH2cTransport(rt)
+	}
+	if o.h3 != nil {
+		rt = newH3Transport(o.h3, rt)
+	}
+	return rt
+}
+
+// timeoutTransport returns timeout errors for requests which fail because of the transport's timeouts.
+type timeoutTransport struct {
+	*http.Transport
+}
+
+func (t timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rsp, err := t.Transport.RoundTrip(req)
+	var nerr net.Error
+	if err != nil && req.Context().Err() == nil && errors.As(err, &nerr) && nerr.Timeout() {
+		err = terrors.Timeout("transport", err.Error(), nil)
+	}
+	return rsp, err
+}
+
+// defaultHeaderFilter adds the passed headers to requests which don't have them.
+func defaultHeaderFilter(h http.Header) Filter {
+	return func(req Request, svc Service) Response {
+		if req.Header == nil {
+			req.Header = make(http.Header, len(h))
+		}
+		for name, vs := range h {
+			if _, ok := req.Header[name]; !ok {
+				req.Header[name] = append([]string(nil), vs...)
+			}
+		}
+		return svc(req)
+	}
+}
+
+// timeoutFilter applies a deadline to requests (the request's own timeout if it has one, otherwise d if it is
+// positive), which lasts until the response body is closed.
+func timeoutFilter(d time.Duration) Filter {
+	return func(req Request, svc Service) Response {
+		d := d
+		if v, ok := Value(req, requestTimeoutContextKey); ok {
+			d = v.(time.Duration)
+		}
+		if d <= 0 {
+			return svc(req)
+		}
+		parent := req.unwrappedContext()
+		if parent == nil {
+			parent = context.Background()
+		}
+		ctx, cancel := context.WithTimeout(parent, d)
+		req.Context = ctx
+		rsp := svc(req)
+		if rsp.Error != nil && ctx.Err() == context.DeadlineExceeded && parent.Err() == nil {
+			rsp.Error = terrors.Timeout("request", "Request timed out", map[string]string{
+				"timeout": d.String()})
+		}
+		rsp, _ = cancelOnClose(rsp, cancel)
+		return rsp
+	}
+}
+
+// cancelOnClose arranges for a context which a response was produced with to be cancelled once its body has been
+// closed (or read to completion), or straight away if it has no body. It returns the wrapped body, if any. No goroutine
+// waits for this, so a body which is never closed doesn't pin one.
+func cancelOnClose(rsp Response, cancel context.CancelFunc) (Response, io.Closer) {
+	if rsp.Response == nil || rsp.Body == nil {
+		cancel()
+		return rsp, nil
+	}
+	body := newDoneReader(rsp.Body, -1)
+	body.onClose = cancel
+	rsp.Body = body
+	return rsp, body
+}
+
+var requestTimeoutContextKey = NewContextKey("request_timeout", time.Duration(0))
+
+// WithTimeout returns a copy of the request which, when sent by a client created by NewClient, is limited to d in place
+// of the client's request timeout (a zero duration removes the timeout). Unlike a context deadline, the time starts
+// when the request is sent rather than when WithTimeout is called.
+func (r Request) WithTimeout(d time.Duration) Request {
+	return SetValue(r, requestTimeoutContextKey, d)
+}
@@ -0,0 +1,128 @@
+package libhttp
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/monzo/slog"
+)
+
+// A ClientCAPool holds the bundle of certificate authorities used to verify client certificates in mutual TLS. The
+// bundle is loaded from a PEM file, and can be reloaded at runtime (either explicitly with Reload, or automatically by
+// Watch) so that internal CAs can be rotated without restarting servers.
+//
+// To use a pool, pass it to ServeTLS or ListenTLS with WithClientCAs.
+type ClientCAPool struct {
+	file    string
+	m       sync.RWMutex
+	pool    *x509.CertPool
+	modTime time.Time // of the file when it was last loaded
+	size    int64
+}
+
+// NewClientCAPool loads the PEM-encoded certificate authorities in the passed file.
+func NewClientCAPool(file string) (*ClientCAPool, error) {
+	p := &ClientCAPool{
+		file: file}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Reload re-reads the pool's file. Handshakes which start after Reload returns verify client certificates against
+// the new bundle. If the file can't be loaded, the pool continues to use the previous bundle.
+func (p *ClientCAPool) Reload() error {
+	fi, err := os.Stat(p.file)
+	if err != nil {
+		return err
+	}
+	pem, err := ioutil.ReadFile(p.file)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no certificates found in %s", p.file)
+	}
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.pool = pool
+	p.modTime = fi.ModTime()
+	p.size = fi.Size()
+	return nil
+}
+
+// Watch polls the pool's file at the passed interval, reloading it whenever it changes, until the context is
+// cancelled. It is typically run in its own goroutine. Failures to reload are logged.
+func (p *ClientCAPool) Watch(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if !p.changed() {
+			continue
+		}
+		if err := p.Reload(); err != nil {
+			slog.Warn(ctx, "Couldn't reload client CAs from %s: %v", p.file, err)
+		} else {
+			slog.Info(ctx, "Reloaded client CAs from %s", p.file)
+		}
+	}
+}
+
+func (p *ClientCAPool) changed() bool {
+	fi, err := os.Stat(p.file)
+	if err != nil {
+		return true // let Reload report the error
+	}
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return !fi.ModTime().Equal(p.modTime) || fi.Size() != p.size
+}
+
+// Pool returns the current certificate pool.
+func (p *ClientCAPool) Pool() *x509.CertPool {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return p.pool
+}
+
+// tlsConfig returns a copy of the passed configuration which verifies client certificates against the pool's current
+// contents. If the configuration doesn't otherwise ask for client certificates, they are required.
+func (p *ClientCAPool) tlsConfig(base *tls.Config) *tls.Config {
+	template := base.Clone()
+	if template.ClientAuth == tls.NoClientCert {
+		template.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	getConfigForClient := template.GetConfigForClient
+	template.GetConfigForClient = nil
+
+	cfg := template.Clone()
+	cfg.ClientCAs = p.Pool()
+	// The pool is looked up for every handshake, since a tls.Config can't be modified once it's in use
+	cfg.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		c := template
+		if getConfigForClient != nil {
+			if override, err := getConfigForClient(hello); err != nil {
+				return nil, err
+			} else if override != nil {
+				c = override
+			}
+		}
+		c = c.Clone()
+		c.ClientCAs = p.Pool()
+		return c, nil
+	}
+	return cfg
+}
@@ -0,0 +1,119 @@
+package libhttp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clientCA creates a certificate authority, returning it PEM-encoded along with a client certificate it has issued
+func clientCA(t *testing.T, name string) ([]byte, tls.Certificate) {
+	now := time.Now()
+	caKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDer)
+	require.NoError(t, err)
+
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: name + " client"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDer}),
+		tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestClientCAReload(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "libhttp-client-ca")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	ca1, client1 := clientCA(t, "CA 1")
+	ca2, client2 := clientCA(t, "CA 2")
+	require.NoError(t, ioutil.WriteFile(caFile, ca1, 0600))
+
+	pool, err := NewClientCAPool(caFile)
+	require.NoError(t, err)
+	cfg := TLSProfileIntermediate()
+	cfg.Certificates = []tls.Certificate{keypair(t, []string{"127.0.0.1"})}
+	srv, err := ListenTLS(Service(func(req Request) Response {
+		return req.Response(req.TLS.PeerCertificates[0].Subject.CommonName)
+	}), "localhost:0", "", "", cfg, WithClientCAs(pool))
+	require.NoError(t, err)
+	defer srv.Stop(context.Background())
+
+	get := func(cert tls.Certificate) Response {
+		client := HttpService(&http.Transport{
+			DisableKeepAlives: true,
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+				Certificates:       []tls.Certificate{cert}}}).Filter(ErrorFilter)
+		req := NewRequest(context.Background(), "GET", fmt.Sprintf("https://%s", srv.Listener().Addr()), nil)
+		return req.SendVia(client).Response()
+	}
+	rsp := get(client1)
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Error(t, get(client2).Error)
+
+	// Rotate the CA and reload explicitly
+	require.NoError(t, ioutil.WriteFile(caFile, ca2, 0600))
+	require.NoError(t, pool.Reload())
+	assert.Error(t, get(client1).Error)
+	rsp = get(client2)
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+
+	// A broken file leaves the previous bundle in place
+	require.NoError(t, ioutil.WriteFile(caFile, []byte("garbage"), 0600))
+	assert.Error(t, pool.Reload())
+	assert.NoError(t, get(client2).Error)
+
+	// Rotate back, and let the watcher notice
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go pool.Watch(ctx, 10*time.Millisecond)
+	require.NoError(t, ioutil.WriteFile(caFile, ca1, 0600))
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(caFile, later, later))
+	deadline := time.Now().Add(5 * time.Second)
+	for get(client1).Error != nil {
+		require.True(t, time.Now().Before(deadline), "watcher didn't reload the rotated CA")
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.Error(t, get(client2).Error)
+}
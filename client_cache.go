@@ -0,0 +1,435 @@
+package libhttp
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A CacheEntry is a response stored by a ClientCacheFilter. Its fields are exported so that stores can serialise it.
+type CacheEntry struct {
+	StatusCode   int
+	Header       http.Header
+	Body         []byte
+	Vary         http.Header // the request's values of the headers named by the response's Vary header
+	RequestTime  time.Time   // when the request which produced the response was sent
+	ResponseTime time.Time   // when the response was received
+}
+
+// A CacheStore stores the responses of a ClientCacheFilter, by keys derived from request URLs. Entries it returns are
+// not modified. Implementations must be safe for concurrent use, and may evict entries whenever they like.
+type CacheStore interface {
+	Get(key string) (*CacheEntry, bool)
+	Set(key string, e *CacheEntry)
+	Delete(key string)
+}
+
+// memoryCacheStore is a CacheStore which keeps a bounded number of entries in memory, evicting the least recently
+// used.
+type memoryCacheStore struct {
+	m          sync.Mutex
+	maxEntries int
+	lru        *list.List // of *memoryCacheItem, most recently used first
+	items      map[string]*list.Element
+}
+
+type memoryCacheItem struct {
+	key   string
+	entry *CacheEntry
+}
+
+// NewMemoryCacheStore returns a CacheStore which keeps up to maxEntries responses in memory, evicting the least
+// recently used.
+func NewMemoryCacheStore(maxEntries int) CacheStore {
+	return &memoryCacheStore{
+		maxEntries: maxEntries,
+		lru:        list.New(),
+		items:      map[string]*list.Element{}}
+}
+
+func (s *memoryCacheStore) Get(key string) (*CacheEntry, bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	el, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	s.lru.MoveToFront(el)
+	return el.Value.(*memoryCacheItem).entry, true
+}
+
+func (s *memoryCacheStore) Set(key string, e *CacheEntry) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if el, ok := s.items[key]; ok {
+		el.Value.(*memoryCacheItem).entry = e
+		s.lru.MoveToFront(el)
+		return
+	}
+	s.items[key] = s.lru.PushFront(&memoryCacheItem{
+		key:   key,
+		entry: e})
+	for s.maxEntries > 0 && s.lru.Len() > s.maxEntries {
+		el := s.lru.Back()
+		s.lru.Remove(el)
+		delete(s.items, el.Value.(*memoryCacheItem).key)
+	}
+}
+
+func (s *memoryCacheStore) Delete(key string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if el, ok := s.items[key]; ok {
+		s.lru.Remove(el)
+		delete(s.items, key)
+	}
+}
+
+// A CacheOption configures a ClientCacheFilter.
+type CacheOption func(*cacheOptions)
+
+type cacheOptions struct {
+	shared       bool
+	maxBodyBytes int64
+	now          func() time.Time
+}
+
+// CacheShared makes the cache behave as a shared cache (like a proxy's), rather than a private one (like a browser's):
+// responses marked private, and responses to requests with an Authorization header which aren't explicitly marked as
+// shareable, aren't stored, and s-maxage is honoured. This is appropriate when the client makes requests on behalf of
+// several users.
+func CacheShared() CacheOption {
+	return func(o *cacheOptions) {
+		o.shared = true
+	}
+}
+
+// CacheMaxBodyBytes sets the largest response body which is stored; the default is 1MiB.
+func CacheMaxBodyBytes(n int64) CacheOption {
+	return func(o *cacheOptions) {
+		o.maxBodyBytes = n
+	}
+}
+
+// heuristicallyCacheable are the statuses whose responses may be stored without explicit freshness information
+// (RFC 7231 section 6.1).
+var heuristicallyCacheable = map[int]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true, 404: true, 405: true, 410: true, 414: true,
+	501: true}
+
+// ClientCacheFilter returns a client Filter which caches responses to GET requests in the store, following the HTTP
+// caching rules of RFC 7234, so that repeated requests for the same resources are answered without contacting the
+// server while the responses are fresh:
+//
+//  client := libhttp.NewClient(libhttp.WithClientFilters(
+//      libhttp.ClientCacheFilter(libhttp.NewMemoryCacheStore(10000))))
+//
+// Responses are fresh for as long as their Cache-Control max-age (or Expires header) allows; responses without these
+// but with a Last-Modified time are considered fresh for a tenth of the time since they were last modified, up to a
+// day. Stale responses (and those marked no-cache) are revalidated with a conditional request using their ETag or
+// Last-Modified time, and served from the cache if the server responds 304 Not Modified. Responses are stored
+// separately for each set of values of the request headers named by their Vary header (one set at a time). Requests'
+// Cache-Control directives (no-store, no-cache, max-age, max-stale, min-fresh and only-if-cached) are honoured too.
+//
+// Successful requests with unsafe methods (POST, PUT, DELETE and so on) invalidate the cached response for their URL.
+// Responses served from the cache have an Age header. Requests with a Range header bypass the cache, and partial (206)
+// responses are never stored.
+func ClientCacheFilter(store CacheStore, opts ...CacheOption) Filter {
+	o := cacheOptions{
+		maxBodyBytes: 1 << 20,
+		now:          time.Now}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return func(req Request, svc Service) Response {
+		if req.URL == nil {
+			return svc(req)
+		}
+		key := req.URL.String()
+		if req.Method != http.MethodGet {
+			rsp := svc(req)
+			if !isSafeMethod(req.Method) && rsp.Error == nil && rsp.Response != nil && rsp.StatusCode < 400 {
+				store.Delete(key)
+			}
+			return rsp
+		}
+
+		reqCC := parseCacheControl(req.Header)
+		if reqCC.has("no-store") || req.Header.Get("Range") != "" {
+			return svc(req) // partial responses aren't cached, and can't be served from whole ones
+		}
+		entry, ok := store.Get(key)
+		if ok && !varyMatches(entry, req.Header) {
+			entry, ok = nil, false
+		}
+		if ok {
+			if o.servable(entry, reqCC) {
+				return cachedResponse(req, entry, o.now())
+			}
+		}
+		if reqCC.has("only-if-cached") {
+			rsp := NewResponse(req)
+			rsp.StatusCode = http.StatusGatewayTimeout
+			return rsp
+		}
+
+		// Revalidate the stored response, unless the caller is making a conditional request of their own
+		sent := req
+		revalidating := false
+		if ok && req.Header.Get("If-None-Match") == "" && req.Header.Get("If-Modified-Since") == "" {
+			if etag, lastModified := entry.Header.Get("ETag"), entry.Header.Get("Last-Modified"); etag != "" ||
+				lastModified != "" {
+				sent.Header = req.Header.Clone()
+				if etag != "" {
+					sent.Header.Set("If-None-Match", etag)
+				}
+				if lastModified != "" {
+					sent.Header.Set("If-Modified-Since", lastModified)
+				}
+				revalidating = true
+			}
+		}
+		requestTime := o.now()
+		rsp := svc(sent)
+		if rsp.Response == nil {
+			return rsp
+		}
+		if revalidating && rsp.StatusCode == http.StatusNotModified {
+			discardResponse(rsp)
+			updated := *entry
+			updated.Header = entry.Header.Clone()
+			for name, values := range rsp.Header {
+				switch http.CanonicalHeaderKey(name) {
+				case "Content-Length", "Transfer-Encoding", "Connection":
+				default:
+					updated.Header[name] = values
+				}
+			}
+			updated.RequestTime, updated.ResponseTime = requestTime, o.now()
+			store.Set(key, &updated)
+			return cachedResponse(req, &updated, o.now())
+		}
+		if rsp.Error == nil {
+			o.store(store, key, req, rsp, requestTime)
+		}
+		return rsp
+	}
+}
+
+// servable returns whether the entry can be used without revalidation, given the request's Cache-Control directives.
+func (o cacheOptions) servable(e *CacheEntry, reqCC cacheControl) bool {
+	rspCC := parseCacheControl(e.Header)
+	if reqCC.has("no-cache") || rspCC.has("no-cache") || e.Header.Get("Pragma") == "no-cache" {
+		return false
+	}
+	age := currentAge(e, o.now())
+	lifetime := o.freshnessLifetime(e, rspCC)
+	if maxAge, ok := reqCC.seconds("max-age"); ok && age > maxAge {
+		return false
+	}
+	if minFresh, ok := reqCC.seconds("min-fresh"); ok {
+		age += minFresh
+	}
+	if age < lifetime {
+		return true
+	}
+	// Stale responses may be served if the request allows it, and the response doesn't forbid it
+	if rspCC.has("must-revalidate") || (o.shared && rspCC.has("proxy-revalidate")) {
+		return false
+	}
+	if maxStale, ok := reqCC.seconds("max-stale"); ok {
+		return age < lifetime+maxStale
+	}
+	return reqCC.has("max-stale") // with no value, any staleness is acceptable
+}
+
+// freshnessLifetime returns how long the response is fresh for after it was generated (RFC 7234 section 4.2.1).
+func (o cacheOptions) freshnessLifetime(e *CacheEntry, cc cacheControl) time.Duration {
+	if o.shared {
+		if d, ok := cc.seconds("s-maxage"); ok {
+			return d
+		}
+	}
+	if d, ok := cc.seconds("max-age"); ok {
+		return d
+	}
+	date, err := http.ParseTime(e.Header.Get("Date"))
+	if err != nil {
+		date = e.ResponseTime
+	}
+	if expires := e.Header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil || t.Before(date) {
+			return 0 // invalid dates mean already expired
+		}
+		return t.Sub(date)
+	}
+	if lastModified, err := http.ParseTime(e.Header.Get("Last-Modified")); err == nil &&
+		heuristicallyCacheable[e.StatusCode] && date.After(lastModified) {
+		d := date.Sub(lastModified) / 10
+		if d > 24*time.Hour {
+			d = 24 * time.Hour
+		}
+		return d
+	}
+	return 0
+}
+
+// currentAge returns the age of the stored response (RFC 7234 section 4.2.3).
+func currentAge(e *CacheEntry, now time.Time) time.Duration {
+	var apparentAge time.Duration
+	if date, err := http.ParseTime(e.Header.Get("Date")); err == nil && e.ResponseTime.After(date) {
+		apparentAge = e.ResponseTime.Sub(date)
+	}
+	ageValue, _ := strconv.Atoi(e.Header.Get("Age"))
+	correctedAge := time.Duration(ageValue)*time.Second + e.ResponseTime.Sub(e.RequestTime)
+	if correctedAge > apparentAge {
+		apparentAge = correctedAge
+	}
+	return apparentAge + now.Sub(e.ResponseTime)
+}
+
+// store stores the response if it may be, replacing its body with a buffered copy.
+func (o cacheOptions) store(store CacheStore, key string, req Request, rsp Response, requestTime time.Time) {
+	cc := parseCacheControl(rsp.Header)
+	switch {
+	case parseCacheControl(req.Header).has("no-store"), cc.has("no-store"), o.shared && cc.has("private"):
+		return
+	case o.shared && req.Header.Get("Authorization") != "" &&
+		!(cc.has("public") || cc.has("must-revalidate") || cc.has("s-maxage")):
+		return
+	case strings.Contains(rsp.Header.Get("Vary"), "*"):
+		return
+	case rsp.StatusCode == http.StatusPartialContent, req.Header.Get("Range") != "":
+		return // it's only part of the representation stored under the key
+	}
+	_, hasMaxAge := cc.seconds("max-age")
+	_, hasSMaxAge := cc.seconds("s-maxage")
+	explicit := hasMaxAge || (o.shared && hasSMaxAge) || rsp.Header.Get("Expires") != "" || cc.has("public")
+	if !explicit && !heuristicallyCacheable[rsp.StatusCode] {
+		return
+	}
+	if !explicit && rsp.Header.Get("ETag") == "" && rsp.Header.Get("Last-Modified") == "" {
+		return // it would be stale immediately, with no way to revalidate it
+	}
+
+	// Buffer the body, up to the limit
+	var body []byte
+	if rsp.Body != nil {
+		rc := rsp.Body
+		b, err := ioutil.ReadAll(io.LimitReader(rc, o.maxBodyBytes+1))
+		if err != nil || int64(len(b)) > o.maxBodyBytes {
+			// Put back what was read, so nothing is lost
+			rsp.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(b), rc), rc}
+			return
+		}
+		rc.Close()
+		buf := &bufCloser{}
+		buf.Write(b)
+		rsp.Body = buf
+		body = b
+	}
+
+	vary := http.Header{}
+	for _, name := range varyHeaders(rsp.Header) {
+		vary[name] = req.Header[name]
+	}
+	store.Set(key, &CacheEntry{
+		StatusCode:   rsp.StatusCode,
+		Header:       rsp.Header.Clone(),
+		Body:         body,
+		Vary:         vary,
+		RequestTime:  requestTime,
+		ResponseTime: o.now()})
+}
+
+// cachedResponse returns a response to the request from the stored entry.
+func cachedResponse(req Request, e *CacheEntry, now time.Time) Response {
+	rsp := NewResponse(req)
+	rsp.StatusCode = e.StatusCode
+	rsp.Header = e.Header.Clone()
+	rsp.Header.Set("Age", strconv.Itoa(int(currentAge(e, now).Seconds())))
+	rsp.Write(e.Body)
+	return rsp
+}
+
+// varyHeaders returns the canonicalised names of the headers named by the response's Vary header.
+func varyHeaders(h http.Header) []string {
+	var names []string
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return names
+}
+
+// varyMatches returns whether the request has the same values as the entry's for the headers it varies on.
+func varyMatches(e *CacheEntry, h http.Header) bool {
+	for _, name := range varyHeaders(e.Header) {
+		if name == "*" || strings.Join(e.Vary[name], ",") != strings.Join(h[name], ",") {
+			return false
+		}
+	}
+	return true
+}
+
+// isSafeMethod returns whether the method is safe (RFC 7231 section 4.2.1).
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// cacheControl holds parsed Cache-Control directives, by their lower case names.
+type cacheControl map[string]string
+
+func parseCacheControl(h http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, v := range h.Values("Cache-Control") {
+		for _, directive := range strings.Split(v, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+			parts := strings.SplitN(directive, "=", 2)
+			value := ""
+			if len(parts) == 2 {
+				value = strings.Trim(strings.TrimSpace(parts[1]), `"`)
+			}
+			cc[strings.ToLower(strings.TrimSpace(parts[0]))] = value
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
+
+// seconds returns the value of a directive which is a number of seconds, if it has one.
+func (cc cacheControl) seconds(directive string) (time.Duration, bool) {
+	v, ok := cc[directive]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
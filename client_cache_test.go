@@ -0,0 +1,201 @@
+package libhttp
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientCacheFilter(t *testing.T) {
+	t.Parallel()
+	var m sync.Mutex
+	hits := map[string]int{}
+	now := time.Now()
+	router := Router{}
+	handle := func(path string, f func(req Request, rsp *Response)) {
+		router.Register("*", path, func(req Request) Response {
+			m.Lock()
+			hits[req.Method+" "+path]++
+			n := hits[req.Method+" "+path]
+			date := now.UTC().Format(http.TimeFormat)
+			m.Unlock()
+			rsp := req.Response(n)
+			rsp.Header.Set("Date", date)
+			f(req, &rsp)
+			return rsp
+		})
+	}
+	handle("/fresh", func(req Request, rsp *Response) {
+		rsp.Header.Set("Cache-Control", "max-age=60")
+	})
+	handle("/etag", func(req Request, rsp *Response) {
+		rsp.Header.Set("Cache-Control", "no-cache")
+		if req.Header.Get("If-None-Match") == `"v1"` {
+			*rsp = req.NotModified(`"v1"`)
+		}
+		rsp.Header.Set("ETag", `"v1"`)
+	})
+	handle("/vary", func(req Request, rsp *Response) {
+		rsp.Header.Set("Cache-Control", "max-age=60")
+		rsp.Header.Set("Vary", "Accept-Language")
+	})
+	handle("/private", func(req Request, rsp *Response) {
+		rsp.Header.Set("Cache-Control", "private, max-age=60")
+	})
+	handle("/nostore", func(req Request, rsp *Response) {
+		rsp.Header.Set("Cache-Control", "no-store")
+	})
+	s, err := Listen(router.Serve(), "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+	base := "http://" + s.Listener().Addr().String()
+
+	clock := func(o *cacheOptions) {
+		o.now = func() time.Time {
+			m.Lock()
+			defer m.Unlock()
+			return now
+		}
+	}
+	advance := func(d time.Duration) {
+		m.Lock()
+		defer m.Unlock()
+		now = now.Add(d)
+	}
+	client := NewClient(WithClientFilters(ClientCacheFilter(NewMemoryCacheStore(100), clock)))
+	get := func(path string, header ...string) (int, Response) {
+		req := NewRequest(context.Background(), "GET", base+path, nil)
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rsp := req.SendVia(client).Response()
+		require.NoError(t, rsp.Error)
+		var n int
+		require.NoError(t, rsp.Decode(&n))
+		return n, rsp
+	}
+
+	// Fresh responses are served from the cache until they go stale
+	n, _ := get("/fresh")
+	assert.Equal(t, 1, n)
+	advance(10 * time.Second)
+	n, rsp := get("/fresh")
+	assert.Equal(t, 1, n)
+	assert.Equal(t, "10", rsp.Header.Get("Age"))
+	n, _ = get("/fresh", "Cache-Control", "max-age=5")
+	assert.Equal(t, 2, n)
+	advance(61 * time.Second)
+	n, _ = get("/fresh")
+	assert.Equal(t, 3, n)
+
+	// Unsafe requests invalidate the cache
+	rsp = NewRequest(context.Background(), "POST", base+"/fresh", nil).SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+	n, _ = get("/fresh")
+	assert.Equal(t, 4, n)
+
+	// Responses are revalidated with their ETags
+	n, _ = get("/etag")
+	assert.Equal(t, 1, n)
+	n, rsp = get("/etag")
+	assert.Equal(t, 1, n)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Equal(t, 2, hits["GET /etag"])
+
+	// Vary
+	n, _ = get("/vary", "Accept-Language", "en")
+	assert.Equal(t, 1, n)
+	n, _ = get("/vary", "Accept-Language", "en")
+	assert.Equal(t, 1, n)
+	n, _ = get("/vary", "Accept-Language", "fr")
+	assert.Equal(t, 2, n)
+
+	// no-store, and private responses in a shared cache
+	get("/nostore")
+	n, _ = get("/nostore")
+	assert.Equal(t, 2, n)
+	get("/private")
+	n, _ = get("/private")
+	assert.Equal(t, 1, n)
+	shared := NewClient(WithClientFilters(ClientCacheFilter(NewMemoryCacheStore(100), CacheShared())))
+	rsp = NewRequest(context.Background(), "GET", base+"/private", nil).SendVia(shared).Response()
+	require.NoError(t, rsp.Error)
+	rsp = NewRequest(context.Background(), "GET", base+"/private", nil).SendVia(shared).Response()
+	require.NoError(t, rsp.Error)
+	require.NoError(t, rsp.Decode(&n))
+	assert.Equal(t, 3, n)
+
+	// only-if-cached
+	req := NewRequest(context.Background(), "GET", base+"/uncached", nil)
+	req.Header.Set("Cache-Control", "only-if-cached")
+	assert.Equal(t, http.StatusGatewayTimeout, req.SendVia(client).Response().StatusCode)
+}
+
+func TestMemoryCacheStore(t *testing.T) {
+	t.Parallel()
+	s := NewMemoryCacheStore(2)
+	s.Set("a", &CacheEntry{StatusCode: 1})
+	s.Set("b", &CacheEntry{StatusCode: 2})
+	_, ok := s.Get("a")
+	assert.True(t, ok)
+	s.Set("c", &CacheEntry{StatusCode: 3})
+	_, ok = s.Get("b")
+	assert.False(t, ok, "the least recently used entry should be evicted")
+	e, ok := s.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, e.StatusCode)
+	s.Delete("a")
+	_, ok = s.Get("a")
+	assert.False(t, ok)
+}
+
+func TestClientCacheFilterRange(t *testing.T) {
+	t.Parallel()
+	var hits int32
+	svc := Service(func(req Request) Response {
+		atomic.AddInt32(&hits, 1)
+		rsp := req.ServeRange(strings.NewReader("hello world"), 11, "text/plain", `"v1"`)
+		rsp.Header.Set("Cache-Control", "max-age=60")
+		return rsp
+	})
+	s, err := Listen(svc, "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+	client := NewClient(WithClientFilters(ClientCacheFilter(NewMemoryCacheStore(100))))
+	get := func(rangeHeader string) (int, string) {
+		req := NewRequest(context.Background(), "GET", "http://"+s.Listener().Addr().String()+"/", nil)
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		rsp := req.SendVia(client).Response()
+		require.NoError(t, rsp.Error)
+		b, err := rsp.BodyBytes(true)
+		require.NoError(t, err)
+		return rsp.StatusCode, string(b)
+	}
+
+	// Partial responses aren't stored, so don't stand in for the whole
+	status, body := get("bytes=0-4")
+	assert.Equal(t, http.StatusPartialContent, status)
+	assert.Equal(t, "hello", body)
+	status, body = get("")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "hello world", body)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&hits))
+
+	// Nor are whole responses served to range requests
+	status, body = get("bytes=6-")
+	assert.Equal(t, http.StatusPartialContent, status)
+	assert.Equal(t, "world", body)
+	assert.EqualValues(t, 3, atomic.LoadInt32(&hits))
+	_, body = get("")
+	assert.Equal(t, "hello world", body)
+	assert.EqualValues(t, 3, atomic.LoadInt32(&hits))
+}
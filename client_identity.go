@@ -0,0 +1,137 @@
+package libhttp
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/monzo/slog"
+)
+
+// WithClientCertificate makes the client present the certificate to servers which ask for one, for mutual TLS.
+func WithClientCertificate(cert tls.Certificate) ClientOption {
+	return WithClientCertificateProvider(func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		return &cert, nil
+	})
+}
+
+// WithClientCertificateProvider makes the client call the function for a certificate to present whenever a server
+// asks for one, for mutual TLS. This allows certificates to be obtained from elsewhere (such as a secrets manager or a
+// mesh's identity agent) and rotated without recreating the client. The function may be called concurrently.
+func WithClientCertificateProvider(f func(*tls.CertificateRequestInfo) (*tls.Certificate, error)) ClientOption {
+	return func(o *clientOptions) {
+		o.transportOpts = append(o.transportOpts, func(t *http.Transport) {
+			t.TLSClientConfig = transportTLSConfig(t)
+			t.TLSClientConfig.GetClientCertificate = f
+		})
+	}
+}
+
+// WithClientIdentity makes the client present the identity's current certificate to servers which ask for one.
+func WithClientIdentity(id *ClientIdentity) ClientOption {
+	return WithClientCertificateProvider(id.GetClientCertificate)
+}
+
+// A ClientIdentity is a client certificate and key loaded from PEM files, which can be reloaded at runtime (either
+// explicitly with Reload, or automatically by Watch) so that short-lived certificates can be rotated without
+// restarting clients. New connections present the certificate loaded last; existing connections are unaffected.
+//
+// To use an identity, pass it to NewClient with WithClientIdentity.
+type ClientIdentity struct {
+	certFile, keyFile string
+	m                 sync.RWMutex
+	cert              *tls.Certificate
+	modTimes          [2]time.Time // of the files when they were last loaded
+	sizes             [2]int64
+}
+
+// NewClientIdentity loads the PEM-encoded certificate (which may include intermediates) and key in the passed files.
+func NewClientIdentity(certFile, keyFile string) (*ClientIdentity, error) {
+	id := &ClientIdentity{
+		certFile: certFile,
+		keyFile:  keyFile}
+	if err := id.Reload(); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// Reload re-reads the identity's files. If they can't be loaded (or the key doesn't match the certificate), the
+// identity continues to use the previous certificate.
+func (id *ClientIdentity) Reload() error {
+	var modTimes [2]time.Time
+	var sizes [2]int64
+	for i, file := range []string{id.certFile, id.keyFile} {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTimes[i], sizes[i] = fi.ModTime(), fi.Size()
+	}
+	cert, err := tls.LoadX509KeyPair(id.certFile, id.keyFile)
+	if err != nil {
+		return err
+	}
+	id.m.Lock()
+	defer id.m.Unlock()
+	id.cert = &cert
+	id.modTimes = modTimes
+	id.sizes = sizes
+	return nil
+}
+
+// Watch polls the identity's files at the passed interval, reloading them whenever they change, until the context is
+// cancelled. It is typically run in its own goroutine. Failures to reload are logged.
+//
+// The certificate and key should be replaced together, and atomically (by renaming new files over them); a reload
+// which sees only one of them replaced fails, and is retried when the other is.
+func (id *ClientIdentity) Watch(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if !id.changed() {
+			continue
+		}
+		if err := id.Reload(); err != nil {
+			slog.Warn(ctx, "Couldn't reload client certificate from %s: %v", id.certFile, err)
+		} else {
+			slog.Info(ctx, "Reloaded client certificate from %s", id.certFile)
+		}
+	}
+}
+
+func (id *ClientIdentity) changed() bool {
+	id.m.RLock()
+	defer id.m.RUnlock()
+	for i, file := range []string{id.certFile, id.keyFile} {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return true // let Reload report the error
+		}
+		if !fi.ModTime().Equal(id.modTimes[i]) || fi.Size() != id.sizes[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// Certificate returns the current certificate.
+func (id *ClientIdentity) Certificate() *tls.Certificate {
+	id.m.RLock()
+	defer id.m.RUnlock()
+	return id.cert
+}
+
+// GetClientCertificate returns the current certificate. It has the signature of tls.Config.GetClientCertificate, to
+// be used there.
+func (id *ClientIdentity) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return id.Certificate(), nil
+}
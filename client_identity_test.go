@@ -0,0 +1,95 @@
+package libhttp
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientIdentity(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "libhttp-client-identity")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ca1, client1 := clientCA(t, "CA 1")
+	ca2, client2 := clientCA(t, "CA 2")
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, ioutil.WriteFile(caFile, append(ca1, ca2...), 0600))
+	pool, err := NewClientCAPool(caFile)
+	require.NoError(t, err)
+
+	cfg := TLSProfileIntermediate()
+	cfg.Certificates = []tls.Certificate{keypair(t, []string{"127.0.0.1"})}
+	srv, err := ListenTLS(Service(func(req Request) Response {
+		return req.Response(req.TLS.PeerCertificates[0].Subject.CommonName)
+	}), "127.0.0.1:0", "", "", cfg, WithClientCAs(pool))
+	require.NoError(t, err)
+	defer srv.Stop(context.Background())
+	url := "https://" + srv.Listener().Addr().String()
+
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	write := func(cert tls.Certificate) {
+		require.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: cert.Certificate[0]}), 0600))
+		require.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(cert.PrivateKey.(*rsa.PrivateKey))}), 0600))
+		later := time.Now().Add(time.Minute)
+		require.NoError(t, os.Chtimes(certFile, later, later))
+	}
+	get := func(opts ...ClientOption) (string, error) {
+		client := NewClient(append([]ClientOption{
+			WithRoundTripper(&http.Transport{}),
+			WithTLSConfig(&tls.Config{InsecureSkipVerify: true})}, opts...)...)
+		rsp := NewRequest(context.Background(), "GET", url, nil).SendVia(client).Response()
+		if rsp.Error != nil {
+			return "", rsp.Error
+		}
+		var cn string
+		err := rsp.Decode(&cn)
+		return cn, err
+	}
+
+	_, err = get()
+	assert.Error(t, err)
+	cn, err := get(WithClientCertificate(client1))
+	require.NoError(t, err)
+	assert.Equal(t, "CA 1 client", cn)
+
+	// From files, reloaded when they change
+	write(client1)
+	id, err := NewClientIdentity(certFile, keyFile)
+	require.NoError(t, err)
+	cn, err = get(WithClientIdentity(id))
+	require.NoError(t, err)
+	assert.Equal(t, "CA 1 client", cn)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go id.Watch(ctx, 10*time.Millisecond)
+	write(client2)
+	deadline := time.Now().Add(5 * time.Second)
+	for cn, _ := get(WithClientIdentity(id)); cn != "CA 2 client"; cn, _ = get(WithClientIdentity(id)) {
+		require.True(t, time.Now().Before(deadline), "watcher didn't reload the rotated certificate")
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// A broken file leaves the previous certificate in place
+	require.NoError(t, ioutil.WriteFile(keyFile, []byte("garbage"), 0600))
+	assert.Error(t, id.Reload())
+	cn, err = get(WithClientIdentity(id))
+	require.NoError(t, err)
+	assert.Equal(t, "CA 2 client", cn)
+}
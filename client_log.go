@@ -0,0 +1,226 @@
+package libhttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/monzo/slog"
+)
+
+// clientLogMaxBuffered is the largest response body which is buffered so that it can be logged.
+const clientLogMaxBuffered = 1 << 20
+
+var attemptsContextKey = NewContextKey("attempts", (*int32)(nil))
+
+// A ClientLogOption configures the logging of a client's requests; see WithRequestLogging.
+type ClientLogOption func(*clientLogOptions)
+
+type clientLogOptions struct {
+	logger     slog.Logger
+	bodyLimit  int
+	headers    bool
+	redactBody func(contentType string, body []byte) []byte
+	sampler    *LogSampler
+}
+
+// ClientLogBodies includes up to limit bytes of request and response bodies in the log. Response bodies are only
+// logged if their length is known and no more than 1MiB, as they must be buffered in memory to be logged; streamed
+// responses aren't. The values of sensitive fields in form and JSON bodies are redacted (see RegisterSensitiveParam),
+// as are those redacted by ClientLogRedactBody.
+func ClientLogBodies(limit int) ClientLogOption {
+	return func(o *clientLogOptions) {
+		o.bodyLimit = limit
+	}
+}
+
+// ClientLogHeaders includes requests' and responses' headers in the log, with the values of sensitive headers
+// redacted (see RegisterSensitiveHeader).
+func ClientLogHeaders() ClientLogOption {
+	return func(o *clientLogOptions) {
+		o.headers = true
+	}
+}
+
+// ClientLogRedactBody sets a function which redacts secrets from logged bodies, after the built-in redaction. It is
+// passed a copy of the body, with its content type.
+func ClientLogRedactBody(f func(contentType string, body []byte) []byte) ClientLogOption {
+	return func(o *clientLogOptions) {
+		o.redactBody = f
+	}
+}
+
+// ClientLogSampler samples the requests which are logged with s (see LogSampler). Requests which failed without a
+// response are sampled with status 0. By default, every request is logged.
+func ClientLogSampler(s *LogSampler) ClientLogOption {
+	return func(o *clientLogOptions) {
+		o.sampler = s
+	}
+}
+
+// ClientLogger sets the logger which requests are logged to; the default is slog's default logger.
+func ClientLogger(l slog.Logger) ClientLogOption {
+	return func(o *clientLogOptions) {
+		o.logger = l
+	}
+}
+
+// WithRequestLogging logs each request the client sends once it has completed: its method, URL, status, how long it
+// took, and how many attempts were made (when requests are retried or hedged). Successful requests are logged at info
+// level, and those with an error (which includes error statuses, if the client uses ErrorFilter) or a 5xx response
+// at warning level. Secrets are redacted from URLs (and headers and bodies, if they are logged) as they are by
+// Request.Dump. These are logs of the client's outbound requests, separate from any access logs of a server.
+func WithRequestLogging(opts ...ClientLogOption) ClientOption {
+	o := clientLogOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return func(co *clientOptions) {
+		co.logging = &o
+	}
+}
+
+// filter logs requests.
+func (o *clientLogOptions) filter(req Request, svc Service) Response {
+	attempts := new(int32)
+	start := time.Now()
+	var reqBody string
+	if o.bodyLimit > 0 {
+		b, text, err := req.redactedBody()
+		reqBody = o.body(req.Header.Get("Content-Type"), b, text, err)
+	}
+	rsp := svc(SetValue(req, attemptsContextKey, attempts))
+	elapsed := time.Since(start)
+	sampleStatus := 0
+	if rsp.Response != nil {
+		sampleStatus = rsp.StatusCode
+	}
+	if !o.sampler.Sample(sampleStatus) {
+		return rsp
+	}
+
+	meta := map[string]string{
+		"method":   req.Method,
+		"url":      req.redactedURL(),
+		"duration": elapsed.String(),
+		"attempts": strconv.Itoa(int(atomic.LoadInt32(attempts)))}
+	status := "no response"
+	if rsp.Response != nil {
+		status = strconv.Itoa(rsp.StatusCode)
+		meta["status"] = status
+	}
+	if rsp.Error != nil {
+		meta["error"] = rsp.Error.Error()
+	}
+	if o.headers {
+		meta["request_headers"] = redactedHeaders(req.Header)
+		if rsp.Response != nil {
+			meta["response_headers"] = redactedHeaders(rsp.Header)
+		}
+	}
+	if o.bodyLimit > 0 {
+		if reqBody != "" {
+			meta["request_body"] = reqBody
+		}
+		if rsp.Response != nil && rsp.Body != nil && rsp.ContentLength >= 0 &&
+			rsp.ContentLength <= clientLogMaxBuffered {
+			b, err := rsp.BodyBytes(false)
+			if body := o.body(rsp.Header.Get("Content-Type"), b, utf8.Valid(b), err); body != "" {
+				meta["response_body"] = body
+			}
+		}
+	}
+
+	sev := slog.InfoSeverity
+	if rsp.Error != nil || (rsp.Response != nil && rsp.StatusCode >= 500) {
+		sev = slog.WarnSeverity
+	}
+	msg := fmt.Sprintf("%s %s: %s in %v", req.Method, meta["url"], status, elapsed)
+	if n := atomic.LoadInt32(attempts); n > 1 {
+		msg += fmt.Sprintf(" (%d attempts)", n)
+	}
+	ev := slog.Eventf(sev, req, msg, meta)
+	if o.logger != nil {
+		o.logger.Log(ev)
+	} else {
+		slog.Log(ev)
+	}
+	return rsp
+}
+
+// body returns the body to log: redacted, and truncated to the limit.
+func (o *clientLogOptions) body(contentType string, b []byte, text bool, err error) string {
+	switch {
+	case err != nil:
+		return fmt.Sprintf("[error reading body: %v]", err)
+	case len(b) == 0:
+		return ""
+	case !text:
+		return fmt.Sprintf("[%d bytes of binary data]", len(b))
+	}
+	if lookupCodec(contentType) == (jsonCodec{}) {
+		b = redactJSON(b)
+	}
+	if o.redactBody != nil {
+		b = o.redactBody(contentType, append([]byte(nil), b...))
+	}
+	if len(b) > o.bodyLimit {
+		return fmt.Sprintf("%s [%d more bytes]", b[:o.bodyLimit], len(b)-o.bodyLimit)
+	}
+	return string(b)
+}
+
+// countAttempt counts a request being sent, for the log.
+func countAttempt(req Request, svc Service) Response {
+	if v, ok := Value(req, attemptsContextKey); ok && v != nil {
+		atomic.AddInt32(v.(*int32), 1)
+	}
+	return svc(req)
+}
+
+// redactJSON returns the JSON document with the values of sensitive fields replaced. Documents which can't be parsed
+// are returned unchanged.
+func redactJSON(b []byte) []byte {
+	var v interface{}
+	if json.Unmarshal(b, &v) != nil {
+		return b
+	}
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for k, child := range v {
+				if isSensitiveParam(k) {
+					v[k] = redacted
+				} else {
+					walk(child)
+				}
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(v)
+	redactedB, err := json.Marshal(v)
+	if err != nil {
+		return b
+	}
+	return redactedB
+}
+
+// redactedHeaders formats the headers on one line, with secrets redacted.
+func redactedHeaders(h map[string][]string) string {
+	var parts []string
+	for _, name := range sortedHeaderNames(h) {
+		for _, v := range h[name] {
+			parts = append(parts, name+": "+redactHeader(name, v))
+		}
+	}
+	return strings.Join(parts, "; ")
+}
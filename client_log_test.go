@@ -0,0 +1,139 @@
+package libhttp
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/monzo/slog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type captureLogger struct {
+	m   sync.Mutex
+	evs []slog.Event
+}
+
+func (l *captureLogger) Log(evs ...slog.Event) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.evs = append(l.evs, evs...)
+}
+
+func (l *captureLogger) Flush() error { return nil }
+
+func (l *captureLogger) events() []slog.Event {
+	l.m.Lock()
+	defer l.m.Unlock()
+	return append([]slog.Event(nil), l.evs...)
+}
+
+func TestWithRequestLogging(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	var m sync.Mutex
+	calls := 0
+	s, err := Listen(Service(func(req Request) Response {
+		if req.URL.Path == "/flaky" {
+			m.Lock()
+			calls++
+			n := calls
+			m.Unlock()
+			if n == 1 {
+				rsp := req.Response(nil)
+				rsp.StatusCode = http.StatusServiceUnavailable
+				return rsp
+			}
+		}
+		return req.Response(map[string]interface{}{
+			"id":    1,
+			"token": "rsp-secret"})
+	}), "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(ctx)
+	base := "http://" + s.Listener().Addr().String()
+
+	logger := &captureLogger{}
+	client := NewClient(
+		WithRequestLogging(ClientLogger(logger), ClientLogBodies(1024), ClientLogHeaders()),
+		WithClientFilters(RetryFilter(RetryBackoff(time.Millisecond, time.Millisecond))))
+
+	req := NewRequest(ctx, "POST", base+"/users?api_key=q-secret&x=1", map[string]interface{}{
+		"name":     "ada",
+		"password": "body-secret"})
+	req.Header.Set("Authorization", "Bearer hdr-secret")
+	req.Header.Set("Idempotency-Key", "k")
+	rsp := req.SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+	var v map[string]interface{}
+	require.NoError(t, rsp.Decode(&v), "the response body can still be read")
+	assert.Equal(t, "rsp-secret", v["token"])
+
+	require.NoError(t, NewRequest(ctx, "GET", base+"/flaky", nil).SendVia(client).Response().Error)
+	require.Error(t, NewRequest(ctx, "GET", "http://localhost:1/", nil).SendVia(client).Response().Error,
+		"connection refused")
+
+	evs := logger.events()
+	require.Len(t, evs, 3)
+	ev := evs[0]
+	assert.Equal(t, slog.InfoSeverity, ev.Severity)
+	assert.Equal(t, "POST", ev.Metadata["method"])
+	assert.Equal(t, "200", ev.Metadata["status"])
+	assert.Equal(t, "1", ev.Metadata["attempts"])
+	u, err := url.Parse(ev.Metadata["url"])
+	require.NoError(t, err)
+	assert.Equal(t, "REDACTED", u.Query().Get("api_key"))
+	assert.Contains(t, ev.Metadata["request_headers"], "Authorization: Bearer REDACTED")
+	assert.Contains(t, ev.Metadata["request_body"], `"password":"REDACTED"`)
+	assert.Contains(t, ev.Metadata["request_body"], `"name":"ada"`)
+	assert.Contains(t, ev.Metadata["response_body"], `"token":"REDACTED"`)
+	for _, secret := range []string{"q-secret", "hdr-secret", "body-secret", "rsp-secret"} {
+		assert.NotContains(t, ev.String(), secret)
+	}
+
+	assert.Equal(t, "2", evs[1].Metadata["attempts"])
+	assert.Contains(t, evs[1].Message, "(2 attempts)")
+	assert.Equal(t, slog.InfoSeverity, evs[1].Severity)
+
+	assert.Equal(t, slog.WarnSeverity, evs[2].Severity)
+	assert.Contains(t, evs[2].Message, "no response")
+	assert.NotEmpty(t, evs[2].Metadata["error"])
+}
+
+func TestClientLogRedactBody(t *testing.T) {
+	t.Parallel()
+	o := clientLogOptions{
+		bodyLimit: 10,
+		redactBody: func(contentType string, b []byte) []byte {
+			return []byte("<" + contentType + ">" + string(b))
+		}}
+	assert.Equal(t, "<text/plai [7 more bytes]", o.body("text/plain", []byte("hello"), true, nil))
+	assert.Equal(t, "[3 bytes of binary data]", o.body("", []byte{0xff, 0xfe, 0}, false, nil))
+	assert.Equal(t, "", o.body("", nil, true, nil))
+}
+
+func TestClientLogSampler(t *testing.T) {
+	t.Parallel()
+	logger := &captureLogger{}
+	svc := Service(func(req Request) Response {
+		rsp := req.Response(nil)
+		if req.URL.Path == "/error" {
+			rsp.StatusCode = http.StatusBadGateway
+		}
+		return rsp
+	})
+	o := clientLogOptions{
+		logger:  logger,
+		sampler: NewLogSampler(SampleRate(0), SampleStatusClass(5, 1))}
+	ctx := context.Background()
+	for _, path := range []string{"/ok", "/error", "/ok"} {
+		o.filter(NewRequest(ctx, "GET", "http://example.com"+path, nil), svc)
+	}
+	evs := logger.events()
+	require.Len(t, evs, 1)
+	assert.Equal(t, "502", evs[0].Metadata["status"])
+}
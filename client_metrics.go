@@ -0,0 +1,163 @@
+package libhttp
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// ClientLatencyBuckets are the upper bounds of the latency histogram buckets in TargetStats.
+var ClientLatencyBuckets = []time.Duration{
+	5 * time.Millisecond, 10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond, time.Second, 2500 * time.Millisecond,
+	5 * time.Second, 10 * time.Second}
+
+// WithClientMetrics records the latency and outcome of each request the client sends in m, by target. Each attempt
+// is recorded separately (if the client retries requests, say), and latency is measured until the response's headers
+// arrive, not its body.
+//
+// Deprecated: WithClientMetricsRegistry records the same metrics, by target, in a libhttpmetrics.Registry.
+func WithClientMetrics(m *ClientMetrics) ClientOption {
+	return func(o *clientOptions) {
+		o.metrics = m
+	}
+}
+
+// ClientMetrics records metrics about the requests sent by clients (see WithClientMetrics), from which a snapshot of
+// each target's TargetStats can be taken at any time, for example to export to a monitoring system. The zero value is
+// ready to use, and it is safe for concurrent use.
+//
+// Deprecated: WithClientMetricsRegistry records the same metrics, by target, in a libhttpmetrics.Registry.
+type ClientMetrics struct {
+	// Target returns the name a request is recorded under. By default, it is the host (and port, if any) of the
+	// request's URL. It must be set before the metrics are used.
+	Target func(Request) string
+
+	m       sync.Mutex
+	targets map[string]*TargetStats
+}
+
+// TargetStats is a snapshot of the metrics for requests to a target. Counts and durations are totals since the
+// ClientMetrics was created. Buckets is a histogram of the requests' latencies: Buckets[i] counts those which took up
+// to ClientLatencyBuckets[i] (and more than the bucket before), and the last bucket those which took longer than all
+// of them.
+type TargetStats struct {
+	Requests   int64         // requests sent
+	Errors     int64         // requests which failed without a response, or with a 5xx status
+	Statuses   [6]int64      // responses by status class: Statuses[2] counts 2xx responses, and so on
+	Latency    time.Duration // total time until the responses' headers arrived
+	MaxLatency time.Duration
+	Buckets    []int64
+}
+
+// ErrorRate returns the proportion of requests which failed. It is zero if no requests have been sent.
+func (s TargetStats) ErrorRate() float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return float64(s.Errors) / float64(s.Requests)
+}
+
+// MeanLatency returns the mean latency of the requests. It is zero if no requests have been sent.
+func (s TargetStats) MeanLatency() time.Duration {
+	if s.Requests == 0 {
+		return 0
+	}
+	return s.Latency / time.Duration(s.Requests)
+}
+
+// Quantile estimates the qth quantile (0.99 for the 99th percentile, say) of the requests' latencies, as the upper
+// bound of the histogram bucket it falls in; for the last bucket, that is MaxLatency. It is zero if no requests have
+// been sent.
+func (s TargetStats) Quantile(q float64) time.Duration {
+	if s.Requests == 0 {
+		return 0
+	}
+	rank := int64(q * float64(s.Requests))
+	if rank >= s.Requests {
+		rank = s.Requests - 1
+	}
+	var n int64
+	for i, c := range s.Buckets {
+		n += c
+		if n > rank {
+			if i < len(ClientLatencyBuckets) && ClientLatencyBuckets[i] < s.MaxLatency {
+				return ClientLatencyBuckets[i]
+			}
+			return s.MaxLatency
+		}
+	}
+	return s.MaxLatency
+}
+
+// Stats returns a snapshot of the metrics of each target.
+func (m *ClientMetrics) Stats() map[string]TargetStats {
+	m.m.Lock()
+	defer m.m.Unlock()
+	stats := make(map[string]TargetStats, len(m.targets))
+	for t, s := range m.targets {
+		snapshot := *s
+		snapshot.Buckets = append([]int64(nil), s.Buckets...)
+		stats[t] = snapshot
+	}
+	return stats
+}
+
+// Targets returns the names of the targets which requests have been sent to, in order.
+func (m *ClientMetrics) Targets() []string {
+	m.m.Lock()
+	defer m.m.Unlock()
+	targets := make([]string, 0, len(m.targets))
+	for t := range m.targets {
+		targets = append(targets, t)
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+// filter records metrics for requests.
+func (m *ClientMetrics) filter(req Request, svc Service) Response {
+	target := ""
+	switch {
+	case m.Target != nil:
+		target = m.Target(req)
+	case req.URL != nil:
+		target = req.URL.Host
+	}
+	start := time.Now()
+	rsp := svc(req)
+	m.record(target, rsp, time.Since(start))
+	return rsp
+}
+
+func (m *ClientMetrics) record(target string, rsp Response, latency time.Duration) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	if m.targets == nil {
+		m.targets = map[string]*TargetStats{}
+	}
+	s, ok := m.targets[target]
+	if !ok {
+		s = &TargetStats{
+			Buckets: make([]int64, len(ClientLatencyBuckets)+1)}
+		m.targets[target] = s
+	}
+	s.Requests++
+	if rsp.Response == nil {
+		s.Errors++
+	} else {
+		class := rsp.StatusCode / 100
+		if class >= 1 && class <= 5 {
+			s.Statuses[class]++
+		}
+		if class == 5 {
+			s.Errors++
+		}
+	}
+	s.Latency += latency
+	if latency > s.MaxLatency {
+		s.MaxLatency = latency
+	}
+	i := sort.Search(len(ClientLatencyBuckets), func(i int) bool { return latency <= ClientLatencyBuckets[i] })
+	s.Buckets[i]++
+}
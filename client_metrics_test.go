@@ -0,0 +1,72 @@
+package libhttp
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithClientMetrics(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	s, err := Listen(Service(func(req Request) Response {
+		rsp := req.Response(nil)
+		switch req.URL.Path {
+		case "/slow":
+			time.Sleep(30 * time.Millisecond)
+		case "/missing":
+			rsp.StatusCode = http.StatusNotFound
+		case "/broken":
+			rsp.StatusCode = http.StatusBadGateway
+		}
+		return rsp
+	}), "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(ctx)
+	addr := s.Listener().Addr().String()
+
+	m := &ClientMetrics{}
+	client := NewClient(WithClientMetrics(m))
+	for _, p := range []string{"/", "/", "/slow", "/missing", "/broken"} {
+		NewRequest(ctx, "GET", "http://"+addr+p, nil).SendVia(client).Response()
+	}
+	NewRequest(ctx, "GET", "http://localhost:1/", nil).SendVia(client).Response()
+
+	assert.Equal(t, []string{addr, "localhost:1"}, m.Targets())
+	stats := m.Stats()
+	st := stats[addr]
+	assert.Equal(t, int64(5), st.Requests)
+	assert.Equal(t, int64(1), st.Errors)
+	assert.Equal(t, [6]int64{0, 0, 3, 0, 1, 1}, st.Statuses)
+	assert.InDelta(t, 0.2, st.ErrorRate(), 0.0001)
+	assert.True(t, st.MaxLatency >= 30*time.Millisecond)
+	assert.True(t, st.Latency >= st.MaxLatency)
+	assert.True(t, st.Quantile(0.99) >= 30*time.Millisecond)
+	assert.True(t, st.Quantile(0.1) < 30*time.Millisecond)
+	var n int64
+	for _, c := range st.Buckets {
+		n += c
+	}
+	assert.Equal(t, st.Requests, n)
+
+	assert.Equal(t, int64(1), stats["localhost:1"].Requests)
+	assert.Equal(t, int64(1), stats["localhost:1"].Errors)
+}
+
+func TestClientMetricsTarget(t *testing.T) {
+	t.Parallel()
+	m := &ClientMetrics{
+		Target: func(req Request) string { return req.Header.Get("X-Service") }}
+	svc := Service(func(req Request) Response {
+		return req.Response(nil)
+	}).Filter(m.filter)
+	req := NewRequest(context.Background(), "GET", "http://example.com/", nil)
+	req.Header.Set("X-Service", "users")
+	svc(req)
+	assert.Equal(t, []string{"users"}, m.Targets())
+	assert.Equal(t, time.Duration(0), TargetStats{}.Quantile(0.5))
+}
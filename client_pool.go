@@ -0,0 +1,254 @@
+package libhttp
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/4thel00z/libhttp/libhttpmetrics"
+)
+
+// WithMaxIdleConns limits the number of idle connections the client keeps open, across all hosts. Zero means no
+// limit. Like the other transport options, it requires the client's RoundTripper to be an *http.Transport.
+func WithMaxIdleConns(n int) ClientOption {
+	return func(o *clientOptions) {
+		o.transportOpts = append(o.transportOpts, func(t *http.Transport) {
+			t.MaxIdleConns = n
+		})
+	}
+}
+
+// WithMaxIdleConnsPerHost limits the number of idle connections the client keeps open to each host. If it is zero,
+// net/http's default of 2 is used, which is often too few for busy clients.
+func WithMaxIdleConnsPerHost(n int) ClientOption {
+	return func(o *clientOptions) {
+		o.transportOpts = append(o.transportOpts, func(t *http.Transport) {
+			t.MaxIdleConnsPerHost = n
+		})
+	}
+}
+
+// WithMaxConnsPerHost limits the number of connections (idle, in use, or being established) the client has to each
+// host; requests wait for a connection once the limit is reached. Zero means no limit.
+func WithMaxConnsPerHost(n int) ClientOption {
+	return func(o *clientOptions) {
+		o.transportOpts = append(o.transportOpts, func(t *http.Transport) {
+			t.MaxConnsPerHost = n
+		})
+	}
+}
+
+// WithIdleConnTimeout sets how long idle connections are kept open before they are closed. Zero means they are kept
+// open indefinitely.
+func WithIdleConnTimeout(d time.Duration) ClientOption {
+	return func(o *clientOptions) {
+		o.transportOpts = append(o.transportOpts, func(t *http.Transport) {
+			t.IdleConnTimeout = d
+		})
+	}
+}
+
+// WithPoolMetrics makes the client record metrics about its use of connections in m. A PoolMetrics may be shared by
+// several clients, to record their combined metrics.
+//
+// Deprecated: WithClientMetricsRegistry records the same metrics, by target, in a libhttpmetrics.Registry.
+func WithPoolMetrics(m *PoolMetrics) ClientOption {
+	return func(o *clientOptions) {
+		o.poolMetrics = m
+	}
+}
+
+// PoolMetrics records metrics about a client's connection pool (see WithPoolMetrics), from which a PoolStats snapshot
+// can be taken at any time, for example to export to a monitoring system. The zero value is ready to use, and it is
+// safe for concurrent use.
+//
+// Deprecated: WithClientMetricsRegistry records the same metrics, by target, in a libhttpmetrics.Registry.
+type PoolMetrics struct {
+	conns, reused, wasIdle, dials, dialErrors, dnsLookups, tlsHandshakes int64
+	dialNanos, dnsNanos, tlsNanos, idleNanos                             int64
+}
+
+// PoolStats is a snapshot of PoolMetrics. Counts and durations are totals since the PoolMetrics was created.
+type PoolStats struct {
+	Conns         int64         // connections obtained for requests, whether new or reused
+	Reused        int64         // connections obtained which had been used before
+	Idle          int64         // reused connections which were taken from the idle pool
+	IdleTime      time.Duration // total time reused connections had been idle for
+	Dials         int64         // connection attempts (which may be several per connection, eg. for IPv4 and IPv6)
+	DialErrors    int64         // connection attempts which failed
+	DialTime      time.Duration // total time spent connecting
+	DNSLookups    int64         // host name lookups
+	DNSTime       time.Duration // total time spent looking up host names
+	TLSHandshakes int64         // TLS handshakes performed
+	TLSTime       time.Duration // total time spent performing TLS handshakes
+}
+
+// ReuseRatio returns the proportion of connections obtained which were reused, rather than established for the
+// request. It is zero if no connections have been obtained.
+func (s PoolStats) ReuseRatio() float64 {
+	if s.Conns == 0 {
+		return 0
+	}
+	return float64(s.Reused) / float64(s.Conns)
+}
+
+// Stats returns a snapshot of the metrics. As the counters are read individually, a snapshot taken while requests are
+// in flight may be slightly inconsistent (more Reused than Conns, say).
+func (m *PoolMetrics) Stats() PoolStats {
+	return PoolStats{
+		Conns:         atomic.LoadInt64(&m.conns),
+		Reused:        atomic.LoadInt64(&m.reused),
+		Idle:          atomic.LoadInt64(&m.wasIdle),
+		IdleTime:      time.Duration(atomic.LoadInt64(&m.idleNanos)),
+		Dials:         atomic.LoadInt64(&m.dials),
+		DialErrors:    atomic.LoadInt64(&m.dialErrors),
+		DialTime:      time.Duration(atomic.LoadInt64(&m.dialNanos)),
+		DNSLookups:    atomic.LoadInt64(&m.dnsLookups),
+		DNSTime:       time.Duration(atomic.LoadInt64(&m.dnsNanos)),
+		TLSHandshakes: atomic.LoadInt64(&m.tlsHandshakes),
+		TLSTime:       time.Duration(atomic.LoadInt64(&m.tlsNanos))}
+}
+
+func (m *PoolMetrics) gotConn(_ string, info httptrace.GotConnInfo) {
+	atomic.AddInt64(&m.conns, 1)
+	if info.Reused {
+		atomic.AddInt64(&m.reused, 1)
+	}
+	if info.WasIdle {
+		atomic.AddInt64(&m.wasIdle, 1)
+		atomic.AddInt64(&m.idleNanos, int64(info.IdleTime))
+	}
+}
+
+func (m *PoolMetrics) dnsDone(_ string, d time.Duration) {
+	atomic.AddInt64(&m.dnsLookups, 1)
+	atomic.AddInt64(&m.dnsNanos, int64(d))
+}
+
+func (m *PoolMetrics) dialDone(_ string, d time.Duration, err error) {
+	atomic.AddInt64(&m.dials, 1)
+	atomic.AddInt64(&m.dialNanos, int64(d))
+	if err != nil {
+		atomic.AddInt64(&m.dialErrors, 1)
+	}
+}
+
+func (m *PoolMetrics) tlsDone(_ string, d time.Duration) {
+	atomic.AddInt64(&m.tlsHandshakes, 1)
+	atomic.AddInt64(&m.tlsNanos, int64(d))
+}
+
+// poolRegistry records connection pool metrics in a Registry, by target (see WithClientMetricsRegistry).
+type poolRegistry struct {
+	conns                        libhttpmetrics.Counter
+	idle, dials, dns, handshakes libhttpmetrics.Histogram
+	dialErrors                   libhttpmetrics.Counter
+}
+
+func newPoolRegistry(r libhttpmetrics.Registry) *poolRegistry {
+	return &poolRegistry{
+		conns: r.Counter("http_client_connections_total", "Connections obtained for requests, new or reused.",
+			"target", "reused"),
+		idle: r.Histogram("http_client_connection_idle_seconds", "Time reused connections had been idle for.", nil,
+			"target"),
+		dials:      r.Histogram("http_client_dial_duration_seconds", "Time taken by connection attempts.", nil, "target"),
+		dialErrors: r.Counter("http_client_dial_errors_total", "Connection attempts which failed.", "target"),
+		dns:        r.Histogram("http_client_dns_duration_seconds", "Time taken to look up host names.", nil, "target"),
+		handshakes: r.Histogram("http_client_tls_handshake_duration_seconds", "Time taken by TLS handshakes.", nil,
+			"target")}
+}
+
+func (p *poolRegistry) gotConn(target string, info httptrace.GotConnInfo) {
+	p.conns.Add(1, target, strconv.FormatBool(info.Reused))
+	if info.WasIdle {
+		p.idle.Observe(info.IdleTime.Seconds(), target)
+	}
+}
+
+func (p *poolRegistry) dnsDone(target string, d time.Duration) {
+	p.dns.Observe(d.Seconds(), target)
+}
+
+func (p *poolRegistry) dialDone(target string, d time.Duration, err error) {
+	p.dials.Observe(d.Seconds(), target)
+	if err != nil {
+		p.dialErrors.Add(1, target)
+	}
+}
+
+func (p *poolRegistry) tlsDone(target string, d time.Duration) {
+	p.handshakes.Observe(d.Seconds(), target)
+}
+
+// A poolObserver is told how the connections for requests to a target (the host and port of their URLs) were
+// obtained.
+type poolObserver interface {
+	gotConn(target string, info httptrace.GotConnInfo)
+	dnsDone(target string, d time.Duration)
+	dialDone(target string, d time.Duration, err error)
+	tlsDone(target string, d time.Duration)
+}
+
+// poolMetricsFilter reports how connections are obtained for requests to o, with an httptrace.ClientTrace.
+func poolMetricsFilter(o poolObserver) Filter {
+	return func(req Request, svc Service) Response {
+		target := ""
+		if req.URL != nil {
+			target = req.URL.Host
+		}
+		var (
+			mu                 sync.Mutex
+			dialStart          = map[string]time.Time{} // several addresses may be dialled at once
+			dnsStart, tlsStart time.Time
+		)
+		trace := &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				o.gotConn(target, info)
+			},
+			DNSStart: func(httptrace.DNSStartInfo) {
+				mu.Lock()
+				dnsStart = time.Now()
+				mu.Unlock()
+			},
+			DNSDone: func(httptrace.DNSDoneInfo) {
+				mu.Lock()
+				start := dnsStart
+				mu.Unlock()
+				o.dnsDone(target, time.Since(start))
+			},
+			ConnectStart: func(network, addr string) {
+				mu.Lock()
+				dialStart[network+" "+addr] = time.Now()
+				mu.Unlock()
+			},
+			ConnectDone: func(network, addr string, err error) {
+				mu.Lock()
+				start := dialStart[network+" "+addr]
+				mu.Unlock()
+				o.dialDone(target, time.Since(start), err)
+			},
+			TLSHandshakeStart: func() {
+				mu.Lock()
+				tlsStart = time.Now()
+				mu.Unlock()
+			},
+			TLSHandshakeDone: func(tls.ConnectionState, error) {
+				mu.Lock()
+				start := tlsStart
+				mu.Unlock()
+				o.tlsDone(target, time.Since(start))
+			}}
+
+		ctx := req.unwrappedContext()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		req.Context = httptrace.WithClientTrace(ctx, trace)
+		return svc(req)
+	}
+}
@@ -0,0 +1,90 @@
+package libhttp
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/4thel00z/libhttp/libhttpmetrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientPoolMetrics(t *testing.T) {
+	t.Parallel()
+	s, err := Listen(Service(func(req Request) Response {
+		return req.Response("ok")
+	}), "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+	// Use a host name, so it's looked up
+	url := "http://" + strings.Replace(s.Listener().Addr().String(), "127.0.0.1", "localhost", 1)
+
+	m := &PoolMetrics{}
+	transport := &http.Transport{}
+	client := NewClient(
+		WithRoundTripper(transport),
+		WithMaxIdleConns(10),
+		WithMaxIdleConnsPerHost(5),
+		WithMaxConnsPerHost(1),
+		WithIdleConnTimeout(time.Minute),
+		WithPoolMetrics(m))
+	for i := 0; i < 3; i++ {
+		rsp := NewRequest(context.Background(), "GET", url, nil).SendVia(client).Response()
+		require.NoError(t, rsp.Error)
+		_, err := rsp.BodyBytes(true)
+		require.NoError(t, err)
+	}
+
+	stats := m.Stats()
+	assert.EqualValues(t, 3, stats.Conns)
+	assert.EqualValues(t, 2, stats.Reused)
+	assert.EqualValues(t, 2, stats.Idle)
+	assert.InDelta(t, 2.0/3.0, stats.ReuseRatio(), 0.001)
+	assert.True(t, stats.Dials >= 1, "expected dials: %+v", stats)
+	assert.True(t, stats.DialTime > 0, "expected dial time: %+v", stats)
+	assert.EqualValues(t, 1, stats.DNSLookups)
+	assert.EqualValues(t, 0, stats.TLSHandshakes)
+	assert.Zero(t, PoolStats{}.ReuseRatio())
+
+	// The passed transport is copied, rather than modified
+	assert.Zero(t, transport.MaxConnsPerHost)
+	assert.Zero(t, transport.IdleConnTimeout)
+}
+
+func TestClientPoolMetricsRegistry(t *testing.T) {
+	t.Parallel()
+	s, err := Listen(Service(func(req Request) Response {
+		return req.Response("ok")
+	}), "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+	target := strings.Replace(s.Listener().Addr().String(), "127.0.0.1", "localhost", 1)
+
+	reg := libhttpmetrics.NewPrometheus()
+	client := NewClient(WithRoundTripper(&http.Transport{}), WithClientMetricsRegistry(reg))
+	for i := 0; i < 3; i++ {
+		rsp := NewRequest(context.Background(), "GET", "http://"+target, nil).SendVia(client).Response()
+		require.NoError(t, rsp.Error)
+		_, err := rsp.BodyBytes(true)
+		require.NoError(t, err)
+	}
+
+	var buf bytes.Buffer
+	_, err = reg.WriteTo(&buf)
+	require.NoError(t, err)
+	out := buf.String()
+	for _, line := range []string{
+		`http_client_connections_total{target="` + target + `",reused="false"} 1`,
+		`http_client_connections_total{target="` + target + `",reused="true"} 2`,
+		`http_client_connection_idle_seconds_count{target="` + target + `"} 2`,
+		`http_client_dns_duration_seconds_count{target="` + target + `"} 1`,
+	} {
+		assert.Contains(t, out, line+"\n")
+	}
+	assert.Contains(t, out, `http_client_dial_duration_seconds_count{target="`+target+`"}`)
+	assert.NotContains(t, out, `http_client_tls_handshake_duration_seconds_count`)
+}
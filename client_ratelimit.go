@@ -0,0 +1,137 @@
+package libhttp
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/monzo/terrors"
+)
+
+// A RateLimitOption configures a ClientRateLimitFilter.
+type RateLimitOption func(*clientRateLimiter)
+
+// rateLimit is a rate, in requests per second, and the burst of requests allowed above it.
+type rateLimit struct {
+	rate  float64
+	burst int
+}
+
+// RateLimitGlobal limits the rate of all requests through the filter to rate requests a second, allowing bursts of up
+// to burst requests.
+func RateLimitGlobal(rate float64, burst int) RateLimitOption {
+	return func(l *clientRateLimiter) {
+		l.global = newTokenBucket(rate, burst)
+	}
+}
+
+// RateLimitPerHost limits the rate of requests to each host (as in requests' URLs, including any port) to rate
+// requests a second, allowing bursts of up to burst requests.
+func RateLimitPerHost(rate float64, burst int) RateLimitOption {
+	return func(l *clientRateLimiter) {
+		l.perHost = &rateLimit{
+			rate:  rate,
+			burst: burst}
+	}
+}
+
+// RateLimitHost sets the limit for requests to a particular host, overriding RateLimitPerHost (to match an API's
+// quota, say).
+func RateLimitHost(host string, rate float64, burst int) RateLimitOption {
+	return func(l *clientRateLimiter) {
+		l.hostLimits[host] = rateLimit{
+			rate:  rate,
+			burst: burst}
+	}
+}
+
+// RateLimitMaxWait sets the longest a request waits to be sent. Requests which would have to wait longer fail
+// immediately with an internal_service.rate_limited error, rather than queueing. By default, requests wait as long as
+// they need to (or until their contexts are done).
+func RateLimitMaxWait(d time.Duration) RateLimitOption {
+	return func(l *clientRateLimiter) {
+		l.maxWait = d
+	}
+}
+
+type clientRateLimiter struct {
+	global     *tokenBucket
+	perHost    *rateLimit
+	hostLimits map[string]rateLimit
+	maxWait    time.Duration
+
+	m     sync.Mutex
+	hosts map[string]*tokenBucket
+}
+
+// ClientRateLimitFilter returns a client Filter which limits the rate at which requests are sent, so that a client
+// (such as a batch job) doesn't overwhelm the APIs it calls, or exceed their quotas. Limits can apply to all requests,
+// to each host, or to particular hosts; a request must be within all of the limits which apply to it. Requests beyond
+// a limit are delayed until they are within it:
+//
+//  client := libhttp.NewClient(libhttp.WithClientFilters(libhttp.ClientRateLimitFilter(
+//      libhttp.RateLimitPerHost(50, 10),
+//      libhttp.RateLimitHost("api.partner.com", 5, 1))))
+//
+// A request whose context is done while it waits fails with the context's error.
+func ClientRateLimitFilter(opts ...RateLimitOption) Filter {
+	l := &clientRateLimiter{
+		hostLimits: map[string]rateLimit{},
+		hosts:      map[string]*tokenBucket{}}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return func(req Request, svc Service) Response {
+		buckets := make([]*tokenBucket, 0, 2)
+		if l.global != nil {
+			buckets = append(buckets, l.global)
+		}
+		if req.URL != nil {
+			if b := l.hostBucket(req.URL.Host); b != nil {
+				buckets = append(buckets, b)
+			}
+		}
+		var wait time.Duration
+		for _, b := range buckets {
+			if d := b.reserve(1); d > wait {
+				wait = d
+			}
+		}
+		if wait > 0 && l.maxWait > 0 && wait > l.maxWait {
+			for _, b := range buckets {
+				b.unreserve(1)
+			}
+			return Response{
+				Request: &req,
+				Error: terrors.InternalService("rate_limited", fmt.Sprintf("Rate limit exceeded; request would wait %v",
+					wait), nil)}
+		}
+		if wait > 0 {
+			if err := sleepContext(req, wait); err != nil {
+				return Response{
+					Request: &req,
+					Error:   terrors.Wrap(err, nil)}
+			}
+		}
+		return svc(req)
+	}
+}
+
+// hostBucket returns the bucket for the host, or nil if requests to it aren't limited.
+func (l *clientRateLimiter) hostBucket(host string) *tokenBucket {
+	limit, ok := l.hostLimits[host]
+	if !ok {
+		if l.perHost == nil {
+			return nil
+		}
+		limit = *l.perHost
+	}
+	l.m.Lock()
+	defer l.m.Unlock()
+	b := l.hosts[host]
+	if b == nil {
+		b = newTokenBucket(limit.rate, limit.burst)
+		l.hosts[host] = b
+	}
+	return b
+}
@@ -0,0 +1,65 @@
+package libhttp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientRateLimitFilter(t *testing.T) {
+	t.Parallel()
+	svc := Service(func(req Request) Response {
+		return req.Response(nil)
+	}).Filter(ClientRateLimitFilter(
+		RateLimitPerHost(20, 2),
+		RateLimitHost("slow", 10, 1)))
+	send := func(host string) time.Duration {
+		start := time.Now()
+		require.NoError(t, svc(NewRequest(context.Background(), "GET", "http://"+host+"/", nil)).Error)
+		return time.Since(start)
+	}
+
+	// The burst is sent immediately, then requests are spaced out
+	var elapsed time.Duration
+	for i := 0; i < 6; i++ {
+		elapsed += send("a")
+	}
+	assert.True(t, elapsed >= 180*time.Millisecond, "6 requests took %v", elapsed)
+	assert.True(t, elapsed < time.Second, "6 requests took %v", elapsed)
+	// Each host has its own limit
+	assert.True(t, send("b") < 20*time.Millisecond)
+	send("slow")
+	assert.True(t, send("slow") >= 80*time.Millisecond)
+}
+
+func TestClientRateLimitFilterGlobal(t *testing.T) {
+	t.Parallel()
+	svc := Service(func(req Request) Response {
+		return req.Response(nil)
+	}).Filter(ClientRateLimitFilter(
+		RateLimitGlobal(10, 1),
+		RateLimitMaxWait(50*time.Millisecond)))
+
+	require.NoError(t, svc(NewRequest(context.Background(), "GET", "http://a/", nil)).Error)
+	rsp := svc(NewRequest(context.Background(), "GET", "http://b/", nil))
+	assert.True(t, terrors.PrefixMatches(rsp.Error, terrors.ErrInternalService, "rate_limited"))
+
+	// A rejected request doesn't use up the limit
+	time.Sleep(110 * time.Millisecond)
+	require.NoError(t, svc(NewRequest(context.Background(), "GET", "http://b/", nil)).Error)
+
+	// Waiting requests give up when their contexts are done
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	svc = Service(func(req Request) Response {
+		return req.Response(nil)
+	}).Filter(ClientRateLimitFilter(RateLimitGlobal(1, 1)))
+	require.NoError(t, svc(NewRequest(ctx, "GET", "http://a/", nil)).Error)
+	start := time.Now()
+	assert.Error(t, svc(NewRequest(ctx, "GET", "http://a/", nil)).Error)
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+}
@@ -0,0 +1,162 @@
+package libhttp
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/monzo/terrors"
+)
+
+// redirectMaxBody is the largest request body which is kept to be sent again on 307 and 308 redirects.
+const redirectMaxBody = 1 << 20
+
+// A RedirectOption configures how redirects are followed; see RedirectFilter.
+type RedirectOption func(*redirectOptions)
+
+type redirectOptions struct {
+	maxHops        int
+	preserveMethod bool
+	stripHeaders   []string
+	check          func(req Request, via []Request) bool
+}
+
+// RedirectMaxHops sets the most redirects followed for a request; the default is 10. If a request is redirected more
+// times than that, the last redirect response is returned with a too_many_redirects error.
+func RedirectMaxHops(n int) RedirectOption {
+	return func(o *redirectOptions) {
+		o.maxHops = n
+	}
+}
+
+// RedirectPreserveMethod sets whether 307 (Temporary Redirect) and 308 (Permanent Redirect) responses are followed
+// with the original method and body, as RFC 7231 requires; this is the default. If not, they are followed like 301
+// and 302 responses, with other methods than GET and HEAD changed to GET without a body. Requests whose bodies are
+// too large to keep in memory (more than 1MiB) aren't redirected with them: the redirect response is returned.
+func RedirectPreserveMethod(preserve bool) RedirectOption {
+	return func(o *redirectOptions) {
+		o.preserveMethod = preserve
+	}
+}
+
+// RedirectStripHeaders sets the headers removed from requests redirected to a different origin (scheme, host and
+// port), so that credentials meant for one service aren't sent to another. It replaces the default of Authorization,
+// Proxy-Authorization and Cookie.
+func RedirectStripHeaders(names ...string) RedirectOption {
+	return func(o *redirectOptions) {
+		o.stripHeaders = names
+	}
+}
+
+// RedirectCheck sets a function which decides whether to follow a redirect, given the request it would send and
+// those sent already (the original request first). If it returns false, the redirect response is returned.
+func RedirectCheck(f func(req Request, via []Request) bool) RedirectOption {
+	return func(o *redirectOptions) {
+		o.check = f
+	}
+}
+
+// WithRedirects makes the client follow redirects, configured by the passed options. Without it, redirect responses
+// are returned to the caller like any other. The redirects are followed outside the client's filters, so each request
+// in the chain passes through them (and the cookie jar, if there is one).
+func WithRedirects(opts ...RedirectOption) ClientOption {
+	return func(o *clientOptions) {
+		o.redirects = RedirectFilter(opts...)
+	}
+}
+
+// RedirectFilter returns a Filter which follows 301, 302, 303, 307 and 308 responses, sending the request again to
+// the URL in their Location header and returning the final response. 303 responses (and 301 and 302, as browsers do)
+// are followed with a GET, unless the request was a GET or HEAD already.
+func RedirectFilter(opts ...RedirectOption) Filter {
+	o := redirectOptions{
+		maxHops:        10,
+		preserveMethod: true,
+		stripHeaders:   []string{"Authorization", "Proxy-Authorization", "Cookie"}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return func(req Request, svc Service) Response {
+		body, replayable, err := replayableBody(&req, redirectMaxBody)
+		if err != nil {
+			return Response{
+				Request: &req,
+				Error:   terrors.Wrap(err, nil)}
+		}
+		var via []Request
+		for {
+			sent := req
+			if replayable {
+				sent = withReplayedBody(req, body)
+			}
+			rsp := svc(sent)
+			next, keepBody, ok := o.redirect(req, rsp)
+			switch {
+			case !ok:
+				return rsp
+			case keepBody && !replayable:
+				return rsp
+			}
+
+			via = append(via, req)
+			if len(via) > o.maxHops {
+				discardResponse(rsp)
+				rsp.Error = terrors.BadResponse("too_many_redirects", fmt.Sprintf("Stopped after %d redirects",
+					o.maxHops), map[string]string{
+					"location": next.URL.String()})
+				return rsp
+			}
+			if o.check != nil && !o.check(next, via) {
+				return rsp
+			}
+			discardResponse(rsp)
+			if !keepBody {
+				body, replayable = nil, true
+			}
+			req = next
+		}
+	}
+}
+
+// redirect returns the request to send to follow the response, and whether it has the original request's body, or
+// false if the response isn't a redirect which can be followed.
+func (o redirectOptions) redirect(req Request, rsp Response) (Request, bool, bool) {
+	if rsp.Response == nil || req.URL == nil {
+		return req, false, false
+	}
+	switch rsp.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect,
+		http.StatusPermanentRedirect:
+	default:
+		return req, false, false
+	}
+	loc := rsp.Header.Get("Location")
+	if loc == "" {
+		return req, false, false
+	}
+	u, err := req.URL.Parse(loc)
+	if err != nil {
+		return req, false, false
+	}
+
+	next := req
+	next.URL = u
+	next.Host = ""
+	next.Header = req.Header.Clone()
+	if u.Scheme != req.URL.Scheme || u.Host != req.URL.Host {
+		for _, h := range o.stripHeaders {
+			next.Header.Del(h)
+		}
+	}
+	keepBody := o.preserveMethod && (rsp.StatusCode == http.StatusTemporaryRedirect ||
+		rsp.StatusCode == http.StatusPermanentRedirect)
+	if !keepBody && req.Method != http.MethodGet && req.Method != http.MethodHead {
+		next.Method = http.MethodGet
+		next.Body = nil
+		next.ContentLength = 0
+		for _, h := range []string{"Content-Type", "Content-Length", "Content-Encoding", "Transfer-Encoding"} {
+			next.Header.Del(h)
+		}
+	}
+	return next, keepBody && next.Body != nil, true
+}
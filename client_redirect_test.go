@@ -0,0 +1,137 @@
+package libhttp
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type redirectEcho struct {
+	Method string `json:"method"`
+	Body   string `json:"body"`
+	Auth   string `json:"auth"`
+	Host   string `json:"host"`
+}
+
+func redirectServer(t *testing.T) string {
+	router := &Router{}
+	router.Register("*", "/redirect/:code", func(req Request) Response {
+		var code int
+		switch req.URL.Path {
+		case "/redirect/302":
+			code = http.StatusFound
+		case "/redirect/303":
+			code = http.StatusSeeOther
+		case "/redirect/307":
+			code = http.StatusTemporaryRedirect
+		case "/redirect/308":
+			code = http.StatusPermanentRedirect
+		}
+		return req.Redirect(req.URL.Query().Get("to"), code)
+	})
+	router.Register("*", "/loop", func(req Request) Response {
+		return req.Redirect("/loop", http.StatusFound)
+	})
+	router.Register("*", "/echo", func(req Request) Response {
+		b, _ := ioutil.ReadAll(req.Body)
+		return req.Response(redirectEcho{
+			Method: req.Method,
+			Body:   string(b),
+			Auth:   req.Header.Get("Authorization"),
+			Host:   req.Host})
+	})
+	s, err := Listen(router.Serve(), "localhost:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Stop(context.Background()) })
+	return s.Listener().Addr().String()
+}
+
+func TestRedirectFilter(t *testing.T) {
+	t.Parallel()
+	addr := redirectServer(t)
+	base := "http://" + addr
+	ctx := context.Background()
+
+	send := func(client Service, method, url string, body interface{}) (Response, redirectEcho) {
+		req := NewRequest(ctx, method, url, body)
+		req.Header.Set("Authorization", "Bearer abc")
+		rsp := req.SendVia(client).Response()
+		e := redirectEcho{}
+		if rsp.Error == nil && rsp.StatusCode == http.StatusOK && method != "HEAD" {
+			require.NoError(t, rsp.Decode(&e))
+		}
+		return rsp, e
+	}
+
+	// Without the option, redirects are returned
+	rsp, _ := send(NewClient(), "GET", base+"/redirect/302?to=/echo", nil)
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusFound, rsp.StatusCode)
+
+	client := NewClient(WithRedirects())
+	cases := []struct {
+		code, method, wantMethod, wantBody string
+	}{
+		{"302", "GET", "GET", ""},
+		{"302", "POST", "GET", ""},
+		{"303", "PUT", "GET", ""},
+		{"303", "HEAD", "HEAD", ""},
+		{"307", "POST", "POST", `{"a":1}`},
+		{"308", "PUT", "PUT", `{"a":1}`}}
+	for _, c := range cases {
+		rsp, e := send(client, c.method, base+"/redirect/"+c.code+"?to=/echo", map[string]int{"a": 1})
+		require.NoError(t, rsp.Error, "%s %s", c.code, c.method)
+		assert.Equal(t, http.StatusOK, rsp.StatusCode, "%s %s", c.code, c.method)
+		if c.method == "HEAD" {
+			continue
+		}
+		assert.Equal(t, c.wantMethod, e.Method, "%s %s", c.code, c.method)
+		assert.Equal(t, c.wantBody, strings.TrimSpace(e.Body), "%s %s", c.code, c.method)
+		assert.Equal(t, "Bearer abc", e.Auth, "%s %s", c.code, c.method)
+	}
+
+	// Method preservation can be turned off
+	rsp, e := send(NewClient(WithRedirects(RedirectPreserveMethod(false))), "POST", base+"/redirect/307?to=/echo",
+		map[string]int{"a": 1})
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, "GET", e.Method)
+	assert.Equal(t, "", e.Body)
+
+	// Credentials are removed on cross-origin redirects
+	_, port, _ := net.SplitHostPort(addr)
+	other := "http://localhost:" + port
+	rsp, e = send(client, "GET", base+"/redirect/302?to="+other+"/echo", nil)
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, "", e.Auth)
+	rsp, e = send(NewClient(WithRedirects(RedirectStripHeaders())), "GET", base+"/redirect/302?to="+other+"/echo", nil)
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, "Bearer abc", e.Auth)
+}
+
+func TestRedirectFilterLimits(t *testing.T) {
+	t.Parallel()
+	base := "http://" + redirectServer(t)
+	ctx := context.Background()
+
+	rsp := NewRequest(ctx, "GET", base+"/loop", nil).SendVia(NewClient(WithRedirects(RedirectMaxHops(3)))).Response()
+	require.Error(t, rsp.Error)
+	assert.True(t, terrors.PrefixMatches(rsp.Error, terrors.ErrBadResponse, "too_many_redirects"))
+	assert.Equal(t, http.StatusFound, rsp.StatusCode)
+
+	var vias []int
+	client := NewClient(WithRedirects(RedirectCheck(func(req Request, via []Request) bool {
+		vias = append(vias, len(via))
+		return req.URL.Path != "/echo"
+	})))
+	rsp = NewRequest(ctx, "GET", base+"/redirect/303?to=/redirect/302%3Fto%3D/echo", nil).SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusFound, rsp.StatusCode)
+	assert.Equal(t, []int{1, 2}, vias)
+}
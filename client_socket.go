@@ -0,0 +1,36 @@
+package libhttp
+
+import (
+	"context"
+	"net"
+	"net/http"
+)
+
+// WithUnixSocket makes the client connect to the unix socket at the passed path for every request, whatever host its
+// URL names, and never through a proxy. Like the other transport options, it requires the client's RoundTripper to
+// be an *http.Transport.
+func WithUnixSocket(path string) ClientOption {
+	return func(o *clientOptions) {
+		o.transportOpts = append(o.transportOpts, func(t *http.Transport) {
+			d := &net.Dialer{}
+			t.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return d.DialContext(ctx, "unix", path)
+			}
+			t.Proxy = nil
+		})
+	}
+}
+
+// ClientForUnixSocket returns a client Service which sends requests to the unix socket at the passed path, such as
+// those of local daemons like Docker, or servers started with ListenUnix. Requests keep normal URLs, whose host is
+// sent in the Host header but otherwise ignored:
+//
+//  docker := libhttp.ClientForUnixSocket("/var/run/docker.sock")
+//  rsp := libhttp.NewRequest(ctx, "GET", "http://docker/v1.41/containers/json", nil).SendVia(docker).Response()
+//
+// Further options are applied as they are by NewClient.
+func ClientForUnixSocket(path string, opts ...ClientOption) Service {
+	return NewClient(append([]ClientOption{
+		WithRoundTripper(&http.Transport{}),
+		WithUnixSocket(path)}, opts...)...)
+}
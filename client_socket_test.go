@@ -0,0 +1,37 @@
+package libhttp
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientForUnixSocket(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "test.sock")
+	s, err, _ := ListenUnix(Service(func(req Request) Response {
+		return req.Response(map[string]string{
+			"host": req.Host,
+			"path": req.URL.Path,
+			"ua":   req.Header.Get("User-Agent")})
+	}), path)
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+
+	client := ClientForUnixSocket(path, WithDefaultHeader("User-Agent", "test"))
+	rsp := NewRequest(context.Background(), "GET", "http://daemon/v1/status", nil).SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+	body := map[string]string{}
+	require.NoError(t, rsp.Decode(&body))
+	assert.Equal(t, map[string]string{
+		"host": "daemon",
+		"path": "/v1/status",
+		"ua":   "test"}, body)
+
+	rsp = NewRequest(context.Background(), "GET", "http://daemon/", nil).
+		SendVia(ClientForUnixSocket(filepath.Join(t.TempDir(), "missing.sock"))).Response()
+	assert.Error(t, rsp.Error)
+}
@@ -0,0 +1,163 @@
+package libhttp
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewClient(t *testing.T) {
+	t.Parallel()
+	svc := Service(func(req Request) Response {
+		if req.URL.Path == "/slow" {
+			select {
+			case <-req.Done():
+			case <-time.After(5 * time.Second):
+			}
+			return req.Response(nil)
+		}
+		return req.Response(map[string]string{
+			"user_agent": req.Header.Get("User-Agent"),
+			"api_key":    req.Header.Get("X-Api-Key"),
+			"trace":      req.Header.Get("X-Trace")})
+	})
+	s, err := Listen(svc, "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+
+	var order []string
+	trace := func(name string) Filter {
+		return func(req Request, svc Service) Response {
+			order = append(order, name)
+			// Filters see the default headers, and can override them
+			if name == "outer" {
+				assert.Equal(t, "test/1.0", req.Header.Get("User-Agent"))
+				req.Header.Set("X-Trace", "traced")
+			}
+			rsp := svc(req)
+			order = append(order, name)
+			return rsp
+		}
+	}
+	client := NewClient(
+		WithRoundTripper(&http.Transport{}),
+		WithDefaultHeader("User-Agent", "test/1.0"),
+		WithDefaultHeader("X-Api-Key", "default"),
+		WithRequestTimeout(time.Second),
+		WithClientFilters(trace("outer"), trace("inner")),
+		WithClientFilters(ErrorFilter))
+
+	req := NewRequest(context.Background(), "GET", "http://"+s.Listener().Addr().String(), nil)
+	req.Header.Set("X-Api-Key", "mine")
+	rsp := req.SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+	body := map[string]string{}
+	require.NoError(t, rsp.Decode(&body))
+	assert.Equal(t, map[string]string{
+		"user_agent": "test/1.0",
+		"api_key":    "mine",
+		"trace":      "traced"}, body)
+	assert.Equal(t, []string{"outer", "inner", "inner", "outer"}, order)
+
+	// The deadline lasts while the body is read, but not for ever
+	req = NewRequest(context.Background(), "GET", "http://"+s.Listener().Addr().String()+"/slow", nil)
+	start := time.Now()
+	rsp = req.SendVia(client).Response()
+	require.Error(t, rsp.Error)
+	assert.True(t, time.Since(start) < 4*time.Second, "request wasn't cancelled")
+	assert.True(t, strings.Contains(rsp.Error.Error(), "deadline") || terrors.Is(rsp.Error, terrors.ErrTimeout),
+		"unexpected error: %v", rsp.Error)
+}
+
+func TestClientTimeouts(t *testing.T) {
+	t.Parallel()
+	svc := Service(func(req Request) Response {
+		select {
+		case <-req.Done():
+		case <-time.After(200 * time.Millisecond):
+		}
+		return req.Response(nil)
+	})
+	s, err := Listen(svc, "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+	addr := s.Listener().Addr().String()
+	send := func(client Service, req Request) Response {
+		rsp := req.SendVia(client).Response()
+		if rsp.Response != nil && rsp.Body != nil {
+			rsp.BodyBytes(true)
+		}
+		return rsp
+	}
+
+	// Connecting
+	client := NewClient(
+		WithRoundTripper(&http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}}),
+		WithConnectTimeout(20*time.Millisecond))
+	rsp := send(client, NewRequest(context.Background(), "GET", "http://"+addr, nil))
+	assert.True(t, terrors.Is(rsp.Error, terrors.ErrTimeout, "transport"), "unexpected error: %v", rsp.Error)
+
+	// The TLS handshake, with a server which never responds
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		var conns []net.Conn
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				for _, c := range conns {
+					c.Close()
+				}
+				return
+			}
+			conns = append(conns, c)
+		}
+	}()
+	client = NewClient(
+		WithRoundTripper(&http.Transport{}),
+		WithTLSHandshakeTimeout(20*time.Millisecond))
+	rsp = send(client, NewRequest(context.Background(), "GET", "https://"+l.Addr().String(), nil))
+	assert.True(t, terrors.Is(rsp.Error, terrors.ErrTimeout, "transport"), "unexpected error: %v", rsp.Error)
+
+	// Waiting for the response's headers
+	client = NewClient(
+		WithRoundTripper(&http.Transport{}),
+		WithResponseHeaderTimeout(20*time.Millisecond))
+	rsp = send(client, NewRequest(context.Background(), "GET", "http://"+addr, nil))
+	assert.True(t, terrors.Is(rsp.Error, terrors.ErrTimeout, "transport"), "unexpected error: %v", rsp.Error)
+
+	// The request as a whole, which individual requests can override
+	client = NewClient(
+		WithRoundTripper(&http.Transport{}),
+		WithRequestTimeout(20*time.Millisecond))
+	rsp = send(client, NewRequest(context.Background(), "GET", "http://"+addr, nil))
+	assert.True(t, terrors.Is(rsp.Error, terrors.ErrTimeout, "request"), "unexpected error: %v", rsp.Error)
+	rsp = send(client, NewRequest(context.Background(), "GET", "http://"+addr, nil).WithTimeout(0))
+	assert.NoError(t, rsp.Error)
+	rsp = send(client, NewRequest(context.Background(), "GET", "http://"+addr, nil).WithTimeout(time.Minute))
+	assert.NoError(t, rsp.Error)
+
+	// When the context's deadline is earlier, its error is returned instead
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	rsp = send(client, NewRequest(ctx, "GET", "http://"+addr, nil).WithTimeout(time.Minute))
+	require.Error(t, rsp.Error)
+	assert.False(t, terrors.Is(rsp.Error, terrors.ErrTimeout), "unexpected error: %v", rsp.Error)
+
+	// Transport timeouts need an http.Transport
+	assert.Panics(t, func() {
+		NewClient(WithRoundTripper(http.NewFileTransport(http.Dir("."))), WithConnectTimeout(time.Second))
+	})
+}
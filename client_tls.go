@@ -0,0 +1,234 @@
+package libhttp
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WithTLSConfig sets the TLS configuration the client uses to connect to servers. The config is copied, so it may not
+// be modified afterwards. Like the other transport options, it requires the client's RoundTripper to be an
+// *http.Transport.
+func WithTLSConfig(cfg *tls.Config) ClientOption {
+	return func(o *clientOptions) {
+		o.transportOpts = append(o.transportOpts, func(t *http.Transport) {
+			t.TLSClientConfig = cfg.Clone()
+			t.ForceAttemptHTTP2 = true // a custom config otherwise disables HTTP/2
+		})
+	}
+}
+
+// WithRootCAs sets the certificate authorities the client trusts to verify servers' certificates, instead of the
+// system's, so it can connect to endpoints with certificates issued by an internal CA.
+func WithRootCAs(pool *x509.CertPool) ClientOption {
+	return func(o *clientOptions) {
+		o.transportOpts = append(o.transportOpts, func(t *http.Transport) {
+			t.TLSClientConfig = transportTLSConfig(t)
+			t.TLSClientConfig.RootCAs = pool
+		})
+	}
+}
+
+// WithSPKIPins makes the client only accept servers whose certificate chains include a certificate with one of the
+// pinned public keys (see PinSet), in addition to the usual verification of certificates. Pins for particular hosts
+// can be set with WithHostTLSConfig, using PinSet.VerifyConnection.
+func WithSPKIPins(pins *PinSet) ClientOption {
+	return func(o *clientOptions) {
+		o.transportOpts = append(o.transportOpts, func(t *http.Transport) {
+			t.TLSClientConfig = transportTLSConfig(t)
+			t.TLSClientConfig.VerifyConnection = pins.chain(t.TLSClientConfig.VerifyConnection)
+		})
+	}
+}
+
+// WithHostTLSConfig makes the client use the passed TLS configuration (rather than its default one) to connect to the
+// host, which is matched exactly against the host name of requests' URLs, without the port. For example, to pin the
+// certificate of one internal endpoint:
+//
+//  pins, _ := libhttp.NewPinSet("sha256/7HIpactkIAq2Y49orFOOQKurWxmmSFZhBCoQYcRhJ3Y=")
+//  client := libhttp.NewClient(libhttp.WithHostTLSConfig("ledger.internal", &tls.Config{
+//      RootCAs:          internalCAs,
+//      VerifyConnection: pins.VerifyConnection}))
+//
+// Timeouts set with WithConnectTimeout and WithTLSHandshakeTimeout apply to these connections too.
+func WithHostTLSConfig(host string, cfg *tls.Config) ClientOption {
+	cfg = cfg.Clone()
+	return func(o *clientOptions) {
+		o.transportOpts = append(o.transportOpts, func(t *http.Transport) {
+			next := t.DialTLSContext
+			t.ForceAttemptHTTP2 = true
+			t.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+				h, _, err := net.SplitHostPort(addr)
+				switch {
+				case err == nil && strings.EqualFold(h, host):
+					return dialTLS(ctx, t, network, addr, cfg)
+				case next != nil:
+					return next(ctx, network, addr)
+				default:
+					return dialTLS(ctx, t, network, addr, t.TLSClientConfig)
+				}
+			}
+		})
+	}
+}
+
+// transportTLSConfig returns a copy of the transport's TLS config, which may be modified, or a new one if it has none.
+func transportTLSConfig(t *http.Transport) *tls.Config {
+	t.ForceAttemptHTTP2 = true
+	if t.TLSClientConfig == nil {
+		return &tls.Config{}
+	}
+	return t.TLSClientConfig.Clone()
+}
+
+// dialTLS connects to the address with the transport's dialer, and completes a TLS handshake using the config, much
+// like the transport itself does.
+func dialTLS(ctx context.Context, t *http.Transport, network, addr string, cfg *tls.Config) (net.Conn, error) {
+	dial := t.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{
+			KeepAlive: 30 * time.Second}).DialContext
+	}
+	conn, err := dial(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		cfg = &tls.Config{}
+	} else {
+		cfg = cfg.Clone()
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+	if len(cfg.NextProtos) == 0 {
+		cfg.NextProtos = []string{"h2", "http/1.1"}
+	}
+	deadline, _ := ctx.Deadline()
+	if d := t.TLSHandshakeTimeout; d > 0 && (deadline.IsZero() || time.Now().Add(d).Before(deadline)) {
+		deadline = time.Now().Add(d)
+	}
+	conn.SetDeadline(deadline)
+	tlsConn := tls.Client(conn, cfg)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now()) // abort the handshake
+		case <-done:
+		}
+	}()
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+// A PinSet is a set of pinned public keys, used to restrict which certificates a client accepts to those issued for
+// (or by) known keys. Pins are the base64-encoded SHA-256 hashes of certificates' DER-encoded SubjectPublicKeyInfo,
+// optionally prefixed with "sha256/", as in HPKP; SPKIPin computes them, or with OpenSSL:
+//
+//  openssl x509 -in cert.pem -pubkey -noout | openssl pkey -pubin -outform der | openssl dgst -sha256 -binary | base64
+//
+// To rotate keys without an outage, pin both the current key and the next one (and pinning a backup key is prudent
+// anyway); once the new key is deployed, the old pin can be removed. Set replaces the pins of a running client.
+type PinSet struct {
+	m    sync.RWMutex
+	pins map[string]bool
+}
+
+// NewPinSet returns a PinSet of the passed pins. An error is returned if any pin is invalid.
+func NewPinSet(pins ...string) (*PinSet, error) {
+	s := &PinSet{}
+	if err := s.Set(pins...); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Set replaces the set's pins. Connections made afterwards are checked against the new pins; if any pin is invalid,
+// an error is returned and the set is unchanged.
+func (s *PinSet) Set(pins ...string) error {
+	if len(pins) == 0 {
+		return errors.New("no pins")
+	}
+	m := make(map[string]bool, len(pins))
+	for _, pin := range pins {
+		pin = strings.TrimPrefix(strings.TrimSpace(pin), "sha256/")
+		if b, err := base64.StdEncoding.DecodeString(pin); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("invalid SPKI pin %q", pin)
+		}
+		m[pin] = true
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.pins = m
+	return nil
+}
+
+// Pins returns the set's pins.
+func (s *PinSet) Pins() []string {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	pins := make([]string, 0, len(s.pins))
+	for pin := range s.pins {
+		pins = append(pins, pin)
+	}
+	return pins
+}
+
+// VerifyConnection returns an error unless a certificate in a verified chain has a pinned public key. If the chain
+// wasn't verified (as with InsecureSkipVerify), only the server's own certificate is checked, as the others it presents
+// prove nothing. It has the signature of tls.Config.VerifyConnection, to be used there.
+func (s *PinSet) VerifyConnection(cs tls.ConnectionState) error {
+	var certs []*x509.Certificate
+	for _, chain := range cs.VerifiedChains {
+		certs = append(certs, chain...)
+	}
+	if len(cs.VerifiedChains) == 0 && len(cs.PeerCertificates) > 0 {
+		certs = cs.PeerCertificates[:1]
+	}
+	s.m.RLock()
+	defer s.m.RUnlock()
+	for _, cert := range certs {
+		if s.pins[SPKIPin(cert)] {
+			return nil
+		}
+	}
+	return fmt.Errorf("certificate for %s doesn't match any pinned public key", cs.ServerName)
+}
+
+// chain returns a VerifyConnection function which checks the pins, then calls next (if it isn't nil).
+func (s *PinSet) chain(next func(tls.ConnectionState) error) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if err := s.VerifyConnection(cs); err != nil {
+			return err
+		}
+		if next != nil {
+			return next(cs)
+		}
+		return nil
+	}
+}
+
+// SPKIPin returns the pin of the certificate's public key: the base64-encoded SHA-256 hash of its
+// SubjectPublicKeyInfo.
+func SPKIPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
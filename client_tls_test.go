@@ -0,0 +1,76 @@
+package libhttp
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientTLS(t *testing.T) {
+	t.Parallel()
+	cert := keypair(t, []string{"127.0.0.1"})
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2"}})
+	require.NoError(t, err)
+	s, err := Serve(Service(func(req Request) Response {
+		return req.Response(req.Proto)
+	}), l)
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+	_, port, _ := net.SplitHostPort(s.Listener().Addr().String())
+	url := "https://127.0.0.1:" + port + "/"
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+	pin := "sha256/" + SPKIPin(leaf)
+	otherSum := sha256.Sum256([]byte("another key"))
+	otherPin := base64.StdEncoding.EncodeToString(otherSum[:])
+
+	get := func(url string, opts ...ClientOption) Response {
+		client := NewClient(append([]ClientOption{WithRoundTripper(&http.Transport{})}, opts...)...)
+		rsp := NewRequest(context.Background(), "GET", url, nil).SendVia(client).Response()
+		if rsp.Error == nil {
+			var proto string
+			require.NoError(t, rsp.Decode(&proto))
+			assert.Equal(t, "HTTP/2.0", proto)
+		}
+		return rsp
+	}
+
+	// The server's certificate isn't trusted by default
+	assert.Error(t, get(url).Error)
+	assert.NoError(t, get(url, WithRootCAs(roots)).Error)
+	assert.NoError(t, get(url, WithTLSConfig(&tls.Config{RootCAs: roots})).Error)
+
+	// Pinning
+	pins, err := NewPinSet(otherPin, pin)
+	require.NoError(t, err)
+	assert.NoError(t, get(url, WithRootCAs(roots), WithSPKIPins(pins)).Error)
+	require.NoError(t, pins.Set(otherPin))
+	assert.Error(t, get(url, WithRootCAs(roots), WithSPKIPins(pins)).Error)
+	assert.Equal(t, []string{otherPin}, pins.Pins())
+	assert.Error(t, pins.Set("not a pin"))
+	assert.Equal(t, []string{otherPin}, pins.Pins())
+
+	// Per-host configuration
+	hostPins, err := NewPinSet(pin)
+	require.NoError(t, err)
+	opt := WithHostTLSConfig("127.0.0.1", &tls.Config{
+		RootCAs:          roots,
+		VerifyConnection: hostPins.VerifyConnection})
+	assert.NoError(t, get(url, opt).Error)
+	assert.Error(t, get("https://localhost:"+port+"/", opt).Error)
+	require.NoError(t, hostPins.Set(otherPin))
+	assert.Error(t, get(url, opt).Error)
+}
@@ -0,0 +1,219 @@
+package libhttp
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A Codec serialises and de-serialises bodies of a particular content type. Codecs are registered with RegisterCodec,
+// and Decode on Requests and Responses selects between them based on the Content-Type of the body.
+type Codec interface {
+	// ContentType is the media type that the codec produces, which is set as the Content-Type of encoded bodies
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(b []byte, v interface{}) error
+}
+
+var (
+	codecsM sync.RWMutex
+	codecs  = map[string]Codec{}
+)
+
+func init() {
+	RegisterCodec(jsonCodec{})
+	RegisterCodec(xmlCodec{}, "text/xml")
+}
+
+// RegisterCodec makes a codec available for decoding bodies of its content type, and of any other media types which
+// are passed (aliases, for example). A codec registered for a media type which already has one replaces it.
+func RegisterCodec(c Codec, mediaTypes ...string) {
+	codecsM.Lock()
+	defer codecsM.Unlock()
+	for _, mt := range append([]string{c.ContentType()}, mediaTypes...) {
+		codecs[mediaType(mt)] = c
+	}
+}
+
+// mediaType returns the normalised media type from a Content-Type (stripping any parameters).
+func mediaType(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mt = strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+	}
+	return strings.ToLower(mt)
+}
+
+// lookupCodec returns the codec registered for the passed Content-Type, or nil. Structured syntax suffixes are
+// understood, so application/problem+json uses the JSON codec.
+func lookupCodec(contentType string) Codec {
+	mt := mediaType(contentType)
+	if c := registeredCodec(mt); c != nil {
+		return c
+	}
+	if i := strings.LastIndexByte(mt, '+'); i >= 0 {
+		return registeredCodec("application/" + mt[i+1:])
+	}
+	return nil
+}
+
+// registeredCodec returns the codec registered for exactly the passed media type, or nil.
+func registeredCodec(mt string) Codec {
+	codecsM.RLock()
+	defer codecsM.RUnlock()
+	return codecs[mt]
+}
+
+// codecFor returns the codec for the passed Content-Type. Bodies of unknown types are assumed to be JSON.
+func codecFor(contentType string) Codec {
+	if c := lookupCodec(contentType); c != nil {
+		return c
+	}
+	return jsonCodec{}
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string                     { return "application/json" }
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)   { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(b []byte, v interface{}) error { return json.Unmarshal(b, v) }
+
+type xmlCodec struct{}
+
+func (xmlCodec) ContentType() string { return "application/xml" }
+
+func (xmlCodec) Marshal(v interface{}) ([]byte, error) {
+	b, err := xml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), b...), nil
+}
+
+func (xmlCodec) Unmarshal(b []byte, v interface{}) error { return xml.Unmarshal(b, v) }
+
+// encodeAs serialises v with the codec, or returns false if it can't: the protobuf codec only handles messages, and
+// others may not support every type (the XML codec can't encode maps, for example).
+func encodeAs(c Codec, v interface{}) ([]byte, bool) {
+	if _, ok := c.(protoCodec); ok {
+		if _, ok := protoMessage(v); !ok {
+			return nil, false
+		}
+	}
+	b, err := c.Marshal(v)
+	return b, err == nil
+}
+
+// An acceptRange is an entry from an Accept header.
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// parseAccept returns the media ranges from Accept headers which are acceptable (ie. have a non-zero quality), most
+// preferred first. Ranges of equal quality are ordered by specificity (so text/* is preferred to */*), and then as
+// listed.
+func parseAccept(headers []string) []acceptRange {
+	var ranges []acceptRange
+	for _, h := range headers {
+		for _, entry := range strings.Split(h, ",") {
+			mt, params, err := mime.ParseMediaType(entry)
+			if err != nil {
+				continue
+			}
+			q := 1.0
+			if qs, ok := params["q"]; ok {
+				if q, err = strconv.ParseFloat(qs, 64); err != nil {
+					continue
+				}
+			}
+			if q > 0 {
+				ranges = append(ranges, acceptRange{
+					mediaType: mt,
+					q:         q})
+			}
+		}
+	}
+	specificity := func(mt string) int {
+		switch {
+		case mt == "*/*":
+			return 0
+		case strings.HasSuffix(mt, "/*"):
+			return 1
+		}
+		return 2
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].q != ranges[j].q {
+			return ranges[i].q > ranges[j].q
+		}
+		return specificity(ranges[i].mediaType) > specificity(ranges[j].mediaType)
+	})
+	return ranges
+}
+
+// negotiateCodec returns the codec with which to encode v in response to the request, along with v encoded by it:
+// the most preferred by its Accept header that can encode v, or if it doesn't have one, the codec for the type of its
+// body. Failing those, JSON is used, and v is left for the caller to encode (so the returned bytes are nil).
+func (r Request) negotiateCodec(v interface{}) (Codec, []byte) {
+	accept := r.Header.Values("Accept")
+	if len(accept) == 0 {
+		if c := lookupCodec(r.Header.Get("Content-Type")); c != nil && c != (jsonCodec{}) {
+			if b, ok := encodeAs(c, v); ok {
+				return c, b
+			}
+		}
+		return jsonCodec{}, nil
+	}
+	for _, ar := range parseAccept(accept) {
+		if ar.mediaType == "*/*" {
+			return jsonCodec{}, nil
+		}
+		if strings.HasSuffix(ar.mediaType, "/*") {
+			if c, b := codecForRange(strings.TrimSuffix(ar.mediaType, "*"), v); c != nil {
+				return c, b
+			}
+			continue
+		}
+		// Suffixes aren't understood here: a client accepting application/xhtml+xml (as browsers do) doesn't want XML
+		if c := registeredCodec(ar.mediaType); c == (jsonCodec{}) {
+			return c, nil
+		} else if c != nil {
+			if b, ok := encodeAs(c, v); ok {
+				return c, b
+			}
+		}
+	}
+	return jsonCodec{}, nil
+}
+
+// codecForRange returns a codec registered for a type with the passed prefix (eg. "application/") which can encode v,
+// and v encoded by it, preferring JSON and then choosing by media type so that the choice is stable.
+func codecForRange(prefix string, v interface{}) (Codec, []byte) {
+	if strings.HasPrefix(jsonCodec{}.ContentType(), prefix) {
+		return jsonCodec{}, nil
+	}
+	codecsM.RLock()
+	var mts []string
+	candidates := map[string]Codec{}
+	for mt, c := range codecs {
+		if strings.HasPrefix(mt, prefix) {
+			mts = append(mts, mt)
+			candidates[mt] = c
+		}
+	}
+	codecsM.RUnlock()
+	sort.Strings(mts)
+	for _, mt := range mts {
+		if c := candidates[mt]; c == (jsonCodec{}) {
+			return c, nil
+		} else if b, ok := encodeAs(c, v); ok {
+			return c, b
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,729 @@
+package libhttp
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// This file implements CBOR (RFC 8949) for the codec registry. Values are mapped as encoding/json maps them: structs
+// become maps keyed by field name (taken from a cbor tag, or failing that a json tag), and when decoding into an empty
+// interface, maps with text keys become map[string]interface{}, arrays []interface{}, integers int64 (or uint64 if they
+// don't fit), and floating-point numbers float64. Tagged items are decoded as their content.
+
+func init() {
+	RegisterCodec(cborCodec{})
+}
+
+type cborCodec struct{}
+
+func (cborCodec) ContentType() string { return "application/cbor" }
+
+func (cborCodec) Marshal(v interface{}) ([]byte, error) {
+	e := &cborEncoder{}
+	if err := e.encode(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return e.buf.Bytes(), nil
+}
+
+func (cborCodec) Unmarshal(b []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("cbor: can't decode into %T", v)
+	}
+	d := &cborDecoder{b: b}
+	if err := d.decode(rv.Elem(), 0); err != nil {
+		return err
+	}
+	if d.off != len(d.b) {
+		return errors.New("cbor: unexpected data after top-level item")
+	}
+	return nil
+}
+
+// EncodeCBOR serialises the passed object as CBOR into the body (and sets appropriate headers).
+func (r *Request) EncodeCBOR(v interface{}) {
+	r.encodeWith(cborCodec{}, v)
+}
+
+// EncodeCBOR serialises the passed object as CBOR into the body (and sets appropriate headers).
+func (r *Response) EncodeCBOR(v interface{}) {
+	r.encodeWith(cborCodec{}, v)
+}
+
+const (
+	cborUint   = 0
+	cborNegInt = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborTag    = 6
+	cborSimple = 7
+
+	cborFalse      = 0xf4
+	cborTrue       = 0xf5
+	cborNull       = 0xf6
+	cborUndefined  = 0xf7
+	cborBreak      = 0xff
+	cborIndefinite = 31
+
+	cborMaxDepth = 512
+)
+
+var (
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	errCBORTruncated  = errors.New("cbor: unexpected end of data")
+)
+
+// cborField is a struct field which is encoded as a map entry.
+type cborField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+var cborFieldCache sync.Map // reflect.Type → []cborField
+
+// cborFields returns the encoded fields of a struct type, including those promoted from embedded structs.
+func cborFields(t reflect.Type) []cborField {
+	if fs, ok := cborFieldCache.Load(t); ok {
+		return fs.([]cborField)
+	}
+	var fields []cborField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, ok := f.Tag.Lookup("cbor")
+		if !ok {
+			tag = f.Tag.Get("json")
+		}
+		if tag == "-" {
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		if f.Anonymous && opts[0] == "" && f.Type.Kind() == reflect.Struct {
+			for _, ef := range cborFields(f.Type) {
+				ef.index = append([]int{i}, ef.index...)
+				fields = append(fields, ef)
+			}
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		field := cborField{
+			name:  opts[0],
+			index: []int{i}}
+		if field.name == "" {
+			field.name = f.Name
+		}
+		for _, o := range opts[1:] {
+			if o == "omitempty" {
+				field.omitEmpty = true
+			}
+		}
+		fields = append(fields, field)
+	}
+	cborFieldCache.Store(t, fields)
+	return fields
+}
+
+type cborEncoder struct {
+	buf bytes.Buffer
+}
+
+// head writes the initial bytes of an item: its major type and argument.
+func (e *cborEncoder) head(major byte, n uint64) {
+	major <<= 5
+	switch {
+	case n < 24:
+		e.buf.WriteByte(major | byte(n))
+	case n <= math.MaxUint8:
+		e.buf.Write([]byte{major | 24, byte(n)})
+	case n <= math.MaxUint16:
+		b := []byte{major | 25, 0, 0}
+		binary.BigEndian.PutUint16(b[1:], uint16(n))
+		e.buf.Write(b)
+	case n <= math.MaxUint32:
+		b := []byte{major | 26, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		e.buf.Write(b)
+	default:
+		b := []byte{major | 27, 0, 0, 0, 0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint64(b[1:], n)
+		e.buf.Write(b)
+	}
+}
+
+func (e *cborEncoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		e.buf.WriteByte(cborNull)
+		return nil
+	}
+	if v.Type().Implements(textMarshalerType) && !(v.Kind() == reflect.Ptr && v.IsNil()) {
+		text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return err
+		}
+		e.head(cborText, uint64(len(text)))
+		e.buf.Write(text)
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			e.buf.WriteByte(cborNull)
+			return nil
+		}
+		return e.encode(v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			e.buf.WriteByte(cborTrue)
+		} else {
+			e.buf.WriteByte(cborFalse)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n := v.Int(); n >= 0 {
+			e.head(cborUint, uint64(n))
+		} else {
+			e.head(cborNegInt, uint64(-1-n))
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.head(cborUint, v.Uint())
+	case reflect.Float32:
+		e.buf.WriteByte(cborSimple<<5 | 26)
+		binary.Write(&e.buf, binary.BigEndian, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		e.buf.WriteByte(cborSimple<<5 | 27)
+		binary.Write(&e.buf, binary.BigEndian, math.Float64bits(v.Float()))
+	case reflect.String:
+		e.head(cborText, uint64(v.Len()))
+		e.buf.WriteString(v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			e.buf.WriteByte(cborNull)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.head(cborBytes, uint64(v.Len()))
+			if v.Kind() == reflect.Slice {
+				e.buf.Write(v.Bytes())
+			} else {
+				for i := 0; i < v.Len(); i++ {
+					e.buf.WriteByte(byte(v.Index(i).Uint()))
+				}
+			}
+			return nil
+		}
+		e.head(cborArray, uint64(v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			if err := e.encode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			e.buf.WriteByte(cborNull)
+			return nil
+		}
+		// Keys are sorted by their encoding, as in RFC 8949's deterministic encoding
+		type entry struct{ k, v []byte }
+		entries := make([]entry, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			ke, ve := &cborEncoder{}, &cborEncoder{}
+			if err := ke.encode(iter.Key()); err != nil {
+				return err
+			}
+			if err := ve.encode(iter.Value()); err != nil {
+				return err
+			}
+			entries = append(entries, entry{ke.buf.Bytes(), ve.buf.Bytes()})
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return bytes.Compare(entries[i].k, entries[j].k) < 0
+		})
+		e.head(cborMap, uint64(len(entries)))
+		for _, en := range entries {
+			e.buf.Write(en.k)
+			e.buf.Write(en.v)
+		}
+	case reflect.Struct:
+		fields := cborFields(v.Type())
+		present := make([]reflect.Value, len(fields))
+		n := 0
+		for i, f := range fields {
+			fv := v.FieldByIndex(f.index)
+			if f.omitEmpty && fv.IsZero() {
+				continue
+			}
+			present[i] = fv
+			n++
+		}
+		e.head(cborMap, uint64(n))
+		for i, f := range fields {
+			if !present[i].IsValid() {
+				continue
+			}
+			e.head(cborText, uint64(len(f.name)))
+			e.buf.WriteString(f.name)
+			if err := e.encode(present[i]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("cbor: unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+type cborDecoder struct {
+	b   []byte
+	off int
+}
+
+// head reads the initial bytes of an item, returning its major type, additional information, and argument.
+func (d *cborDecoder) head() (major, info byte, n uint64, err error) {
+	if d.off >= len(d.b) {
+		return 0, 0, 0, errCBORTruncated
+	}
+	ib := d.b[d.off]
+	d.off++
+	major, info = ib>>5, ib&0x1f
+	var size int
+	switch {
+	case info < 24:
+		return major, info, uint64(info), nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	case info == cborIndefinite:
+		if major == cborUint || major == cborNegInt || major == cborTag {
+			return 0, 0, 0, fmt.Errorf("cbor: invalid indefinite length for major type %d", major)
+		}
+		return major, info, 0, nil
+	default:
+		return 0, 0, 0, fmt.Errorf("cbor: invalid additional information %d", info)
+	}
+	if len(d.b)-d.off < size {
+		return 0, 0, 0, errCBORTruncated
+	}
+	for _, b := range d.b[d.off : d.off+size] {
+		n = n<<8 | uint64(b)
+	}
+	d.off += size
+	return major, info, n, nil
+}
+
+// length checks that a definite length of n items of at least one byte each could fit in the remaining data.
+func (d *cborDecoder) length(n uint64) (int, error) {
+	if n > uint64(len(d.b)-d.off) {
+		return 0, errCBORTruncated
+	}
+	return int(n), nil
+}
+
+// atBreak consumes a break code if it is next.
+func (d *cborDecoder) atBreak() (bool, error) {
+	if d.off >= len(d.b) {
+		return false, errCBORTruncated
+	}
+	if d.b[d.off] == cborBreak {
+		d.off++
+		return true, nil
+	}
+	return false, nil
+}
+
+// str reads the content of a byte or text string whose head has been read.
+func (d *cborDecoder) str(major, info byte, n uint64) ([]byte, error) {
+	if info != cborIndefinite {
+		l, err := d.length(n)
+		if err != nil {
+			return nil, err
+		}
+		s := d.b[d.off : d.off+l]
+		d.off += l
+		return s, nil
+	}
+	// An indefinite-length string is a sequence of definite-length chunks of the same type
+	var s []byte
+	for {
+		if brk, err := d.atBreak(); err != nil {
+			return nil, err
+		} else if brk {
+			return s, nil
+		}
+		cm, ci, cn, err := d.head()
+		if err != nil {
+			return nil, err
+		}
+		if cm != major || ci == cborIndefinite {
+			return nil, errors.New("cbor: invalid indefinite-length string chunk")
+		}
+		chunk, err := d.str(cm, ci, cn)
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, chunk...)
+	}
+}
+
+func (d *cborDecoder) decode(v reflect.Value, depth int) error {
+	if depth > cborMaxDepth {
+		return errors.New("cbor: maximum nesting depth exceeded")
+	}
+	if d.off >= len(d.b) {
+		return errCBORTruncated
+	}
+	if ib := d.b[d.off]; ib == cborNull || ib == cborUndefined {
+		d.off++
+		switch v.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+			v.Set(reflect.Zero(v.Type()))
+		}
+		return nil
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.decode(v.Elem(), depth)
+	}
+	if v.Kind() == reflect.Interface && v.NumMethod() == 0 {
+		x, err := d.decodeAny(depth)
+		if err != nil {
+			return err
+		}
+		if x == nil {
+			v.Set(reflect.Zero(v.Type()))
+		} else {
+			v.Set(reflect.ValueOf(x))
+		}
+		return nil
+	}
+
+	major, info, n, err := d.head()
+	if err != nil {
+		return err
+	}
+	mismatch := func(what string) error {
+		return fmt.Errorf("cbor: can't decode %s into %s", what, v.Type())
+	}
+
+	if major == cborText && v.CanAddr() && v.Addr().Type().Implements(textUnmarshalerType) {
+		s, err := d.str(major, info, n)
+		if err != nil {
+			return err
+		}
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText(s)
+	}
+
+	switch major {
+	case cborUint, cborNegInt:
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if n > math.MaxInt64 {
+				return mismatch("integer")
+			}
+			i := int64(n)
+			if major == cborNegInt {
+				i = -1 - i
+			}
+			if v.OverflowInt(i) {
+				return mismatch("integer")
+			}
+			v.SetInt(i)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if major == cborNegInt || v.OverflowUint(n) {
+				return mismatch("integer")
+			}
+			v.SetUint(n)
+		case reflect.Float32, reflect.Float64:
+			f := float64(n)
+			if major == cborNegInt {
+				f = -1 - f
+			}
+			v.SetFloat(f)
+		default:
+			return mismatch("integer")
+		}
+	case cborBytes, cborText:
+		s, err := d.str(major, info, n)
+		if err != nil {
+			return err
+		}
+		switch {
+		case v.Kind() == reflect.String:
+			v.SetString(string(s))
+		case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+			v.SetBytes(append([]byte(nil), s...))
+		default:
+			return mismatch("string")
+		}
+	case cborArray:
+		if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+			return mismatch("array")
+		}
+		if v.Kind() == reflect.Slice {
+			v.Set(v.Slice(0, 0))
+		}
+		for i := 0; ; i++ {
+			if info == cborIndefinite {
+				if brk, err := d.atBreak(); err != nil {
+					return err
+				} else if brk {
+					break
+				}
+			} else if uint64(i) >= n {
+				break
+			}
+			if v.Kind() == reflect.Slice {
+				v.Set(reflect.Append(v, reflect.Zero(v.Type().Elem())))
+			} else if i >= v.Len() {
+				if _, err := d.decodeAny(depth + 1); err != nil { // discard excess elements
+					return err
+				}
+				continue
+			}
+			if err := d.decode(v.Index(i), depth+1); err != nil {
+				return err
+			}
+		}
+	case cborMap:
+		return d.decodeMap(v, info, n, depth)
+	case cborTag:
+		return d.decode(v, depth+1)
+	case cborSimple:
+		switch {
+		case info == cborFalse&0x1f || info == cborTrue&0x1f:
+			if v.Kind() != reflect.Bool {
+				return mismatch("boolean")
+			}
+			v.SetBool(info == cborTrue&0x1f)
+		case info >= 25 && info <= 27:
+			if v.Kind() != reflect.Float32 && v.Kind() != reflect.Float64 {
+				return mismatch("float")
+			}
+			v.SetFloat(cborFloat(info, n))
+		default:
+			return mismatch("simple value")
+		}
+	}
+	return nil
+}
+
+func (d *cborDecoder) decodeMap(v reflect.Value, info byte, n uint64, depth int) error {
+	var fields map[string]cborField
+	switch v.Kind() {
+	case reflect.Map:
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+	case reflect.Struct:
+		fs := cborFields(v.Type())
+		fields = make(map[string]cborField, len(fs))
+		for _, f := range fs {
+			fields[f.name] = f
+		}
+	default:
+		return fmt.Errorf("cbor: can't decode map into %s", v.Type())
+	}
+
+	for i := uint64(0); ; i++ {
+		if info == cborIndefinite {
+			if brk, err := d.atBreak(); err != nil {
+				return err
+			} else if brk {
+				return nil
+			}
+		} else if i >= n {
+			return nil
+		}
+
+		if v.Kind() == reflect.Map {
+			k := reflect.New(v.Type().Key()).Elem()
+			if err := d.decode(k, depth+1); err != nil {
+				return err
+			}
+			e := reflect.New(v.Type().Elem()).Elem()
+			if err := d.decode(e, depth+1); err != nil {
+				return err
+			}
+			v.SetMapIndex(k, e)
+			continue
+		}
+
+		var name string
+		if err := d.decode(reflect.ValueOf(&name).Elem(), depth+1); err != nil {
+			return err
+		}
+		f, ok := fields[name]
+		if !ok {
+			for fn, ff := range fields {
+				if strings.EqualFold(fn, name) {
+					f, ok = ff, true
+					break
+				}
+			}
+		}
+		if !ok {
+			if _, err := d.decodeAny(depth + 1); err != nil { // skip unknown fields
+				return err
+			}
+			continue
+		}
+		fv := v
+		for _, idx := range f.index {
+			fv = fv.Field(idx)
+		}
+		if err := d.decode(fv, depth+1); err != nil {
+			return err
+		}
+	}
+}
+
+// decodeAny decodes the next item into a generic Go value.
+func (d *cborDecoder) decodeAny(depth int) (interface{}, error) {
+	if depth > cborMaxDepth {
+		return nil, errors.New("cbor: maximum nesting depth exceeded")
+	}
+	major, info, n, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case cborUint:
+		if n > math.MaxInt64 {
+			return n, nil
+		}
+		return int64(n), nil
+	case cborNegInt:
+		if n > math.MaxInt64 {
+			return -1 - float64(n), nil
+		}
+		return -1 - int64(n), nil
+	case cborBytes:
+		s, err := d.str(major, info, n)
+		return append([]byte(nil), s...), err
+	case cborText:
+		s, err := d.str(major, info, n)
+		return string(s), err
+	case cborArray:
+		a := []interface{}{}
+		for i := uint64(0); ; i++ {
+			if info == cborIndefinite {
+				if brk, err := d.atBreak(); err != nil {
+					return nil, err
+				} else if brk {
+					return a, nil
+				}
+			} else if i >= n {
+				return a, nil
+			}
+			x, err := d.decodeAny(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, x)
+		}
+	case cborMap:
+		m := map[interface{}]interface{}{}
+		textKeys := true
+		for i := uint64(0); ; i++ {
+			if info == cborIndefinite {
+				if brk, err := d.atBreak(); err != nil {
+					return nil, err
+				} else if brk {
+					break
+				}
+			} else if i >= n {
+				break
+			}
+			k, err := d.decodeAny(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			x, err := d.decodeAny(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := k.(string); !ok {
+				textKeys = false
+			}
+			if !reflect.TypeOf(k).Comparable() {
+				return nil, errors.New("cbor: unsupported map key type")
+			}
+			m[k] = x
+		}
+		if !textKeys {
+			return m, nil
+		}
+		sm := make(map[string]interface{}, len(m))
+		for k, x := range m {
+			sm[k.(string)] = x
+		}
+		return sm, nil
+	case cborTag:
+		return d.decodeAny(depth + 1)
+	default: // cborSimple
+		switch {
+		case info == cborFalse&0x1f:
+			return false, nil
+		case info == cborTrue&0x1f:
+			return true, nil
+		case info == cborNull&0x1f, info == cborUndefined&0x1f:
+			return nil, nil
+		case info >= 25 && info <= 27:
+			return cborFloat(info, n), nil
+		case info == cborIndefinite:
+			return nil, errors.New("cbor: unexpected break")
+		}
+		return n, nil // an unassigned simple value
+	}
+}
+
+// cborFloat converts the argument of a half-, single- or double-precision float item to a float64.
+func cborFloat(info byte, n uint64) float64 {
+	switch info {
+	case 25:
+		// IEEE 754 half precision: 1 sign bit, 5 exponent bits, 10 mantissa bits
+		h := uint16(n)
+		exp, mant := int(h>>10)&0x1f, float64(h&0x3ff)
+		var f float64
+		switch exp {
+		case 0:
+			f = math.Ldexp(mant, -24)
+		case 31:
+			if mant == 0 {
+				f = math.Inf(1)
+			} else {
+				f = math.NaN()
+			}
+		default:
+			f = math.Ldexp(mant+1024, exp-25)
+		}
+		if h&0x8000 != 0 {
+			f = -f
+		}
+		return f
+	case 26:
+		return float64(math.Float32frombits(uint32(n)))
+	default:
+		return math.Float64frombits(n)
+	}
+}
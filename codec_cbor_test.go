@@ -0,0 +1,153 @@
+package libhttp
+
+import (
+	"context"
+	"encoding/hex"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCBORVectors(t *testing.T) {
+	t.Parallel()
+
+	// Examples from RFC 8949 Appendix A
+	cases := []struct {
+		v   interface{}
+		hex string
+	}{
+		{0, "00"},
+		{23, "17"},
+		{24, "1818"},
+		{1000, "1903e8"},
+		{uint64(18446744073709551615), "1bffffffffffffffff"},
+		{-1, "20"},
+		{-1000, "3903e7"},
+		{1.1, "fb3ff199999999999a"},
+		{float32(100000.0), "fa47c35000"},
+		{false, "f4"},
+		{true, "f5"},
+		{nil, "f6"},
+		{"", "60"},
+		{"IETF", "6449455446"},
+		{"ü", "62c3bc"},
+		{[]byte{1, 2, 3, 4}, "4401020304"},
+		{[]int{1, 2, 3}, "83010203"},
+		{map[string]interface{}{"a": 1, "b": []int{2, 3}}, "a26161016162820203"}}
+	for _, c := range cases {
+		b, err := cborCodec{}.Marshal(c.v)
+		require.NoError(t, err)
+		assert.Equal(t, c.hex, hex.EncodeToString(b), "%#v", c.v)
+	}
+
+	decode := func(h string) interface{} {
+		b, err := hex.DecodeString(h)
+		require.NoError(t, err)
+		var v interface{}
+		require.NoError(t, cborCodec{}.Unmarshal(b, &v), h)
+		return v
+	}
+	assert.Equal(t, int64(1000), decode("1903e8"))
+	assert.Equal(t, int64(-1000), decode("3903e7"))
+	assert.Equal(t, 1.5, decode("f93e00"))                                                          // half precision
+	assert.Equal(t, 65504.0, decode("f97bff"))                                                      // largest half
+	assert.True(t, math.IsInf(decode("f97c00").(float64), 1))                                       // half infinity
+	assert.Equal(t, "2013-03-21T20:04:00Z", decode("c074323031332d30332d32315432303a30343a30305a")) // tag 0
+	assert.Equal(t, "streaming", decode("7f657374726561646d696e67ff"))                              // indefinite text
+	assert.Equal(t, []interface{}{int64(1), []interface{}{int64(2), int64(3)}, []interface{}{int64(4), int64(5)}},
+		decode("9f018202039f0405ffff"))
+	assert.Equal(t, map[string]interface{}{"a": int64(1), "b": []interface{}{int64(2), int64(3)}},
+		decode("bf61610161629f0203ffff"))
+	assert.Equal(t, map[interface{}]interface{}{int64(1): int64(2)}, decode("a10102"))
+}
+
+func TestCBORStructs(t *testing.T) {
+	t.Parallel()
+
+	type inner struct {
+		When time.Time `json:"when"`
+	}
+	type payload struct {
+		inner
+		Name    string            `json:"name"`
+		Temp    float64           `cbor:"t"`
+		Count   *int              `json:"count,omitempty"`
+		Tags    []string          `json:"tags"`
+		Meta    map[string]string `json:"meta"`
+		Skipped string            `json:"-"`
+		Raw     []byte
+	}
+	n := 3
+	in := payload{
+		inner:   inner{When: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)},
+		Name:    "sensor",
+		Temp:    21.5,
+		Count:   &n,
+		Tags:    []string{"a", "b"},
+		Meta:    map[string]string{"k": "v"},
+		Skipped: "x",
+		Raw:     []byte{0xde, 0xad}}
+	b, err := cborCodec{}.Marshal(in)
+	require.NoError(t, err)
+
+	out := payload{}
+	require.NoError(t, cborCodec{}.Unmarshal(b, &out))
+	assert.True(t, in.When.Equal(out.When))
+	assert.Equal(t, in.Name, out.Name)
+	assert.Equal(t, in.Temp, out.Temp)
+	require.NotNil(t, out.Count)
+	assert.Equal(t, 3, *out.Count)
+	assert.Equal(t, in.Tags, out.Tags)
+	assert.Equal(t, in.Meta, out.Meta)
+	assert.Empty(t, out.Skipped)
+	assert.Equal(t, in.Raw, out.Raw)
+
+	var generic map[string]interface{}
+	require.NoError(t, cborCodec{}.Unmarshal(b, &generic))
+	assert.Equal(t, "sensor", generic["name"])
+	assert.Equal(t, 21.5, generic["t"])
+
+	// Type mismatches, truncation and trailing data are errors
+	var s string
+	assert.Error(t, cborCodec{}.Unmarshal([]byte{0x01}, &s))
+	var u uint8
+	assert.Error(t, cborCodec{}.Unmarshal([]byte{0x19, 0x01, 0x00}, &u))
+	assert.Error(t, cborCodec{}.Unmarshal([]byte{0x20}, &u))
+	assert.Error(t, cborCodec{}.Unmarshal([]byte{0x64, 'a'}, &s))
+	assert.Error(t, cborCodec{}.Unmarshal([]byte{0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, &generic))
+	assert.Error(t, cborCodec{}.Unmarshal([]byte{0x01, 0x02}, &u))
+}
+
+func TestCBORNegotiation(t *testing.T) {
+	t.Parallel()
+
+	type reading struct {
+		Temp float64 `json:"temp"`
+	}
+	req := NewRequest(context.Background(), "POST", "/", nil)
+	req.EncodeCBOR(reading{Temp: 20})
+	assert.Equal(t, "application/cbor", req.Header.Get("Content-Type"))
+	in := reading{}
+	require.NoError(t, req.Decode(&in))
+	assert.Equal(t, 20.0, in.Temp)
+
+	// A CBOR request gets a CBOR response…
+	rsp := req.Response(reading{Temp: 21})
+	assert.Equal(t, "application/cbor", rsp.Header.Get("Content-Type"))
+	out := reading{}
+	require.NoError(t, rsp.Decode(&out))
+	assert.Equal(t, 21.0, out.Temp)
+
+	// …unless it asks for something else
+	req.Header.Set("Accept", "application/json")
+	rsp = req.Response(reading{Temp: 21})
+	assert.Equal(t, "application/json", rsp.Header.Get("Content-Type"))
+
+	req = NewRequest(context.Background(), "GET", "/", nil)
+	req.Header.Set("Accept", "application/cbor")
+	rsp = req.Response(reading{Temp: 22})
+	assert.Equal(t, "application/cbor", rsp.Header.Get("Content-Type"))
+}
@@ -0,0 +1,316 @@
+package libhttp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// This file implements MessagePack (https://msgpack.org) for the codec registry. Its data model is a subset of CBOR's,
+// so values are mapped exactly as the CBOR codec maps them (structs become maps keyed by their cbor or json tags, and so
+// on): encoding transcodes the CBOR encoding of a value, and decoding transcodes MessagePack to CBOR before decoding
+// that. Extension types (including timestamps) aren't supported.
+
+func init() {
+	RegisterCodec(msgpackCodec{}, "application/x-msgpack", "application/vnd.msgpack")
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string { return "application/msgpack" }
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	c, err := cborCodec{}.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	d, e := &cborDecoder{b: c}, &msgpackEncoder{}
+	if err := e.fromCBOR(d, 0); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+func (msgpackCodec) Unmarshal(b []byte, v interface{}) error {
+	d, e := &msgpackDecoder{b: b}, &cborEncoder{}
+	if err := d.toCBOR(e, 0); err != nil {
+		return err
+	}
+	if d.off != len(d.b) {
+		return errors.New("msgpack: unexpected data after top-level item")
+	}
+	return cborCodec{}.Unmarshal(e.buf.Bytes(), v)
+}
+
+// EncodeMsgPack serialises the passed object as MessagePack into the body (and sets appropriate headers).
+func (r *Request) EncodeMsgPack(v interface{}) {
+	r.encodeWith(msgpackCodec{}, v)
+}
+
+// EncodeMsgPack serialises the passed object as MessagePack into the body (and sets appropriate headers).
+func (r *Response) EncodeMsgPack(v interface{}) {
+	r.encodeWith(msgpackCodec{}, v)
+}
+
+var errMsgPackTruncated = errors.New("msgpack: unexpected end of data")
+
+type msgpackEncoder struct {
+	buf []byte
+}
+
+// head writes a type byte followed by an n byte big-endian argument.
+func (e *msgpackEncoder) head(typ byte, size int, n uint64) {
+	e.buf = append(e.buf, typ)
+	for i := size - 1; i >= 0; i-- {
+		e.buf = append(e.buf, byte(n>>(8*uint(i))))
+	}
+}
+
+// length writes the header of a string, binary, array or map of length n, choosing the smallest format of the fix,
+// 8, 16 and 32 bit variants passed (0 where a variant doesn't exist).
+func (e *msgpackEncoder) length(n uint64, fix byte, fixMax uint64, t8, t16, t32 byte) error {
+	switch {
+	case fix != 0 && n <= fixMax:
+		e.buf = append(e.buf, fix|byte(n))
+	case t8 != 0 && n <= math.MaxUint8:
+		e.head(t8, 1, n)
+	case n <= math.MaxUint16:
+		e.head(t16, 2, n)
+	case n <= math.MaxUint32:
+		e.head(t32, 4, n)
+	default:
+		return errors.New("msgpack: item too long")
+	}
+	return nil
+}
+
+// fromCBOR transcodes the next CBOR item. Only the definite-length items produced by the CBOR codec are supported.
+func (e *msgpackEncoder) fromCBOR(d *cborDecoder, depth int) error {
+	if depth > cborMaxDepth {
+		return errors.New("msgpack: maximum nesting depth exceeded")
+	}
+	major, info, n, err := d.head()
+	if err != nil {
+		return err
+	}
+	if info == cborIndefinite && major != cborSimple {
+		return errors.New("msgpack: indefinite-length items are not supported")
+	}
+	switch major {
+	case cborUint:
+		switch {
+		case n <= math.MaxInt8:
+			e.buf = append(e.buf, byte(n))
+		case n <= math.MaxUint8:
+			e.head(0xcc, 1, n)
+		case n <= math.MaxUint16:
+			e.head(0xcd, 2, n)
+		case n <= math.MaxUint32:
+			e.head(0xce, 4, n)
+		default:
+			e.head(0xcf, 8, n)
+		}
+	case cborNegInt:
+		if n > math.MaxInt64 {
+			return errors.New("msgpack: integer out of range")
+		}
+		i := -1 - int64(n)
+		switch {
+		case i >= -32:
+			e.buf = append(e.buf, byte(i))
+		case i >= math.MinInt8:
+			e.head(0xd0, 1, uint64(i))
+		case i >= math.MinInt16:
+			e.head(0xd1, 2, uint64(i))
+		case i >= math.MinInt32:
+			e.head(0xd2, 4, uint64(i))
+		default:
+			e.head(0xd3, 8, uint64(i))
+		}
+	case cborBytes, cborText:
+		s, err := d.str(major, info, n)
+		if err != nil {
+			return err
+		}
+		if major == cborBytes {
+			err = e.length(uint64(len(s)), 0, 0, 0xc4, 0xc5, 0xc6)
+		} else {
+			err = e.length(uint64(len(s)), 0xa0, 31, 0xd9, 0xda, 0xdb)
+		}
+		if err != nil {
+			return err
+		}
+		e.buf = append(e.buf, s...)
+	case cborArray, cborMap:
+		items := n
+		if major == cborArray {
+			err = e.length(n, 0x90, 15, 0, 0xdc, 0xdd)
+		} else {
+			err = e.length(n, 0x80, 15, 0, 0xde, 0xdf)
+			items *= 2
+		}
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < items; i++ {
+			if err := e.fromCBOR(d, depth+1); err != nil {
+				return err
+			}
+		}
+	case cborTag:
+		return e.fromCBOR(d, depth+1)
+	case cborSimple:
+		switch {
+		case info == cborFalse&0x1f:
+			e.buf = append(e.buf, 0xc2)
+		case info == cborTrue&0x1f:
+			e.buf = append(e.buf, 0xc3)
+		case info == cborNull&0x1f, info == cborUndefined&0x1f:
+			e.buf = append(e.buf, 0xc0)
+		case info == 25 || info == 26:
+			e.head(0xca, 4, uint64(math.Float32bits(float32(cborFloat(info, n)))))
+		case info == 27:
+			e.head(0xcb, 8, n)
+		default:
+			return fmt.Errorf("msgpack: unsupported CBOR simple value %d", info)
+		}
+	}
+	return nil
+}
+
+type msgpackDecoder struct {
+	b   []byte
+	off int
+}
+
+// uint reads an n byte big-endian integer.
+func (d *msgpackDecoder) uint(size int) (uint64, error) {
+	if len(d.b)-d.off < size {
+		return 0, errMsgPackTruncated
+	}
+	var n uint64
+	for _, b := range d.b[d.off : d.off+size] {
+		n = n<<8 | uint64(b)
+	}
+	d.off += size
+	return n, nil
+}
+
+// toCBOR transcodes the next MessagePack item.
+func (d *msgpackDecoder) toCBOR(e *cborEncoder, depth int) error {
+	if depth > cborMaxDepth {
+		return errors.New("msgpack: maximum nesting depth exceeded")
+	}
+	if d.off >= len(d.b) {
+		return errMsgPackTruncated
+	}
+	t := d.b[d.off]
+	d.off++
+
+	// The argument of each type: the size in bytes of its length or value which follows, or the value itself
+	var major byte
+	size, n := 0, uint64(0)
+	switch {
+	case t <= 0x7f:
+		major, n = cborUint, uint64(t)
+	case t >= 0xe0:
+		major, n = cborNegInt, uint64(-1-int64(int8(t)))
+	case t <= 0x8f:
+		major, n = cborMap, uint64(t&0x0f)
+	case t <= 0x9f:
+		major, n = cborArray, uint64(t&0x0f)
+	case t <= 0xbf:
+		major, n = cborText, uint64(t&0x1f)
+	case t == 0xc0:
+		e.buf.WriteByte(cborNull)
+		return nil
+	case t == 0xc2:
+		e.buf.WriteByte(cborFalse)
+		return nil
+	case t == 0xc3:
+		e.buf.WriteByte(cborTrue)
+		return nil
+	case t >= 0xc4 && t <= 0xc6:
+		major, size = cborBytes, 1<<(t-0xc4)
+	case t == 0xca || t == 0xcb:
+		size = 4 << (t - 0xca)
+		bits, err := d.uint(size)
+		if err != nil {
+			return err
+		}
+		b := make([]byte, size)
+		if size == 4 {
+			e.buf.WriteByte(cborSimple<<5 | 26)
+			binary.BigEndian.PutUint32(b, uint32(bits))
+		} else {
+			e.buf.WriteByte(cborSimple<<5 | 27)
+			binary.BigEndian.PutUint64(b, bits)
+		}
+		e.buf.Write(b)
+		return nil
+	case t >= 0xcc && t <= 0xcf:
+		v, err := d.uint(1 << (t - 0xcc))
+		if err != nil {
+			return err
+		}
+		e.head(cborUint, v)
+		return nil
+	case t >= 0xd0 && t <= 0xd3:
+		size = 1 << (t - 0xd0)
+		v, err := d.uint(size)
+		if err != nil {
+			return err
+		}
+		i := int64(v<<(64-8*uint(size))) >> (64 - 8*uint(size)) // sign extend
+		if i >= 0 {
+			e.head(cborUint, uint64(i))
+		} else {
+			e.head(cborNegInt, uint64(-1-i))
+		}
+		return nil
+	case t >= 0xd9 && t <= 0xdb:
+		major, size = cborText, 1<<(t-0xd9)
+	case t == 0xdc || t == 0xdd:
+		major, size = cborArray, 2<<(t-0xdc)
+	case t == 0xde || t == 0xdf:
+		major, size = cborMap, 2<<(t-0xde)
+	case t == 0xc1:
+		return errors.New("msgpack: invalid type 0xc1")
+	default: // 0xc7-0xc9 and 0xd4-0xd8
+		return errors.New("msgpack: extension types are not supported")
+	}
+	if size > 0 {
+		var err error
+		if n, err = d.uint(size); err != nil {
+			return err
+		}
+	}
+
+	switch major {
+	case cborUint, cborNegInt:
+		e.head(major, n)
+	case cborBytes, cborText:
+		if n > uint64(len(d.b)-d.off) {
+			return errMsgPackTruncated
+		}
+		e.head(major, n)
+		e.buf.Write(d.b[d.off : d.off+int(n)])
+		d.off += int(n)
+	case cborArray, cborMap:
+		items := n
+		if major == cborMap {
+			items *= 2
+		}
+		if items > uint64(len(d.b)-d.off) { // each item is at least a byte
+			return errMsgPackTruncated
+		}
+		e.head(major, n)
+		for i := uint64(0); i < items; i++ {
+			if err := d.toCBOR(e, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package libhttp
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMsgPackVectors(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		v   interface{}
+		hex string
+	}{
+		{0, "00"},
+		{127, "7f"},
+		{128, "cc80"},
+		{256, "cd0100"},
+		{65536, "ce00010000"},
+		{uint64(1) << 32, "cf0000000100000000"},
+		{-1, "ff"},
+		{-32, "e0"},
+		{-33, "d0df"},
+		{-129, "d1ff7f"},
+		{-32769, "d2ffff7fff"},
+		{1.1, "cb3ff199999999999a"},
+		{float32(100000.0), "ca47c35000"},
+		{false, "c2"},
+		{true, "c3"},
+		{nil, "c0"},
+		{"", "a0"},
+		{"IETF", "a449455446"},
+		{strings.Repeat("a", 32), "d920" + strings.Repeat("61", 32)},
+		{[]byte{1, 2, 3, 4}, "c40401020304"},
+		{[]int{1, 2, 3}, "93010203"},
+		{map[string]interface{}{"a": 1, "b": []int{2, 3}}, "82a16101a162920203"}}
+	for _, c := range cases {
+		b, err := msgpackCodec{}.Marshal(c.v)
+		require.NoError(t, err)
+		assert.Equal(t, c.hex, hex.EncodeToString(b), "%#v", c.v)
+	}
+
+	decode := func(h string) interface{} {
+		b, err := hex.DecodeString(h)
+		require.NoError(t, err)
+		var v interface{}
+		require.NoError(t, msgpackCodec{}.Unmarshal(b, &v), h)
+		return v
+	}
+	assert.Equal(t, int64(-33), decode("d0df"))
+	assert.Equal(t, int64(-2), decode("d3fffffffffffffffe"))
+	assert.Equal(t, int64(256), decode("cd0100"))
+	assert.Equal(t, int64(5), decode("d005")) // a positive signed integer
+	assert.Equal(t, 1.5, decode("ca3fc00000"))
+	assert.Equal(t, "hi", decode("d9026869"))
+	assert.Equal(t, []byte("hi"), decode("c4026869"))
+	assert.Equal(t, []interface{}{int64(1), nil}, decode("dc000201c0"))
+	assert.Equal(t, map[string]interface{}{"a": true}, decode("de0001a161c3"))
+
+	// Reserved and extension types, truncation and trailing data are errors
+	var v interface{}
+	for _, h := range []string{"c1", "d40102", "a261", "ddffffffff", "0101", "cd01"} {
+		b, _ := hex.DecodeString(h)
+		assert.Error(t, msgpackCodec{}.Unmarshal(b, &v), h)
+	}
+}
+
+func TestMsgPackNegotiation(t *testing.T) {
+	t.Parallel()
+
+	type reading struct {
+		When time.Time         `json:"when"`
+		Temp float64           `json:"temp"`
+		Meta map[string]string `json:"meta"`
+	}
+	in := reading{
+		When: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Temp: 20.5,
+		Meta: map[string]string{"k": "v"}}
+	req := NewRequest(context.Background(), "POST", "/", nil)
+	req.EncodeMsgPack(in)
+	assert.Equal(t, "application/msgpack", req.Header.Get("Content-Type"))
+	out := reading{}
+	require.NoError(t, req.Decode(&out))
+	assert.True(t, in.When.Equal(out.When))
+	assert.Equal(t, in.Temp, out.Temp)
+	assert.Equal(t, in.Meta, out.Meta)
+
+	for _, accept := range []string{"application/msgpack", "application/x-msgpack", "application/vnd.msgpack"} {
+		req := NewRequest(context.Background(), "GET", "/", nil)
+		req.Header.Set("Accept", accept)
+		rsp := req.Response(map[string]float64{"temp": 21})
+		require.NoError(t, rsp.Error)
+		assert.Equal(t, "application/msgpack", rsp.Header.Get("Content-Type"), accept)
+		v := map[string]float64{}
+		require.NoError(t, rsp.Decode(&v))
+		assert.Equal(t, 21.0, v["temp"])
+	}
+}
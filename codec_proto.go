@@ -0,0 +1,56 @@
+package libhttp
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/runtime/protoiface"
+	"google.golang.org/protobuf/runtime/protoimpl"
+)
+
+func init() {
+	RegisterCodec(protoCodec{}, "application/x-protobuf")
+}
+
+// protoCodec serialises protocol buffer messages in their binary wire format. Both current (APIv2) messages and those
+// generated by older versions of protoc-gen-go are supported.
+type protoCodec struct{}
+
+func (protoCodec) ContentType() string { return "application/protobuf" }
+
+func (protoCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := protoMessage(v)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a protobuf message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (protoCodec) Unmarshal(b []byte, v interface{}) error {
+	m, ok := protoMessage(v)
+	if !ok {
+		return fmt.Errorf("%T is not a protobuf message", v)
+	}
+	return proto.Unmarshal(b, m)
+}
+
+// protoMessage returns v as an APIv2 protobuf message, if it is a message at all.
+func protoMessage(v interface{}) (proto.Message, bool) {
+	switch m := v.(type) {
+	case proto.Message:
+		return m, true
+	case protoiface.MessageV1:
+		return protoimpl.X.ProtoMessageV2Of(m), true
+	}
+	return nil, false
+}
+
+// EncodeProto serialises the passed protobuf message into the body (and sets appropriate headers).
+func (r *Request) EncodeProto(m protoiface.MessageV1) {
+	r.encodeWith(protoCodec{}, m)
+}
+
+// EncodeProto serialises the passed protobuf message into the body (and sets appropriate headers).
+func (r *Response) EncodeProto(m protoiface.MessageV1) {
+	r.encodeWith(protoCodec{}, m)
+}
@@ -0,0 +1,49 @@
+package libhttp
+
+import (
+	"context"
+	"testing"
+
+	"github.com/monzo/terrors/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProtobuf(t *testing.T) {
+	t.Parallel()
+
+	msg := &terrorsproto.Error{
+		Code:    "bad_request",
+		Message: "hello",
+		Params:  map[string]string{"a": "b"}}
+	req := NewRequest(context.Background(), "POST", "/", nil)
+	req.EncodeProto(msg)
+	assert.Equal(t, "application/protobuf", req.Header.Get("Content-Type"))
+	out := &terrorsproto.Error{}
+	require.NoError(t, req.Decode(out))
+	assert.Equal(t, msg.Code, out.Code)
+	assert.Equal(t, msg.Params, out.Params)
+
+	// A protobuf request gets a protobuf response
+	req = NewRequest(context.Background(), "POST", "/", nil)
+	req.EncodeProto(msg)
+	rsp := req.Response(msg)
+	assert.Equal(t, "application/protobuf", rsp.Header.Get("Content-Type"))
+	out = &terrorsproto.Error{}
+	require.NoError(t, rsp.Decode(out))
+	assert.Equal(t, msg.Message, out.Message)
+
+	// …unless it asks for something else
+	req.Header.Set("Accept", "application/json")
+	rsp = req.Response(msg)
+	assert.Equal(t, "application/json", rsp.Header.Get("Content-Type"))
+
+	req = NewRequest(context.Background(), "GET", "/", nil)
+	req.Header.Set("Accept", "application/json;q=0.5, application/x-protobuf")
+	rsp = req.Response(msg)
+	assert.Equal(t, "application/protobuf", rsp.Header.Get("Content-Type"))
+
+	// Non-messages can't be encoded as protobuf
+	_, err := protoCodec{}.Marshal("not a message")
+	assert.Error(t, err)
+}
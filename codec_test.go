@@ -0,0 +1,157 @@
+package libhttp
+
+import (
+	"context"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type codecTestDoc struct {
+	XMLName xml.Name `xml:"doc" json:"-"`
+	Name    string   `xml:"name" json:"name"`
+}
+
+func TestXML(t *testing.T) {
+	t.Parallel()
+
+	req := NewRequest(context.Background(), "POST", "/", nil)
+	req.EncodeXML(codecTestDoc{Name: "a"})
+	assert.Equal(t, "application/xml", req.Header.Get("Content-Type"))
+	b, err := req.BodyBytes(false)
+	require.NoError(t, err)
+	assert.Equal(t, xml.Header+"<doc><name>a</name></doc>", string(b))
+
+	// Decode picks the codec from the Content-Type
+	v := codecTestDoc{}
+	require.NoError(t, req.Decode(&v))
+	assert.Equal(t, "a", v.Name)
+
+	rsp := req.Response(nil)
+	rsp.EncodeXML(codecTestDoc{Name: "b"})
+	assert.Equal(t, "application/xml", rsp.Header.Get("Content-Type"))
+	v = codecTestDoc{}
+	require.NoError(t, rsp.Decode(&v))
+	assert.Equal(t, "b", v.Name)
+
+	// DecodeXML ignores the Content-Type
+	req = NewRequest(context.Background(), "POST", "/", strings.NewReader("<doc><name>c</name></doc>"))
+	v = codecTestDoc{}
+	require.NoError(t, req.DecodeXML(&v))
+	assert.Equal(t, "c", v.Name)
+}
+
+func TestCodecLookup(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, xmlCodec{}, codecFor("text/xml; charset=utf-8"))
+	assert.Equal(t, xmlCodec{}, codecFor("application/atom+xml"))
+	assert.Equal(t, jsonCodec{}, codecFor("application/problem+json"))
+	assert.Equal(t, jsonCodec{}, codecFor(""))
+	assert.Equal(t, jsonCodec{}, codecFor("text/plain"))
+	assert.Nil(t, lookupCodec("text/plain"))
+}
+
+func TestResponseNegotiation(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		accept, contentType, expected string
+	}{
+		{"", "", "application/json"},
+		{"", "application/xml", "application/xml"},
+		{"", "text/plain", "application/json"},
+		{"application/xml", "", "application/xml"},
+		{"text/xml", "", "application/xml"},
+		{"application/cbor", "application/xml", "application/cbor"},
+		{"application/xml;q=0.5, application/cbor", "", "application/cbor"},
+		{"application/xml;q=0.9, application/json;q=0.8", "", "application/xml"},
+		{"application/json;q=0, application/xml", "", "application/xml"},
+		{"*/*;q=0.1, application/xml", "", "application/xml"},
+		{"*/*", "application/xml", "application/json"},
+		{"application/*", "", "application/json"},
+		{"text/*", "", "application/xml"}, // text/xml is an alias of the XML codec
+		{"image/png", "", "application/json"},
+		{"application/protobuf", "", "application/json"}, // not a message
+		{"garbage;;;", "", "application/json"}}
+	for _, c := range cases {
+		req := NewRequest(context.Background(), "GET", "/", nil)
+		if c.accept != "" {
+			req.Header.Set("Accept", c.accept)
+		}
+		if c.contentType != "" {
+			req.Header.Set("Content-Type", c.contentType)
+		}
+		rsp := req.Response(codecTestDoc{Name: "a"})
+		require.NoError(t, rsp.Error)
+		assert.Equal(t, c.expected, rsp.Header.Get("Content-Type"), "Accept: %s, Content-Type: %s", c.accept,
+			c.contentType)
+		assert.Equal(t, "Accept", rsp.Header.Get("Vary"))
+
+		out := codecTestDoc{}
+		require.NoError(t, rsp.Decode(&out))
+		assert.Equal(t, "a", out.Name)
+	}
+}
+
+func TestResponseNegotiationUnencodable(t *testing.T) {
+	t.Parallel()
+
+	// XML can't encode maps or anonymous structs, so they're sent in the next most preferred encoding, or as JSON
+	cases := []struct {
+		accept, contentType, expected string
+		body                          interface{}
+	}{
+		{"application/xml", "", "application/json", map[string]string{"name": "a"}},
+		{"", "application/xml", "application/json", map[string]string{"name": "a"}},
+		{"application/xml, application/cbor;q=0.5", "", "application/cbor", map[string]string{"name": "a"}},
+		{"application/xhtml+xml", "", "application/json", codecTestDoc{Name: "a"}}, // suffixes aren't matched
+		{"text/*", "", "application/json", struct {
+			Name string `json:"name" cbor:"name"`
+		}{"a"}}}
+	for _, c := range cases {
+		req := NewRequest(context.Background(), "GET", "/", nil)
+		if c.accept != "" {
+			req.Header.Set("Accept", c.accept)
+		}
+		if c.contentType != "" {
+			req.Header.Set("Content-Type", c.contentType)
+		}
+		rsp := req.Response(c.body)
+		require.NoError(t, rsp.Error)
+		assert.Equal(t, c.expected, rsp.Header.Get("Content-Type"), "Accept: %s, Content-Type: %s", c.accept,
+			c.contentType)
+		out := map[string]string{}
+		require.NoError(t, rsp.Decode(&out))
+		assert.Equal(t, "a", out["name"])
+	}
+}
+
+func TestResponseNegotiationBrowser(t *testing.T) {
+	t.Parallel()
+
+	// Browsers accept XML, but not as much as HTML or XHTML, which no codec produces; values which can't be encoded as
+	// XML are sent as JSON (rather than failing), as they are for */*
+	accept := "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+	for _, c := range []struct {
+		body     interface{}
+		expected string
+	}{
+		{codecTestDoc{Name: "a"}, "application/xml"},
+		{map[string]string{"name": "a"}, "application/json"},
+		{struct {
+			Name string `json:"name"`
+		}{"a"}, "application/json"}} {
+		req := NewRequest(context.Background(), "GET", "/", nil)
+		req.Header.Set("Accept", accept)
+		rsp := req.Response(c.body)
+		require.NoError(t, rsp.Error)
+		assert.Equal(t, c.expected, rsp.Header.Get("Content-Type"), "%T", c.body)
+		out := codecTestDoc{}
+		require.NoError(t, rsp.Decode(&out))
+		assert.Equal(t, "a", out.Name)
+	}
+}
@@ -0,0 +1,77 @@
+package libhttp
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// A ContextKey identifies a value carried in a request's context. Unlike an arbitrary key passed to
+// context.WithValue, it can't collide with keys from other packages (each key is distinct, even if they have the same
+// name), and it checks the type of the values stored under it, so values can be asserted back to that type safely:
+//
+//  var userKey = libhttp.NewContextKey("user", (*User)(nil))
+//
+//  func authFilter(req libhttp.Request, svc libhttp.Service) libhttp.Response {
+//      user, err := authenticate(req)
+//      ...
+//      return svc(libhttp.SetValue(req, userKey, user))
+//  }
+//
+//  func userForRequest(req libhttp.Request) *User {
+//      v, _ := libhttp.Value(req, userKey)
+//      return v.(*User) // can't panic: only *Users (or nil) are stored under userKey
+//  }
+type ContextKey struct {
+	name string
+	typ  reflect.Type
+}
+
+// NewContextKey returns a new key, for values of the same type as example (typically a nil pointer or a zero value).
+// If example is nil, values of any type may be stored under the key.
+func NewContextKey(name string, example interface{}) *ContextKey {
+	return &ContextKey{
+		name: name,
+		typ:  reflect.TypeOf(example)}
+}
+
+func (k *ContextKey) String() string {
+	if k.typ == nil {
+		return fmt.Sprintf("libhttp.ContextKey(%s)", k.name)
+	}
+	return fmt.Sprintf("libhttp.ContextKey(%s %v)", k.name, k.typ)
+}
+
+// SetValue returns a copy of the request whose context carries v under key. It panics if v isn't of the key's type;
+// this is a programming error, like a failed type assertion.
+func SetValue(req Request, key *ContextKey, v interface{}) Request {
+	if key.typ != nil && v != nil && !reflect.TypeOf(v).AssignableTo(key.typ) {
+		panic(fmt.Sprintf("libhttp: value of type %T can't be stored under %v", v, key))
+	}
+	if req.Context == nil {
+		req.Context = context.Background()
+	}
+	req.Context = context.WithValue(req.Context, key, v)
+	return req
+}
+
+// Value returns the value carried under key in the request's context, and whether there was one. A value which is
+// present is always of the key's type, or nil.
+func Value(req Request, key *ContextKey) (interface{}, bool) {
+	if req.Context == nil {
+		return zeroValue(key), false
+	}
+	v := req.Context.Value(key)
+	if v == nil {
+		return zeroValue(key), false
+	}
+	return v, true
+}
+
+// zeroValue returns the zero value of the key's type, so a missing value can still be asserted to it.
+func zeroValue(key *ContextKey) interface{} {
+	if key.typ == nil {
+		return nil
+	}
+	return reflect.Zero(key.typ).Interface()
+}
@@ -0,0 +1,52 @@
+package libhttp
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContextKey(t *testing.T) {
+	t.Parallel()
+
+	type user struct {
+		name string
+	}
+	userKey := NewContextKey("user", (*user)(nil))
+	otherKey := NewContextKey("user", (*user)(nil))
+	anyKey := NewContextKey("any", nil)
+
+	req := NewRequest(context.Background(), "GET", "/", nil)
+	v, ok := Value(req, userKey)
+	assert.False(t, ok)
+	assert.Nil(t, v.(*user)) // a missing value can still be asserted to the key's type
+
+	u := &user{
+		name: "bob"}
+	req2 := SetValue(req, userKey, u)
+	v, ok = Value(req2, userKey)
+	assert.True(t, ok)
+	assert.Equal(t, u, v.(*user))
+	// Keys with the same name are distinct, and the original request is unchanged
+	_, ok = Value(req2, otherKey)
+	assert.False(t, ok)
+	_, ok = Value(req, userKey)
+	assert.False(t, ok)
+
+	assert.Panics(t, func() {
+		SetValue(req, userKey, "bob")
+	})
+	req2 = SetValue(req2, anyKey, 42)
+	v, ok = Value(req2, anyKey)
+	assert.True(t, ok)
+	assert.Equal(t, 42, v)
+
+	// Requests without a context
+	_, ok = Value(Request{}, userKey)
+	assert.False(t, ok)
+	v, ok = Value(SetValue(Request{}, userKey, u), userKey)
+	assert.True(t, ok)
+	assert.Equal(t, u, v)
+	assert.Nil(t, RouterForRequest(Request{}))
+}
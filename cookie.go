@@ -0,0 +1,98 @@
+package libhttp
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/monzo/terrors"
+)
+
+// A CookieOption relaxes the defaults applied by Response.SetCookie.
+type CookieOption func(*cookieOptions)
+
+type cookieOptions struct {
+	allowScripts bool
+	insecure     bool
+}
+
+// CookieAllowScripts allows the cookie to be read by scripts in the page, by not setting HttpOnly. This is needed for
+// double-submit CSRF tokens, for example.
+func CookieAllowScripts() CookieOption {
+	return func(o *cookieOptions) {
+		o.allowScripts = true
+	}
+}
+
+// CookieInsecure allows the cookie to be sent over plain HTTP, even though the request it is set in response to was
+// made over TLS.
+func CookieInsecure() CookieOption {
+	return func(o *cookieOptions) {
+		o.insecure = true
+	}
+}
+
+// CookieValue returns the value of the named cookie sent with the request, or a bad request error if there is none.
+// (Request.Cookie and Request.Cookies, from the embedded http.Request, return the cookies themselves.)
+func (r Request) CookieValue(name string) (string, error) {
+	c, err := r.Cookie(name)
+	if err != nil {
+		return "", terrors.BadRequest("missing_cookie", fmt.Sprintf("Cookie %q is required", name),
+			map[string]string{
+				"cookie": name})
+	}
+	return c.Value, nil
+}
+
+// SetCookie adds a Set-Cookie header to the response. Unless options say otherwise, the cookie is:
+//
+//  HttpOnly            so that it can't be read by scripts
+//  Secure              if the request was made over TLS (or if SameSite is None, which browsers require)
+//  SameSite=Lax        if the cookie doesn't set a SameSite mode
+//  Path=/              if the cookie doesn't set a path
+//
+// Invalid cookies result in an internal service error on the response.
+func (r *Response) SetCookie(c http.Cookie, opts ...CookieOption) {
+	if r.Response == nil {
+		r.Response = newHTTPResponse(Request{})
+	}
+	o := cookieOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if c.Path == "" {
+		c.Path = "/"
+	}
+	if c.SameSite == 0 {
+		c.SameSite = http.SameSiteLaxMode
+	}
+	c.HttpOnly = c.HttpOnly || !o.allowScripts
+	tls := r.Request != nil && r.Request.TLS != nil
+	c.Secure = c.Secure || (tls && !o.insecure) || c.SameSite == http.SameSiteNoneMode
+
+	v := c.String()
+	if v == "" || !validCookieValue(c.Value) {
+		r.Error = terrors.InternalService("invalid_cookie", fmt.Sprintf("Cookie %q is invalid", c.Name), nil)
+		return
+	}
+	r.Header.Add("Set-Cookie", v)
+}
+
+// DeleteCookie tells the client to discard the named cookie, which must have been set with the default path (/).
+func (r *Response) DeleteCookie(name string) {
+	r.SetCookie(http.Cookie{
+		Name:    name,
+		MaxAge:  -1,
+		Expires: time.Unix(0, 0)})
+}
+
+// validCookieValue returns whether v can be sent as a cookie value unchanged; http.Cookie.String silently drops
+// invalid bytes, which would corrupt the value.
+func validCookieValue(v string) bool {
+	for i := 0; i < len(v); i++ {
+		if b := v[i]; b < 0x20 || b >= 0x7f || b == '"' || b == ';' || b == '\\' {
+			return false
+		}
+	}
+	return true
+}
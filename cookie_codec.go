@@ -0,0 +1,208 @@
+package libhttp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/monzo/terrors"
+)
+
+// maxCookieBytes is the largest encoded cookie value a CookieCodec produces; browsers are only required to store 4096
+// bytes for a whole cookie.
+const maxCookieBytes = 4000
+
+var (
+	errCookieInvalid = errors.New("cookie is invalid or was not produced with a current key")
+	errCookieExpired = errors.New("cookie has expired")
+	cookieB64        = base64.RawURLEncoding
+)
+
+// A CookieCodecOption configures a CookieCodec.
+type CookieCodecOption func(*CookieCodec)
+
+// CookieEncrypt makes a CookieCodec encrypt values (with AES-GCM) as well as authenticate them, so clients can't read
+// them.
+func CookieEncrypt() CookieCodecOption {
+	return func(c *CookieCodec) {
+		c.encrypt = true
+	}
+}
+
+// CookieMaxAge makes a CookieCodec reject values it encoded longer ago than d, regardless of how long the client
+// keeps the cookie.
+func CookieMaxAge(d time.Duration) CookieCodecOption {
+	return func(c *CookieCodec) {
+		c.maxAge = d
+	}
+}
+
+// A CookieCodec protects cookie values from tampering, so that state can be stored on the client. Values are
+// authenticated with HMAC-SHA256 and optionally encrypted with AES-256-GCM; both are bound to the cookie's name, so a
+// value can't be moved from one cookie to another.
+//
+// A codec has a list of secret keys. New values are always produced with the first, but values produced with any of
+// them are accepted, so keys can be rotated by adding a new key to the front of the list and removing the oldest once
+// the cookies it produced have expired.
+type CookieCodec struct {
+	keys    []cookieKey
+	encrypt bool
+	maxAge  time.Duration
+	now     func() time.Time
+}
+
+type cookieKey struct {
+	mac  []byte
+	aead cipher.AEAD
+}
+
+// NewCookieCodec returns a CookieCodec using the passed secret keys, the first of which is current. Keys must be at
+// least 32 bytes, and should be random.
+func NewCookieCodec(keys [][]byte, opts ...CookieCodecOption) (*CookieCodec, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("a cookie codec needs at least one key")
+	}
+	c := &CookieCodec{
+		now: time.Now}
+	for _, opt := range opts {
+		opt(c)
+	}
+	for _, secret := range keys {
+		if len(secret) < 32 {
+			return nil, errors.New("cookie keys must be at least 32 bytes")
+		}
+		// Separate keys are derived for signing and encryption, so the same secret can safely be used for both
+		k := cookieKey{
+			mac: deriveCookieKey(secret, "libhttp cookie signing")}
+		block, err := aes.NewCipher(deriveCookieKey(secret, "libhttp cookie encryption"))
+		if err != nil {
+			return nil, err
+		}
+		if k.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+		c.keys = append(c.keys, k)
+	}
+	return c, nil
+}
+
+func deriveCookieKey(secret []byte, purpose string) []byte {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte(purpose))
+	return m.Sum(nil)
+}
+
+// Encode protects value for storage in the named cookie.
+func (c *CookieCodec) Encode(name string, value []byte) (string, error) {
+	// The payload is the time of encoding (so that a maximum age can be enforced) followed by the value
+	payload := make([]byte, 8, 8+len(value))
+	binary.BigEndian.PutUint64(payload, uint64(c.now().Unix()))
+	payload = append(payload, value...)
+
+	k := c.keys[0]
+	var encoded string
+	if c.encrypt {
+		nonce := make([]byte, k.aead.NonceSize(), k.aead.NonceSize()+len(payload)+k.aead.Overhead())
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return "", err
+		}
+		encoded = cookieB64.EncodeToString(k.aead.Seal(nonce, nonce, payload, []byte(name)))
+	} else {
+		encoded = cookieB64.EncodeToString(payload) + "." + cookieB64.EncodeToString(cookieMAC(k, name, payload))
+	}
+	if len(name)+len(encoded) > maxCookieBytes {
+		return "", fmt.Errorf("cookie %q is too large (%d bytes encoded)", name, len(encoded))
+	}
+	return encoded, nil
+}
+
+// Decode verifies (and if necessary decrypts) a value produced by Encode for the named cookie.
+func (c *CookieCodec) Decode(name, encoded string) ([]byte, error) {
+	var payload []byte
+	if c.encrypt {
+		b, err := cookieB64.DecodeString(encoded)
+		if err != nil {
+			return nil, errCookieInvalid
+		}
+		for _, k := range c.keys {
+			if n := k.aead.NonceSize(); len(b) >= n {
+				if p, err := k.aead.Open(nil, b[:n], b[n:], []byte(name)); err == nil {
+					payload = p
+					break
+				}
+			}
+		}
+	} else {
+		parts := strings.Split(encoded, ".")
+		if len(parts) != 2 {
+			return nil, errCookieInvalid
+		}
+		p, err1 := cookieB64.DecodeString(parts[0])
+		mac, err2 := cookieB64.DecodeString(parts[1])
+		if err1 != nil || err2 != nil {
+			return nil, errCookieInvalid
+		}
+		for _, k := range c.keys {
+			if hmac.Equal(mac, cookieMAC(k, name, p)) {
+				payload = p
+				break
+			}
+		}
+	}
+	if len(payload) < 8 {
+		return nil, errCookieInvalid
+	}
+	if c.maxAge > 0 {
+		encodedAt := time.Unix(int64(binary.BigEndian.Uint64(payload)), 0)
+		if c.now().Sub(encodedAt) > c.maxAge {
+			return nil, errCookieExpired
+		}
+	}
+	return payload[8:], nil
+}
+
+func cookieMAC(k cookieKey, name string, payload []byte) []byte {
+	m := hmac.New(sha256.New, k.mac)
+	m.Write([]byte(name))
+	m.Write([]byte{0})
+	m.Write(payload)
+	return m.Sum(nil)
+}
+
+// SecureCookieValue returns the value of the named cookie sent with the request, which must have been set with
+// Response.SetSecureCookie using the same codec. Missing, tampered-with and expired cookies result in bad request
+// errors.
+func (r Request) SecureCookieValue(codec *CookieCodec, name string) (string, error) {
+	encoded, err := r.CookieValue(name)
+	if err != nil {
+		return "", err
+	}
+	v, err := codec.Decode(name, encoded)
+	if err != nil {
+		return "", terrors.BadRequest("invalid_cookie", fmt.Sprintf("Cookie %q: %v", name, err),
+			map[string]string{
+				"cookie": name})
+	}
+	return string(v), nil
+}
+
+// SetSecureCookie sets a cookie in the manner of SetCookie, with its value protected by the passed codec.
+func (r *Response) SetSecureCookie(codec *CookieCodec, c http.Cookie, opts ...CookieOption) {
+	encoded, err := codec.Encode(c.Name, []byte(c.Value))
+	if err != nil {
+		r.Error = terrors.Wrap(err, nil)
+		return
+	}
+	c.Value = encoded
+	r.SetCookie(c, opts...)
+}
@@ -0,0 +1,114 @@
+package libhttp
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCookieCodec(t *testing.T) {
+	t.Parallel()
+
+	oldKey, newKey := bytes.Repeat([]byte("o"), 32), bytes.Repeat([]byte("n"), 32)
+	for _, encrypt := range []bool{false, true} {
+		var opts []CookieCodecOption
+		if encrypt {
+			opts = append(opts, CookieEncrypt())
+		}
+		old, err := NewCookieCodec([][]byte{oldKey}, opts...)
+		require.NoError(t, err)
+		rotated, err := NewCookieCodec([][]byte{newKey, oldKey}, opts...)
+		require.NoError(t, err)
+		retired, err := NewCookieCodec([][]byte{newKey}, opts...)
+		require.NoError(t, err)
+
+		encoded, err := old.Encode("session", []byte("user=42"))
+		require.NoError(t, err)
+		assert.Equal(t, encrypt, !strings.Contains(encoded, ".")) // encrypted values are opaque
+
+		v, err := old.Decode("session", encoded)
+		require.NoError(t, err)
+		assert.Equal(t, "user=42", string(v))
+
+		// Values produced with old keys are accepted until the key is removed
+		v, err = rotated.Decode("session", encoded)
+		require.NoError(t, err)
+		assert.Equal(t, "user=42", string(v))
+		_, err = retired.Decode("session", encoded)
+		assert.Error(t, err)
+		encoded2, err := rotated.Encode("session", []byte("user=42"))
+		require.NoError(t, err)
+		_, err = retired.Decode("session", encoded2)
+		assert.NoError(t, err)
+
+		// Values are bound to the cookie's name, and can't be tampered with
+		_, err = old.Decode("other", encoded)
+		assert.Error(t, err)
+		tampered := []byte(encoded)
+		tampered[3] ^= 1
+		_, err = old.Decode("session", string(tampered))
+		assert.Error(t, err)
+		_, err = old.Decode("session", "")
+		assert.Error(t, err)
+	}
+
+	_, err := NewCookieCodec(nil)
+	assert.Error(t, err)
+	_, err = NewCookieCodec([][]byte{[]byte("short")})
+	assert.Error(t, err)
+	_, err = NewCookieCodec([][]byte{newKey, bytes.Repeat([]byte("k"), 31)})
+	assert.Error(t, err)
+}
+
+func TestCookieCodecMaxAge(t *testing.T) {
+	t.Parallel()
+
+	c, err := NewCookieCodec([][]byte{bytes.Repeat([]byte("k"), 32)}, CookieMaxAge(time.Hour))
+	require.NoError(t, err)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	encoded, err := c.Encode("session", []byte("v"))
+	require.NoError(t, err)
+
+	now = now.Add(59 * time.Minute)
+	_, err = c.Decode("session", encoded)
+	assert.NoError(t, err)
+	now = now.Add(2 * time.Minute)
+	_, err = c.Decode("session", encoded)
+	assert.Error(t, err)
+
+	_, err = c.Encode("session", bytes.Repeat([]byte("v"), 4000))
+	assert.Error(t, err)
+}
+
+func TestSecureCookies(t *testing.T) {
+	t.Parallel()
+
+	codec, err := NewCookieCodec([][]byte{bytes.Repeat([]byte("k"), 32)}, CookieEncrypt())
+	require.NoError(t, err)
+	rsp := NewRequest(context.Background(), "GET", "/", nil).Response(nil)
+	rsp.SetSecureCookie(codec, http.Cookie{Name: "prefs", Value: "theme=dark"})
+	require.NoError(t, rsp.Error)
+	set := (&http.Response{Header: rsp.Header}).Cookies()
+	require.Len(t, set, 1)
+	assert.True(t, set[0].HttpOnly)
+	assert.NotContains(t, set[0].Value, "dark")
+
+	req := NewRequest(context.Background(), "GET", "/", nil)
+	req.AddCookie(set[0])
+	v, err := req.SecureCookieValue(codec, "prefs")
+	require.NoError(t, err)
+	assert.Equal(t, "theme=dark", v)
+
+	req = NewRequest(context.Background(), "GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: "prefs", Value: "theme=dark"})
+	_, err = req.SecureCookieValue(codec, "prefs")
+	assert.True(t, terrors.Is(err, terrors.ErrBadRequest))
+}
@@ -0,0 +1,178 @@
+package libhttp
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/monzo/slog"
+	"github.com/monzo/terrors"
+)
+
+// WithCookieJar makes the client keep cookies in the jar: cookies set by responses are stored in it, and those which
+// apply to a request are added to it, so multi-step flows against services which use cookies (logging in, then
+// making requests with the session cookie, say) work without handling the cookies by hand. Cookies are matched to
+// requests by their URLs as sent to the client, before any filters change them.
+func WithCookieJar(jar http.CookieJar) ClientOption {
+	return func(o *clientOptions) {
+		o.jar = jar
+	}
+}
+
+// cookieJarFilter adds cookies from the jar to requests, and stores those set by their responses.
+func cookieJarFilter(jar http.CookieJar) Filter {
+	return func(req Request, svc Service) Response {
+		if req.URL == nil {
+			return svc(req)
+		}
+		u := req.URL
+		cookies := jar.Cookies(u)
+		if len(cookies) > 0 {
+			req.Header = req.Header.Clone()
+			for _, c := range cookies {
+				req.AddCookie(c)
+			}
+		}
+		rsp := svc(req)
+		if rsp.Response != nil {
+			if cookies := rsp.Cookies(); len(cookies) > 0 {
+				jar.SetCookies(u, cookies)
+			}
+		}
+		return rsp
+	}
+}
+
+// NewCookieJar returns an in-memory cookie jar, for use with WithCookieJar.
+//
+// The jar doesn't know the public suffix list, so it accepts cookies for domains like co.uk which browsers would
+// reject. This doesn't matter for the services a client is written to talk to, but the jar shouldn't be used to crawl
+// arbitrary sites.
+func NewCookieJar() http.CookieJar {
+	jar, _ := cookiejar.New(nil) // which never fails
+	return jar
+}
+
+// jarRecord is a cookie set in a PersistentCookieJar, with the URL it was set for.
+type jarRecord struct {
+	URL    string      `json:"url"`
+	Cookie http.Cookie `json:"cookie"`
+}
+
+// A PersistentCookieJar is a cookie jar which is saved to a file, so that cookies (a login session, say) outlive the
+// program: a command-line tool can log in once, and use the session on later runs. Session cookies (those without
+// an expiry) are saved too. The file is rewritten whenever cookies are set, and contains the cookies' values in
+// plain text, so it should be kept somewhere private.
+//
+// Like NewCookieJar's, the jar doesn't know the public suffix list.
+type PersistentCookieJar struct {
+	path    string
+	m       sync.Mutex
+	jar     http.CookieJar
+	records map[string]jarRecord // by URL host, and the cookie's name, domain and path
+}
+
+// NewPersistentCookieJar returns a jar which is saved to the file at path, loading the cookies already saved there if
+// it exists.
+func NewPersistentCookieJar(path string) (*PersistentCookieJar, error) {
+	j := &PersistentCookieJar{
+		path:    path,
+		jar:     NewCookieJar(),
+		records: map[string]jarRecord{}}
+	b, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		return j, nil
+	case err != nil:
+		return nil, terrors.Wrap(err, nil)
+	}
+	var records []jarRecord
+	if err := json.Unmarshal(b, &records); err != nil {
+		return nil, terrors.Wrap(err, map[string]string{
+			"path": path})
+	}
+	for _, r := range records {
+		u, err := url.Parse(r.URL)
+		if err != nil {
+			continue
+		}
+		j.record(u, r.Cookie)
+		j.jar.SetCookies(u, []*http.Cookie{&r.Cookie})
+	}
+	return j, nil
+}
+
+// Cookies returns the cookies to send in a request for the URL.
+func (j *PersistentCookieJar) Cookies(u *url.URL) []*http.Cookie {
+	return j.jar.Cookies(u)
+}
+
+// SetCookies stores the cookies received in a response for the URL, and saves the jar. Errors saving it are logged.
+func (j *PersistentCookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.jar.SetCookies(u, cookies)
+	j.m.Lock()
+	for _, c := range cookies {
+		j.record(u, *c)
+	}
+	j.m.Unlock()
+	if err := j.Save(); err != nil {
+		slog.Warn(nil, "Couldn't save cookies to %s: %v", j.path, err)
+	}
+}
+
+// record remembers a cookie, replacing any earlier one it overrides. Relative expiry times are made absolute, so they
+// still mean the same when the jar is loaded again.
+func (j *PersistentCookieJar) record(u *url.URL, c http.Cookie) {
+	if c.MaxAge > 0 {
+		c.Expires = time.Now().Add(time.Duration(c.MaxAge) * time.Second)
+		c.MaxAge = 0
+	}
+	c.Raw = ""
+	key := u.Hostname() + "\x00" + c.Name + "\x00" + c.Domain + "\x00" + c.Path
+	j.records[key] = jarRecord{
+		URL: (&url.URL{
+			Scheme: u.Scheme,
+			Host:   u.Host,
+			Path:   u.Path}).String(),
+		Cookie: c}
+}
+
+// Save writes the jar's cookies to its file, omitting those which have expired or been deleted. The file is replaced
+// atomically, so it is never left partially written.
+func (j *PersistentCookieJar) Save() error {
+	j.m.Lock()
+	now := time.Now()
+	records := make([]jarRecord, 0, len(j.records))
+	for key, r := range j.records {
+		if r.Cookie.MaxAge < 0 || (!r.Cookie.Expires.IsZero() && !r.Cookie.Expires.After(now)) {
+			delete(j.records, key)
+			continue
+		}
+		records = append(records, r)
+	}
+	j.m.Unlock()
+
+	b, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return terrors.Wrap(err, nil)
+	}
+	f, err := ioutil.TempFile(filepath.Dir(j.path), filepath.Base(j.path)+".*")
+	if err != nil {
+		return terrors.Wrap(err, nil)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return terrors.Wrap(err, nil)
+	}
+	if err := f.Close(); err != nil {
+		return terrors.Wrap(err, nil)
+	}
+	return terrors.Wrap(os.Rename(f.Name(), j.path), nil)
+}
@@ -0,0 +1,117 @@
+package libhttp
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func cookieServer(t *testing.T) string {
+	router := &Router{}
+	router.Register("*", "/login", func(req Request) Response {
+		rsp := req.Response(nil)
+		rsp.SetCookie(http.Cookie{Name: "session", Value: "s3cret", MaxAge: 3600})
+		rsp.SetCookie(http.Cookie{Name: "flash", Value: "hello", MaxAge: 3600})
+		return rsp
+	})
+	router.Register("*", "/logout", func(req Request) Response {
+		rsp := req.Response(nil)
+		rsp.DeleteCookie("session")
+		return rsp
+	})
+	router.Register("*", "/me", func(req Request) Response {
+		if v, err := req.CookieValue("session"); err != nil || v != "s3cret" {
+			rsp := req.Response(nil)
+			rsp.StatusCode = http.StatusUnauthorized
+			return rsp
+		}
+		return req.Response(map[string]string{"user": "alice"})
+	})
+	s, err := Listen(router.Serve(), "localhost:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Stop(context.Background()) })
+	return "http://" + s.Listener().Addr().String()
+}
+
+func TestWithCookieJar(t *testing.T) {
+	t.Parallel()
+	base := cookieServer(t)
+	ctx := context.Background()
+
+	rsp := NewRequest(ctx, "GET", base+"/me", nil).SendVia(NewClient()).Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusUnauthorized, rsp.StatusCode)
+
+	client := NewClient(WithCookieJar(NewCookieJar()))
+	rsp = NewRequest(ctx, "GET", base+"/me", nil).SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusUnauthorized, rsp.StatusCode)
+
+	rsp = NewRequest(ctx, "POST", base+"/login", nil).SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+	rsp = NewRequest(ctx, "GET", base+"/me", nil).SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+
+	rsp = NewRequest(ctx, "POST", base+"/logout", nil).SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+	rsp = NewRequest(ctx, "GET", base+"/me", nil).SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusUnauthorized, rsp.StatusCode)
+}
+
+func TestPersistentCookieJar(t *testing.T) {
+	t.Parallel()
+	base := cookieServer(t)
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cookies.json")
+
+	jar, err := NewPersistentCookieJar(path)
+	require.NoError(t, err)
+	rsp := NewRequest(ctx, "POST", base+"/login", nil).SendVia(NewClient(WithCookieJar(jar))).Response()
+	require.NoError(t, rsp.Error)
+
+	// A new jar loaded from the file has the session
+	jar, err = NewPersistentCookieJar(path)
+	require.NoError(t, err)
+	client := NewClient(WithCookieJar(jar))
+	rsp = NewRequest(ctx, "GET", base+"/me", nil).SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+
+	// Deleted cookies stay deleted
+	rsp = NewRequest(ctx, "POST", base+"/logout", nil).SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+	jar, err = NewPersistentCookieJar(path)
+	require.NoError(t, err)
+	u, _ := url.Parse(base)
+	cookies := jar.Cookies(u)
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "flash", cookies[0].Name)
+}
+
+func TestPersistentCookieJarExpiry(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "cookies.json")
+	u, _ := url.Parse("https://example.com/a")
+
+	jar, err := NewPersistentCookieJar(path)
+	require.NoError(t, err)
+	jar.SetCookies(u, []*http.Cookie{
+		{Name: "short", Value: "1", Expires: time.Now().Add(50 * time.Millisecond)},
+		{Name: "long", Value: "2", Expires: time.Now().Add(time.Hour)}})
+	assert.Len(t, jar.Cookies(u), 2)
+
+	time.Sleep(100 * time.Millisecond)
+	jar, err = NewPersistentCookieJar(path)
+	require.NoError(t, err)
+	cookies := jar.Cookies(u)
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "long", cookies[0].Name)
+}
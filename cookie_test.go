@@ -0,0 +1,56 @@
+package libhttp
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCookies(t *testing.T) {
+	t.Parallel()
+
+	req := NewRequest(context.Background(), "GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+	v, err := req.CookieValue("session")
+	require.NoError(t, err)
+	assert.Equal(t, "abc", v)
+	_, err = req.CookieValue("missing")
+	assert.True(t, terrors.Is(err, terrors.ErrBadRequest))
+	c, err := req.Cookie("session")
+	require.NoError(t, err)
+	assert.Equal(t, "abc", c.Value)
+
+	rsp := req.Response(nil)
+	rsp.SetCookie(http.Cookie{Name: "a", Value: "1"})
+	rsp.SetCookie(http.Cookie{Name: "b", Value: "2", Path: "/b", SameSite: http.SameSiteStrictMode},
+		CookieAllowScripts())
+	rsp.SetCookie(http.Cookie{Name: "c", Value: "3", SameSite: http.SameSiteNoneMode})
+	rsp.DeleteCookie("d")
+	assert.Equal(t, []string{
+		"a=1; Path=/; HttpOnly; SameSite=Lax",
+		"b=2; Path=/b; SameSite=Strict",
+		"c=3; Path=/; HttpOnly; Secure; SameSite=None",
+		"d=; Path=/; Expires=Thu, 01 Jan 1970 00:00:00 GMT; Max-Age=0; HttpOnly; SameSite=Lax"},
+		rsp.Header.Values("Set-Cookie"))
+	require.NoError(t, rsp.Error)
+
+	// Cookies set in response to TLS requests are Secure
+	req.TLS = &tls.ConnectionState{}
+	rsp = req.Response(nil)
+	rsp.SetCookie(http.Cookie{Name: "a", Value: "1"})
+	rsp.SetCookie(http.Cookie{Name: "b", Value: "2"}, CookieInsecure())
+	assert.Equal(t, []string{
+		"a=1; Path=/; HttpOnly; Secure; SameSite=Lax",
+		"b=2; Path=/; HttpOnly; SameSite=Lax"},
+		rsp.Header.Values("Set-Cookie"))
+
+	rsp = req.Response(nil)
+	rsp.SetCookie(http.Cookie{Name: "a", Value: "semi;colon"})
+	assert.True(t, terrors.Is(rsp.Error, terrors.ErrInternalService))
+	assert.Empty(t, rsp.Header.Values("Set-Cookie"))
+}
@@ -0,0 +1,83 @@
+package libhttp
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/monzo/terrors"
+)
+
+// TimeoutHeader carries the time a request has left before its caller gives up on it, in milliseconds, so that the
+// deadline holds across services: see WithDeadlinePropagation and DeadlineFilter. A duration is sent rather than a
+// time so that the services' clocks needn't agree.
+const TimeoutHeader = "X-Request-Timeout-Ms"
+
+// WithDeadlinePropagation makes the client tell services how long they have to respond: if a request's context has a
+// deadline (the client's request timeout, or the deadline of the request being served, say), the time remaining is
+// sent in TimeoutHeader. Requests whose deadline has already passed aren't sent; they fail with a timeout error
+// straight away. Each attempt of a retried request is sent with the time then remaining.
+func WithDeadlinePropagation() ClientOption {
+	return func(o *clientOptions) {
+		o.deadlines = true
+	}
+}
+
+// deadlinePropagationFilter sets TimeoutHeader from the request's deadline.
+func deadlinePropagationFilter(req Request, svc Service) Response {
+	if req.Context == nil {
+		return svc(req)
+	}
+	deadline, ok := req.Deadline()
+	if !ok {
+		return svc(req)
+	}
+	remaining := time.Until(deadline)
+	if remaining < time.Millisecond || req.Err() != nil {
+		return Response{
+			Request: &req,
+			Error: terrors.Timeout("deadline_exceeded", "Request deadline passed before it was sent", map[string]string{
+				"deadline": deadline.Format(time.RFC3339Nano)})}
+	}
+	req.Header = req.Header.Clone()
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	req.Header.Set(TimeoutHeader, strconv.FormatInt(int64(remaining/time.Millisecond), 10))
+	return svc(req)
+}
+
+// DeadlineFilter is a server Filter which applies the caller's deadline, sent in TimeoutHeader, to the request's
+// context, so the service stops work which the caller has given up on (and passes the deadline on, with clients
+// created with WithDeadlinePropagation). Requests whose caller had no time left are responded to with a timeout error
+// without being served. Requests without the header are served unchanged.
+func DeadlineFilter(req Request, svc Service) Response {
+	v := req.Header.Get(TimeoutHeader)
+	if v == "" {
+		return svc(req)
+	}
+	ms, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return svc(req)
+	}
+	if ms <= 0 {
+		return Response{
+			Request: &req,
+			Error:   terrors.Timeout("deadline_exceeded", "Request deadline has passed", nil)}
+	}
+	parent := req.unwrappedContext()
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(parent, time.Duration(ms)*time.Millisecond)
+	served := req
+	served.Context = ctx
+	rsp := svc(served)
+	if _, ok := rsp.Body.(*bufCloser); ok || rsp.Response == nil {
+		cancel()
+		return rsp
+	}
+	rsp, _ = cancelOnClose(rsp, cancel)
+	return rsp
+}
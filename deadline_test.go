@@ -0,0 +1,96 @@
+package libhttp
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadlinePropagation(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	served := 0
+	backend, err := Listen(Service(func(req Request) Response {
+		served++
+		deadline, ok := req.Deadline()
+		if !ok {
+			return req.Response(int64(-1))
+		}
+		return req.Response(int64(time.Until(deadline) / time.Millisecond))
+	}).Filter(DeadlineFilter).Filter(ErrorFilter), "localhost:0")
+	require.NoError(t, err)
+	defer backend.Stop(ctx)
+	backendURL := "http://" + backend.Listener().Addr().String()
+
+	client := NewClient(WithDeadlinePropagation(), WithClientFilters(ErrorFilter))
+	remaining := func(rsp Response) int64 {
+		require.NoError(t, rsp.Error)
+		var ms int64
+		require.NoError(t, rsp.Decode(&ms))
+		return ms
+	}
+
+	// Without a deadline, there's nothing to propagate
+	assert.Equal(t, int64(-1), remaining(NewRequest(ctx, "GET", backendURL, nil).SendVia(client).Response()))
+
+	// The context's deadline is propagated
+	dctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	ms := remaining(NewRequest(dctx, "GET", backendURL, nil).SendVia(client).Response())
+	assert.True(t, ms > 1000 && ms <= 2000, "%d", ms)
+
+	// So is the client's request timeout, and through a chain of services
+	frontend, err := Listen(Service(func(req Request) Response {
+		return NewRequest(req, "GET", backendURL, nil).SendVia(client).Response()
+	}).Filter(DeadlineFilter).Filter(ErrorFilter), "localhost:0")
+	require.NoError(t, err)
+	defer frontend.Stop(ctx)
+	timeoutClient := NewClient(WithDeadlinePropagation(), WithRequestTimeout(500*time.Millisecond),
+		WithClientFilters(ErrorFilter))
+	ms = remaining(NewRequest(ctx, "GET", "http://"+frontend.Listener().Addr().String(), nil).SendVia(timeoutClient).
+		Response())
+	assert.True(t, ms > 0 && ms <= 500, "%d", ms)
+
+	// Requests which are out of time aren't sent
+	before := served
+	dctx, cancel = context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	time.Sleep(5 * time.Millisecond)
+	rsp := NewRequest(dctx, "GET", backendURL, nil).SendVia(client).Response()
+	require.Error(t, rsp.Error)
+	assert.True(t, terrors.PrefixMatches(rsp.Error, terrors.ErrTimeout))
+	assert.Equal(t, before, served)
+}
+
+func TestDeadlineFilter(t *testing.T) {
+	t.Parallel()
+	svc := Service(func(req Request) Response {
+		_, ok := req.Deadline()
+		return req.Response(ok)
+	}).Filter(DeadlineFilter)
+	for _, c := range []struct {
+		header      string
+		hasDeadline bool
+	}{{"", false}, {"junk", false}, {"250", true}} {
+		req := NewRequest(context.Background(), "GET", "/", nil)
+		if c.header != "" {
+			req.Header.Set(TimeoutHeader, c.header)
+		}
+		rsp := svc(req)
+		require.NoError(t, rsp.Error)
+		var ok bool
+		require.NoError(t, rsp.Decode(&ok))
+		assert.Equal(t, c.hasDeadline, ok, c.header)
+	}
+
+	req := NewRequest(context.Background(), "GET", "/", nil)
+	req.Header.Set(TimeoutHeader, strconv.Itoa(0))
+	rsp := svc(req)
+	require.Error(t, rsp.Error)
+	assert.True(t, terrors.PrefixMatches(rsp.Error, terrors.ErrTimeout))
+}
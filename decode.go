@@ -0,0 +1,143 @@
+package libhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+
+	"github.com/monzo/terrors"
+)
+
+// A DecodeOption configures how a request body is decoded.
+type DecodeOption func(*decodeOptions)
+
+type decodeOptions struct {
+	strict   bool
+	maxBytes int64
+}
+
+func newDecodeOptions(opts []DecodeOption) decodeOptions {
+	o := decodeOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// DecodeStrict rejects bodies containing fields which don't exist in the destination, or data following the encoded
+// value.
+func DecodeStrict() DecodeOption {
+	return func(o *decodeOptions) {
+		o.strict = true
+	}
+}
+
+// DecodeMaxBytes rejects bodies larger than n bytes. Without this option there is no limit.
+func DecodeMaxBytes(n int64) DecodeOption {
+	return func(o *decodeOptions) {
+		o.maxBytes = n
+	}
+}
+
+// readBody consumes and closes the request body, enforcing any size limit.
+func (r Request) readBody(o decodeOptions) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+	defer r.Body.Close()
+	if o.maxBytes <= 0 {
+		return ioutil.ReadAll(r.Body)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(r.Body, o.maxBytes+1))
+	if err == nil && int64(len(b)) > o.maxBytes {
+		return nil, terrors.BadRequest("body_too_large", fmt.Sprintf("Request body exceeds %d bytes", o.maxBytes),
+			map[string]string{
+				"max_bytes": fmt.Sprint(o.maxBytes)})
+	}
+	return b, err
+}
+
+// DecodeJSON de-serialises the JSON body into the passed object. Unlike Decode, its behaviour can be adjusted with
+// options, and failures are reported with messages that are meaningful to the client: the returned errors are bad
+// requests, which ErrorFilter converts to 400 responses.
+func (r Request) DecodeJSON(v interface{}, opts ...DecodeOption) error {
+	o := newDecodeOptions(opts)
+	b, err := r.readBody(o)
+	if err != nil {
+		return terrors.WrapWithCode(err, nil, terrors.ErrBadRequest)
+	}
+	return decodeJSON(b, v, o)
+}
+
+// decodeJSON de-serialises JSON into the passed object, returning bad request errors.
+func decodeJSON(b []byte, v interface{}, o decodeOptions) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if o.strict {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(v); err != nil {
+		return jsonDecodeError(err)
+	}
+	if o.strict {
+		if _, err := dec.Token(); err != io.EOF {
+			return terrors.BadRequest("invalid_json", "Request body must contain a single JSON value", nil)
+		}
+	}
+	return nil
+}
+
+// jsonDecodeError converts an error from encoding/json into a bad request error describing the problem.
+func jsonDecodeError(err error) error {
+	switch err := err.(type) {
+	case *json.SyntaxError:
+		return terrors.BadRequest("invalid_json", fmt.Sprintf("Malformed JSON at offset %d: %v", err.Offset, err),
+			map[string]string{
+				"offset": fmt.Sprint(err.Offset)})
+	case *json.UnmarshalTypeError:
+		if err.Field == "" {
+			return terrors.BadRequest("invalid_json", fmt.Sprintf("Request body: expected %s, got %s",
+				jsonTypeName(err.Type), err.Value), nil)
+		}
+		return terrors.BadRequest("invalid_field", fmt.Sprintf("Field %q: expected %s, got %s", err.Field,
+			jsonTypeName(err.Type), err.Value),
+			map[string]string{
+				"field": err.Field})
+	}
+	switch {
+	case err == io.EOF:
+		return terrors.BadRequest("empty_body", "Request body is empty", nil)
+	case err == io.ErrUnexpectedEOF:
+		return terrors.BadRequest("invalid_json", "Request body contains truncated JSON", nil)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json doesn't export a type for this error
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return terrors.BadRequest("unknown_field", fmt.Sprintf("Unknown field %q", field),
+			map[string]string{
+				"field": field})
+	}
+	return terrors.WrapWithCode(err, nil, terrors.ErrBadRequest)
+}
+
+// jsonTypeName returns the name of the JSON type that a Go type is decoded from.
+func jsonTypeName(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	}
+	return t.Kind().String()
+}
@@ -0,0 +1,53 @@
+package libhttp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestDecodeJSON(t *testing.T) {
+	t.Parallel()
+
+	type input struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+	decode := func(body string, opts ...DecodeOption) (input, error) {
+		req := NewRequest(nil, "POST", "/", strings.NewReader(body))
+		v := input{}
+		err := req.DecodeJSON(&v, opts...)
+		return v, err
+	}
+
+	v, err := decode(`{"name":"a","count":2,"extra":true}`)
+	require.NoError(t, err)
+	assert.Equal(t, input{Name: "a", Count: 2}, v)
+
+	cases := []struct {
+		body string
+		opts []DecodeOption
+		code string
+		msg  string
+	}{
+		{``, nil, "bad_request.empty_body", "Request body is empty"},
+		{`{"name":`, nil, "bad_request.invalid_json", "Request body contains truncated JSON"},
+		{`{"name" "a"}`, nil, "bad_request.invalid_json", "Malformed JSON at offset"},
+		{`{"count":"two"}`, nil, "bad_request.invalid_field", `Field "count": expected number, got string`},
+		{`[]`, nil, "bad_request.invalid_json", "Request body: expected object, got array"},
+		{`{"extra":true}`, []DecodeOption{DecodeStrict()}, "bad_request.unknown_field", `Unknown field "extra"`},
+		{`{} {}`, []DecodeOption{DecodeStrict()}, "bad_request.invalid_json", "single JSON value"},
+		{`{"name":"abcdef"}`, []DecodeOption{DecodeMaxBytes(10)}, "bad_request.body_too_large", "exceeds 10 bytes"},
+	}
+	for _, c := range cases {
+		_, err := decode(c.body, c.opts...)
+		require.Error(t, err, c.body)
+		terr := err.(*terrors.Error)
+		assert.Equal(t, c.code, terr.Code, c.body)
+		assert.Contains(t, terr.Message, c.msg, c.body)
+		assert.Equal(t, 400, ErrorStatusCode(err))
+	}
+}
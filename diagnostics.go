@@ -0,0 +1,132 @@
+package libhttp
+
+import (
+	"crypto/subtle"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// Diagnostics is a snapshot of the state of the process and server, returned by DiagnosticsService.
+type Diagnostics struct {
+	Time        time.Time         `json:"time"`
+	Goroutines  int               `json:"goroutines"`
+	CPUs        int               `json:"cpus"`
+	GOMAXPROCS  int               `json:"gomaxprocs"`
+	Memory      DiagnosticsMemory `json:"memory"`
+	GC          DiagnosticsGC     `json:"gc"`
+	Connections map[string]int    `json:"connections,omitempty"` // by state; see Server.OpenConnections
+	InFlight    *int64            `json:"in_flight_requests,omitempty"`
+}
+
+// DiagnosticsMemory is a summary of runtime.MemStats, in bytes (other than the counts of objects).
+type DiagnosticsMemory struct {
+	Alloc        uint64 `json:"alloc"`
+	TotalAlloc   uint64 `json:"total_alloc"`
+	Sys          uint64 `json:"sys"`
+	HeapAlloc    uint64 `json:"heap_alloc"`
+	HeapInuse    uint64 `json:"heap_inuse"`
+	HeapIdle     uint64 `json:"heap_idle"`
+	HeapReleased uint64 `json:"heap_released"`
+	HeapObjects  uint64 `json:"heap_objects"`
+	StackInuse   uint64 `json:"stack_inuse"`
+	Mallocs      uint64 `json:"mallocs"`
+	Frees        uint64 `json:"frees"`
+}
+
+// DiagnosticsGC is a summary of the garbage collector's statistics. Pauses are the most recent pauses (up to 16),
+// newest first.
+type DiagnosticsGC struct {
+	NumGC         int64     `json:"num_gc"`
+	LastGC        time.Time `json:"last_gc"`
+	NextGC        uint64    `json:"next_gc"`
+	PauseTotalMs  float64   `json:"pause_total_ms"`
+	PausesMs      []float64 `json:"pauses_ms"`
+	GCCPUFraction float64   `json:"gc_cpu_fraction"`
+}
+
+// DiagnosticsAuthToken returns a function for DiagnosticsService which authorises requests bearing the token in their
+// Authorization header ("Bearer <token>").
+func DiagnosticsAuthToken(token string) func(Request) bool {
+	return func(req Request) bool {
+		auth := req.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") || token == "" {
+			return false
+		}
+		return subtle.ConstantTimeCompare([]byte(auth[len("Bearer "):]), []byte(token)) == 1
+	}
+}
+
+// ReadDiagnostics returns a snapshot of the process's state, and of the server's, if s isn't nil. Reading the memory
+// statistics briefly stops the world, so it shouldn't be done often.
+func ReadDiagnostics(s *Server) Diagnostics {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	var gc debug.GCStats
+	debug.ReadGCStats(&gc)
+
+	d := Diagnostics{
+		Time:       time.Now().UTC(),
+		Goroutines: runtime.NumGoroutine(),
+		CPUs:       runtime.NumCPU(),
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		Memory: DiagnosticsMemory{
+			Alloc:        ms.Alloc,
+			TotalAlloc:   ms.TotalAlloc,
+			Sys:          ms.Sys,
+			HeapAlloc:    ms.HeapAlloc,
+			HeapInuse:    ms.HeapInuse,
+			HeapIdle:     ms.HeapIdle,
+			HeapReleased: ms.HeapReleased,
+			HeapObjects:  ms.HeapObjects,
+			StackInuse:   ms.StackInuse,
+			Mallocs:      ms.Mallocs,
+			Frees:        ms.Frees},
+		GC: DiagnosticsGC{
+			NumGC:         gc.NumGC,
+			LastGC:        gc.LastGC.UTC(),
+			NextGC:        ms.NextGC,
+			PauseTotalMs:  durationMs(gc.PauseTotal),
+			PausesMs:      []float64{},
+			GCCPUFraction: ms.GCCPUFraction}}
+	for i, p := range gc.Pause {
+		if i == 16 {
+			break
+		}
+		d.GC.PausesMs = append(d.GC.PausesMs, durationMs(p))
+	}
+	if s != nil {
+		d.Connections = s.OpenConnections()
+		inFlight := s.ActiveRequests()
+		d.InFlight = &inFlight
+	}
+	return d
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// DiagnosticsService returns a Service which responds with a JSON snapshot of the process's goroutines, memory and
+// garbage collection, and the connections and in-flight requests of the server which handled the request, for quick
+// production triage (see ReadDiagnostics).
+//
+// As the snapshot reveals details of the process, requests must be authorised by authorize, which is required;
+// others get a 401 error:
+//
+//  authorize := libhttp.DiagnosticsAuthToken(os.Getenv("DIAGNOSTICS_TOKEN"))
+//  router.GET("/debug/diagnostics", libhttp.DiagnosticsService(authorize))
+func DiagnosticsService(authorize func(Request) bool) Service {
+	return func(req Request) Response {
+		if authorize == nil || !authorize(req) {
+			rsp := req.Response(nil)
+			rsp.Error = Unauthorized("Not authorised to read diagnostics")
+			rsp.Header.Set("WWW-Authenticate", "Bearer")
+			return rsp
+		}
+		rsp := req.Response(ReadDiagnostics(req.server))
+		rsp.Header.Set("Cache-Control", "no-store")
+		return rsp
+	}
+}
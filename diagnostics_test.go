@@ -0,0 +1,62 @@
+package libhttp
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiagnosticsService(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	runtime.GC()
+	s, err := Listen(DiagnosticsService(DiagnosticsAuthToken("sekrit")).Filter(ErrorFilter), "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(ctx)
+	url := "http://" + s.Listener().Addr().String() + "/debug/diagnostics"
+
+	// An idle connection, which should be counted
+	idle, err := net.Dial("tcp", s.Listener().Addr().String())
+	require.NoError(t, err)
+	defer idle.Close()
+
+	rsp := NewRequest(ctx, "GET", url, nil).Send().Response()
+	assert.Equal(t, http.StatusUnauthorized, rsp.StatusCode)
+	req := NewRequest(ctx, "GET", url, nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	assert.Equal(t, http.StatusUnauthorized, req.Send().Response().StatusCode)
+
+	req = NewRequest(ctx, "GET", url, nil)
+	req.Header.Set("Authorization", "Bearer sekrit")
+	rsp = req.Send().Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, "no-store", rsp.Header.Get("Cache-Control"))
+	var d Diagnostics
+	require.NoError(t, rsp.Decode(&d))
+	assert.True(t, d.Goroutines > 0)
+	assert.True(t, d.Memory.HeapAlloc > 0)
+	assert.True(t, d.GC.NumGC > 0)
+	assert.NotEmpty(t, d.GC.PausesMs)
+	require.NotNil(t, d.InFlight)
+	assert.Equal(t, int64(1), *d.InFlight, "the diagnostics request itself")
+	assert.Equal(t, 1, d.Connections["active"])
+	assert.True(t, d.Connections["new"]+d.Connections["idle"] >= 1)
+}
+
+func TestDiagnosticsAuthToken(t *testing.T) {
+	t.Parallel()
+	req := NewRequest(context.Background(), "GET", "/", nil)
+	assert.False(t, DiagnosticsAuthToken("")(req), "an empty token authorises nothing")
+	req.Header.Set("Authorization", "Bearer ")
+	assert.False(t, DiagnosticsAuthToken("")(req))
+	req.Header.Set("Authorization", "Basic abc")
+	assert.False(t, DiagnosticsAuthToken("abc")(req))
+	req.Header.Set("Authorization", "Bearer abc")
+	assert.True(t, DiagnosticsAuthToken("abc")(req))
+	assert.False(t, DiagnosticsService(nil)(req).Error == nil, "authorisation is required")
+}
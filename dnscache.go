@@ -0,0 +1,229 @@
+package libhttp
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A DNSCacheOption configures a DNSCache.
+type DNSCacheOption func(*DNSCache)
+
+// DNSCacheTTL sets how long successful lookups are cached for, when the lookup function doesn't give a TTL. The
+// default is 30 seconds.
+func DNSCacheTTL(d time.Duration) DNSCacheOption {
+	return func(c *DNSCache) {
+		c.ttl = d
+	}
+}
+
+// DNSCacheNegativeTTL sets how long failed lookups are cached for, so that a host which doesn't resolve doesn't cause
+// a lookup for every request. The default is 5 seconds; zero disables negative caching.
+func DNSCacheNegativeTTL(d time.Duration) DNSCacheOption {
+	return func(c *DNSCache) {
+		c.negativeTTL = d
+	}
+}
+
+// DNSCacheLookup sets the function which looks up the addresses of a host, returning with them how long they may be
+// cached for. A zero TTL means the cache's default TTL is used.
+//
+// The default uses net.DefaultResolver, which doesn't expose the TTLs of the records it finds, so lookups are cached
+// for the default TTL; if record TTLs must be respected exactly, a lookup function using a DNS library which exposes
+// them can be used.
+func DNSCacheLookup(f func(ctx context.Context, host string) ([]string, time.Duration, error)) DNSCacheOption {
+	return func(c *DNSCache) {
+		c.lookup = f
+	}
+}
+
+// dnsEntry is a cached lookup. Its fields (other than refreshing) are set before ready is closed, and not modified
+// afterwards.
+type dnsEntry struct {
+	ready      chan struct{}
+	addrs      []string
+	err        error
+	expires    time.Time
+	refresh    time.Time // when a background refresh should start
+	refreshing int32
+}
+
+// A DNSCache caches the addresses of the hosts a client connects to, so requests don't wait on DNS lookups (which may
+// be slow, or fail, at high request rates). Entries which are in use are refreshed in the background shortly before
+// they expire, so they are rarely looked up while a request waits. Concurrent lookups of a host are combined.
+//
+// The cache is used by a client with WithDNSCache, and is safe for concurrent use; a cache can be shared between
+// several clients.
+type DNSCache struct {
+	ttl         time.Duration
+	negativeTTL time.Duration
+	lookup      func(ctx context.Context, host string) ([]string, time.Duration, error)
+
+	m         sync.Mutex
+	entries   map[string]*dnsEntry
+	nextSweep time.Time // when expired entries are next removed; guarded by m
+	next      uint32    // to rotate between addresses
+}
+
+// NewDNSCache returns an empty DNSCache.
+func NewDNSCache(opts ...DNSCacheOption) *DNSCache {
+	c := &DNSCache{
+		ttl:         30 * time.Second,
+		negativeTTL: 5 * time.Second,
+		lookup: func(ctx context.Context, host string) ([]string, time.Duration, error) {
+			addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+			return addrs, 0, err
+		},
+		entries: map[string]*dnsEntry{}}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WithDNSCache makes the client look up the hosts it connects to using the passed cache. Like the other transport
+// options, it requires the client's RoundTripper to be an *http.Transport.
+func WithDNSCache(c *DNSCache) ClientOption {
+	return func(o *clientOptions) {
+		o.transportOpts = append(o.transportOpts, func(t *http.Transport) {
+			dial := t.DialContext
+			if dial == nil {
+				dial = (&net.Dialer{
+					KeepAlive: 30 * time.Second}).DialContext
+			}
+			t.DialContext = c.dialer(dial)
+		})
+	}
+}
+
+// LookupHost returns the addresses of the host, from the cache if possible.
+func (c *DNSCache) LookupHost(ctx context.Context, host string) ([]string, error) {
+	now := time.Now()
+	c.m.Lock()
+	e, ok := c.entries[host]
+	if ok {
+		select {
+		case <-e.ready:
+			if now.After(e.expires) {
+				ok = false
+			}
+		default: // a lookup is in progress: wait for it
+		}
+	}
+	if !ok {
+		c.sweep(now)
+		e = &dnsEntry{
+			ready: make(chan struct{})}
+		c.entries[host] = e
+		c.m.Unlock()
+		c.resolve(host, e)
+	} else {
+		c.m.Unlock()
+	}
+
+	select {
+	case <-e.ready:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if e.err == nil && now.After(e.refresh) && atomic.CompareAndSwapInt32(&e.refreshing, 0, 1) {
+		go c.refresh(host, e)
+	}
+	return e.addrs, e.err
+}
+
+// sweep removes expired entries, so hosts which are no longer used don't stay in the cache forever. It does so at most
+// once per (default) TTL, so busy caches aren't swept for every lookup. c.m must be held.
+func (c *DNSCache) sweep(now time.Time) {
+	if now.Before(c.nextSweep) {
+		return
+	}
+	c.nextSweep = now.Add(c.ttl)
+	for host, e := range c.entries {
+		select {
+		case <-e.ready:
+			if now.After(e.expires) {
+				delete(c.entries, host)
+			}
+		default: // still being looked up
+		}
+	}
+}
+
+// resolve looks up the host, completing the entry. The lookup is not bound to the context of any one request, as
+// others may be waiting for it.
+func (c *DNSCache) resolve(host string, e *dnsEntry) {
+	go func() {
+		defer close(e.ready)
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		addrs, ttl, err := c.lookup(ctx, host)
+		if err == nil && len(addrs) == 0 {
+			err = &net.DNSError{
+				Err:        "no addresses",
+				Name:       host,
+				IsNotFound: true}
+		}
+		if ttl <= 0 {
+			ttl = c.ttl
+		}
+		if err != nil {
+			ttl = c.negativeTTL
+		}
+		now := time.Now()
+		e.addrs, e.err = addrs, err
+		e.expires = now.Add(ttl)
+		e.refresh = now.Add(ttl * 4 / 5)
+	}()
+}
+
+// refresh replaces an entry which is near expiry with a fresh lookup. If the lookup fails, the existing entry is kept
+// until it expires.
+func (c *DNSCache) refresh(host string, old *dnsEntry) {
+	e := &dnsEntry{
+		ready: make(chan struct{})}
+	c.resolve(host, e)
+	<-e.ready
+	if e.err != nil {
+		return
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.entries[host] == old {
+		c.entries[host] = e
+	}
+}
+
+// dialer returns a dial function which looks up host names in the cache, and dials their addresses with dial. If a
+// host has several addresses, each is tried in turn until a connection is established; successive dials start with
+// different addresses, to spread connections between them.
+func (c *DNSCache) dialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(
+	ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil {
+			return dial(ctx, network, addr)
+		}
+		addrs, err := c.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		// Unsigned, so the index stays in range when the counter wraps (int is 32 bits on some platforms)
+		n := uint32(len(addrs))
+		start := atomic.AddUint32(&c.next, 1) % n
+		for i := range addrs {
+			var conn net.Conn
+			conn, err = dial(ctx, network, net.JoinHostPort(addrs[(start+uint32(i))%n], port))
+			if err == nil {
+				return conn, nil
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		return nil, err
+	}
+}
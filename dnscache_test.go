@@ -0,0 +1,161 @@
+package libhttp
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSCache(t *testing.T) {
+	t.Parallel()
+	var (
+		lookups int32
+		m       sync.Mutex
+		addrs   = []string{"10.0.0.1"}
+		err     error
+	)
+	c := NewDNSCache(
+		DNSCacheTTL(200*time.Millisecond),
+		DNSCacheNegativeTTL(20*time.Millisecond),
+		DNSCacheLookup(func(ctx context.Context, host string) ([]string, time.Duration, error) {
+			atomic.AddInt32(&lookups, 1)
+			time.Sleep(5 * time.Millisecond)
+			m.Lock()
+			defer m.Unlock()
+			if host == "short.example.com" {
+				return []string{"10.0.0.9"}, 10 * time.Millisecond, nil
+			}
+			return addrs, 0, err
+		}))
+
+	// Concurrent lookups are combined, and the result is cached
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := c.LookupHost(context.Background(), "example.com")
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"10.0.0.1"}, got)
+		}()
+	}
+	wg.Wait()
+	c.LookupHost(context.Background(), "example.com")
+	assert.EqualValues(t, 1, atomic.LoadInt32(&lookups))
+
+	// Entries used near their expiry are refreshed in the background
+	m.Lock()
+	addrs = []string{"10.0.0.2"}
+	m.Unlock()
+	time.Sleep(170 * time.Millisecond)
+	got, _ := c.LookupHost(context.Background(), "example.com")
+	assert.Equal(t, []string{"10.0.0.1"}, got) // the stale entry is used while refreshing
+	time.Sleep(20 * time.Millisecond)
+	got, _ = c.LookupHost(context.Background(), "example.com")
+	assert.Equal(t, []string{"10.0.0.2"}, got)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&lookups))
+
+	// Failures are cached too, but not for as long
+	atomic.StoreInt32(&lookups, 0)
+	m.Lock()
+	err = errors.New("no such host")
+	m.Unlock()
+	_, lookupErr := c.LookupHost(context.Background(), "missing.example.com")
+	assert.Error(t, lookupErr)
+	_, lookupErr = c.LookupHost(context.Background(), "missing.example.com")
+	assert.Error(t, lookupErr)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&lookups))
+	time.Sleep(25 * time.Millisecond)
+	c.LookupHost(context.Background(), "missing.example.com")
+	assert.EqualValues(t, 2, atomic.LoadInt32(&lookups))
+
+	// TTLs from the lookup are respected
+	atomic.StoreInt32(&lookups, 0)
+	c.LookupHost(context.Background(), "short.example.com")
+	time.Sleep(15 * time.Millisecond)
+	c.LookupHost(context.Background(), "short.example.com")
+	assert.EqualValues(t, 2, atomic.LoadInt32(&lookups))
+
+	// Waiting callers can give up
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, lookupErr = c.LookupHost(ctx, "other.example.com")
+	assert.Equal(t, context.Canceled, lookupErr)
+}
+
+func TestWithDNSCache(t *testing.T) {
+	t.Parallel()
+	s, err := Listen(Service(func(req Request) Response {
+		return req.Response("ok")
+	}), "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+	port := s.Listener().Addr().String()[strings.LastIndex(s.Listener().Addr().String(), ":"):]
+
+	var lookups int32
+	c := NewDNSCache(DNSCacheLookup(func(ctx context.Context, host string) ([]string, time.Duration, error) {
+		atomic.AddInt32(&lookups, 1)
+		assert.Equal(t, "users.test", host)
+		// The first address refuses connections, so the second is used
+		return []string{"127.0.0.2", "127.0.0.1"}, 0, nil
+	}))
+	client := NewClient(
+		WithRoundTripper(&http.Transport{
+			DisableKeepAlives: true}),
+		WithDNSCache(c),
+		WithConnectTimeout(time.Second))
+	for i := 0; i < 3; i++ {
+		rsp := NewRequest(context.Background(), "GET", "http://users.test"+port, nil).SendVia(client).Response()
+		require.NoError(t, rsp.Error)
+		var body string
+		require.NoError(t, rsp.Decode(&body))
+		assert.Equal(t, "ok", body)
+	}
+	assert.EqualValues(t, 1, atomic.LoadInt32(&lookups))
+}
+
+func TestDNSCacheEviction(t *testing.T) {
+	t.Parallel()
+	c := NewDNSCache(
+		DNSCacheTTL(10*time.Millisecond),
+		DNSCacheLookup(func(ctx context.Context, host string) ([]string, time.Duration, error) {
+			return []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, 0, nil
+		}))
+	for _, host := range []string{"a.example.com", "b.example.com"} {
+		_, err := c.LookupHost(context.Background(), host)
+		require.NoError(t, err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	_, err := c.LookupHost(context.Background(), "c.example.com")
+	require.NoError(t, err)
+	c.m.Lock()
+	assert.Len(t, c.entries, 1)
+	assert.Contains(t, c.entries, "c.example.com")
+	c.m.Unlock()
+
+	// Every address is tried, and they are rotated between, as the counter wraps
+	var dialled []string
+	dial := c.dialer(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialled = append(dialled, addr)
+		return nil, errors.New("refused")
+	})
+	atomic.StoreUint32(&c.next, math.MaxUint32-1)
+	for i := 0; i < 3; i++ {
+		dial(context.Background(), "tcp", "c.example.com:80")
+	}
+	require.Len(t, dialled, 9)
+	for i := 0; i < 9; i += 3 {
+		assert.ElementsMatch(t, []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80"}, dialled[i:i+3])
+	}
+	assert.Equal(t, "10.0.0.2:80", dialled[6])
+}
@@ -0,0 +1,342 @@
+package libhttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/monzo/terrors"
+)
+
+// A DownloadOption configures Download or DownloadFile.
+type DownloadOption func(*downloadOptions)
+
+type downloadOptions struct {
+	client      Service
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	newHash     func() hash.Hash
+	checksum    []byte
+}
+
+// DownloadClient sets the client which sends requests. The default is the package's Client.
+func DownloadClient(svc Service) DownloadOption {
+	return func(o *downloadOptions) {
+		o.client = svc
+	}
+}
+
+// DownloadMaxAttempts sets the maximum number of requests made for a download, including the first. The default is
+// 10. Attempts which make progress (by receiving some of the content) don't count towards the limit.
+func DownloadMaxAttempts(n int) DownloadOption {
+	return func(o *downloadOptions) {
+		o.maxAttempts = n
+	}
+}
+
+// DownloadBackoff sets the delay before the first retry, which doubles for each retry after that (until one makes
+// progress) up to max. The default is 500ms, up to 30s.
+func DownloadBackoff(base, max time.Duration) DownloadOption {
+	return func(o *downloadOptions) {
+		o.baseDelay = base
+		o.maxDelay = max
+	}
+}
+
+// DownloadChecksum verifies the downloaded content, which must hash to the passed sum with the hash function h (as
+// returned by sha256.New, say). A download which doesn't match fails with a bad_response.checksum_mismatch error.
+func DownloadChecksum(h func() hash.Hash, sum []byte) DownloadOption {
+	return func(o *downloadOptions) {
+		o.newHash = h
+		o.checksum = sum
+	}
+}
+
+// DownloadSHA256 verifies the downloaded content against the passed hex-encoded SHA-256 sum.
+func DownloadSHA256(sum string) DownloadOption {
+	b, err := hex.DecodeString(sum)
+	if err != nil {
+		b = []byte(sum) // which won't match, so the download fails rather than going unverified
+	}
+	return DownloadChecksum(sha256.New, b)
+}
+
+func newDownloadOptions(opts []DownloadOption) downloadOptions {
+	o := downloadOptions{
+		maxAttempts: 10,
+		baseDelay:   500 * time.Millisecond,
+		maxDelay:    30 * time.Second}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.client == nil {
+		o.client = Client
+	}
+	return o
+}
+
+// download is the state of a download in progress.
+type download struct {
+	downloadOptions
+	url       string
+	w         io.Writer
+	reset     func() error // discards what has been written, or returns an error if that's impossible
+	offset    int64        // bytes written so far
+	validator string       // the ETag or Last-Modified of the content being downloaded
+	h         hash.Hash
+
+	onValidator func(string) error // called when the validator changes, to persist it
+}
+
+// Download downloads the content at the URL to the writer, returning the number of bytes written. If the transfer is
+// interrupted, it resumes where it left off with a Range request, so large downloads over unreliable networks don't
+// start from scratch. The server's ETag (or failing that, Last-Modified time) is sent with the Range request in an
+// If-Range header, so that if the content changes in between, it isn't stitched together from two versions. As the
+// writer can't be rewound, the download then fails (DownloadFile can start again).
+//
+// Failed requests, and responses with 5xx statuses, are retried with backoff (see DownloadMaxAttempts and
+// DownloadBackoff), until the context is done. Other error statuses fail the download.
+func Download(ctx context.Context, url string, w io.Writer, opts ...DownloadOption) (int64, error) {
+	d := &download{
+		downloadOptions: newDownloadOptions(opts),
+		url:             url,
+		w:               w}
+	d.reset = func() error {
+		if d.offset == 0 {
+			return nil
+		}
+		return terrors.BadResponse("content_changed", "Content changed during download", map[string]string{
+			"url": url})
+	}
+	if d.newHash != nil {
+		d.h = d.newHash()
+	}
+	err := d.run(ctx)
+	return d.offset, err
+}
+
+// DownloadFile downloads the content at the URL to the file at path, resuming if the transfer is interrupted, like
+// Download. The content is written to path.partial, which is renamed to path once it is complete. If a previous
+// download was interrupted (even by the program exiting), it is resumed from the partial file, provided the content
+// hasn't changed; otherwise it starts again.
+func DownloadFile(ctx context.Context, url, path string, opts ...DownloadOption) error {
+	partial, meta := path+".partial", path+".partial.validator"
+	f, err := os.OpenFile(partial, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return terrors.Wrap(err, nil)
+	}
+	defer f.Close()
+
+	d := &download{
+		downloadOptions: newDownloadOptions(opts),
+		url:             url,
+		w:               f}
+	if d.newHash != nil {
+		d.h = d.newHash()
+	}
+	d.reset = func() error {
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		_, err := f.Seek(0, io.SeekStart)
+		d.offset = 0
+		if d.h != nil {
+			d.h.Reset()
+		}
+		return err
+	}
+	// Resume from a partial download, if there's one whose validator is known
+	if v, err := ioutil.ReadFile(meta); err == nil && len(v) > 0 {
+		d.validator = string(v)
+		var w io.Writer = ioutil.Discard
+		if d.h != nil {
+			w = d.h
+		}
+		if d.offset, err = io.Copy(w, f); err != nil {
+			return terrors.Wrap(err, nil)
+		}
+	} else if err := d.reset(); err != nil {
+		return terrors.Wrap(err, nil)
+	}
+	d.onValidator = func(v string) error {
+		return ioutil.WriteFile(meta, []byte(v), 0644)
+	}
+
+	if err := d.run(ctx); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return terrors.Wrap(err, nil)
+	}
+	os.Remove(meta)
+	return terrors.Wrap(os.Rename(partial, path), nil)
+}
+
+// run requests the content until it has all been written.
+func (d *download) run(ctx context.Context) error {
+	delay := d.baseDelay
+	for attempt := 1; ; attempt++ {
+		progress, done, retry, err := d.attempt(ctx)
+		switch {
+		case done:
+			return d.verify()
+		case ctx.Err() != nil:
+			return terrors.Wrap(ctx.Err(), nil)
+		case !retry:
+			return err
+		case progress:
+			attempt, delay = 0, d.baseDelay
+			continue
+		case attempt >= d.maxAttempts:
+			return err
+		}
+		if err := sleepContext(Request{Context: ctx}, delay); err != nil {
+			return terrors.Wrap(err, nil)
+		}
+		if delay *= 2; delay > d.maxDelay {
+			delay = d.maxDelay
+		}
+	}
+}
+
+// attempt requests the rest of the content, and writes what it receives. It returns whether any content was
+// written, whether the download is complete, and if not, whether it should be retried.
+func (d *download) attempt(ctx context.Context) (progress, done, retry bool, err error) {
+	req := NewRequest(ctx, "GET", d.url, nil)
+	req.Header.Set("Accept-Encoding", "identity") // ranges must be of the content itself
+	if d.offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", d.offset))
+		if d.validator != "" {
+			req.Header.Set("If-Range", d.validator)
+		}
+	}
+	rsp := req.SendVia(d.client).Response()
+	if rsp.Response == nil {
+		if rsp.Error == nil {
+			rsp.Error = terrors.BadResponse("download", "No response", nil)
+		}
+		return false, false, true, rsp.Error
+	}
+	defer discardResponse(rsp)
+	validator := responseValidator(rsp.Header)
+
+	switch status := rsp.StatusCode; {
+	case status == http.StatusPartialContent:
+		start, _ := contentRange(rsp.Header.Get("Content-Range"))
+		if start != d.offset || (d.validator != "" && validator != "" && validator != d.validator) {
+			// Not what was asked for, or a different version of the content: start again
+			if err := d.reset(); err != nil {
+				return false, false, false, err
+			}
+			return false, false, true, terrors.BadResponse("content_changed", "Content changed during download", nil)
+		}
+	case status == http.StatusOK:
+		// The whole content, either because none had been written, or because it changed
+		if err := d.reset(); err != nil {
+			return false, false, false, err
+		}
+	case status == http.StatusRequestedRangeNotSatisfiable && d.offset > 0:
+		if _, size := contentRange(rsp.Header.Get("Content-Range")); size == d.offset {
+			return false, true, false, nil // it was all downloaded already
+		}
+		if err := d.reset(); err != nil {
+			return false, false, false, err
+		}
+		return false, false, true, terrors.BadResponse("content_changed", "Content changed during download", nil)
+	default:
+		err := terrors.BadResponse("download_status", fmt.Sprintf("Download failed with status %d", status),
+			map[string]string{
+				"status": strconv.Itoa(status)})
+		return false, false, status >= 500 || status == http.StatusTooManyRequests, err
+	}
+
+	if validator != d.validator {
+		d.validator = validator
+		if d.onValidator != nil {
+			if err := d.onValidator(validator); err != nil {
+				return false, false, false, terrors.Wrap(err, nil)
+			}
+		}
+	}
+	w := &downloadWriter{
+		w: d.w}
+	if d.h != nil {
+		w.w = io.MultiWriter(d.w, d.h)
+	}
+	n, err := io.Copy(w, rsp.Body)
+	d.offset += n
+	switch {
+	case w.err != nil:
+		return n > 0, false, false, terrors.Wrap(w.err, nil)
+	case err != nil:
+		return n > 0, false, true, terrors.Wrap(err, nil)
+	}
+	return n > 0, true, false, nil
+}
+
+// verify checks the checksum of the downloaded content, if there is one to check.
+func (d *download) verify() error {
+	if d.h == nil {
+		return nil
+	}
+	if sum := d.h.Sum(nil); !bytes.Equal(sum, d.checksum) {
+		return terrors.BadResponse("checksum_mismatch", fmt.Sprintf("Downloaded content has checksum %x, not %x",
+			sum, d.checksum), map[string]string{
+			"url": d.url})
+	}
+	return nil
+}
+
+// downloadWriter records errors writing downloaded content, to tell them apart from errors reading it.
+type downloadWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *downloadWriter) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	if err != nil {
+		w.err = err
+	}
+	return n, err
+}
+
+// responseValidator returns the strong ETag of the response, or failing that its Last-Modified time, to send in
+// If-Range headers.
+func responseValidator(h http.Header) string {
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return h.Get("Last-Modified")
+}
+
+// contentRange parses a Content-Range header, like "bytes 100-199/1000" or "bytes */1000", returning the start of the
+// range and the size of the content (either of which are -1 if not given).
+func contentRange(h string) (start, size int64) {
+	start, size = -1, -1
+	h = strings.TrimPrefix(strings.TrimSpace(h), "bytes ")
+	slash := strings.IndexByte(h, '/')
+	if slash < 0 {
+		return start, size
+	}
+	if n, err := strconv.ParseInt(h[slash+1:], 10, 64); err == nil {
+		size = n
+	}
+	if dash := strings.IndexByte(h[:slash], '-'); dash > 0 {
+		if n, err := strconv.ParseInt(h[:dash], 10, 64); err == nil {
+			start = n
+		}
+	}
+	return start, size
+}
@@ -0,0 +1,186 @@
+package libhttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// brokenReader fails after reading limit bytes.
+type brokenReader struct {
+	r     io.Reader
+	limit int
+}
+
+func (b *brokenReader) Read(p []byte) (int, error) {
+	if b.limit <= 0 {
+		return 0, errors.New("connection lost")
+	}
+	if len(p) > b.limit {
+		p = p[:b.limit]
+	}
+	n, err := b.r.Read(p)
+	b.limit -= n
+	return n, err
+}
+
+// flakyContent serves content with ranges, cutting the first few responses short, and then failing a few.
+type flakyContent struct {
+	m           sync.Mutex
+	content     []byte
+	etag        string
+	failures    int // responses still to cut short
+	unavailable int // responses to fail with 503s, after those cut short
+	ranges      []string
+}
+
+func (f *flakyContent) serve(req Request) Response {
+	f.m.Lock()
+	content, etag := f.content, f.etag
+	fail := f.failures > 0
+	f.failures--
+	unavailable := !fail && f.unavailable > 0
+	if unavailable {
+		f.unavailable--
+	}
+	f.ranges = append(f.ranges, req.Header.Get("Range"))
+	f.m.Unlock()
+	if unavailable {
+		rsp := req.Response(nil)
+		rsp.StatusCode = 503
+		return rsp
+	}
+	rsp := req.ServeRange(bytes.NewReader(content), int64(len(content)), "application/octet-stream", etag)
+	if fail && rsp.Body != nil {
+		rsp.Body = ioutil.NopCloser(&brokenReader{r: rsp.Body, limit: 100000})
+	}
+	return rsp
+}
+
+func TestDownload(t *testing.T) {
+	t.Parallel()
+	content := make([]byte, 500000)
+	rand.Read(content)
+	sum := sha256.Sum256(content)
+	f := &flakyContent{
+		content:  content,
+		etag:     `"v1"`,
+		failures: 2}
+	s, err := Listen(Service(f.serve), "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+	url := "http://" + s.Listener().Addr().String() + "/blob"
+	opts := []DownloadOption{
+		DownloadClient(NewClient()),
+		DownloadBackoff(time.Millisecond, 10*time.Millisecond),
+		DownloadSHA256(hex.EncodeToString(sum[:]))}
+
+	var buf bytes.Buffer
+	n, err := Download(context.Background(), url, &buf, opts...)
+	require.NoError(t, err)
+	assert.EqualValues(t, len(content), n)
+	assert.True(t, bytes.Equal(content, buf.Bytes()))
+	require.Len(t, f.ranges, 3)
+	assert.Equal(t, "", f.ranges[0])
+	assert.Contains(t, f.ranges[1], "bytes=")
+
+	// A wrong checksum fails the download
+	_, err = Download(context.Background(), url, ioutil.Discard, DownloadClient(NewClient()),
+		DownloadSHA256(hex.EncodeToString(make([]byte, 32))))
+	assert.True(t, terrors.PrefixMatches(err, terrors.ErrBadResponse, "checksum_mismatch"))
+
+	// If the content changes mid-download, a writer can't be rewound
+	f.m.Lock()
+	f.failures = 1
+	f.m.Unlock()
+	changed := Service(func(req Request) Response {
+		if req.Header.Get("Range") != "" {
+			req.Header.Del("If-Range")
+			f.m.Lock()
+			f.etag = `"v2"`
+			f.m.Unlock()
+		}
+		return f.serve(req)
+	})
+	s2, err := Listen(changed, "localhost:0")
+	require.NoError(t, err)
+	defer s2.Stop(context.Background())
+	_, err = Download(context.Background(), "http://"+s2.Listener().Addr().String(), ioutil.Discard,
+		DownloadClient(NewClient()), DownloadBackoff(time.Millisecond, time.Millisecond))
+	assert.True(t, terrors.PrefixMatches(err, terrors.ErrBadResponse, "content_changed"), "%v", err)
+
+	// Permanent errors aren't retried
+	_, err = Download(context.Background(), url+"/missing", ioutil.Discard, DownloadClient(Service(
+		func(req Request) Response {
+			rsp := req.Response(nil)
+			rsp.StatusCode = 404
+			return rsp
+		})))
+	assert.True(t, terrors.PrefixMatches(err, terrors.ErrBadResponse, "download_status"))
+}
+
+func TestDownloadFile(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "blob")
+	content := make([]byte, 300000)
+	rand.Read(content)
+	f := &flakyContent{
+		content:     content,
+		etag:        `"v1"`,
+		failures:    1,
+		unavailable: 1}
+	s, err := Listen(Service(f.serve), "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+	url := "http://" + s.Listener().Addr().String() + "/blob"
+
+	// The first attempt is interrupted, and the next fails, so the download gives up
+	err = DownloadFile(context.Background(), url, path, DownloadClient(NewClient()), DownloadMaxAttempts(1))
+	require.Error(t, err)
+	partial, err := ioutil.ReadFile(path + ".partial")
+	require.NoError(t, err)
+	assert.Len(t, partial, 100000)
+
+	// A later download resumes it
+	sum := sha256.Sum256(content)
+	require.NoError(t, DownloadFile(context.Background(), url, path, DownloadClient(NewClient()),
+		DownloadSHA256(hex.EncodeToString(sum[:]))))
+	b, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(content, b))
+	assert.Equal(t, "bytes=100000-", f.ranges[len(f.ranges)-1])
+	_, err = os.Stat(path + ".partial")
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(path + ".partial.validator")
+	assert.True(t, os.IsNotExist(err))
+
+	// If the content has changed since, it starts again
+	f.m.Lock()
+	f.failures, f.unavailable = 1, 1
+	f.m.Unlock()
+	require.Error(t, DownloadFile(context.Background(), url, path, DownloadClient(NewClient()),
+		DownloadMaxAttempts(1)))
+	f.m.Lock()
+	f.content = content[:200000]
+	f.etag = `"v2"`
+	f.m.Unlock()
+	require.NoError(t, DownloadFile(context.Background(), url, path, DownloadClient(NewClient())))
+	b, err = ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(content[:200000], b))
+}
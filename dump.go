@@ -0,0 +1,232 @@
+package libhttp
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	redacted      = "REDACTED"
+	dumpBodyLimit = 4096 // bytes of body included by Dump
+)
+
+var (
+	sensitiveM       sync.RWMutex
+	sensitiveHeaders = map[string]bool{}
+	sensitiveParams  = map[string]bool{}
+)
+
+func init() {
+	RegisterSensitiveHeader("Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key",
+		"X-Auth-Token", "X-Csrf-Token")
+	RegisterSensitiveParam("access_token", "api_key", "apikey", "client_secret", "code", "key", "password",
+		"refresh_token", "secret", "signature", "token")
+}
+
+// RegisterSensitiveHeader adds headers whose values are redacted by Request.Dump and Request.AsCurl, and scrubbed from
+// VCR cassettes. Authorization and cookie headers, amongst others, are registered by default.
+func RegisterSensitiveHeader(names ...string) {
+	sensitiveM.Lock()
+	defer sensitiveM.Unlock()
+	for _, name := range names {
+		sensitiveHeaders[http.CanonicalHeaderKey(name)] = true
+	}
+}
+
+// RegisterSensitiveParam adds query (and form body) parameters whose values are redacted by Request.Dump and
+// Request.AsCurl, and query parameters whose values are scrubbed from VCR cassettes. Parameters are matched
+// case-insensitively; common names for tokens and passwords are registered by default.
+func RegisterSensitiveParam(names ...string) {
+	sensitiveM.Lock()
+	defer sensitiveM.Unlock()
+	for _, name := range names {
+		sensitiveParams[strings.ToLower(name)] = true
+	}
+}
+
+func isSensitiveHeader(name string) bool {
+	sensitiveM.RLock()
+	defer sensitiveM.RUnlock()
+	return sensitiveHeaders[http.CanonicalHeaderKey(name)]
+}
+
+func isSensitiveParam(name string) bool {
+	sensitiveM.RLock()
+	defer sensitiveM.RUnlock()
+	return sensitiveParams[strings.ToLower(name)]
+}
+
+// redactHeader returns the value of a header with any secret replaced. The authentication scheme of credentials (like
+// "Bearer") is kept, as it's often useful to know.
+func redactHeader(name, value string) string {
+	if !isSensitiveHeader(name) {
+		return value
+	}
+	switch http.CanonicalHeaderKey(name) {
+	case "Authorization", "Proxy-Authorization":
+		if i := strings.IndexByte(value, ' '); i > 0 {
+			return value[:i+1] + redacted
+		}
+	}
+	return redacted
+}
+
+// redactParams returns an encoded query string with the values of sensitive parameters replaced.
+func redactParams(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	vs, err := url.ParseQuery(raw)
+	if err != nil {
+		// It's safer to lose the query than to leak from one we don't understand
+		return redacted
+	}
+	for name := range vs {
+		if isSensitiveParam(name) {
+			for i := range vs[name] {
+				vs[name][i] = redacted
+			}
+		}
+	}
+	return vs.Encode()
+}
+
+// redactedURL returns the request's absolute URL with sensitive parameters and any password redacted.
+func (r *Request) redactedURL() string {
+	if r.URL == nil {
+		return ""
+	}
+	u := *r.URL
+	if u.Host == "" {
+		u.Host = r.Host
+	}
+	if u.Scheme == "" {
+		u.Scheme = "http"
+		if r.TLS != nil {
+			u.Scheme = "https"
+		}
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+	u.RawQuery = redactParams(u.RawQuery)
+	return u.String()
+}
+
+// redactedBody returns the request's body, without consuming it, with the values of sensitive parameters redacted
+// from form bodies. The second value is false for bodies which aren't text.
+func (r *Request) redactedBody() ([]byte, bool, error) {
+	if r.Body == nil {
+		return nil, true, nil
+	}
+	b, err := r.BodyBytes(false)
+	if err != nil {
+		return nil, true, err
+	}
+	if mediaType(r.Header.Get("Content-Type")) == "application/x-www-form-urlencoded" {
+		return []byte(redactParams(string(b))), true, nil
+	}
+	return b, utf8.Valid(b), nil
+}
+
+// sortedHeaderNames returns the names of the headers in a stable order, so dumps can be compared.
+func sortedHeaderNames(h http.Header) []string {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Dump returns a textual representation of the request, in the style of an HTTP/1.1 request on the wire, for debugging
+// and to include in support tickets:
+//
+//  POST https://api.example.com/v1/users?token=REDACTED HTTP/1.1
+//  Authorization: Bearer REDACTED
+//  Content-Type: application/json
+//
+//  {"name":"Ada"}
+//
+// Secrets are redacted: the values of sensitive headers and parameters (see RegisterSensitiveHeader and
+// RegisterSensitiveParam) are replaced, including parameters within form bodies; other bodies are included as they
+// are, so they shouldn't be dumped if they may contain secrets. Only the first 4KiB of the body is included, and
+// binary bodies are summarised.
+//
+// The body is read into memory (if it isn't already) so that it can still be read after the dump.
+func (r *Request) Dump() string {
+	buf := new(bytes.Buffer)
+	proto := r.Proto
+	if proto == "" {
+		proto = "HTTP/1.1"
+	}
+	fmt.Fprintf(buf, "%s %s %s\n", r.Method, r.redactedURL(), proto)
+	for _, name := range sortedHeaderNames(r.Header) {
+		for _, v := range r.Header[name] {
+			fmt.Fprintf(buf, "%s: %s\n", name, redactHeader(name, v))
+		}
+	}
+
+	b, text, err := r.redactedBody()
+	switch {
+	case err != nil:
+		fmt.Fprintf(buf, "\n[error reading body: %v]\n", err)
+	case len(b) == 0:
+	case !text:
+		fmt.Fprintf(buf, "\n[%d bytes of binary data]\n", len(b))
+	case len(b) > dumpBodyLimit:
+		fmt.Fprintf(buf, "\n%s\n[%d more bytes]\n", b[:dumpBodyLimit], len(b)-dumpBodyLimit)
+	default:
+		fmt.Fprintf(buf, "\n%s\n", b)
+	}
+	return buf.String()
+}
+
+// AsCurl returns a curl command which reproduces the request, which can be pasted into a (POSIX) shell. Secrets are
+// redacted as they are by Dump, so they need to be filled back in before the command is run. Text bodies are
+// included in full; binary bodies are replaced by a placeholder.
+//
+// The body is read into memory (if it isn't already) so that it can still be read afterwards.
+func (r *Request) AsCurl() string {
+	buf := new(bytes.Buffer)
+	buf.WriteString("curl")
+	if r.Method != "" && r.Method != http.MethodGet {
+		fmt.Fprintf(buf, " -X %s", shellQuote(r.Method))
+	}
+	fmt.Fprintf(buf, " %s", shellQuote(r.redactedURL()))
+	if r.URL != nil && r.URL.Host != "" && r.Host != "" && r.Host != r.URL.Host {
+		fmt.Fprintf(buf, " -H %s", shellQuote("Host: "+r.Host))
+	}
+	for _, name := range sortedHeaderNames(r.Header) {
+		// curl computes these itself
+		if name == "Content-Length" || name == "Host" {
+			continue
+		}
+		for _, v := range r.Header[name] {
+			fmt.Fprintf(buf, " -H %s", shellQuote(name+": "+redactHeader(name, v)))
+		}
+	}
+
+	b, text, err := r.redactedBody()
+	switch {
+	case err != nil:
+		fmt.Fprintf(buf, " --data-binary %s", shellQuote(fmt.Sprintf("[error reading body: %v]", err)))
+	case len(b) == 0:
+	case !text:
+		fmt.Fprintf(buf, " --data-binary %s", shellQuote(fmt.Sprintf("[%d bytes of binary data]", len(b))))
+	default:
+		fmt.Fprintf(buf, " --data-binary %s", shellQuote(string(b)))
+	}
+	return buf.String()
+}
+
+// shellQuote quotes s as a single word for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -49,6 +49,9 @@ func someFlavours(t *testing.T, only []string, impl func(*testing.T, e2eFlavour)
 		onlys[o] = true
 	}
 
+	// The flavours replace the default Client; restore it for the tests which run afterwards
+	defer func(c Service) { Client = c }(Client)
+
 	if run("http1.1") {
 		t.Run("http1.1", func(t *testing.T) {
 			defer leaktest.Check(t)()
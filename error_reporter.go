@@ -0,0 +1,104 @@
+package libhttp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/monzo/terrors"
+)
+
+// An ErrorReporter captures the causes of a server's 5xx responses and panics centrally, for example by sending them to
+// an error tracking service. See RegisterErrorReporter.
+type ErrorReporter interface {
+	ReportError(ctx context.Context, report ErrorReport)
+}
+
+// ErrorReporterFunc adapts a function to an ErrorReporter.
+type ErrorReporterFunc func(ctx context.Context, report ErrorReport)
+
+// ReportError implements ErrorReporter.
+func (f ErrorReporterFunc) ReportError(ctx context.Context, report ErrorReport) {
+	f(ctx, report)
+}
+
+// An ErrorReport describes an error or panic which caused a server to fail a request.
+type ErrorReport struct {
+	Error     error
+	Panic     interface{} // the recovered value, if the request panicked
+	Stack     []byte      // the stack of the panic, or where the error was created, if known
+	Status    int         // the status of the response, or 0 if it had already started to be sent
+	Request   ErrorRequest
+	RequestID string
+}
+
+// ErrorRequest is a snapshot of the request which failed, with secrets redacted as they are by Request.Dump. The body
+// isn't included, as it may have been consumed and may contain personal data.
+type ErrorRequest struct {
+	Method     string
+	URL        string
+	Header     http.Header
+	RemoteAddr string
+	Route      string // the pattern of the Router route which handled the request, if any
+}
+
+var (
+	errorReportersM sync.RWMutex
+	errorReporters  []ErrorReporter
+)
+
+// RegisterErrorReporter adds a reporter which is called with the cause of each 5xx response which ErrorFilter
+// serialises for a Server, and of each panic recovered by a Server, with its stack. Reporters are called synchronously
+// in the serving path, so ones which send reports over the network should do so in the background:
+//
+//  libhttp.RegisterErrorReporter(libhttp.ErrorReporterFunc(func(ctx context.Context, r libhttp.ErrorReport) {
+//      tracker.Capture(r.Error, r.Stack, r.Request.Method+" "+r.Request.URL)
+//  }))
+//
+// Errors returned by clients, and those which aren't serialised by ErrorFilter, aren't reported.
+func RegisterErrorReporter(r ErrorReporter) {
+	errorReportersM.Lock()
+	defer errorReportersM.Unlock()
+	errorReporters = append(errorReporters, r)
+}
+
+// reportError calls the registered reporters.
+func reportError(ctx context.Context, report ErrorReport) {
+	errorReportersM.RLock()
+	reporters := errorReporters
+	errorReportersM.RUnlock()
+	for _, r := range reporters {
+		r.ReportError(ctx, report)
+	}
+}
+
+// newErrorReport returns a report of a failed request, without its cause.
+func newErrorReport(r *http.Request, status int) ErrorReport {
+	req := &Request{Request: *r}
+	report := ErrorReport{
+		Status:    status,
+		RequestID: r.Header.Get("X-Request-ID"),
+		Request: ErrorRequest{
+			Method:     r.Method,
+			URL:        req.redactedURL(),
+			Header:     make(http.Header, len(r.Header)),
+			RemoteAddr: r.RemoteAddr}}
+	for name, vs := range r.Header {
+		for _, v := range vs {
+			report.Request.Header[name] = append(report.Request.Header[name], redactHeader(name, v))
+		}
+	}
+	return report
+}
+
+// reportRequestError reports the cause of a 5xx response to a request.
+func reportRequestError(req Request, status int, err error) {
+	report := newErrorReport(&req.Request, status)
+	report.Error = err
+	report.Request.Route = routeOf(req)
+	if terr := (*terrors.Error)(nil); errors.As(err, &terr) && len(terr.StackFrames) > 0 {
+		report.Stack = []byte(terr.StackString())
+	}
+	reportError(req, report)
+}
@@ -0,0 +1,105 @@
+package libhttp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// reportsFor registers an ErrorReporter which receives the reports of requests whose URLs contain marker; as
+// reporters are global, this keeps tests running in parallel apart.
+func reportsFor(marker string) <-chan ErrorReport {
+	reports := make(chan ErrorReport, 10)
+	RegisterErrorReporter(ErrorReporterFunc(func(ctx context.Context, r ErrorReport) {
+		if strings.Contains(r.Request.URL, marker) {
+			reports <- r
+		}
+	}))
+	return reports
+}
+
+func TestErrorReporter(t *testing.T) {
+	t.Parallel()
+
+	reports := reportsFor("/reporter-test/")
+	router := Router{}
+	router.GET("/reporter-test/fail/:id", func(req Request) Response {
+		return Response{Error: terrors.InternalService("db", "Database unavailable", nil)}
+	})
+	router.GET("/reporter-test/missing", func(req Request) Response {
+		return Response{Error: NotFound("No such thing")}
+	})
+	router.GET("/reporter-test/panic", func(req Request) Response {
+		panic("boom")
+	})
+	s, err := Listen(router.Serve().Filter(ErrorFilter), "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+	base := fmt.Sprintf("http://%s/reporter-test", s.Listener().Addr())
+	client := HttpService(&http.Transport{}).Filter(ErrorFilter)
+
+	req := NewRequest(context.Background(), "GET", base+"/fail/1?token=secret&q=1", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("X-Request-ID", "abc")
+	rsp := req.SendVia(client).Response()
+	assert.Equal(t, http.StatusInternalServerError, rsp.StatusCode)
+	select {
+	case r := <-reports:
+		require.Error(t, r.Error)
+		assert.Contains(t, r.Error.Error(), "Database unavailable")
+		assert.Nil(t, r.Panic)
+		assert.NotEmpty(t, r.Stack)
+		assert.Equal(t, http.StatusInternalServerError, r.Status)
+		assert.Equal(t, "abc", r.RequestID)
+		assert.Equal(t, "GET", r.Request.Method)
+		assert.Contains(t, r.Request.URL, "token=REDACTED")
+		assert.NotContains(t, r.Request.URL, "secret")
+		assert.Equal(t, "Bearer REDACTED", r.Request.Header.Get("Authorization"))
+		assert.Equal(t, "/reporter-test/fail/:id", r.Request.Route)
+		assert.NotEmpty(t, r.Request.RemoteAddr)
+	case <-time.After(time.Second):
+		assert.Fail(t, "error not reported")
+	}
+
+	// Client errors aren't reported
+	rsp = NewRequest(context.Background(), "GET", base+"/missing", nil).SendVia(client).Response()
+	assert.Equal(t, http.StatusNotFound, rsp.StatusCode)
+
+	// Panics are reported once, with their stack
+	rsp = NewRequest(context.Background(), "GET", base+"/panic", nil).SendVia(client).Response()
+	assert.Equal(t, http.StatusInternalServerError, rsp.StatusCode)
+	select {
+	case r := <-reports:
+		assert.Equal(t, "boom", r.Panic)
+		assert.EqualError(t, r.Error, "panic: boom")
+		assert.Contains(t, string(r.Stack), "error_reporter_test.go")
+		assert.Equal(t, http.StatusInternalServerError, r.Status)
+		assert.True(t, strings.HasSuffix(r.Request.URL, "/reporter-test/panic"))
+	case <-time.After(time.Second):
+		assert.Fail(t, "panic not reported")
+	}
+	select {
+	case r := <-reports:
+		assert.Fail(t, "unexpected report", "%+v", r)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestErrorReporterNotCalledOutsideServer(t *testing.T) {
+	t.Parallel()
+
+	reports := reportsFor("/reporter-client-test")
+	svc := Service(func(req Request) Response {
+		return Response{Error: terrors.InternalService("", "Failed", nil)}
+	}).Filter(ErrorFilter)
+	rsp := svc(NewRequest(context.Background(), "GET", "http://localhost/reporter-client-test", nil))
+	assert.Equal(t, http.StatusInternalServerError, rsp.StatusCode)
+	assert.Len(t, reports, 0)
+}
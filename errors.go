@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 
 	"github.com/monzo/slog"
 	"github.com/monzo/terrors"
@@ -33,10 +34,186 @@ func init() {
 	}
 }
 
+// An Error is an error to be returned to a client, with the HTTP status to respond with. It separates what the client
+// is told (the status, code, message and metadata) from the internal cause, which is available to filters (with
+// errors.As and errors.Unwrap) but never sent:
+//
+//  if err == sql.ErrNoRows {
+//      err = libhttp.NotFound("User %s not found", id).WithCode("user_not_found").WithCause(err)
+//      return libhttp.Response{Error: err}
+//  }
+//
+// ErrorFilter serialises an Error as a terror, so libhttp clients receive it in the usual way. Its code is the terror
+// code for the status (eg. not_found) followed by the Error's own code, and its params are the Error's metadata.
+type Error struct {
+	Status   int               // HTTP status code
+	Code     string            // application error code, eg. "user_not_found"
+	Message  string            // message for the client
+	Cause    error             // underlying error, which is not sent to the client
+	Metadata map[string]string // additional detail for the client
+}
+
+// NewError returns an Error with the passed status and a message formatted in the manner of fmt.Sprintf.
+func NewError(status int, format string, args ...interface{}) *Error {
+	return &Error{
+		Status:  status,
+		Message: fmt.Sprintf(format, args...)}
+}
+
+// BadRequest returns a 400 Error with a formatted message.
+func BadRequest(format string, args ...interface{}) *Error {
+	return NewError(http.StatusBadRequest, format, args...)
+}
+
+// Unauthorized returns a 401 Error with a formatted message.
+func Unauthorized(format string, args ...interface{}) *Error {
+	return NewError(http.StatusUnauthorized, format, args...)
+}
+
+// Forbidden returns a 403 Error with a formatted message.
+func Forbidden(format string, args ...interface{}) *Error {
+	return NewError(http.StatusForbidden, format, args...)
+}
+
+// NotFound returns a 404 Error with a formatted message.
+func NotFound(format string, args ...interface{}) *Error {
+	return NewError(http.StatusNotFound, format, args...)
+}
+
+// Conflict returns a 409 Error with a formatted message.
+func Conflict(format string, args ...interface{}) *Error {
+	return NewError(http.StatusConflict, format, args...)
+}
+
+// InternalError returns a 500 Error with a formatted message.
+func InternalError(format string, args ...interface{}) *Error {
+	return NewError(http.StatusInternalServerError, format, args...)
+}
+
+// WithCode returns a copy of the error with the passed application error code.
+func (e *Error) WithCode(code string) *Error {
+	c := e.clone()
+	c.Code = code
+	return c
+}
+
+// WithCause returns a copy of the error with the passed underlying cause.
+func (e *Error) WithCause(err error) *Error {
+	c := e.clone()
+	c.Cause = err
+	return c
+}
+
+// WithMetadata returns a copy of the error with an additional metadata entry.
+func (e *Error) WithMetadata(key, value string) *Error {
+	c := e.clone()
+	c.Metadata = make(map[string]string, len(e.Metadata)+1)
+	for k, v := range e.Metadata {
+		c.Metadata[k] = v
+	}
+	c.Metadata[key] = value
+	return c
+}
+
+func (e *Error) clone() *Error {
+	c := *e
+	return &c
+}
+
+func (e *Error) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = http.StatusText(e.Status)
+	}
+	if e.Code != "" {
+		msg = e.Code + ": " + msg
+	}
+	if e.Cause != nil {
+		msg += ": " + e.Cause.Error()
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// terror converts the error to the terror sent to clients, omitting its cause.
+func (e *Error) terror() *terrors.Error {
+	code := status2TerrCode(e.Status)
+	if e.Code != "" {
+		code += "." + e.Code
+	}
+	params := make(map[string]string, len(e.Metadata))
+	for k, v := range e.Metadata {
+		params[k] = v
+	}
+	return terrors.New(code, e.Message, params)
+}
+
+// An ErrorMapper converts an application's errors into ones which produce the right response, typically an Error. It
+// returns nil if it doesn't handle the passed error.
+type ErrorMapper func(err error) error
+
+var (
+	errorMappersM sync.RWMutex
+	errorMappers  []ErrorMapper
+)
+
+// RegisterErrorMapper adds a mapper which ErrorFilter applies to response errors before serialising them, so handlers
+// can return domain errors directly. Mappers registered later take precedence. For example, to map a type of error:
+//
+//  libhttp.RegisterErrorMapper(func(err error) error {
+//      var verr *domain.ValidationError
+//      if errors.As(err, &verr) {
+//          return libhttp.BadRequest(verr.Reason).WithCode("invalid").WithCause(err)
+//      }
+//      return nil
+//  })
+func RegisterErrorMapper(m ErrorMapper) {
+	errorMappersM.Lock()
+	defer errorMappersM.Unlock()
+	errorMappers = append(errorMappers, m)
+}
+
+// MapError registers a mapping from errors matching target (according to errors.Is) to the passed Error, which is
+// returned with the original error as its cause:
+//
+//  libhttp.MapError(sql.ErrNoRows, libhttp.NotFound("Not found"))
+//
+// No errors are mapped by default. To send 504s rather than 500s for requests which time out, say:
+//
+//  libhttp.MapError(context.DeadlineExceeded, libhttp.NewError(http.StatusGatewayTimeout, "Request timed out"))
+func MapError(target error, to *Error) {
+	RegisterErrorMapper(func(err error) error {
+		if errors.Is(err, target) {
+			return to.WithCause(err)
+		}
+		return nil
+	})
+}
+
+// mapError applies the registered mappers to an error, returning it unchanged if none handle it.
+func mapError(err error) error {
+	errorMappersM.RLock()
+	defer errorMappersM.RUnlock()
+	for i := len(errorMappers) - 1; i >= 0; i-- {
+		if mapped := errorMappers[i](err); mapped != nil {
+			return mapped
+		}
+	}
+	return err
+}
+
 // ErrorStatusCode returns a HTTP status code for the given error.
 //
-// If the error is not a terror, this will always be 500 (Internal Server Error).
+// If the error is an Error, its status is used. Otherwise, if the error is not a terror, this will always be 500
+// (Internal Server Error).
 func ErrorStatusCode(err error) int {
+	var herr *Error
+	if errors.As(err, &herr) && herr.Status >= 400 && herr.Status <= 599 {
+		return herr.Status
+	}
 	code := terrors.Wrap(err, nil).(*terrors.Error).Code
 	if c, ok := mapTerr2Status[strings.SplitN(code, ".", 2)[0]]; ok {
 		return c
@@ -54,7 +231,14 @@ func status2TerrCode(code int) string {
 
 // ErrorFilter serialises and deserialises response errors. Without this filter, errors may not be passed across
 // the network properly so it is recommended to use this in most/all cases.
+//
+// The causes of 5xx responses it serialises for a Server are passed to the registered ErrorReporters.
 func ErrorFilter(req Request, svc Service) Response {
+	return errorFilter(req, svc, true)
+}
+
+// errorFilter is ErrorFilter, optionally without reporting errors (for panics, which are reported with their stacks).
+func errorFilter(req Request, svc Service, report bool) Response {
 	// If the request contains an error, short-circuit and return that directly
 	var rsp Response
 	if req.err != nil {
@@ -74,14 +258,33 @@ func ErrorFilter(req Request, svc Service) Response {
 	if rsp.Error != nil {
 		if rsp.StatusCode == http.StatusOK {
 			// We got an error, but there is no error in the underlying response; marshal
-			if rsp.Body != nil {
-				rsp.Body.Close()
+			if b, ok := rsp.Body.(*bufCloser); ok {
+				b.Reset()
+			} else {
+				if rsp.Body != nil {
+					rsp.Body.Close()
+				}
+				rsp.Body = newBufCloser()
+			}
+			rsp.ContentLength = 0
+			rsp.Error = mapError(rsp.Error)
+			var terr *terrors.Error
+			if herr := (*Error)(nil); errors.As(rsp.Error, &herr) {
+				terr = herr.terror()
+			} else {
+				terr = terrors.Wrap(rsp.Error, nil).(*terrors.Error)
+			}
+			if terr.PrefixMatches(terrors.ErrBadRequest, validationErrCode) {
+				rsp.Encode(newProblemDetails(terr))
+				rsp.Header.Set("Content-Type", "application/problem+json")
+			} else {
+				rsp.Encode(terrors.Marshal(terr))
 			}
-			rsp.Body = &bufCloser{}
-			terr := terrors.Wrap(rsp.Error, nil).(*terrors.Error)
-			rsp.Encode(terrors.Marshal(terr))
-			rsp.StatusCode = ErrorStatusCode(terr)
+			rsp.StatusCode = ErrorStatusCode(rsp.Error)
 			rsp.Header.Set("Terror", "1")
+			if report && rsp.StatusCode >= 500 && req.server != nil {
+				reportRequestError(*rsp.Request, rsp.StatusCode, rsp.Error)
+			}
 		}
 	} else if rsp.StatusCode >= 400 && rsp.StatusCode <= 599 {
 		// There is an error in the underlying response; unmarshal
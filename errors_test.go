@@ -0,0 +1,99 @@
+package libhttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestError(t *testing.T) {
+	t.Parallel()
+
+	cause := errors.New("sql: no rows in result set")
+	base := NotFound("User %s not found", "u1")
+	err := base.WithCode("user_not_found").WithCause(cause).WithMetadata("user_id", "u1")
+	assert.Equal(t, "user_not_found: User u1 not found: sql: no rows in result set", err.Error())
+	assert.True(t, errors.Is(err, cause))
+	assert.Empty(t, base.Code) // the With methods don't modify the receiver
+	assert.Empty(t, base.Metadata)
+	assert.Equal(t, http.StatusNotFound, ErrorStatusCode(err))
+	assert.Equal(t, http.StatusConflict, ErrorStatusCode(fmt.Errorf("wrapped: %w", Conflict("exists"))))
+	assert.Equal(t, "Unauthorized", (&Error{Status: http.StatusUnauthorized}).Error())
+
+	svc := Service(func(req Request) Response {
+		switch req.URL.Path {
+		case "/conflict":
+			return Response{Error: Conflict("Account already exists").WithCode("duplicate")}
+		default:
+			return Response{Error: err}
+		}
+	}).Filter(ErrorFilter)
+
+	rsp := svc(NewRequest(context.Background(), "GET", "/", nil))
+	assert.Equal(t, http.StatusNotFound, rsp.StatusCode)
+	b, _ := rsp.BodyBytes(false)
+	assert.NotContains(t, string(b), "sql") // the cause isn't sent
+
+	// A client sees an equivalent terror
+	rsp.Error = nil
+	rsp = ErrorFilter(NewRequest(context.Background(), "GET", "/", nil), func(req Request) Response { return rsp })
+	require.Error(t, rsp.Error)
+	terr := rsp.Error.(*terrors.Error)
+	assert.Equal(t, "not_found.user_not_found", terr.Code)
+	assert.Equal(t, "User u1 not found", terr.Message)
+	assert.Equal(t, "u1", terr.Params["user_id"])
+
+	rsp = svc(NewRequest(context.Background(), "GET", "/conflict", nil))
+	assert.Equal(t, http.StatusConflict, rsp.StatusCode)
+}
+
+type testDomainError struct {
+	reason string
+}
+
+func (e testDomainError) Error() string { return e.reason }
+
+func TestErrorMappers(t *testing.T) {
+	t.Parallel()
+
+	errNoRows := errors.New("no rows")
+	MapError(errNoRows, NotFound("Not found").WithCode("no_rows"))
+	RegisterErrorMapper(func(err error) error {
+		var derr testDomainError
+		if errors.As(err, &derr) {
+			return BadRequest("Invalid: %s", derr.reason).WithCause(err)
+		}
+		return nil
+	})
+
+	var returned error
+	svc := Service(func(req Request) Response {
+		return Response{Error: returned}
+	}).Filter(ErrorFilter)
+
+	cases := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{fmt.Errorf("loading user: %w", errNoRows), http.StatusNotFound, "not_found.no_rows"},
+		{testDomainError{reason: "name too long"}, http.StatusBadRequest, "bad_request"},
+		{context.DeadlineExceeded, http.StatusInternalServerError, "internal_service"}, // not mapped by default
+		{errors.New("other"), http.StatusInternalServerError, "internal_service"}}
+	for _, c := range cases {
+		returned = c.err
+		rsp := svc(NewRequest(context.Background(), "GET", "/", nil))
+		assert.Equal(t, c.status, rsp.StatusCode, c.err.Error())
+		assert.True(t, errors.Is(rsp.Error, c.err) || rsp.Error == c.err, "cause is kept for %v", c.err)
+
+		rsp.Error = nil
+		rsp = ErrorFilter(NewRequest(context.Background(), "GET", "/", nil), func(req Request) Response { return rsp })
+		assert.Equal(t, c.code, rsp.Error.(*terrors.Error).Code)
+	}
+}
@@ -0,0 +1,94 @@
+package libhttp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ETag returns a strong entity tag for a representation with the passed content, derived from a hash of it. Strong
+// tags may be used for range requests, so they should only be used for exact byte-for-byte content.
+func ETag(b []byte) string {
+	h := sha256.New()
+	h.Write(b)
+	return etagFromHash(h, false)
+}
+
+// WeakETag returns a weak entity tag for a representation with the passed content. Weak tags only claim semantic
+// equivalence, so they suit content that may be encoded differently (eg. compressed) without changing meaning.
+func WeakETag(b []byte) string {
+	h := sha256.New()
+	h.Write(b)
+	return etagFromHash(h, true)
+}
+
+// ReaderETag returns a strong entity tag for the content read from r, without holding it all in memory.
+func ReaderETag(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return etagFromHash(h, false), nil
+}
+
+// ValueETag returns a weak entity tag for the passed value, derived from a hash of its JSON encoding (in which map
+// keys are sorted, so equal values have equal tags). This is convenient for tagging the objects a Service returns,
+// without encoding the response first.
+func ValueETag(v interface{}) (string, error) {
+	h := sha256.New()
+	if err := json.NewEncoder(h).Encode(v); err != nil {
+		return "", err
+	}
+	return etagFromHash(h, true), nil
+}
+
+func etagFromHash(h hash.Hash, weak bool) string {
+	tag := `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+	if weak {
+		return "W/" + tag
+	}
+	return tag
+}
+
+// SetETag sets the entity tag of the response, which should be a quoted string (optionally prefixed with W/ if it is
+// weak) such as those returned by ETag.
+func (r *Response) SetETag(etag string) {
+	if r.Response == nil {
+		r.Response = newHTTPResponse(Request{})
+	}
+	r.Header.Set("ETag", etag)
+}
+
+// ETagMatches returns whether the passed entity tag matches the request's If-None-Match header (using the weak
+// comparison required for If-None-Match), in which case the client already has the current representation.
+func (r Request) ETagMatches(etag string) bool {
+	for _, v := range r.Header.Values("If-None-Match") {
+		for _, candidate := range strings.Split(v, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NotModified returns a 304 (Not Modified) response with the passed entity tag, for use when ETagMatches:
+//
+//  etag, err := libhttp.ValueETag(user)
+//  if err == nil && req.ETagMatches(etag) {
+//      return req.NotModified(etag)
+//  }
+//  rsp := req.Response(user)
+//  rsp.SetETag(etag)
+//  return rsp
+func (r Request) NotModified(etag string) Response {
+	rsp := r.Response(nil)
+	rsp.StatusCode = http.StatusNotModified
+	rsp.SetETag(etag)
+	return rsp
+}
@@ -0,0 +1,58 @@
+package libhttp
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestETags(t *testing.T) {
+	t.Parallel()
+
+	strong := ETag([]byte("content"))
+	assert.Regexp(t, `^"[0-9a-f]{32}"$`, strong)
+	assert.NotEqual(t, strong, ETag([]byte("other")))
+	assert.Equal(t, "W/"+strong, WeakETag([]byte("content")))
+	fromReader, err := ReaderETag(strings.NewReader("content"))
+	require.NoError(t, err)
+	assert.Equal(t, strong, fromReader)
+
+	a, err := ValueETag(map[string]int{"a": 1, "b": 2})
+	require.NoError(t, err)
+	b, err := ValueETag(map[string]int{"b": 2, "a": 1})
+	require.NoError(t, err)
+	assert.Equal(t, a, b)
+	assert.True(t, strings.HasPrefix(a, `W/"`))
+	_, err = ValueETag(make(chan int))
+	assert.Error(t, err)
+}
+
+func TestETagMatches(t *testing.T) {
+	t.Parallel()
+
+	etag := ETag([]byte("content"))
+	cases := map[string]bool{
+		"":                             false,
+		etag:                           true,
+		"W/" + etag:                    true,
+		`"other", ` + etag:             true,
+		`"other"`:                      false,
+		"*":                            true,
+		`"other",W/` + etag + `,"foo"`: true}
+	for header, expected := range cases {
+		req := NewRequest(context.Background(), "GET", "/", nil)
+		if header != "" {
+			req.Header.Set("If-None-Match", header)
+		}
+		assert.Equal(t, expected, req.ETagMatches(etag), header)
+	}
+
+	req := NewRequest(context.Background(), "GET", "/", nil)
+	rsp := req.NotModified(etag)
+	assert.Equal(t, http.StatusNotModified, rsp.StatusCode)
+	assert.Equal(t, etag, rsp.Header.Get("ETag"))
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/4thel00z/libhttp"
+)
+
+// sentryReporter is an ErrorReporter which sends reports to Sentry's store API, in the background, using libhttp's
+// own client. It is a sketch of an adapter for an error tracking service; a real one would use the service's SDK.
+type sentryReporter struct {
+	storeURL string
+	auth     string
+	events   chan map[string]interface{}
+}
+
+// newSentryReporter parses a Sentry DSN (https://<key>@<host>/<project>).
+func newSentryReporter(dsn string) (*sentryReporter, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || u.User == nil {
+		return nil, fmt.Errorf("invalid DSN %q", dsn)
+	}
+	project := strings.TrimPrefix(u.Path, "/")
+	r := &sentryReporter{
+		storeURL: fmt.Sprintf("%s://%s/api/%s/store/", u.Scheme, u.Host, project),
+		auth:     fmt.Sprintf("Sentry sentry_version=7, sentry_client=libhttp-example/1.0, sentry_key=%s", u.User.Username()),
+		events:   make(chan map[string]interface{}, 100)}
+	go r.send()
+	return r, nil
+}
+
+func (r *sentryReporter) ReportError(ctx context.Context, report libhttp.ErrorReport) {
+	id := make([]byte, 16)
+	rand.Read(id)
+	headers := map[string]string{}
+	for name := range report.Request.Header {
+		headers[name] = report.Request.Header.Get(name)
+	}
+	event := map[string]interface{}{
+		"event_id":  hex.EncodeToString(id),
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+		"level":     "error",
+		"platform":  "go",
+		"exception": map[string]interface{}{
+			"values": []map[string]interface{}{{
+				"type":  fmt.Sprintf("%T", report.Error),
+				"value": report.Error.Error()}}},
+		"request": map[string]interface{}{
+			"method":  report.Request.Method,
+			"url":     report.Request.URL,
+			"headers": headers},
+		"tags": map[string]string{
+			"route":      report.Request.Route,
+			"status":     fmt.Sprint(report.Status),
+			"request_id": report.RequestID},
+		"extra": map[string]string{
+			"stack": string(report.Stack)}}
+	select {
+	case r.events <- event:
+	default: // drop reports rather than slow down requests when Sentry can't keep up
+	}
+}
+
+func (r *sentryReporter) send() {
+	for event := range r.events {
+		req := libhttp.NewRequest(context.Background(), "POST", r.storeURL, event)
+		req.Header.Set("X-Sentry-Auth", r.auth)
+		if rsp := req.Send().Response(); rsp.Error != nil {
+			log.Printf("Failed to send error report: %v", rsp.Error)
+		}
+	}
+}
+
+func fail(req libhttp.Request) libhttp.Response {
+	return libhttp.Response{Error: libhttp.NewError(500, "Something went wrong")}
+}
+
+func crash(req libhttp.Request) libhttp.Response {
+	panic("something went very wrong")
+}
+
+func main() {
+	reporter, err := newSentryReporter(os.Getenv("SENTRY_DSN"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	libhttp.RegisterErrorReporter(reporter)
+
+	router := libhttp.Router{}
+	router.GET("/fail", fail)
+	router.GET("/crash", crash)
+
+	svc := router.Serve().
+		Filter(libhttp.ErrorFilter)
+	log.Print("Try: curl localhost:8000/fail or curl localhost:8000/crash")
+	if err := libhttp.Run(context.Background(), svc, ":8000"); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -3,10 +3,6 @@ package main
 import (
 	"context"
 	"log"
-	"os"
-	"os/signal"
-	"syscall"
-	"time"
 
 	"github.com/4thel00z/libhttp"
 )
@@ -22,17 +18,7 @@ func main() {
 	svc := router.Serve().
 		Filter(libhttp.ErrorFilter).
 		Filter(libhttp.H2cFilter)
-	srv, err := libhttp.Listen(svc, ":8000")
-	if err != nil {
-		panic(err)
+	if err := libhttp.Run(context.Background(), svc, ":8000"); err != nil {
+		log.Fatal(err)
 	}
-	log.Printf("👋  Listening on %v", srv.Listener().Addr())
-
-	done := make(chan os.Signal, 1)
-	signal.Notify(done, syscall.SIGINT, syscall.SIGTERM)
-	<-done
-	log.Printf("☠️  Shutting down")
-	c, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	srv.Stop(c)
 }
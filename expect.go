@@ -0,0 +1,52 @@
+package libhttp
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// ExpectContinue returns a Filter which vets requests whose clients ask (with Expect: 100-continue) for permission
+// before sending the body. The check is passed the request, with its headers but before any of the body has been read,
+// so it can reject uploads based on authentication, quotas or the Content-Length, without the client sending the body
+// at all. If check returns nil, the request is passed on, and the client is told to continue when the Service first
+// reads the body.
+//
+// If check returns an Error (see NewError), it is the response. Otherwise, a non-nil error results in a 417
+// (Expectation Failed) with the error as its cause. ErrorFilter should be applied outside the filter, to serialise it:
+//
+//  svc = svc.
+//      Filter(libhttp.ExpectContinue(libhttp.MaxContentLength(10 << 20))).
+//      Filter(libhttp.ErrorFilter)
+//
+// Requests which don't ask to continue aren't checked, so Services must still enforce any limits themselves.
+func ExpectContinue(check func(req Request) error) Filter {
+	return func(req Request, svc Service) Response {
+		if !strings.EqualFold(req.Header.Get("Expect"), "100-continue") {
+			return svc(req)
+		}
+		if err := check(req); err != nil {
+			rsp := NewResponse(req)
+			var herr *Error
+			if !errors.As(err, &herr) {
+				err = NewError(http.StatusExpectationFailed, "Request rejected before its body was sent").
+					WithCode("expectation_failed").WithCause(err)
+			}
+			rsp.Error = err
+			return rsp
+		}
+		return svc(req)
+	}
+}
+
+// MaxContentLength returns a check, for use with ExpectContinue, which rejects requests whose declared Content-Length
+// is more than n bytes (or is unknown) with a 413 (Request Entity Too Large).
+func MaxContentLength(n int64) func(req Request) error {
+	return func(req Request) error {
+		if req.ContentLength < 0 || req.ContentLength > n {
+			return NewError(http.StatusRequestEntityTooLarge, "Request body is larger than %d bytes", n).
+				WithCode("too_large")
+		}
+		return nil
+	}
+}
@@ -0,0 +1,85 @@
+package libhttp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingReader counts the bytes read from it.
+type countingReader struct {
+	r *strings.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+func TestExpectContinue(t *testing.T) {
+	t.Parallel()
+
+	svc := Service(func(req Request) Response {
+		b, err := req.BodyBytes(true)
+		if err != nil {
+			return Response{Error: err}
+		}
+		return req.Response(len(b))
+	}).
+		Filter(ExpectContinue(func(req Request) error {
+			if req.Header.Get("Authorization") == "" {
+				return errors.New("no credentials")
+			}
+			return MaxContentLength(10)(req)
+		})).
+		Filter(ErrorFilter)
+	s, err := Listen(svc, "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+
+	client := HttpService(&http.Transport{
+		ExpectContinueTimeout: 10 * time.Second})
+	send := func(body string, auth bool) (Response, int64) {
+		r := &countingReader{
+			r: strings.NewReader(body)}
+		req := NewRequest(context.Background(), "POST", "http://"+s.Listener().Addr().String(), nil)
+		req.Body = readCloser{Reader: r, close: func() {}}
+		req.ContentLength = int64(len(body))
+		req.Header.Set("Expect", "100-continue")
+		if auth {
+			req.Header.Set("Authorization", "yes")
+		}
+		rsp := req.SendVia(client).Response()
+		rsp.BodyBytes(false) // read the body so the connection is released
+		return rsp, atomic.LoadInt64(&r.n)
+	}
+
+	rsp, sent := send("small", true)
+	require.NoError(t, rsp.Error)
+	n := 0
+	require.NoError(t, rsp.Decode(&n))
+	assert.Equal(t, 5, n)
+	assert.EqualValues(t, 5, sent)
+
+	rsp, sent = send(strings.Repeat("x", 100), true)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rsp.StatusCode)
+	assert.EqualValues(t, 0, sent)
+
+	rsp, sent = send("small", false)
+	assert.Equal(t, http.StatusExpectationFailed, rsp.StatusCode)
+	assert.EqualValues(t, 0, sent)
+
+	// Requests without Expect aren't checked
+	req := NewRequest(context.Background(), "POST", "http://"+s.Listener().Addr().String(), "unchecked")
+	rsp = req.SendVia(client).Response()
+	assert.NoError(t, rsp.Error)
+}
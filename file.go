@@ -0,0 +1,247 @@
+package libhttp
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/monzo/terrors"
+)
+
+// ServeContent responds with the content of rs, with the semantics of http.ServeContent: Range requests are satisfied
+// with partial content (subject to If-Range), conditional requests with If-Modified-Since may result in a 304, and the
+// Content-Type is taken from name's extension or, failing that, sniffed from the content. If modtime is the zero
+// time, it is not used.
+//
+// The content is streamed to the client rather than buffered. If rs is an io.Closer, it is closed once it has been
+// sent.
+func (r Request) ServeContent(rs io.ReadSeeker, name string, modtime time.Time) Response {
+	return r.serveContent(rs, name, modtime, nil)
+}
+
+// ServeFile responds with the content of the file at path, in the manner of ServeContent. The response has an ETag
+// derived from the file's size and modification time (as nginx's is), so If-None-Match and If-Range can be used too.
+// Missing files result in not found errors, and unreadable ones (including directories) in forbidden errors.
+func (r Request) ServeFile(path string) Response {
+	f, err := os.Open(path)
+	if err != nil {
+		return r.fileError(err)
+	}
+	fi, err := f.Stat()
+	if err == nil && fi.IsDir() {
+		err = fmt.Errorf("%s is a directory", path)
+	}
+	if err != nil {
+		f.Close()
+		return r.fileError(err)
+	}
+	header := http.Header{
+		"Etag": []string{fmt.Sprintf(`"%x-%x"`, fi.Size(), fi.ModTime().UnixNano())}}
+	return r.serveContent(f, fi.Name(), fi.ModTime(), header)
+}
+
+// ServeRange responds with the content of rs, which is size bytes long, in the manner of ServeContent: requests for a
+// single range are answered with that part of the content, and those for several with a multipart/byteranges body.
+// This suits objects in blob stores, whose size (and entity tag) is known without reading them: rs is only read from,
+// and seeked to, the positions of the ranges requested, and is never asked for its size.
+//
+// If contentType is empty, it is sniffed from the start of the content. If etag is not empty, it is sent as the
+// ETag, and used to evaluate If-Range, If-Match and If-None-Match. If rs is an io.Closer, it is closed once the content
+// has been sent.
+func (r Request) ServeRange(rs io.ReadSeeker, size int64, contentType, etag string) Response {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	if etag != "" {
+		header.Set("Etag", etag)
+	}
+	return r.serveContent(&sizedReadSeeker{
+		rs:   rs,
+		size: size}, "", time.Time{}, header)
+}
+
+// sizedReadSeeker is an io.ReadSeeker of a known size, which defers seeks until the next read (so it never seeks the
+// underlying reader to its end, as http.ServeContent does to discover the size.)
+type sizedReadSeeker struct {
+	rs    io.ReadSeeker
+	size  int64
+	pos   int64 // the position reads should start from
+	rsPos int64 // the position of rs
+}
+
+func (s *sizedReadSeeker) Read(p []byte) (int, error) {
+	if s.pos >= s.size {
+		return 0, io.EOF
+	}
+	if s.pos != s.rsPos {
+		if _, err := s.rs.Seek(s.pos, io.SeekStart); err != nil {
+			return 0, err
+		}
+		s.rsPos = s.pos
+	}
+	if remaining := s.size - s.pos; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := s.rs.Read(p)
+	s.pos += int64(n)
+	s.rsPos += int64(n)
+	if err == io.EOF && s.pos < s.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (s *sizedReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.pos
+	case io.SeekEnd:
+		offset += s.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position %d", offset)
+	}
+	s.pos = offset
+	return offset, nil
+}
+
+func (s *sizedReadSeeker) Close() error {
+	if c, ok := s.rs.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// AsAttachment marks the response as a download to be saved as filename (rather than displayed inline), by setting
+// an RFC 6266 Content-Disposition. Names which aren't plain ASCII are sent UTF-8 encoded, with an ASCII fallback for
+// old clients. Only the final element of a path is used. The Content-Type defaults to application/octet-stream, and
+// clients are told not to sniff it.
+func (r *Response) AsAttachment(filename string) {
+	if r.Response == nil {
+		r.Response = newHTTPResponse(Request{})
+	}
+	r.Header.Set("Content-Disposition", contentDisposition("attachment", filename))
+	r.Header.Set("X-Content-Type-Options", "nosniff")
+	if r.Header.Get("Content-Type") == "" {
+		r.Header.Set("Content-Type", "application/octet-stream")
+	}
+}
+
+// contentDisposition formats a Content-Disposition header value with the passed filename.
+func contentDisposition(disposition, filename string) string {
+	filename = path.Base(strings.ReplaceAll(filename, "\\", "/"))
+	if filename == "." || filename == "/" {
+		return disposition
+	}
+	fallback, ascii := &strings.Builder{}, true
+	for _, c := range filename {
+		switch {
+		case c == '"' || c == '\\':
+			fallback.WriteByte('_')
+		case c < 0x20 || c > 0x7e:
+			fallback.WriteByte('_')
+			ascii = false
+		default:
+			fallback.WriteRune(c)
+		}
+	}
+	v := fmt.Sprintf(`%s; filename="%s"`, disposition, fallback)
+	if !ascii || strings.ContainsAny(filename, `"\`) {
+		// RFC 5987 ext-value: only attr-chars may appear unencoded
+		enc := &strings.Builder{}
+		for _, b := range []byte(filename) {
+			if ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z') || ('0' <= b && b <= '9') ||
+				strings.IndexByte("!#$&+-.^_`|~", b) >= 0 {
+				enc.WriteByte(b)
+			} else {
+				fmt.Fprintf(enc, "%%%02X", b)
+			}
+		}
+		v += "; filename*=UTF-8''" + enc.String()
+	}
+	return v
+}
+
+func (r Request) fileError(err error) Response {
+	rsp := r.Response(nil)
+	switch {
+	case os.IsNotExist(err):
+		rsp.Error = terrors.NotFound("file", "File not found", nil)
+	default:
+		rsp.Error = terrors.Forbidden("file", "File can't be served", nil)
+	}
+	return rsp
+}
+
+// serveContent runs http.ServeContent in its own goroutine, waiting until it has written the status code and headers
+// before returning a Response which streams the body it writes.
+func (r Request) serveContent(rs io.ReadSeeker, name string, modtime time.Time, header http.Header) Response {
+	pr, pw := io.Pipe()
+	w := &contentWriter{
+		header: make(http.Header),
+		ready:  make(chan struct{}),
+		body:   pw}
+	for k, v := range header {
+		w.header[k] = v
+	}
+	httpReq := r.Request
+	if r.Context != nil {
+		httpReq = *httpReq.WithContext(r)
+	}
+	go func() {
+		defer pw.Close() // after rs is closed, so it's closed by the time the body has been read
+		defer func() {
+			if c, ok := rs.(io.Closer); ok {
+				c.Close()
+			}
+		}()
+		http.ServeContent(w, &httpReq, name, modtime, rs)
+		w.WriteHeader(http.StatusOK) // in case nothing at all was written
+	}()
+	<-w.ready
+
+	rsp := r.Response(nil)
+	rsp.StatusCode = w.status
+	rsp.Header = w.header
+	rsp.Body = pr
+	rsp.ContentLength = -1
+	if n, err := strconv.ParseInt(w.header.Get("Content-Length"), 10, 64); err == nil {
+		rsp.ContentLength = n
+	}
+	return rsp
+}
+
+// contentWriter is an http.ResponseWriter which captures the status code and headers, and writes the body into a pipe.
+type contentWriter struct {
+	header http.Header
+	status int
+	ready  chan struct{}
+	once   sync.Once
+	body   *io.PipeWriter
+}
+
+func (w *contentWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *contentWriter) WriteHeader(status int) {
+	w.once.Do(func() {
+		w.status = status
+		close(w.ready)
+	})
+}
+
+func (w *contentWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(b)
+}
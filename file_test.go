@@ -0,0 +1,220 @@
+package libhttp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeFile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hello.txt")
+	require.NoError(t, ioutil.WriteFile(path, []byte("hello world"), 0644))
+
+	svc := Service(func(req Request) Response {
+		if req.URL.Path == "/content" {
+			modtime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+			return req.ServeContent(bytes.NewReader([]byte("<html></html>")), "page", modtime)
+		}
+		return req.ServeFile(filepath.Join(dir, req.URL.Path))
+	}).Filter(ErrorFilter)
+	s, err := Listen(svc, "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+	client := HttpService(&http.Transport{}).Filter(ErrorFilter)
+	get := func(path string, header http.Header) Response {
+		req := NewRequest(context.Background(), "GET", "http://"+s.Listener().Addr().String()+path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		return req.SendVia(client).Response()
+	}
+	body := func(rsp Response) string {
+		b, err := rsp.BodyBytes(true)
+		require.NoError(t, err)
+		return string(b)
+	}
+
+	rsp := get("/hello.txt", nil)
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, "text/plain; charset=utf-8", rsp.Header.Get("Content-Type"))
+	assert.Equal(t, "bytes", rsp.Header.Get("Accept-Ranges"))
+	assert.EqualValues(t, 11, rsp.ContentLength)
+	etag, lastModified := rsp.Header.Get("ETag"), rsp.Header.Get("Last-Modified")
+	assert.NotEmpty(t, etag)
+	assert.Equal(t, "hello world", body(rsp))
+
+	// Ranges
+	rsp = get("/hello.txt", http.Header{"Range": {"bytes=6-"}})
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusPartialContent, rsp.StatusCode)
+	assert.Equal(t, "bytes 6-10/11", rsp.Header.Get("Content-Range"))
+	assert.Equal(t, "world", body(rsp))
+
+	rsp = get("/hello.txt", http.Header{"Range": {"bytes=0-4"}, "If-Range": {etag}})
+	assert.Equal(t, http.StatusPartialContent, rsp.StatusCode)
+	assert.Equal(t, "hello", body(rsp))
+	rsp = get("/hello.txt", http.Header{"Range": {"bytes=0-4"}, "If-Range": {`"stale"`}})
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Equal(t, "hello world", body(rsp))
+
+	rsp = get("/hello.txt", http.Header{"Range": {"bytes=100-"}})
+	require.Error(t, rsp.Error)
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, rsp.StatusCode)
+
+	// Conditional requests
+	rsp = get("/hello.txt", http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusNotModified, rsp.StatusCode)
+	rsp = get("/hello.txt", http.Header{"If-Modified-Since": {lastModified}})
+	assert.Equal(t, http.StatusNotModified, rsp.StatusCode)
+
+	// Content types are sniffed if they can't be inferred from the name
+	rsp = get("/content", nil)
+	require.NoError(t, rsp.Error)
+	assert.True(t, strings.HasPrefix(rsp.Header.Get("Content-Type"), "text/html"))
+	assert.Equal(t, "<html></html>", body(rsp))
+
+	// Missing files and directories
+	rsp = get("/missing.txt", nil)
+	assert.True(t, terrors.Is(rsp.Error, terrors.ErrNotFound), "%v", rsp.Error)
+	rsp = get("/", nil)
+	assert.True(t, terrors.Is(rsp.Error, terrors.ErrForbidden), "%v", rsp.Error)
+}
+
+func TestAsAttachment(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]string{
+		"report.pdf":        `attachment; filename="report.pdf"`,
+		"../../etc/passwd":  `attachment; filename="passwd"`,
+		`C:\tmp\a b.txt`:    `attachment; filename="a b.txt"`,
+		"naïve résumé.txt":  `attachment; filename="na_ve r_sum_.txt"; filename*=UTF-8''na%C3%AFve%20r%C3%A9sum%C3%A9.txt`,
+		`say "hi".txt`:      `attachment; filename="say _hi_.txt"; filename*=UTF-8''say%20%22hi%22.txt`,
+		"":                  `attachment`,
+		"line\nbreak.txt":   `attachment; filename="line_break.txt"; filename*=UTF-8''line%0Abreak.txt`,
+		"€100;ok=1,yes.csv": `attachment; filename="_100;ok=1,yes.csv"; filename*=UTF-8''%E2%82%AC100%3Bok%3D1%2Cyes.csv`}
+	for name, expected := range cases {
+		rsp := NewResponse(Request{})
+		rsp.AsAttachment(name)
+		assert.Equal(t, expected, rsp.Header.Get("Content-Disposition"), name)
+		assert.Equal(t, "application/octet-stream", rsp.Header.Get("Content-Type"))
+		assert.Equal(t, "nosniff", rsp.Header.Get("X-Content-Type-Options"))
+	}
+
+	rsp := NewResponse(Request{})
+	rsp.Header.Set("Content-Type", "text/csv")
+	rsp.AsAttachment("data.csv")
+	assert.Equal(t, "text/csv", rsp.Header.Get("Content-Type"))
+}
+
+// blobReader is an io.ReadSeeker which records the seeks made on it, and refuses to seek relative to its end.
+type blobReader struct {
+	*bytes.Reader
+	seeks  []int64
+	closed bool
+}
+
+func (b *blobReader) Seek(offset int64, whence int) (int64, error) {
+	if whence != io.SeekStart {
+		return 0, errors.New("only absolute seeks are supported")
+	}
+	b.seeks = append(b.seeks, offset)
+	return b.Reader.Seek(offset, whence)
+}
+
+func (b *blobReader) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestServeRange(t *testing.T) {
+	t.Parallel()
+
+	content := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	blobs := make(chan *blobReader, 10)
+	svc := Service(func(req Request) Response {
+		b := &blobReader{
+			Reader: bytes.NewReader(content)}
+		blobs <- b
+		return req.ServeRange(b, int64(len(content)), "text/plain", `"v1"`)
+	}).Filter(ErrorFilter)
+	s, err := Listen(svc, "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+	client := HttpService(&http.Transport{})
+	get := func(header http.Header) (Response, []byte) {
+		req := NewRequest(context.Background(), "GET", "http://"+s.Listener().Addr().String(), nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rsp := req.SendVia(client).Response()
+		require.NoError(t, rsp.Error)
+		b, err := rsp.BodyBytes(true)
+		require.NoError(t, err)
+		return rsp, b
+	}
+
+	rsp, b := get(nil)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Equal(t, content, b)
+	assert.Equal(t, `"v1"`, rsp.Header.Get("ETag"))
+	assert.Equal(t, "bytes", rsp.Header.Get("Accept-Ranges"))
+	assert.True(t, (<-blobs).closed)
+
+	rsp, b = get(http.Header{
+		"Range": []string{"bytes=10-15"}})
+	assert.Equal(t, http.StatusPartialContent, rsp.StatusCode)
+	assert.Equal(t, "bytes 10-15/36", rsp.Header.Get("Content-Range"))
+	assert.Equal(t, "abcdef", string(b))
+	assert.Equal(t, []int64{10}, (<-blobs).seeks)
+
+	// Several ranges are sent as multipart/byteranges
+	rsp, b = get(http.Header{
+		"Range": []string{"bytes=0-1,-3"}})
+	assert.Equal(t, http.StatusPartialContent, rsp.StatusCode)
+	mediaType, params, err := mime.ParseMediaType(rsp.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/byteranges", mediaType)
+	mr := multipart.NewReader(bytes.NewReader(b), params["boundary"])
+	var parts []string
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		assert.Equal(t, "text/plain", p.Header.Get("Content-Type"))
+		b, _ := ioutil.ReadAll(p)
+		parts = append(parts, p.Header.Get("Content-Range")+" "+string(b))
+	}
+	assert.Equal(t, []string{"bytes 0-1/36 01", "bytes 33-35/36 xyz"}, parts)
+	<-blobs
+
+	// A stale If-Range gets the whole content
+	rsp, b = get(http.Header{
+		"Range":    []string{"bytes=0-1"},
+		"If-Range": []string{`"v0"`}})
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Equal(t, content, b)
+	<-blobs
+
+	rsp, _ = get(http.Header{
+		"If-None-Match": []string{`"v1"`}})
+	assert.Equal(t, http.StatusNotModified, rsp.StatusCode)
+	<-blobs
+}
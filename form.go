@@ -0,0 +1,137 @@
+package libhttp
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/monzo/terrors"
+)
+
+const formContentType = "application/x-www-form-urlencoded"
+
+// FormValues parses the application/x-www-form-urlencoded body of the request, consuming it. Unlike
+// http.Request.ParseForm, query parameters are not included.
+func (r Request) FormValues() (url.Values, error) {
+	if r.Body == nil {
+		return url.Values{}, nil
+	}
+	b, err := r.BodyBytes(true)
+	if err != nil {
+		return nil, terrors.WrapWithCode(err, nil, terrors.ErrBadRequest)
+	}
+	vs, err := url.ParseQuery(string(b))
+	if err != nil {
+		return nil, terrors.BadRequest("invalid_form", fmt.Sprintf("Malformed form body: %v", err), nil)
+	}
+	return vs, nil
+}
+
+// EncodeForm serialises the passed values into the body as application/x-www-form-urlencoded (and sets appropriate
+// headers).
+func (r *Request) EncodeForm(vs url.Values) {
+	r.Write([]byte(vs.Encode()))
+	r.Header.Set("Content-Type", formContentType)
+}
+
+// DecodeForm parses the application/x-www-form-urlencoded body of the request into the struct pointed to by v. Fields
+// are matched to keys by their form tag (or their name, if they have none):
+//
+//  type signupForm struct {
+//      Email     string            `form:"email"`
+//      Interests []string          `form:"interest"`  // interest=a&interest=b, or interest[]=a&interest[]=b
+//      Address   struct {
+//          City string `form:"city"`
+//      } `form:"address"`                              // address[city]=London
+//      Prefs     map[string]string `form:"prefs"`     // prefs[theme]=dark
+//  }
+//
+// Values are converted in the same way as by Bind.
+func (r Request) DecodeForm(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return terrors.InternalService("bind_target", fmt.Sprintf("Form target must be a pointer to a struct, not %T", v),
+			nil)
+	}
+	vs, err := r.FormValues()
+	if err != nil {
+		return err
+	}
+	return bindForm(rv.Elem(), vs, "")
+}
+
+// formKey returns the key for a field named name within the passed prefix: name, or prefix[name] if nested.
+func formKey(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "[" + name + "]"
+}
+
+// bindForm populates the struct v from form values, with field keys nested within the passed prefix.
+func bindForm(v reflect.Value, vs url.Values, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f, fv := t.Field(i), v.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			if err := bindForm(fv, vs, prefix); err != nil {
+				return err
+			}
+			continue
+		}
+		name := strings.Split(f.Tag.Get("form"), ",")[0]
+		if f.PkgPath != "" || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		key := formKey(prefix, name)
+
+		var err error
+		switch {
+		case fv.Kind() == reflect.Struct && !reflect.PtrTo(fv.Type()).Implements(textUnmarshalerType):
+			err = bindForm(fv, vs, key)
+		case fv.Kind() == reflect.Map && fv.Type().Key().Kind() == reflect.String:
+			err = bindFormMap(fv, vs, key)
+		default:
+			values := append(append([]string(nil), vs[key]...), vs[key+"[]"]...)
+			if len(values) > 0 {
+				if err = setField(fv, values); err != nil {
+					err = terrors.BadRequest("invalid_form", fmt.Sprintf("Invalid form field %q: %v", key, err),
+						map[string]string{
+							"field": key})
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bindFormMap populates a map with string keys from values keyed like prefix[key].
+func bindFormMap(v reflect.Value, vs url.Values, prefix string) error {
+	for k, values := range vs {
+		if !strings.HasPrefix(k, prefix+"[") || !strings.HasSuffix(k, "]") {
+			continue
+		}
+		mk := k[len(prefix)+1 : len(k)-1]
+		if strings.ContainsAny(mk, "[]") {
+			continue
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		ev := reflect.New(v.Type().Elem()).Elem()
+		if err := setField(ev, values); err != nil {
+			return terrors.BadRequest("invalid_form", fmt.Sprintf("Invalid form field %q: %v", k, err),
+				map[string]string{
+					"field": k})
+		}
+		v.SetMapIndex(reflect.ValueOf(mk).Convert(v.Type().Key()), ev)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package libhttp
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type formTestInput struct {
+	Email     string   `form:"email"`
+	Interests []string `form:"interest"`
+	Age       int
+	Address   struct {
+		City string `form:"city"`
+		Geo  struct {
+			Lat float64 `form:"lat"`
+		} `form:"geo"`
+	} `form:"address"`
+	Prefs   map[string]string `form:"prefs"`
+	Ignored string            `form:"-"`
+}
+
+func TestRequestDecodeForm(t *testing.T) {
+	t.Parallel()
+
+	body := "email=a%40b.com&interest=x&interest[]=y&Age=30&address[city]=London&address[geo][lat]=51.5" +
+		"&prefs[theme]=dark&prefs[lang]=en&Ignored=z"
+	req := NewRequest(context.Background(), "POST", "/", strings.NewReader(body))
+	v := formTestInput{}
+	require.NoError(t, req.DecodeForm(&v))
+	assert.Equal(t, "a@b.com", v.Email)
+	assert.Equal(t, []string{"x", "y"}, v.Interests)
+	assert.Equal(t, 30, v.Age)
+	assert.Equal(t, "London", v.Address.City)
+	assert.Equal(t, 51.5, v.Address.Geo.Lat)
+	assert.Equal(t, map[string]string{"theme": "dark", "lang": "en"}, v.Prefs)
+	assert.Empty(t, v.Ignored)
+
+	req = NewRequest(context.Background(), "POST", "/", strings.NewReader("Age=old"))
+	err := req.DecodeForm(&v)
+	require.Error(t, err)
+	assert.Equal(t, "bad_request.invalid_form", err.(*terrors.Error).Code)
+
+	// Bind understands form bodies, and EncodeForm produces them
+	req = NewRequest(context.Background(), "POST", "/", nil)
+	req.EncodeForm(url.Values{"email": {"c@d.com"}, "address[city]": {"Paris"}})
+	v = formTestInput{}
+	require.NoError(t, Bind(req, &v))
+	assert.Equal(t, "c@d.com", v.Email)
+	assert.Equal(t, "Paris", v.Address.City)
+}
@@ -0,0 +1,386 @@
+package libhttp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/monzo/slog"
+	"github.com/monzo/terrors"
+)
+
+// A ForwardProxyOption configures a ForwardProxyFilter.
+type ForwardProxyOption func(*forwardProxy)
+
+// ForwardProxyAllow restricts the destinations which may be reached through the proxy to those matching the rules,
+// which have the same syntax as the no-proxy rules of WithProxy (domains and their subdomains, IP addresses and
+// ranges, optionally with a port). Domain rules are matched against the destination as it is requested; IP address
+// and range rules are also matched against the addresses which it resolves to, when the proxy connects to it (so
+// "10.0.0.0/8" allows any name which resolves into that range).
+func ForwardProxyAllow(rules ...string) ForwardProxyOption {
+	return func(p *forwardProxy) {
+		p.allow = append(p.allow, parseNoProxy(rules)...)
+	}
+}
+
+// ForwardProxyDeny forbids destinations matching the rules (see ForwardProxyAllow), even if they are allowed. A name
+// which resolves into a denied range is forbidden, as well as a request for an address in the range.
+func ForwardProxyDeny(rules ...string) ForwardProxyOption {
+	return func(p *forwardProxy) {
+		p.deny = append(p.deny, parseNoProxy(rules)...)
+	}
+}
+
+// ForwardProxyBandwidth limits the data transferred through each CONNECT tunnel to bytesPerSecond, in each direction.
+func ForwardProxyBandwidth(bytesPerSecond int) ForwardProxyOption {
+	return func(p *forwardProxy) {
+		p.bandwidth = bytesPerSecond
+	}
+}
+
+// ForwardProxyIdleTimeout closes CONNECT tunnels through which no data has been transferred, in either direction, for
+// the duration. The default is 5 minutes; 0 means tunnels are never closed for being idle.
+func ForwardProxyIdleTimeout(d time.Duration) ForwardProxyOption {
+	return func(p *forwardProxy) {
+		p.idleTimeout = d
+	}
+}
+
+// ForwardProxyDialer sets the function used to connect to the destinations of CONNECT tunnels and, unless
+// ForwardProxyTransport is used, of proxied requests. The default is a net.Dialer with a 30 second timeout, which
+// checks each address it resolves against the IP address and range rules before connecting to it; connections made
+// by dial are instead checked once they are open, by their remote address, and closed if it isn't allowed.
+func ForwardProxyDialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) ForwardProxyOption {
+	return func(p *forwardProxy) {
+		p.dial = dial
+	}
+}
+
+// ForwardProxyTransport sets the RoundTripper which sends proxied (non-CONNECT) requests. The IP address and range
+// rules are only applied to the addresses names resolve to if it connects with the proxy's dialer.
+func ForwardProxyTransport(rt http.RoundTripper) ForwardProxyOption {
+	return func(p *forwardProxy) {
+		p.transport = rt
+	}
+}
+
+type forwardProxy struct {
+	allow, deny noProxyRules
+	bandwidth   int
+	idleTimeout time.Duration
+	dial        func(ctx context.Context, network, addr string) (net.Conn, error)
+	transport   http.RoundTripper
+	client      Service
+}
+
+// ForwardProxyFilter makes a server act as a forward (egress) HTTP proxy. Requests for absolute URLs
+// (like "GET http://example.com/ HTTP/1.1") are sent on to their destination, and CONNECT requests open a TCP tunnel
+// to theirs (typically for HTTPS); other requests are passed to the wrapped Service, so the server can still serve its
+// own endpoints:
+//
+//  svc = svc.Filter(libhttp.ForwardProxyFilter(
+//      libhttp.ForwardProxyAllow("api.example.com:443", "10.0.0.0/8"),
+//      libhttp.ForwardProxyBandwidth(1<<20),
+//      libhttp.ForwardProxyIdleTimeout(time.Minute)))
+//
+// Without ForwardProxyAllow, every destination is allowed, so the server must not be reachable from untrusted
+// networks. Requests for other destinations get a 403 error, and those which can't reach their destination a 502.
+// Hop-by-hop headers, including Proxy-Authorization, aren't forwarded; authentication of the proxy's clients can be
+// done by a filter outside this one.
+//
+// Tunnels are only supported over HTTP/1, as their connections are hijacked (see Request.Hijack). They are closed
+// when either end closes its connection, and when the server is stopped.
+func ForwardProxyFilter(opts ...ForwardProxyOption) Filter {
+	p := &forwardProxy{
+		idleTimeout: 5 * time.Minute}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.transport == nil {
+		p.transport = &http.Transport{
+			DialContext:         p.checkedDial,
+			MaxIdleConnsPerHost: 10,
+			IdleConnTimeout:     90 * time.Second,
+			DisableCompression:  true} // responses must be passed on as they were sent
+	}
+	p.client = HttpService(forwardProxyTransport{p.transport})
+
+	return func(req Request, svc Service) Response {
+		switch {
+		case req.Method == http.MethodConnect:
+			return p.tunnel(req)
+		case req.URL != nil && req.URL.IsAbs():
+			return p.forward(req)
+		default:
+			return svc(req)
+		}
+	}
+}
+
+// allowed returns whether requests to the destination may be proxied, as far as can be told before it is resolved.
+func (p *forwardProxy) allowed(u *url.URL) bool {
+	switch {
+	case p.deny.match(u):
+		return false
+	case len(p.allow) == 0 || p.allow.match(u):
+		return true
+	default:
+		// A name may resolve to an address which an IP address or range rule allows
+		return net.ParseIP(u.Hostname()) == nil && p.allow.hasIPRules()
+	}
+}
+
+// allowedAddr returns whether a destination host and port may be connected to at ip, which it resolved to.
+func (p *forwardProxy) allowedAddr(host, port string, ip net.IP) bool {
+	host = strings.ToLower(host)
+	return !p.deny.matchAddr(host, port, ip) && (len(p.allow) == 0 || p.allow.matchAddr(host, port, ip))
+}
+
+// forwardProxyForbiddenError is returned when connecting to a destination which resolved to an address which isn't
+// allowed.
+type forwardProxyForbiddenError struct {
+	addr string
+	ip   net.IP
+}
+
+func (e *forwardProxyForbiddenError) Error() string {
+	return fmt.Sprintf("%s resolved to %s, which is not allowed", e.addr, e.ip)
+}
+
+// checkedDial connects to addr, but only at an address which the rules allow it to be connected to.
+func (p *forwardProxy) checkedDial(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	check := func(ip net.IP) error {
+		if !p.allowedAddr(host, port, ip) {
+			return &forwardProxyForbiddenError{addr, ip}
+		}
+		return nil
+	}
+	if p.dial == nil {
+		d := &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			Control: func(_, address string, _ syscall.RawConn) error {
+				ip, _, _ := net.SplitHostPort(address)
+				return check(net.ParseIP(ip))
+			}}
+		return d.DialContext(ctx, network, addr)
+	}
+	conn, err := p.dial(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		if err := check(tcpAddr.IP); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// forwardProxyTransport turns errors connecting to forbidden addresses into terrors, so that they survive
+// HttpService.
+type forwardProxyTransport struct {
+	http.RoundTripper
+}
+
+func (t forwardProxyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rsp, err := t.RoundTripper.RoundTrip(req)
+	var ferr *forwardProxyForbiddenError
+	if err != nil && errors.As(err, &ferr) {
+		err = terrors.Forbidden("destination", ferr.Error(), nil)
+	}
+	return rsp, err
+}
+
+// hopHeaders are the hop-by-hop headers, which are meaningful only for a single connection (RFC 7230, section 6.1).
+var hopHeaders = []string{"Connection", "Proxy-Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade"}
+
+// removeHopHeaders returns a copy of h without its hop-by-hop headers, including those listed in its Connection header.
+func removeHopHeaders(h http.Header) http.Header {
+	h = h.Clone()
+	for _, v := range h.Values("Connection") {
+		for _, name := range strings.Split(v, ",") {
+			h.Del(strings.TrimSpace(name))
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+	return h
+}
+
+// forward sends a request for an absolute URL to its destination.
+func (p *forwardProxy) forward(req Request) Response {
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return Response{Error: BadRequest("Unsupported scheme %q", req.URL.Scheme)}
+	}
+	if !p.allowed(req.URL) {
+		return Response{Error: Forbidden("Proxying to %s is not allowed", req.URL.Host)}
+	}
+	out := Request{
+		Request: req.Request,
+		Context: req}
+	out.Header = removeHopHeaders(req.Header)
+	out.RequestURI = ""
+	if _, ok := out.Header["User-Agent"]; !ok {
+		out.Header.Set("User-Agent", "") // stop net/http adding its own
+	}
+	rsp := p.client(out)
+	if terrors.PrefixMatches(rsp.Error, terrors.ErrForbidden) {
+		slog.Debug(req, "Refused to proxy request: %v", rsp.Error)
+		return Response{Error: Forbidden("Proxying to %s is not allowed", req.URL.Host)}
+	}
+	if rsp.Error != nil {
+		slog.Debug(req, "Failed to proxy request to %s: %v", req.URL.Host, rsp.Error)
+		err := NewError(http.StatusBadGateway, "Failed to reach %s", req.URL.Host).WithCause(rsp.Error)
+		return Response{Error: err}
+	}
+	rsp.Header = removeHopHeaders(rsp.Header)
+	rsp.Request = &req
+	return rsp
+}
+
+// tunnel opens a TCP tunnel to the destination of a CONNECT request.
+func (p *forwardProxy) tunnel(req Request) Response {
+	addr := req.Host
+	if req.URL != nil && req.URL.Host != "" {
+		addr = req.URL.Host
+	}
+	if _, port, err := net.SplitHostPort(addr); err != nil || port == "" {
+		return Response{Error: BadRequest("Invalid CONNECT destination %q", addr)}
+	}
+	// The rules' default port is 443 for https, which is the usual destination of tunnels
+	if !p.allowed(&url.URL{Scheme: "https", Host: addr}) {
+		return Response{Error: Forbidden("Tunnelling to %s is not allowed", addr)}
+	}
+	if req.hijacker == nil {
+		return Response{Error: NewError(http.StatusHTTPVersionNotSupported, "CONNECT requires HTTP/1")}
+	}
+
+	upstream, err := p.checkedDial(req, "tcp", addr)
+	var ferr *forwardProxyForbiddenError
+	if errors.As(err, &ferr) {
+		slog.Debug(req, "Refused to open tunnel: %v", err)
+		return Response{Error: Forbidden("Tunnelling to %s is not allowed", addr)}
+	}
+	if err != nil {
+		slog.Debug(req, "Failed to open tunnel to %s: %v", addr, err)
+		return Response{Error: NewError(http.StatusBadGateway, "Failed to reach %s", addr).WithCause(err)}
+	}
+	conn, brw, err := req.Hijack()
+	if err != nil {
+		upstream.Close()
+		return Response{Error: err}
+	}
+	conn.SetDeadline(time.Time{}) // clear any set by the server
+	if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		conn.Close()
+		upstream.Close()
+		return Response{}
+	}
+	if p.bandwidth > 0 {
+		upstream = newRateLimitedConn(upstream,
+			[]*tokenBucket{bandwidthBucket(p.bandwidth)},
+			[]*tokenBucket{bandwidthBucket(p.bandwidth)})
+	}
+	t := &proxyTunnel{
+		client:      conn,
+		upstream:    upstream,
+		buffered:    brw.Reader,
+		idleTimeout: p.idleTimeout,
+		done:        make(chan struct{})}
+	if req.server != nil {
+		t.serverDone = req.server.Done()
+	}
+	go t.run()
+	return Response{}
+}
+
+// proxyTunnel copies data between a client's connection and the destination of its tunnel.
+type proxyTunnel struct {
+	client, upstream net.Conn
+	buffered         *bufio.Reader // data the client sent before its connection was hijacked
+	idleTimeout      time.Duration
+	last             int64 // atomic; UnixNano of the last transfer in either direction
+	serverDone       <-chan struct{}
+	done             chan struct{} // closed when the tunnel is closed
+	closeOnce        sync.Once
+}
+
+func (t *proxyTunnel) run() {
+	t.touch()
+	go func() {
+		select {
+		case <-t.done:
+		case <-t.serverDone:
+			t.close()
+		}
+	}()
+	if n := t.buffered.Buffered(); n > 0 {
+		b, _ := t.buffered.Peek(n)
+		if _, err := t.upstream.Write(b); err != nil {
+			t.close()
+			return
+		}
+	}
+	go t.copy(t.upstream, t.client)
+	t.copy(t.client, t.upstream)
+}
+
+func (t *proxyTunnel) touch() {
+	atomic.StoreInt64(&t.last, time.Now().UnixNano())
+}
+
+// idle returns whether the tunnel has been idle for longer than its timeout.
+func (t *proxyTunnel) idle() bool {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&t.last))) >= t.idleTimeout
+}
+
+// copy copies from src to dst until either fails or the tunnel is idle, and then closes the tunnel.
+func (t *proxyTunnel) copy(dst, src net.Conn) {
+	defer t.close()
+	buf := make([]byte, 32*1024)
+	for {
+		if t.idleTimeout > 0 {
+			src.SetReadDeadline(time.Now().Add(t.idleTimeout))
+		}
+		n, err := src.Read(buf)
+		if n > 0 {
+			t.touch()
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+		if err != nil {
+			// A read times out when this direction is idle, but the tunnel isn't idle if the other direction isn't
+			if nerr, ok := err.(net.Error); ok && nerr.Timeout() && !t.idle() {
+				continue
+			}
+			return
+		}
+	}
+}
+
+func (t *proxyTunnel) close() {
+	t.closeOnce.Do(func() {
+		close(t.done)
+		t.client.Close()
+		t.upstream.Close()
+	})
+}
@@ -0,0 +1,252 @@
+package libhttp
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestForwardProxy returns a server acting as a forward proxy, which serves "not a proxy request" otherwise.
+func newTestForwardProxy(t *testing.T, opts ...ForwardProxyOption) *Server {
+	svc := Service(func(req Request) Response {
+		return req.Response("not a proxy request")
+	}).Filter(ForwardProxyFilter(opts...)).Filter(ErrorFilter)
+	s, err := Listen(svc, "localhost:0")
+	require.NoError(t, err)
+	return s
+}
+
+// newTestEchoListener returns a listener which echoes whatever is written to the connections it accepts.
+func newTestEchoListener(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	return l
+}
+
+// connectVia opens a tunnel to addr through the proxy, returning the response to the CONNECT request and, if it
+// succeeded, the tunnel.
+func connectVia(t *testing.T, proxy *Server, addr string) (*http.Response, net.Conn) {
+	c, err := net.Dial("tcp", proxy.Listener().Addr().String())
+	require.NoError(t, err)
+	fmt.Fprintf(c, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", addr, addr)
+	rsp, err := http.ReadResponse(bufio.NewReader(c), nil)
+	require.NoError(t, err)
+	if rsp.StatusCode != http.StatusOK {
+		c.Close()
+		return rsp, nil
+	}
+	return rsp, c
+}
+
+func TestForwardProxyHTTP(t *testing.T) {
+	t.Parallel()
+
+	backend, err := Listen(Service(func(req Request) Response {
+		return req.Response(map[string]string{
+			"path":          req.URL.Path,
+			"foo":           req.Header.Get("X-Foo"),
+			"bar":           req.Header.Get("X-Bar"),
+			"authorization": req.Header.Get("Proxy-Authorization")})
+	}), "localhost:0")
+	require.NoError(t, err)
+	defer backend.Stop(context.Background())
+	backendURL := fmt.Sprintf("http://%s", backend.Listener().Addr())
+
+	proxy := newTestForwardProxy(t, ForwardProxyAllow(backend.Listener().Addr().String()))
+	defer proxy.Stop(context.Background())
+	proxyURL, _ := url.Parse(fmt.Sprintf("http://%s", proxy.Listener().Addr()))
+	client := HttpService(&http.Transport{Proxy: http.ProxyURL(proxyURL)}).Filter(ErrorFilter)
+
+	req := NewRequest(context.Background(), "GET", backendURL+"/hello", nil)
+	req.Header.Set("Connection", "X-Foo")
+	req.Header.Set("X-Foo", "hop")
+	req.Header.Set("X-Bar", "end-to-end")
+	req.Header.Set("Proxy-Authorization", "Basic secret")
+	rsp := req.SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+	body := map[string]string{}
+	require.NoError(t, rsp.Decode(&body))
+	assert.Equal(t, map[string]string{
+		"path":          "/hello",
+		"foo":           "",
+		"bar":           "end-to-end",
+		"authorization": ""}, body)
+
+	// Destinations which aren't allowed are forbidden (names once they resolve, as IP address rules may allow them)
+	rsp = NewRequest(context.Background(), "GET", "http://192.0.2.1/", nil).SendVia(client).Response()
+	assert.Equal(t, http.StatusForbidden, rsp.StatusCode)
+	rsp = NewRequest(context.Background(), "GET", "http://localhost/", nil).SendVia(client).Response()
+	assert.Equal(t, http.StatusForbidden, rsp.StatusCode)
+
+	// Other requests are served by the wrapped service
+	rsp = NewRequest(context.Background(), "GET", proxyURL.String()+"/", nil).
+		SendVia(HttpService(&http.Transport{})).Response()
+	require.NoError(t, rsp.Error)
+	b, _ := rsp.BodyBytes(true)
+	assert.Contains(t, string(b), "not a proxy request")
+}
+
+func TestForwardProxyHTTPUnreachable(t *testing.T) {
+	t.Parallel()
+
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	proxy := newTestForwardProxy(t)
+	defer proxy.Stop(context.Background())
+	proxyURL, _ := url.Parse(fmt.Sprintf("http://%s", proxy.Listener().Addr()))
+	client := HttpService(&http.Transport{Proxy: http.ProxyURL(proxyURL)}).Filter(ErrorFilter)
+	rsp := NewRequest(context.Background(), "GET", "http://"+addr+"/", nil).SendVia(client).Response()
+	assert.Equal(t, http.StatusBadGateway, rsp.StatusCode)
+}
+
+func TestForwardProxyConnect(t *testing.T) {
+	t.Parallel()
+
+	echo := newTestEchoListener(t)
+	defer echo.Close()
+	_, echoPort, _ := net.SplitHostPort(echo.Addr().String())
+	proxy := newTestForwardProxy(t, ForwardProxyAllow("127.0.0.1:"+echoPort), ForwardProxyDeny("127.0.0.2"))
+	defer proxy.Stop(context.Background())
+
+	rsp, c := connectVia(t, proxy, echo.Addr().String())
+	require.NotNil(t, c)
+	defer c.Close()
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	fmt.Fprint(c, "ping\n")
+	line, err := bufio.NewReader(c).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "ping\n", line)
+
+	rsp, c = connectVia(t, proxy, "127.0.0.1:1")
+	assert.Nil(t, c)
+	assert.Equal(t, http.StatusForbidden, rsp.StatusCode)
+	rsp, c = connectVia(t, proxy, "127.0.0.2:"+echoPort)
+	assert.Nil(t, c)
+	assert.Equal(t, http.StatusForbidden, rsp.StatusCode)
+
+	// Tunnels are closed when the server stops
+	_, c = connectVia(t, proxy, echo.Addr().String())
+	require.NotNil(t, c)
+	defer c.Close()
+	proxy.Stop(context.Background())
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = c.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestForwardProxyResolvedAddresses(t *testing.T) {
+	t.Parallel()
+
+	echo := newTestEchoListener(t)
+	defer echo.Close()
+	_, echoPort, _ := net.SplitHostPort(echo.Addr().String())
+	backend, err := Listen(Service(func(req Request) Response {
+		return req.Response("backend")
+	}), "localhost:0")
+	require.NoError(t, err)
+	defer backend.Stop(context.Background())
+	_, backendPort, _ := net.SplitHostPort(backend.Listener().Addr().String())
+
+	// A name which resolves into a denied range is forbidden, whichever dialer connects to it
+	dialer := &net.Dialer{}
+	for _, opts := range [][]ForwardProxyOption{
+		{ForwardProxyDeny("127.0.0.0/8", "::1")},
+		{ForwardProxyDeny("127.0.0.0/8", "::1"), ForwardProxyDialer(dialer.DialContext)}} {
+		proxy := newTestForwardProxy(t, opts...)
+		defer proxy.Stop(context.Background())
+		rsp, c := connectVia(t, proxy, "localhost:"+echoPort)
+		assert.Nil(t, c)
+		assert.Equal(t, http.StatusForbidden, rsp.StatusCode)
+
+		proxyURL, _ := url.Parse(fmt.Sprintf("http://%s", proxy.Listener().Addr()))
+		client := HttpService(&http.Transport{Proxy: http.ProxyURL(proxyURL)}).Filter(ErrorFilter)
+		rsp2 := NewRequest(context.Background(), "GET", "http://localhost:"+backendPort+"/", nil).
+			SendVia(client).Response()
+		assert.Equal(t, http.StatusForbidden, rsp2.StatusCode)
+	}
+
+	// And one which resolves into an allowed range is allowed
+	proxy := newTestForwardProxy(t, ForwardProxyAllow("127.0.0.0/8", "::1"))
+	defer proxy.Stop(context.Background())
+	rsp, c := connectVia(t, proxy, "localhost:"+echoPort)
+	require.NotNil(t, c)
+	defer c.Close()
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	rsp, c = connectVia(t, proxy, "192.0.2.1:443")
+	assert.Nil(t, c)
+	assert.Equal(t, http.StatusForbidden, rsp.StatusCode)
+}
+
+func TestForwardProxyConnectIdleTimeout(t *testing.T) {
+	t.Parallel()
+
+	echo := newTestEchoListener(t)
+	defer echo.Close()
+	proxy := newTestForwardProxy(t, ForwardProxyIdleTimeout(100*time.Millisecond))
+	defer proxy.Stop(context.Background())
+
+	_, c := connectVia(t, proxy, echo.Addr().String())
+	require.NotNil(t, c)
+	defer c.Close()
+	r := bufio.NewReader(c)
+	// Activity keeps the tunnel open
+	for i := 0; i < 3; i++ {
+		time.Sleep(60 * time.Millisecond)
+		fmt.Fprint(c, "ping\n")
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "ping\n", line)
+	}
+	start := time.Now()
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err := r.ReadByte()
+	assert.Equal(t, io.EOF, err)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestForwardProxyConnectBandwidth(t *testing.T) {
+	t.Parallel()
+
+	echo := newTestEchoListener(t)
+	defer echo.Close()
+	proxy := newTestForwardProxy(t, ForwardProxyBandwidth(8192))
+	defer proxy.Stop(context.Background())
+
+	_, c := connectVia(t, proxy, echo.Addr().String())
+	require.NotNil(t, c)
+	defer c.Close()
+	data := strings.Repeat("x", 12*1024)
+	start := time.Now()
+	go fmt.Fprint(c, data)
+	b, err := ioutil.ReadAll(io.LimitReader(c, int64(len(data))))
+	require.NoError(t, err)
+	assert.Equal(t, data, string(b))
+	// 2KiB can be sent at once; the rest at 8KiB a second
+	assert.True(t, time.Since(start) > time.Second, "took %v", time.Since(start))
+}
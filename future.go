@@ -0,0 +1,92 @@
+package libhttp
+
+import (
+	"context"
+)
+
+// Send round-trips the request via the service. It does not block, instead returning a ResponseFuture representing
+// the asynchronous operation to produce the response. It is equivalent to SendVia(req, svc), and makes fanning out
+// requests through a client read naturally:
+//
+//  users, orders := client.Send(usersReq), client.Send(ordersReq)
+//  rsps := libhttp.WaitAll(users, orders)
+func (svc Service) Send(req Request) *ResponseFuture {
+	return SendVia(req, svc)
+}
+
+// Cancel cancels the request, if it is still in flight: the response has a context cancellation error, or if it has
+// arrived already, reading its body fails. It doesn't affect other requests sharing the request's context, so one
+// call of a fan-out can be abandoned without the rest. Calling Cancel more than once does nothing.
+func (f *ResponseFuture) Cancel() {
+	f.m.Lock()
+	f.cancelled = true
+	body := f.body
+	f.m.Unlock()
+	if f.cancel != nil {
+		f.cancel()
+	}
+	if body != nil {
+		body.Close()
+	}
+}
+
+// WaitAll waits for all the futures' responses, returning them in the same order.
+func WaitAll(fs ...*ResponseFuture) []Response {
+	rsps := make([]Response, len(fs))
+	for i, f := range fs {
+		rsps[i] = f.Response()
+	}
+	return rsps
+}
+
+// WaitAny waits for the first of the futures' responses to become available, returning its index and the response.
+// It doesn't cancel the others. It returns -1 if there are no futures.
+func WaitAny(fs ...*ResponseFuture) (int, Response) {
+	if len(fs) == 0 {
+		return -1, Response{}
+	}
+	first := make(chan int, len(fs))
+	for i, f := range fs {
+		go func(i int, f *ResponseFuture) {
+			<-f.WaitC()
+			first <- i
+		}(i, f)
+	}
+	i := <-first
+	return i, fs[i].Response()
+}
+
+// sendContext is the context of a request sent with SendVia, which its ResponseFuture can cancel. It keeps the
+// request's original context, so that can still be inspected (by TraceFromContext, say).
+type sendContext struct {
+	context.Context
+	parent   context.Context // the unwrapped original context, which Context is derived from
+	original context.Context
+}
+
+// withSendContext returns a copy of the request with a cancellable sendContext.
+func withSendContext(req Request) (Request, context.CancelFunc) {
+	parent := req.unwrappedContext()
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithCancel(parent)
+	req.Context = sendContext{
+		Context:  ctx,
+		parent:   parent,
+		original: req.Context}
+	return req, cancel
+}
+
+// withoutSendContext restores the original context of the response's request, if withSendContext replaced it.
+func withoutSendContext(rsp Response) Response {
+	if rsp.Request == nil {
+		return rsp
+	}
+	if c, ok := rsp.Request.Context.(sendContext); ok {
+		req := *rsp.Request
+		req.Context = c.original
+		rsp.Request = &req
+	}
+	return rsp
+}
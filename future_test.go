@@ -0,0 +1,123 @@
+package libhttp
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseFutureCancel(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	s, err := Listen(Service(func(req Request) Response {
+		d, _ := time.ParseDuration(req.URL.Query().Get("sleep"))
+		select {
+		case <-time.After(d):
+		case <-req.Done():
+		}
+		return req.Response(req.URL.Query().Get("sleep"))
+	}), "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(ctx)
+	base := "http://" + s.Listener().Addr().String()
+	client := NewClient()
+
+	parent, cancel := context.WithCancel(ctx)
+	defer cancel()
+	slow := client.Send(NewRequest(parent, "GET", base+"?sleep=10s", nil))
+	fast := client.Send(NewRequest(parent, "GET", base+"?sleep=50ms", nil))
+	start := time.Now()
+	slow.Cancel()
+	slow.Cancel()
+	rsps := WaitAll(slow, fast)
+	assert.True(t, time.Since(start) < 5*time.Second)
+	require.Error(t, rsps[0].Error)
+	require.NoError(t, rsps[1].Error)
+	var body string
+	require.NoError(t, rsps[1].Decode(&body))
+	assert.Equal(t, "50ms", body)
+	assert.NoError(t, parent.Err())
+
+	// Cancelling once the response has arrived is harmless if it's been read
+	f := client.Send(NewRequest(ctx, "GET", base+"?sleep=0s", nil))
+	rsp := f.Response()
+	require.NoError(t, rsp.Decode(&body))
+	f.Cancel()
+	assert.Equal(t, "0s", body)
+}
+
+func TestWaitAny(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	svc := Service(func(req Request) Response {
+		d, _ := time.ParseDuration(req.URL.Query().Get("sleep"))
+		time.Sleep(d)
+		return req.Response(req.URL.Query().Get("sleep"))
+	})
+	fs := []*ResponseFuture{
+		svc.Send(NewRequest(ctx, "GET", "/?sleep=500ms", nil)),
+		svc.Send(NewRequest(ctx, "GET", "/?sleep=10ms", nil))}
+	i, rsp := WaitAny(fs...)
+	assert.Equal(t, 1, i)
+	var body string
+	require.NoError(t, rsp.Decode(&body))
+	assert.Equal(t, "10ms", body)
+
+	i, _ = WaitAny()
+	assert.Equal(t, -1, i)
+}
+
+// futureTestTransport responds to every request with a short body, without touching the network.
+type futureTestTransport struct{}
+
+func (futureTestTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{},
+		Body:          ioutil.NopCloser(strings.NewReader("ok")),
+		ContentLength: 2,
+		Request:       r}, nil
+}
+
+func TestSendViaUnclosedBodies(t *testing.T) {
+	// Not parallel, as it counts goroutines
+	svc := HttpService(futureTestTransport{})
+	before := runtime.NumGoroutine()
+	rsps := make([]Response, 200)
+	for i := range rsps {
+		rsps[i] = NewRequest(context.Background(), "GET", "http://localhost/", nil).SendVia(svc).Response()
+		require.NoError(t, rsps[i].Error)
+	}
+	// Responses whose bodies haven't been closed don't each pin goroutines, unless their contexts can be cancelled
+	time.Sleep(10 * time.Millisecond)
+	assert.True(t, runtime.NumGoroutine()-before < 50, "%d goroutines", runtime.NumGoroutine()-before)
+
+	// They can still be cancelled once they have arrived, which closes their bodies
+	body := &rc{*strings.NewReader("ok"), 0}
+	f := NewRequest(context.Background(), "GET", "/", nil).SendVia(Service(func(req Request) Response {
+		rsp := req.Response(nil)
+		rsp.Body = body
+		return rsp
+	}))
+	f.Response()
+	f.Cancel()
+	assert.Equal(t, 1, body.closed)
+}
+
+func BenchmarkSendVia(b *testing.B) {
+	b.ReportAllocs()
+	svc := HttpService(futureTestTransport{})
+	req := NewRequest(context.Background(), "GET", "http://localhost/", nil)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rsp := req.SendVia(svc).Response()
+		rsp.Body.Close()
+	}
+}
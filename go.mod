@@ -10,10 +10,10 @@ require (
 	github.com/golang/protobuf v1.4.2 // indirect
 	github.com/monzo/slog v0.0.0-20180411100359-4277a1759ecc
 	github.com/monzo/terrors v0.0.0-20200918120145-1b34fb1ca9c3
-	github.com/nu7hatch/gouuid v0.0.0-20131221200532-179d4d0c4d8d // indirect
+	github.com/nu7hatch/gouuid v0.0.0-20131221200532-179d4d0c4d8d
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/stretchr/testify v1.2.2
 	golang.org/x/net v0.0.0-20190311183353-d8887717615a
 	golang.org/x/text v0.3.0 // indirect
-	google.golang.org/protobuf v1.25.0 // indirect
+	google.golang.org/protobuf v1.25.0
 )
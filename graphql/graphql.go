@@ -0,0 +1,276 @@
+// Package graphql serves GraphQL over HTTP (https://graphql.github.io/graphql-over-http/), with queries run by an
+// Executor which wraps whichever GraphQL implementation holds the schema and its resolvers:
+//
+//  exec := graphql.ExecutorFunc(func(ctx context.Context, p graphql.Params) *graphql.Result {
+//      r := gql.Do(gql.Params{Schema: schema, RequestString: p.Query, OperationName: p.OperationName,
+//          VariableValues: p.Variables, Context: ctx})
+//      ...
+//  })
+//  svc := graphql.Service(exec, graphql.PersistedQueries(graphql.NewMemoryQueryStore(1000)))
+//  router.GET("/graphql", svc)
+//  router.POST("/graphql", svc)
+//
+// Requests' operations are named with libhttp.SetOperation, so metrics, access logs, error reports and hooks can
+// tell the operations served by the endpoint apart, but only with names the server knows (see OperationNames), as
+// clients could otherwise give every request a name of its own.
+package graphql
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/4thel00z/libhttp"
+	"github.com/monzo/terrors"
+)
+
+// Params are the parameters of a GraphQL request.
+type Params struct {
+	Query         string
+	OperationName string
+	Variables     map[string]interface{}
+	Extensions    map[string]interface{}
+	// ReadOnly is set for requests sent with GET, which must not run mutations: executors should fail them.
+	ReadOnly bool
+}
+
+// A Result is the outcome of executing a GraphQL request.
+type Result struct {
+	Data       interface{}            `json:"data,omitempty"`
+	Errors     []*Error               `json:"errors,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// An Error is a GraphQL error.
+type Error struct {
+	Message    string                 `json:"message"`
+	Locations  []Location             `json:"locations,omitempty"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// A Location is a position in a GraphQL document.
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// An Executor executes GraphQL requests against a schema. The context is the libhttp.Request being served.
+type Executor interface {
+	Execute(ctx context.Context, p Params) *Result
+}
+
+// ExecutorFunc adapts a function to an Executor.
+type ExecutorFunc func(ctx context.Context, p Params) *Result
+
+// Execute implements Executor.
+func (f ExecutorFunc) Execute(ctx context.Context, p Params) *Result {
+	return f(ctx, p)
+}
+
+// An Option configures a GraphQL Service.
+type Option func(*options)
+
+type options struct {
+	queries        QueryStore
+	onlyStored     bool
+	operationNames map[string]bool
+}
+
+// PersistedQueries supports automatic persisted queries, as sent by Apollo clients: requests may identify their query
+// by its SHA-256 hash (in the persistedQuery extension) rather than sending it, once it has been stored by a request
+// sending both. Queries which aren't stored get a PERSISTED_QUERY_NOT_FOUND error, prompting clients to send them.
+func PersistedQueries(store QueryStore) Option {
+	return func(o *options) {
+		o.queries = store
+	}
+}
+
+// PersistedQueriesOnly only allows queries which are already in the store, so that a server runs only the queries it
+// knows its clients send. Queries sent in full are run only if they are stored, and are never added to the store.
+func PersistedQueriesOnly(store QueryStore) Option {
+	return func(o *options) {
+		o.queries = store
+		o.onlyStored = true
+	}
+}
+
+// OperationNames lists the operation names which requests may be named with. A request's operation is named if its
+// name is listed or, with PersistedQueriesOnly, if it is one of those its stored query defines; other requests aren't
+// named, so that clients can't create any number of operations in metrics (or fill logs with names of their choosing).
+func OperationNames(names ...string) Option {
+	return func(o *options) {
+		if o.operationNames == nil {
+			o.operationNames = map[string]bool{}
+		}
+		for _, name := range names {
+			o.operationNames[name] = true
+		}
+	}
+}
+
+// request is a GraphQL request, as sent in a JSON body.
+type request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+	Extensions    map[string]interface{} `json:"extensions"`
+}
+
+// Service returns a Service which executes GraphQL requests with exec. Queries are accepted with GET, in the query
+// string, and with POST, as JSON or (with a Content-Type of application/graphql) as the body. Results are sent as JSON
+// with a 200 status, even if they have errors, while requests which are malformed get a bad request error.
+func Service(exec Executor, opts ...Option) libhttp.Service {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return func(req libhttp.Request) libhttp.Response {
+		var r request
+		switch req.Method {
+		case http.MethodGet:
+			q := req.URL.Query()
+			r.Query = q.Get("query")
+			r.OperationName = q.Get("operationName")
+			for _, p := range []struct {
+				name string
+				v    *map[string]interface{}
+			}{{"variables", &r.Variables}, {"extensions", &r.Extensions}} {
+				if s := q.Get(p.name); s != "" {
+					if err := json.Unmarshal([]byte(s), p.v); err != nil {
+						return libhttp.Response{Error: terrors.BadRequest("bad_"+p.name,
+							fmt.Sprintf("Invalid %s: %v", p.name, err), nil)}
+					}
+				}
+			}
+		case http.MethodPost:
+			b, err := req.BodyBytes(true)
+			if err != nil {
+				return libhttp.Response{Error: err}
+			}
+			if mt, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mt == "application/graphql" {
+				r.Query = string(b)
+				r.OperationName = req.URL.Query().Get("operationName")
+			} else if err := json.Unmarshal(b, &r); err != nil {
+				return libhttp.Response{Error: terrors.BadRequest("bad_body", fmt.Sprintf("Invalid request: %v", err),
+					nil)}
+			}
+		default:
+			rsp := libhttp.NewResponse(req)
+			rsp.StatusCode = http.StatusMethodNotAllowed
+			rsp.Header.Set("Allow", "GET, POST")
+			return rsp
+		}
+
+		if gqlErr, err := o.resolveQuery(&r); err != nil {
+			return libhttp.Response{Error: err}
+		} else if gqlErr != nil {
+			return respond(req, &Result{Errors: []*Error{gqlErr}})
+		}
+		if r.Query == "" {
+			return libhttp.Response{Error: terrors.BadRequest("missing_query", "No query was sent", nil)}
+		}
+
+		if name := o.operationName(r.Query, r.OperationName); name != "" {
+			req = libhttp.SetOperation(req, name)
+		}
+		result := exec.Execute(req, Params{
+			Query:         r.Query,
+			OperationName: r.OperationName,
+			Variables:     r.Variables,
+			Extensions:    r.Extensions,
+			ReadOnly:      req.Method != http.MethodPost})
+		if result == nil {
+			result = &Result{}
+		}
+		return respond(req, result)
+	}
+}
+
+func respond(req libhttp.Request, result *Result) libhttp.Response {
+	rsp := libhttp.NewResponse(req)
+	rsp.Encode(result)
+	return rsp
+}
+
+// resolveQuery fills in the query of a request which identifies it as a persisted query, and stores those which are
+// sent with their hash. It returns a GraphQL error to send in place of a result, or an error if the request is
+// malformed.
+func (o options) resolveQuery(r *request) (*Error, error) {
+	var hash string
+	if pq, ok := r.Extensions["persistedQuery"].(map[string]interface{}); ok {
+		hash, _ = pq["sha256Hash"].(string)
+		if v, _ := pq["version"].(float64); v != 1 || hash == "" {
+			return nil, terrors.BadRequest("bad_persisted_query", "Unsupported persisted query", nil)
+		}
+	}
+	switch {
+	case hash == "" && (!o.onlyStored || r.Query == ""):
+		return nil, nil
+	case hash == "":
+		hash = queryHash(r.Query)
+	case o.queries == nil:
+		return persistedQueryError("PERSISTED_QUERY_NOT_SUPPORTED", "PersistedQueryNotSupported"), nil
+	case r.Query != "" && queryHash(r.Query) != strings.ToLower(hash):
+		return nil, terrors.BadRequest("bad_persisted_query", "Persisted query hash doesn't match its query", nil)
+	case r.Query != "" && !o.onlyStored:
+		o.queries.Set(strings.ToLower(hash), r.Query)
+		return nil, nil
+	}
+
+	q, ok := o.queries.Get(strings.ToLower(hash))
+	if !ok {
+		return persistedQueryError("PERSISTED_QUERY_NOT_FOUND", "PersistedQueryNotFound"), nil
+	}
+	r.Query = q
+	return nil, nil
+}
+
+func persistedQueryError(code, message string) *Error {
+	return &Error{
+		Message: message,
+		Extensions: map[string]interface{}{
+			"code": code}}
+}
+
+func queryHash(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
+
+var operationRe = regexp.MustCompile(`(?m)^\s*(?:query|mutation|subscription)\s+([_A-Za-z][_0-9A-Za-z]*)`)
+
+// operationName returns the name a request's operation is named with: its operationName, or else the name of the
+// first operation its query defines, if it has one, and if the name is one the server knows.
+func (o options) operationName(query, name string) string {
+	var defined []string
+	for _, m := range operationRe.FindAllStringSubmatch(query, -1) {
+		defined = append(defined, m[1])
+	}
+	if name == "" && len(defined) > 0 {
+		name = defined[0]
+	}
+	switch {
+	case name == "":
+	case o.operationNames[name]:
+		return name
+	case o.onlyStored:
+		// The query is one of the server's, so the operations it defines are too
+		for _, d := range defined {
+			if d == name {
+				return name
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,165 @@
+package graphql
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/4thel00z/libhttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echoExecutor responds with the parameters it was called with, and the operation the request was named with.
+var echoExecutor = ExecutorFunc(func(ctx context.Context, p Params) *Result {
+	if strings.HasPrefix(p.Query, "mutation") && p.ReadOnly {
+		return &Result{Errors: []*Error{{Message: "mutations can't be sent with GET"}}}
+	}
+	return &Result{Data: map[string]interface{}{
+		"query":     p.Query,
+		"variables": p.Variables,
+		"operation": libhttp.Operation(ctx.(libhttp.Request))}}
+})
+
+func send(t *testing.T, svc libhttp.Service, method, target, contentType, body string) (int, map[string]interface{}) {
+	req := libhttp.NewRequest(context.Background(), method, target, nil)
+	if body != "" {
+		req.Header.Set("Content-Type", contentType)
+		req.Body = ioutil.NopCloser(strings.NewReader(body))
+	}
+	rsp := req.SendVia(svc.Filter(libhttp.ErrorFilter)).Response()
+	v := map[string]interface{}{}
+	if rsp.StatusCode == http.StatusOK {
+		require.NoError(t, rsp.Decode(&v))
+	}
+	return rsp.StatusCode, v
+}
+
+func TestService(t *testing.T) {
+	t.Parallel()
+	svc := Service(echoExecutor, OperationNames("GetUser", "Me"))
+
+	status, v := send(t, svc, "POST", "/graphql", "application/json",
+		`{"query":"query GetUser($id: ID!) { user(id: $id) { name } }","variables":{"id":"1"}}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, map[string]interface{}{
+		"query":     "query GetUser($id: ID!) { user(id: $id) { name } }",
+		"variables": map[string]interface{}{"id": "1"},
+		"operation": "GetUser"}, v["data"])
+
+	status, v = send(t, svc, "POST", "/graphql?operationName=Me", "application/graphql", "{ me { name } }")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "Me", v["data"].(map[string]interface{})["operation"])
+
+	// Operations are only named with names the server knows
+	for _, name := range []string{"Other", strings.Repeat("x", 1000)} {
+		status, v = send(t, svc, "POST", "/graphql?operationName="+name, "application/graphql",
+			"query GetUser { me { name } }")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "", v["data"].(map[string]interface{})["operation"])
+	}
+
+	q := url.Values{"query": {"{ me { name } }"}, "variables": {`{"a":1}`}}
+	status, v = send(t, svc, "GET", "/graphql?"+q.Encode(), "", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, map[string]interface{}{
+		"query":     "{ me { name } }",
+		"variables": map[string]interface{}{"a": float64(1)},
+		"operation": ""}, v["data"])
+
+	// Executors are told not to run mutations sent with GET
+	status, v = send(t, svc, "GET", "/graphql?"+url.Values{"query": {"mutation { x }"}}.Encode(), "", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.NotEmpty(t, v["errors"])
+
+	for _, c := range []struct {
+		method, target, contentType, body string
+		status                            int
+	}{
+		{"POST", "/graphql", "application/json", `{"query":`, http.StatusBadRequest},
+		{"POST", "/graphql", "application/json", `{}`, http.StatusBadRequest},
+		{"GET", "/graphql?query=x&variables=nope", "", "", http.StatusBadRequest},
+		{"PUT", "/graphql", "", "", http.StatusMethodNotAllowed}} {
+		status, _ := send(t, svc, c.method, c.target, c.contentType, c.body)
+		assert.Equal(t, c.status, status, c.body)
+	}
+}
+
+func TestServicePersistedQueries(t *testing.T) {
+	t.Parallel()
+	query := "query Hello { hello }"
+	sum := sha256.Sum256([]byte(query))
+	hash := hex.EncodeToString(sum[:])
+	ext := func(hash string) string {
+		b, _ := json.Marshal(map[string]interface{}{
+			"persistedQuery": map[string]interface{}{"version": 1, "sha256Hash": hash}})
+		return string(b)
+	}
+	errorCode := func(v map[string]interface{}) interface{} {
+		errs, _ := v["errors"].([]interface{})
+		if len(errs) == 0 {
+			return nil
+		}
+		return errs[0].(map[string]interface{})["extensions"].(map[string]interface{})["code"]
+	}
+	byHash := "/graphql?" + url.Values{"extensions": {ext(hash)}}.Encode()
+
+	// Without a store, persisted queries aren't supported
+	_, v := send(t, Service(echoExecutor), "GET", byHash, "", "")
+	assert.Equal(t, "PERSISTED_QUERY_NOT_SUPPORTED", errorCode(v))
+
+	svc := Service(echoExecutor, PersistedQueries(NewMemoryQueryStore(10)))
+	_, v = send(t, svc, "GET", byHash, "", "")
+	assert.Equal(t, "PERSISTED_QUERY_NOT_FOUND", errorCode(v))
+	// Sending the query with the wrong hash fails, and with the right one stores it
+	status, _ := send(t, svc, "POST", "/graphql", "application/json",
+		`{"query":"query Hello { hello }","extensions":`+ext(strings.Repeat("0", 64))+`}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	_, v = send(t, svc, "POST", "/graphql", "application/json",
+		`{"query":"query Hello { hello }","extensions":`+ext(hash)+`}`)
+	assert.Nil(t, errorCode(v))
+	_, v = send(t, svc, "GET", byHash, "", "")
+	assert.Nil(t, errorCode(v))
+	// Automatically persisted queries are sent by clients, so aren't named by the operations they define
+	assert.Equal(t, map[string]interface{}{
+		"query":     query,
+		"variables": nil,
+		"operation": ""}, v["data"])
+
+	// Only stored queries are run when they're required
+	store := NewMemoryQueryStore(0)
+	store.Set(hash, query)
+	svc = Service(echoExecutor, PersistedQueriesOnly(store))
+	_, v = send(t, svc, "POST", "/graphql", "application/json", `{"query":"query Hello { hello }"}`)
+	assert.Nil(t, errorCode(v))
+	assert.Equal(t, query, v["data"].(map[string]interface{})["query"])
+	assert.Equal(t, "Hello", v["data"].(map[string]interface{})["operation"])
+	_, v = send(t, svc, "GET", byHash+"&operationName=Other", "", "")
+	assert.Nil(t, errorCode(v))
+	assert.Equal(t, "", v["data"].(map[string]interface{})["operation"])
+	_, v = send(t, svc, "POST", "/graphql", "application/json", `{"query":"{ other }"}`)
+	assert.Equal(t, "PERSISTED_QUERY_NOT_FOUND", errorCode(v))
+	other := sha256.Sum256([]byte("{ other }"))
+	_, ok := store.Get(hex.EncodeToString(other[:]))
+	assert.False(t, ok)
+}
+
+func TestMemoryQueryStore(t *testing.T) {
+	t.Parallel()
+	s := NewMemoryQueryStore(2)
+	s.Set("a", "1")
+	s.Set("b", "2")
+	s.Get("a")
+	s.Set("c", "3") // evicts b, the least recently used
+	_, ok := s.Get("b")
+	assert.False(t, ok)
+	q, ok := s.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "1", q)
+}
@@ -0,0 +1,65 @@
+package graphql
+
+import (
+	"container/list"
+	"sync"
+)
+
+// A QueryStore stores persisted queries, by the lowercase hex SHA-256 hashes of their text. Implementations must be
+// safe for concurrent use; those backing PersistedQueries may evict queries whenever they like, as clients send them
+// again when they aren't found.
+type QueryStore interface {
+	Get(hash string) (string, bool)
+	Set(hash, query string)
+}
+
+// memoryQueryStore is a QueryStore which keeps a bounded number of queries in memory, evicting the least recently
+// used.
+type memoryQueryStore struct {
+	m          sync.Mutex
+	maxEntries int
+	lru        *list.List // of *memoryQuery, most recently used first
+	items      map[string]*list.Element
+}
+
+type memoryQuery struct {
+	hash, query string
+}
+
+// NewMemoryQueryStore returns a QueryStore which keeps up to maxEntries queries in memory, evicting the least recently
+// used; if maxEntries is 0, it keeps every query.
+func NewMemoryQueryStore(maxEntries int) QueryStore {
+	return &memoryQueryStore{
+		maxEntries: maxEntries,
+		lru:        list.New(),
+		items:      map[string]*list.Element{}}
+}
+
+func (s *memoryQueryStore) Get(hash string) (string, bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	el, ok := s.items[hash]
+	if !ok {
+		return "", false
+	}
+	s.lru.MoveToFront(el)
+	return el.Value.(*memoryQuery).query, true
+}
+
+func (s *memoryQueryStore) Set(hash, query string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if el, ok := s.items[hash]; ok {
+		el.Value.(*memoryQuery).query = query
+		s.lru.MoveToFront(el)
+		return
+	}
+	s.items[hash] = s.lru.PushFront(&memoryQuery{
+		hash:  hash,
+		query: query})
+	for s.maxEntries > 0 && s.lru.Len() > s.maxEntries {
+		el := s.lru.Back()
+		s.lru.Remove(el)
+		delete(s.items, el.Value.(*memoryQuery).hash)
+	}
+}
@@ -0,0 +1,56 @@
+package libhttp
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/monzo/terrors"
+)
+
+// GRPCFilter lets a server serve gRPC alongside its Services on the same listener. gRPC requests (those sent over
+// HTTP/2 with a Content-Type of application/grpc, or one of its application/grpc+proto style variants) are handed to
+// grpcServer, and all others to the wrapped Service. grpcServer is typically a *grpc.Server, which implements
+// http.Handler:
+//
+//  grpcSrv := grpc.NewServer()
+//  pb.RegisterGreeterServer(grpcSrv, &greeter{})
+//  svc = svc.Filter(libhttp.GRPCFilter(grpcSrv)).Filter(libhttp.H2cFilter)
+//  srv, err := libhttp.Listen(svc, ":8080")
+//
+// gRPC requires HTTP/2, so the server must either be given H2cFilter (outside this filter, as above) to accept
+// cleartext gRPC, whose clients connect with prior knowledge, or be started with Serve on a TLS listener which
+// negotiates "h2" over ALPN:
+//
+//  l, err := tls.Listen("tcp", ":8443", &tls.Config{Certificates: certs, NextProtos: []string{"h2", "http/1.1"}})
+//  srv, err := libhttp.Serve(svc.Filter(libhttp.GRPCFilter(grpcSrv)), l)
+//
+// ServeTLS and ListenTLS don't serve HTTP/2, so they can't serve gRPC. Filters outside this one see gRPC requests,
+// and Responses with the status and headers grpcServer wrote (but empty bodies, as it writes them itself).
+func GRPCFilter(grpcServer http.Handler) Filter {
+	return func(req Request, svc Service) Response {
+		if !isGRPCRequest(req) {
+			return svc(req)
+		}
+		rw, ok := req.ResponseWriter()
+		if !ok {
+			return Response{Error: terrors.InternalService("grpc_unsupported",
+				"gRPC requests can only be served by a server", nil)}
+		}
+		grpcServer.ServeHTTP(rw, req.Request.WithContext(req))
+		rsp, _ := req.WrittenResponse()
+		return rsp
+	}
+}
+
+// isGRPCRequest returns whether the request is a gRPC request (rather than, say, a gRPC-Web one).
+func isGRPCRequest(req Request) bool {
+	if req.ProtoMajor != 2 {
+		return false
+	}
+	mt, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return mt == "application/grpc" || strings.HasPrefix(mt, "application/grpc+")
+}
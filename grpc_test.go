@@ -0,0 +1,113 @@
+package libhttp
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+)
+
+func TestGRPCFilter(t *testing.T) {
+	t.Parallel()
+
+	// Stands in for a grpc.Server, which responds with a status in its trailers
+	grpcServer := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		rw.Header().Set("Content-Type", "application/grpc")
+		rw.Header().Set("Trailer", "Grpc-Status")
+		rw.WriteHeader(http.StatusOK)
+		rw.(http.Flusher).Flush()
+		rw.Write(b)
+		rw.Header().Set("Grpc-Status", "0")
+	})
+	svc := Service(func(req Request) Response {
+		return req.Response("libhttp " + req.Proto)
+	})
+	// Filters outside GRPCFilter see the status and headers of gRPC responses
+	statuses := make(chan string, 10)
+	logged := func(req Request, svc Service) Response {
+		rsp := svc(req)
+		statuses <- fmt.Sprintf("%d %s", rsp.StatusCode, rsp.Header.Get("Content-Type"))
+		return rsp
+	}
+	s, err := Listen(svc.Filter(GRPCFilter(grpcServer)).Filter(logged).Filter(H2cFilter), "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+	url := "http://" + s.Listener().Addr().String() + "/greeter.Greeter/SayHello"
+	h2c := NewClient(WithRoundTripper(&http.Transport{}), WithH2c())
+
+	for _, ct := range []string{"application/grpc", "application/grpc+proto"} {
+		req := NewRequest(context.Background(), "POST", url, nil)
+		req.Header.Set("Content-Type", ct)
+		req.Body = ioutil.NopCloser(strings.NewReader("\x00\x00\x00\x00\x00"))
+		rsp := req.SendVia(h2c).Response()
+		require.NoError(t, rsp.Error)
+		b, err := rsp.BodyBytes(true)
+		require.NoError(t, err)
+		assert.Equal(t, "\x00\x00\x00\x00\x00", string(b))
+		assert.Equal(t, "0", rsp.Trailer.Get("Grpc-Status"))
+		assert.Equal(t, "200 application/grpc", <-statuses)
+	}
+
+	// Other requests, including ones with a gRPC content type over HTTP/1, are served by the Service
+	for _, c := range []struct {
+		client      Service
+		contentType string
+		body        string
+	}{
+		{h2c, "application/json", `"libhttp HTTP/2.0"`},
+		{h2c, "application/grpc-web", `"libhttp HTTP/2.0"`},
+		{NewClient(), "application/grpc", `"libhttp HTTP/1.1"`}} {
+		req := NewRequest(context.Background(), "POST", url, nil)
+		req.Header.Set("Content-Type", c.contentType)
+		rsp := req.SendVia(c.client).Response()
+		require.NoError(t, rsp.Error)
+		b, _ := rsp.BodyBytes(true)
+		assert.Equal(t, c.body, strings.TrimSpace(string(b)), c.contentType)
+	}
+}
+
+func TestGRPCFilterTLS(t *testing.T) {
+	t.Parallel()
+
+	grpcServer := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		rw.Header().Set("Content-Type", "application/grpc")
+		rw.Header().Set("Trailer", "Grpc-Status")
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(b)
+		rw.Header().Set("Grpc-Status", "0")
+	})
+	svc := Service(func(req Request) Response {
+		return req.Response("libhttp " + req.Proto)
+	})
+	l, err := tls.Listen("tcp", "localhost:0", &tls.Config{
+		Certificates: []tls.Certificate{keypair(t, []string{"localhost"})},
+		NextProtos:   []string{"h2", "http/1.1"}})
+	require.NoError(t, err)
+	s, err := Serve(svc.Filter(GRPCFilter(grpcServer)), l)
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+	url := "https://" + s.Listener().Addr().String() + "/greeter.Greeter/SayHello"
+	h2 := HttpService(&http2.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true}})
+
+	req := NewRequest(context.Background(), "POST", url, nil)
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Body = ioutil.NopCloser(strings.NewReader("\x00\x00\x00\x00\x00"))
+	rsp := req.SendVia(h2).Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, "HTTP/2.0", rsp.Proto)
+	b, err := rsp.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, "\x00\x00\x00\x00\x00", string(b))
+	assert.Equal(t, "0", rsp.Trailer.Get("Grpc-Status"))
+}
@@ -0,0 +1,228 @@
+package libhttp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/monzo/terrors"
+)
+
+// GRPCWebFilter lets browsers call gRPC APIs, by translating gRPC-Web requests (those with a Content-Type of
+// application/grpc-web or application/grpc-web-text, or one of their +proto style variants) to gRPC ones handed to
+// grpcServer, and its responses back to gRPC-Web. All other requests are passed to the wrapped Service:
+//
+//  grpcSrv := grpc.NewServer()
+//  pb.RegisterGreeterServer(grpcSrv, &greeter{})
+//  svc = svc.Filter(libhttp.GRPCWebFilter(grpcSrv)).Filter(libhttp.GRPCFilter(grpcSrv)).Filter(libhttp.H2cFilter)
+//
+// gRPC-Web works over HTTP/1.1 as well as HTTP/2; trailers are sent at the end of the body (as the protocol requires,
+// since browsers can't read HTTP trailers), and bodies of -text requests and responses are base64 encoded.
+// grpcServer is typically a *grpc.Server, but can be any http.Handler speaking gRPC, including a Service which proxies
+// requests to a remote gRPC server. Browsers calling from other origins need CORSFilter to allow the gRPC-Web
+// headers, and to expose the Grpc-Status and Grpc-Message headers. Filters outside this one see Responses with the
+// status and headers which were sent (but empty bodies, as they are written by the gRPC server).
+func GRPCWebFilter(grpcServer http.Handler) Filter {
+	return func(req Request, svc Service) Response {
+		contentType, text, ok := grpcWebContentType(req)
+		if !ok {
+			return svc(req)
+		}
+		rw, ok := req.ResponseWriter()
+		if !ok {
+			return Response{Error: terrors.InternalService("grpc_unsupported",
+				"gRPC-Web requests can only be served by a server", nil)}
+		}
+
+		r := req.Request.WithContext(req)
+		r.Header = req.Header.Clone()
+		subtype := strings.TrimPrefix(strings.TrimPrefix(contentType, "application/grpc-web"), "-text") // "+proto", say
+		r.Header.Set("Content-Type", "application/grpc"+subtype)
+		r.Header.Set("Te", "trailers")
+		r.Header.Del("Content-Length")
+		r.ContentLength = -1
+		r.Proto, r.ProtoMajor, r.ProtoMinor = "HTTP/2.0", 2, 0
+		if text && r.Body != nil {
+			r.Body = &base64Reader{rc: r.Body}
+		}
+
+		w := &grpcWebWriter{
+			rw:          rw,
+			header:      http.Header{},
+			contentType: contentType}
+		if text {
+			w.enc = base64.NewEncoder(base64.StdEncoding, rw)
+		}
+		grpcServer.ServeHTTP(w, r)
+		w.finish()
+		rsp, _ := req.WrittenResponse()
+		return rsp
+	}
+}
+
+// grpcWebContentType returns the media type of a gRPC-Web request, and whether it is a -text one.
+func grpcWebContentType(req Request) (string, bool, bool) {
+	mt, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || req.Method != http.MethodPost {
+		return "", false, false
+	}
+	switch {
+	case mt == "application/grpc-web-text" || strings.HasPrefix(mt, "application/grpc-web-text+"):
+		return mt, true, true
+	case mt == "application/grpc-web" || strings.HasPrefix(mt, "application/grpc-web+"):
+		return mt, false, true
+	}
+	return "", false, false
+}
+
+// grpcWebWriter is handed to a gRPC server as its http.ResponseWriter, translating its response to gRPC-Web.
+type grpcWebWriter struct {
+	rw          http.ResponseWriter
+	header      http.Header // as set by the gRPC server
+	contentType string      // of the request, which the response is sent with
+	enc         io.WriteCloser
+	wroteHeader bool
+	trailers    []string // names declared in the Trailer header
+}
+
+func (w *grpcWebWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *grpcWebWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	h := w.rw.Header()
+	for k, v := range w.header {
+		switch {
+		case k == "Trailer":
+			for _, names := range v {
+				for _, name := range strings.Split(names, ",") {
+					w.trailers = append(w.trailers, http.CanonicalHeaderKey(strings.TrimSpace(name)))
+				}
+			}
+		case !strings.HasPrefix(k, http.TrailerPrefix):
+			h[k] = v
+		}
+	}
+	h.Set("Content-Type", w.contentType)
+	h.Del("Content-Length")
+	w.rw.WriteHeader(status)
+}
+
+func (w *grpcWebWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.enc != nil {
+		return w.enc.Write(b)
+	}
+	return w.rw.Write(b)
+}
+
+func (w *grpcWebWriter) Flush() {
+	w.WriteHeader(http.StatusOK)
+	if w.enc != nil {
+		// Pad what has been written so far so it can be decoded; padding is allowed between frames
+		w.enc.Close()
+		w.enc = base64.NewEncoder(base64.StdEncoding, w.rw)
+	}
+	if f, ok := w.rw.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// finish writes the trailers the gRPC server set as a trailer frame at the end of the body.
+func (w *grpcWebWriter) finish() {
+	sent := w.wroteHeader
+	w.WriteHeader(http.StatusOK)
+	trailers := http.Header{}
+	if sent {
+		for _, name := range w.trailers {
+			if v, ok := w.header[name]; ok {
+				trailers[name] = v
+			}
+		}
+	}
+	for k, v := range w.header {
+		if strings.HasPrefix(k, http.TrailerPrefix) {
+			trailers[http.CanonicalHeaderKey(strings.TrimPrefix(k, http.TrailerPrefix))] = v
+		}
+	}
+	if len(trailers) > 0 {
+		names := make([]string, 0, len(trailers))
+		for name := range trailers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		payload := &bytes.Buffer{}
+		for _, name := range names {
+			for _, v := range trailers[name] {
+				payload.WriteString(strings.ToLower(name) + ": " + v + "\r\n")
+			}
+		}
+		frame := make([]byte, 5, 5+payload.Len())
+		frame[0] = 0x80 // a trailer frame
+		binary.BigEndian.PutUint32(frame[1:], uint32(payload.Len()))
+		w.Write(append(frame, payload.Bytes()...))
+	}
+	if w.enc != nil {
+		w.enc.Close()
+	}
+}
+
+// base64Reader decodes a base64 encoded gRPC-Web request body, which may contain padding between frames (so it is
+// decoded one quantum at a time, rather than with base64.NewDecoder).
+type base64Reader struct {
+	rc  io.ReadCloser
+	in  []byte // encoded input which hasn't been decoded yet
+	out []byte // decoded output which hasn't been read yet
+	err error
+}
+
+func (r *base64Reader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 && r.err == nil {
+		buf := make([]byte, 4096)
+		n, err := r.rc.Read(buf)
+		r.in = append(r.in, buf[:n]...)
+		quanta := len(r.in) / 4 * 4
+		for i := 0; i < quanta; i += 4 {
+			dst := make([]byte, 3)
+			m, derr := base64.StdEncoding.Decode(dst, r.in[i:i+4])
+			if derr != nil {
+				r.err = terrors.BadRequest("invalid_base64", "Invalid base64 in gRPC-Web request body", nil)
+				break
+			}
+			r.out = append(r.out, dst[:m]...)
+		}
+		r.in = r.in[quanta:]
+		if err == io.EOF && r.err == nil && len(r.in) > 0 {
+			// Tolerate a final quantum which isn't padded
+			dst := make([]byte, 3)
+			m, derr := base64.RawStdEncoding.Decode(dst, r.in)
+			if derr != nil {
+				r.err = terrors.BadRequest("invalid_base64", "Invalid base64 in gRPC-Web request body", nil)
+				break
+			}
+			r.out, r.in = append(r.out, dst[:m]...), nil
+		}
+		if err != nil && r.err == nil {
+			r.err = err
+		}
+	}
+	if len(r.out) > 0 {
+		n := copy(p, r.out)
+		r.out = r.out[n:]
+		return n, nil
+	}
+	return 0, r.err
+}
+
+func (r *base64Reader) Close() error {
+	return r.rc.Close()
+}
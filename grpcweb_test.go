@@ -0,0 +1,97 @@
+package libhttp
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGRPCWebFilter(t *testing.T) {
+	t.Parallel()
+
+	// Stands in for a grpc.Server, echoing the request's messages, and responding with a status and metadata in its
+	// trailers (or, for empty requests, only in its headers)
+	grpcServer := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			http.Error(rw, "not gRPC", http.StatusUnsupportedMediaType)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		rw.Header().Set("Content-Type", r.Header.Get("Content-Type"))
+		if len(b) == 0 {
+			rw.Header().Set("Grpc-Status", "3")
+			rw.Header().Set("Grpc-Message", "empty")
+			rw.WriteHeader(http.StatusOK)
+			return
+		}
+		rw.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		rw.WriteHeader(http.StatusOK)
+		rw.(http.Flusher).Flush()
+		rw.Write(b)
+		rw.Header().Set("Grpc-Status", "0")
+		rw.Header().Set(http.TrailerPrefix+"X-Meta", "a")
+	})
+	svc := Service(func(req Request) Response {
+		return req.Response("libhttp")
+	})
+	// Filters outside GRPCWebFilter see the status and headers of gRPC-Web responses
+	statuses := make(chan string, 10)
+	logged := func(req Request, svc Service) Response {
+		rsp := svc(req)
+		statuses <- fmt.Sprintf("%d %s", rsp.StatusCode, rsp.Header.Get("Content-Type"))
+		return rsp
+	}
+	s, err := Listen(svc.Filter(GRPCWebFilter(grpcServer)).Filter(logged), "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+	url := "http://" + s.Listener().Addr().String() + "/greeter.Greeter/SayHello"
+	send := func(contentType, body string) (Response, string) {
+		req := NewRequest(context.Background(), "POST", url, nil)
+		req.Header.Set("Content-Type", contentType)
+		req.Body = ioutil.NopCloser(strings.NewReader(body))
+		rsp := req.SendVia(NewClient()).Response()
+		require.NoError(t, rsp.Error)
+		b, err := rsp.BodyBytes(true)
+		require.NoError(t, err)
+		return rsp, string(b)
+	}
+	messages := "\x00\x00\x00\x00\x02hi" + "\x00\x00\x00\x00\x01!"
+	trailers := "\x80\x00\x00\x00\x1bgrpc-status: 0\r\nx-meta: a\r\n"
+
+	rsp, body := send("application/grpc-web+proto", messages)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Equal(t, "application/grpc-web+proto", rsp.Header.Get("Content-Type"))
+	assert.Empty(t, rsp.Header.Get("Trailer"))
+	assert.Equal(t, messages+trailers, body)
+	assert.Equal(t, "200 application/grpc-web+proto", <-statuses)
+
+	// Text requests may be padded between frames; responses are padded wherever the server flushed
+	text := base64.StdEncoding.EncodeToString([]byte(messages[:7])) +
+		base64.StdEncoding.EncodeToString([]byte(messages[7:]))
+	rsp, body = send("application/grpc-web-text", text)
+	assert.Equal(t, "application/grpc-web-text", rsp.Header.Get("Content-Type"))
+	decoded := ""
+	for _, chunk := range strings.SplitAfter(body, "=") {
+		b, err := base64.StdEncoding.DecodeString(chunk)
+		require.NoError(t, err, chunk)
+		decoded += string(b)
+	}
+	assert.Equal(t, messages+trailers, decoded)
+
+	// Trailers-only responses are sent as headers
+	rsp, body = send("application/grpc-web", "")
+	assert.Equal(t, "3", rsp.Header.Get("Grpc-Status"))
+	assert.Equal(t, "empty", rsp.Header.Get("Grpc-Message"))
+	assert.Empty(t, body)
+
+	// Other requests are served by the Service
+	rsp, body = send("application/json", "{}")
+	assert.Equal(t, `"libhttp"`, strings.TrimSpace(body))
+}
@@ -3,6 +3,7 @@ package libhttp
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
 	"math"
 	"net"
 	"net/http"
@@ -52,8 +53,8 @@ type h2cInfo struct {
 	h2s   *http2.Server
 }
 
-// hijackedConn represents a network connection that has been hijacked for a h2c upgrade. This is necessary because we
-// need to know when the connection has been closed, to know if/when graceful shutdown completes.
+// hijackedConn represents a network connection that has been hijacked for a h2c upgrade (or by Request.Hijack). This is
+// necessary because we need to know when the connection has been closed, to know if/when graceful shutdown completes.
 type hijackedConn struct {
 	net.Conn
 	onClose   func(*hijackedConn)
@@ -85,23 +86,29 @@ func (h h2cHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 }
 
 func shutdownH2c(ctx context.Context, srv *Server, h2c *h2cInfo) {
+	drainHijackedConns(ctx, h2c.conns)
+	h2cConns.Delete(srv)
+}
+
+// drainHijackedConns waits for the passed set of hijacked connections to close, until the context expires, at which
+// point any that remain are closed forcefully.
+func drainHijackedConns(ctx context.Context, conns mapset.Set) {
 gracefulCloseLoop:
-	for _, _c := range h2c.conns.ToSlice() {
+	for _, _c := range conns.ToSlice() {
 		c := _c.(*hijackedConn)
 		select {
 		case <-ctx.Done():
 			break gracefulCloseLoop
 		case <-c.closed:
-			h2c.conns.Remove(c)
+			conns.Remove(c)
 		}
 	}
 	// If any connections remain after gracefulCloseLoop, we need to forcefully close them
-	for _, _c := range h2c.conns.ToSlice() {
+	for _, _c := range conns.ToSlice() {
 		c := _c.(*hijackedConn)
 		c.Close()
-		h2c.conns.Remove(c)
+		conns.Remove(c)
 	}
-	h2cConns.Delete(srv)
 }
 
 func setupH2cHijacker(req Request, rw http.ResponseWriter) (http.ResponseWriter, *http2.Server, error) {
@@ -148,3 +155,64 @@ func setupH2cHijacker(req Request, rw http.ResponseWriter) (http.ResponseWriter,
 		}}
 	return h, h2c.h2s, nil
 }
+
+// WithH2c makes the client send plain http:// requests over HTTP/2 without TLS (h2c), with prior knowledge: it
+// assumes the server supports it, as libhttp servers with H2cFilter do. Requests to a server are then multiplexed
+// over a single connection. As h2c is unencrypted, it should only be used within trusted networks. https:// requests
+// are sent by the client's RoundTripper as usual.
+//
+// Connections are made with the dialer of the client's Transport, so options like WithConnectTimeout and
+// WithUnixSocket still apply.
+func WithH2c() ClientOption {
+	return func(o *clientOptions) {
+		o.h2c = true
+	}
+}
+
+// h2cTransport sends http:// requests with h2c, and others with the underlying RoundTripper.
+type h2cTransport struct {
+	h2c  *http2.Transport
+	next http.RoundTripper
+}
+
+func newH2cTransport(next http.RoundTripper) *h2cTransport {
+	var dial func(ctx context.Context, network, addr string) (net.Conn, error)
+	switch t := next.(type) {
+	case *http.Transport:
+		dial = t.DialContext
+	case timeoutTransport:
+		dial = t.DialContext
+	}
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	return &h2cTransport{
+		h2c: &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dial(context.Background(), network, addr)
+			}},
+		next: next}
+}
+
+func (t *h2cTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "http" {
+		return t.next.RoundTrip(req)
+	}
+	// http2.Transport only accepts https URLs, but it doesn't use TLS when AllowHTTP is set and it dials with DialTLS
+	r := req.Clone(req.Context())
+	r.URL.Scheme = "https"
+	rsp, err := t.h2c.RoundTrip(r)
+	if rsp != nil {
+		rsp.Request = req
+	}
+	return rsp, err
+}
+
+// CloseIdleConnections closes the idle connections of both transports.
+func (t *h2cTransport) CloseIdleConnections() {
+	t.h2c.CloseIdleConnections()
+	if c, ok := t.next.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
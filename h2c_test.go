@@ -0,0 +1,54 @@
+package libhttp
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithH2c(t *testing.T) {
+	t.Parallel()
+	var m sync.Mutex
+	remotes := map[string]bool{}
+	svc := Service(func(req Request) Response {
+		m.Lock()
+		remotes[req.RemoteAddr] = true
+		m.Unlock()
+		return req.Response(req.Proto)
+	})
+	s, err := Listen(svc.Filter(H2cFilter), "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+	url := "http://" + s.Listener().Addr().String() + "/"
+
+	client := NewClient(
+		WithRoundTripper(&http.Transport{}),
+		WithH2c())
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rsp := NewRequest(context.Background(), "GET", url, nil).SendVia(client).Response()
+			require.NoError(t, rsp.Error)
+			var proto string
+			require.NoError(t, rsp.Decode(&proto))
+			assert.Equal(t, "HTTP/2.0", proto)
+			assert.Equal(t, url, rsp.Request.URL.String())
+		}()
+	}
+	wg.Wait()
+	// All requests were multiplexed over one connection
+	assert.Len(t, remotes, 1)
+
+	// Without the option, HTTP/1.1 is used
+	rsp := NewRequest(context.Background(), "GET", url, nil).SendVia(NewClient()).Response()
+	require.NoError(t, rsp.Error)
+	var proto string
+	require.NoError(t, rsp.Decode(&proto))
+	assert.Equal(t, "HTTP/1.1", proto)
+}
@@ -0,0 +1,246 @@
+// Package health checks the health of a service's dependencies (databases, queues, upstream services and so on), so
+// that its readiness reflects whether it can do its job:
+//
+//  checks := health.NewRegistry()
+//  checks.Register(health.CheckFunc("db", db.PingContext), health.Timeout(time.Second))
+//  checks.Register(health.HTTP("billing", "http://billing/healthz", libhttp.Client), health.CacheFor(5*time.Second))
+//  router.GET("/readyz", checks.Service())
+//
+// Checks are run concurrently, each bounded by a timeout, and their results can be cached so that frequent probes
+// don't overload the dependencies.
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/4thel00z/libhttp"
+	"github.com/monzo/terrors"
+)
+
+// Statuses of checks and reports.
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// A Check checks the health of a dependency.
+type Check interface {
+	// Name returns the name which the check's results are reported under.
+	Name() string
+	// Check returns an error if the dependency is unhealthy. It should return promptly when ctx is done.
+	Check(ctx context.Context) error
+}
+
+// CheckFunc returns a Check with the name, which calls f.
+func CheckFunc(name string, f func(ctx context.Context) error) Check {
+	return checkFunc{
+		name: name,
+		f:    f}
+}
+
+type checkFunc struct {
+	name string
+	f    func(context.Context) error
+}
+
+func (c checkFunc) Name() string                    { return c.name }
+func (c checkFunc) Check(ctx context.Context) error { return c.f(ctx) }
+
+// HTTP returns a Check which sends a GET request to url with client, and fails unless it gets a 2xx response.
+func HTTP(name, url string, client libhttp.Service) Check {
+	return CheckFunc(name, func(ctx context.Context) error {
+		rsp := libhttp.NewRequest(ctx, "GET", url, nil).SendVia(client).Response()
+		if rsp.Response != nil && rsp.Body != nil {
+			defer rsp.Body.Close()
+		}
+		switch {
+		case rsp.Error != nil:
+			return rsp.Error
+		case rsp.StatusCode < 200 || rsp.StatusCode > 299:
+			return fmt.Errorf("got status %d", rsp.StatusCode)
+		}
+		return nil
+	})
+}
+
+// An Option configures how a Check is run.
+type Option func(*registration)
+
+// Timeout sets how long the check may take before it fails. The default is 5 seconds.
+func Timeout(d time.Duration) Option {
+	return func(r *registration) {
+		r.timeout = d
+	}
+}
+
+// CacheFor caches the check's result for d, so that it is run at most once in that time however often the registry
+// is asked for a report. By default, results aren't cached (but concurrent requests for a report share one run of
+// each check).
+func CacheFor(d time.Duration) Option {
+	return func(r *registration) {
+		r.cacheFor = d
+	}
+}
+
+// Optional makes the check's failure not affect the report's status, so that a service can report a degraded
+// dependency without being taken out of rotation.
+func Optional() Option {
+	return func(r *registration) {
+		r.optional = true
+	}
+}
+
+// A Result is the outcome of a check.
+type Result struct {
+	Name      string        `json:"name"`
+	Status    string        `json:"status"`
+	Optional  bool          `json:"optional,omitempty"`
+	Latency   time.Duration `json:"-"`
+	LatencyMs float64       `json:"latency_ms"`
+	Error     string        `json:"error,omitempty"`
+	CheckedAt time.Time     `json:"checked_at"`
+	Cached    bool          `json:"cached,omitempty"`
+}
+
+// A Report is the outcome of all of a registry's checks. Its status is StatusFail if any check which isn't optional
+// failed.
+type Report struct {
+	Status string   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+type registration struct {
+	check    Check
+	timeout  time.Duration
+	cacheFor time.Duration
+	optional bool
+
+	m    sync.Mutex // held while the check runs
+	last *Result
+}
+
+// A Registry holds the checks a service depends on. It is safe for concurrent use.
+type Registry struct {
+	m      sync.RWMutex
+	checks []*registration
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds a check to the registry. Registering a check with the same name as another replaces it.
+func (r *Registry) Register(c Check, opts ...Option) {
+	reg := &registration{
+		check:   c,
+		timeout: 5 * time.Second}
+	for _, opt := range opts {
+		opt(reg)
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	for i, existing := range r.checks {
+		if existing.check.Name() == c.Name() {
+			r.checks[i] = reg
+			return
+		}
+	}
+	r.checks = append(r.checks, reg)
+}
+
+// Run runs the checks concurrently (or uses their cached results), and returns a report of them in order of name.
+func (r *Registry) Run(ctx context.Context) Report {
+	r.m.RLock()
+	checks := append([]*registration(nil), r.checks...)
+	r.m.RUnlock()
+
+	report := Report{
+		Status: StatusOK,
+		Checks: make([]Result, len(checks))}
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c *registration) {
+			defer wg.Done()
+			report.Checks[i] = c.run(ctx)
+		}(i, c)
+	}
+	wg.Wait()
+	sort.Slice(report.Checks, func(i, j int) bool { return report.Checks[i].Name < report.Checks[j].Name })
+	for _, res := range report.Checks {
+		if res.Status != StatusOK && !res.Optional {
+			report.Status = StatusFail
+		}
+	}
+	return report
+}
+
+// run returns the check's cached result, if it is still fresh, or runs it.
+func (c *registration) run(parent context.Context) Result {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.last != nil && time.Since(c.last.CheckedAt) < c.cacheFor {
+		res := *c.last
+		res.Cached = true
+		return res
+	}
+
+	ctx, cancel := context.WithTimeout(parent, c.timeout)
+	defer cancel()
+	start := time.Now()
+	errc := make(chan error, 1)
+	go func() {
+		defer func() {
+			if v := recover(); v != nil {
+				errc <- fmt.Errorf("check panicked: %v", v)
+			}
+		}()
+		errc <- c.check.Check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		// Checks which don't heed their context are abandoned
+		err = terrors.Timeout("", fmt.Sprintf("Check timed out after %v", c.timeout), nil)
+		if ctx.Err() != context.DeadlineExceeded {
+			err = terrors.Wrap(ctx.Err(), nil)
+		}
+	}
+
+	res := Result{
+		Name:      c.check.Name(),
+		Status:    StatusOK,
+		Optional:  c.optional,
+		Latency:   time.Since(start),
+		CheckedAt: start}
+	res.LatencyMs = float64(res.Latency) / float64(time.Millisecond)
+	if err != nil {
+		res.Status = StatusFail
+		res.Error = err.Error()
+	}
+	if parent.Err() == nil {
+		c.last = &res // results of checks which were cancelled by the caller say nothing about the dependency
+	}
+	return res
+}
+
+// Service returns a Service which responds with a JSON report of the checks (for a /readyz endpoint, say), with a 200
+// status if the report's status is StatusOK, and a 503 status otherwise.
+func (r *Registry) Service() libhttp.Service {
+	return func(req libhttp.Request) libhttp.Response {
+		report := r.Run(req)
+		rsp := req.Response(report)
+		if report.Status != StatusOK {
+			rsp.StatusCode = http.StatusServiceUnavailable
+		}
+		rsp.Header.Set("Cache-Control", "no-store")
+		return rsp
+	}
+}
@@ -0,0 +1,105 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/4thel00z/libhttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	var dbCalls int32
+	r := NewRegistry()
+	r.Register(CheckFunc("db", func(ctx context.Context) error {
+		atomic.AddInt32(&dbCalls, 1)
+		return nil
+	}), CacheFor(time.Minute))
+	r.Register(CheckFunc("queue", func(ctx context.Context) error {
+		return errors.New("queue is down")
+	}), Optional())
+	r.Register(CheckFunc("stuck", func(ctx context.Context) error {
+		select {} // ignores its context
+	}), Timeout(20*time.Millisecond))
+
+	report := r.Run(ctx)
+	assert.Equal(t, StatusFail, report.Status)
+	require.Len(t, report.Checks, 3)
+	db, queue, stuck := report.Checks[0], report.Checks[1], report.Checks[2]
+	assert.Equal(t, "db", db.Name)
+	assert.Equal(t, StatusOK, db.Status)
+	assert.False(t, db.Cached)
+	assert.Equal(t, StatusFail, queue.Status)
+	assert.Equal(t, "queue is down", queue.Error)
+	assert.True(t, queue.Optional)
+	assert.Equal(t, StatusFail, stuck.Status)
+	assert.Contains(t, stuck.Error, "timed out")
+	assert.True(t, stuck.Latency >= 20*time.Millisecond)
+
+	// Replacing the stuck check leaves only the optional failure, which doesn't fail the report
+	r.Register(CheckFunc("stuck", func(ctx context.Context) error { return nil }))
+	report = r.Run(ctx)
+	assert.Equal(t, StatusOK, report.Status)
+	assert.True(t, report.Checks[0].Cached)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&dbCalls))
+}
+
+func TestRegistryCancelledResultsArentCached(t *testing.T) {
+	t.Parallel()
+	var calls int32
+	r := NewRegistry()
+	r.Register(CheckFunc("slow", func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		<-ctx.Done()
+		return ctx.Err()
+	}), CacheFor(time.Minute), Timeout(time.Minute))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, StatusFail, r.Run(ctx).Status)
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.False(t, r.Run(ctx).Checks[0].Cached)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestService(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	upstream, err := libhttp.Listen(func(req libhttp.Request) libhttp.Response {
+		rsp := req.Response(nil)
+		if req.URL.Path == "/down" {
+			rsp.StatusCode = http.StatusInternalServerError
+		}
+		return rsp
+	}, "localhost:0")
+	require.NoError(t, err)
+	defer upstream.Stop(ctx)
+	base := "http://" + upstream.Listener().Addr().String()
+
+	r := NewRegistry()
+	r.Register(HTTP("up", base+"/up", libhttp.Client))
+	svc := r.Service()
+	rsp := svc(libhttp.NewRequest(ctx, "GET", "/readyz", nil))
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Equal(t, "no-store", rsp.Header.Get("Cache-Control"))
+	var report Report
+	require.NoError(t, rsp.Decode(&report))
+	assert.Equal(t, StatusOK, report.Status)
+	require.Len(t, report.Checks, 1)
+	assert.Equal(t, "up", report.Checks[0].Name)
+
+	r.Register(HTTP("down", base+"/down", libhttp.Client))
+	rsp = svc(libhttp.NewRequest(ctx, "GET", "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rsp.StatusCode)
+	require.NoError(t, rsp.Decode(&report))
+	assert.Equal(t, StatusFail, report.Status)
+	assert.Equal(t, "got status 500", report.Checks[0].Error)
+}
@@ -0,0 +1,222 @@
+package libhttp
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/monzo/terrors"
+)
+
+const (
+	hedgeWindow     = 1000 // latencies remembered to compute percentiles
+	hedgeMinSamples = 20   // latencies needed before the percentile is used instead of the fixed delay
+	hedgeMaxBody    = 1 << 20
+)
+
+// A HedgeOption configures a HedgeFilter.
+type HedgeOption func(*hedgeOptions)
+
+type hedgeOptions struct {
+	delay       time.Duration
+	percentile  float64
+	maxAttempts int
+	methods     map[string]bool
+}
+
+// HedgeDelay sets how long to wait for a response before sending another attempt. If a percentile is also set, this
+// is used until enough latencies have been seen to compute it. The default is 100ms.
+func HedgeDelay(d time.Duration) HedgeOption {
+	return func(o *hedgeOptions) {
+		o.delay = d
+	}
+}
+
+// HedgePercentile makes the delay before another attempt the passed percentile (between 0 and 1, eg. 0.95) of the
+// latencies of recent responses, so that only the slowest requests are hedged.
+func HedgePercentile(p float64) HedgeOption {
+	return func(o *hedgeOptions) {
+		o.percentile = p
+	}
+}
+
+// HedgeMaxAttempts sets the maximum number of attempts sent for a request, including the first. The default is 2.
+func HedgeMaxAttempts(n int) HedgeOption {
+	return func(o *hedgeOptions) {
+		o.maxAttempts = n
+	}
+}
+
+// HedgeMethods allows requests with the passed methods to be hedged, in addition to the idempotent methods. As with
+// RetryFilter, requests with an Idempotency-Key header may also be hedged.
+func HedgeMethods(methods ...string) HedgeOption {
+	return func(o *hedgeOptions) {
+		for _, m := range methods {
+			o.methods[m] = true
+		}
+	}
+}
+
+// HedgeFilter returns a client Filter which sends a request again if no response has arrived after a delay, and
+// returns whichever response arrives first; the other attempts are cancelled. This cuts tail latency, at the cost of
+// a little extra load:
+//
+//  client := libhttp.NewClient(libhttp.WithClientFilters(libhttp.HedgeFilter(libhttp.HedgePercentile(0.95))))
+//
+// Attempts are sent through the rest of the filter chain, so if it balances requests between replicas each attempt
+// may go to a different one. Attempts which fail with a transient error (see RetryIf for which errors are transient)
+// don't win while others are outstanding, and another attempt is sent straight away if one is allowed.
+//
+// Only requests which are safe to repeat are hedged, and only those with bodies of up to 1MiB, which are held in
+// memory so they can be sent with each attempt.
+func HedgeFilter(opts ...HedgeOption) Filter {
+	o := hedgeOptions{
+		delay:       100 * time.Millisecond,
+		maxAttempts: 2,
+		methods:     idempotentMethods()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	var latencies *latencyWindow
+	if o.percentile > 0 {
+		latencies = &latencyWindow{}
+	}
+
+	return func(req Request, svc Service) Response {
+		if o.maxAttempts <= 1 || (!o.methods[req.Method] && req.Header.Get("Idempotency-Key") == "") {
+			return svc(req)
+		}
+		body, ok, err := replayableBody(&req, hedgeMaxBody)
+		switch {
+		case err != nil:
+			return Response{
+				Request: &req,
+				Error:   terrors.Wrap(err, nil)}
+		case !ok:
+			return svc(req)
+		}
+		parent := req.unwrappedContext()
+		if parent == nil {
+			parent = context.Background()
+		}
+		delay := o.delay
+		if latencies != nil {
+			if d, ok := latencies.percentile(o.percentile); ok {
+				delay = d
+			}
+		}
+
+		type attempt struct {
+			ctx    context.Context
+			cancel context.CancelFunc
+		}
+		type result struct {
+			i       int
+			rsp     Response
+			latency time.Duration
+		}
+		var attempts []attempt
+		results := make(chan result, o.maxAttempts)
+		send := func() {
+			ctx, cancel := context.WithCancel(parent)
+			i := len(attempts)
+			attempts = append(attempts, attempt{ctx, cancel})
+			a := withReplayedBody(req, body)
+			a.Context = ctx
+			go func() {
+				start := time.Now()
+				rsp := svc(a)
+				results <- result{i, rsp, time.Since(start)}
+			}()
+		}
+
+		send()
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		pending := 1
+		for {
+			select {
+			case <-timer.C:
+				if len(attempts) < o.maxAttempts {
+					send()
+					pending++
+					timer.Reset(delay)
+				}
+			case r := <-results:
+				pending--
+				if retryableResponse(r.rsp) && (pending > 0 || len(attempts) < o.maxAttempts) {
+					// Let another attempt win
+					discardResponse(r.rsp)
+					attempts[r.i].cancel()
+					if len(attempts) < o.maxAttempts {
+						send()
+						pending++
+					}
+					continue
+				}
+				// This attempt wins: cancel the others, and clean up after them
+				for i, a := range attempts {
+					if i != r.i {
+						a.cancel()
+					}
+				}
+				go func(n int) {
+					for ; n > 0; n-- {
+						discardResponse((<-results).rsp)
+					}
+				}(pending)
+				if latencies != nil && !retryableResponse(r.rsp) {
+					latencies.record(r.latency)
+				}
+				r.rsp.Request = &req
+				rsp, _ := cancelOnClose(r.rsp, attempts[r.i].cancel)
+				return rsp
+			}
+		}
+	}
+}
+
+// latencyWindow holds the most recent response latencies, to compute percentiles of them. It is safe for concurrent
+// use.
+type latencyWindow struct {
+	m       sync.Mutex
+	samples []time.Duration
+	next    int // index of the oldest sample, once the window is full
+	sorted  []time.Duration
+	stale   int // samples recorded since sorted was computed
+}
+
+func (w *latencyWindow) record(d time.Duration) {
+	w.m.Lock()
+	defer w.m.Unlock()
+	if len(w.samples) < hedgeWindow {
+		w.samples = append(w.samples, d)
+	} else {
+		w.samples[w.next] = d
+		w.next = (w.next + 1) % hedgeWindow
+	}
+	w.stale++
+}
+
+// percentile returns the pth percentile of the recorded latencies, or false if too few have been recorded. To avoid
+// sorting for every request, the samples are only re-sorted once several more have been recorded.
+func (w *latencyWindow) percentile(p float64) (time.Duration, bool) {
+	w.m.Lock()
+	defer w.m.Unlock()
+	if len(w.samples) < hedgeMinSamples {
+		return 0, false
+	}
+	if w.sorted == nil || w.stale >= hedgeMinSamples {
+		w.sorted = append(w.sorted[:0], w.samples...)
+		sort.Slice(w.sorted, func(i, j int) bool {
+			return w.sorted[i] < w.sorted[j]
+		})
+		w.stale = 0
+	}
+	i := int(p * float64(len(w.sorted)))
+	if i >= len(w.sorted) {
+		i = len(w.sorted) - 1
+	}
+	return w.sorted[i], true
+}
@@ -0,0 +1,137 @@
+package libhttp
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHedgeFilter(t *testing.T) {
+	t.Parallel()
+	// replicas responds slowly to the first attempt, and quickly to the rest; attempts record whether they were
+	// cancelled
+	var (
+		n         int32
+		cancelled = make(chan int32, 10)
+	)
+	replicas := func(first func(Request) Response) Service {
+		return func(req Request) Response {
+			i := atomic.AddInt32(&n, 1)
+			b, err := req.BodyBytes(true)
+			require.NoError(t, err)
+			if i == 1 {
+				if first != nil {
+					return first(req)
+				}
+				select {
+				case <-req.Done():
+					cancelled <- i
+				case <-time.After(5 * time.Second):
+				}
+			}
+			rsp := req.Response(nil)
+			rsp.Header.Set("Attempt", string('0'+rune(i)))
+			rsp.Write(b)
+			return rsp
+		}
+	}
+
+	svc := replicas(nil).Filter(HedgeFilter(HedgeDelay(10 * time.Millisecond)))
+	start := time.Now()
+	rsp := svc(NewRequest(context.Background(), "PUT", "/", "body"))
+	require.NoError(t, rsp.Error)
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, "2", rsp.Header.Get("Attempt"))
+	b, err := rsp.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, "\"body\"\n", string(b))
+	select {
+	case i := <-cancelled:
+		assert.EqualValues(t, 1, i)
+	case <-time.After(time.Second):
+		assert.Fail(t, "the slow attempt wasn't cancelled")
+	}
+
+	// Requests which aren't idempotent aren't hedged
+	atomic.StoreInt32(&n, 0)
+	svc = replicas(func(req Request) Response {
+		time.Sleep(50 * time.Millisecond)
+		return req.Response(nil)
+	}).Filter(HedgeFilter(HedgeDelay(time.Millisecond)))
+	svc(NewRequest(context.Background(), "POST", "/", nil))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&n))
+
+	// An attempt which fails with a transient error doesn't win, and another is sent straight away
+	atomic.StoreInt32(&n, 0)
+	svc = replicas(func(req Request) Response {
+		rsp := req.Response(nil)
+		rsp.StatusCode = http.StatusServiceUnavailable
+		return rsp
+	}).Filter(HedgeFilter(HedgeDelay(time.Minute)))
+	start = time.Now()
+	rsp = svc(NewRequest(context.Background(), "GET", "/", nil))
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Equal(t, "2", rsp.Header.Get("Attempt"))
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestHedgeFilterPercentile(t *testing.T) {
+	t.Parallel()
+	var (
+		m     sync.Mutex
+		sends int
+	)
+	svc := Service(func(req Request) Response {
+		m.Lock()
+		sends++
+		m.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		return req.Response(nil)
+	}).Filter(HedgeFilter(HedgeDelay(time.Millisecond), HedgePercentile(0.99)))
+
+	// Until enough latencies have been seen, the fixed delay is used, so requests are hedged
+	for i := 0; i < hedgeMinSamples; i++ {
+		svc(NewRequest(context.Background(), "GET", "/", nil))
+	}
+	m.Lock()
+	assert.True(t, sends > hedgeMinSamples, "expected hedging: %d sends", sends)
+	sends = 0
+	m.Unlock()
+
+	// Once they have, requests are only hedged if they're slower than the percentile
+	for i := 0; i < 10; i++ {
+		svc(NewRequest(context.Background(), "GET", "/", nil))
+	}
+	time.Sleep(20 * time.Millisecond) // for any hedged attempts to finish
+	m.Lock()
+	assert.True(t, sends < 15, "expected little hedging: %d sends", sends)
+	m.Unlock()
+}
+
+func TestLatencyWindow(t *testing.T) {
+	t.Parallel()
+	w := &latencyWindow{}
+	_, ok := w.percentile(0.5)
+	assert.False(t, ok)
+	for i := 1; i <= 100; i++ {
+		w.record(time.Duration(i) * time.Millisecond)
+	}
+	d, ok := w.percentile(0.5)
+	assert.True(t, ok)
+	assert.Equal(t, 51*time.Millisecond, d)
+	d, _ = w.percentile(1)
+	assert.Equal(t, 100*time.Millisecond, d)
+
+	// Old samples are forgotten
+	for i := 0; i < hedgeWindow; i++ {
+		w.record(time.Second)
+	}
+	d, _ = w.percentile(0)
+	assert.Equal(t, time.Second, d)
+}
@@ -0,0 +1,79 @@
+package libhttp
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// requestHijacker wraps the http.Hijacker of a request being served by HttpHandler, recording whether the connection
+// has been hijacked so that HttpHandler doesn't then try to write a response.
+type requestHijacker struct {
+	http.Hijacker
+	hijacked int32 // atomic
+}
+
+func (h *requestHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	c, rw, err := h.Hijacker.Hijack()
+	if err == nil {
+		atomic.StoreInt32(&h.hijacked, 1)
+	}
+	return c, rw, err
+}
+
+func (h *requestHijacker) isHijacked() bool {
+	return h != nil && atomic.LoadInt32(&h.hijacked) == 1
+}
+
+// Hijack takes over the request's underlying connection, so that protocols other than HTTP (like raw TCP tunnels, or
+// custom upgrades) can be implemented by a Service. Once it is hijacked, the caller is responsible for the connection,
+// including closing it, and whatever Response the Service returns is ignored:
+//
+//  conn, brw, err := req.Hijack()
+//  if err != nil {
+//      return libhttp.Response{Error: err}
+//  }
+//  go tunnel(conn, brw)
+//  return libhttp.Response{}
+//
+// The returned bufio.ReadWriter may hold data the client has already sent. Connections are only hijackable when the
+// request is served over HTTP/1; otherwise http.ErrNotSupported is returned.
+//
+// Servers keep track of the connections hijacked from them: Stop waits for them to be closed, and forcibly closes any
+// which remain open when its context expires.
+func (r Request) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if r.hijacker == nil {
+		return nil, nil, http.ErrNotSupported
+	}
+	c, rw, err := r.hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	if r.server != nil {
+		c = r.server.trackHijacked(c)
+	}
+	return c, rw, nil
+}
+
+// trackHijacked records a connection hijacked from the server, until it is closed.
+func (s *Server) trackHijacked(c net.Conn) net.Conn {
+	conn := &hijackedConn{
+		Conn:   c,
+		closed: make(chan struct{}),
+		onClose: func(c *hijackedConn) {
+			s.hijackedConns.Remove(c)
+		}}
+	s.hijackedConns.Add(conn)
+	return conn
+}
+
+// HijackedConns returns the number of connections hijacked from the server (with Request.Hijack) which are still open.
+func (s *Server) HijackedConns() int {
+	return s.hijackedConns.Cardinality()
+}
+
+func (s *Server) drainHijacked(ctx context.Context) {
+	drainHijackedConns(ctx, s.hijackedConns)
+}
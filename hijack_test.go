@@ -0,0 +1,72 @@
+package libhttp
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHijack(t *testing.T) {
+	t.Parallel()
+
+	hijacked := make(chan net.Conn, 1)
+	svc := Service(func(req Request) Response {
+		conn, brw, err := req.Hijack()
+		if err != nil {
+			return Response{Error: err}
+		}
+		fmt.Fprint(brw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+		brw.Flush()
+		go func() {
+			line, _ := brw.ReadString('\n')
+			fmt.Fprint(conn, "echo: "+line)
+			hijacked <- conn
+		}()
+		return Response{}
+	}).Filter(ErrorFilter)
+	s, err := Listen(svc, "localhost:0")
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp", s.Listener().Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	r := bufio.NewReader(conn)
+	rsp, err := http.ReadResponse(r, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusSwitchingProtocols, rsp.StatusCode)
+	fmt.Fprint(conn, "hello\n")
+	line, err := r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo: hello\n", line)
+
+	<-hijacked
+	assert.Equal(t, 1, s.HijackedConns())
+	assert.EqualValues(t, 0, s.ActiveRequests())
+
+	// Stopping the server closes hijacked connections once its context expires
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	s.Stop(ctx)
+	assert.Equal(t, 0, s.HijackedConns())
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = r.ReadByte()
+	assert.Error(t, err)
+	assert.False(t, isTimeout(err), "connection should be closed, not idle")
+
+	// Requests which aren't served by HttpHandler can't be hijacked
+	_, _, err = NewRequest(context.Background(), "GET", "/", nil).Hijack()
+	assert.Equal(t, http.ErrNotSupported, err)
+}
+
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
@@ -0,0 +1,25 @@
+package libhttp
+
+import (
+	"net/http"
+)
+
+// EarlyHints sends a 103 (Early Hints) informational response with the passed Link header values, before the final
+// response. This lets browsers start preloading resources the page will need while the response is being prepared:
+//
+//  req.EarlyHints("</style.css>; rel=preload; as=style", "</app.js>; rel=preload; as=script")
+//  data := loadSlowly()
+//  return req.Render("page.html", data)
+//
+// EarlyHints may be called more than once, but only from the Service before it returns its Response. Hints are
+// advisory, so they are silently dropped when they can't be sent: to HTTP/1.0 clients, or for requests which aren't
+// being served by HttpHandler. The links are not sent with the final response unless it sets them itself.
+func (r Request) EarlyHints(links ...string) {
+	if r.rw == nil || len(links) == 0 || !r.ProtoAtLeast(1, 1) {
+		return
+	}
+	h := r.rw.Header()
+	h["Link"] = links
+	r.rw.WriteHeader(http.StatusEarlyHints)
+	h.Del("Link")
+}
@@ -0,0 +1,46 @@
+package libhttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEarlyHints(t *testing.T) {
+	t.Parallel()
+
+	svc := Service(func(req Request) Response {
+		req.EarlyHints("</style.css>; rel=preload; as=style", "</app.js>; rel=preload; as=script")
+		return req.Response("done")
+	})
+	s, err := Listen(svc, "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+
+	var (
+		codes []int
+		hints []string
+	)
+	ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			codes = append(codes, code)
+			hints = append(hints, header["Link"]...)
+			return nil
+		}})
+	req := NewRequest(ctx, "GET", "http://"+s.Listener().Addr().String(), nil)
+	rsp := req.SendVia(HttpService(&http.Transport{})).Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Equal(t, []int{http.StatusEarlyHints}, codes)
+	assert.Equal(t, []string{"</style.css>; rel=preload; as=style", "</app.js>; rel=preload; as=script"}, hints)
+	assert.Empty(t, rsp.Header.Values("Link"))
+
+	// Outside of HttpHandler, hints are dropped
+	rsp = svc(NewRequest(context.Background(), "GET", "/", nil))
+	assert.NoError(t, rsp.Error)
+}
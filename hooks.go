@@ -0,0 +1,81 @@
+package libhttp
+
+import (
+	"context"
+	"time"
+)
+
+// Hooks receive events in the lifecycle of the requests a Server handles or a client sends, so that tooling (APM
+// agents, say) can instrument them without writing filters. Embed NopHooks to implement only some of the events.
+// Hooks are called synchronously, so they should be quick, and they mustn't consume or close response bodies.
+type Hooks interface {
+	// OnRequestStart is called when a server starts handling a request. It returns the request which is handled, so
+	// it may add values to its context (with SetValue, say); most hooks return it unchanged.
+	OnRequestStart(req Request) Request
+	// OnResponse is called when the server's service has returned its response to a request (before any streamed
+	// body is written), with how long that took. req is as returned by OnRequestStart.
+	OnResponse(req Request, rsp Response, elapsed time.Duration)
+	// OnPanic is called with the recovered value and stack trace of any panic in the server (see Server.OnPanic).
+	OnPanic(ctx context.Context, recovered interface{}, stack []byte)
+	// OnClientCall is called when a client's request has been sent and its response's headers have arrived (or it
+	// has failed), with how long that took. If the client retries requests, it is called for each attempt.
+	OnClientCall(req Request, rsp Response, elapsed time.Duration)
+}
+
+// NopHooks implements Hooks, ignoring every event. It is intended to be embedded in types which only need some.
+type NopHooks struct{}
+
+// OnRequestStart implements Hooks.
+func (NopHooks) OnRequestStart(req Request) Request { return req }
+
+// OnResponse implements Hooks.
+func (NopHooks) OnResponse(Request, Response, time.Duration) {}
+
+// OnPanic implements Hooks.
+func (NopHooks) OnPanic(context.Context, interface{}, []byte) {}
+
+// OnClientCall implements Hooks.
+func (NopHooks) OnClientCall(Request, Response, time.Duration) {}
+
+// WithServerHooks registers hooks which receive the server's request, response and panic events, in order.
+func WithServerHooks(hooks ...Hooks) ServerOption {
+	return func(o *serverOptions) {
+		o.hooks = append(o.hooks, hooks...)
+	}
+}
+
+// WithClientHooks registers hooks which receive the client's OnClientCall events, in order.
+func WithClientHooks(hooks ...Hooks) ClientOption {
+	return func(o *clientOptions) {
+		o.hooks = append(o.hooks, hooks...)
+	}
+}
+
+// serverHooksFilter reports requests and responses to the hooks.
+func serverHooksFilter(hooks []Hooks) Filter {
+	return func(req Request, svc Service) Response {
+		for _, h := range hooks {
+			req = h.OnRequestStart(req)
+		}
+		start := time.Now()
+		rsp := svc(req)
+		elapsed := time.Since(start)
+		for _, h := range hooks {
+			h.OnResponse(req, rsp, elapsed)
+		}
+		return rsp
+	}
+}
+
+// clientHooksFilter reports the client's calls to the hooks.
+func clientHooksFilter(hooks []Hooks) Filter {
+	return func(req Request, svc Service) Response {
+		start := time.Now()
+		rsp := svc(req)
+		elapsed := time.Since(start)
+		for _, h := range hooks {
+			h.OnClientCall(req, rsp, elapsed)
+		}
+		return rsp
+	}
+}
@@ -0,0 +1,95 @@
+package libhttp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var hookContextKey = NewContextKey("hook", "")
+
+type recordingHooks struct {
+	NopHooks
+	m      sync.Mutex
+	events []string
+}
+
+func (h *recordingHooks) record(format string, args ...interface{}) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	h.events = append(h.events, fmt.Sprintf(format, args...))
+}
+
+func (h *recordingHooks) recorded() []string {
+	h.m.Lock()
+	defer h.m.Unlock()
+	return append([]string(nil), h.events...)
+}
+
+func (h *recordingHooks) OnRequestStart(req Request) Request {
+	h.record("start %s", req.URL.Path)
+	return SetValue(req, hookContextKey, "span-1")
+}
+
+func (h *recordingHooks) OnResponse(req Request, rsp Response, elapsed time.Duration) {
+	v, _ := Value(req, hookContextKey)
+	h.record("response %s %d %s", req.URL.Path, rsp.StatusCode, v)
+}
+
+func (h *recordingHooks) OnPanic(ctx context.Context, recovered interface{}, stack []byte) {
+	h.record("panic %v", recovered)
+}
+
+func (h *recordingHooks) OnClientCall(req Request, rsp Response, elapsed time.Duration) {
+	status := 0
+	if rsp.Response != nil {
+		status = rsp.StatusCode
+	}
+	h.record("call %s %d", req.URL.Path, status)
+}
+
+func TestHooks(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	hooks := &recordingHooks{}
+	s, err := Listen(Service(func(req Request) Response {
+		if req.URL.Path == "/panic" {
+			panic("boom")
+		}
+		v, _ := Value(req, hookContextKey)
+		return req.Response(v)
+	}), "localhost:0", WithServerHooks(hooks))
+	require.NoError(t, err)
+	defer s.Stop(ctx)
+	base := "http://" + s.Listener().Addr().String()
+	client := NewClient(WithClientHooks(hooks))
+
+	var v string
+	rsp := NewRequest(ctx, "GET", base+"/ok", nil).SendVia(client).Response()
+	require.NoError(t, rsp.Decode(&v))
+	assert.Equal(t, "span-1", v, "OnRequestStart can add to the request's context")
+	rsp = NewRequest(ctx, "GET", base+"/panic", nil).SendVia(client).Response()
+	assert.Equal(t, http.StatusInternalServerError, rsp.StatusCode)
+	require.NoError(t, s.WaitIdle(ctx))
+
+	assert.Equal(t, []string{
+		"start /ok", "response /ok 200 span-1", "call /ok 200",
+		"start /panic", "panic boom", "response /panic 500 span-1", "call /panic 500",
+	}, hooks.recorded())
+}
+
+func TestNopHooks(t *testing.T) {
+	t.Parallel()
+	var h Hooks = NopHooks{}
+	req := NewRequest(context.Background(), "GET", "/", nil)
+	assert.Equal(t, req, h.OnRequestStart(req))
+	h.OnResponse(req, Response{}, 0)
+	h.OnPanic(req, nil, nil)
+	h.OnClientCall(req, Response{}, 0)
+}
@@ -86,16 +86,29 @@ func HttpHandler(svc Service) http.Handler {
 			defer httpReq.Body.Close()
 		}
 
+		raw := &rawWriter{
+			ResponseWriter: rw}
 		req := Request{
 			Context: httpReq.Context(),
-			Request: *httpReq}
+			Request: *httpReq,
+			rw:      raw}
+		var hijacker *requestHijacker
 		if h, ok := rw.(http.Hijacker); ok {
-			req.hijacker = h
+			hijacker = &requestHijacker{
+				Hijacker: h}
+			req.hijacker = hijacker
 		}
 		rsp := svc(req)
 
 		// If the connection was hijacked, we should not attempt to write anything out
-		if rsp.hijacked {
+		if rsp.hijacked || hijacker.isHijacked() {
+			return
+		}
+		// Nor if the Service has already written the response itself
+		if raw.isWritten() {
+			if rsp.Response != nil && rsp.Body != nil {
+				rsp.Body.Close()
+			}
 			return
 		}
 
@@ -103,21 +116,53 @@ func HttpHandler(svc Service) http.Handler {
 		for k, v := range rsp.Header {
 			rwHeader[k] = v
 		}
+		if len(rsp.Trailer) > 0 {
+			// Trailers can only be sent with chunked encoding
+			rwHeader.Del("Content-Length")
+		}
 		rw.WriteHeader(rsp.StatusCode)
 		if rsp.Body != nil {
 			defer rsp.Body.Close()
 			buf := *httpChunkBufPool.Get().(*[]byte)
 			defer httpChunkBufPool.Put(&buf)
+			body := &bodyReader{r: rsp.Body}
 			if isStreamingRsp(rsp) {
 				// Streaming responses use copyChunked(), which takes care of flushing transparently
-				if _, err := copyChunked(rw, rsp.Body, buf); err != nil {
+				if _, err := copyChunked(rw, body, buf); err != nil {
 					slog.Log(slog.Eventf(copyErrSeverity(err), req, "Couldn't send streaming response body: %v", err))
 				}
 			} else {
-				if _, err := io.CopyBuffer(rw, rsp.Body, buf); err != nil {
+				if _, err := io.CopyBuffer(rw, body, buf); err != nil {
 					slog.Log(slog.Eventf(copyErrSeverity(err), req, "Couldn't send response body: %v", err))
 				}
 			}
+			// If the body itself failed, the response is incomplete: abort the connection so the client doesn't mistake
+			// it for a complete one
+			if body.err != nil {
+				panic(http.ErrAbortHandler)
+			}
+			// Buffered bodies have been written in full, and nothing else can see the Response now, so their buffers
+			// can be reused
+			if b, ok := rsp.Body.(*bufCloser); ok {
+				releaseBufCloser(b)
+			}
+		}
+		for k, v := range rsp.Trailer {
+			rwHeader[http.TrailerPrefix+k] = v
 		}
 	})
 }
+
+// bodyReader records any error (other than io.EOF) from reading a response body.
+type bodyReader struct {
+	r   io.Reader
+	err error
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,230 @@
+package libhttp
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	altSvcDefaultMaxAge = 24 * time.Hour  // the Alt-Svc default for ma
+	altSvcBrokenFor     = 5 * time.Minute // how long HTTP/3 isn't used for an origin after it fails
+)
+
+// WithHTTP3 makes the client use HTTP/3 for origins which advertise it. libhttp doesn't include a QUIC
+// implementation, so the HTTP/3 RoundTripper is passed in; for example, with quic-go:
+//
+//  client := libhttp.NewClient(libhttp.WithHTTP3(&http3.RoundTripper{}))
+//
+// Requests are first sent with the client's RoundTripper (over HTTP/2 or HTTP/1.1). When an https origin advertises
+// HTTP/3 support in an Alt-Svc response header (h3 on the same host), later requests to it are sent with the HTTP/3
+// RoundTripper until the advertisement expires or is cleared. If an HTTP/3 request fails without a response, HTTP/3
+// isn't used for the origin for a few minutes, and the request is sent again with the client's RoundTripper if its
+// body can be replayed (as bodies set with Request.Encode can).
+func WithHTTP3(h3 http.RoundTripper) ClientOption {
+	return func(o *clientOptions) {
+		o.h3 = h3
+	}
+}
+
+// altSvc is a cached HTTP/3 alternative for an origin.
+type altSvc struct {
+	port    string
+	expires time.Time
+}
+
+// h3Transport sends requests with h3 to the origins which have advertised HTTP/3, and with next otherwise.
+type h3Transport struct {
+	h3   http.RoundTripper
+	next http.RoundTripper
+
+	m      sync.Mutex
+	alts   map[string]altSvc    // by origin host:port
+	broken map[string]time.Time // origins for which HTTP/3 failed, and until when not to use it
+}
+
+func newH3Transport(h3, next http.RoundTripper) *h3Transport {
+	return &h3Transport{
+		h3:     h3,
+		next:   next,
+		alts:   map[string]altSvc{},
+		broken: map[string]time.Time{}}
+}
+
+func (t *h3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	origin := requestOrigin(req)
+	if alt, ok := t.alternative(origin); ok {
+		replay := bodyReplayer(req)
+		r := req.Clone(req.Context())
+		r.URL.Host = net.JoinHostPort(req.URL.Hostname(), alt.port)
+		if r.Host == "" {
+			r.Host = req.URL.Host
+		}
+		if replay != nil {
+			r.Body = replay()
+		}
+		rsp, err := t.h3.RoundTrip(r)
+		if err == nil {
+			rsp.Request = req
+			t.update(origin, rsp.Header)
+			return rsp, nil
+		}
+		if req.Context().Err() != nil {
+			return nil, err
+		}
+		t.markBroken(origin)
+		if replay == nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		req.Body = replay()
+	}
+
+	rsp, err := t.next.RoundTrip(req)
+	if err == nil && origin != "" {
+		t.update(origin, rsp.Header)
+	}
+	return rsp, err
+}
+
+// CloseIdleConnections closes the idle connections of both transports.
+func (t *h3Transport) CloseIdleConnections() {
+	for _, rt := range []http.RoundTripper{t.h3, t.next} {
+		if c, ok := rt.(interface{ CloseIdleConnections() }); ok {
+			c.CloseIdleConnections()
+		}
+	}
+}
+
+func (t *h3Transport) alternative(origin string) (altSvc, bool) {
+	if origin == "" {
+		return altSvc{}, false
+	}
+	now := time.Now()
+	t.m.Lock()
+	defer t.m.Unlock()
+	if until, ok := t.broken[origin]; ok {
+		if now.Before(until) {
+			return altSvc{}, false
+		}
+		delete(t.broken, origin)
+	}
+	alt, ok := t.alts[origin]
+	if ok && now.After(alt.expires) {
+		delete(t.alts, origin)
+		ok = false
+	}
+	return alt, ok
+}
+
+func (t *h3Transport) markBroken(origin string) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.broken[origin] = time.Now().Add(altSvcBrokenFor)
+}
+
+// update caches the HTTP/3 alternative advertised in the Alt-Svc header, if there is one.
+func (t *h3Transport) update(origin string, h http.Header) {
+	values := h.Values("Alt-Svc")
+	if len(values) == 0 {
+		return
+	}
+	alt, clear, ok := parseAltSvc(strings.Join(values, ","))
+	t.m.Lock()
+	defer t.m.Unlock()
+	switch {
+	case clear:
+		delete(t.alts, origin)
+	case ok:
+		t.alts[origin] = alt
+	}
+}
+
+// requestOrigin returns the host:port of an https request, or an empty string for other requests (HTTP/3 always uses
+// TLS).
+func requestOrigin(req *http.Request) string {
+	if req.URL.Scheme != "https" {
+		return ""
+	}
+	port := req.URL.Port()
+	if port == "" {
+		port = "443"
+	}
+	return net.JoinHostPort(strings.ToLower(req.URL.Hostname()), port)
+}
+
+// parseAltSvc returns the first h3 alternative on the same host from an Alt-Svc header value (RFC 7838), like:
+//
+//  h3=":443"; ma=86400, h3-29=":443"
+//
+// clear is returned if the value is "clear", which invalidates all alternatives.
+func parseAltSvc(value string) (alt altSvc, clear, ok bool) {
+	if strings.TrimSpace(value) == "clear" {
+		return altSvc{}, true, false
+	}
+	for _, entry := range strings.Split(value, ",") {
+		params := strings.Split(entry, ";")
+		kv := strings.SplitN(strings.TrimSpace(params[0]), "=", 2)
+		if len(kv) != 2 || kv[0] != "h3" {
+			continue
+		}
+		authority := strings.Trim(kv[1], `"`)
+		if !strings.HasPrefix(authority, ":") { // alternatives on other hosts aren't supported
+			continue
+		}
+		port := authority[1:]
+		if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+			continue
+		}
+		maxAge := altSvcDefaultMaxAge
+		for _, p := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+			if len(kv) == 2 && kv[0] == "ma" {
+				if secs, err := strconv.Atoi(strings.Trim(kv[1], `"`)); err == nil {
+					maxAge = time.Duration(secs) * time.Second
+				}
+			}
+		}
+		return altSvc{
+			port:    port,
+			expires: time.Now().Add(maxAge)}, false, true
+	}
+	return altSvc{}, false, false
+}
+
+// bodyReplayer returns a function which returns a copy of the request's body each time it is called, or nil if the
+// body can only be read once.
+func bodyReplayer(req *http.Request) func() io.ReadCloser {
+	switch body := req.Body.(type) {
+	case nil:
+		return func() io.ReadCloser {
+			return http.NoBody
+		}
+	case *bufCloser:
+		b := body.Bytes()
+		return func() io.ReadCloser {
+			return ioutil.NopCloser(bytes.NewReader(b))
+		}
+	}
+	if req.Body == http.NoBody {
+		return func() io.ReadCloser {
+			return http.NoBody
+		}
+	}
+	if req.GetBody != nil {
+		return func() io.ReadCloser {
+			body, err := req.GetBody()
+			if err != nil {
+				return ioutil.NopCloser(&errReader{err: err})
+			}
+			return body
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package libhttp
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRoundTripper responds to requests with the passed function, recording the URLs it is sent.
+type fakeRoundTripper struct {
+	m    sync.Mutex
+	urls []string
+	f    func(*http.Request) (*http.Response, error)
+}
+
+func (rt *fakeRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.m.Lock()
+	rt.urls = append(rt.urls, req.URL.String())
+	rt.m.Unlock()
+	return rt.f(req)
+}
+
+func (rt *fakeRoundTripper) reset() []string {
+	rt.m.Lock()
+	defer rt.m.Unlock()
+	urls := rt.urls
+	rt.urls = nil
+	return urls
+}
+
+func TestParseAltSvc(t *testing.T) {
+	t.Parallel()
+	alt, clear, ok := parseAltSvc(`h3-29=":443", h3=":8443"; ma=60; persist=1`)
+	assert.True(t, ok)
+	assert.False(t, clear)
+	assert.Equal(t, "8443", alt.port)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), alt.expires, time.Second)
+
+	alt, _, ok = parseAltSvc(`h3=":443"`)
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(24*time.Hour), alt.expires, time.Second)
+
+	_, _, ok = parseAltSvc(`h3="other.example.com:443", h2=":443"`)
+	assert.False(t, ok)
+	_, clear, _ = parseAltSvc("clear")
+	assert.True(t, clear)
+}
+
+func TestWithHTTP3(t *testing.T) {
+	t.Parallel()
+	altSvc := `h3=":8443"; ma=60`
+	var m sync.Mutex
+	h1 := &fakeRoundTripper{
+		f: func(req *http.Request) (*http.Response, error) {
+			m.Lock()
+			defer m.Unlock()
+			rsp := NewResponse(NewRequest(req.Context(), req.Method, req.URL.String(), nil)).Response
+			rsp.Header.Set("Alt-Svc", altSvc)
+			return rsp, nil
+		}}
+	var h3Err error
+	h3 := &fakeRoundTripper{
+		f: func(req *http.Request) (*http.Response, error) {
+			m.Lock()
+			defer m.Unlock()
+			if h3Err != nil {
+				return nil, h3Err
+			}
+			assert.Equal(t, "example.com", req.Host)
+			rsp := NewResponse(NewRequest(req.Context(), req.Method, req.URL.String(), nil)).Response
+			rsp.Header.Set("Alt-Svc", altSvc)
+			return rsp, nil
+		}}
+	client := NewClient(
+		WithRoundTripper(h1),
+		WithHTTP3(h3))
+	send := func(method, url string) Response {
+		return NewRequest(context.Background(), method, url, nil).SendVia(client).Response()
+	}
+
+	// The first request is sent over TCP, and advertises HTTP/3 for later ones
+	require.NoError(t, send("GET", "https://example.com/a").Error)
+	require.NoError(t, send("GET", "https://example.com/b").Error)
+	require.NoError(t, send("GET", "http://example.com/c").Error)
+	assert.Equal(t, []string{"https://example.com/a", "http://example.com/c"}, h1.reset())
+	assert.Equal(t, []string{"https://example.com:8443/b"}, h3.reset())
+
+	// Alt-Svc: clear removes the alternative
+	m.Lock()
+	altSvc = "clear"
+	m.Unlock()
+	require.NoError(t, send("GET", "https://example.com/d").Error)
+	require.NoError(t, send("GET", "https://example.com/e").Error)
+	assert.Equal(t, []string{"https://example.com:8443/d"}, h3.reset())
+	assert.Equal(t, []string{"https://example.com/e"}, h1.reset())
+
+	// When HTTP/3 fails, requests fall back to TCP (if their bodies can be sent again), and HTTP/3 isn't tried again
+	// for a while
+	m.Lock()
+	altSvc = `h3=":8443"`
+	h3Err = errors.New("no UDP for you")
+	m.Unlock()
+	require.NoError(t, send("GET", "https://example.com/f").Error)
+	require.NoError(t, send("GET", "https://example.com/g").Error)
+	require.NoError(t, send("GET", "https://example.com/h").Error)
+	assert.Equal(t, []string{"https://example.com:8443/g"}, h3.reset())
+	assert.Equal(t, []string{"https://example.com/f", "https://example.com/g", "https://example.com/h"}, h1.reset())
+
+	client = NewClient(
+		WithRoundTripper(h1),
+		WithHTTP3(h3))
+	require.NoError(t, send("GET", "https://example.com/i").Error)
+	req := NewRequest(context.Background(), "POST", "https://example.com/j", nil)
+	req.Body = ioutil.NopCloser(strings.NewReader("body"))
+	assert.Error(t, req.SendVia(client).Response().Error)
+	assert.Equal(t, []string{"https://example.com:8443/j"}, h3.reset())
+}
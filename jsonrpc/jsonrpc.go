@@ -0,0 +1,362 @@
+// Package jsonrpc serves JSON-RPC 2.0 (https://www.jsonrpc.org/specification) over HTTP, calling registered Go
+// functions:
+//
+//  rpc := jsonrpc.NewServer()
+//  rpc.Register("eth_blockNumber", func(ctx context.Context) (string, error) { ... })
+//  rpc.Register("add", func(ctx context.Context, a, b int) (int, error) { return a + b, nil })
+//  router.POST("/rpc", rpc.Service())
+//
+// Batches of calls are run concurrently (up to a limit; see BatchConcurrency), and notifications (calls without an
+// id) are run without their results being sent. Errors returned by methods are sent to clients with their messages:
+// an *Error is sent as it is, and any other error with the code CodeServerError. Methods which panic are logged, and
+// fail with CodeInternalError.
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"runtime/debug"
+	"sync"
+
+	"github.com/4thel00z/libhttp"
+	"github.com/monzo/slog"
+)
+
+// Error codes defined by the specification. Codes from -32000 to -32099 are reserved for implementation-defined
+// server errors.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+	CodeServerError    = -32000
+)
+
+// An Error is a JSON-RPC error object. Methods may return one to control the code and data sent to the client.
+type Error struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// Errorf returns an Error with the code and a formatted message.
+func Errorf(code int, format string, args ...interface{}) *Error {
+	return &Error{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("jsonrpc error %d: %s", e.Code, e.Message)
+}
+
+// request is a single call, as sent by a client.
+type request struct {
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      json.RawMessage `json:"id"` // empty for notifications, and "null" for a null id
+}
+
+// response is the result of a single call.
+type response struct {
+	Version string
+	Result  interface{}
+	Error   *Error
+	ID      json.RawMessage
+}
+
+// MarshalJSON includes exactly one of the result (even if it is null) and the error, as the specification requires.
+func (r *response) MarshalJSON() ([]byte, error) {
+	if r.Error != nil {
+		return json.Marshal(struct {
+			Version string          `json:"jsonrpc"`
+			Error   *Error          `json:"error"`
+			ID      json.RawMessage `json:"id"`
+		}{r.Version, r.Error, r.ID})
+	}
+	return json.Marshal(struct {
+		Version string          `json:"jsonrpc"`
+		Result  interface{}     `json:"result"`
+		ID      json.RawMessage `json:"id"`
+	}{r.Version, r.Result, r.ID})
+}
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	nullID      = json.RawMessage("null")
+)
+
+// method is a registered function.
+type method struct {
+	f         reflect.Value
+	params    []reflect.Type
+	hasResult bool
+}
+
+// A Server dispatches JSON-RPC calls to registered functions. It is safe for concurrent use.
+type Server struct {
+	m                sync.RWMutex
+	methods          map[string]*method
+	maxBatchSize     int
+	batchConcurrency int
+}
+
+// An Option configures a Server.
+type Option func(*Server)
+
+// MaxBatchSize limits the number of calls a batch may contain; larger batches fail with CodeInvalidRequest, without
+// any of their calls being run. The default is 100; 0 means batches aren't limited.
+func MaxBatchSize(n int) Option {
+	return func(s *Server) {
+		s.maxBatchSize = n
+	}
+}
+
+// BatchConcurrency limits the number of calls from each batch which run at once. The default is 10; 1 runs the calls
+// of batches one by one.
+func BatchConcurrency(n int) Option {
+	return func(s *Server) {
+		s.batchConcurrency = n
+	}
+}
+
+// NewServer returns a Server with no methods.
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		methods:          map[string]*method{},
+		maxBatchSize:     100,
+		batchConcurrency: 10}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.batchConcurrency < 1 {
+		s.batchConcurrency = 1
+	}
+	return s
+}
+
+// Register makes f callable as the named method, replacing any method already registered with the name. f must take
+// a context.Context, followed by any number of parameters, and return either an error or a result and an error:
+//
+//  func(ctx context.Context, params T) (R, error)
+//  func(ctx context.Context, a A, b B) error
+//
+// Parameters are decoded from the JSON of the call's params: by position when they are an array, each element into
+// the corresponding parameter, or into a single parameter from the whole of the params otherwise (so named params
+// are decoded into a struct or map). Parameters which aren't passed get their zero values. The context is the
+// libhttp.Request the call was made in.
+//
+// Register panics if f doesn't have a suitable signature.
+func (s *Server) Register(name string, f interface{}) {
+	v := reflect.ValueOf(f)
+	t := v.Type()
+	if t.Kind() != reflect.Func || t.NumIn() < 1 || t.In(0) != contextType || t.IsVariadic() {
+		panic(fmt.Sprintf("jsonrpc: method %s must be a function taking a context.Context", name))
+	}
+	if t.NumOut() < 1 || t.NumOut() > 2 || t.Out(t.NumOut()-1) != errorType {
+		panic(fmt.Sprintf("jsonrpc: method %s must return an error, optionally preceded by a result", name))
+	}
+	m := &method{
+		f:         v,
+		hasResult: t.NumOut() == 2}
+	for i := 1; i < t.NumIn(); i++ {
+		m.params = append(m.params, t.In(i))
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.methods[name] = m
+}
+
+// Service returns a Service which serves calls POSTed to it, singly or in batches. Responses are sent with a 200
+// status, whether the calls succeeded or not; requests consisting only of notifications get an empty 204 response.
+func (s *Server) Service() libhttp.Service {
+	return func(req libhttp.Request) libhttp.Response {
+		if req.Method != http.MethodPost {
+			rsp := libhttp.NewResponse(req)
+			rsp.StatusCode = http.StatusMethodNotAllowed
+			rsp.Header.Set("Allow", http.MethodPost)
+			return rsp
+		}
+		b, err := req.BodyBytes(true)
+		if err != nil {
+			return libhttp.Response{Error: err}
+		}
+		result := s.handle(req, b)
+		if result == nil {
+			rsp := libhttp.NewResponse(req)
+			rsp.StatusCode = http.StatusNoContent
+			return rsp
+		}
+		rsp := libhttp.NewResponse(req)
+		rsp.Encode(result)
+		return rsp
+	}
+}
+
+// handle runs the call or batch of calls in b, returning what should be sent in response (nil if nothing should).
+func (s *Server) handle(ctx context.Context, b []byte) interface{} {
+	b = bytes.TrimSpace(b)
+	if len(b) > 0 && b[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(b, &batch); err != nil {
+			return errorResponse(nullID, Errorf(CodeParseError, "Parse error: %v", err))
+		}
+		if len(batch) == 0 {
+			return errorResponse(nullID, Errorf(CodeInvalidRequest, "Empty batch"))
+		}
+		if s.maxBatchSize > 0 && len(batch) > s.maxBatchSize {
+			return errorResponse(nullID, Errorf(CodeInvalidRequest, "Batch of %d calls exceeds the limit of %d",
+				len(batch), s.maxBatchSize))
+		}
+		rsps := make([]*response, len(batch))
+		sem := make(chan struct{}, s.batchConcurrency)
+		var wg sync.WaitGroup
+		for i, call := range batch {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int, call json.RawMessage) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				rsps[i] = s.call(ctx, call)
+			}(i, call)
+		}
+		wg.Wait()
+		results := make([]*response, 0, len(rsps))
+		for _, rsp := range rsps {
+			if rsp != nil {
+				results = append(results, rsp)
+			}
+		}
+		if len(results) == 0 {
+			return nil
+		}
+		return results
+	}
+
+	if !json.Valid(b) {
+		return errorResponse(nullID, Errorf(CodeParseError, "Parse error: invalid JSON"))
+	}
+	if rsp := s.call(ctx, b); rsp != nil {
+		return rsp
+	}
+	return nil
+}
+
+// call runs a single call, returning its response, or nil if it was a notification.
+func (s *Server) call(ctx context.Context, b json.RawMessage) *response {
+	var req request
+	if err := json.Unmarshal(b, &req); err != nil || req.Version != "2.0" || req.Method == "" {
+		return errorResponse(nullID, Errorf(CodeInvalidRequest, "Invalid request"))
+	}
+	if len(req.ID) > 0 && (req.ID[0] == '{' || req.ID[0] == '[') {
+		return errorResponse(nullID, Errorf(CodeInvalidRequest, "Invalid request id"))
+	}
+
+	result, err := s.invoke(ctx, req.Method, req.Params)
+	if len(req.ID) == 0 {
+		return nil
+	}
+	if err != nil {
+		return errorResponse(req.ID, err)
+	}
+	return &response{
+		Version: "2.0",
+		Result:  result,
+		ID:      req.ID}
+}
+
+// invoke calls the named method with the params.
+func (s *Server) invoke(ctx context.Context, name string, params json.RawMessage) (result interface{}, rpcErr *Error) {
+	defer func() {
+		if v := recover(); v != nil {
+			// The panic value may hold anything, so it isn't sent to the client
+			slog.Critical(ctx, "Recovered panic in JSON-RPC method %s: %v\n%s", name, v, debug.Stack())
+			result, rpcErr = nil, Errorf(CodeInternalError, "Internal error")
+		}
+	}()
+
+	s.m.RLock()
+	m, ok := s.methods[name]
+	s.m.RUnlock()
+	if !ok {
+		return nil, Errorf(CodeMethodNotFound, "Method not found: %s", name)
+	}
+
+	args := []reflect.Value{reflect.ValueOf(ctx)}
+	params = bytes.TrimSpace(params)
+	switch {
+	case len(params) > 0 && params[0] == '[' && !(len(m.params) == 1 && isList(m.params[0])):
+		var positional []json.RawMessage
+		if err := json.Unmarshal(params, &positional); err != nil {
+			return nil, Errorf(CodeInvalidParams, "Invalid params: %v", err)
+		}
+		if len(positional) > len(m.params) {
+			return nil, Errorf(CodeInvalidParams, "Invalid params: expected at most %d, got %d", len(m.params),
+				len(positional))
+		}
+		for i, t := range m.params {
+			v := reflect.New(t)
+			if i < len(positional) {
+				if err := json.Unmarshal(positional[i], v.Interface()); err != nil {
+					return nil, Errorf(CodeInvalidParams, "Invalid params: param %d: %v", i, err)
+				}
+			}
+			args = append(args, v.Elem())
+		}
+	case len(m.params) == 1:
+		v := reflect.New(m.params[0])
+		if len(params) > 0 && !bytes.Equal(params, nullID) {
+			if err := json.Unmarshal(params, v.Interface()); err != nil {
+				return nil, Errorf(CodeInvalidParams, "Invalid params: %v", err)
+			}
+		}
+		args = append(args, v.Elem())
+	case len(params) > 0 && !bytes.Equal(params, nullID):
+		return nil, Errorf(CodeInvalidParams, "Invalid params: expected %d positional params", len(m.params))
+	default:
+		for _, t := range m.params {
+			args = append(args, reflect.Zero(t))
+		}
+	}
+
+	out := m.f.Call(args)
+	if err, _ := out[len(out)-1].Interface().(error); err != nil {
+		if rpcErr, ok := err.(*Error); ok {
+			return nil, rpcErr
+		}
+		return nil, &Error{
+			Code:    CodeServerError,
+			Message: err.Error()}
+	}
+	if !m.hasResult {
+		return nil, nil
+	}
+	return out[0].Interface(), nil
+}
+
+// isList returns whether a JSON array can be decoded into a value of type t.
+func isList(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Interface:
+		return true
+	case reflect.Ptr:
+		return isList(t.Elem())
+	}
+	return false
+}
+
+func errorResponse(id json.RawMessage, err *Error) *response {
+	return &response{
+		Version: "2.0",
+		Error:   err,
+		ID:      id}
+}
@@ -0,0 +1,185 @@
+package jsonrpc
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/4thel00z/libhttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestServer() (*Server, *int32) {
+	var notified int32
+	rpc := NewServer()
+	rpc.Register("add", func(ctx context.Context, a, b int) (int, error) {
+		return a + b, nil
+	})
+	rpc.Register("greet", func(ctx context.Context, p struct {
+		Name string `json:"name"`
+	}) (string, error) {
+		return "hello " + p.Name, nil
+	})
+	rpc.Register("sum", func(ctx context.Context, ns []int) (int, error) {
+		total := 0
+		for _, n := range ns {
+			total += n
+		}
+		return total, nil
+	})
+	rpc.Register("notify", func(ctx context.Context) error {
+		atomic.AddInt32(&notified, 1)
+		return nil
+	})
+	rpc.Register("fail", func(ctx context.Context) (interface{}, error) {
+		return nil, errors.New("boom")
+	})
+	rpc.Register("teapot", func(ctx context.Context) error {
+		return &Error{Code: 418, Message: "I'm a teapot", Data: "short and stout"}
+	})
+	return rpc, &notified
+}
+
+// call POSTs body to the service, returning the response's status and body.
+func call(t *testing.T, svc libhttp.Service, body string) (int, string) {
+	req := libhttp.NewRequest(context.Background(), "POST", "/rpc", nil)
+	req.Body = ioutil.NopCloser(strings.NewReader(body))
+	rsp := req.SendVia(svc).Response()
+	require.NoError(t, rsp.Error)
+	b, err := rsp.BodyBytes(true)
+	require.NoError(t, err)
+	return rsp.StatusCode, strings.TrimSpace(string(b))
+}
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+	rpc, _ := newTestServer()
+	svc := rpc.Service()
+
+	cases := []struct {
+		body, expected string
+	}{
+		{`{"jsonrpc":"2.0","method":"add","params":[1,2],"id":1}`,
+			`{"jsonrpc":"2.0","result":3,"id":1}`},
+		{`{"jsonrpc":"2.0","method":"greet","params":{"name":"world"},"id":"a"}`,
+			`{"jsonrpc":"2.0","result":"hello world","id":"a"}`},
+		{`{"jsonrpc":"2.0","method":"sum","params":[1,2,3],"id":null}`,
+			`{"jsonrpc":"2.0","result":6,"id":null}`},
+		{`{"jsonrpc":"2.0","method":"add","params":[1],"id":2}`,
+			`{"jsonrpc":"2.0","result":1,"id":2}`},
+		{`{"jsonrpc":"2.0","method":"notify","id":3}`,
+			`{"jsonrpc":"2.0","result":null,"id":3}`},
+		{`{"jsonrpc":"2.0","method":"add","params":[1,2,3],"id":4}`,
+			`{"jsonrpc":"2.0","error":{"code":-32602,"message":"Invalid params: expected at most 2, got 3"},"id":4}`},
+		{`{"jsonrpc":"2.0","method":"add","params":["x"],"id":5}`,
+			`{"jsonrpc":"2.0","error":{"code":-32602,"message":"Invalid params: param 0: json: cannot unmarshal string into Go value of type int"},"id":5}`},
+		{`{"jsonrpc":"2.0","method":"nope","id":6}`,
+			`{"jsonrpc":"2.0","error":{"code":-32601,"message":"Method not found: nope"},"id":6}`},
+		{`{"jsonrpc":"2.0","method":"fail","id":7}`,
+			`{"jsonrpc":"2.0","error":{"code":-32000,"message":"boom"},"id":7}`},
+		{`{"jsonrpc":"2.0","method":"teapot","id":8}`,
+			`{"jsonrpc":"2.0","error":{"code":418,"message":"I'm a teapot","data":"short and stout"},"id":8}`},
+		{`{"jsonrpc":"1.0","method":"add","id":9}`,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid request"},"id":null}`},
+		{`{"jsonrpc":"2.0","method":"add"`,
+			`{"jsonrpc":"2.0","error":{"code":-32700,"message":"Parse error: invalid JSON"},"id":null}`},
+		{`[]`,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"Empty batch"},"id":null}`}}
+	for _, c := range cases {
+		status, body := call(t, svc, c.body)
+		assert.Equal(t, http.StatusOK, status, c.body)
+		assert.JSONEq(t, c.expected, body, c.body)
+	}
+}
+
+func TestServerBatch(t *testing.T) {
+	t.Parallel()
+	rpc, notified := newTestServer()
+	svc := rpc.Service()
+
+	status, body := call(t, svc, `[
+		{"jsonrpc":"2.0","method":"add","params":[1,2],"id":1},
+		{"jsonrpc":"2.0","method":"notify"},
+		1,
+		{"jsonrpc":"2.0","method":"nope","id":2}]`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `[
+		{"jsonrpc":"2.0","result":3,"id":1},
+		{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid request"},"id":null},
+		{"jsonrpc":"2.0","error":{"code":-32601,"message":"Method not found: nope"},"id":2}]`, body)
+	assert.Equal(t, int32(1), atomic.LoadInt32(notified))
+
+	// Notifications alone get no response
+	status, body = call(t, svc, `[{"jsonrpc":"2.0","method":"notify"},{"jsonrpc":"2.0","method":"notify"}]`)
+	assert.Equal(t, http.StatusNoContent, status)
+	assert.Empty(t, body)
+	status, body = call(t, svc, `{"jsonrpc":"2.0","method":"notify"}`)
+	assert.Equal(t, http.StatusNoContent, status)
+	assert.Empty(t, body)
+	assert.Equal(t, int32(4), atomic.LoadInt32(notified))
+
+	// Only POST is allowed
+	rsp := libhttp.NewRequest(context.Background(), "GET", "/rpc", nil).SendVia(svc).Response()
+	assert.Equal(t, http.StatusMethodNotAllowed, rsp.StatusCode)
+}
+
+func TestServerPanic(t *testing.T) {
+	t.Parallel()
+	rpc := NewServer()
+	rpc.Register("panic", func(ctx context.Context) error {
+		panic("secret")
+	})
+	svc := rpc.Service()
+
+	_, body := call(t, svc, `{"jsonrpc":"2.0","method":"panic","id":1}`)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","error":{"code":-32603,"message":"Internal error"},"id":1}`, body)
+	_, body = call(t, svc, `[{"jsonrpc":"2.0","method":"panic","id":1},{"jsonrpc":"2.0","method":"panic"}]`)
+	assert.JSONEq(t, `[{"jsonrpc":"2.0","error":{"code":-32603,"message":"Internal error"},"id":1}]`, body)
+}
+
+func TestServerBatchLimits(t *testing.T) {
+	t.Parallel()
+	var running, peak int32
+	rpc := NewServer(MaxBatchSize(5), BatchConcurrency(2))
+	rpc.Register("slow", func(ctx context.Context) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	})
+	svc := rpc.Service()
+
+	calls := strings.TrimSuffix(strings.Repeat(`{"jsonrpc":"2.0","method":"slow","id":1},`, 5), ",")
+	_, body := call(t, svc, "["+calls+"]")
+	assert.Equal(t, 5, strings.Count(body, `"result":null`))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&peak))
+
+	_, body = call(t, svc, "["+calls+`,{"jsonrpc":"2.0","method":"slow","id":6}]`)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","error":{"code":-32600,"message":"Batch of 6 calls exceeds the limit of 5"},
+		"id":null}`, body)
+}
+
+func TestRegisterInvalid(t *testing.T) {
+	t.Parallel()
+	rpc := NewServer()
+	for _, f := range []interface{}{
+		"not a function",
+		func() error { return nil },
+		func(ctx context.Context) {},
+		func(ctx context.Context) (int, int) { return 0, 0 },
+		func(ctx context.Context, args ...int) error { return nil }} {
+		assert.Panics(t, func() { rpc.Register("bad", f) })
+	}
+}
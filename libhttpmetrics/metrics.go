@@ -0,0 +1,92 @@
+// Package libhttpmetrics defines the interface through which libhttp's servers, routers, clients and filters report
+// metrics, so they can all record into one registry whichever monitoring system it belongs to. It doesn't depend on
+// libhttp, so it can be imported by packages which libhttp itself depends on without creating an import cycle.
+//
+// NewPrometheus returns a Registry which exposes its metrics in the Prometheus text format, without needing the
+// Prometheus client library:
+//
+//  reg := libhttpmetrics.NewPrometheus()
+//  srv, err := libhttp.Listen(svc, ":8000", libhttp.WithServerMetricsRegistry(reg))
+//  client := libhttp.NewClient(libhttp.WithClientMetricsRegistry(reg))
+//  http.Handle("/metrics", reg)
+//
+// Other systems can be supported by implementing Registry. The otelmetrics module
+// (github.com/4thel00z/libhttp/otelmetrics) records metrics through OpenTelemetry; it is a module of its own, so that
+// libhttp doesn't depend on the OpenTelemetry API unless it is used.
+package libhttpmetrics
+
+// A Registry creates metrics. Each metric has a name, help text, and the names of the labels which its values are
+// partitioned by; the values of the labels are given, in the same order, whenever it is updated.
+//
+// Asking a Registry for a metric which already exists returns the existing metric, so that several components (two
+// clients, say) can share a registry and record into the same metrics. Registries must be safe for concurrent use.
+type Registry interface {
+	// Counter returns a counter, which is a value that only increases, such as a number of requests.
+	Counter(name, help string, labels ...string) Counter
+	// Gauge returns a gauge, which is a value that can go up and down, such as a number of requests in progress.
+	Gauge(name, help string, labels ...string) Gauge
+	// Histogram returns a histogram, which counts observations (of request durations, say) in buckets with the
+	// given upper bounds, which must be in increasing order. If buckets is nil, DefaultBuckets are used.
+	Histogram(name, help string, buckets []float64, labels ...string) Histogram
+}
+
+// A Counter is a value that only increases.
+type Counter interface {
+	// Add adds v, which mustn't be negative, to the counter with the given label values.
+	Add(v float64, labelValues ...string)
+}
+
+// A Gauge is a value that can go up and down.
+type Gauge interface {
+	// Add adds v, which may be negative, to the gauge with the given label values.
+	Add(v float64, labelValues ...string)
+	// Set sets the gauge with the given label values to v.
+	Set(v float64, labelValues ...string)
+}
+
+// A Histogram counts observations in buckets.
+type Histogram interface {
+	// Observe records v in the histogram with the given label values.
+	Observe(v float64, labelValues ...string)
+}
+
+// DefaultBuckets are the default histogram buckets, suited to request durations in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Nop returns a Registry whose metrics discard everything recorded in them.
+func Nop() Registry {
+	return nop{}
+}
+
+type nop struct{}
+
+func (nop) Counter(string, string, ...string) Counter                { return nop{} }
+func (nop) Gauge(string, string, ...string) Gauge                    { return nop{} }
+func (nop) Histogram(string, string, []float64, ...string) Histogram { return nop{} }
+func (nop) Add(float64, ...string)                                   {}
+func (nop) Set(float64, ...string)                                   {}
+func (nop) Observe(float64, ...string)                               {}
+
+// A MeterProvider provides a Registry for each instrumentation scope, in the same way as OpenTelemetry's
+// MeterProvider provides a Meter for each. Adapting an OpenTelemetry MeterProvider to it allows libhttp to record
+// metrics through the OpenTelemetry SDK.
+type MeterProvider interface {
+	Meter(scope string) Registry
+}
+
+// SingleRegistry returns a MeterProvider which provides r for every scope.
+func SingleRegistry(r Registry) MeterProvider {
+	return singleRegistry{r}
+}
+
+type singleRegistry struct {
+	r Registry
+}
+
+func (p singleRegistry) Meter(string) Registry {
+	return p.r
+}
+
+// DurationBuckets are the histogram buckets which OpenTelemetry's semantic conventions advise for HTTP request
+// durations, in seconds.
+var DurationBuckets = []float64{0, .005, .01, .025, .05, .075, .1, .25, .5, .75, 1, 2.5, 5, 7.5, 10}
@@ -0,0 +1,274 @@
+package libhttpmetrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	kindCounter   = "counter"
+	kindGauge     = "gauge"
+	kindHistogram = "histogram"
+)
+
+// Prometheus is a Registry which keeps its metrics in memory, and exposes them in the Prometheus text format: it is
+// an http.Handler, which can be mounted on a path (such as /metrics) to be scraped.
+//
+// Characters which Prometheus doesn't allow in names are replaced by underscores, so that the dotted names of
+// OpenTelemetry's semantic conventions (http.server.request.duration, say) become http_server_request_duration.
+//
+// Asking for a metric with the same name as an existing metric, but of a different kind or with different labels,
+// panics, as does updating a metric with the wrong number of label values: these are programming errors.
+type Prometheus struct {
+	m        sync.Mutex
+	families map[string]*family
+}
+
+// NewPrometheus returns an empty Prometheus registry.
+func NewPrometheus() *Prometheus {
+	return &Prometheus{
+		families: map[string]*family{}}
+}
+
+type family struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+
+	m      sync.Mutex
+	series map[string]*series // joined label values → series
+}
+
+type series struct {
+	labelValues []string
+	value       float64  // the value of counters and gauges, and the sum of histograms
+	counts      []uint64 // histogram bucket counts (not cumulative), with a last bucket for +Inf
+	count       uint64
+}
+
+// Counter implements Registry.
+func (p *Prometheus) Counter(name, help string, labels ...string) Counter {
+	return (*counter)(p.family(name, help, kindCounter, nil, labels))
+}
+
+// Gauge implements Registry.
+func (p *Prometheus) Gauge(name, help string, labels ...string) Gauge {
+	return (*gauge)(p.family(name, help, kindGauge, nil, labels))
+}
+
+// Histogram implements Registry.
+func (p *Prometheus) Histogram(name, help string, buckets []float64, labels ...string) Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	return (*histogram)(p.family(name, help, kindHistogram, buckets, labels))
+}
+
+func (p *Prometheus) family(name, help, kind string, buckets []float64, labels []string) *family {
+	name = sanitizeName(name)
+	sanitized := make([]string, len(labels))
+	for i, l := range labels {
+		sanitized[i] = sanitizeName(l)
+	}
+	labels = sanitized
+	p.m.Lock()
+	defer p.m.Unlock()
+	if f, ok := p.families[name]; ok {
+		if f.kind != kind || strings.Join(f.labels, ",") != strings.Join(labels, ",") {
+			panic(fmt.Sprintf("libhttpmetrics: %s is already registered as a %s with labels %v", name, f.kind,
+				f.labels))
+		}
+		return f
+	}
+	f := &family{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  append([]string(nil), labels...),
+		buckets: append([]float64(nil), buckets...),
+		series:  map[string]*series{}}
+	p.families[name] = f
+	return f
+}
+
+// update calls fn with the series for the label values, creating it if necessary.
+func (f *family) update(labelValues []string, fn func(*series)) {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("libhttpmetrics: %s has labels %v, but got values %v", f.name, f.labels, labelValues))
+	}
+	key := strings.Join(labelValues, "\xff")
+	f.m.Lock()
+	defer f.m.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{
+			labelValues: append([]string(nil), labelValues...)}
+		if f.kind == kindHistogram {
+			s.counts = make([]uint64, len(f.buckets)+1)
+		}
+		f.series[key] = s
+	}
+	fn(s)
+}
+
+type counter family
+
+func (c *counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic(fmt.Sprintf("libhttpmetrics: counter %s can't decrease", c.name))
+	}
+	(*family)(c).update(labelValues, func(s *series) { s.value += v })
+}
+
+type gauge family
+
+func (g *gauge) Add(v float64, labelValues ...string) {
+	(*family)(g).update(labelValues, func(s *series) { s.value += v })
+}
+
+func (g *gauge) Set(v float64, labelValues ...string) {
+	(*family)(g).update(labelValues, func(s *series) { s.value = v })
+}
+
+type histogram family
+
+func (h *histogram) Observe(v float64, labelValues ...string) {
+	i := sort.SearchFloat64s(h.buckets, v) // the first bucket whose upper bound is ≥ v
+	(*family)(h).update(labelValues, func(s *series) {
+		s.counts[i]++
+		s.count++
+		s.value += v
+	})
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (p *Prometheus) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	p.WriteTo(rw)
+}
+
+// WriteTo writes the metrics in the Prometheus text format to w, ordered by name and then by label values.
+func (p *Prometheus) WriteTo(w io.Writer) (int64, error) {
+	p.m.Lock()
+	families := make([]*family, 0, len(p.families))
+	for _, f := range p.families {
+		families = append(families, f)
+	}
+	p.m.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, f := range families {
+		f.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+func (f *family) write(w *bufio.Writer) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	if len(f.series) == 0 {
+		return
+	}
+	all := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		all = append(all, s)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		a, b := all[i].labelValues, all[j].labelValues
+		for k := range a {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return false
+	})
+
+	if f.help != "" {
+		fmt.Fprintf(w, "# HELP %s %s\n", f.name, helpEscaper.Replace(f.help))
+	}
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+	for _, s := range all {
+		labels := f.labelPairs(s.labelValues)
+		if f.kind != kindHistogram {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, wrapLabels(labels), formatFloat(s.value))
+			continue
+		}
+		var cumulative uint64
+		for i, c := range s.counts {
+			cumulative += c
+			le := math.Inf(1)
+			if i < len(f.buckets) {
+				le = f.buckets[i]
+			}
+			bucketLabels := append(labels[:len(labels):len(labels)], `le="`+formatFloat(le)+`"`)
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, wrapLabels(bucketLabels), cumulative)
+		}
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, wrapLabels(labels), formatFloat(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, wrapLabels(labels), s.count)
+	}
+}
+
+func (f *family) labelPairs(values []string) []string {
+	pairs := make([]string, len(values))
+	for i, v := range values {
+		pairs[i] = f.labels[i] + `="` + labelEscaper.Replace(v) + `"`
+	}
+	return pairs
+}
+
+func wrapLabels(pairs []string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// sanitizeName replaces characters which aren't allowed in metric and label names with underscores.
+func sanitizeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == ':' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
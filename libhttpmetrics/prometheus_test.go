@@ -0,0 +1,92 @@
+package libhttpmetrics
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheus(t *testing.T) {
+	t.Parallel()
+	p := NewPrometheus()
+	c := p.Counter("requests_total", "Requests.\nAll of them.", "method", "path")
+	c.Add(1, "GET", "/a")
+	c.Add(2, "GET", "/a")
+	c.Add(1, "POST", `/"b"`)
+	assert.Equal(t, c, p.Counter("requests_total", "ignored", "method", "path"), "metrics are shared by name")
+
+	g := p.Gauge("in_flight", "")
+	g.Add(3)
+	g.Add(-1)
+	p.Gauge("temperature", "Temperature.").Set(-2.5)
+	p.Gauge("unused", "Never updated.")
+
+	h := p.Histogram("duration_seconds", "Durations.", []float64{0.1, 1}, "method")
+	h.Observe(0.05, "GET")
+	h.Observe(0.1, "GET")
+	h.Observe(0.5, "GET")
+	h.Observe(3, "GET")
+
+	var buf bytes.Buffer
+	n, err := p.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), n)
+	assert.Equal(t, `# HELP duration_seconds Durations.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{method="GET",le="0.1"} 2
+duration_seconds_bucket{method="GET",le="1"} 3
+duration_seconds_bucket{method="GET",le="+Inf"} 4
+duration_seconds_sum{method="GET"} 3.65
+duration_seconds_count{method="GET"} 4
+# TYPE in_flight gauge
+in_flight 2
+# HELP requests_total Requests.\nAll of them.
+# TYPE requests_total counter
+requests_total{method="GET",path="/a"} 3
+requests_total{method="POST",path="/\"b\""} 1
+# HELP temperature Temperature.
+# TYPE temperature gauge
+temperature -2.5
+`, buf.String())
+
+	rw := httptest.NewRecorder()
+	p.ServeHTTP(rw, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rw.Header().Get("Content-Type"))
+	b, _ := ioutil.ReadAll(rw.Body)
+	assert.Equal(t, buf.String(), string(b))
+}
+
+func TestPrometheusMisuse(t *testing.T) {
+	t.Parallel()
+	p := NewPrometheus()
+	c := p.Counter("requests_total", "", "method")
+	assert.Panics(t, func() { p.Gauge("requests_total", "", "method") })
+	assert.Panics(t, func() { p.Counter("requests_total", "", "method", "path") })
+	assert.Panics(t, func() { c.Add(1) })
+	assert.Panics(t, func() { c.Add(-1, "GET") })
+}
+
+func TestNop(t *testing.T) {
+	t.Parallel()
+	r := Nop()
+	r.Counter("a", "").Add(1, "x")
+	r.Gauge("b", "").Set(1)
+	r.Histogram("c", "", nil).Observe(1)
+}
+
+func TestPrometheusSanitizesNames(t *testing.T) {
+	t.Parallel()
+	p := NewPrometheus()
+	p.Gauge("http.server.active_requests", "", "http.request.method").Set(1, "GET")
+	var buf bytes.Buffer
+	_, err := p.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, "# TYPE http_server_active_requests gauge\n"+
+		`http_server_active_requests{http_request_method="GET"} 1`+"\n", buf.String())
+	assert.Equal(t, p.Gauge("http.server.active_requests", "", "http.request.method"),
+		p.Gauge("http_server_active_requests", "", "http_request_method"))
+}
@@ -0,0 +1,97 @@
+package libhttp
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// A SampleOption configures a LogSampler.
+type SampleOption func(*LogSampler)
+
+// SampleRate sets the proportion (between 0 and 1) of entries which are logged, for statuses without an override.
+// The default is 1, logging all of them.
+func SampleRate(rate float64) SampleOption {
+	return func(s *LogSampler) {
+		s.rate = rate
+	}
+}
+
+// SampleStatus overrides the sampling rate for responses with the status code. Requests which failed without a
+// response have status 0.
+func SampleStatus(status int, rate float64) SampleOption {
+	return func(s *LogSampler) {
+		s.statuses[status] = rate
+	}
+}
+
+// SampleStatusClass overrides the sampling rate for responses in a class of statuses: SampleStatusClass(5, 1) logs
+// all 5xx responses, for example. Overrides for individual statuses take precedence.
+func SampleStatusClass(class int, rate float64) SampleOption {
+	return func(s *LogSampler) {
+		s.classes[class] = rate
+	}
+}
+
+// SampleBurst limits the sampled entries to a burst of n, refilled at n per interval, suppressing any more. The limit
+// applies to all entries, including those whose status is always logged, so that the cost of logging stays bounded
+// when everything is failing.
+func SampleBurst(n int, interval time.Duration) SampleOption {
+	return func(s *LogSampler) {
+		s.burst = newTokenBucket(float64(n)/interval.Seconds(), n)
+	}
+}
+
+// A LogSampler decides which entries are written by a logging path (AccessLogFilter, ErrorLogFilter,
+// SlowRequestFilter or a client's WithRequestLogging), to keep the cost of logging bounded under load. Each entry is
+// sampled independently when its request completes, at a rate which can depend on its status, and then the burst
+// limit, if any, is applied:
+//
+//  sampler := libhttp.NewLogSampler(
+//      libhttp.SampleRate(0.01),              // 1% of requests,
+//      libhttp.SampleStatusClass(5, 1),       // but every 5xx,
+//      libhttp.SampleBurst(100, time.Second)) // and no more than 100 a second
+//
+// A LogSampler is safe for concurrent use, and may be shared by several logging paths to bound their combined cost.
+type LogSampler struct {
+	rate     float64
+	statuses map[int]float64
+	classes  map[int]float64
+	burst    *tokenBucket
+	dropped  int64
+}
+
+// NewLogSampler returns a LogSampler, which logs every entry unless it is configured otherwise.
+func NewLogSampler(opts ...SampleOption) *LogSampler {
+	s := &LogSampler{
+		rate:     1,
+		statuses: map[int]float64{},
+		classes:  map[int]float64{}}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Sample returns whether an entry for a request with the status should be logged. A nil LogSampler logs everything.
+func (s *LogSampler) Sample(status int) bool {
+	if s == nil {
+		return true
+	}
+	rate, ok := s.statuses[status]
+	if !ok {
+		if rate, ok = s.classes[status/100]; !ok {
+			rate = s.rate
+		}
+	}
+	if (rate < 1 && rand.Float64() >= rate) || (s.burst != nil && !s.burst.take(1)) {
+		atomic.AddInt64(&s.dropped, 1)
+		return false
+	}
+	return true
+}
+
+// Dropped returns the number of entries which haven't been logged, because they weren't sampled or were suppressed.
+func (s *LogSampler) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
@@ -0,0 +1,46 @@
+package libhttp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogSampler(t *testing.T) {
+	t.Parallel()
+	var s *LogSampler
+	assert.True(t, s.Sample(200), "a nil sampler logs everything")
+
+	s = NewLogSampler(SampleRate(0), SampleStatusClass(5, 1), SampleStatus(503, 0), SampleStatus(0, 1))
+	for i := 0; i < 100; i++ {
+		assert.False(t, s.Sample(200))
+		assert.True(t, s.Sample(500))
+		assert.False(t, s.Sample(503))
+		assert.True(t, s.Sample(0))
+	}
+	assert.Equal(t, int64(200), s.Dropped())
+
+	s = NewLogSampler(SampleRate(0.5))
+	n := 0
+	for i := 0; i < 10000; i++ {
+		if s.Sample(200) {
+			n++
+		}
+	}
+	assert.InDelta(t, 5000, n, 500)
+	assert.Equal(t, int64(10000-n), s.Dropped())
+}
+
+func TestLogSamplerBurst(t *testing.T) {
+	t.Parallel()
+	s := NewLogSampler(SampleStatusClass(5, 1), SampleBurst(3, 100*time.Millisecond))
+	for i := 0; i < 3; i++ {
+		assert.True(t, s.Sample(500))
+	}
+	assert.False(t, s.Sample(500), "the burst limit applies to statuses which are always logged")
+	assert.False(t, s.Sample(200))
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, s.Sample(200))
+	assert.Equal(t, int64(2), s.Dropped())
+}
@@ -0,0 +1,60 @@
+package libhttp
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// LongPoll responds to a long-poll request with the result of wait, which should block until there is an event to
+// send to the client, or until the context it is passed is done:
+//
+//  func messages(req libhttp.Request) libhttp.Response {
+//      return req.LongPoll(30*time.Second, func(ctx context.Context) (interface{}, error) {
+//          select {
+//          case msg := <-subscribe(req.URL.Query().Get("after")):
+//              return msg, nil
+//          case <-ctx.Done():
+//              return nil, ctx.Err()
+//          }
+//      })
+//  }
+//
+// The context is done once timeout has passed, when the client disconnects, or when the server begins to shut down
+// (so that long polls don't hold up draining). In the first and last cases, the client gets an empty 204 response,
+// telling it to poll again; otherwise the event wait returns is sent as the response's body (with a 204 if it is nil),
+// or the error it returns as an error. Responses are marked as uncacheable.
+func (r Request) LongPoll(timeout time.Duration, wait func(ctx context.Context) (interface{}, error)) Response {
+	var parent context.Context = r
+	if r.Context == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+	if r.server != nil {
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-r.server.Done():
+				cancel()
+			case <-stop:
+			}
+		}()
+	}
+
+	v, err := wait(ctx)
+	var rsp Response
+	switch {
+	case err == nil && v != nil:
+		rsp = r.Response(v)
+	case err == nil, ctx.Err() != nil && parent.Err() == nil:
+		// The event didn't arrive in time, or the server is draining
+		rsp = NewResponse(r)
+		rsp.StatusCode = http.StatusNoContent
+	default:
+		return Response{Error: err}
+	}
+	rsp.Header.Set("Cache-Control", "no-store")
+	return rsp
+}
@@ -0,0 +1,79 @@
+package libhttp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLongPoll(t *testing.T) {
+	t.Parallel()
+	events := make(chan string, 1)
+	svc := Service(func(req Request) Response {
+		return req.LongPoll(100*time.Millisecond, func(ctx context.Context) (interface{}, error) {
+			if req.URL.Path == "/fail" {
+				return nil, errors.New("boom")
+			}
+			select {
+			case e := <-events:
+				return e, nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		})
+	}).Filter(ErrorFilter)
+	ctx := context.Background()
+
+	events <- "hello"
+	rsp := svc(NewRequest(ctx, "GET", "/", nil))
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Equal(t, "no-store", rsp.Header.Get("Cache-Control"))
+	var body string
+	require.NoError(t, rsp.Decode(&body))
+	assert.Equal(t, "hello", body)
+
+	// Without an event, the poll times out
+	start := time.Now()
+	rsp = svc(NewRequest(ctx, "GET", "/", nil))
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusNoContent, rsp.StatusCode)
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+
+	rsp = svc(NewRequest(ctx, "GET", "/fail", nil))
+	assert.Equal(t, http.StatusInternalServerError, rsp.StatusCode)
+
+	// When the client goes away, the wait ends with its context's error
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	rsp = svc(NewRequest(cctx, "GET", "/", nil))
+	assert.Error(t, rsp.Error)
+}
+
+func TestLongPollServerDrain(t *testing.T) {
+	t.Parallel()
+	waiting := make(chan struct{})
+	svc := Service(func(req Request) Response {
+		return req.LongPoll(time.Minute, func(ctx context.Context) (interface{}, error) {
+			close(waiting)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+	})
+	s, err := Listen(svc, "localhost:0")
+	require.NoError(t, err)
+
+	f := NewRequest(context.Background(), "GET", "http://"+s.Listener().Addr().String()+"/", nil).Send()
+	<-waiting
+	start := time.Now()
+	s.Stop(context.Background())
+	rsp := f.Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusNoContent, rsp.StatusCode)
+	assert.True(t, time.Since(start) < time.Second)
+}
@@ -0,0 +1,74 @@
+package libhttp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+var errMemListenerClosed = errors.New("libhttp: in-memory listener closed")
+
+type memAddr struct{}
+
+func (memAddr) Network() string { return "memory" }
+func (memAddr) String() string  { return "memory" }
+
+// memListener is a net.Listener whose connections are in-memory pipes, created by calls to its Dial method.
+type memListener struct {
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newMemListener() *memListener {
+	return &memListener{
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{})}
+}
+
+func (l *memListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.closed:
+		return nil, errMemListenerClosed
+	}
+}
+
+func (l *memListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *memListener) Addr() net.Addr {
+	return memAddr{}
+}
+
+// Dial connects to the listener. Its signature matches that of net.Dialer.DialContext; the network and address are
+// ignored.
+func (l *memListener) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.closed:
+		return nil, errMemListenerClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// ListenInMemory starts a HTTP server on an in-memory listener, which allows the full HTTP stack to be exercised (for
+// example in tests) without binding to a real port. Connections can be made using the returned dial function, which
+// can be used as the DialContext of a http.Transport:
+//
+//  srv, dial := libhttp.ListenInMemory(svc)
+//  defer srv.Stop(ctx)
+//  client := libhttp.HttpService(&http.Transport{DialContext: dial})
+//  rsp := libhttp.NewRequest(ctx, "GET", "http://any-host/ping", nil).SendVia(client).Response()
+func ListenInMemory(svc Service) (*Server, func(ctx context.Context, network, addr string) (net.Conn, error)) {
+	l := newMemListener()
+	s, _ := Serve(svc, l) // Serve can't fail
+	return s, l.Dial
+}
@@ -0,0 +1,33 @@
+package libhttp
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenInMemory(t *testing.T) {
+	t.Parallel()
+
+	srv, dial := ListenInMemory(Service(func(req Request) Response {
+		return req.Response(req.URL.Path)
+	}).Filter(ErrorFilter))
+	transport := &http.Transport{DialContext: dial}
+	defer transport.CloseIdleConnections()
+	client := HttpService(transport).Filter(ErrorFilter)
+
+	for _, p := range []string{"/a", "/b"} {
+		rsp := NewRequest(context.Background(), "GET", "http://in-memory"+p, nil).SendVia(client).Response()
+		require.NoError(t, rsp.Error)
+		var body string
+		require.NoError(t, rsp.Decode(&body))
+		assert.Equal(t, p, body)
+	}
+
+	srv.Stop(context.Background())
+	_, err := dial(context.Background(), "tcp", "in-memory")
+	assert.Error(t, err)
+}
@@ -0,0 +1,127 @@
+package libhttp
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/4thel00z/libhttp/libhttpmetrics"
+)
+
+// WithServerMetricsRegistry records metrics about the requests the server handles in r:
+//
+//  http_server_requests_total{method,route,code}            counter
+//  http_server_request_duration_seconds{method,route}       histogram
+//  http_server_requests_in_flight{method}                   gauge
+//
+// The route is the pattern of the Router route which handled the request (so that requests for /users/1 and /users/2
+// are recorded together, as /users/:id), along with any operation named with SetOperation, or empty if the request
+// wasn't routed. Durations are measured until the service returns its response, so they don't include writing a
+// streamed body. The code is the response's status, so services should use ErrorFilter for errors to be recorded with
+// the status they are sent with.
+func WithServerMetricsRegistry(r libhttpmetrics.Registry) ServerOption {
+	return func(o *serverOptions) {
+		o.metrics = r
+	}
+}
+
+// WithClientMetricsRegistry records metrics about the requests the client sends, and the connections it obtains for
+// them, in r, by target (the host and port of each request's URL). Each attempt is recorded separately, if the client
+// retries requests, and a code of "error" is recorded for requests which failed without a response.
+//
+//  http_client_requests_total{method,target,code}           counter
+//  http_client_request_duration_seconds{method,target}      histogram
+//  http_client_connections_total{target,reused}             counter
+//  http_client_connection_idle_seconds{target}              histogram
+//  http_client_dial_duration_seconds{target}                histogram
+//  http_client_dial_errors_total{target}                    counter
+//  http_client_dns_duration_seconds{target}                 histogram
+//  http_client_tls_handshake_duration_seconds{target}       histogram
+//
+// Connections are counted once per request, whether they were new (reused="false") or reused; dials are counted for
+// each connection attempt, of which there may be several per connection (for IPv4 and IPv6, say).
+func WithClientMetricsRegistry(r libhttpmetrics.Registry) ClientOption {
+	return func(o *clientOptions) {
+		o.registry = r
+	}
+}
+
+// RetryMetrics records the number of retries a RetryFilter makes, by target, in r:
+//
+//  http_client_retries_total{method,target}                 counter
+func RetryMetrics(r libhttpmetrics.Registry) RetryOption {
+	return func(o *retryOptions) {
+		o.retries = r.Counter("http_client_retries_total", "Requests retried by clients.", "method", "target")
+	}
+}
+
+// BreakerMetrics records the state of a CircuitBreaker's circuits (0 when closed, 1 when open, and 2 when half-open),
+// its state changes, and the requests it rejects, by circuit key, in r:
+//
+//  http_client_circuit_state{circuit}                       gauge
+//  http_client_circuit_transitions_total{circuit,state}     counter
+//  http_client_circuit_rejected_total{circuit}              counter
+func BreakerMetrics(r libhttpmetrics.Registry) BreakerOption {
+	return func(b *CircuitBreaker) {
+		b.metrics = &breakerMetrics{
+			state: r.Gauge("http_client_circuit_state",
+				"State of circuit breakers' circuits: 0 when closed, 1 when open, and 2 when half-open.", "circuit"),
+			transitions: r.Counter("http_client_circuit_transitions_total",
+				"Circuit breaker state changes, by the new state.", "circuit", "state"),
+			rejected: r.Counter("http_client_circuit_rejected_total",
+				"Requests rejected by open circuit breakers.", "circuit")}
+	}
+}
+
+type breakerMetrics struct {
+	state       libhttpmetrics.Gauge
+	transitions libhttpmetrics.Counter
+	rejected    libhttpmetrics.Counter
+}
+
+// serverMetricsFilter records metrics for requests handled by a server.
+func serverMetricsFilter(r libhttpmetrics.Registry) Filter {
+	requests := r.Counter("http_server_requests_total", "Requests handled by servers.", "method", "route", "code")
+	durations := r.Histogram("http_server_request_duration_seconds", "Time taken to handle requests.", nil,
+		"method", "route")
+	inFlight := r.Gauge("http_server_requests_in_flight", "Requests being handled by servers.", "method")
+	return func(req Request, svc Service) Response {
+		inFlight.Add(1, req.Method)
+		defer inFlight.Add(-1, req.Method)
+		start := time.Now()
+		rsp := svc(req)
+		route := ""
+		if rsp.Request != nil {
+			route = routeOf(*rsp.Request)
+		}
+		code := http.StatusInternalServerError
+		if rsp.Response != nil {
+			code = rsp.StatusCode
+		}
+		requests.Add(1, req.Method, route, strconv.Itoa(code))
+		durations.Observe(time.Since(start).Seconds(), req.Method, route)
+		return rsp
+	}
+}
+
+// clientMetricsFilter records metrics for requests sent by a client.
+func clientMetricsFilter(r libhttpmetrics.Registry) Filter {
+	requests := r.Counter("http_client_requests_total", "Requests sent by clients.", "method", "target", "code")
+	durations := r.Histogram("http_client_request_duration_seconds", "Time until responses' headers arrived.", nil,
+		"method", "target")
+	return func(req Request, svc Service) Response {
+		target := ""
+		if req.URL != nil {
+			target = req.URL.Host
+		}
+		start := time.Now()
+		rsp := svc(req)
+		code := "error"
+		if rsp.Response != nil {
+			code = strconv.Itoa(rsp.StatusCode)
+		}
+		requests.Add(1, req.Method, target, code)
+		durations.Observe(time.Since(start).Seconds(), req.Method, target)
+		return rsp
+	}
+}
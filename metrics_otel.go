@@ -0,0 +1,135 @@
+package libhttp
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/4thel00z/libhttp/libhttpmetrics"
+	"github.com/monzo/terrors"
+)
+
+// meterScope is the instrumentation scope which semantic convention metrics are recorded under.
+const meterScope = "github.com/4thel00z/libhttp"
+
+// WithServerMeterProvider records metrics about the requests the server handles, following OpenTelemetry's semantic
+// conventions for HTTP servers, in the Registry mp provides for libhttp's scope:
+//
+//  http.server.request.duration   histogram  http.request.method, url.scheme, http.route, http.response.status_code,
+//                                            error.type
+//  http.server.active_requests    gauge      http.request.method, url.scheme
+//
+// Attributes which don't apply to a request (http.route, for requests which weren't routed, or error.type, for
+// requests which succeeded) are recorded as empty strings. As with WithServerMetricsRegistry, services should use
+// ErrorFilter for errors to be recorded with the status they are sent with. To record the metrics through an
+// OpenTelemetry SDK, adapt its MeterProvider with the otelmetrics module's NewMeterProvider; to record them in the
+// same Registry as WithServerMetricsRegistry, pass libhttpmetrics.SingleRegistry.
+func WithServerMeterProvider(mp libhttpmetrics.MeterProvider) ServerOption {
+	return func(o *serverOptions) {
+		o.meters = mp
+	}
+}
+
+// WithClientMeterProvider records metrics about the requests the client sends, following OpenTelemetry's semantic
+// conventions for HTTP clients, in the Registry mp provides for libhttp's scope:
+//
+//  http.client.request.duration   histogram  http.request.method, server.address, server.port, url.scheme,
+//                                            http.response.status_code, error.type
+//  http.client.active_requests    gauge      http.request.method, server.address, server.port, url.scheme
+//
+// Each attempt is recorded separately, if the client retries requests, and durations are measured until the
+// responses' headers arrive. As for servers, otelmetrics.NewMeterProvider adapts an OpenTelemetry SDK's MeterProvider.
+func WithClientMeterProvider(mp libhttpmetrics.MeterProvider) ClientOption {
+	return func(o *clientOptions) {
+		o.meters = mp
+	}
+}
+
+// serverMeterFilter records semantic convention metrics for requests handled by a server in m.
+func serverMeterFilter(m libhttpmetrics.Registry) Filter {
+	durations := m.Histogram("http.server.request.duration", "Duration of HTTP server requests, in seconds.",
+		libhttpmetrics.DurationBuckets, "http.request.method", "url.scheme", "http.route", "http.response.status_code",
+		"error.type")
+	active := m.Gauge("http.server.active_requests", "Number of active HTTP server requests.",
+		"http.request.method", "url.scheme")
+	return func(req Request, svc Service) Response {
+		method, scheme := semconvMethod(req.Method), "http"
+		if req.TLS != nil {
+			scheme = "https"
+		}
+		active.Add(1, method, scheme)
+		defer active.Add(-1, method, scheme)
+		start := time.Now()
+		rsp := svc(req)
+		route := ""
+		if rsp.Request != nil {
+			route = routeOf(*rsp.Request)
+		}
+		status, errorType := "", semconvErrorType(rsp)
+		if rsp.Response != nil {
+			status = strconv.Itoa(rsp.StatusCode)
+			if rsp.StatusCode < 500 {
+				errorType = "" // for servers, 4xx responses aren't errors
+			}
+		}
+		durations.Observe(time.Since(start).Seconds(), method, scheme, route, status, errorType)
+		return rsp
+	}
+}
+
+// clientMeterFilter records semantic convention metrics for requests sent by a client in m.
+func clientMeterFilter(m libhttpmetrics.Registry) Filter {
+	durations := m.Histogram("http.client.request.duration", "Duration of HTTP client requests, in seconds.",
+		libhttpmetrics.DurationBuckets, "http.request.method", "server.address", "server.port", "url.scheme",
+		"http.response.status_code", "error.type")
+	active := m.Gauge("http.client.active_requests", "Number of active HTTP client requests.",
+		"http.request.method", "server.address", "server.port", "url.scheme")
+	return func(req Request, svc Service) Response {
+		method, address, port, scheme := semconvMethod(req.Method), "", "", ""
+		if req.URL != nil {
+			address, port, scheme = req.URL.Hostname(), req.URL.Port(), req.URL.Scheme
+			switch {
+			case port != "":
+			case scheme == "https":
+				port = "443"
+			case scheme == "http":
+				port = "80"
+			}
+		}
+		active.Add(1, method, address, port, scheme)
+		defer active.Add(-1, method, address, port, scheme)
+		start := time.Now()
+		rsp := svc(req)
+		status := ""
+		if rsp.Response != nil {
+			status = strconv.Itoa(rsp.StatusCode)
+		}
+		durations.Observe(time.Since(start).Seconds(), method, address, port, scheme, status, semconvErrorType(rsp))
+		return rsp
+	}
+}
+
+// semconvMethod returns the method as the semantic conventions record it: unknown methods are recorded as _OTHER, to
+// bound the metrics' cardinality.
+func semconvMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete,
+		http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "_OTHER"
+}
+
+// semconvErrorType returns the error.type of a response: the status code of responses with error statuses, the
+// terrors code of those which failed without a response, and empty otherwise.
+func semconvErrorType(rsp Response) string {
+	switch {
+	case rsp.Response != nil && rsp.StatusCode >= 400:
+		return strconv.Itoa(rsp.StatusCode)
+	case rsp.Response != nil:
+		return ""
+	case rsp.Error != nil:
+		return terrors.Wrap(rsp.Error, nil).(*terrors.Error).Code
+	}
+	return "_OTHER"
+}
@@ -0,0 +1,91 @@
+package libhttp
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/4thel00z/libhttp/libhttpmetrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type scopeRecorder struct {
+	libhttpmetrics.Registry
+	scopes []string
+}
+
+func (r *scopeRecorder) Meter(scope string) libhttpmetrics.Registry {
+	r.scopes = append(r.scopes, scope)
+	return r.Registry
+}
+
+func TestMeterProvider(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	reg := libhttpmetrics.NewPrometheus()
+	mp := &scopeRecorder{Registry: reg}
+
+	router := &Router{}
+	router.POST("/items/:id", func(req Request) Response {
+		rsp := req.Response(nil)
+		rsp.StatusCode = http.StatusCreated
+		return rsp
+	})
+	router.GET("/fail", func(req Request) Response {
+		rsp := req.Response(nil)
+		rsp.StatusCode = http.StatusBadGateway
+		return rsp
+	})
+	s, err := Listen(router.Serve().Filter(ErrorFilter), "localhost:0", WithServerMeterProvider(mp))
+	require.NoError(t, err)
+	defer s.Stop(ctx)
+	base := "http://localhost:" + portOf(t, s)
+	client := NewClient(WithClientMeterProvider(mp))
+
+	NewRequest(ctx, "POST", base+"/items/1", nil).SendVia(client).Response()
+	NewRequest(ctx, "GET", base+"/fail", nil).SendVia(client).Response()
+	NewRequest(ctx, "GET", base+"/nope", nil).SendVia(client).Response()
+	NewRequest(ctx, "GET", "http://localhost:1/", nil).SendVia(client).Response()
+	assert.Equal(t, []string{meterScope, meterScope}, mp.scopes)
+
+	var buf bytes.Buffer
+	_, err = reg.WriteTo(&buf)
+	require.NoError(t, err)
+	out := buf.String()
+	port := portOf(t, s)
+	for _, line := range []string{
+		`http_server_request_duration_count{http_request_method="POST",url_scheme="http",http_route="/items/:id",` +
+			`http_response_status_code="201",error_type=""} 1`,
+		`http_server_request_duration_count{http_request_method="GET",url_scheme="http",http_route="/fail",` +
+			`http_response_status_code="502",error_type="502"} 1`,
+		`http_server_request_duration_count{http_request_method="GET",url_scheme="http",http_route="",` +
+			`http_response_status_code="404",error_type=""} 1`,
+		`http_server_request_duration_bucket{http_request_method="POST",url_scheme="http",http_route="/items/:id",` +
+			`http_response_status_code="201",error_type="",le="7.5"} 1`,
+		`http_server_active_requests{http_request_method="POST",url_scheme="http"} 0`,
+		`http_client_request_duration_count{http_request_method="POST",server_address="localhost",` +
+			`server_port="` + port + `",url_scheme="http",http_response_status_code="201",error_type=""} 1`,
+		`http_client_request_duration_count{http_request_method="GET",server_address="localhost",` +
+			`server_port="` + port + `",url_scheme="http",http_response_status_code="404",error_type="404"} 1`,
+		`http_client_active_requests{http_request_method="GET",server_address="localhost",server_port="1",` +
+			`url_scheme="http"} 0`,
+	} {
+		assert.Contains(t, out, line+"\n")
+	}
+	assert.Contains(t, out, `server_port="1",url_scheme="http",http_response_status_code="",error_type="`)
+}
+
+func TestSemconvMethod(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, "GET", semconvMethod("GET"))
+	assert.Equal(t, "_OTHER", semconvMethod("PROPFIND"))
+}
+
+func portOf(t *testing.T, s *Server) string {
+	_, port, err := net.SplitHostPort(s.Listener().Addr().String())
+	require.NoError(t, err)
+	return port
+}
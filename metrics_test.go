@@ -0,0 +1,73 @@
+package libhttp
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/4thel00z/libhttp/libhttpmetrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsRegistry(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	reg := libhttpmetrics.NewPrometheus()
+
+	router := &Router{}
+	router.GET("/users/:id", func(req Request) Response {
+		if router.Params(req)["id"] == "0" {
+			rsp := req.Response(nil)
+			rsp.StatusCode = http.StatusServiceUnavailable
+			return rsp
+		}
+		return req.Response("ok")
+	})
+	s, err := Listen(router.Serve().Filter(ErrorFilter), "localhost:0", WithServerMetricsRegistry(reg))
+	require.NoError(t, err)
+	defer s.Stop(ctx)
+	base := "http://" + s.Listener().Addr().String()
+	target := s.Listener().Addr().String()
+
+	breaker := NewCircuitBreaker(BreakerConsecutiveFailures(2), BreakerMetrics(reg))
+	client := NewClient(
+		WithClientMetricsRegistry(reg),
+		WithClientFilters(
+			RetryFilter(RetryMaxAttempts(2), RetryBackoff(time.Millisecond, time.Millisecond), RetryMetrics(reg)),
+			breaker.Filter))
+
+	for _, path := range []string{"/users/1", "/users/2", "/nope"} {
+		NewRequest(ctx, "GET", base+path, nil).SendVia(client).Response()
+	}
+	rsp := NewRequest(ctx, "GET", base+"/users/0", nil).SendVia(client).Response()
+	assert.Equal(t, http.StatusServiceUnavailable, rsp.StatusCode)
+	// Rejections by the open circuit are retried (and rejected again)
+	rsp = NewRequest(ctx, "GET", base+"/users/0", nil).SendVia(client).Response()
+	require.Error(t, rsp.Error)
+	assert.Equal(t, BreakerOpen, breaker.State(target))
+
+	var buf bytes.Buffer
+	_, err = reg.WriteTo(&buf)
+	require.NoError(t, err)
+	out := buf.String()
+	for _, line := range []string{
+		`http_server_requests_total{method="GET",route="/users/:id",code="200"} 2`,
+		`http_server_requests_total{method="GET",route="/users/:id",code="503"} 2`,
+		`http_server_requests_total{method="GET",route="",code="404"} 1`,
+		`http_server_request_duration_seconds_count{method="GET",route="/users/:id"} 4`,
+		`http_server_requests_in_flight{method="GET"} 0`,
+		`http_client_requests_total{method="GET",target="` + target + `",code="200"} 2`,
+		`http_client_requests_total{method="GET",target="` + target + `",code="404"} 1`,
+		`http_client_requests_total{method="GET",target="` + target + `",code="503"} 2`,
+		`http_client_request_duration_seconds_count{method="GET",target="` + target + `"} 5`,
+		`http_client_retries_total{method="GET",target="` + target + `"} 2`,
+		`http_client_circuit_state{circuit="` + target + `"} 1`,
+		`http_client_circuit_transitions_total{circuit="` + target + `",state="open"} 1`,
+		`http_client_circuit_rejected_total{circuit="` + target + `"} 2`,
+	} {
+		assert.Contains(t, out, line+"\n")
+	}
+}
@@ -0,0 +1,257 @@
+package libhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// A MockService is a Service which responds to requests with canned responses, for testing code which sends requests
+// without a network. Tests register the requests they expect (by method, path, and optionally query parameters,
+// headers and body) with the responses to give, then check the expectations were met:
+//
+//  mock := libhttp.NewMockService()
+//  mock.On("GET", "/users/:id").Respond(http.StatusOK, user)
+//  mock.On("POST", "/users").WithBody(newUser).Respond(http.StatusCreated, user).Times(1)
+//
+//  client := libhttp.NewClient(libhttp.WithRoundTripper(mock.RoundTripper()))
+//  ... exercise the code under test ...
+//  require.NoError(t, mock.Verify())
+//
+// The mock can also be used directly as a Service, in place of a client (mock.Serve), in which case responses don't
+// pass through ErrorFilter. Requests which match no expectation are responded to with a 501 (Not Implemented) Error.
+type MockService struct {
+	m            sync.Mutex
+	expectations []*MockExpectation
+	unmatched    []string
+}
+
+// NewMockService returns a MockService with no expectations.
+func NewMockService() *MockService {
+	return &MockService{}
+}
+
+// A MockExpectation is a request which a MockService expects, and how to respond to it. Its methods configure it
+// and return it, so calls can be chained; they must not be called once the mock is in use.
+type MockExpectation struct {
+	method   string
+	path     *regexp.Regexp
+	desc     string
+	matchers []func(Request, []byte) bool
+	svc      Service
+	times    int // -1 if any number of calls is allowed
+	calls    int // guarded by the MockService's mutex
+}
+
+// On adds an expectation of requests with the method ("*" for any) and path. Paths are patterns in the same format as
+// a Router's, so they can include :name parameters and *residuals. When several expectations match a request, the
+// last registered is used, unless it has had all the calls allowed by Times.
+func (m *MockService) On(method, path string) *MockExpectation {
+	e := &MockExpectation{
+		method: strings.ToUpper(method),
+		path:   (&Router{}).compile(path),
+		desc:   method + " " + path,
+		times:  -1,
+		svc: func(req Request) Response {
+			return req.Response(nil)
+		}}
+	m.m.Lock()
+	defer m.m.Unlock()
+	m.expectations = append(m.expectations, e)
+	return e
+}
+
+// WithQuery requires the request to have the query parameter, with the value.
+func (e *MockExpectation) WithQuery(name, value string) *MockExpectation {
+	return e.WithFunc(func(req Request, _ []byte) bool {
+		return req.URL.Query().Get(name) == value
+	})
+}
+
+// WithHeader requires the request to have the header, with the value.
+func (e *MockExpectation) WithHeader(name, value string) *MockExpectation {
+	return e.WithFunc(func(req Request, _ []byte) bool {
+		return req.Header.Get(name) == value
+	})
+}
+
+// WithBody requires the request to have the body. A string or []byte must match the body exactly; other values are
+// compared with the body as JSON, so they match regardless of formatting and the order of object keys.
+func (e *MockExpectation) WithBody(body interface{}) *MockExpectation {
+	switch body := body.(type) {
+	case string:
+		return e.WithFunc(func(_ Request, b []byte) bool { return string(b) == body })
+	case []byte:
+		return e.WithFunc(func(_ Request, b []byte) bool { return bytes.Equal(b, body) })
+	}
+	want, err := normaliseJSON(body)
+	if err != nil {
+		panic(fmt.Sprintf("libhttp: mock body can't be marshalled as JSON: %v", err))
+	}
+	return e.WithFunc(func(_ Request, b []byte) bool {
+		var v interface{}
+		if json.Unmarshal(b, &v) != nil {
+			return false
+		}
+		got, err := normaliseJSON(v)
+		return err == nil && bytes.Equal(got, want)
+	})
+}
+
+// WithFunc requires the request to satisfy f, which is passed the request and its body.
+func (e *MockExpectation) WithFunc(f func(req Request, body []byte) bool) *MockExpectation {
+	e.matchers = append(e.matchers, f)
+	return e
+}
+
+// Respond sets the response to matching requests: the status, and a body which is encoded in the same way as by
+// Request.Response. If body is an error, the response is that error instead (and the status is ignored).
+func (e *MockExpectation) Respond(status int, body interface{}) *MockExpectation {
+	return e.RespondWith(func(req Request) Response {
+		if err, ok := body.(error); ok {
+			rsp := NewResponse(req)
+			rsp.Error = err
+			return rsp
+		}
+		rsp := req.Response(body)
+		rsp.StatusCode = status
+		return rsp
+	})
+}
+
+// RespondWith sets a Service which responds to matching requests, for responses which depend on the request or need
+// headers. By default, requests are responded to with an empty 200 (OK) response.
+func (e *MockExpectation) RespondWith(svc Service) *MockExpectation {
+	e.svc = svc
+	return e
+}
+
+// Times sets the number of requests which must match the expectation. Once it has matched that many, it doesn't
+// match any more, so an earlier expectation can match later requests (a failure, then a success, say). By default,
+// any number of requests can match, including none.
+func (e *MockExpectation) Times(n int) *MockExpectation {
+	e.times = n
+	return e
+}
+
+// Serve responds to the request from the matching expectation.
+func (m *MockService) Serve(req Request) Response {
+	var body []byte
+	if req.Body != nil {
+		b, err := req.BodyBytes(false)
+		if err != nil {
+			return Response{
+				Request: &req,
+				Error:   err}
+		}
+		body = b
+	}
+
+	m.m.Lock()
+	var match *MockExpectation
+	for i := len(m.expectations) - 1; i >= 0; i-- {
+		e := m.expectations[i]
+		if e.times >= 0 && e.calls >= e.times {
+			continue
+		}
+		if e.matches(req, body) {
+			match = e
+			break
+		}
+	}
+	if match == nil {
+		m.unmatched = append(m.unmatched, req.Method+" "+req.URL.String())
+		m.m.Unlock()
+		rsp := NewResponse(req)
+		rsp.Error = NewError(http.StatusNotImplemented, "No mock expectation matches %s %s", req.Method,
+			req.URL.Path).WithCode("mock_unmatched")
+		return rsp
+	}
+	match.calls++
+	m.m.Unlock()
+	return match.svc(req)
+}
+
+// matches returns whether the request, whose body has been read, meets the expectation.
+func (e *MockExpectation) matches(req Request, body []byte) bool {
+	if (e.method != "*" && e.method != req.Method) || req.URL == nil || !e.path.MatchString(req.URL.Path) {
+		return false
+	}
+	for _, f := range e.matchers {
+		if !f(req, body) {
+			return false
+		}
+	}
+	return true
+}
+
+// RoundTripper returns an http.RoundTripper which sends requests to the mock, for clients created with
+// WithRoundTripper. Responses pass through ErrorFilter, as they would from a libhttp server, so errors are received by
+// the client in the usual way.
+func (m *MockService) RoundTripper() http.RoundTripper {
+	return mockTransport{Service(m.Serve).Filter(ErrorFilter)}
+}
+
+type mockTransport struct {
+	svc Service
+}
+
+func (t mockTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	rsp := t.svc(Request{
+		Request: *r,
+		Context: r.Context()})
+	if rsp.Response == nil {
+		return nil, rsp.Error
+	}
+	rsp.Response.Request = r
+	return rsp.Response, nil
+}
+
+// Calls returns the number of requests which have matched each expectation, in the order they were registered.
+func (m *MockService) Calls() []int {
+	m.m.Lock()
+	defer m.m.Unlock()
+	calls := make([]int, len(m.expectations))
+	for i, e := range m.expectations {
+		calls[i] = e.calls
+	}
+	return calls
+}
+
+// Verify returns an error describing any requests which matched no expectation, and any expectations which matched
+// a different number of requests than they required.
+func (m *MockService) Verify() error {
+	m.m.Lock()
+	defer m.m.Unlock()
+	var problems []string
+	for _, r := range m.unmatched {
+		problems = append(problems, fmt.Sprintf("unexpected request %s", r))
+	}
+	for _, e := range m.expectations {
+		if e.times >= 0 && e.calls != e.times {
+			problems = append(problems, fmt.Sprintf("expected %d requests matching %s, got %d", e.times, e.desc,
+				e.calls))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("mock expectations not met: %s", strings.Join(problems, "; "))
+}
+
+// normaliseJSON marshals v in a canonical form: that of encoding/json, which sorts object keys.
+func normaliseJSON(v interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var generic interface{}
+	if err := json.Unmarshal(b, &generic); err != nil {
+		return nil, err
+	}
+	return json.Marshal(generic)
+}
@@ -0,0 +1,84 @@
+package libhttp
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMockService(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	mock := NewMockService()
+	mock.On("GET", "/users/:id").Respond(http.StatusOK, map[string]string{"name": "alice"})
+	mock.On("GET", "/users/2").Respond(http.StatusOK, map[string]string{"name": "bob"}).Times(1)
+	mock.On("POST", "/users").WithBody(map[string]interface{}{"name": "carol", "age": 30}).
+		Respond(http.StatusCreated, map[string]string{"id": "3"})
+	mock.On("GET", "/search").WithQuery("q", "x").WithHeader("X-Api-Key", "k").Respond(http.StatusOK, "found")
+	client := NewClient(WithRoundTripper(mock.RoundTripper()), WithClientFilters(ErrorFilter))
+
+	get := func(path string) map[string]string {
+		rsp := NewRequest(ctx, "GET", "http://api.example.com"+path, nil).SendVia(client).Response()
+		require.NoError(t, rsp.Error)
+		v := map[string]string{}
+		require.NoError(t, rsp.Decode(&v))
+		return v
+	}
+	assert.Equal(t, "bob", get("/users/2")["name"])
+	assert.Equal(t, "alice", get("/users/2")["name"]) // the more specific expectation is used up
+	assert.Equal(t, "alice", get("/users/1")["name"])
+
+	rsp := NewRequest(ctx, "POST", "http://api.example.com/users", map[string]interface{}{"age": 30, "name": "carol"}).
+		SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusCreated, rsp.StatusCode)
+
+	req := NewRequest(ctx, "GET", "http://api.example.com/search?q=x", nil)
+	req.Header.Set("X-Api-Key", "k")
+	rsp = req.SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+	var found string
+	require.NoError(t, rsp.Decode(&found))
+	assert.Equal(t, "found", found)
+
+	assert.Equal(t, []int{2, 1, 1, 1}, mock.Calls())
+	require.NoError(t, mock.Verify())
+
+	// Requests which don't match get errors, and fail verification
+	rsp = NewRequest(ctx, "GET", "http://api.example.com/search?q=y", nil).SendVia(client).Response()
+	require.Error(t, rsp.Error)
+	assert.Equal(t, http.StatusNotImplemented, rsp.StatusCode)
+	rsp = NewRequest(ctx, "POST", "http://api.example.com/users", map[string]string{"name": "dave"}).
+		SendVia(client).Response()
+	require.Error(t, rsp.Error)
+	err := mock.Verify()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unexpected request GET http://api.example.com/search?q=y")
+	assert.Contains(t, err.Error(), "unexpected request POST http://api.example.com/users")
+}
+
+func TestMockServiceTimes(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	mock := NewMockService()
+	mock.On("*", "/flaky").Respond(http.StatusOK, nil)
+	mock.On("*", "/flaky").Respond(0, terrors.InternalService("", "boom", nil)).Times(2)
+
+	svc := Service(mock.Serve)
+	for i := 0; i < 2; i++ {
+		rsp := NewRequest(ctx, "GET", "/flaky", nil).SendVia(svc).Response()
+		require.Error(t, rsp.Error)
+	}
+	rsp := NewRequest(ctx, "DELETE", "/flaky", nil).SendVia(svc).Response()
+	require.NoError(t, rsp.Error)
+	require.NoError(t, mock.Verify())
+
+	mock.On("GET", "/once").Times(1)
+	err := mock.Verify()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "expected 1 requests matching GET /once, got 0")
+}
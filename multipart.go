@@ -0,0 +1,238 @@
+package libhttp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/monzo/terrors"
+)
+
+// A MultipartOption configures how a multipart body is read.
+type MultipartOption func(*multipartOptions)
+
+type multipartOptions struct {
+	maxPartBytes  int64
+	maxTotalBytes int64
+}
+
+// MultipartMaxPartBytes limits the size of the content of each part. Reading a part beyond this fails with a bad
+// request error.
+func MultipartMaxPartBytes(n int64) MultipartOption {
+	return func(o *multipartOptions) {
+		o.maxPartBytes = n
+	}
+}
+
+// MultipartMaxTotalBytes limits the size of the whole body (including part headers and boundaries). Reading beyond
+// this fails with a bad request error.
+func MultipartMaxTotalBytes(n int64) MultipartOption {
+	return func(o *multipartOptions) {
+		o.maxTotalBytes = n
+	}
+}
+
+// A MultipartReader iterates over the parts of a multipart request body, streaming them rather than buffering the
+// body in memory.
+type MultipartReader struct {
+	r *multipart.Reader
+	o multipartOptions
+}
+
+// Multipart returns a reader over the parts of a multipart (e.g. multipart/form-data) request body:
+//
+//  parts, err := req.Multipart(libhttp.MultipartMaxPartBytes(10 << 20))
+//  for {
+//      part, err := parts.Next()
+//      if err == io.EOF {
+//          break
+//      } else if err != nil {
+//          return libhttp.Response{Error: err}
+//      }
+//      // read the part
+//  }
+//
+// Parts must be read in order; calling Next discards any unread content of the current part.
+func (r Request) Multipart(opts ...MultipartOption) (*MultipartReader, error) {
+	o := multipartOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	mt, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mt, "multipart/") {
+		return nil, terrors.BadRequest("not_multipart", "Request body is not multipart", nil)
+	}
+	if params["boundary"] == "" {
+		return nil, terrors.BadRequest("not_multipart", "Multipart request has no boundary", nil)
+	}
+	var body io.Reader = r.Body
+	if o.maxTotalBytes > 0 {
+		body = &limitedReader{
+			r: body,
+			n: o.maxTotalBytes,
+			err: terrors.BadRequest("body_too_large", fmt.Sprintf("Request body exceeds %d bytes", o.maxTotalBytes),
+				nil)}
+	}
+	return &MultipartReader{
+		r: multipart.NewReader(body, params["boundary"]),
+		o: o}, nil
+}
+
+// Next returns the next part of the body, or io.EOF when there are no more.
+func (m *MultipartReader) Next() (*Part, error) {
+	p, err := m.r.NextPart()
+	if err == io.EOF {
+		return nil, err
+	} else if err != nil {
+		var terr *terrors.Error
+		if errors.As(err, &terr) { // exceeded the body size limit
+			return nil, terr
+		}
+		return nil, terrors.BadRequest("invalid_multipart", fmt.Sprintf("Malformed multipart body: %v", err), nil)
+	}
+	var content io.Reader = p
+	if m.o.maxPartBytes > 0 {
+		content = &limitedReader{
+			r: p,
+			n: m.o.maxPartBytes,
+			err: terrors.BadRequest("part_too_large", fmt.Sprintf("Part %q exceeds %d bytes", p.FormName(),
+				m.o.maxPartBytes), map[string]string{
+				"part": p.FormName()})}
+	}
+	return &Part{
+		Part:    p,
+		content: bufio.NewReaderSize(content, 512)}, nil
+}
+
+// A Part is a single part of a multipart body. Its content is read with Read.
+type Part struct {
+	*multipart.Part
+	content *bufio.Reader
+}
+
+// Read reads the content of the part.
+func (p *Part) Read(b []byte) (int, error) {
+	return p.content.Read(b)
+}
+
+// ContentType returns the media type of the part's content. If the part doesn't declare one (or declares only the
+// generic application/octet-stream), the type is detected from the content in the manner of http.DetectContentType.
+func (p *Part) ContentType() string {
+	if ct := p.Header.Get("Content-Type"); ct != "" && mediaType(ct) != "application/octet-stream" {
+		return ct
+	}
+	head, _ := p.content.Peek(512)
+	return http.DetectContentType(head)
+}
+
+// Spool reads the rest of the part, holding up to maxMemory bytes in memory and writing larger content to a temporary
+// file. The returned SpooledPart must be closed to remove any temporary file.
+func (p *Part) Spool(maxMemory int64) (*SpooledPart, error) {
+	sp := &SpooledPart{
+		Name:        p.FormName(),
+		FileName:    p.FileName(),
+		ContentType: p.ContentType()}
+	buf := &bytes.Buffer{}
+	n, err := io.CopyN(buf, p, maxMemory+1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if n <= maxMemory {
+		sp.Size = n
+		sp.mem = bytes.NewReader(buf.Bytes())
+		return sp, nil
+	}
+
+	f, err := ioutil.TempFile("", "libhttp-multipart-")
+	if err != nil {
+		return nil, err
+	}
+	sp.file = f
+	if sp.Size, err = io.Copy(f, io.MultiReader(buf, p)); err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		sp.Close()
+		return nil, err
+	}
+	return sp, nil
+}
+
+// A SpooledPart holds the content of a multipart part which has been read in full, either in memory or in a temporary
+// file on disk. It is an io.Reader, io.Seeker and io.ReaderAt over the content.
+type SpooledPart struct {
+	Name        string // the form field name
+	FileName    string
+	ContentType string
+	Size        int64
+	mem         *bytes.Reader
+	file        *os.File
+}
+
+// OnDisk returns whether the content was too large to hold in memory, and was written to a temporary file.
+func (s *SpooledPart) OnDisk() bool {
+	return s.file != nil
+}
+
+func (s *SpooledPart) Read(b []byte) (int, error) {
+	if s.file != nil {
+		return s.file.Read(b)
+	}
+	return s.mem.Read(b)
+}
+
+func (s *SpooledPart) ReadAt(b []byte, off int64) (int, error) {
+	if s.file != nil {
+		return s.file.ReadAt(b, off)
+	}
+	return s.mem.ReadAt(b, off)
+}
+
+func (s *SpooledPart) Seek(offset int64, whence int) (int64, error) {
+	if s.file != nil {
+		return s.file.Seek(offset, whence)
+	}
+	return s.mem.Seek(offset, whence)
+}
+
+// Close releases the content, removing any temporary file.
+func (s *SpooledPart) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	if rerr := os.Remove(s.file.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// limitedReader reads from r until n bytes have been read, and then fails with err.
+type limitedReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (l *limitedReader) Read(b []byte) (int, error) {
+	if l.n < 0 {
+		return 0, l.err
+	}
+	if int64(len(b)) > l.n+1 {
+		b = b[:l.n+1]
+	}
+	n, err := l.r.Read(b)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n + int(l.n), l.err
+	}
+	return n, err
+}
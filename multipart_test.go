@@ -0,0 +1,105 @@
+package libhttp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"strings"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func multipartTestRequest(t *testing.T, parts map[string]string) Request {
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	for _, name := range []string{"field", "small", "big"} {
+		if content, ok := parts[name]; ok {
+			var pw io.Writer
+			var err error
+			if name == "field" {
+				pw, err = w.CreateFormField(name)
+			} else {
+				pw, err = w.CreateFormFile(name, name+".txt")
+			}
+			require.NoError(t, err)
+			_, err = pw.Write([]byte(content))
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, w.Close())
+	req := NewRequest(context.Background(), "POST", "/", body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	return req
+}
+
+func TestRequestMultipart(t *testing.T) {
+	t.Parallel()
+
+	big := strings.Repeat("x", 4096)
+	req := multipartTestRequest(t, map[string]string{
+		"field": "value",
+		"small": "<html><body>hi</body></html>",
+		"big":   big})
+	parts, err := req.Multipart(MultipartMaxPartBytes(8192))
+	require.NoError(t, err)
+
+	p, err := parts.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "field", p.FormName())
+	b, err := ioutil.ReadAll(p)
+	require.NoError(t, err)
+	assert.Equal(t, "value", string(b))
+
+	p, err = parts.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "text/html; charset=utf-8", p.ContentType()) // sniffed, since the declared type is octet-stream
+	sp, err := p.Spool(1024)
+	require.NoError(t, err)
+	assert.False(t, sp.OnDisk())
+	assert.Equal(t, "small.txt", sp.FileName)
+	require.NoError(t, sp.Close())
+
+	p, err = parts.Next()
+	require.NoError(t, err)
+	sp, err = p.Spool(1024)
+	require.NoError(t, err)
+	assert.True(t, sp.OnDisk())
+	assert.EqualValues(t, len(big), sp.Size)
+	b, err = ioutil.ReadAll(sp)
+	require.NoError(t, err)
+	assert.Equal(t, big, string(b))
+	require.NoError(t, sp.Close())
+
+	_, err = parts.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestRequestMultipartLimits(t *testing.T) {
+	t.Parallel()
+
+	req := multipartTestRequest(t, map[string]string{"big": strings.Repeat("x", 4096)})
+	parts, err := req.Multipart(MultipartMaxPartBytes(1024))
+	require.NoError(t, err)
+	p, err := parts.Next()
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(p)
+	require.Error(t, err)
+	assert.Equal(t, "bad_request.part_too_large", err.(*terrors.Error).Code)
+
+	req = multipartTestRequest(t, map[string]string{"big": strings.Repeat("x", 4096)})
+	parts, err = req.Multipart(MultipartMaxTotalBytes(1024))
+	require.NoError(t, err)
+	p, err = parts.Next()
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(p)
+	require.Error(t, err)
+	assert.Equal(t, "bad_request.body_too_large", err.(*terrors.Error).Code)
+
+	_, err = NewRequest(context.Background(), "POST", "/", nil).Multipart()
+	assert.Error(t, err)
+}
@@ -0,0 +1,150 @@
+package libhttp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/monzo/slog"
+	"github.com/monzo/terrors"
+)
+
+const ndjsonContentType = "application/x-ndjson"
+
+// StreamNDJSON streams the values received from ch into the body as newline-delimited JSON, one value per line, until
+// ch is closed. Each value is sent as soon as it is encoded, so large result sets needn't be held in memory:
+//
+//	rsp := req.Response(nil)
+//	rows := make(chan interface{})
+//	go func() {
+//	    defer close(rows)
+//	    for row := range query(req) {
+//	        select {
+//	        case rows <- row:
+//	        case <-req.Done():
+//	            return
+//	        }
+//	    }
+//	}()
+//	rsp.StreamNDJSON(rows)
+//	return rsp
+//
+// If a value can't be encoded, or the client goes away, the stream is ended and the remaining values in ch are
+// discarded; producers should stop when the request's context is done, as above.
+func (r *Response) StreamNDJSON(ch <-chan interface{}) {
+	if r.Response == nil {
+		r.Response = newHTTPResponse(Request{})
+	}
+	body := Streamer().(*streamer)
+	r.Body = body
+	r.ContentLength = -1
+	r.Header.Set("Content-Type", ndjsonContentType)
+
+	var ctx context.Context = context.Background()
+	if r.Request != nil {
+		ctx = r.Request
+	}
+	go func() {
+		defer func() {
+			for range ch { // don't block the producer
+			}
+		}()
+		w := bufio.NewWriter(body)
+		enc := json.NewEncoder(w) // Encode terminates each value with a newline
+		for v := range ch {
+			err := enc.Encode(v)
+			if err == nil {
+				err = w.Flush()
+			}
+			if err != nil {
+				if err != io.ErrClosedPipe {
+					slog.Warn(ctx, "Couldn't stream NDJSON value: %v", err)
+				}
+				body.pipeW.CloseWithError(err)
+				return
+			}
+		}
+		body.Close()
+	}()
+}
+
+// An NDJSONDecoder reads a stream of newline-delimited JSON values from a body incrementally.
+type NDJSONDecoder struct {
+	body     io.ReadCloser
+	dec      *json.Decoder
+	wrapErr  func(error) error
+	finished bool
+}
+
+// Decode de-serialises the next value in the stream into v. It returns io.EOF when there are no more values, at which
+// point the body has been closed.
+func (d *NDJSONDecoder) Decode(v interface{}) error {
+	if d.finished {
+		return io.EOF
+	}
+	err := d.dec.Decode(v)
+	if err == io.EOF {
+		d.Close()
+		return err
+	} else if err != nil {
+		d.Close()
+		return d.wrapErr(err)
+	}
+	return nil
+}
+
+// Close stops reading the stream and closes the body. It need only be called if the stream is abandoned before Decode
+// returns an error.
+func (d *NDJSONDecoder) Close() error {
+	if d.finished {
+		return nil
+	}
+	d.finished = true
+	return d.body.Close()
+}
+
+// NDJSONDecoder returns a decoder which reads the newline-delimited JSON values in the request body one at a time.
+// Malformed values are reported as bad requests.
+func (r Request) NDJSONDecoder() *NDJSONDecoder {
+	body := r.Body
+	if body == nil {
+		body = &bufCloser{}
+	}
+	return &NDJSONDecoder{
+		body:    body,
+		dec:     json.NewDecoder(body),
+		wrapErr: jsonDecodeError}
+}
+
+// NDJSONDecoder returns a decoder which reads the newline-delimited JSON values in the response body one at a time.
+// If the response is an error, it is returned by the first call to Decode.
+func (r *Response) NDJSONDecoder() *NDJSONDecoder {
+	var body io.ReadCloser = &bufCloser{}
+	if r.Response != nil && r.Body != nil {
+		body = r.Body
+	}
+	rspErr := r.Error
+	return &NDJSONDecoder{
+		body: body,
+		dec:  json.NewDecoder(&errReader{err: rspErr, r: body}),
+		wrapErr: func(err error) error {
+			if err == rspErr {
+				return err
+			}
+			return terrors.WrapWithCode(err, nil, terrors.ErrBadResponse)
+		}}
+}
+
+// errReader fails with err (if it is non-nil) instead of reading from r.
+type errReader struct {
+	err error
+	r   io.Reader
+}
+
+func (e *errReader) Read(b []byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+	return e.r.Read(b)
+}
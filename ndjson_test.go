@@ -0,0 +1,99 @@
+package libhttp
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNDJSON(t *testing.T) {
+	t.Parallel()
+
+	type row struct {
+		N int `json:"n"`
+	}
+	svc := Service(func(req Request) Response {
+		dec := req.NDJSONDecoder()
+		var in []row
+		for {
+			r := row{}
+			if err := dec.Decode(&r); err == io.EOF {
+				break
+			} else if err != nil {
+				return Response{Error: err}
+			}
+			in = append(in, r)
+		}
+
+		rows := make(chan interface{})
+		go func() {
+			defer close(rows)
+			for _, r := range in {
+				select {
+				case rows <- row{N: r.N * 2}:
+				case <-req.Done():
+					return
+				}
+			}
+		}()
+		rsp := req.Response(nil)
+		rsp.StreamNDJSON(rows)
+		return rsp
+	})
+	s, err := Listen(svc.Filter(ErrorFilter), "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+	client := HttpService(&http.Transport{}).Filter(ErrorFilter)
+
+	req := NewRequest(context.Background(), "POST", "http://"+s.Listener().Addr().String(), nil)
+	req.Write([]byte("{\"n\":1}\n{\"n\":2}\n\n{\"n\":3}\n"))
+	rsp := req.SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, "application/x-ndjson", rsp.Header.Get("Content-Type"))
+	dec := rsp.NDJSONDecoder()
+	var out []int
+	for {
+		r := row{}
+		if err := dec.Decode(&r); err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		out = append(out, r.N)
+	}
+	assert.Equal(t, []int{2, 4, 6}, out)
+
+	// A malformed line is a bad request
+	req = NewRequest(context.Background(), "POST", "http://"+s.Listener().Addr().String(), nil)
+	req.Write([]byte("{\"n\":1}\n{\"n\":\n"))
+	rsp = req.SendVia(client).Response()
+	require.Error(t, rsp.Error)
+	assert.True(t, terrors.Is(rsp.Error, terrors.ErrBadRequest), "%v", rsp.Error)
+
+	// A response error is returned by the decoder
+	rsp = Response{Error: terrors.NotFound("", "gone", nil)}
+	assert.Equal(t, rsp.Error, rsp.NDJSONDecoder().Decode(&row{}))
+}
+
+func TestNDJSONUnencodable(t *testing.T) {
+	t.Parallel()
+
+	rows := make(chan interface{}, 3)
+	rows <- 1
+	rows <- func() {}
+	rows <- 2
+	close(rows)
+	rsp := NewResponse(Request{})
+	rsp.StreamNDJSON(rows)
+	b, err := rsp.BodyBytes(true)
+	assert.Error(t, err)
+	assert.Equal(t, "1\n", string(b))
+
+	dec := NewRequest(context.Background(), "POST", "/", strings.NewReader("")).NDJSONDecoder()
+	assert.Equal(t, io.EOF, dec.Decode(&struct{}{}))
+}
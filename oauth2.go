@@ -0,0 +1,319 @@
+package libhttp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/monzo/slog"
+	"github.com/monzo/terrors"
+)
+
+// An OAuth2Token is an access token, which authorises requests, obtained from an OAuth 2.0 authorisation server.
+type OAuth2Token struct {
+	AccessToken  string
+	TokenType    string    // "Bearer" if empty
+	RefreshToken string    // if the server issued one
+	Expiry       time.Time // zero if the token doesn't expire
+}
+
+// expiresWithin returns whether the token expires within d.
+func (t *OAuth2Token) expiresWithin(d time.Duration) bool {
+	return !t.Expiry.IsZero() && time.Now().Add(d).After(t.Expiry)
+}
+
+// A TokenSource obtains OAuth 2.0 access tokens. Each call should return a fresh token: caching is left to the
+// OAuth2Filter.
+type TokenSource interface {
+	Token(ctx context.Context) (*OAuth2Token, error)
+}
+
+// TokenSourceFunc adapts a function to a TokenSource.
+type TokenSourceFunc func(ctx context.Context) (*OAuth2Token, error)
+
+// Token calls f.
+func (f TokenSourceFunc) Token(ctx context.Context) (*OAuth2Token, error) {
+	return f(ctx)
+}
+
+// A TokenSourceOption configures a TokenSource which fetches tokens from a token endpoint.
+type TokenSourceOption func(*tokenSourceOptions)
+
+type tokenSourceOptions struct {
+	scopes []string
+	client Service
+}
+
+// TokenScopes sets the scopes requested for tokens. For refresh tokens, they may only narrow the scopes originally
+// granted; by default the same scopes are requested.
+func TokenScopes(scopes ...string) TokenSourceOption {
+	return func(o *tokenSourceOptions) {
+		o.scopes = scopes
+	}
+}
+
+// TokenClient sets the client which sends requests to the token endpoint (one with its own TLS configuration, say, or
+// with client filters applied). The default is the package's Client. It shouldn't be a client with the OAuth2Filter
+// which uses the TokenSource.
+func TokenClient(svc Service) TokenSourceOption {
+	return func(o *tokenSourceOptions) {
+		o.client = svc
+	}
+}
+
+func newTokenSourceOptions(opts []TokenSourceOption) tokenSourceOptions {
+	o := tokenSourceOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// ClientCredentialsTokenSource returns a TokenSource which obtains tokens from the authorisation server's token
+// endpoint with the client credentials grant (RFC 6749 section 4.4), for services acting on their own behalf. The
+// client authenticates with HTTP Basic authentication.
+func ClientCredentialsTokenSource(tokenURL, clientID, clientSecret string, opts ...TokenSourceOption) TokenSource {
+	o := newTokenSourceOptions(opts)
+	return TokenSourceFunc(func(ctx context.Context) (*OAuth2Token, error) {
+		return fetchOAuth2Token(ctx, o, tokenURL, clientID, clientSecret, url.Values{
+			"grant_type": {"client_credentials"}})
+	})
+}
+
+// refreshTokenSource exchanges a refresh token for access tokens, keeping the latest refresh token if the server
+// rotates it.
+type refreshTokenSource struct {
+	tokenURL, clientID, clientSecret string
+	opts                             tokenSourceOptions
+	m                                sync.Mutex
+	refreshToken                     string
+}
+
+// RefreshTokenSource returns a TokenSource which obtains tokens from the authorisation server's token endpoint by
+// presenting a refresh token (RFC 6749 section 6). If the server issues a new refresh token along with an access
+// token, it is used from then on.
+func RefreshTokenSource(tokenURL, clientID, clientSecret, refreshToken string, opts ...TokenSourceOption) TokenSource {
+	return &refreshTokenSource{
+		tokenURL:     tokenURL,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		opts:         newTokenSourceOptions(opts),
+		refreshToken: refreshToken}
+}
+
+func (s *refreshTokenSource) Token(ctx context.Context) (*OAuth2Token, error) {
+	// Refreshes are serialised, as a server which rotates refresh tokens may revoke them all if one is reused
+	s.m.Lock()
+	defer s.m.Unlock()
+	tok, err := fetchOAuth2Token(ctx, s.opts, s.tokenURL, s.clientID, s.clientSecret, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {s.refreshToken}})
+	if err != nil {
+		return nil, err
+	}
+	if tok.RefreshToken != "" {
+		s.refreshToken = tok.RefreshToken
+	}
+	return tok, nil
+}
+
+// fetchOAuth2Token requests a token from the token endpoint.
+func fetchOAuth2Token(ctx context.Context, o tokenSourceOptions, tokenURL, clientID, clientSecret string,
+	vs url.Values) (*OAuth2Token, error) {
+	if len(o.scopes) > 0 {
+		vs.Set("scope", strings.Join(o.scopes, " "))
+	}
+	req := NewRequest(ctx, "POST", tokenURL, nil)
+	req.EncodeForm(vs)
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+	var rsp Response
+	if o.client != nil {
+		rsp = req.SendVia(o.client).Response()
+	} else {
+		rsp = req.Send().Response()
+	}
+	body := struct {
+		AccessToken      string `json:"access_token"`
+		TokenType        string `json:"token_type"`
+		RefreshToken     string `json:"refresh_token"`
+		ExpiresIn        int64  `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}{}
+	if rsp.Error != nil {
+		return nil, terrors.Augment(rsp.Error, "Failed to fetch OAuth2 token", nil)
+	}
+	if err := rsp.Decode(&body); err != nil && rsp.StatusCode < 400 {
+		return nil, terrors.Augment(err, "Failed to decode OAuth2 token", nil)
+	}
+	if rsp.StatusCode >= 400 || body.Error != "" || body.AccessToken == "" {
+		return nil, terrors.BadResponse("oauth2_token", fmt.Sprintf("Token endpoint responded with %d: %s %s",
+			rsp.StatusCode, body.Error, body.ErrorDescription), map[string]string{
+			"error": body.Error})
+	}
+	tok := &OAuth2Token{
+		AccessToken:  body.AccessToken,
+		TokenType:    body.TokenType,
+		RefreshToken: body.RefreshToken}
+	if body.ExpiresIn > 0 {
+		tok.Expiry = time.Now().Add(time.Duration(body.ExpiresIn) * time.Second)
+	}
+	return tok, nil
+}
+
+// An OAuth2Option configures an OAuth2Filter.
+type OAuth2Option func(*oauth2Options)
+
+type oauth2Options struct {
+	refreshBefore time.Duration
+	maxBodyBytes  int64
+}
+
+// OAuth2RefreshBefore sets how long before a token expires a new one is fetched, in the background while the current
+// token is still used. The default is a minute.
+func OAuth2RefreshBefore(d time.Duration) OAuth2Option {
+	return func(o *oauth2Options) {
+		o.refreshBefore = d
+	}
+}
+
+// tokenCache holds the current token of an OAuth2Filter.
+type tokenCache struct {
+	src           TokenSource
+	refreshBefore time.Duration
+	m             sync.Mutex
+	token         *OAuth2Token
+	fetching      chan struct{} // closed when a fetch completes; nil if none is in progress
+	err           error         // of the last fetch
+}
+
+// get returns a current token: the cached one if it hasn't expired, or else a new one. If the cached token expires
+// soon, a new one is fetched in the background.
+func (c *tokenCache) get(ctx context.Context) (*OAuth2Token, error) {
+	c.m.Lock()
+	if tok := c.token; tok != nil && !tok.expiresWithin(0) {
+		if tok.expiresWithin(c.refreshBefore) {
+			c.fetch()
+		}
+		c.m.Unlock()
+		return tok, nil
+	}
+	fetching := c.fetch()
+	c.m.Unlock()
+	select {
+	case <-fetching:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.token == nil || c.token.expiresWithin(0) {
+		return nil, c.err
+	}
+	return c.token, nil
+}
+
+// fetch starts fetching a new token, if a fetch isn't already in progress, returning a channel which is closed when
+// it completes. The fetch isn't bound to any one request's context, as others may be waiting for it. The cache must
+// be locked.
+func (c *tokenCache) fetch() <-chan struct{} {
+	if c.fetching != nil {
+		return c.fetching
+	}
+	fetching := make(chan struct{})
+	c.fetching = fetching
+	go func() {
+		defer close(fetching)
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		tok, err := c.src.Token(ctx)
+		if err != nil {
+			slog.Warn(ctx, "Couldn't fetch OAuth2 token: %v", err)
+		}
+		c.m.Lock()
+		defer c.m.Unlock()
+		c.fetching = nil
+		c.err = err
+		if err == nil {
+			c.token = tok
+		}
+	}()
+	return fetching
+}
+
+// invalidate discards the token, if it is still the cached one.
+func (c *tokenCache) invalidate(tok *OAuth2Token) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.token == tok {
+		c.token = nil
+	}
+}
+
+// OAuth2Filter returns a client Filter which authorises requests with access tokens from the TokenSource, adding an
+// Authorization header to each:
+//
+//  src := libhttp.ClientCredentialsTokenSource("https://auth.example.com/oauth2/token", id, secret,
+//      libhttp.TokenScopes("payments:write"))
+//  client := libhttp.NewClient(libhttp.WithClientFilters(libhttp.OAuth2Filter(src)))
+//
+// Tokens are cached until they expire, and replaced in the background shortly before then (see OAuth2RefreshBefore),
+// so requests rarely wait for one. Concurrent requests share a single fetch.
+//
+// Tokens may still be revoked or rotated before they expire. So if a request is rejected with 401 Unauthorized, a new
+// token is fetched, and if it differs from the one the request was sent with the request is sent again (once) with
+// it. Request bodies of up to 1MiB are buffered in memory to allow this; larger requests aren't sent again.
+func OAuth2Filter(src TokenSource, opts ...OAuth2Option) Filter {
+	o := oauth2Options{
+		refreshBefore: time.Minute,
+		maxBodyBytes:  1 << 20}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	cache := &tokenCache{
+		src:           src,
+		refreshBefore: o.refreshBefore}
+	authorise := func(req Request, tok *OAuth2Token) Request {
+		req.Header = req.Header.Clone()
+		tokenType := tok.TokenType
+		if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+			tokenType = "Bearer"
+		}
+		req.Header.Set("Authorization", tokenType+" "+tok.AccessToken)
+		return req
+	}
+	return func(req Request, svc Service) Response {
+		tok, err := cache.get(req)
+		if err != nil {
+			return Response{
+				Request: &req,
+				Error:   terrors.Wrap(terrors.Augment(err, "Failed to obtain OAuth2 token", nil), nil)}
+		}
+		body, replayable, err := replayableBody(&req, o.maxBodyBytes)
+		switch {
+		case err != nil:
+			return Response{
+				Request: &req,
+				Error:   terrors.Wrap(err, nil)}
+		case !replayable:
+			return svc(authorise(req, tok)) // can't be sent again
+		}
+		rsp := svc(authorise(withReplayedBody(req, body), tok))
+		if rsp.Response == nil || rsp.StatusCode != http.StatusUnauthorized {
+			return rsp
+		}
+
+		cache.invalidate(tok)
+		fresh, err := cache.get(req)
+		if err != nil || fresh.AccessToken == tok.AccessToken {
+			return rsp
+		}
+		discardResponse(rsp)
+		return svc(authorise(withReplayedBody(req, body), fresh))
+	}
+}
@@ -0,0 +1,184 @@
+package libhttp
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testAuthServer is an OAuth2 token endpoint, which issues numbered tokens, and an API accepting only the latest.
+type testAuthServer struct {
+	m         sync.Mutex
+	issued    int
+	expiresIn int
+	refresh   string
+	grants    []string
+}
+
+func (a *testAuthServer) token(req Request) Response {
+	id, secret, ok := req.BasicAuth()
+	vs, err := req.FormValues()
+	if !ok || id != "client" || secret != "s3cret" || err != nil {
+		rsp := req.Response(map[string]string{"error": "invalid_client"})
+		rsp.StatusCode = http.StatusUnauthorized
+		return rsp
+	}
+	a.m.Lock()
+	defer a.m.Unlock()
+	a.grants = append(a.grants, vs.Get("grant_type")+" "+vs.Get("scope")+vs.Get("refresh_token"))
+	if vs.Get("grant_type") == "refresh_token" && vs.Get("refresh_token") != a.refresh {
+		rsp := req.Response(map[string]string{"error": "invalid_grant"})
+		rsp.StatusCode = http.StatusBadRequest
+		return rsp
+	}
+	a.issued++
+	a.refresh = fmt.Sprintf("refresh-%d", a.issued)
+	return req.Response(map[string]interface{}{
+		"access_token":  fmt.Sprintf("token-%d", a.issued),
+		"token_type":    "bearer",
+		"expires_in":    a.expiresIn,
+		"refresh_token": a.refresh})
+}
+
+func (a *testAuthServer) api(req Request) Response {
+	a.m.Lock()
+	current := fmt.Sprintf("Bearer token-%d", a.issued)
+	a.m.Unlock()
+	if req.Header.Get("Authorization") != current {
+		rsp := req.Response(nil)
+		rsp.StatusCode = http.StatusUnauthorized
+		return rsp
+	}
+	b, _ := req.BodyBytes(true)
+	return req.Response(req.Header.Get("Authorization") + " " + string(b))
+}
+
+func TestOAuth2Filter(t *testing.T) {
+	t.Parallel()
+	a := &testAuthServer{
+		expiresIn: 3600}
+	router := Router{}
+	router.POST("/token", a.token)
+	router.Register("*", "/api", a.api)
+	s, err := Listen(router.Serve(), "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+	base := "http://" + s.Listener().Addr().String()
+
+	// Token requests are sent with the client they're given
+	var tokenRequests int32
+	tokenClient := NewClient(WithClientFilters(func(req Request, svc Service) Response {
+		atomic.AddInt32(&tokenRequests, 1)
+		return svc(req)
+	}))
+	client := NewClient(WithClientFilters(OAuth2Filter(
+		ClientCredentialsTokenSource(base+"/token", "client", "s3cret", TokenScopes("read", "write"),
+			TokenClient(tokenClient)))))
+	call := func(body string) (string, int) {
+		rsp := NewRequest(context.Background(), "POST", base+"/api", body).SendVia(client).Response()
+		require.NoError(t, rsp.Error)
+		if rsp.StatusCode != http.StatusOK {
+			return "", rsp.StatusCode
+		}
+		var s string
+		require.NoError(t, rsp.Decode(&s))
+		return s, rsp.StatusCode
+	}
+
+	// Concurrent requests share a token
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, _ := call("hi")
+			assert.Equal(t, "Bearer token-1 \"hi\"\n", got)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, []string{"client_credentials read write"}, a.grants)
+
+	// When the token is rotated server-side, the request is retried with a new one
+	a.m.Lock()
+	a.issued++
+	a.m.Unlock()
+	got, _ := call("again")
+	assert.Equal(t, "Bearer token-3 \"again\"\n", got)
+	assert.Equal(t, int32(len(a.grants)), atomic.LoadInt32(&tokenRequests))
+
+	// Bad credentials
+	client = NewClient(WithClientFilters(OAuth2Filter(
+		ClientCredentialsTokenSource(base+"/token", "client", "wrong"))))
+	rsp := NewRequest(context.Background(), "GET", base+"/api", nil).SendVia(client).Response()
+	assert.Error(t, rsp.Error)
+}
+
+func TestOAuth2FilterLargeBody(t *testing.T) {
+	t.Parallel()
+	var issued int32
+	src := TokenSourceFunc(func(ctx context.Context) (*OAuth2Token, error) {
+		return &OAuth2Token{AccessToken: fmt.Sprintf("token-%d", atomic.AddInt32(&issued, 1))}, nil
+	})
+	var calls int32
+	svc := Service(func(req Request) Response {
+		atomic.AddInt32(&calls, 1)
+		b, err := ioutil.ReadAll(req.Body)
+		require.NoError(t, err)
+		rsp := NewResponse(req)
+		rsp.StatusCode = http.StatusUnauthorized
+		rsp.Header.Set("X-Length", fmt.Sprintf("%d %d", len(b), req.ContentLength))
+		return rsp
+	}).Filter(OAuth2Filter(src))
+
+	// Bodies too large to be buffered are sent as they are, and not sent again
+	body := strings.Repeat("x", 2<<20)
+	req := NewRequest(context.Background(), "POST", "/", nil)
+	req.Body = ioutil.NopCloser(strings.NewReader(body))
+	req.ContentLength = int64(len(body))
+	rsp := svc(req)
+	assert.Equal(t, http.StatusUnauthorized, rsp.StatusCode)
+	assert.Equal(t, fmt.Sprintf("%d %d", len(body), len(body)), rsp.Header.Get("X-Length"))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestOAuth2FilterRefresh(t *testing.T) {
+	t.Parallel()
+	a := &testAuthServer{
+		expiresIn: 1,
+		refresh:   "refresh-0"}
+	router := Router{}
+	router.POST("/token", a.token)
+	router.Register("*", "/api", a.api)
+	s, err := Listen(router.Serve(), "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+	base := "http://" + s.Listener().Addr().String()
+
+	// Tokens expire after a second, and are refreshed half a second before, in the background; the rotated refresh
+	// tokens are used each time
+	client := NewClient(WithClientFilters(OAuth2Filter(
+		RefreshTokenSource(base+"/token", "client", "s3cret", "refresh-0"),
+		OAuth2RefreshBefore(500*time.Millisecond))))
+	for i := 0; i < 3; i++ {
+		rsp := NewRequest(context.Background(), "GET", base+"/api", nil).SendVia(client).Response()
+		require.NoError(t, rsp.Error)
+		assert.Equal(t, http.StatusOK, rsp.StatusCode)
+		rsp.Body.Close()
+		time.Sleep(600 * time.Millisecond)
+	}
+	a.m.Lock()
+	defer a.m.Unlock()
+	assert.True(t, a.issued >= 2)
+	for i, g := range a.grants {
+		assert.Equal(t, fmt.Sprintf("refresh_token refresh-%d", i), g)
+	}
+}
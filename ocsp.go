@@ -0,0 +1,422 @@
+package libhttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/monzo/slog"
+)
+
+const (
+	// ocspMinRefresh and ocspMaxRefresh bound how often a stapled OCSP response is refreshed
+	ocspMinRefresh = time.Minute
+	ocspMaxRefresh = 12 * time.Hour
+	// ocspRetryInterval is how long to wait before trying again after a failed fetch
+	ocspRetryInterval = 5 * time.Minute
+	// ocspIdleTimeout is how long a certificate can go unserved before its responses stop being refreshed
+	ocspIdleTimeout = 24 * time.Hour
+)
+
+var (
+	oidSHA1          = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidOCSPBasicResp = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+
+	// ocspSignatureAlgorithms maps the OIDs of the signature algorithms responders use to crypto/x509's
+	ocspSignatureAlgorithms = map[string]x509.SignatureAlgorithm{
+		"1.2.840.113549.1.1.5":  x509.SHA1WithRSA,
+		"1.2.840.113549.1.1.11": x509.SHA256WithRSA,
+		"1.2.840.113549.1.1.12": x509.SHA384WithRSA,
+		"1.2.840.113549.1.1.13": x509.SHA512WithRSA,
+		"1.2.840.10045.4.1":     x509.ECDSAWithSHA1,
+		"1.2.840.10045.4.3.2":   x509.ECDSAWithSHA256,
+		"1.2.840.10045.4.3.3":   x509.ECDSAWithSHA384,
+		"1.2.840.10045.4.3.4":   x509.ECDSAWithSHA512,
+		"1.3.101.112":           x509.PureEd25519}
+)
+
+// These types are a minimal rendering of the ASN.1 structures in RFC 6960; only what is needed to request a response,
+// verify its signature and decide when it needs refreshing is modelled.
+
+type ocspCertID struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	IssuerKeyHash []byte
+	SerialNumber  *big.Int
+}
+
+type ocspRequest struct {
+	TBSRequest struct {
+		Version     int `asn1:"explicit,tag:0,default:0,optional"`
+		RequestList []struct {
+			Cert ocspCertID
+		}
+	}
+}
+
+type ocspResponse struct {
+	Status   asn1.Enumerated
+	Response struct {
+		ResponseType asn1.ObjectIdentifier
+		Response     []byte
+	} `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspBasicResponse struct {
+	TBSResponseData struct {
+		Raw            asn1.RawContent
+		Version        int `asn1:"optional,default:0,explicit,tag:0"`
+		RawResponderID asn1.RawValue
+		ProducedAt     time.Time `asn1:"generalized"`
+		Responses      []ocspSingleResponse
+	}
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspSingleResponse struct {
+	CertID  ocspCertID
+	Good    asn1.Flag `asn1:"tag:0,optional"`
+	Revoked struct {
+		RevocationTime time.Time       `asn1:"generalized"`
+		Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+	} `asn1:"tag:1,optional"`
+	Unknown          asn1.Flag        `asn1:"tag:2,optional"`
+	ThisUpdate       time.Time        `asn1:"generalized"`
+	NextUpdate       time.Time        `asn1:"generalized,explicit,tag:0,optional"`
+	SingleExtensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+// ocspStaplers staple OCSP responses to the certificates a server presents, starting a stapler for each certificate
+// the first time it is used (or when the server starts, for those known up front).
+type ocspStaplers struct {
+	m       sync.Mutex
+	byLeaf  map[string]*ocspStapler // keyed by the leaf's DER
+	stopped bool                    // guarded by m
+}
+
+func newOCSPStaplers() *ocspStaplers {
+	return &ocspStaplers{
+		byLeaf: make(map[string]*ocspStapler)}
+}
+
+// staple returns the certificate with its most recently fetched OCSP response stapled to it, if it has one.
+func (r *ocspStaplers) staple(cert *tls.Certificate) *tls.Certificate {
+	if cert == nil || len(cert.Certificate) == 0 {
+		return cert
+	}
+	key := string(cert.Certificate[0])
+	r.m.Lock()
+	stapler, ok := r.byLeaf[key]
+	if !ok && !r.stopped {
+		// Certificates which aren't eligible aren't kept, so they don't accumulate as certificates come and go
+		if stapler = newOCSPStapler(*cert); stapler != nil {
+			r.byLeaf[key] = stapler
+			go stapler.run(func() {
+				r.m.Lock()
+				delete(r.byLeaf, key)
+				r.m.Unlock()
+			})
+		}
+	}
+	r.m.Unlock()
+	if stapler == nil {
+		return cert
+	}
+	return stapler.Certificate()
+}
+
+// getCertificate wraps a tls.Config's certificate selection (its GetCertificate func, falling back to its
+// Certificates) to staple OCSP responses to the selected certificates.
+func (r *ocspStaplers) getCertificate(cfg *tls.Config) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	getCertificate, certs := cfg.GetCertificate, cfg.Certificates
+	for i := range certs {
+		r.staple(&certs[i]) // start fetching before the first handshake
+	}
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if getCertificate != nil {
+			if c, err := getCertificate(hello); c != nil || err != nil {
+				return r.staple(c), err
+			}
+		}
+		if len(certs) == 0 {
+			return nil, nil // let crypto/tls report the lack of certificates
+		}
+		for i := range certs {
+			if len(certs) == 1 || hello.SupportsCertificate(&certs[i]) == nil {
+				return r.staple(&certs[i]), nil
+			}
+		}
+		return r.staple(&certs[0]), nil
+	}
+}
+
+// shutdown stops all the staplers. It is suitable to be used as a Server shutdown func.
+func (r *ocspStaplers) shutdown(ctx context.Context) {
+	r.m.Lock()
+	r.stopped = true
+	staplers := make([]*ocspStapler, 0, len(r.byLeaf))
+	for _, s := range r.byLeaf {
+		staplers = append(staplers, s)
+	}
+	r.m.Unlock()
+	for _, s := range staplers {
+		s.shutdown(ctx)
+	}
+}
+
+// ocspStapler fetches OCSP responses for a certificate from its issuer's responder and keeps them fresh in the
+// background, so they can be stapled to TLS handshakes without clients making their own revocation checks.
+type ocspStapler struct {
+	leaf, issuer *x509.Certificate
+	certM        sync.RWMutex
+	cert         tls.Certificate // guarded by certM
+	lastUsed     int64           // atomic; unix nanoseconds
+	stop         chan struct{}
+	stopped      chan struct{}
+	stopOnce     sync.Once
+}
+
+// newOCSPStapler returns a stapler for the passed certificate, or nil if the certificate is not eligible for stapling
+// (it names no OCSP responder, or its chain does not include the issuer).
+func newOCSPStapler(cert tls.Certificate) *ocspStapler {
+	if len(cert.Certificate) < 2 {
+		return nil
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil || len(leaf.OCSPServer) == 0 {
+		return nil
+	}
+	issuer, err := x509.ParseCertificate(cert.Certificate[1])
+	if err != nil {
+		return nil
+	}
+	cert.OCSPStaple = nil // only ever staple responses which have been checked
+	return &ocspStapler{
+		leaf:     leaf,
+		issuer:   issuer,
+		cert:     cert,
+		lastUsed: time.Now().UnixNano(),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{})}
+}
+
+// Certificate returns the certificate with the most recently fetched OCSP response stapled to it.
+func (s *ocspStapler) Certificate() *tls.Certificate {
+	atomic.StoreInt64(&s.lastUsed, time.Now().UnixNano())
+	s.certM.RLock()
+	defer s.certM.RUnlock()
+	cert := s.cert
+	return &cert
+}
+
+// run fetches responses until the stapler is stopped, or until its certificate hasn't been served for a while (it
+// may have been removed from a CertificateStore), in which case it calls idle and returns.
+func (s *ocspStapler) run(idle func()) {
+	defer close(s.stopped)
+	for {
+		wait := ocspRetryInterval
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		staple, next, err := s.fetch(ctx)
+		cancel()
+		if err != nil {
+			slog.Warn(nil, "Couldn't refresh OCSP staple for %s: %v", s.leaf.Subject, err)
+		} else {
+			// A nil staple (the certificate is revoked, or unknown to the responder) replaces any good one
+			s.certM.Lock()
+			s.cert.OCSPStaple = staple
+			s.certM.Unlock()
+			wait = ocspRefreshInterval(time.Now(), next)
+		}
+
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(wait):
+		}
+		if time.Since(time.Unix(0, atomic.LoadInt64(&s.lastUsed))) > ocspIdleTimeout {
+			idle()
+			return
+		}
+	}
+}
+
+// shutdown stops background refreshing.
+func (s *ocspStapler) shutdown(ctx context.Context) {
+	s.stopOnce.Do(func() { close(s.stop) })
+	select {
+	case <-s.stopped:
+	case <-ctx.Done():
+	}
+}
+
+// fetch requests a fresh OCSP response from the certificate's responder, returning the raw response if it is suitable
+// for stapling (or nil if it isn't good news), and the time by which it should be refreshed.
+func (s *ocspStapler) fetch(ctx context.Context) ([]byte, time.Time, error) {
+	reqBody, err := s.request()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	req := NewRequest(ctx, "POST", s.leaf.OCSPServer[0], bytes.NewReader(reqBody))
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	req.Header.Set("Accept", "application/ocsp-response")
+	rsp := req.SendVia(BareClient).Response()
+	if rsp.Error != nil {
+		return nil, time.Time{}, rsp.Error
+	}
+	b, err := rsp.BodyBytes(true)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if rsp.StatusCode != http.StatusOK {
+		return nil, time.Time{}, fmt.Errorf("OCSP responder returned status %d", rsp.StatusCode)
+	}
+	next, good, err := s.parse(b, time.Now())
+	if err != nil || !good {
+		return nil, next, err
+	}
+	return b, next, nil
+}
+
+func (s *ocspStapler) certID() (ocspCertID, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(s.issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return ocspCertID{}, err
+	}
+	nameHash := sha1.Sum(s.issuer.RawSubject)
+	keyHash := sha1.Sum(spki.PublicKey.RightAlign())
+	return ocspCertID{
+		HashAlgorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  oidSHA1,
+			Parameters: asn1.NullRawValue},
+		NameHash:      nameHash[:],
+		IssuerKeyHash: keyHash[:],
+		SerialNumber:  s.leaf.SerialNumber}, nil
+}
+
+func (s *ocspStapler) request() ([]byte, error) {
+	id, err := s.certID()
+	if err != nil {
+		return nil, err
+	}
+	req := ocspRequest{}
+	req.TBSRequest.RequestList = []struct{ Cert ocspCertID }{{Cert: id}}
+	return asn1.Marshal(req)
+}
+
+// parse checks that the passed DER-encoded OCSP response is a current, successful one concerning our certificate,
+// signed by its issuer (or a responder the issuer delegated to). It returns the time at which it should be refreshed,
+// and whether it says the certificate is good: revoked and unknown certificates aren't stapled.
+func (s *ocspStapler) parse(b []byte, now time.Time) (time.Time, bool, error) {
+	rsp := ocspResponse{}
+	if _, err := asn1.Unmarshal(b, &rsp); err != nil {
+		return time.Time{}, false, err
+	}
+	if rsp.Status != 0 {
+		return time.Time{}, false, fmt.Errorf("OCSP responder returned response status %d", rsp.Status)
+	}
+	if !rsp.Response.ResponseType.Equal(oidOCSPBasicResp) {
+		return time.Time{}, false, fmt.Errorf("unsupported OCSP response type %v", rsp.Response.ResponseType)
+	}
+	basic := ocspBasicResponse{}
+	if _, err := asn1.Unmarshal(rsp.Response.Response, &basic); err != nil {
+		return time.Time{}, false, err
+	}
+	if err := s.verify(basic); err != nil {
+		return time.Time{}, false, err
+	}
+	id, err := s.certID()
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	for _, sr := range basic.TBSResponseData.Responses {
+		if sr.CertID.SerialNumber == nil || sr.CertID.SerialNumber.Cmp(s.leaf.SerialNumber) != 0 {
+			continue
+		}
+		if sr.CertID.HashAlgorithm.Algorithm.Equal(oidSHA1) && !bytes.Equal(sr.CertID.IssuerKeyHash, id.IssuerKeyHash) {
+			continue // the same serial from another issuer
+		}
+		next := sr.NextUpdate
+		if next.IsZero() {
+			next = sr.ThisUpdate.Add(ocspMaxRefresh * 2)
+		}
+		if !sr.NextUpdate.IsZero() && now.After(sr.NextUpdate) {
+			return time.Time{}, false, fmt.Errorf("OCSP response expired at %v", sr.NextUpdate)
+		}
+		// The midpoint of the validity window leaves plenty of room for retries before the staple goes stale
+		refresh := sr.ThisUpdate.Add(next.Sub(sr.ThisUpdate) / 2)
+		switch {
+		case !sr.Revoked.RevocationTime.IsZero():
+			slog.Critical(nil, "Certificate for %s has been revoked at %v; not stapling OCSP responses", s.leaf.Subject,
+				sr.Revoked.RevocationTime)
+			return refresh, false, nil
+		case bool(sr.Unknown):
+			slog.Error(nil, "OCSP responder doesn't know the certificate for %s; not stapling its responses",
+				s.leaf.Subject)
+			return refresh, false, nil
+		}
+		return refresh, true, nil
+	}
+	return time.Time{}, false, fmt.Errorf("OCSP response does not cover certificate serial %v", s.leaf.SerialNumber)
+}
+
+// verify checks the signature of a basic OCSP response, which must be made by the certificate's issuer, or by a
+// responder certificate included in the response which the issuer has authorised to sign OCSP responses.
+func (s *ocspStapler) verify(basic ocspBasicResponse) error {
+	algo, ok := ocspSignatureAlgorithms[basic.SignatureAlgorithm.Algorithm.String()]
+	if !ok {
+		return fmt.Errorf("unsupported OCSP signature algorithm %v", basic.SignatureAlgorithm.Algorithm)
+	}
+	signed, sig := basic.TBSResponseData.Raw, basic.Signature.RightAlign()
+	err := s.issuer.CheckSignature(algo, signed, sig)
+	if err == nil {
+		return nil
+	}
+	for _, raw := range basic.Certificates {
+		responder, perr := x509.ParseCertificate(raw.FullBytes)
+		if perr != nil || responder.CheckSignatureFrom(s.issuer) != nil || !hasOCSPSigning(responder) {
+			continue
+		}
+		if err = responder.CheckSignature(algo, signed, sig); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("OCSP response signature is invalid: %v", err)
+}
+
+func hasOCSPSigning(cert *x509.Certificate) bool {
+	for _, u := range cert.ExtKeyUsage {
+		if u == x509.ExtKeyUsageOCSPSigning {
+			return true
+		}
+	}
+	return false
+}
+
+// ocspRefreshInterval returns how long to wait from now until refreshing at the passed time, clamped to sensible
+// bounds.
+func ocspRefreshInterval(now, refreshAt time.Time) time.Duration {
+	d := refreshAt.Sub(now)
+	switch {
+	case d < ocspMinRefresh:
+		return ocspMinRefresh
+	case d > ocspMaxRefresh:
+		return ocspMaxRefresh
+	default:
+		return d
+	}
+}
@@ -0,0 +1,246 @@
+package libhttp
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ocspTestResponse builds a successful OCSP response covering the passed serial number, with the passed status (0 for
+// good, 1 for revoked and 2 for unknown), signed with key and including the passed certificates
+func ocspTestResponse(t *testing.T, key *rsa.PrivateKey, certs [][]byte, serial *big.Int, status int,
+	thisUpdate, nextUpdate time.Time) []byte {
+	sr := ocspSingleResponse{
+		CertID: ocspCertID{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue},
+			NameHash:      []byte{0},
+			IssuerKeyHash: []byte{0},
+			SerialNumber:  serial},
+		ThisUpdate: thisUpdate,
+		NextUpdate: nextUpdate}
+	switch status {
+	case 0:
+		sr.Good = true
+	case 1:
+		sr.Revoked.RevocationTime = thisUpdate
+	case 2:
+		sr.Unknown = true
+	}
+	basic := ocspBasicResponse{}
+	basic.TBSResponseData.RawResponderID = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, IsCompound: true,
+		Bytes: []byte{0x04, 0x01, 0x00}}
+	basic.TBSResponseData.ProducedAt = thisUpdate
+	basic.TBSResponseData.Responses = []ocspSingleResponse{sr}
+	tbs, err := asn1.Marshal(basic.TBSResponseData)
+	require.NoError(t, err)
+	basic.TBSResponseData.Raw = tbs
+	digest := sha256.Sum256(tbs)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	require.NoError(t, err)
+	basic.SignatureAlgorithm = pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}}
+	basic.Signature = asn1.BitString{Bytes: sig, BitLength: 8 * len(sig)}
+	for _, c := range certs {
+		basic.Certificates = append(basic.Certificates, asn1.RawValue{FullBytes: c})
+	}
+	basicDer, err := asn1.Marshal(basic)
+	require.NoError(t, err)
+
+	rsp := ocspResponse{}
+	rsp.Response.ResponseType = oidOCSPBasicResp
+	rsp.Response.Response = basicDer
+	der, err := asn1.Marshal(rsp)
+	require.NoError(t, err)
+	return der
+}
+
+// ocspTestCA is a CA which issues certificates pointing at an OCSP responder
+type ocspTestCA struct {
+	key  *rsa.PrivateKey
+	cert *x509.Certificate
+}
+
+func newOCSPTestCA(t *testing.T) ocspTestCA {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(100),
+		Subject:               pkix.Name{Organization: []string{"MomCorp CA"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return ocspTestCA{key, cert}
+}
+
+// issue returns a certificate chain for the passed template, signed by the CA
+func (ca ocspTestCA) issue(t *testing.T, tmpl *x509.Certificate) (tls.Certificate, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	tmpl.NotBefore, tmpl.NotAfter = time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	return tls.Certificate{
+		Certificate: [][]byte{der, ca.cert.Raw},
+		PrivateKey:  key}, key
+}
+
+func TestOCSPStapling(t *testing.T) {
+	t.Parallel()
+
+	leafSerial := big.NewInt(200)
+	now := time.Now().UTC().Truncate(time.Second)
+	var staple atomic.Value
+	var ocspRequested int32
+	responder, err := Listen(Service(func(req Request) Response {
+		if req.Header.Get("Content-Type") == "application/ocsp-request" {
+			atomic.StoreInt32(&ocspRequested, 1)
+		}
+		rsp := req.Response(bytes.NewReader(staple.Load().([]byte)))
+		rsp.Header.Set("Content-Type", "application/ocsp-response")
+		return rsp
+	}), "localhost:0")
+	require.NoError(t, err)
+	defer responder.Stop(context.Background())
+
+	// Build a CA and a leaf which points at our responder
+	ca := newOCSPTestCA(t)
+	staple.Store(ocspTestResponse(t, ca.key, nil, leafSerial, 0, now, now.Add(time.Hour)))
+	cert, leafKey := ca.issue(t, &x509.Certificate{
+		SerialNumber: leafSerial,
+		Subject:      pkix.Name{Organization: []string{"MomCorp"}},
+		DNSNames:     []string{"localhost"},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		OCSPServer:   []string{fmt.Sprintf("http://%s/", responder.Listener().Addr())}})
+
+	dir, err := ioutil.TempDir("", "libhttp-ocsp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	chain := append(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[1]})...)
+	require.NoError(t, ioutil.WriteFile(certFile, chain, 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(leafKey)}), 0600))
+
+	svc := Service(func(req Request) Response {
+		return req.Response(nil)
+	})
+	fileSrv, err := ListenTLS(svc, "localhost:0", certFile, keyFile, nil)
+	require.NoError(t, err)
+	defer fileSrv.Stop(context.Background())
+	// Certificates selected from a CertificateStore are stapled too
+	store := NewCertificateStore()
+	require.NoError(t, store.Add(cert))
+	storeSrv, err := ListenTLS(svc, "localhost:0", "", "", store.TLSConfig(nil))
+	require.NoError(t, err)
+	defer storeSrv.Stop(context.Background())
+
+	for _, srv := range []*Server{fileSrv, storeSrv} {
+		// The staple is fetched in the background, so it may take a few handshakes to appear
+		var got []byte
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			conn, err := tls.Dial("tcp", srv.Listener().Addr().String(), &tls.Config{
+				ServerName:         "localhost",
+				InsecureSkipVerify: true})
+			require.NoError(t, err)
+			got = conn.ConnectionState().OCSPResponse
+			conn.Close()
+			if got != nil {
+				break
+			}
+		}
+		assert.Equal(t, staple.Load(), got)
+	}
+	assert.EqualValues(t, 1, atomic.LoadInt32(&ocspRequested))
+}
+
+func TestOCSPStaplersIneligible(t *testing.T) {
+	t.Parallel()
+	ca := newOCSPTestCA(t)
+	cert, _ := ca.issue(t, &x509.Certificate{
+		SerialNumber: big.NewInt(201)}) // no OCSP responder
+	r := newOCSPStaplers()
+	assert.Equal(t, &cert, r.staple(&cert))
+	assert.Empty(t, r.byLeaf)
+}
+
+func TestOCSPParse(t *testing.T) {
+	t.Parallel()
+	ca := newOCSPTestCA(t)
+	serial := big.NewInt(200)
+	cert, _ := ca.issue(t, &x509.Certificate{
+		SerialNumber: serial,
+		OCSPServer:   []string{"http://ocsp.example.com/"}})
+	stapler := newOCSPStapler(cert)
+	require.NotNil(t, stapler)
+	delegate, _ := ca.issue(t, &x509.Certificate{
+		SerialNumber: big.NewInt(300),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning}})
+	undelegated, undelegatedKey := ca.issue(t, &x509.Certificate{
+		SerialNumber: big.NewInt(400)})
+	delegateKey := delegate.PrivateKey.(*rsa.PrivateKey)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	for _, c := range []struct {
+		name   string
+		der    []byte
+		good   bool
+		hasErr bool
+	}{
+		{"good", ocspTestResponse(t, ca.key, nil, serial, 0, now, now.Add(time.Hour)), true, false},
+		{"delegated", ocspTestResponse(t, delegateKey, delegate.Certificate[:1], serial, 0, now, now.Add(time.Hour)),
+			true, false},
+		{"revoked", ocspTestResponse(t, ca.key, nil, serial, 1, now, now.Add(time.Hour)), false, false},
+		{"unknown", ocspTestResponse(t, ca.key, nil, serial, 2, now, now.Add(time.Hour)), false, false},
+		{"undelegated", ocspTestResponse(t, undelegatedKey, undelegated.Certificate[:1], serial, 0, now,
+			now.Add(time.Hour)), false, true},
+		{"forged", ocspTestResponse(t, delegateKey, nil, serial, 0, now, now.Add(time.Hour)), false, true},
+		{"expired", ocspTestResponse(t, ca.key, nil, serial, 0, now.Add(-2*time.Hour), now.Add(-time.Hour)), false,
+			true},
+		{"other serial", ocspTestResponse(t, ca.key, nil, big.NewInt(201), 0, now, now.Add(time.Hour)), false, true}} {
+		next, good, err := stapler.parse(c.der, now)
+		assert.Equal(t, c.good, good, c.name)
+		assert.Equal(t, c.hasErr, err != nil, "%s: %v", c.name, err)
+		if !c.hasErr {
+			assert.Equal(t, now.Add(30*time.Minute), next, c.name)
+		}
+	}
+}
+
+var oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+
+func TestOCSPRefreshInterval(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	assert.Equal(t, ocspMinRefresh, ocspRefreshInterval(now, now.Add(-time.Hour)))
+	assert.Equal(t, ocspMaxRefresh, ocspRefreshInterval(now, now.Add(7*24*time.Hour)))
+	assert.Equal(t, time.Hour, ocspRefreshInterval(now, now.Add(time.Hour)))
+}
@@ -0,0 +1,170 @@
+package openapi
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/4thel00z/libhttp"
+)
+
+// A DocsOption configures the documentation served by Spec.Docs.
+type DocsOption func(*docsOptions)
+
+type docsOptions struct {
+	redoc     bool
+	title     string
+	assets    string
+	integrity map[string]string // by file name
+}
+
+// Redoc serves the documentation with Redoc (https://github.com/Redocly/redoc), rather than Swagger UI.
+func Redoc() DocsOption {
+	return func(o *docsOptions) {
+		o.redoc = true
+	}
+}
+
+// DocsTitle sets the title of the documentation page. It defaults to the title of the specification.
+func DocsTitle(title string) DocsOption {
+	return func(o *docsOptions) {
+		o.title = title
+	}
+}
+
+// DocsAssets sets the URL of the directory which the documentation page loads its scripts and styles from, so that they
+// can be self-hosted (behind a firewall, say). For Swagger UI, this is a copy of the swagger-ui-dist package; for
+// Redoc, its bundles directory. By default, they are loaded from public CDNs, at the exact versions DefaultSwaggerUI
+// and DefaultRedoc.
+func DocsAssets(url string) DocsOption {
+	return func(o *docsOptions) {
+		o.assets = strings.TrimSuffix(url, "/")
+	}
+}
+
+// DocsIntegrity sets the Subresource Integrity hash (such as "sha384-...") which browsers check the asset file
+// (swagger-ui-bundle.js or swagger-ui.css for Swagger UI, or redoc.standalone.js for Redoc) against before using it,
+// so that a compromised CDN or mirror can't run scripts of its own in the page:
+//
+//  spec.Docs(openapi.DocsIntegrity("swagger-ui-bundle.js", "sha384-..."),
+//      openapi.DocsIntegrity("swagger-ui.css", "sha384-..."))
+//
+// The hashes of the files being served can be found with:
+//
+//  curl -s <url> | openssl dgst -sha384 -binary | openssl base64 -A
+func DocsIntegrity(file, hash string) DocsOption {
+	return func(o *docsOptions) {
+		if o.integrity == nil {
+			o.integrity = map[string]string{}
+		}
+		o.integrity[file] = hash
+	}
+}
+
+// The versions of Swagger UI and Redoc loaded from CDNs, unless DocsAssets is used. They are exact versions, so that
+// the files are the same each time the page is loaded (and their hashes can be given to DocsIntegrity).
+const (
+	DefaultSwaggerUI = "5.17.14"
+	DefaultRedoc     = "2.1.5"
+)
+
+var docsTemplate = template.Must(template.New("docs").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+{{- if .Redoc}}
+</head>
+<body>
+<redoc spec-url="{{.SpecURL}}"></redoc>
+<script src="{{.Assets}}/redoc.standalone.js"{{template "integrity" index .Integrity "redoc.standalone.js"}}></script>
+{{- else}}
+<link rel="stylesheet" href="{{.Assets}}/swagger-ui.css"{{template "integrity" index .Integrity "swagger-ui.css"}}>
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="{{.Assets}}/swagger-ui-bundle.js"{{template "integrity" index .Integrity "swagger-ui-bundle.js"}}></script>
+<script>
+window.ui = SwaggerUIBundle({url: {{.SpecURL}}, dom_id: "#swagger-ui", deepLinking: true});
+</script>
+{{- end}}
+</body>
+</html>
+{{- define "integrity"}}{{if .}} integrity="{{.}}" crossorigin="anonymous"{{end}}{{end}}
+`))
+
+// Docs returns a Service which serves browsable documentation of the specification, along with the specification
+// itself as openapi.json, under the path it is mounted at:
+//
+//  router.GET("/docs/*", spec.Docs())
+//
+// serves Swagger UI at /docs/, pointed at /docs/openapi.json. Other paths under /docs/ are not found.
+func (s *Spec) Docs(opts ...DocsOption) libhttp.Service {
+	o := docsOptions{
+		title: s.doc.Info.Title}
+	if o.title == "" {
+		o.title = "API documentation"
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	switch {
+	case o.assets != "":
+	case o.redoc:
+		o.assets = "https://cdn.jsdelivr.net/npm/redoc@" + DefaultRedoc + "/bundles"
+	default:
+		o.assets = "https://unpkg.com/swagger-ui-dist@" + DefaultSwaggerUI
+	}
+
+	return func(req libhttp.Request) libhttp.Response {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			return libhttp.Response{Error: libhttp.NewError(http.StatusMethodNotAllowed,
+				"Method %s is not allowed", req.Method)}
+		}
+		// The page is served at the root of wherever the Service is mounted, so the specification is beside it
+		root := mountRoot(req)
+		specURL := strings.TrimSuffix(root, "/") + "/openapi.json"
+		rsp := libhttp.NewResponse(req)
+		switch req.URL.Path {
+		case specURL:
+			rsp.SetBodyReader(bytes.NewReader(s.raw), int64(len(s.raw)), "application/json")
+		case root:
+			buf := &bytes.Buffer{}
+			docsTemplate.Execute(buf, struct {
+				Title, Assets, SpecURL string
+				Redoc                  bool
+				Integrity              map[string]string
+			}{o.title, o.assets, specURL, o.redoc, o.integrity})
+			rsp.SetBodyReader(buf, int64(buf.Len()), "text/html; charset=utf-8")
+		default:
+			return libhttp.Response{Error: libhttp.NotFound("%s not found", req.URL.Path)}
+		}
+		return rsp
+	}
+}
+
+// mountRoot returns the path which the Service serving req is mounted at: the part of the request's path matched by
+// its route's pattern before a trailing residual component (so /docs/ for /docs/*), or / if it wasn't routed.
+func mountRoot(req libhttp.Request) string {
+	router := libhttp.RouterForRequest(req)
+	if router == nil {
+		return "/"
+	}
+	pattern := router.Pattern(req)
+	i := strings.LastIndex(pattern, "/*")
+	if i < 0 || strings.Contains(pattern[i+1:], "/") {
+		return req.URL.Path
+	}
+	// The root has as many components as the pattern before its residual (some of which may be :name parameters)
+	path, end := req.URL.Path, 0
+	for n := strings.Count(pattern[:i+1], "/"); n > 0; n-- {
+		j := strings.IndexByte(path[end:], '/')
+		if j < 0 {
+			return path
+		}
+		end += j + 1
+	}
+	return path[:end]
+}
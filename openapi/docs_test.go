@@ -0,0 +1,64 @@
+package openapi
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/4thel00z/libhttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDocs(t *testing.T) {
+	t.Parallel()
+	spec, err := Parse([]byte(petstore))
+	require.NoError(t, err)
+	get := func(svc libhttp.Service, path string) (libhttp.Response, string) {
+		router := libhttp.Router{}
+		router.GET("/docs/*", svc)
+		router.GET("/v/:version/docs/*", svc)
+		rsp := libhttp.NewRequest(context.Background(), "GET", path, nil).
+			SendVia(router.Serve().Filter(libhttp.ErrorFilter)).Response()
+		b, err := rsp.BodyBytes(true)
+		require.NoError(t, err)
+		return rsp, string(b)
+	}
+
+	rsp, body := get(spec.Docs(), "/docs/")
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Equal(t, "text/html; charset=utf-8", rsp.Header.Get("Content-Type"))
+	assert.Contains(t, body, "<title>Petstore</title>")
+	assert.Contains(t, body, `<script src="https://unpkg.com/swagger-ui-dist@5.17.14/swagger-ui-bundle.js"></script>`)
+	assert.Contains(t, body, `url: "/docs/openapi.json"`)
+
+	rsp, body = get(spec.Docs(), "/docs/openapi.json")
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Equal(t, "application/json", rsp.Header.Get("Content-Type"))
+	assert.Equal(t, petstore, body)
+
+	// Only the page and the specification are served
+	for _, path := range []string{"/docs/other", "/docs/other/", "/docs/other/openapi.json"} {
+		rsp, _ = get(spec.Docs(), path)
+		assert.Equal(t, http.StatusNotFound, rsp.StatusCode, path)
+	}
+	_, body = get(spec.Docs(), "/v/1/docs/")
+	assert.Contains(t, body, `url: "/v/1/docs/openapi.json"`)
+	rsp, _ = get(spec.Docs(), "/v/1/docs/openapi.json")
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+
+	_, body = get(spec.Docs(Redoc(), DocsTitle("Pets <API>"), DocsAssets("/static/redoc/")), "/docs/")
+	assert.Contains(t, body, "<title>Pets &lt;API&gt;</title>")
+	assert.Contains(t, body, `<redoc spec-url="/docs/openapi.json"></redoc>`)
+	assert.Contains(t, body, `<script src="/static/redoc/redoc.standalone.js"></script>`)
+
+	_, body = get(spec.Docs(DocsIntegrity("swagger-ui-bundle.js", "sha384-abc"),
+		DocsIntegrity("swagger-ui.css", "sha384-def")), "/docs/")
+	assert.Contains(t, body, `<script src="https://unpkg.com/swagger-ui-dist@5.17.14/swagger-ui-bundle.js" `+
+		`integrity="sha384-abc" crossorigin="anonymous"></script>`)
+	assert.Contains(t, body, `<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5.17.14/swagger-ui.css" `+
+		`integrity="sha384-def" crossorigin="anonymous">`)
+	_, body = get(spec.Docs(Redoc(), DocsIntegrity("redoc.standalone.js", "sha384-ghi")), "/docs/")
+	assert.Contains(t, body, `<script src="https://cdn.jsdelivr.net/npm/redoc@2.1.5/bundles/redoc.standalone.js" `+
+		`integrity="sha384-ghi" crossorigin="anonymous"></script>`)
+}
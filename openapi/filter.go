@@ -0,0 +1,274 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/4thel00z/libhttp"
+	"github.com/monzo/slog"
+	"github.com/monzo/terrors"
+)
+
+// An Option configures a validation filter.
+type Option func(*options)
+
+type options struct {
+	basePath          string
+	validateResponses bool
+	allowUnknown      bool
+	maxBodyBytes      int64
+}
+
+// BasePath sets a prefix of request paths which isn't part of the specification's paths (the path of its server's
+// URL, say), and is removed before they are matched.
+func BasePath(prefix string) Option {
+	return func(o *options) {
+		o.basePath = strings.TrimSuffix(prefix, "/")
+	}
+}
+
+// ValidateResponses validates the responses which the service returns, as well as the requests it is sent. Responses
+// which don't match the specification are replaced with an internal error (and logged), so that contract violations
+// are caught (in tests and canaries, say) rather than sent to clients. Error responses and streamed bodies aren't
+// validated.
+func ValidateResponses() Option {
+	return func(o *options) {
+		o.validateResponses = true
+	}
+}
+
+// AllowUnknown passes requests for paths and methods which the specification doesn't describe to the service,
+// unvalidated. By default, they get not found and method not allowed errors.
+func AllowUnknown() Option {
+	return func(o *options) {
+		o.allowUnknown = true
+	}
+}
+
+// MaxBodyBytes sets the size of the largest request body which is validated; larger bodies are rejected. The default
+// is 10 MiB.
+func MaxBodyBytes(n int64) Option {
+	return func(o *options) {
+		o.maxBodyBytes = n
+	}
+}
+
+// Filter returns a Filter which validates requests against the specification before passing them on to the service.
+// Requests whose parameters or bodies don't match it get a validation error (see libhttp.ValidationError), listing
+// each invalid field: parameters by their names, and properties of JSON bodies by their paths within the body
+// ("items[0].name", say, or "body" for the whole body). Bodies are validated if their media type is JSON, and are
+// left for the service to read.
+//
+// The filter names each request's operation (see libhttp.SetOperation) by the operationId of the specification's
+// operation, if it has one.
+func (s *Spec) Filter(opts ...Option) libhttp.Filter {
+	o := options{
+		maxBodyBytes: 10 << 20}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return func(req libhttp.Request, svc libhttp.Service) libhttp.Response {
+		path := req.URL.Path
+		if o.basePath != "" {
+			if !strings.HasPrefix(path, o.basePath+"/") && path != o.basePath {
+				return s.unknown(o, req, svc, terrors.NotFound("no_operation",
+					fmt.Sprintf("No operation for %s %s", req.Method, req.URL.Path), nil))
+			}
+			path = strings.TrimPrefix(path, o.basePath)
+		}
+		po, pathParams := s.find(path)
+		if po == nil {
+			return s.unknown(o, req, svc, terrors.NotFound("no_operation",
+				fmt.Sprintf("No operation for %s %s", req.Method, req.URL.Path), nil))
+		}
+		op, ok := po.byMethod[req.Method]
+		if !ok {
+			return s.unknown(o, req, svc, libhttp.NewError(http.StatusMethodNotAllowed,
+				"Method %s is not allowed for %s", req.Method, req.URL.Path))
+		}
+
+		fields := s.validateParams(req, op, pathParams)
+		bodyFields, err := s.validateBody(&req, op, o.maxBodyBytes)
+		if err != nil {
+			return libhttp.Response{Error: err}
+		}
+		if fields = append(fields, bodyFields...); len(fields) > 0 {
+			return libhttp.Response{Error: libhttp.ValidationError(fields...)}
+		}
+
+		if op.OperationID != "" {
+			req = libhttp.SetOperation(req, op.OperationID)
+		}
+		rsp := svc(req)
+		if o.validateResponses && rsp.Error == nil && rsp.Response != nil {
+			if err := s.validateResponse(&rsp, op); err != nil {
+				slog.Error(req, "Response to %s %s doesn't match the OpenAPI specification: %v", req.Method,
+					req.URL.Path, err)
+				return libhttp.Response{Error: err}
+			}
+		}
+		return rsp
+	}
+}
+
+// unknown handles a request which the specification doesn't describe.
+func (s *Spec) unknown(o options, req libhttp.Request, svc libhttp.Service, err error) libhttp.Response {
+	if o.allowUnknown {
+		return svc(req)
+	}
+	return libhttp.Response{Error: err}
+}
+
+// validateParams checks the request's parameters against those of the operation.
+func (s *Spec) validateParams(req libhttp.Request, op *operation, pathParams map[string]string) []libhttp.FieldError {
+	var fields []libhttp.FieldError
+	query := req.URL.Query()
+	for _, p := range op.params {
+		var values []string
+		switch p.In {
+		case "path":
+			if v, ok := pathParams[p.Name]; ok {
+				values = []string{v}
+			}
+		case "query":
+			values = query[p.Name]
+		case "header":
+			values = req.Header.Values(p.Name)
+		case "cookie":
+			if c, err := req.Cookie(p.Name); err == nil {
+				values = []string{c.Value}
+			}
+		}
+		if len(values) == 0 {
+			if p.Required || p.In == "path" {
+				fields = append(fields, libhttp.FieldError{
+					Field:  p.Name,
+					Reason: "is required"})
+			}
+			continue
+		}
+		// Query parameters are exploded (repeated for each value of an array) by default
+		explode := p.In == "query" || p.In == "cookie"
+		if p.Explode != nil {
+			explode = *p.Explode
+		}
+		v := p.Schema.parse(s, values, explode)
+		fields = append(fields, p.Schema.validate(s, v, p.Name)...)
+	}
+	return fields
+}
+
+// validateBody checks the request's body against the operation's, buffering it so that the service can read it.
+func (s *Spec) validateBody(req *libhttp.Request, op *operation, limit int64) ([]libhttp.FieldError, error) {
+	if op.body == nil {
+		return nil, nil
+	}
+	b, err := req.BufferBody(limit)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		if op.body.Required {
+			return []libhttp.FieldError{{Field: "body", Reason: "is required"}}, nil
+		}
+		return nil, nil
+	}
+	contentType := req.Header.Get("Content-Type")
+	mt, ok := matchContent(op.body.Content, contentType)
+	if !ok {
+		return []libhttp.FieldError{{
+			Field:  "body",
+			Reason: fmt.Sprintf("must not have a Content-Type of %q", contentType)}}, nil
+	}
+	return s.validateJSON(mt, b), nil
+}
+
+// validateResponse checks a response against those of the operation, returning an internal error if it doesn't
+// match.
+func (s *Spec) validateResponse(rsp *libhttp.Response, op *operation) error {
+	status := strconv.Itoa(rsp.StatusCode)
+	declared, ok := op.Responses[status]
+	if !ok {
+		declared, ok = op.Responses[status[:1]+"XX"]
+	}
+	if !ok {
+		declared, ok = op.Responses["default"]
+	}
+	if !ok {
+		return terrors.InternalService("response_validation",
+			fmt.Sprintf("Response status %d isn't in the OpenAPI specification", rsp.StatusCode), nil)
+	}
+	if rsp.Body == nil || len(declared.Content) == 0 {
+		return nil
+	}
+	contentType := rsp.Header.Get("Content-Type")
+	mt, ok := matchContent(declared.Content, contentType)
+	if !ok {
+		return terrors.InternalService("response_validation",
+			fmt.Sprintf("Response Content-Type %q isn't in the OpenAPI specification", contentType), nil)
+	}
+	if !mt.json || mt.Schema == nil {
+		return nil // only JSON bodies are validated, so streams of events (say) are never buffered
+	}
+	b, err := rsp.BodyBytes(false)
+	if err != nil {
+		return terrors.Wrap(err, nil)
+	}
+	if fields := s.validateJSON(mt, b); len(fields) > 0 {
+		params := make(map[string]string, len(fields))
+		reasons := make([]string, len(fields))
+		for i, f := range fields {
+			params["field:"+f.Field] = f.Reason
+			reasons[i] = f.Field + " " + f.Reason
+		}
+		return terrors.InternalService("response_validation",
+			"Response doesn't match the OpenAPI specification: "+strings.Join(reasons, "; "), params)
+	}
+	return nil
+}
+
+// validateJSON checks a body against the schema of its media type, if it is JSON.
+func (s *Spec) validateJSON(mt mediaTypeMatch, b []byte) []libhttp.FieldError {
+	if !mt.json || mt.Schema == nil {
+		return nil
+	}
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	if err := d.Decode(&v); err != nil || d.More() {
+		return []libhttp.FieldError{{Field: "body", Reason: "must be valid JSON"}}
+	}
+	fields := mt.Schema.validate(s, v, "")
+	for i := range fields {
+		if fields[i].Field == "" {
+			fields[i].Field = "body"
+		}
+	}
+	return fields
+}
+
+type mediaTypeMatch struct {
+	*mediaType
+	json bool
+}
+
+// matchContent returns the media type which describes content of the type, preferring exact matches over wildcards.
+func matchContent(content map[string]*mediaType, contentType string) (mediaTypeMatch, bool) {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return mediaTypeMatch{}, false
+	}
+	isJSON := mt == "application/json" || strings.HasSuffix(mt, "+json")
+	for _, candidate := range []string{mt, mt[:strings.IndexByte(mt, '/')+1] + "*", "*/*"} {
+		for name, m := range content {
+			if parsed, _, err := mime.ParseMediaType(name); err == nil && parsed == candidate {
+				return mediaTypeMatch{m, isJSON}, true
+			}
+		}
+	}
+	return mediaTypeMatch{}, false
+}
@@ -0,0 +1,263 @@
+// Package openapi enforces an OpenAPI 3.0 specification (https://spec.openapis.org/oas/v3.0.3) as a contract between
+// a service and its clients, by validating requests (and optionally responses) against it:
+//
+//  spec, err := openapi.ParseFile("openapi.json")
+//  if err != nil {
+//      ...
+//  }
+//  svc = svc.Filter(spec.Filter(openapi.ValidateResponses()))
+//
+// Specifications must be JSON (YAML ones can be converted with any YAML to JSON tool), and their references must be
+// local ("#/components/schemas/User", say). The validated subset of JSON Schema covers types, enums, formats (date,
+// date-time, email, uuid, ipv4 and ipv6), numeric and length limits, patterns, required and additional properties,
+// array items, and allOf, anyOf, oneOf and not.
+//
+// Spec.Docs serves browsable documentation of the specification, with Swagger UI or Redoc.
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Spec is a parsed OpenAPI specification. It is safe for concurrent use.
+type Spec struct {
+	raw        []byte
+	doc        document
+	operations []*pathOperations // in the order they are matched
+}
+
+// document is the subset of an OpenAPI document which is used to validate requests and responses.
+type document struct {
+	OpenAPI string `json:"openapi"`
+	Info    struct {
+		Title string `json:"title"`
+	} `json:"info"`
+	Paths      map[string]*pathItem `json:"paths"`
+	Components struct {
+		Schemas       map[string]*schema      `json:"schemas"`
+		Parameters    map[string]*parameter   `json:"parameters"`
+		RequestBodies map[string]*requestBody `json:"requestBodies"`
+		Responses     map[string]*response    `json:"responses"`
+	} `json:"components"`
+}
+
+type pathItem struct {
+	Parameters []*parameter `json:"parameters"`
+	Get        *operation   `json:"get"`
+	Put        *operation   `json:"put"`
+	Post       *operation   `json:"post"`
+	Delete     *operation   `json:"delete"`
+	Options    *operation   `json:"options"`
+	Head       *operation   `json:"head"`
+	Patch      *operation   `json:"patch"`
+	Trace      *operation   `json:"trace"`
+}
+
+type operation struct {
+	OperationID string               `json:"operationId"`
+	Parameters  []*parameter         `json:"parameters"`
+	RequestBody *requestBody         `json:"requestBody"`
+	Responses   map[string]*response `json:"responses"`
+
+	params []*parameter // the operation's and path's parameters, with references resolved
+	body   *requestBody // with references resolved
+}
+
+type parameter struct {
+	Ref      string  `json:"$ref"`
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Explode  *bool   `json:"explode"`
+	Schema   *schema `json:"schema"`
+}
+
+type requestBody struct {
+	Ref      string                `json:"$ref"`
+	Required bool                  `json:"required"`
+	Content  map[string]*mediaType `json:"content"`
+}
+
+type response struct {
+	Ref     string                `json:"$ref"`
+	Content map[string]*mediaType `json:"content"`
+}
+
+type mediaType struct {
+	Schema *schema `json:"schema"`
+}
+
+// pathOperations are the operations on a path.
+type pathOperations struct {
+	template string
+	re       *regexp.Regexp
+	params   []string // names of the path's parameters, in order
+	byMethod map[string]*operation
+}
+
+var pathParamRe = regexp.MustCompile(`\{([^{}/]+)\}`)
+
+// Parse parses a JSON OpenAPI 3 specification.
+func Parse(b []byte) (*Spec, error) {
+	s := &Spec{
+		raw: b}
+	if err := json.Unmarshal(b, &s.doc); err != nil {
+		return nil, fmt.Errorf("openapi: invalid specification: %v", err)
+	}
+	if !strings.HasPrefix(s.doc.OpenAPI, "3.") {
+		return nil, fmt.Errorf("openapi: unsupported version %q", s.doc.OpenAPI)
+	}
+	if err := s.compile(); err != nil {
+		return nil, fmt.Errorf("openapi: %v", err)
+	}
+	return s, nil
+}
+
+// ParseFile parses the JSON OpenAPI 3 specification in the named file.
+func ParseFile(path string) (*Spec, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(b)
+}
+
+// JSON returns the specification, as it was parsed.
+func (s *Spec) JSON() []byte {
+	return s.raw
+}
+
+// compile resolves the references in the specification's operations and prepares its paths for matching.
+func (s *Spec) compile() error {
+	seen := map[*schema]bool{}
+	for _, sch := range s.doc.Components.Schemas {
+		if err := sch.compile(s, seen); err != nil {
+			return err
+		}
+	}
+	for template, item := range s.doc.Paths {
+		po := &pathOperations{
+			template: template,
+			byMethod: map[string]*operation{}}
+		re := "^"
+		last := 0
+		for _, m := range pathParamRe.FindAllStringSubmatchIndex(template, -1) {
+			re += regexp.QuoteMeta(template[last:m[0]]) + "([^/]+)"
+			po.params = append(po.params, template[m[2]:m[3]])
+			last = m[1]
+		}
+		po.re = regexp.MustCompile(re + regexp.QuoteMeta(template[last:]) + "$")
+
+		for method, op := range map[string]*operation{
+			http.MethodGet: item.Get, http.MethodPut: item.Put, http.MethodPost: item.Post,
+			http.MethodDelete: item.Delete, http.MethodOptions: item.Options, http.MethodHead: item.Head,
+			http.MethodPatch: item.Patch, http.MethodTrace: item.Trace} {
+			if op == nil {
+				continue
+			}
+			if err := s.compileOperation(op, item.Parameters, seen); err != nil {
+				return fmt.Errorf("%s %s: %v", method, template, err)
+			}
+			po.byMethod[method] = op
+		}
+		s.operations = append(s.operations, po)
+	}
+	// Paths without parameters take precedence over templated ones which would also match them
+	sort.Slice(s.operations, func(i, j int) bool {
+		a, b := s.operations[i], s.operations[j]
+		if len(a.params) != len(b.params) {
+			return len(a.params) < len(b.params)
+		}
+		return a.template < b.template
+	})
+	return nil
+}
+
+func (s *Spec) compileOperation(op *operation, pathParams []*parameter, seen map[*schema]bool) error {
+	// Parameters of the operation override those of the path with the same name and location
+	byKey := map[string]*parameter{}
+	var keys []string
+	for _, params := range [][]*parameter{pathParams, op.Parameters} {
+		for _, p := range params {
+			if p.Ref != "" {
+				ref, ok := s.doc.Components.Parameters[strings.TrimPrefix(p.Ref, "#/components/parameters/")]
+				if !ok {
+					return fmt.Errorf("unresolved reference %q", p.Ref)
+				}
+				p = ref
+			}
+			if err := p.Schema.compile(s, seen); err != nil {
+				return err
+			}
+			key := p.In + ":" + p.Name
+			if _, ok := byKey[key]; !ok {
+				keys = append(keys, key)
+			}
+			byKey[key] = p
+		}
+	}
+	for _, key := range keys {
+		op.params = append(op.params, byKey[key])
+	}
+
+	op.body = op.RequestBody
+	if op.body != nil && op.body.Ref != "" {
+		ref, ok := s.doc.Components.RequestBodies[strings.TrimPrefix(op.body.Ref, "#/components/requestBodies/")]
+		if !ok {
+			return fmt.Errorf("unresolved reference %q", op.body.Ref)
+		}
+		op.body = ref
+	}
+	if op.body != nil {
+		for _, mt := range op.body.Content {
+			if err := mt.Schema.compile(s, seen); err != nil {
+				return err
+			}
+		}
+	}
+	for status, rsp := range op.Responses {
+		if rsp.Ref != "" {
+			ref, ok := s.doc.Components.Responses[strings.TrimPrefix(rsp.Ref, "#/components/responses/")]
+			if !ok {
+				return fmt.Errorf("unresolved reference %q", rsp.Ref)
+			}
+			op.Responses[status] = ref
+			rsp = ref
+		}
+		for _, mt := range rsp.Content {
+			if err := mt.Schema.compile(s, seen); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// schema resolves a reference to a schema.
+func (s *Spec) schema(ref string) (*schema, error) {
+	if sch, ok := s.doc.Components.Schemas[strings.TrimPrefix(ref, "#/components/schemas/")]; ok &&
+		strings.HasPrefix(ref, "#/components/schemas/") {
+		return sch, nil
+	}
+	return nil, fmt.Errorf("unresolved reference %q", ref)
+}
+
+// find returns the operations on the path which matches a request's path, and the values of its parameters.
+func (s *Spec) find(path string) (*pathOperations, map[string]string) {
+	for _, po := range s.operations {
+		if m := po.re.FindStringSubmatch(path); m != nil {
+			params := make(map[string]string, len(po.params))
+			for i, name := range po.params {
+				params[name] = m[i+1]
+			}
+			return po, params
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,259 @@
+package openapi
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/4thel00z/libhttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const petstore = `{
+  "openapi": "3.0.3",
+  "info": {"title": "Petstore", "version": "1"},
+  "paths": {
+    "/pets": {
+      "get": {
+        "operationId": "listPets",
+        "parameters": [
+          {"name": "limit", "in": "query", "schema": {"type": "integer", "minimum": 1, "maximum": 100}},
+          {"name": "tag", "in": "query", "schema": {"type": "array", "items": {"type": "string"}}}
+        ],
+        "responses": {
+          "200": {"description": "", "content": {"application/json": {"schema": {
+            "type": "array", "items": {"$ref": "#/components/schemas/Pet"}}}}}
+        }
+      },
+      "post": {
+        "operationId": "createPet",
+        "requestBody": {"$ref": "#/components/requestBodies/Pet"},
+        "responses": {
+          "201": {"description": "", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Pet"}}}},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/pets/{id}": {
+      "parameters": [{"$ref": "#/components/parameters/ID"}],
+      "get": {
+        "operationId": "getPet",
+        "parameters": [{"name": "X-Request-Id", "in": "header", "required": true, "schema": {"type": "string", "format": "uuid"}}],
+        "responses": {"2XX": {"description": "", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Pet"}}}}}
+      }
+    },
+    "/pets/mine": {
+      "get": {"operationId": "myPets", "responses": {"200": {"description": ""}}}
+    }
+  },
+  "components": {
+    "schemas": {
+      "Pet": {
+        "type": "object",
+        "required": ["name"],
+        "additionalProperties": false,
+        "properties": {
+          "id": {"type": "integer"},
+          "name": {"type": "string", "minLength": 1, "maxLength": 10},
+          "kind": {"type": "string", "enum": ["cat", "dog"]},
+          "tags": {"type": "array", "items": {"type": "string"}, "uniqueItems": true},
+          "born": {"type": "string", "format": "date", "nullable": true}
+        }
+      }
+    },
+    "parameters": {
+      "ID": {"name": "id", "in": "path", "required": true, "schema": {"type": "integer", "minimum": 1}}
+    },
+    "requestBodies": {
+      "Pet": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Pet"}}}}
+    },
+    "responses": {
+      "Error": {"description": "", "content": {"application/json": {"schema": {"type": "object"}}}}
+    }
+  }
+}`
+
+func problem(t *testing.T, rsp libhttp.Response) map[string]string {
+	require.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+	v := struct {
+		InvalidParams []struct {
+			Name, Reason string
+		} `json:"invalid-params"`
+	}{}
+	b, err := rsp.BodyBytes(true)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(b, &v))
+	fields := map[string]string{}
+	for _, p := range v.InvalidParams {
+		fields[p.Name] = p.Reason
+	}
+	return fields
+}
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+	spec, err := Parse([]byte(petstore))
+	require.NoError(t, err)
+	assert.Equal(t, petstore, string(spec.JSON()))
+
+	for _, doc := range []string{
+		`{`,
+		`{"swagger": "2.0"}`,
+		`{"openapi": "3.0.0", "paths": {"/a": {"get": {"parameters": [{"$ref": "#/components/parameters/nope"}]}}}}`,
+		`{"openapi": "3.0.0", "components": {"schemas": {"A": {"$ref": "#/components/schemas/B"}}}}`,
+		`{"openapi": "3.0.0", "components": {"schemas": {"A": {"type": "string", "pattern": "("}}}}`} {
+		_, err := Parse([]byte(doc))
+		assert.Error(t, err, doc)
+	}
+}
+
+func TestFilterRequests(t *testing.T) {
+	t.Parallel()
+	spec, err := Parse([]byte(petstore))
+	require.NoError(t, err)
+	svc := libhttp.Service(func(req libhttp.Request) libhttp.Response {
+		b, _ := req.BodyBytes(true)
+		rsp := req.Response(map[string]string{
+			"operation": libhttp.Operation(req),
+			"body":      string(b)})
+		return rsp
+	}).Filter(spec.Filter(BasePath("/v1/"))).Filter(libhttp.ErrorFilter)
+
+	send := func(method, target, body string, header ...string) libhttp.Response {
+		req := libhttp.NewRequest(context.Background(), method, target, nil)
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+			req.Body = ioutil.NopCloser(strings.NewReader(body))
+		}
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		return req.SendVia(svc).Response()
+	}
+
+	// Valid requests reach the service, named by their operations, with their bodies intact
+	body := `{"name":"Rex","kind":"dog","tags":["a","b"],"born":null}`
+	for _, c := range []struct {
+		method, target, body, operation string
+		header                          []string
+	}{
+		{"GET", "/v1/pets?limit=10&tag=a&tag=b", "", "listPets", nil},
+		{"POST", "/v1/pets", body, "createPet", nil},
+		{"GET", "/v1/pets/1", "", "getPet", []string{"X-Request-Id", "123e4567-e89b-12d3-a456-426614174000"}},
+		{"GET", "/v1/pets/mine", "", "myPets", nil}} {
+		rsp := send(c.method, c.target, c.body, c.header...)
+		require.Equal(t, http.StatusOK, rsp.StatusCode, c.target)
+		v := map[string]string{}
+		require.NoError(t, rsp.Decode(&v))
+		assert.Equal(t, c.operation, v["operation"])
+		assert.Equal(t, c.body, v["body"])
+	}
+
+	assert.Equal(t, map[string]string{
+		"limit": "must be at most 100",
+	}, problem(t, send("GET", "/v1/pets?limit=1000", "")))
+	assert.Equal(t, map[string]string{
+		"limit": "must be an integer",
+	}, problem(t, send("GET", "/v1/pets?limit=ten", "")))
+	assert.Equal(t, map[string]string{
+		"id":           "must be at least 1",
+		"X-Request-Id": "is required",
+	}, problem(t, send("GET", "/v1/pets/0", "")))
+	assert.Equal(t, map[string]string{
+		"body": "is required",
+	}, problem(t, send("POST", "/v1/pets", "")))
+	assert.Equal(t, map[string]string{
+		"body": "must be valid JSON",
+	}, problem(t, send("POST", "/v1/pets", `{"name":`)))
+	assert.Equal(t, map[string]string{
+		"name":   "must have at most 10 characters",
+		"kind":   "must be one of: cat, dog",
+		"tags":   "must not contain duplicate elements",
+		"born":   "must be a valid date",
+		"colour": "is not allowed",
+	}, problem(t, send("POST", "/v1/pets",
+		`{"name":"Rex the Wonder Dog","kind":"cow","tags":["a","a"],"born":"yesterday","colour":"brown"}`)))
+	assert.Equal(t, map[string]string{
+		"name": "is required",
+	}, problem(t, send("POST", "/v1/pets", `{"id":1}`)))
+	assert.Equal(t, map[string]string{
+		"body": `must not have a Content-Type of "text/plain"`,
+	}, problem(t, send("POST", "/v1/pets", "Rex", "Content-Type", "text/plain")))
+
+	assert.Equal(t, http.StatusNotFound, send("GET", "/v1/owners", "").StatusCode)
+	assert.Equal(t, http.StatusNotFound, send("GET", "/pets", "").StatusCode)
+	assert.Equal(t, http.StatusMethodNotAllowed, send("DELETE", "/v1/pets", "").StatusCode)
+
+	// Unknown requests can be let through
+	svc = libhttp.Service(func(req libhttp.Request) libhttp.Response {
+		return libhttp.NewResponse(req)
+	}).Filter(spec.Filter(AllowUnknown()))
+	rsp := libhttp.NewRequest(context.Background(), "GET", "/owners", nil).SendVia(svc).Response()
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+}
+
+func TestFilterResponses(t *testing.T) {
+	t.Parallel()
+	spec, err := Parse([]byte(petstore))
+	require.NoError(t, err)
+	var rspBody interface{}
+	status := http.StatusOK
+	svc := libhttp.Service(func(req libhttp.Request) libhttp.Response {
+		rsp := req.Response(rspBody)
+		rsp.StatusCode = status
+		return rsp
+	}).Filter(spec.Filter(ValidateResponses())).Filter(libhttp.ErrorFilter)
+	send := func() libhttp.Response {
+		return libhttp.NewRequest(context.Background(), "GET", "/pets", nil).SendVia(svc).Response()
+	}
+
+	rspBody = []map[string]interface{}{{"id": 1, "name": "Rex"}}
+	rsp := send()
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	v := []map[string]interface{}{}
+	require.NoError(t, rsp.Decode(&v))
+	assert.Equal(t, "Rex", v[0]["name"])
+
+	rspBody = []map[string]interface{}{{"id": "one"}}
+	rsp = send()
+	assert.Equal(t, http.StatusInternalServerError, rsp.StatusCode)
+	b, err := rsp.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), "[0].id must be an integer")
+	assert.Contains(t, string(b), "[0].name is required")
+
+	// Undeclared statuses are errors too
+	rspBody, status = []interface{}{}, http.StatusAccepted
+	assert.Equal(t, http.StatusInternalServerError, send().StatusCode)
+}
+
+func TestSchemaRefs(t *testing.T) {
+	t.Parallel()
+	spec, err := Parse([]byte(`{
+	  "openapi": "3.0.0",
+	  "components": {"schemas": {
+	    "Node": {"type": "object", "properties": {"children": {"type": "array", "items": {"$ref": "#/components/schemas/Node"}}}},
+	    "Shape": {"oneOf": [{"$ref": "#/components/schemas/Circle"}, {"$ref": "#/components/schemas/Square"}]},
+	    "Circle": {"type": "object", "required": ["radius"], "properties": {"radius": {"type": "number", "exclusiveMinimum": true, "minimum": 0}}},
+	    "Square": {"type": "object", "required": ["side"], "properties": {"side": {"type": "number", "multipleOf": 0.5}}}
+	  }}}`))
+	require.NoError(t, err)
+	validate := func(name, doc string) []libhttp.FieldError {
+		var v interface{}
+		require.NoError(t, json.Unmarshal([]byte(doc), &v))
+		return (&schema{Ref: "#/components/schemas/" + name}).validate(spec, v, "")
+	}
+
+	assert.Empty(t, validate("Node", `{"children":[{"children":[]}]}`))
+	assert.Equal(t, []libhttp.FieldError{{Field: "children[0].children", Reason: "must be an array"}},
+		validate("Node", `{"children":[{"children":1}]}`))
+	assert.Empty(t, validate("Shape", `{"radius":1}`))
+	assert.Empty(t, validate("Shape", `{"side":1.5}`))
+	assert.NotEmpty(t, validate("Shape", `{"radius":0}`))
+	assert.NotEmpty(t, validate("Shape", `{"side":1.2}`))
+	assert.NotEmpty(t, validate("Shape", `{"radius":1,"side":1}`))
+}
@@ -0,0 +1,373 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/4thel00z/libhttp"
+)
+
+// schema is the subset of the OpenAPI 3.0 Schema Object which is validated.
+type schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Format               string             `json:"format"`
+	Nullable             bool               `json:"nullable"`
+	Enum                 []interface{}      `json:"enum"`
+	Properties           map[string]*schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties json.RawMessage    `json:"additionalProperties"` // a boolean or a schema
+	MinProperties        *int               `json:"minProperties"`
+	MaxProperties        *int               `json:"maxProperties"`
+	Items                *schema            `json:"items"`
+	MinItems             *int               `json:"minItems"`
+	MaxItems             *int               `json:"maxItems"`
+	UniqueItems          bool               `json:"uniqueItems"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	ExclusiveMinimum     bool               `json:"exclusiveMinimum"`
+	ExclusiveMaximum     bool               `json:"exclusiveMaximum"`
+	MultipleOf           *float64           `json:"multipleOf"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+	Pattern              string             `json:"pattern"`
+	AllOf                []*schema          `json:"allOf"`
+	AnyOf                []*schema          `json:"anyOf"`
+	OneOf                []*schema          `json:"oneOf"`
+	Not                  *schema            `json:"not"`
+
+	pattern        *regexp.Regexp
+	additionalProp *schema // compiled from AdditionalProperties when it is a schema
+	noAdditional   bool    // AdditionalProperties is false
+}
+
+// compile resolves the schema's references and compiles its patterns, recursively.
+func (s *schema) compile(spec *Spec, seen map[*schema]bool) error {
+	if s == nil || seen[s] {
+		return nil
+	}
+	seen[s] = true
+	if s.Ref != "" {
+		if _, err := spec.schema(s.Ref); err != nil {
+			return err
+		}
+	}
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", s.Pattern, err)
+		}
+		s.pattern = re
+	}
+	switch a := strings.TrimSpace(string(s.AdditionalProperties)); {
+	case a == "false":
+		s.noAdditional = true
+	case strings.HasPrefix(a, "{"):
+		s.additionalProp = &schema{}
+		if err := json.Unmarshal(s.AdditionalProperties, s.additionalProp); err != nil {
+			return err
+		}
+	}
+	children := append(append(append([]*schema{s.Items, s.Not, s.additionalProp}, s.AllOf...), s.AnyOf...), s.OneOf...)
+	for _, p := range s.Properties {
+		children = append(children, p)
+	}
+	for _, c := range children {
+		if err := c.compile(spec, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validate checks a value decoded from JSON against the schema, returning the problems with it. The field names the
+// value in errors.
+func (s *schema) validate(spec *Spec, v interface{}, field string) []libhttp.FieldError {
+	if s == nil {
+		return nil
+	}
+	if s.Ref != "" {
+		ref, _ := spec.schema(s.Ref) // checked when the spec was parsed
+		return ref.validate(spec, v, field)
+	}
+	fail := func(format string, args ...interface{}) []libhttp.FieldError {
+		return []libhttp.FieldError{{
+			Field:  field,
+			Reason: fmt.Sprintf(format, args...)}}
+	}
+
+	if v == nil {
+		if s.Nullable || (s.Type == "" && len(s.AllOf)+len(s.AnyOf)+len(s.OneOf) == 0) {
+			return nil
+		}
+		return fail("must not be null")
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if reflect.DeepEqual(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			options := make([]string, len(s.Enum))
+			for i, e := range s.Enum {
+				options[i] = fmt.Sprint(e)
+			}
+			return fail("must be one of: %s", strings.Join(options, ", "))
+		}
+	}
+
+	var errs []libhttp.FieldError
+	switch s.Type {
+	case "":
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return fail("must be a string")
+		}
+		errs = append(errs, s.validateString(str, fail)...)
+	case "integer", "number":
+		n, ok := v.(float64)
+		if !ok {
+			return fail("must be %s", typeName(s.Type))
+		}
+		if s.Type == "integer" && n != math.Trunc(n) {
+			return fail("must be an integer")
+		}
+		errs = append(errs, s.validateNumber(n, fail)...)
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fail("must be a boolean")
+		}
+	case "array":
+		a, ok := v.([]interface{})
+		if !ok {
+			return fail("must be an array")
+		}
+		errs = append(errs, s.validateArray(spec, a, field, fail)...)
+	case "object":
+		o, ok := v.(map[string]interface{})
+		if !ok {
+			return fail("must be an object")
+		}
+		errs = append(errs, s.validateObject(spec, o, field, fail)...)
+	}
+
+	for _, sub := range s.AllOf {
+		errs = append(errs, sub.validate(spec, v, field)...)
+	}
+	if len(s.AnyOf) > 0 {
+		matched := false
+		for _, sub := range s.AnyOf {
+			if len(sub.validate(spec, v, field)) == 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			errs = append(errs, fail("must match at least one of the allowed schemas")...)
+		}
+	}
+	if len(s.OneOf) > 0 {
+		matched := 0
+		for _, sub := range s.OneOf {
+			if len(sub.validate(spec, v, field)) == 0 {
+				matched++
+			}
+		}
+		if matched != 1 {
+			errs = append(errs, fail("must match exactly one of the allowed schemas")...)
+		}
+	}
+	if s.Not != nil && len(s.Not.validate(spec, v, field)) == 0 {
+		errs = append(errs, fail("must not match the disallowed schema")...)
+	}
+	return errs
+}
+
+func (s *schema) validateString(str string,
+	fail func(string, ...interface{}) []libhttp.FieldError) []libhttp.FieldError {
+	n := len([]rune(str))
+	switch {
+	case s.MinLength != nil && n < *s.MinLength:
+		return fail("must have at least %d characters", *s.MinLength)
+	case s.MaxLength != nil && n > *s.MaxLength:
+		return fail("must have at most %d characters", *s.MaxLength)
+	case s.pattern != nil && !s.pattern.MatchString(str):
+		return fail("must match the pattern %s", s.Pattern)
+	}
+	if checkFormat, ok := formats[s.Format]; ok && !checkFormat(str) {
+		return fail("must be a valid %s", s.Format)
+	}
+	return nil
+}
+
+func (s *schema) validateNumber(n float64,
+	fail func(string, ...interface{}) []libhttp.FieldError) []libhttp.FieldError {
+	switch {
+	case s.Minimum != nil && s.ExclusiveMinimum && n <= *s.Minimum:
+		return fail("must be greater than %v", *s.Minimum)
+	case s.Minimum != nil && n < *s.Minimum:
+		return fail("must be at least %v", *s.Minimum)
+	case s.Maximum != nil && s.ExclusiveMaximum && n >= *s.Maximum:
+		return fail("must be less than %v", *s.Maximum)
+	case s.Maximum != nil && n > *s.Maximum:
+		return fail("must be at most %v", *s.Maximum)
+	case s.MultipleOf != nil && *s.MultipleOf != 0 && math.Mod(n, *s.MultipleOf) != 0:
+		return fail("must be a multiple of %v", *s.MultipleOf)
+	}
+	return nil
+}
+
+func (s *schema) validateArray(spec *Spec, a []interface{}, field string,
+	fail func(string, ...interface{}) []libhttp.FieldError) []libhttp.FieldError {
+	switch {
+	case s.MinItems != nil && len(a) < *s.MinItems:
+		return fail("must have at least %d elements", *s.MinItems)
+	case s.MaxItems != nil && len(a) > *s.MaxItems:
+		return fail("must have at most %d elements", *s.MaxItems)
+	}
+	if s.UniqueItems {
+		for i := range a {
+			for j := 0; j < i; j++ {
+				if reflect.DeepEqual(a[i], a[j]) {
+					return fail("must not contain duplicate elements")
+				}
+			}
+		}
+	}
+	var errs []libhttp.FieldError
+	for i, item := range a {
+		errs = append(errs, s.Items.validate(spec, item, fmt.Sprintf("%s[%d]", field, i))...)
+	}
+	return errs
+}
+
+func (s *schema) validateObject(spec *Spec, o map[string]interface{}, field string,
+	fail func(string, ...interface{}) []libhttp.FieldError) []libhttp.FieldError {
+	switch {
+	case s.MinProperties != nil && len(o) < *s.MinProperties:
+		return fail("must have at least %d properties", *s.MinProperties)
+	case s.MaxProperties != nil && len(o) > *s.MaxProperties:
+		return fail("must have at most %d properties", *s.MaxProperties)
+	}
+	var errs []libhttp.FieldError
+	for _, name := range s.Required {
+		if _, ok := o[name]; !ok {
+			errs = append(errs, libhttp.FieldError{
+				Field:  join(field, name),
+				Reason: "is required"})
+		}
+	}
+	names := make([]string, 0, len(o))
+	for name := range o {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if p, ok := s.Properties[name]; ok {
+			errs = append(errs, p.validate(spec, o[name], join(field, name))...)
+		} else if s.noAdditional {
+			errs = append(errs, libhttp.FieldError{
+				Field:  join(field, name),
+				Reason: "is not allowed"})
+		} else if s.additionalProp != nil {
+			errs = append(errs, s.additionalProp.validate(spec, o[name], join(field, name))...)
+		}
+	}
+	return errs
+}
+
+// join returns the name of a property of the named field.
+func join(field, name string) string {
+	if field == "" {
+		return name
+	}
+	return field + "." + name
+}
+
+func typeName(t string) string {
+	if t == "integer" {
+		return "an integer"
+	}
+	return "a " + t
+}
+
+var uuidRe = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// formats check the string formats which are validated; others are accepted as they are.
+var formats = map[string]func(string) bool{
+	"date-time": func(s string) bool {
+		_, err := time.Parse(time.RFC3339, s)
+		return err == nil
+	},
+	"date": func(s string) bool {
+		_, err := time.Parse("2006-01-02", s)
+		return err == nil
+	},
+	"uuid": uuidRe.MatchString,
+	"ipv4": func(s string) bool {
+		ip := net.ParseIP(s)
+		return ip != nil && ip.To4() != nil && !strings.Contains(s, ":")
+	},
+	"ipv6": func(s string) bool {
+		ip := net.ParseIP(s)
+		return ip != nil && strings.Contains(s, ":")
+	},
+	"email": func(s string) bool {
+		i := strings.LastIndexByte(s, '@')
+		return i > 0 && i < len(s)-1 && !strings.ContainsAny(s, " \t\r\n")
+	},
+}
+
+// parse converts the string value of a parameter to the type its schema describes, so that it can be validated.
+// Values which can't be converted are returned as they are, so that validation reports their type.
+func (s *schema) parse(spec *Spec, values []string, explode bool) interface{} {
+	if s != nil && s.Ref != "" {
+		s, _ = spec.schema(s.Ref)
+	}
+	if s == nil || s.Type != "array" {
+		if len(values) == 0 {
+			return ""
+		}
+		return s.parseScalar(spec, values[0])
+	}
+	if !explode && len(values) == 1 {
+		values = strings.Split(values[0], ",")
+	}
+	a := make([]interface{}, len(values))
+	for i, v := range values {
+		a[i] = s.Items.parseScalar(spec, v)
+	}
+	return a
+}
+
+func (s *schema) parseScalar(spec *Spec, v string) interface{} {
+	if s != nil && s.Ref != "" {
+		s, _ = spec.schema(s.Ref)
+	}
+	if s == nil {
+		return v
+	}
+	switch s.Type {
+	case "integer", "number":
+		if n, err := strconv.ParseFloat(v, 64); err == nil {
+			return n
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return v
+}
@@ -0,0 +1,73 @@
+package libhttp
+
+import (
+	"syscall"
+	"time"
+
+	"github.com/4thel00z/libhttp/libhttpmetrics"
+)
+
+// A ServerOption configures optional behaviour of a Server, or of the listener that is created for it.
+type ServerOption func(*serverOptions)
+
+type serverOptions struct {
+	listenControl   func(network, address string, c syscall.RawConn) error
+	shutdownTimeout time.Duration
+	bandwidth       BandwidthLimits
+	clientCAs       *ClientCAPool
+	metrics         libhttpmetrics.Registry
+	meters          libhttpmetrics.MeterProvider
+	hooks           []Hooks
+	abortOnPanic    bool
+}
+
+func newServerOptions(opts []ServerOption) serverOptions {
+	o := serverOptions{
+		shutdownTimeout: 10 * time.Second}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithListenControl sets a function that is called on the listening socket after it is created but before it is
+// bound, in the same way as net.ListenConfig.Control. This can be used to set arbitrary socket options (for example
+// IP_FREEBIND, TCP_FASTOPEN or SO_MARK).
+func WithListenControl(f func(network, address string, c syscall.RawConn) error) ServerOption {
+	return func(o *serverOptions) {
+		o.listenControl = f
+	}
+}
+
+// WithShutdownTimeout sets how long Run will wait for in-flight requests to complete when shutting down, before
+// connections are forcibly closed. The default is 10 seconds.
+func WithShutdownTimeout(d time.Duration) ServerOption {
+	return func(o *serverOptions) {
+		o.shutdownTimeout = d
+	}
+}
+
+// WithBandwidthLimits throttles the rate at which data is transferred over the server's connections, which can protect
+// (for example) file-serving endpoints from bandwidth exhaustion by a few clients.
+func WithBandwidthLimits(limits BandwidthLimits) ServerOption {
+	return func(o *serverOptions) {
+		o.bandwidth = limits
+	}
+}
+
+// WithClientCAs makes a TLS server verify client certificates against the passed pool, which may be reloaded while the
+// server is running. Unless the server's tls.Config sets a different ClientAuth policy, client certificates are
+// required. The option has no effect on servers that don't use TLS.
+func WithClientCAs(pool *ClientCAPool) ServerOption {
+	return func(o *serverOptions) {
+		o.clientCAs = pool
+	}
+}
+
+// WithAbortOnPanic makes the server abort the connection when a filter or handler panics, as net/http does, rather
+// than sending a 500 response. Panics are reported to OnPanic hooks either way.
+func WithAbortOnPanic() ServerOption {
+	return func(o *serverOptions) {
+		o.abortOnPanic = true
+	}
+}
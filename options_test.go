@@ -0,0 +1,41 @@
+package libhttp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenControl(t *testing.T) {
+	t.Parallel()
+
+	var controlled []string
+	control := func(network, address string, c syscall.RawConn) error {
+		controlled = append(controlled, network)
+		return nil
+	}
+	s, err := Listen(Service(func(req Request) Response {
+		return req.Response(nil)
+	}), "127.0.0.1:0", WithListenControl(control))
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+	assert.Equal(t, []string{"tcp4"}, controlled)
+
+	req := NewRequest(context.Background(), "GET", fmt.Sprintf("http://%s", s.Listener().Addr()), nil)
+	rsp := req.SendVia(BareClient).Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+
+	// An error from the control function prevents the listener being created
+	_, err = Listen(Service(func(req Request) Response {
+		return req.Response(nil)
+	}), "127.0.0.1:0", WithListenControl(func(string, string, syscall.RawConn) error {
+		return fmt.Errorf("nope")
+	}))
+	assert.Error(t, err)
+}
@@ -0,0 +1,170 @@
+// Package otelmetrics records libhttp's metrics through OpenTelemetry, by adapting its MeterProviders and Meters to
+// libhttpmetrics MeterProviders and Registries. It is a module of its own, so that libhttp itself doesn't depend on
+// the OpenTelemetry API.
+//
+// Counters are recorded as Float64Counters, gauges as Float64UpDownCounters, and histograms as Float64Histograms
+// with the buckets they are created with as their explicit bucket boundaries. Label names and values become
+// attributes.
+package otelmetrics
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/4thel00z/libhttp/libhttpmetrics"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// NewMeterProvider returns a MeterProvider which provides a Registry for each scope backed by the Meter mp provides
+// for it, so that libhttp records its metrics through an OpenTelemetry SDK:
+//
+//  mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+//  srv, err := libhttp.Listen(svc, ":8000", libhttp.WithServerMeterProvider(otelmetrics.NewMeterProvider(mp)))
+func NewMeterProvider(mp metric.MeterProvider) libhttpmetrics.MeterProvider {
+	return &meterProvider{
+		mp:         mp,
+		registries: map[string]libhttpmetrics.Registry{}}
+}
+
+type meterProvider struct {
+	mp         metric.MeterProvider
+	m          sync.Mutex
+	registries map[string]libhttpmetrics.Registry // by scope
+}
+
+func (p *meterProvider) Meter(scope string) libhttpmetrics.Registry {
+	p.m.Lock()
+	defer p.m.Unlock()
+	r, ok := p.registries[scope]
+	if !ok {
+		r = NewRegistry(p.mp.Meter(scope))
+		p.registries[scope] = r
+	}
+	return r
+}
+
+// NewRegistry returns a Registry which creates its metrics as instruments of m. Errors creating instruments are
+// passed to otel.Handle, and the metric concerned is recorded in whatever (possibly no-op) instrument m returned.
+func NewRegistry(m metric.Meter) libhttpmetrics.Registry {
+	return &registry{
+		meter:   m,
+		metrics: map[string]interface{}{}}
+}
+
+type registry struct {
+	meter   metric.Meter
+	m       sync.Mutex
+	metrics map[string]interface{} // by name
+}
+
+// lookup returns the metric with the given name, creating it with create if it doesn't exist yet.
+func (r *registry) lookup(name string, create func() interface{}) interface{} {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if m, ok := r.metrics[name]; ok {
+		return m
+	}
+	m := create()
+	r.metrics[name] = m
+	return m
+}
+
+func (r *registry) Counter(name, help string, labels ...string) libhttpmetrics.Counter {
+	return r.lookup(name, func() interface{} {
+		c, err := r.meter.Float64Counter(name, metric.WithDescription(help))
+		if err != nil {
+			otel.Handle(err)
+		}
+		return &counter{attributes{labels}, c}
+	}).(libhttpmetrics.Counter)
+}
+
+func (r *registry) Gauge(name, help string, labels ...string) libhttpmetrics.Gauge {
+	return r.lookup(name, func() interface{} {
+		c, err := r.meter.Float64UpDownCounter(name, metric.WithDescription(help))
+		if err != nil {
+			otel.Handle(err)
+		}
+		return &gauge{
+			attributes: attributes{labels},
+			c:          c,
+			values:     map[string]float64{}}
+	}).(libhttpmetrics.Gauge)
+}
+
+func (r *registry) Histogram(name, help string, buckets []float64, labels ...string) libhttpmetrics.Histogram {
+	if buckets == nil {
+		buckets = libhttpmetrics.DefaultBuckets
+	}
+	return r.lookup(name, func() interface{} {
+		h, err := r.meter.Float64Histogram(name, metric.WithDescription(help),
+			metric.WithExplicitBucketBoundaries(buckets...))
+		if err != nil {
+			otel.Handle(err)
+		}
+		return &histogram{attributes{labels}, h}
+	}).(libhttpmetrics.Histogram)
+}
+
+// attributes pairs the label names of a metric with the values it is updated with.
+type attributes struct {
+	labels []string
+}
+
+func (a attributes) option(values []string) metric.MeasurementOption {
+	kvs := make([]attribute.KeyValue, len(a.labels))
+	for i, label := range a.labels {
+		v := ""
+		if i < len(values) {
+			v = values[i]
+		}
+		kvs[i] = attribute.String(label, v)
+	}
+	return metric.WithAttributes(kvs...)
+}
+
+type counter struct {
+	attributes
+	c metric.Float64Counter
+}
+
+func (c *counter) Add(v float64, labelValues ...string) {
+	c.c.Add(context.Background(), v, c.option(labelValues))
+}
+
+// gauge keeps the value of each of its series, so that setting one can be recorded as the difference from its
+// previous value.
+type gauge struct {
+	attributes
+	c      metric.Float64UpDownCounter
+	m      sync.Mutex
+	values map[string]float64 // by label values, joined with NULs
+}
+
+func (g *gauge) Add(v float64, labelValues ...string) {
+	g.m.Lock()
+	g.values[strings.Join(labelValues, "\x00")] += v
+	g.m.Unlock()
+	g.c.Add(context.Background(), v, g.option(labelValues))
+}
+
+func (g *gauge) Set(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\x00")
+	g.m.Lock()
+	delta := v - g.values[key]
+	g.values[key] = v
+	g.m.Unlock()
+	g.c.Add(context.Background(), delta, g.option(labelValues))
+}
+
+type histogram struct {
+	attributes
+	h metric.Float64Histogram
+}
+
+func (h *histogram) Observe(v float64, labelValues ...string) {
+	h.h.Record(context.Background(), v, h.option(labelValues))
+}
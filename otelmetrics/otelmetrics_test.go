@@ -0,0 +1,100 @@
+package otelmetrics
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// collect returns the metrics reader has collected, by name.
+func collect(t *testing.T, reader sdkmetric.Reader) map[string]metricdata.Metrics {
+	rm := metricdata.ResourceMetrics{}
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	metrics := map[string]metricdata.Metrics{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			metrics[m.Name] = m
+		}
+	}
+	return metrics
+}
+
+func TestRegistry(t *testing.T) {
+	t.Parallel()
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	defer mp.Shutdown(context.Background())
+	reg := NewRegistry(mp.Meter("test"))
+
+	c := reg.Counter("requests", "Requests.", "method")
+	c.Add(1, "GET")
+	c.Add(2, "GET")
+	assert.Equal(t, c, reg.Counter("requests", "ignored", "method"), "metrics are shared by name")
+
+	g := reg.Gauge("in_flight", "In flight.", "method")
+	g.Add(3, "GET")
+	g.Add(-1, "GET")
+	g.Set(5, "POST")
+	g.Set(1, "POST")
+
+	h := reg.Histogram("duration", "Durations.", []float64{0.1, 1}, "method")
+	h.Observe(0.05, "GET")
+	h.Observe(0.5, "GET")
+	h.Observe(3, "GET")
+
+	metrics := collect(t, reader)
+	get := attribute.NewSet(attribute.String("method", "GET"))
+	post := attribute.NewSet(attribute.String("method", "POST"))
+
+	sum := metrics["requests"].Data.(metricdata.Sum[float64])
+	assert.Equal(t, "Requests.", metrics["requests"].Description)
+	assert.True(t, sum.IsMonotonic)
+	require.Len(t, sum.DataPoints, 1)
+	assert.Equal(t, get, sum.DataPoints[0].Attributes)
+	assert.Equal(t, 3.0, sum.DataPoints[0].Value)
+
+	sum = metrics["in_flight"].Data.(metricdata.Sum[float64])
+	assert.False(t, sum.IsMonotonic)
+	values := map[attribute.Distinct]float64{}
+	for _, dp := range sum.DataPoints {
+		values[dp.Attributes.Equivalent()] = dp.Value
+	}
+	assert.Equal(t, map[attribute.Distinct]float64{get.Equivalent(): 2, post.Equivalent(): 1}, values)
+
+	hist := metrics["duration"].Data.(metricdata.Histogram[float64])
+	require.Len(t, hist.DataPoints, 1)
+	assert.Equal(t, []float64{0.1, 1}, hist.DataPoints[0].Bounds)
+	assert.Equal(t, []uint64{1, 1, 1}, hist.DataPoints[0].BucketCounts)
+	assert.Equal(t, uint64(3), hist.DataPoints[0].Count)
+}
+
+func TestMeterProvider(t *testing.T) {
+	t.Parallel()
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	defer mp.Shutdown(context.Background())
+	p := NewMeterProvider(mp)
+	assert.Equal(t, p.Meter("a"), p.Meter("a"), "registries are shared by scope")
+
+	// As libhttp's servers record their metrics
+	p.Meter("github.com/4thel00z/libhttp").Histogram("http.server.request.duration", "", nil,
+		"http.response.status_code").Observe(0.1, "200")
+	p.Meter("other").Counter("requests", "").Add(1)
+
+	rm := metricdata.ResourceMetrics{}
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	scopes := map[string][]string{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			scopes[sm.Scope.Name] = append(scopes[sm.Scope.Name], m.Name)
+		}
+	}
+	assert.Equal(t, map[string][]string{
+		"github.com/4thel00z/libhttp": {"http.server.request.duration"},
+		"other":                       {"requests"}}, scopes)
+}
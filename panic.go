@@ -0,0 +1,86 @@
+package libhttp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/monzo/slog"
+	"github.com/monzo/terrors"
+)
+
+// OnPanic registers a function which is called with the recovered value and stack trace of any panic in the server's
+// serving path: filters and handlers, the writing of response bodies, and the server's own background work such as
+// shutdown hooks. This allows crash telemetry to be centralised.
+//
+// Panics in filters and handlers result in a 500 response to the client with a generic message (the recovered value
+// is only passed to hooks and ErrorReporters), rather than the aborted connection net/http gives; WithAbortOnPanic
+// restores that. Panics while writing a response body always abort the connection.
+func (s *Server) OnPanic(f func(ctx context.Context, recovered interface{}, stack []byte)) {
+	s.panicHooksM.Lock()
+	defer s.panicHooksM.Unlock()
+	s.panicHooks = append(s.panicHooks, f)
+}
+
+// panicked reports a recovered panic to the registered hooks and ErrorReporters. r is the request being served, if
+// any, and status the status of the response sent in its place (or 0 if the response had already started).
+func (s *Server) panicked(ctx context.Context, v interface{}, r *http.Request, status int) {
+	stack := debug.Stack()
+	slog.Critical(ctx, "Recovered panic: %v\n%s", v, stack)
+	s.panicHooksM.Lock()
+	hooks := s.panicHooks
+	s.panicHooksM.Unlock()
+	for _, f := range hooks {
+		f(ctx, v, stack)
+	}
+	if r != nil {
+		report := newErrorReport(r, status)
+		report.Error = fmt.Errorf("panic: %v", v)
+		report.Panic = v
+		report.Stack = stack
+		reportError(ctx, report)
+	}
+}
+
+// recoverFilter turns panics in the wrapped Service into 500 responses (or aborted connections, with
+// WithAbortOnPanic), reporting them to the server's panic hooks.
+func (s *Server) recoverFilter(req Request, svc Service) (rsp Response) {
+	defer func() {
+		if v := recover(); v != nil {
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			if s.abortOnPanic {
+				s.panicked(req, v, &req.Request, 0)
+				panic(http.ErrAbortHandler)
+			}
+			s.panicked(req, v, &req.Request, http.StatusInternalServerError)
+			// This filter is outside any the user may have applied, so the error must be serialised here. The panic
+			// value may hold anything, so it isn't sent to the client.
+			err := terrors.InternalService("panic", "Internal server error", nil)
+			rsp = errorFilter(req, func(req Request) Response {
+				rsp := NewResponse(req)
+				rsp.Error = err
+				return rsp
+			}, false)
+		}
+	}()
+	return svc(req)
+}
+
+// recoverHandler recovers panics from the wrapped http.Handler, which may happen after the response has started
+// to be written. They are reported to the server's panic hooks, and the connection is aborted.
+func (s *Server) recoverHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if v := recover(); v != nil {
+				if v != http.ErrAbortHandler {
+					s.panicked(r.Context(), v, r, 0)
+				}
+				panic(http.ErrAbortHandler) // net/http aborts the response without logging this
+			}
+		}()
+		h.ServeHTTP(rw, r)
+	})
+}
@@ -0,0 +1,71 @@
+package libhttp
+
+import (
+	"strconv"
+	"strings"
+
+	uuid "github.com/nu7hatch/gouuid"
+)
+
+// Param returns the value of the named path parameter captured by the Router which dispatched the request, or an
+// empty string if there is none.
+func (r Request) Param(name string) string {
+	if r.Context == nil || r.URL == nil {
+		return ""
+	}
+	router := RouterForRequest(r)
+	if router == nil {
+		return ""
+	}
+	return router.Params(r)[name]
+}
+
+// param returns the value of the named path parameter, or a bad request error if it is missing or empty.
+func (r Request) param(name string) (string, error) {
+	v := r.Param(name)
+	if v == "" {
+		return "", missingParamError("path", name)
+	}
+	return v, nil
+}
+
+// ParamInt returns the value of the named path parameter as an integer. Malformed values result in bad request errors.
+func (r Request) ParamInt(name string) (int, error) {
+	n, err := r.paramInt(name, strconv.IntSize)
+	return int(n), err
+}
+
+// ParamInt64 returns the value of the named path parameter as a 64-bit integer. Malformed values result in bad
+// request errors.
+func (r Request) ParamInt64(name string) (int64, error) {
+	return r.paramInt(name, 64)
+}
+
+func (r Request) paramInt(name string, bits int) (int64, error) {
+	v, err := r.param(name)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseInt(v, 10, bits)
+	if err != nil {
+		return 0, invalidParamError("path", name, "expected integer")
+	}
+	return n, nil
+}
+
+// ParamUUID returns the value of the named path parameter as a UUID, in its canonical hyphenated form (in either case).
+// Malformed values result in bad request errors.
+func (r Request) ParamUUID(name string) (uuid.UUID, error) {
+	v, err := r.param(name)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	if len(v) != 36 {
+		return uuid.UUID{}, invalidParamError("path", name, "expected UUID")
+	}
+	u, err := uuid.ParseHex(strings.ToLower(v))
+	if err != nil {
+		return uuid.UUID{}, invalidParamError("path", name, "expected UUID")
+	}
+	return *u, nil
+}
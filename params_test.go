@@ -0,0 +1,52 @@
+package libhttp
+
+import (
+	"context"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParams(t *testing.T) {
+	t.Parallel()
+
+	router := Router{}
+	router.GET("/users/:id/accounts/:account", func(req Request) Response {
+		id, err := req.ParamInt("id")
+		if err != nil {
+			return Response{Error: err}
+		}
+		account, err := req.ParamUUID("account")
+		if err != nil {
+			return Response{Error: err}
+		}
+		_, err = req.ParamInt64("nope")
+		require.Error(t, err)
+		assert.True(t, terrors.PrefixMatches(err, terrors.ErrBadRequest, "missing_path"))
+		return req.Response(map[string]interface{}{
+			"id":      id,
+			"account": account.String()})
+	})
+	svc := router.Serve().Filter(ErrorFilter)
+
+	rsp := svc(NewRequest(context.Background(), "GET", "/users/42/accounts/6BA7B814-9DAD-11D1-80B4-00C04FD430C8", nil))
+	require.NoError(t, rsp.Error)
+	body := map[string]interface{}{}
+	require.NoError(t, rsp.Decode(&body))
+	assert.Equal(t, 42.0, body["id"])
+	assert.Equal(t, "6ba7b814-9dad-11d1-80b4-00c04fd430c8", body["account"])
+
+	for _, path := range []string{
+		"/users/x/accounts/6ba7b814-9dad-11d1-80b4-00c04fd430c8",
+		"/users/42/accounts/6ba7b814",
+		"/users/42/accounts/{6ba7b814-9dad-11d1-80b4-00c04fd430c8}"} {
+		rsp = svc(NewRequest(context.Background(), "GET", path, nil))
+		assert.Equal(t, 400, rsp.StatusCode, path)
+		assert.True(t, terrors.PrefixMatches(rsp.Error, terrors.ErrBadRequest, "invalid_path"), "%v", rsp.Error)
+	}
+
+	// Outside a router, there are no parameters
+	assert.Equal(t, "", NewRequest(context.Background(), "GET", "/", nil).Param("id"))
+}
@@ -0,0 +1,12 @@
+// +build !windows
+
+package libhttp
+
+import (
+	"errors"
+	"net"
+)
+
+func listenPipe(name string) (net.Listener, error) {
+	return nil, errors.New("libhttp: named pipes are only supported on Windows")
+}
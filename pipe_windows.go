@@ -0,0 +1,308 @@
+package libhttp
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	pipePrefix = `\\.\pipe\`
+
+	pipeAccessDuplex         = 0x3
+	pipeFlagFirstInstance    = 0x00080000
+	pipeFlagOverlapped       = 0x40000000
+	pipeRejectRemoteClients  = 0x8
+	pipeUnlimitedInstances   = 255
+	pipeBufferSize           = 64 * 1024
+	errorPipeConnected       = syscall.Errno(535)
+	errorNoData              = syscall.Errno(232)
+	errorPipeNotConnected    = syscall.Errno(233)
+	pipeDefaultTimeoutMillis = 50
+)
+
+var (
+	modkernel32             = syscall.NewLazyDLL("kernel32.dll")
+	procCreateNamedPipeW    = modkernel32.NewProc("CreateNamedPipeW")
+	procConnectNamedPipe    = modkernel32.NewProc("ConnectNamedPipe")
+	procDisconnectNamedPipe = modkernel32.NewProc("DisconnectNamedPipe")
+	procCreateEventW        = modkernel32.NewProc("CreateEventW")
+	procGetOverlappedResult = modkernel32.NewProc("GetOverlappedResult")
+
+	errPipeListenerClosed = errors.New("libhttp: named pipe listener closed")
+)
+
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+// pipeTimeoutError is returned from I/O operations whose deadline has passed.
+type pipeTimeoutError struct{}
+
+func (pipeTimeoutError) Error() string   { return "i/o timeout" }
+func (pipeTimeoutError) Timeout() bool   { return true }
+func (pipeTimeoutError) Temporary() bool { return true }
+
+func createNamedPipe(name string, first bool) (syscall.Handle, error) {
+	name16, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return syscall.InvalidHandle, err
+	}
+	flags := uint32(pipeAccessDuplex | pipeFlagOverlapped)
+	if first {
+		flags |= pipeFlagFirstInstance
+	}
+	r, _, err := procCreateNamedPipeW.Call(
+		uintptr(unsafe.Pointer(name16)),
+		uintptr(flags),
+		uintptr(pipeRejectRemoteClients),
+		uintptr(pipeUnlimitedInstances),
+		uintptr(pipeBufferSize),
+		uintptr(pipeBufferSize),
+		uintptr(pipeDefaultTimeoutMillis),
+		0)
+	if syscall.Handle(r) == syscall.InvalidHandle {
+		return syscall.InvalidHandle, err
+	}
+	return syscall.Handle(r), nil
+}
+
+// overlappedIO runs an overlapped operation against h, waiting for it to complete. Before waiting, the operation's
+// cancellation is registered with the passed deadline (if non-nil) so that it can be aborted.
+func overlappedIO(h syscall.Handle, d *pipeDeadline, op func(*syscall.Overlapped, *uint32) error) (int, error) {
+	r, _, err := procCreateEventW.Call(0, 1, 0, 0) // manual reset, initially unsignalled
+	if r == 0 {
+		return 0, err
+	}
+	evt := syscall.Handle(r)
+	defer syscall.CloseHandle(evt)
+	ov := &syscall.Overlapped{HEvent: evt}
+
+	if d != nil && !d.begin(func() { syscall.CancelIoEx(h, ov) }) {
+		return 0, pipeTimeoutError{}
+	}
+	var n uint32
+	err = op(ov, &n)
+	if err == syscall.ERROR_IO_PENDING {
+		err = nil
+		if r, _, e := procGetOverlappedResult.Call(uintptr(h), uintptr(unsafe.Pointer(ov)), uintptr(unsafe.Pointer(&n)), 1); r == 0 {
+			err = e
+		}
+	}
+	if d != nil && d.end() && err == syscall.ERROR_OPERATION_ABORTED {
+		return int(n), pipeTimeoutError{}
+	}
+	return int(n), err
+}
+
+// pipeDeadline implements deadlines for overlapped I/O, by cancelling the in-flight operation when they pass.
+type pipeDeadline struct {
+	m      sync.Mutex
+	t      time.Time
+	timer  *time.Timer
+	cancel func() // cancels the in-flight operation, if any
+}
+
+func (d *pipeDeadline) expired() bool {
+	return !d.t.IsZero() && !time.Now().Before(d.t)
+}
+
+func (d *pipeDeadline) set(t time.Time) {
+	d.m.Lock()
+	defer d.m.Unlock()
+	d.t = t
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	if t.IsZero() {
+		return
+	}
+	if d.expired() {
+		if d.cancel != nil {
+			d.cancel()
+		}
+		return
+	}
+	d.timer = time.AfterFunc(time.Until(t), func() {
+		d.m.Lock()
+		defer d.m.Unlock()
+		if d.cancel != nil {
+			d.cancel()
+		}
+	})
+}
+
+// begin registers the cancellation function for an operation, returning false if the deadline has already passed.
+func (d *pipeDeadline) begin(cancel func()) bool {
+	d.m.Lock()
+	defer d.m.Unlock()
+	if d.expired() {
+		return false
+	}
+	d.cancel = cancel
+	return true
+}
+
+// end unregisters the in-flight operation, returning whether the deadline has passed.
+func (d *pipeDeadline) end() bool {
+	d.m.Lock()
+	defer d.m.Unlock()
+	d.cancel = nil
+	return d.expired()
+}
+
+type pipeConn struct {
+	h             syscall.Handle
+	addr          pipeAddr
+	readDeadline  pipeDeadline
+	writeDeadline pipeDeadline
+	closeOnce     sync.Once
+}
+
+func (c *pipeConn) Read(b []byte) (int, error) {
+	n, err := overlappedIO(c.h, &c.readDeadline, func(ov *syscall.Overlapped, n *uint32) error {
+		return syscall.ReadFile(c.h, b, n, ov)
+	})
+	switch err {
+	case nil:
+		if n == 0 && len(b) > 0 {
+			return 0, io.EOF
+		}
+		return n, nil
+	case syscall.ERROR_BROKEN_PIPE, errorPipeNotConnected:
+		return n, io.EOF
+	default:
+		return n, err
+	}
+}
+
+func (c *pipeConn) Write(b []byte) (int, error) {
+	n, err := overlappedIO(c.h, &c.writeDeadline, func(ov *syscall.Overlapped, n *uint32) error {
+		return syscall.WriteFile(c.h, b, n, ov)
+	})
+	if err == syscall.ERROR_BROKEN_PIPE || err == errorNoData {
+		err = io.ErrClosedPipe
+	}
+	return n, err
+}
+
+func (c *pipeConn) Close() error {
+	err := error(nil)
+	c.closeOnce.Do(func() {
+		syscall.CancelIoEx(c.h, nil)
+		syscall.FlushFileBuffers(c.h)
+		procDisconnectNamedPipe.Call(uintptr(c.h))
+		err = syscall.CloseHandle(c.h)
+	})
+	return err
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return c.addr }
+func (c *pipeConn) RemoteAddr() net.Addr { return c.addr }
+
+func (c *pipeConn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *pipeConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *pipeConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
+
+// pipeListener is a net.Listener which accepts connections on a Windows named pipe. Each accepted connection is a new
+// instance of the pipe.
+type pipeListener struct {
+	addr      pipeAddr
+	m         sync.Mutex
+	next      syscall.Handle // the instance that the next client will connect to
+	closed    bool
+	closeOnce sync.Once
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	l.m.Lock()
+	if l.closed {
+		l.m.Unlock()
+		return nil, errPipeListenerClosed
+	}
+	h := l.next
+	l.m.Unlock()
+
+	_, err := overlappedIO(h, nil, func(ov *syscall.Overlapped, _ *uint32) error {
+		r, _, err := procConnectNamedPipe.Call(uintptr(h), uintptr(unsafe.Pointer(ov)))
+		if r != 0 {
+			return nil
+		}
+		return err
+	})
+	if err == errorPipeConnected { // the client connected before we started waiting
+		err = nil
+	}
+
+	l.m.Lock()
+	defer l.m.Unlock()
+	if l.closed {
+		return nil, errPipeListenerClosed
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Create the instance for the next client before handing this one over, so clients never see the pipe missing
+	next, err := createNamedPipe(string(l.addr), false)
+	if err != nil {
+		syscall.CloseHandle(h)
+		l.next = syscall.InvalidHandle
+		l.closed = true
+		return nil, err
+	}
+	l.next = next
+	return &pipeConn{
+		h:    h,
+		addr: l.addr}, nil
+}
+
+func (l *pipeListener) Close() error {
+	l.closeOnce.Do(func() {
+		l.m.Lock()
+		defer l.m.Unlock()
+		l.closed = true
+		if l.next != syscall.InvalidHandle {
+			syscall.CancelIoEx(l.next, nil)
+			syscall.CloseHandle(l.next)
+		}
+	})
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return l.addr
+}
+
+// listenPipe creates a listener on the named pipe with the given name, which may be either a full pipe path
+// (\\.\pipe\name) or just the name.
+func listenPipe(name string) (net.Listener, error) {
+	if !strings.HasPrefix(name, pipePrefix) {
+		name = pipePrefix + name
+	}
+	h, err := createNamedPipe(name, true)
+	if err != nil {
+		return nil, &net.OpError{Op: "listen", Net: "pipe", Addr: pipeAddr(name), Err: err}
+	}
+	return &pipeListener{
+		addr: pipeAddr(name),
+		next: h}, nil
+}
@@ -0,0 +1,55 @@
+package libhttp
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func dialPipe(name string) (net.Conn, error) {
+	name16, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	h, err := syscall.CreateFile(name16, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil, syscall.OPEN_EXISTING,
+		pipeFlagOverlapped, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &pipeConn{
+		h:    h,
+		addr: pipeAddr(name)}, nil
+}
+
+func TestListenPipe(t *testing.T) {
+	t.Parallel()
+
+	hooks := &recordingHooks{}
+	s, err := ListenPipe(Service(func(req Request) Response {
+		return req.Response("pong")
+	}), "libhttp-test", WithServerHooks(hooks))
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+	assert.Equal(t, `\\.\pipe\libhttp-test`, s.Listener().Addr().String())
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialPipe(s.Listener().Addr().String())
+		}}
+	defer transport.CloseIdleConnections()
+	for i := 0; i < 3; i++ {
+		req := NewRequest(context.Background(), "GET", "http://pipe/ping", nil)
+		rsp := req.SendVia(HttpService(transport)).Response()
+		require.NoError(t, rsp.Error)
+		assert.Equal(t, http.StatusOK, rsp.StatusCode)
+		var body string
+		require.NoError(t, rsp.Decode(&body))
+		assert.Equal(t, "pong", body)
+	}
+	assert.Contains(t, hooks.recorded(), "response /ping 200 span-1")
+}
@@ -0,0 +1,172 @@
+package libhttp
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNoProxyRules(t *testing.T) {
+	t.Parallel()
+	rules := parseNoProxy([]string{"example.com", ".internal", "10.0.0.0/8", "192.168.1.1", "api.test:8080", " "})
+	cases := map[string]bool{
+		"http://example.com/":          true,
+		"http://www.example.com/":      true,
+		"http://notexample.com/":       false,
+		"https://svc.internal/":        true,
+		"http://internal.com/":         false,
+		"http://10.1.2.3/":             true,
+		"http://11.1.2.3/":             false,
+		"http://192.168.1.1:9000/":     true,
+		"http://api.test:8080/":        true,
+		"http://api.test/":             false,
+		"http://EXAMPLE.com/":          true,
+		"http://[::1]/":                false,
+		"https://example.com:443/path": true}
+	for raw, want := range cases {
+		u, err := url.Parse(raw)
+		require.NoError(t, err)
+		assert.Equal(t, want, rules.match(u), raw)
+	}
+	u, _ := url.Parse("http://anything/")
+	assert.True(t, parseNoProxy([]string{"*"}).match(u))
+	assert.False(t, parseNoProxy(nil).match(u))
+}
+
+func TestWithProxy(t *testing.T) {
+	t.Parallel()
+	target, err := Listen(Service(func(req Request) Response {
+		return req.Response("direct")
+	}), "localhost:0")
+	require.NoError(t, err)
+	defer target.Stop(context.Background())
+
+	// The "proxy" responds itself, with what it was asked for
+	proxy, err := Listen(Service(func(req Request) Response {
+		return req.Response(map[string]string{
+			"uri":  req.RequestURI,
+			"auth": req.Header.Get("Proxy-Authorization")})
+	}), "localhost:0")
+	require.NoError(t, err)
+	defer proxy.Stop(context.Background())
+
+	proxyURL, _ := url.Parse("http://user:pass@" + proxy.Listener().Addr().String())
+	client := NewClient(
+		WithRoundTripper(&http.Transport{}),
+		WithProxy(proxyURL, "localhost"))
+
+	rsp := NewRequest(context.Background(), "GET", "http://upstream.test/path", nil).SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+	body := map[string]string{}
+	require.NoError(t, rsp.Decode(&body))
+	assert.Equal(t, map[string]string{
+		"uri":  "http://upstream.test/path",
+		"auth": "Basic dXNlcjpwYXNz"}, body)
+
+	// Hosts matching the no-proxy rules are requested directly
+	targetURL := "http://" + target.Listener().Addr().String()
+	_, port, _ := net.SplitHostPort(target.Listener().Addr().String())
+	rsp = NewRequest(context.Background(), "GET", "http://localhost:"+port, nil).SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+	var direct string
+	require.NoError(t, rsp.Decode(&direct))
+	assert.Equal(t, "direct", direct)
+
+	// SOCKS5 proxies
+	socks, conns := newTestSOCKS5(t, "user", "pass")
+	defer socks.Close()
+	socksURL, _ := url.Parse("socks5://user:pass@" + socks.Addr().String())
+	client = NewClient(
+		WithRoundTripper(&http.Transport{}),
+		WithProxy(socksURL))
+	rsp = NewRequest(context.Background(), "GET", targetURL, nil).SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+	require.NoError(t, rsp.Decode(&direct))
+	assert.Equal(t, "direct", direct)
+	assert.EqualValues(t, 1, atomic.LoadInt32(conns))
+}
+
+// newTestSOCKS5 returns a listener serving a minimal SOCKS5 proxy, which requires the passed credentials and only
+// supports CONNECT. The number of connections it has accepted is also returned.
+func newTestSOCKS5(t *testing.T, user, pass string) (net.Listener, *int32) {
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	conns := new(int32)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(conns, 1)
+			go serveTestSOCKS5(t, c, user, pass)
+		}
+	}()
+	return l, conns
+}
+
+func serveTestSOCKS5(t *testing.T, c net.Conn, user, pass string) {
+	defer c.Close()
+	read := func(n int) []byte {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(c, b); err != nil {
+			return nil
+		}
+		return b
+	}
+	// Greeting: require username/password authentication
+	hdr := read(2)
+	if hdr == nil || read(int(hdr[1])) == nil {
+		return
+	}
+	c.Write([]byte{5, 2})
+	// Credentials
+	ver := read(2)
+	if ver == nil {
+		return
+	}
+	u := string(read(int(ver[1])))
+	pl := read(1)
+	if pl == nil {
+		return
+	}
+	p := string(read(int(pl[0])))
+	if u != user || p != pass {
+		c.Write([]byte{1, 1})
+		return
+	}
+	c.Write([]byte{1, 0})
+	// Request
+	req := read(4)
+	if req == nil || req[1] != 1 {
+		return
+	}
+	var host string
+	switch req[3] {
+	case 1:
+		host = net.IP(read(4)).String()
+	case 3:
+		host = string(read(int(read(1)[0])))
+	case 4:
+		host = net.IP(read(16)).String()
+	}
+	port := binary.BigEndian.Uint16(read(2))
+	upstream, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+	if !assert.NoError(t, err) {
+		c.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+	c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	go io.Copy(upstream, c)
+	io.Copy(c, upstream)
+}
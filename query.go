@@ -0,0 +1,214 @@
+package libhttp
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/monzo/terrors"
+)
+
+// Query provides typed access to a request's query parameters. Getters which fail (because a value is malformed, or a
+// required parameter is missing) return a zero value and record an error, which is returned by Err; the first error
+// is kept. This allows parameters to be read together and checked once:
+//
+//  q := req.Query()
+//  limit := q.Int("limit", 20)
+//  since := q.RequiredTime("since", time.RFC3339)
+//  tags := q.StringSlice("tag")
+//  if err := q.Err(); err != nil {
+//      return libhttp.Response{Error: err} // a 400 once ErrorFilter has serialised it
+//  }
+//
+// Errors are bad requests with the same codes as those returned by Bind.
+type Query struct {
+	values url.Values
+	err    error
+}
+
+// Query returns typed accessors for the request's query parameters.
+func (r Request) Query() *Query {
+	q := &Query{
+		values: url.Values{}}
+	if r.URL != nil {
+		q.values = r.URL.Query()
+	}
+	return q
+}
+
+// Err returns the first error encountered by a getter, or nil.
+func (q *Query) Err() error {
+	return q.err
+}
+
+// Has returns whether the parameter is present (even if empty).
+func (q *Query) Has(name string) bool {
+	_, ok := q.values[name]
+	return ok
+}
+
+// lookup returns the value of the parameter, recording an error if it is required but missing.
+func (q *Query) lookup(name string, required bool) (string, bool) {
+	if v := q.values.Get(name); v != "" {
+		return v, true
+	}
+	if required {
+		q.fail(missingParamError("query", name))
+	}
+	return "", false
+}
+
+func (q *Query) fail(err error) {
+	if q.err == nil {
+		q.err = err
+	}
+}
+
+// String returns the value of the parameter, or def if it is missing or empty.
+func (q *Query) String(name, def string) string {
+	if v, ok := q.lookup(name, false); ok {
+		return v
+	}
+	return def
+}
+
+// RequiredString returns the value of the parameter, which must not be missing or empty.
+func (q *Query) RequiredString(name string) string {
+	v, _ := q.lookup(name, true)
+	return v
+}
+
+func (q *Query) int64(name string, bits int, def int64, required bool) int64 {
+	s, ok := q.lookup(name, required)
+	if !ok {
+		return def
+	}
+	n, err := strconv.ParseInt(s, 10, bits)
+	if err != nil {
+		q.fail(invalidParamError("query", name, "expected integer"))
+		return def
+	}
+	return n
+}
+
+// Int returns the value of the parameter as an integer, or def if it is missing or empty.
+func (q *Query) Int(name string, def int) int {
+	return int(q.int64(name, strconv.IntSize, int64(def), false))
+}
+
+// RequiredInt returns the value of the parameter as an integer; it must be present.
+func (q *Query) RequiredInt(name string) int {
+	return int(q.int64(name, strconv.IntSize, 0, true))
+}
+
+// Int64 returns the value of the parameter as a 64-bit integer, or def if it is missing or empty.
+func (q *Query) Int64(name string, def int64) int64 {
+	return q.int64(name, 64, def, false)
+}
+
+// RequiredInt64 returns the value of the parameter as a 64-bit integer; it must be present.
+func (q *Query) RequiredInt64(name string) int64 {
+	return q.int64(name, 64, 0, true)
+}
+
+func (q *Query) float(name string, def float64, required bool) float64 {
+	s, ok := q.lookup(name, required)
+	if !ok {
+		return def
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		q.fail(invalidParamError("query", name, "expected number"))
+		return def
+	}
+	return f
+}
+
+// Float returns the value of the parameter as a number, or def if it is missing or empty.
+func (q *Query) Float(name string, def float64) float64 {
+	return q.float(name, def, false)
+}
+
+// RequiredFloat returns the value of the parameter as a number; it must be present.
+func (q *Query) RequiredFloat(name string) float64 {
+	return q.float(name, 0, true)
+}
+
+func (q *Query) bool(name string, def, required bool) bool {
+	s, ok := q.lookup(name, required)
+	if !ok {
+		return def
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		q.fail(invalidParamError("query", name, "expected boolean"))
+		return def
+	}
+	return b
+}
+
+// Bool returns the value of the parameter as a boolean (as accepted by strconv.ParseBool), or def if it is missing or
+// empty.
+func (q *Query) Bool(name string, def bool) bool {
+	return q.bool(name, def, false)
+}
+
+// RequiredBool returns the value of the parameter as a boolean; it must be present.
+func (q *Query) RequiredBool(name string) bool {
+	return q.bool(name, false, true)
+}
+
+func (q *Query) time(name, layout string, def time.Time, required bool) time.Time {
+	s, ok := q.lookup(name, required)
+	if !ok {
+		return def
+	}
+	t, err := time.Parse(layout, s)
+	if err != nil {
+		q.fail(invalidParamError("query", name, fmt.Sprintf("expected time in the format %s", layout)))
+		return def
+	}
+	return t
+}
+
+// Time returns the value of the parameter as a time in the passed layout (see time.Parse), or def if it is missing or
+// empty.
+func (q *Query) Time(name, layout string, def time.Time) time.Time {
+	return q.time(name, layout, def, false)
+}
+
+// RequiredTime returns the value of the parameter as a time in the passed layout; it must be present.
+func (q *Query) RequiredTime(name, layout string) time.Time {
+	return q.time(name, layout, time.Time{}, true)
+}
+
+// StringSlice returns all the values of the parameter, which may be repeated (?tag=a&tag=b), or nil if there are none.
+func (q *Query) StringSlice(name string) []string {
+	return q.values[name]
+}
+
+// RequiredStringSlice returns all the values of the parameter, of which there must be at least one.
+func (q *Query) RequiredStringSlice(name string) []string {
+	vs := q.values[name]
+	if len(vs) == 0 {
+		q.fail(missingParamError("query", name))
+	}
+	return vs
+}
+
+// missingParamError returns a bad request error for a required parameter which is missing.
+func missingParamError(in, name string) error {
+	return terrors.BadRequest("missing_"+in, fmt.Sprintf("Missing %s parameter %q", in, name),
+		map[string]string{
+			"in":    in,
+			"param": name})
+}
+
+// invalidParamError returns a bad request error for a parameter whose value is malformed, in the same form as Bind's.
+func invalidParamError(in, name, reason string) error {
+	return terrors.BadRequest("invalid_"+in, fmt.Sprintf("Invalid %s parameter %q: %s", in, name, reason),
+		map[string]string{
+			"in":    in,
+			"param": name})
+}
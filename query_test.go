@@ -0,0 +1,60 @@
+package libhttp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuery(t *testing.T) {
+	t.Parallel()
+
+	req := NewRequest(context.Background(), "GET",
+		"/?limit=50&big=9000000000&ratio=0.5&debug=true&since=2020-01-02T03:04:05Z&tag=a&tag=b&empty=", nil)
+	q := req.Query()
+	assert.Equal(t, 50, q.Int("limit", 20))
+	assert.Equal(t, 20, q.Int("missing", 20))
+	assert.Equal(t, 20, q.Int("empty", 20))
+	assert.Equal(t, int64(9000000000), q.RequiredInt64("big"))
+	assert.Equal(t, 0.5, q.RequiredFloat("ratio"))
+	assert.True(t, q.Bool("debug", false))
+	assert.Equal(t, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), q.RequiredTime("since", time.RFC3339))
+	assert.Equal(t, []string{"a", "b"}, q.StringSlice("tag"))
+	assert.Nil(t, q.StringSlice("missing"))
+	assert.Equal(t, "x", q.String("missing", "x"))
+	assert.True(t, q.Has("empty"))
+	assert.False(t, q.Has("missing"))
+	assert.NoError(t, q.Err())
+
+	// The first error is kept
+	q = req.Query()
+	assert.Equal(t, 0, q.RequiredInt("missing"))
+	assert.Equal(t, 1.0, q.Float("debug", 1))
+	err := q.Err()
+	require.Error(t, err)
+	assert.True(t, terrors.PrefixMatches(err, terrors.ErrBadRequest, "missing_query"), "%v", err)
+	assert.Equal(t, "missing", err.(*terrors.Error).Params["param"])
+
+	q = req.Query()
+	q.Time("limit", time.RFC3339, time.Time{})
+	q.RequiredStringSlice("missing")
+	assert.True(t, terrors.PrefixMatches(q.Err(), terrors.ErrBadRequest, "invalid_query"), "%v", q.Err())
+
+	// Errors become 400s via ErrorFilter
+	svc := Service(func(req Request) Response {
+		q := req.Query()
+		n := q.RequiredInt("n")
+		if err := q.Err(); err != nil {
+			return Response{Error: err}
+		}
+		return req.Response(n)
+	}).Filter(ErrorFilter)
+	rsp := svc(NewRequest(context.Background(), "GET", "/?n=abc", nil))
+	assert.Equal(t, 400, rsp.StatusCode)
+	rsp = svc(NewRequest(context.Background(), "GET", "/?n=3", nil))
+	assert.Equal(t, 200, rsp.StatusCode)
+}
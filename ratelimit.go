@@ -0,0 +1,278 @@
+package libhttp
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// tokenBucket is a token bucket rate limiter. It is safe for concurrent use.
+type tokenBucket struct {
+	m      sync.Mutex
+	rate   float64 // tokens added per second
+	burst  float64 // maximum tokens held
+	tokens float64 // may go negative, when callers have reserved tokens in advance
+	last   time.Time
+}
+
+// newTokenBucket returns a bucket which admits rate tokens per second, and which starts off (and is capped at) burst
+// tokens.
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now()}
+}
+
+// refill adds the tokens accrued since the bucket was last used. b.m must be held.
+func (b *tokenBucket) refill() {
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// take takes n tokens from the bucket if they are available, returning whether it did. Unlike reserve, it never puts
+// the bucket into debt.
+func (b *tokenBucket) take(n int) bool {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.refill()
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// reserve takes n tokens from the bucket, returning how long the caller must wait before acting on them.
+func (b *tokenBucket) reserve(n int) time.Duration {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.refill()
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// unreserve returns n tokens which were reserved but not used.
+func (b *tokenBucket) unreserve(n int) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.tokens += float64(n)
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// wait takes n tokens from the bucket, blocking until they are available or the context expires.
+func (b *tokenBucket) wait(ctx context.Context, n int) error {
+	return waitBuckets(ctx, []*tokenBucket{b}, n)
+}
+
+// waitBuckets takes n tokens from each of the buckets, blocking until they are all available or the context expires,
+// in which case they are returned. They are reserved from all the buckets up front, so this waits for the slowest of
+// them rather than for each in turn.
+func waitBuckets(ctx context.Context, buckets []*tokenBucket, n int) error {
+	var d time.Duration
+	for _, b := range buckets {
+		if bd := b.reserve(n); bd > d {
+			d = bd
+		}
+	}
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		for _, b := range buckets {
+			b.unreserve(n)
+		}
+		return ctx.Err()
+	}
+}
+
+// BandwidthLimits configure the rate at which data may be transferred over a server's connections, in bytes per
+// second. A zero value means no limit.
+type BandwidthLimits struct {
+	// ReadBytesPerSecond and WriteBytesPerSecond limit each individual connection
+	ReadBytesPerSecond  int
+	WriteBytesPerSecond int
+	// AggregateReadBytesPerSecond and AggregateWriteBytesPerSecond limit all connections on the listener combined
+	AggregateReadBytesPerSecond  int
+	AggregateWriteBytesPerSecond int
+}
+
+// bandwidthBucket returns a bucket enforcing the passed rate, or nil if the rate is unlimited. The bucket's burst is a
+// quarter of a second's worth of transfer, which keeps transfer smooth without making I/O too fine-grained.
+func bandwidthBucket(bytesPerSecond int) *tokenBucket {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	burst := bytesPerSecond / 4
+	if burst < 1024 {
+		burst = 1024
+	}
+	return newTokenBucket(float64(bytesPerSecond), burst)
+}
+
+// rateLimitedListener wraps the connections accepted by a listener in rateLimitedConns.
+type rateLimitedListener struct {
+	net.Listener
+	limits            BandwidthLimits
+	aggRead, aggWrite *tokenBucket
+}
+
+// RateLimitListener wraps the passed listener so that all connections it accepts are subject to the passed bandwidth
+// limits. Servers can also be configured to do this with WithBandwidthLimits.
+func RateLimitListener(l net.Listener, limits BandwidthLimits) net.Listener {
+	return &rateLimitedListener{
+		Listener: l,
+		limits:   limits,
+		aggRead:  bandwidthBucket(limits.AggregateReadBytesPerSecond),
+		aggWrite: bandwidthBucket(limits.AggregateWriteBytesPerSecond)}
+}
+
+func (l *rateLimitedListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return newRateLimitedConn(c,
+		[]*tokenBucket{bandwidthBucket(l.limits.ReadBytesPerSecond), l.aggRead},
+		[]*tokenBucket{bandwidthBucket(l.limits.WriteBytesPerSecond), l.aggWrite}), nil
+}
+
+// rateLimitedConn is a net.Conn whose reads and writes are throttled by token buckets, in which each token represents
+// a byte. Throttling gives up at the conn's deadlines, or when it is closed.
+type rateLimitedConn struct {
+	net.Conn
+	read, write []*tokenBucket
+	chunk       int // maximum bytes to transfer at once, so a single large write doesn't drain buckets far below zero
+	ctx         context.Context
+	cancel      context.CancelFunc
+	deadlinesM  sync.Mutex
+	readDL      time.Time // guarded by deadlinesM
+	writeDL     time.Time // guarded by deadlinesM
+}
+
+func newRateLimitedConn(c net.Conn, read, write []*tokenBucket) *rateLimitedConn {
+	nonNil := func(bs []*tokenBucket) []*tokenBucket {
+		out := bs[:0]
+		for _, b := range bs {
+			if b != nil {
+				out = append(out, b)
+			}
+		}
+		return out
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	rc := &rateLimitedConn{
+		Conn:   c,
+		read:   nonNil(read),
+		write:  nonNil(write),
+		chunk:  32 * 1024,
+		ctx:    ctx,
+		cancel: cancel}
+	for _, b := range append(append([]*tokenBucket(nil), rc.read...), rc.write...) {
+		if int(b.burst) < rc.chunk {
+			rc.chunk = int(b.burst)
+		}
+	}
+	return rc
+}
+
+// throttle takes n tokens from each of the buckets, waiting until they are available, the passed deadline (if it is
+// not zero) passes, or the conn is closed.
+func (c *rateLimitedConn) throttle(buckets []*tokenBucket, n int, deadline time.Time) error {
+	ctx := c.ctx
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	return waitBuckets(ctx, buckets, n)
+}
+
+func (c *rateLimitedConn) deadlines() (read, write time.Time) {
+	c.deadlinesM.Lock()
+	defer c.deadlinesM.Unlock()
+	return c.readDL, c.writeDL
+}
+
+func (c *rateLimitedConn) Read(p []byte) (int, error) {
+	if len(c.read) == 0 {
+		return c.Conn.Read(p)
+	}
+	if len(p) > c.chunk {
+		p = p[:c.chunk]
+	}
+	n, err := c.Conn.Read(p)
+	// The bytes have already been read, so they're returned even if throttling is cut short; the next read will see
+	// the deadline or closure itself
+	readDL, _ := c.deadlines()
+	c.throttle(c.read, n, readDL)
+	return n, err
+}
+
+func (c *rateLimitedConn) Write(p []byte) (int, error) {
+	if len(c.write) == 0 {
+		return c.Conn.Write(p)
+	}
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > c.chunk {
+			chunk = chunk[:c.chunk]
+		}
+		_, writeDL := c.deadlines()
+		if err := c.throttle(c.write, len(chunk), writeDL); err == context.DeadlineExceeded {
+			return written, os.ErrDeadlineExceeded
+		}
+		// Otherwise throttling either succeeded, or the conn was closed, which writing will report
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (c *rateLimitedConn) SetDeadline(t time.Time) error {
+	c.deadlinesM.Lock()
+	c.readDL, c.writeDL = t, t
+	c.deadlinesM.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *rateLimitedConn) SetReadDeadline(t time.Time) error {
+	c.deadlinesM.Lock()
+	c.readDL = t
+	c.deadlinesM.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *rateLimitedConn) SetWriteDeadline(t time.Time) error {
+	c.deadlinesM.Lock()
+	c.writeDL = t
+	c.deadlinesM.Unlock()
+	return c.Conn.SetWriteDeadline(t)
+}
+
+func (c *rateLimitedConn) Close() error {
+	c.cancel()
+	return c.Conn.Close()
+}
@@ -0,0 +1,112 @@
+package libhttp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBucket(t *testing.T) {
+	t.Parallel()
+
+	b := newTokenBucket(100, 10)
+	assert.Zero(t, b.reserve(10))
+	// The bucket is now empty, so 5 more tokens should take ~50ms to arrive
+	d := b.reserve(5)
+	assert.InDelta(t, float64(50*time.Millisecond), float64(d), float64(5*time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, b.wait(ctx, 100))
+	// The tokens a cancelled wait reserved are returned, so the bucket is still only 5 tokens in debt
+	d = b.reserve(5)
+	assert.InDelta(t, float64(100*time.Millisecond), float64(d), float64(10*time.Millisecond))
+}
+
+func TestRateLimitedConnThrottle(t *testing.T) {
+	t.Parallel()
+
+	slow := newTokenBucket(100, 10)
+	slow.reserve(10)
+	agg := newTokenBucket(1, 5)
+	c := newRateLimitedConn(nil, nil, nil)
+	done := make(chan error, 1)
+	go func() { done <- c.throttle([]*tokenBucket{slow, agg}, 5, time.Time{}) }()
+	// While it waits for the slow bucket, the tokens are already reserved from the other one too
+	time.Sleep(20 * time.Millisecond)
+	assert.False(t, agg.take(1))
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("throttle didn't return")
+	}
+
+	// Giving up returns the tokens to all the buckets
+	slow.reserve(100)
+	agg = newTokenBucket(1, 5)
+	go func() { done <- c.throttle([]*tokenBucket{slow, agg}, 5, time.Time{}) }()
+	time.Sleep(20 * time.Millisecond)
+	c.cancel()
+	assert.Equal(t, context.Canceled, <-done)
+	assert.True(t, agg.take(5))
+}
+
+func TestBandwidthLimits(t *testing.T) {
+	t.Parallel()
+
+	body := bytes.Repeat([]byte("x"), 64*1024)
+	s, err := Listen(Service(func(req Request) Response {
+		return req.Response(bytes.NewReader(body))
+	}), "localhost:0", WithBandwidthLimits(BandwidthLimits{
+		WriteBytesPerSecond: 128 * 1024}))
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+
+	start := time.Now()
+	rsp := NewRequest(context.Background(), "GET", fmt.Sprintf("http://%s", s.Listener().Addr()), nil).
+		SendVia(BareClient).Response()
+	require.NoError(t, rsp.Error)
+	b, err := rsp.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, body, b)
+	// 64KiB at 128KiB/s, less the initial burst of 32KiB, should take at least 250ms
+	assert.True(t, time.Since(start) >= 200*time.Millisecond, "transfer took %v", time.Since(start))
+}
+
+func TestRateLimitedConnDeadlinesAndClose(t *testing.T) {
+	t.Parallel()
+
+	client, server := net.Pipe()
+	defer server.Close()
+	go io.Copy(ioutil.Discard, server)
+	// At 1KiB/s, writing 10KiB would take around 9s were throttling not cut short
+	c := newRateLimitedConn(client, nil, []*tokenBucket{newTokenBucket(1024, 1024)})
+	payload := bytes.Repeat([]byte("x"), 10*1024)
+
+	require.NoError(t, c.SetWriteDeadline(time.Now().Add(100*time.Millisecond)))
+	start := time.Now()
+	_, err := c.Write(payload)
+	assert.True(t, errors.Is(err, os.ErrDeadlineExceeded), "%v", err)
+	assert.True(t, time.Since(start) < time.Second, "write took %v", time.Since(start))
+
+	require.NoError(t, c.SetWriteDeadline(time.Time{}))
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		c.Close()
+	}()
+	start = time.Now()
+	_, err = c.Write(payload)
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) < time.Second, "write took %v", time.Since(start))
+}
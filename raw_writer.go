@@ -0,0 +1,125 @@
+package libhttp
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// rawWriter wraps the http.ResponseWriter of a request being served by HttpHandler, recording the status of the final
+// response written through it (if any) so that HttpHandler doesn't write another, and so that it can be reported.
+type rawWriter struct {
+	http.ResponseWriter
+	status int32 // atomic: 0 until a final response has been written
+}
+
+func (w *rawWriter) WriteHeader(status int) {
+	if status >= 200 { // informational responses don't commit the response
+		atomic.CompareAndSwapInt32(&w.status, 0, int32(status))
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *rawWriter) Write(b []byte) (int, error) {
+	atomic.CompareAndSwapInt32(&w.status, 0, http.StatusOK)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *rawWriter) Flush() {
+	atomic.CompareAndSwapInt32(&w.status, 0, http.StatusOK)
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// writtenStatus returns the status of the final response written, or 0 if none has been.
+func (w *rawWriter) writtenStatus() int {
+	if w == nil {
+		return 0
+	}
+	return int(atomic.LoadInt32(&w.status))
+}
+
+func (w *rawWriter) isWritten() bool {
+	return w.writtenStatus() != 0
+}
+
+// ResponseWriter returns the http.ResponseWriter for the request, for things libhttp's own abstractions don't cover.
+// It is only available for requests being served by HttpHandler, and can only be used until the Service returns.
+//
+// Headers set with it are sent along with those of the returned Response (which take precedence), as long as nothing
+// is written with it. Once a status or any of the body has been written (or flushed) through it, the response has been
+// committed: the Response the Service returns is ignored, apart from its body being closed, so the Service should
+// write the whole response itself.
+//
+// The server's own metrics, meters and hooks then record the status which was written, rather than that of the
+// returned Response. The Service's own filters (AccessLogFilter, say) only see the returned Response, so it should be
+// the one WrittenResponse describes.
+func (r Request) ResponseWriter() (http.ResponseWriter, bool) {
+	if r.rw == nil {
+		return nil, false
+	}
+	return r.rw, true
+}
+
+// WrittenResponse returns a Response describing the one written through the request's ResponseWriter, with the status
+// and headers which were sent and an empty body, or false if none has been written. Services which write their
+// responses themselves return it so that filters (which record metrics or logs, say) see what was sent:
+//
+//  rw.WriteHeader(http.StatusAccepted)
+//  ...
+//  rsp, _ := req.WrittenResponse()
+//  return rsp
+func (r Request) WrittenResponse() (Response, bool) {
+	status := r.rw.writtenStatus()
+	if status == 0 {
+		return Response{}, false
+	}
+	rsp := NewResponse(r)
+	rsp.StatusCode = status
+	rsp.Header = r.rw.Header().Clone()
+	return rsp, true
+}
+
+// writtenResponseFilter makes the Response returned for a request whose response was written through its
+// ResponseWriter reflect the status which was sent, for the filters a server wraps Services in.
+func writtenResponseFilter(req Request, svc Service) Response {
+	rsp := svc(req)
+	if rsp.hijacked {
+		return rsp
+	}
+	if status := req.rw.writtenStatus(); status != 0 && (rsp.Response == nil || rsp.StatusCode != status) {
+		written, _ := req.WrittenResponse()
+		if rsp.Response != nil && rsp.Body != nil {
+			rsp.Body.Close()
+		}
+		if rsp.Request != nil {
+			written.Request = rsp.Request
+		}
+		written.Error = rsp.Error
+		return written
+	}
+	return rsp
+}
+
+// Flusher returns an http.Flusher for the request's ResponseWriter, which commits the response (see ResponseWriter)
+// and sends whatever has been written to the client. Streaming bodies are flushed automatically, so this is rarely
+// needed.
+func (r Request) Flusher() (http.Flusher, bool) {
+	if r.rw == nil {
+		return nil, false
+	}
+	if _, ok := r.rw.ResponseWriter.(http.Flusher); !ok {
+		return nil, false
+	}
+	return r.rw, true
+}
+
+// Pusher returns an http.Pusher for the request, if it is being served over HTTP/2 with server push enabled by the
+// client. Pushing doesn't commit the response, so Services may push resources and then return a Response as usual.
+func (r Request) Pusher() (http.Pusher, bool) {
+	if r.rw == nil {
+		return nil, false
+	}
+	p, ok := r.rw.ResponseWriter.(http.Pusher)
+	return p, ok
+}
@@ -0,0 +1,108 @@
+package libhttp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/4thel00z/libhttp/libhttpmetrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRawResponseWriter(t *testing.T) {
+	t.Parallel()
+
+	svc := Service(func(req Request) Response {
+		rw, ok := req.ResponseWriter()
+		if !ok {
+			return Response{Error: fmt.Errorf("no writer")}
+		}
+		rw.Header().Set("X-Raw", "1")
+		switch req.URL.Path {
+		case "/direct":
+			rw.WriteHeader(http.StatusAccepted)
+			fmt.Fprint(rw, "written directly")
+			f, ok := req.Flusher()
+			if !ok {
+				panic("no flusher")
+			}
+			f.Flush()
+			return req.Response("ignored")
+		case "/described":
+			fmt.Fprint(rw, "written directly")
+			rsp, ok := req.WrittenResponse()
+			if !ok {
+				panic("not written")
+			}
+			return rsp
+		default:
+			if _, ok := req.Pusher(); ok {
+				panic("push over HTTP/1")
+			}
+			rsp := req.Response("returned")
+			rsp.Header.Set("X-Rsp", "1")
+			return rsp
+		}
+	})
+	logged := make(chan Response, 3)
+	svc = svc.Filter(func(req Request, svc Service) Response {
+		rsp := svc(req)
+		logged <- rsp
+		return rsp
+	}).Filter(ErrorFilter)
+	reg := libhttpmetrics.NewPrometheus()
+	s, err := Listen(svc, "localhost:0", WithServerMetricsRegistry(reg))
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+	client := HttpService(&http.Transport{})
+
+	rsp := NewRequest(context.Background(), "GET", "http://"+s.Listener().Addr().String()+"/direct", nil).
+		SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusAccepted, rsp.StatusCode)
+	assert.Equal(t, "1", rsp.Header.Get("X-Raw"))
+	b, _ := rsp.BodyBytes(true)
+	assert.Equal(t, "written directly", string(b))
+
+	// Headers set on the raw writer are sent along with the Response's
+	rsp = NewRequest(context.Background(), "GET", "http://"+s.Listener().Addr().String()+"/returned", nil).
+		SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, "1", rsp.Header.Get("X-Raw"))
+	assert.Equal(t, "1", rsp.Header.Get("X-Rsp"))
+	body := ""
+	require.NoError(t, rsp.Decode(&body))
+	assert.Equal(t, "returned", body)
+
+	// Filters see what was written if the Service returns WrittenResponse, and the server's metrics regardless
+	rsp = NewRequest(context.Background(), "GET", "http://"+s.Listener().Addr().String()+"/described", nil).
+		SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	b, _ = rsp.BodyBytes(true)
+	assert.Equal(t, "written directly", string(b))
+	<-logged
+	<-logged
+	described := <-logged
+	assert.Equal(t, http.StatusOK, described.StatusCode)
+	assert.Equal(t, "1", described.Header.Get("X-Raw"))
+	buf := &bytes.Buffer{}
+	_, err = reg.WriteTo(buf)
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), `http_server_requests_total{method="GET",route="",code="202"} 1`)
+	assert.Contains(t, buf.String(), `http_server_requests_total{method="GET",route="",code="200"} 2`)
+
+	// Outside of HttpHandler
+	req := NewRequest(context.Background(), "GET", "/", nil)
+	_, ok := req.ResponseWriter()
+	assert.False(t, ok)
+	_, ok = req.WrittenResponse()
+	assert.False(t, ok)
+	_, ok = req.Flusher()
+	assert.False(t, ok)
+	_, ok = req.Pusher()
+	assert.False(t, ok)
+}
@@ -0,0 +1,54 @@
+package libhttp
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/monzo/terrors"
+)
+
+// Redirect returns a response redirecting the client to location, which may be relative to the request's path, with
+// the passed status code: one of 301 (Moved Permanently), 302 (Found), 303 (See Other), 307 (Temporary Redirect) or
+// 308 (Permanent Redirect). The Location header and a short HTML body are produced in the manner of http.Redirect.
+func (r Request) Redirect(location string, code int) Response {
+	rsp := r.Response(nil)
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect,
+		http.StatusPermanentRedirect:
+	default:
+		rsp.Error = terrors.InternalService("redirect_status", fmt.Sprintf("%d is not a redirect status", code), nil)
+		return rsp
+	}
+	httpReq := r.Request
+	if httpReq.URL == nil {
+		httpReq.URL = &url.URL{
+			Path: "/"}
+	}
+	http.Redirect(rsp.Writer(), &httpReq, location, code)
+	return rsp
+}
+
+// RedirectToRoute returns a response redirecting the client to the route with the passed name, on the Router which
+// dispatched the request, with the passed path parameters (given as name, value pairs; see Router.URL). Requests
+// using GET or HEAD are redirected with 302 (Found), and those with other methods with 303 (See Other), so that the
+// client follows the redirect with a GET.
+func (r Request) RedirectToRoute(name string, params ...string) Response {
+	router := RouterForRequest(r)
+	if router == nil {
+		rsp := r.Response(nil)
+		rsp.Error = terrors.InternalService("redirect_route", "Request wasn't dispatched by a Router", nil)
+		return rsp
+	}
+	location, err := router.URL(name, params...)
+	if err != nil {
+		rsp := r.Response(nil)
+		rsp.Error = terrors.InternalService("redirect_route", err.Error(), nil)
+		return rsp
+	}
+	code := http.StatusSeeOther
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		code = http.StatusFound
+	}
+	return r.Redirect(location, code)
+}
@@ -0,0 +1,76 @@
+package libhttp
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedirect(t *testing.T) {
+	t.Parallel()
+
+	for _, code := range []int{301, 302, 303, 307, 308} {
+		req := NewRequest(context.Background(), "GET", "http://example.com/a/b", nil)
+		rsp := req.Redirect("c?d=1", code)
+		require.NoError(t, rsp.Error)
+		assert.Equal(t, code, rsp.StatusCode)
+		assert.Equal(t, "/a/c?d=1", rsp.Header.Get("Location"))
+		assert.Equal(t, "text/html; charset=utf-8", rsp.Header.Get("Content-Type"))
+		b, _ := rsp.BodyBytes(true)
+		assert.Contains(t, string(b), `<a href="/a/c?d=1">`)
+	}
+
+	req := NewRequest(context.Background(), "POST", "http://example.com/", nil)
+	rsp := req.Redirect("https://example.org/", http.StatusTemporaryRedirect)
+	assert.Equal(t, "https://example.org/", rsp.Header.Get("Location"))
+
+	rsp = req.Redirect("/", http.StatusOK)
+	assert.True(t, terrors.Is(rsp.Error, terrors.ErrInternalService))
+}
+
+func TestRedirectToRoute(t *testing.T) {
+	t.Parallel()
+
+	router := Router{}
+	router.RegisterNamed("user", "GET", "/users/:id", func(req Request) Response {
+		return req.Response(nil)
+	})
+	router.RegisterNamed("file", "GET", "/files/*path", func(req Request) Response {
+		return req.Response(nil)
+	})
+	router.GET("/old/:id", func(req Request) Response {
+		return req.RedirectToRoute("user", "id", RouterForRequest(req).Params(req)["id"])
+	})
+	router.POST("/users", func(req Request) Response {
+		return req.RedirectToRoute("user", "id", "new user")
+	})
+	router.GET("/missing", func(req Request) Response {
+		return req.RedirectToRoute("nope")
+	})
+	svc := router.Serve().Filter(ErrorFilter)
+
+	rsp := svc(NewRequest(context.Background(), "GET", "/old/42", nil))
+	assert.Equal(t, http.StatusFound, rsp.StatusCode)
+	assert.Equal(t, "/users/42", rsp.Header.Get("Location"))
+
+	rsp = svc(NewRequest(context.Background(), "POST", "/users", nil))
+	assert.Equal(t, http.StatusSeeOther, rsp.StatusCode)
+	assert.Equal(t, "/users/new%20user", rsp.Header.Get("Location"))
+
+	rsp = svc(NewRequest(context.Background(), "GET", "/missing", nil))
+	assert.Equal(t, http.StatusInternalServerError, rsp.StatusCode)
+
+	url, err := router.URL("file", "path", "a b/c")
+	require.NoError(t, err)
+	assert.Equal(t, "/files/a%20b/c", url)
+	_, err = router.URL("user")
+	assert.Error(t, err)
+	_, err = router.URL("user", "id")
+	assert.Error(t, err)
+	_, err = router.URL("user", "id", "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,197 @@
+package libhttp
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/monzo/terrors"
+)
+
+var rendererContextKey = NewContextKey("renderer", (*Renderer)(nil))
+
+// A RendererOption configures a Renderer.
+type RendererOption func(*Renderer)
+
+// RendererLayout sets the layout which pages are rendered within by default: the name of a template under layouts/,
+// for example "layouts/base.html". See Renderer for how layouts and pages fit together.
+func RendererLayout(name string) RendererOption {
+	return func(r *Renderer) {
+		r.layout = name
+	}
+}
+
+// RendererFuncs adds functions which templates may call. They must be added before the templates are parsed, which is
+// why they are passed to NewRenderer rather than added afterwards.
+func RendererFuncs(funcs template.FuncMap) RendererOption {
+	return func(r *Renderer) {
+		for name, f := range funcs {
+			r.funcs[name] = f
+		}
+	}
+}
+
+// RendererReload makes a Renderer re-read its templates from disk each time a page is rendered, so changes are seen
+// without restarting. This is intended for development: it is slow, and template errors are only reported when a
+// page is rendered.
+func RendererReload() RendererOption {
+	return func(r *Renderer) {
+		r.reload = true
+	}
+}
+
+// A Renderer renders HTML pages from a directory tree of html/template files (those with a .html or .tmpl extension).
+// Templates are named by their slash-separated path relative to the root of the tree, and laid out like:
+//
+//  layouts/base.html       layouts, which wrap pages
+//  partials/nav.html       partials, which may be included by any template with {{template "partials/nav.html" .}}
+//  users/show.html         pages, which are rendered by name: Render(w, "users/show.html", data)
+//
+// Each page is parsed together with the layouts and partials, but not with other pages, so pages may define
+// templates with the same names as one another. A page which defines a template named "content" is rendered within
+// the layout (the Renderer's default, set with RendererLayout), which includes it with {{template "content" .}} and
+// may include other templates the page defines, like "title". Pages which don't define "content", or any page if no
+// layout is set, are rendered on their own.
+//
+// To render pages from Services with Request.Render, the Renderer is attached to requests with its Filter:
+//
+//  renderer, err := libhttp.NewRenderer("templates", libhttp.RendererLayout("layouts/base.html"))
+//  svc = svc.Filter(renderer.Filter)
+type Renderer struct {
+	dir    string
+	layout string
+	funcs  template.FuncMap
+	reload bool
+	pages  map[string]*template.Template
+}
+
+// NewRenderer returns a Renderer for the templates in the directory tree rooted at dir. Unless reloading is enabled,
+// the templates are all parsed immediately, and errors in any of them are returned.
+func NewRenderer(dir string, opts ...RendererOption) (*Renderer, error) {
+	r := &Renderer{
+		dir:   dir,
+		funcs: template.FuncMap{}}
+	for _, opt := range opts {
+		opt(r)
+	}
+	pages, err := r.load()
+	if err != nil && !r.reload {
+		return nil, err
+	}
+	r.pages = pages
+	return r, nil
+}
+
+// load parses the tree of templates, returning a template set for each page.
+func (r *Renderer) load() (map[string]*template.Template, error) {
+	var shared, pages []string
+	sources := map[string]string{}
+	err := filepath.Walk(r.dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() || (filepath.Ext(p) != ".html" && filepath.Ext(p) != ".tmpl") {
+			return nil
+		}
+		rel, err := filepath.Rel(r.dir, p)
+		if err != nil {
+			return err
+		}
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		sources[name] = string(b)
+		if strings.HasPrefix(name, "layouts/") || strings.HasPrefix(name, "partials/") {
+			shared = append(shared, name)
+		} else {
+			pages = append(pages, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(shared)
+
+	base := template.New("").Funcs(r.funcs)
+	for _, name := range shared {
+		if _, err := base.New(name).Parse(sources[name]); err != nil {
+			return nil, err
+		}
+	}
+	if r.layout != "" && base.Lookup(r.layout) == nil {
+		return nil, fmt.Errorf("layout %q not found in %s", r.layout, r.dir)
+	}
+	sets := make(map[string]*template.Template, len(pages))
+	for _, name := range pages {
+		set, err := base.Clone()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := set.New(name).Parse(sources[name]); err != nil {
+			return nil, err
+		}
+		sets[name] = set
+	}
+	return sets, nil
+}
+
+// Render executes the named page with data, writing the result to w.
+func (r *Renderer) Render(w io.Writer, name string, data interface{}) error {
+	pages := r.pages
+	if r.reload {
+		var err error
+		if pages, err = r.load(); err != nil {
+			return err
+		}
+	}
+	set, ok := pages[path.Clean(name)]
+	if !ok {
+		return fmt.Errorf("template %q not found in %s", name, r.dir)
+	}
+	if r.layout != "" && set.Lookup("content") != nil {
+		return set.ExecuteTemplate(w, r.layout, data)
+	}
+	return set.ExecuteTemplate(w, path.Clean(name), data)
+}
+
+// Filter attaches the Renderer to requests, so that Services may use Request.Render.
+func (r *Renderer) Filter(req Request, svc Service) Response {
+	return svc(SetValue(req, rendererContextKey, r))
+}
+
+// RendererForRequest returns the Renderer attached to the request by Renderer.Filter, or nil.
+func RendererForRequest(r Request) *Renderer {
+	v, _ := Value(r, rendererContextKey)
+	return v.(*Renderer)
+}
+
+// Render returns an HTML response containing the named page rendered with data, using the Renderer attached to the
+// request (see Renderer.Filter). The page is rendered completely before the response is returned, so a template
+// which fails to execute results in an internal service error rather than a partial page.
+func (r Request) Render(name string, data interface{}) Response {
+	rsp := r.Response(nil)
+	renderer := RendererForRequest(r)
+	if renderer == nil {
+		rsp.Error = terrors.InternalService("render", "No Renderer is attached to the request", nil)
+		return rsp
+	}
+	if err := renderer.Render(&rsp, name, data); err != nil {
+		// Discard the partial page
+		rsp.Body.(*bufCloser).Reset()
+		rsp.ContentLength = 0
+		rsp.Error = terrors.InternalService("render", err.Error(), map[string]string{
+			"template": name})
+		return rsp
+	}
+	rsp.Header.Set("Content-Type", "text/html; charset=utf-8")
+	return rsp
+}
@@ -0,0 +1,108 @@
+package libhttp
+
+import (
+	"context"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTemplates(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, ioutil.WriteFile(p, []byte(content), 0644))
+	}
+}
+
+func TestRenderer(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeTemplates(t, dir, map[string]string{
+		"layouts/base.html": `<title>{{template "title" .}}</title>{{template "partials/nav.html" .}}<main>{{template "content" .}}</main>`,
+		"partials/nav.html": `<nav>{{upper .User}}</nav>`,
+		"users/show.html":   `{{define "title"}}User{{end}}{{define "content"}}<p>{{.User}}</p>{{end}}`,
+		"users/index.html":  `{{define "title"}}Users{{end}}{{define "content"}}<p>all</p>{{end}}`,
+		"plain.html":        `<p>{{.User}}</p>`,
+		"broken.html":       `{{call .User}}`,
+		"README.md":         `{{ not a template`})
+	renderer, err := NewRenderer(dir,
+		RendererLayout("layouts/base.html"),
+		RendererFuncs(template.FuncMap{
+			"upper": strings.ToUpper}))
+	require.NoError(t, err)
+
+	svc := Service(func(req Request) Response {
+		return req.Render(req.URL.Path[1:], map[string]interface{}{
+			"User": "<bob>"})
+	}).Filter(renderer.Filter)
+
+	rsp := svc(NewRequest(context.Background(), "GET", "/users/show.html", nil))
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, "text/html; charset=utf-8", rsp.Header.Get("Content-Type"))
+	b, _ := rsp.BodyBytes(true)
+	assert.Equal(t, "<title>User</title><nav>&lt;BOB&gt;</nav><main><p>&lt;bob&gt;</p></main>", string(b))
+
+	// Pages don't see each other's definitions
+	rsp = svc(NewRequest(context.Background(), "GET", "/users/index.html", nil))
+	b, _ = rsp.BodyBytes(true)
+	assert.Equal(t, "<title>Users</title><nav>&lt;BOB&gt;</nav><main><p>all</p></main>", string(b))
+
+	// Pages without content are rendered on their own
+	rsp = svc(NewRequest(context.Background(), "GET", "/plain.html", nil))
+	b, _ = rsp.BodyBytes(true)
+	assert.Equal(t, "<p>&lt;bob&gt;</p>", string(b))
+
+	rsp = svc(NewRequest(context.Background(), "GET", "/missing.html", nil))
+	assert.True(t, terrors.Is(rsp.Error, terrors.ErrInternalService))
+	rsp = svc(NewRequest(context.Background(), "GET", "/broken.html", nil))
+	assert.True(t, terrors.Is(rsp.Error, terrors.ErrInternalService))
+
+	// Without the filter
+	rsp = NewRequest(context.Background(), "GET", "/", nil).Render("plain.html", nil)
+	assert.Error(t, rsp.Error)
+}
+
+func TestRendererErrors(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeTemplates(t, dir, map[string]string{
+		"page.html": `{{if}}`})
+	_, err := NewRenderer(dir)
+	assert.Error(t, err)
+
+	dir = t.TempDir()
+	writeTemplates(t, dir, map[string]string{
+		"page.html": `ok`})
+	_, err = NewRenderer(dir, RendererLayout("layouts/missing.html"))
+	assert.Error(t, err)
+}
+
+func TestRendererReload(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeTemplates(t, dir, map[string]string{
+		"page.html": `one`})
+	renderer, err := NewRenderer(dir, RendererReload())
+	require.NoError(t, err)
+
+	b := &strings.Builder{}
+	require.NoError(t, renderer.Render(b, "page.html", nil))
+	assert.Equal(t, "one", b.String())
+
+	writeTemplates(t, dir, map[string]string{
+		"page.html": `two`})
+	b.Reset()
+	require.NoError(t, renderer.Render(b, "page.html", nil))
+	assert.Equal(t, "two", b.String())
+}
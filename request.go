@@ -1,6 +1,7 @@
 package libhttp
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -20,6 +21,7 @@ type Request struct {
 	context.Context
 	err      error // Any error from request construction; read by ErrorFilter
 	hijacker http.Hijacker
+	rw       *rawWriter // set for requests served by HttpHandler
 	server   *Server
 }
 
@@ -61,11 +63,39 @@ func (r *Request) Encode(v interface{}) {
 	r.Header.Set("Content-Type", "application/json")
 }
 
-// Decode de-serialises the JSON body into the passed object.
+// EncodeXML serialises the passed object as XML into the body (and sets appropriate headers).
+func (r *Request) EncodeXML(v interface{}) {
+	r.encodeWith(xmlCodec{}, v)
+}
+
+func (r *Request) encodeWith(c Codec, v interface{}) {
+	b, err := c.Marshal(v)
+	if err != nil {
+		r.err = terrors.Wrap(err, nil)
+		return
+	}
+	if _, err := r.Write(b); err != nil {
+		r.err = terrors.Wrap(err, nil)
+		return
+	}
+	r.Header.Set("Content-Type", c.ContentType())
+}
+
+// Decode de-serialises the body into the passed object, using the codec registered for its Content-Type. Bodies
+// without a Content-Type, or of a type with no registered codec, are treated as JSON.
 func (r Request) Decode(v interface{}) error {
+	return r.decodeWith(codecFor(r.Header.Get("Content-Type")), v)
+}
+
+// DecodeXML de-serialises the XML body into the passed object, regardless of its Content-Type.
+func (r Request) DecodeXML(v interface{}) error {
+	return r.decodeWith(xmlCodec{}, v)
+}
+
+func (r Request) decodeWith(c Codec, v interface{}) error {
 	b, err := r.BodyBytes(true)
 	if err == nil {
-		err = json.Unmarshal(b, v)
+		err = c.Unmarshal(b, v)
 	}
 	return terrors.WrapWithCode(err, nil, terrors.ErrBadRequest)
 }
@@ -132,6 +162,85 @@ func (r *Request) BodyBytes(consume bool) ([]byte, error) {
 	}
 }
 
+// BufferBody reads the request body into memory, so that it can be read more than once: the body is replaced by the
+// buffered copy, and later calls return the buffered bytes without reading it again. This lets several filters (to
+// verify a signature, or log the body, say) see the body without consuming it for the Service:
+//
+//  b, err := req.BufferBody(1 << 20)
+//  if err != nil {
+//      return libhttp.Response{Error: err}
+//  }
+//  if !validSignature(req.Header.Get("Signature"), b) { ... }
+//  return svc(req)
+//
+// Bodies of more than limit bytes result in a bad request error; the body is then left intact (but unbuffered) for
+// the Service to deal with.
+func (r *Request) BufferBody(limit int64) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+	var b []byte
+	if buf, ok := r.Body.(*bufCloser); ok {
+		b = append([]byte(nil), buf.Bytes()...) // so the caller can't modify the buffered body
+	} else {
+		rc := r.Body
+		var err error
+		b, err = ioutil.ReadAll(io.LimitReader(rc, limit+1))
+		if err != nil {
+			rc.Close()
+			return nil, terrors.WrapWithCode(err, nil, terrors.ErrBadRequest)
+		}
+		if int64(len(b)) <= limit {
+			rc.Close()
+			buf := &bufCloser{}
+			buf.Write(b)
+			r.Body = buf
+			r.ContentLength = int64(len(b))
+		} else {
+			// Put back what was read, so nothing is lost
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(b), rc), rc}
+		}
+	}
+	if int64(len(b)) > limit {
+		return nil, terrors.BadRequest("body_too_large", fmt.Sprintf("Request body exceeds %d bytes", limit),
+			map[string]string{
+				"max_bytes": fmt.Sprint(limit)})
+	}
+	return b, nil
+}
+
+// Clone returns a deep copy of the request, which can be sent or served independently of the original; this allows a
+// request to be fanned out to several Services (eg. to shadow traffic, or scatter and gather). The body is read into
+// memory, and the clone and the original each get their own copy of it, so either may be read (or written) without
+// affecting the other.
+//
+// If the body can't be read, the clone carries the error, which ErrorFilter returns when it is sent.
+func (r *Request) Clone() Request {
+	ctx := r.unwrappedContext()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	c := *r
+	c.Request = *r.Request.Clone(ctx)
+	// The clone's responses don't go to the client directly, so it can't hijack the connection or send hints
+	c.hijacker, c.rw = nil, nil
+	if r.Body != nil {
+		b, err := r.BodyBytes(false)
+		if err != nil {
+			c.err = terrors.Wrap(err, nil)
+		} else {
+			c.ContentLength = int64(len(b))
+		}
+		buf := &bufCloser{}
+		buf.Write(b)
+		c.Body = buf
+	}
+	return c
+}
+
 // Send round-trips the request via the default Client. It does not block, instead returning a ResponseFuture
 // representing the asynchronous operation to produce the response. It is equivalent to:
 //
@@ -146,10 +255,23 @@ func (r Request) SendVia(svc Service) *ResponseFuture {
 	return SendVia(r, svc)
 }
 
-// Response construct a new Response to the request, and if non-nil, encodes the given body into it.
+// Response construct a new Response to the request, and if non-nil, encodes the given body into it. The encoding is
+// chosen from the registered codecs by the request's Accept header (honouring quality values), or if it has none, to
+// match the request's own body; codecs which can't encode the body (protobuf for anything but protobuf messages, or XML
+// for maps) are passed over. Failing those, the body is encoded as JSON. io.Readers are used as the body directly.
 func (r Request) Response(body interface{}) Response {
 	rsp := NewResponse(r)
-	if body != nil {
+	if body == nil {
+		return rsp
+	}
+	if _, ok := body.(io.Reader); ok {
+		rsp.Encode(body)
+		return rsp
+	}
+	rsp.Header.Add("Vary", "Accept")
+	if c, b := r.negotiateCodec(body); c != (jsonCodec{}) {
+		rsp.writeEncoded(c, b)
+	} else {
 		rsp.Encode(body)
 	}
 	return rsp
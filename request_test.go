@@ -2,10 +2,13 @@ package libhttp
 
 import (
 	"bytes"
+	"context"
 	"io/ioutil"
 	"strings"
 	"testing"
+	"testing/iotest"
 
+	"github.com/monzo/terrors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -61,3 +64,93 @@ func TestRequestEncodeReader(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, []byte("{}\n"), body)
 }
+
+func TestRequestClone(t *testing.T) {
+	t.Parallel()
+
+	req := NewRequest(context.Background(), "POST", "http://localhost/a?b=c", nil)
+	req.Body = ioutil.NopCloser(strings.NewReader("payload")) // not re-readable
+	req.ContentLength = -1
+	req.Header.Set("X-Foo", "bar")
+
+	clones := make([]Request, 3)
+	for i := range clones {
+		clones[i] = req.Clone()
+	}
+	for _, c := range clones {
+		c.Header.Set("X-Foo", "changed")
+		c.URL.Path = "/changed"
+		b, err := c.BodyBytes(true)
+		require.NoError(t, err)
+		assert.Equal(t, "payload", string(b))
+		assert.EqualValues(t, 7, c.ContentLength)
+	}
+	assert.Equal(t, "bar", req.Header.Get("X-Foo"))
+	assert.Equal(t, "/a", req.URL.Path)
+	b, err := req.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, "payload", string(b))
+
+	// Clones can be served concurrently
+	svc := Service(func(req Request) Response {
+		b, _ := req.BodyBytes(true)
+		return req.Response(string(b))
+	})
+	req.Body = ioutil.NopCloser(strings.NewReader("fan out"))
+	futures := make([]*ResponseFuture, 5)
+	for i := range futures {
+		futures[i] = req.Clone().SendVia(svc)
+	}
+	for _, f := range futures {
+		rsp := f.Response()
+		require.NoError(t, rsp.Error)
+		s := ""
+		require.NoError(t, rsp.Decode(&s))
+		assert.Equal(t, "fan out", s)
+	}
+
+	// Body errors are carried by the clone
+	req.Body = ioutil.NopCloser(iotest.TimeoutReader(strings.NewReader("x")))
+	c := req.Clone()
+	assert.Error(t, c.SendVia(Service(BareClient).Filter(ErrorFilter)).Response().Error)
+}
+
+func TestRequestBufferBody(t *testing.T) {
+	t.Parallel()
+
+	req := NewRequest(context.Background(), "POST", "/", nil)
+	req.Body = ioutil.NopCloser(strings.NewReader("payload"))
+	req.ContentLength = -1
+	for i := 0; i < 3; i++ {
+		b, err := req.BufferBody(100)
+		require.NoError(t, err)
+		assert.Equal(t, "payload", string(b))
+	}
+	assert.EqualValues(t, 7, req.ContentLength)
+	// Modifying the bytes returned doesn't modify the buffered body
+	b, err := req.BufferBody(100)
+	require.NoError(t, err)
+	copy(b, "XXX")
+	b, err = req.BufferBody(100)
+	require.NoError(t, err)
+	assert.Equal(t, "payload", string(b))
+	s := ""
+	req.Body = ioutil.NopCloser(strings.NewReader(`"json"`))
+	_, err = req.BufferBody(100)
+	require.NoError(t, err)
+	require.NoError(t, req.Decode(&s))
+	assert.Equal(t, "json", s)
+
+	// Too large: the body is left intact
+	req.Body = ioutil.NopCloser(strings.NewReader("0123456789"))
+	_, err = req.BufferBody(5)
+	assert.True(t, terrors.Is(err, terrors.ErrBadRequest))
+	b, err = req.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(b))
+
+	req.Body = nil
+	b, err = req.BufferBody(5)
+	assert.NoError(t, err)
+	assert.Nil(t, b)
+}
@@ -0,0 +1,166 @@
+package libhttp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/monzo/slog"
+)
+
+// A Resolver turns the name of a service into the set of endpoints (host:port pairs, or URLs with a scheme and host)
+// which serve it, for a LoadBalancer to spread requests across (see LoadBalancer.Watch).
+type Resolver interface {
+	Resolve(ctx context.Context, name string) ([]string, error)
+}
+
+// ResolverFunc adapts a function to a Resolver.
+type ResolverFunc func(ctx context.Context, name string) ([]string, error)
+
+// Resolve calls f.
+func (f ResolverFunc) Resolve(ctx context.Context, name string) ([]string, error) {
+	return f(ctx, name)
+}
+
+// StaticResolver returns a Resolver which resolves names from a fixed map of names to endpoints.
+func StaticResolver(endpoints map[string][]string) Resolver {
+	return ResolverFunc(func(ctx context.Context, name string) ([]string, error) {
+		eps, ok := endpoints[name]
+		if !ok {
+			return nil, fmt.Errorf("no endpoints for %q", name)
+		}
+		return append([]string(nil), eps...), nil
+	})
+}
+
+// DNSResolver returns a Resolver which looks up the addresses (A and AAAA records) of the name, which are served on the
+// passed port.
+func DNSResolver(port int) Resolver {
+	return ResolverFunc(func(ctx context.Context, name string) ([]string, error) {
+		addrs, err := net.DefaultResolver.LookupHost(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		eps := make([]string, len(addrs))
+		for i, addr := range addrs {
+			eps[i] = net.JoinHostPort(addr, strconv.Itoa(port))
+		}
+		sort.Strings(eps)
+		return eps, nil
+	})
+}
+
+// SRVResolver returns a Resolver which looks up the SRV records of the name for the passed service and protocol (for
+// example "http" and "tcp", which looks up _http._tcp.<name>), giving the targets and ports they list. Priorities
+// and weights aren't taken into account: all targets are used.
+func SRVResolver(service, proto string) Resolver {
+	return ResolverFunc(func(ctx context.Context, name string) ([]string, error) {
+		_, srvs, err := net.DefaultResolver.LookupSRV(ctx, service, proto, name)
+		if err != nil {
+			return nil, err
+		}
+		eps := make([]string, len(srvs))
+		for i, srv := range srvs {
+			eps[i] = net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port)))
+		}
+		sort.Strings(eps)
+		return eps, nil
+	})
+}
+
+// fileResolver resolves names from a JSON file, which is re-read when it changes.
+type fileResolver struct {
+	path      string
+	m         sync.Mutex
+	modTime   time.Time
+	size      int64
+	endpoints map[string][]string
+}
+
+// FileResolver returns a Resolver which resolves names from a JSON file mapping names to their endpoints:
+//
+//  {"users": ["10.0.0.1:8080", "10.0.0.2:8080"], "orders": ["https://10.0.1.1:8443"]}
+//
+// The file is re-read whenever it has changed, so endpoints can be updated (by a configuration management system, for
+// example) while the program is running. The file should be replaced atomically (by renaming a new file over it) so
+// that it is never read while partially written.
+func FileResolver(path string) Resolver {
+	return &fileResolver{
+		path: path}
+}
+
+func (r *fileResolver) Resolve(ctx context.Context, name string) ([]string, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	fi, err := os.Stat(r.path)
+	if err != nil {
+		return nil, err
+	}
+	if r.endpoints == nil || !fi.ModTime().Equal(r.modTime) || fi.Size() != r.size {
+		b, err := ioutil.ReadFile(r.path)
+		if err != nil {
+			return nil, err
+		}
+		endpoints := map[string][]string{}
+		if err := json.Unmarshal(b, &endpoints); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", r.path, err)
+		}
+		r.endpoints, r.modTime, r.size = endpoints, fi.ModTime(), fi.Size()
+	}
+	eps, ok := r.endpoints[name]
+	if !ok {
+		return nil, fmt.Errorf("no endpoints for %q in %s", name, r.path)
+	}
+	return append([]string(nil), eps...), nil
+}
+
+// Watch sets the balancer's endpoints from the resolver, resolving its service name now and then again at the passed
+// interval until the context is done:
+//
+//  lb, _ := libhttp.NewLoadBalancer("users.internal", nil)
+//  if err := lb.Watch(ctx, libhttp.DNSResolver(8080), 30*time.Second); err != nil {
+//      return err
+//  }
+//
+// An error is returned if the first resolution fails. Later failures are logged, and the endpoints resolved last are
+// kept, as are they if the resolver returns none: a broken resolver shouldn't take a service down.
+func (lb *LoadBalancer) Watch(ctx context.Context, r Resolver, interval time.Duration) error {
+	eps, err := r.Resolve(ctx, lb.name)
+	if err == nil {
+		err = lb.SetEndpoints(eps)
+	}
+	if err != nil {
+		return err
+	}
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			eps, err := r.Resolve(ctx, lb.name)
+			switch {
+			case err != nil:
+				slog.Warn(ctx, "Couldn't resolve endpoints for %s: %v", lb.name, err)
+			case len(eps) == 0:
+				slog.Warn(ctx, "Resolved no endpoints for %s; keeping the previous endpoints", lb.name)
+			default:
+				if err := lb.SetEndpoints(eps); err != nil {
+					slog.Warn(ctx, "Couldn't update endpoints for %s: %v", lb.name, err)
+				}
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,118 @@
+package libhttp
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStaticResolver(t *testing.T) {
+	t.Parallel()
+	r := StaticResolver(map[string][]string{
+		"users": {"a:80", "b:80"}})
+	eps, err := r.Resolve(context.Background(), "users")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a:80", "b:80"}, eps)
+	_, err = r.Resolve(context.Background(), "orders")
+	assert.Error(t, err)
+}
+
+func TestDNSResolver(t *testing.T) {
+	t.Parallel()
+	eps, err := DNSResolver(8080).Resolve(context.Background(), "127.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"127.0.0.1:8080"}, eps)
+	eps, err = DNSResolver(8080).Resolve(context.Background(), "::1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"[::1]:8080"}, eps)
+}
+
+func TestFileResolver(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "endpoints.json")
+	write := func(content string, mtime time.Time) {
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+		require.NoError(t, os.Chtimes(path, mtime, mtime))
+	}
+	r := FileResolver(path)
+	_, err := r.Resolve(context.Background(), "users")
+	assert.Error(t, err)
+
+	now := time.Now()
+	write(`{"users": ["a:80", "b:80"]}`, now.Add(-time.Minute))
+	eps, err := r.Resolve(context.Background(), "users")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a:80", "b:80"}, eps)
+	_, err = r.Resolve(context.Background(), "orders")
+	assert.Error(t, err)
+
+	// Changes are picked up
+	write(`{"users": ["c:80"]}`, now)
+	eps, err = r.Resolve(context.Background(), "users")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c:80"}, eps)
+
+	write(`{"users": `, now.Add(time.Minute))
+	_, err = r.Resolve(context.Background(), "users")
+	assert.Error(t, err)
+}
+
+func TestLoadBalancerWatch(t *testing.T) {
+	t.Parallel()
+	var (
+		m   sync.Mutex
+		eps = []string{"a:80"}
+		err error
+	)
+	r := ResolverFunc(func(ctx context.Context, name string) ([]string, error) {
+		m.Lock()
+		defer m.Unlock()
+		assert.Equal(t, "users", name)
+		return eps, err
+	})
+	set := func(e []string, resolveErr error) {
+		m.Lock()
+		eps, err = e, resolveErr
+		m.Unlock()
+	}
+	waitFor := func(lb *LoadBalancer, want []string) {
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			if assert.ObjectsAreEqual(want, lb.Endpoints()) {
+				return
+			}
+		}
+		assert.Equal(t, want, lb.Endpoints())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lb, lbErr := NewLoadBalancer("users", nil)
+	require.NoError(t, lbErr)
+	require.NoError(t, lb.Watch(ctx, r, time.Millisecond))
+	assert.Equal(t, []string{"a:80"}, lb.Endpoints())
+
+	set([]string{"b:80", "c:80"}, nil)
+	waitFor(lb, []string{"b:80", "c:80"})
+
+	// Failures, and empty results, leave the endpoints alone
+	set(nil, errors.New("boom"))
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, []string{"b:80", "c:80"}, lb.Endpoints())
+	set(nil, nil)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, []string{"b:80", "c:80"}, lb.Endpoints())
+
+	// The first resolution must succeed
+	lb, lbErr = NewLoadBalancer("users", nil)
+	require.NoError(t, lbErr)
+	set(nil, errors.New("boom"))
+	assert.Error(t, lb.Watch(ctx, r, time.Millisecond))
+}
@@ -1,13 +1,18 @@
 package libhttp
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"reflect"
+	"strconv"
 
+	"github.com/monzo/slog"
 	"github.com/monzo/terrors"
 )
 
@@ -48,6 +53,136 @@ func (r *Response) Encode(v interface{}) {
 	r.Header.Set("Content-Type", "application/json")
 }
 
+// EncodeStream serialises the passed object as JSON into the body in the manner of Encode, but the JSON is streamed
+// to the client as it is produced rather than held in memory, so large payloads (like big result sets) don't need to
+// be buffered. Slices and arrays are encoded an element at a time. The response is sent with chunked encoding; use
+// Encode when a Content-Length is required.
+//
+// The object is encoded after the Service returns, so it must not be modified afterwards. As the status code has been
+// sent by then, an object which can't be encoded results in a truncated response (the connection is aborted) rather
+// than an error response.
+func (r *Response) EncodeStream(v interface{}) {
+	if r.Response == nil {
+		r.Response = newHTTPResponse(Request{})
+	}
+	body := Streamer().(*streamer)
+	r.Body = body
+	r.ContentLength = -1
+	r.Header.Del("Content-Length")
+	r.Header.Set("Content-Type", "application/json")
+
+	var ctx context.Context = context.Background()
+	if r.Request != nil {
+		ctx = r.Request
+	}
+	go func() {
+		w := bufio.NewWriterSize(body, 32*1024)
+		err := encodeJSONStream(w, v)
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil && err != io.ErrClosedPipe {
+			slog.Warn(ctx, "Couldn't stream JSON response: %v", err)
+		}
+		body.pipeW.CloseWithError(err)
+	}()
+}
+
+// encodeJSONStream writes v as JSON to w, encoding the elements of slices and arrays one by one.
+func encodeJSONStream(w *bufio.Writer, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	_, marshaler := v.(json.Marshaler)
+	switch {
+	case marshaler || !rv.IsValid():
+		return json.NewEncoder(w).Encode(v)
+	case rv.Kind() == reflect.Array:
+	case rv.Kind() == reflect.Slice && !rv.IsNil() && rv.Type().Elem().Kind() != reflect.Uint8: // []byte is base64
+	default:
+		return json.NewEncoder(w).Encode(v)
+	}
+	w.WriteByte('[')
+	for i := 0; i < rv.Len(); i++ {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		elem := rv.Index(i)
+		if elem.CanAddr() {
+			elem = elem.Addr() // as json.Marshal would, so pointer-receiver MarshalJSON methods are used
+		}
+		b, err := json.Marshal(elem.Interface())
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	_, err := w.WriteString("]\n")
+	return err
+}
+
+// SetBodyReader sets the body to be read from r, which is streamed to the client rather than buffered. If length is
+// not negative it is the number of bytes r will produce, which is sent as the Content-Length; otherwise the body is
+// sent with chunked encoding. If contentType is not empty, it is set as the Content-Type. If r is an io.Closer, it is
+// closed once the body has been sent.
+func (r *Response) SetBodyReader(body io.Reader, length int64, contentType string) {
+	if r.Response == nil {
+		r.Response = newHTTPResponse(Request{})
+	}
+	rc, ok := body.(io.ReadCloser)
+	if !ok {
+		rc = ioutil.NopCloser(body)
+	}
+	r.Body = rc
+	if length >= 0 {
+		r.ContentLength = length
+		r.Header.Set("Content-Length", strconv.FormatInt(length, 10))
+	} else {
+		r.ContentLength = -1
+		r.Header.Del("Content-Length")
+	}
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+}
+
+// DeclareTrailers announces (in the Trailer header) that the response will have trailers with the passed names, which
+// are sent after the body. Their values are set with SetTrailer, which may be called until the body has been read to
+// completion, so values computed while streaming (like checksums) can be sent. Trailers must be declared before the
+// Response is returned by a Service, so that the announcement is sent with the headers.
+//
+// Clients can read the trailers from Response.Trailer, once the body has been read to completion.
+func (r *Response) DeclareTrailers(names ...string) {
+	if r.Response == nil {
+		r.Response = newHTTPResponse(Request{})
+	}
+	if r.Trailer == nil {
+		r.Trailer = make(http.Header, len(names))
+	}
+	for _, name := range names {
+		name = http.CanonicalHeaderKey(name)
+		if _, ok := r.Trailer[name]; !ok {
+			r.Trailer[name] = nil
+			r.Header.Add("Trailer", name)
+		}
+	}
+}
+
+// SetTrailer sets the value of a trailer, which should have been declared with DeclareTrailers. Trailers which weren't
+// declared are still sent, but clients are less likely to expect them.
+//
+// SetTrailer may be called from a goroutine producing a streaming body, as long as it is called before the body is
+// closed or returns io.EOF.
+func (r *Response) SetTrailer(name, value string) {
+	if r.Response == nil {
+		r.Response = newHTTPResponse(Request{})
+	}
+	if r.Trailer == nil {
+		r.Trailer = make(http.Header, 1)
+	}
+	r.Trailer.Set(name, value)
+}
+
 // WrapDownstreamErrors is a context key that can be used to enable
 // wrapping of downstream response errors on a per-request basis.
 //
@@ -56,8 +191,45 @@ func (r *Response) Encode(v interface{}) {
 // dependency on config to Typhon.
 type WrapDownstreamErrors struct{}
 
-// Decode de-serialises the JSON body into the passed object.
+// EncodeXML serialises the passed object as XML into the body (and sets appropriate headers).
+func (r *Response) EncodeXML(v interface{}) {
+	r.encodeWith(xmlCodec{}, v)
+}
+
+func (r *Response) encodeWith(c Codec, v interface{}) {
+	if r.Response == nil {
+		r.Response = newHTTPResponse(Request{})
+	}
+	b, err := c.Marshal(v)
+	if err != nil {
+		r.Error = terrors.Wrap(err, nil)
+		return
+	}
+	r.writeEncoded(c, b)
+}
+
+// writeEncoded writes a body already serialised by the codec.
+func (r *Response) writeEncoded(c Codec, b []byte) {
+	if _, err := r.Write(b); err != nil {
+		r.Error = terrors.Wrap(err, nil)
+		return
+	}
+	r.Header.Set("Content-Type", c.ContentType())
+}
+
+// Decode de-serialises the body into the passed object, using the codec registered for its Content-Type. Bodies
+// without a Content-Type, or of a type with no registered codec, are treated as JSON.
 func (r *Response) Decode(v interface{}) error {
+	return r.decodeWith(nil, v)
+}
+
+// DecodeXML de-serialises the XML body into the passed object, regardless of its Content-Type.
+func (r *Response) DecodeXML(v interface{}) error {
+	return r.decodeWith(xmlCodec{}, v)
+}
+
+// decodeWith de-serialises the body using the passed codec, or if it is nil the codec for the body's Content-Type.
+func (r *Response) decodeWith(c Codec, v interface{}) error {
 	if r.Error != nil {
 		if r.Request != nil && r.Request.Context != nil {
 			if s, ok := r.Request.Context.Value(WrapDownstreamErrors{}).(string); ok && s != "" {
@@ -72,9 +244,12 @@ func (r *Response) Decode(v interface{}) error {
 		err = terrors.InternalService("", "Response has no body", nil)
 	} else {
 		var b []byte
+		if c == nil {
+			c = codecFor(r.Header.Get("Content-Type"))
+		}
 		b, err = r.BodyBytes(true)
 		if err == nil {
-			err = json.Unmarshal(b, v)
+			err = c.Unmarshal(b, v)
 		}
 		err = terrors.WrapWithCode(err, nil, terrors.ErrBadResponse)
 	}
@@ -128,6 +303,9 @@ func (r *Response) Write(b []byte) (n int, err error) {
 
 // BodyBytes fully reads the response body and returns the bytes read. If consume is false, the body is copied into a
 // new buffer such that it may be read again.
+//
+// The returned bytes are always the caller's own: buffered bodies are pooled, and a response's buffer is reused once a
+// server has sent it, so they are copied rather than returned directly.
 func (r *Response) BodyBytes(consume bool) ([]byte, error) {
 	if consume {
 		defer r.Body.Close()
@@ -136,7 +314,7 @@ func (r *Response) BodyBytes(consume bool) ([]byte, error) {
 
 	switch rc := r.Body.(type) {
 	case *bufCloser:
-		return rc.Bytes(), nil
+		return append([]byte(nil), rc.Bytes()...), nil
 
 	default:
 		buf := &bufCloser{}
@@ -186,7 +364,7 @@ func newHTTPResponse(req Request) *http.Response {
 		ProtoMinor:    req.ProtoMinor,
 		ContentLength: 0,
 		Header:        make(http.Header, 5),
-		Body:          &bufCloser{}}
+		Body:          newBufCloser()}
 }
 
 // NewResponse constructs a Response
@@ -1,8 +1,12 @@
 package libhttp
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
+
+	"github.com/monzo/terrors"
 )
 
 func BenchmarkResponseDecode(b *testing.B) {
@@ -36,3 +40,45 @@ func BenchmarkRepeatedResponseBodyBytes(b *testing.B) {
 		rsp.BodyBytes(false)
 	}
 }
+
+func BenchmarkHttpHandler(b *testing.B) {
+	payload := map[string]interface{}{
+		"id":    "0a6a93e7-4a7b-4d8a-8b1b-4ea0a0d9a6f2",
+		"name":  strings.Repeat("x", 512),
+		"items": []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}}
+	h := HttpHandler(Service(func(req Request) Response {
+		return req.Response(payload)
+	}).Filter(ErrorFilter))
+	httpReq := httptest.NewRequest("GET", "/", nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httpReq)
+	}
+}
+
+func BenchmarkHttpHandlerError(b *testing.B) {
+	h := HttpHandler(Service(func(req Request) Response {
+		return Response{Error: terrors.NotFound("thing", "Thing not found", nil)}
+	}).Filter(ErrorFilter))
+	httpReq := httptest.NewRequest("GET", "/", nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httpReq)
+	}
+}
+
+func BenchmarkFromHTTPHandler(b *testing.B) {
+	body := []byte(strings.Repeat("x", 4096))
+	svc := FromHTTPHandler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write(body)
+	}))
+	h := HttpHandler(svc)
+	httpReq := httptest.NewRequest("GET", "/", nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httpReq)
+	}
+}
@@ -3,6 +3,7 @@ package libhttp
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"io"
 	"io/ioutil"
@@ -205,6 +206,16 @@ func TestResponseBodyBytes_Preserving(t *testing.T) {
 		require.NoError(t, err)
 		assert.Equal(t, []byte("def"), b)
 	}
+
+	// The bytes don't alias the buffer, which is pooled and reused once a server has sent the response
+	buf := newBufCloser()
+	buf.WriteString("ghi")
+	rsp.Body = buf
+	b, err := rsp.BodyBytes(false)
+	require.NoError(t, err)
+	releaseBufCloser(buf)
+	buf.WriteString("jkl")
+	assert.Equal(t, []byte("ghi"), b)
 }
 
 type jsonMarshalerReader struct {
@@ -252,3 +263,147 @@ func TestResponseEncodeReader(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, []byte("{}\n"), body)
 }
+
+func TestResponseSetBodyReader(t *testing.T) {
+	t.Parallel()
+
+	const size = 8 << 20
+	closed := make(chan struct{})
+	svc := Service(func(req Request) Response {
+		rsp := req.Response(nil)
+		body := io.LimitReader(zeroReader{}, size)
+		if req.URL.Path == "/known" {
+			rsp.SetBodyReader(readCloser{body, func() { close(closed) }}, size, "application/octet-stream")
+		} else {
+			rsp.SetBodyReader(body, -1, "")
+		}
+		return rsp
+	})
+	s, err := Listen(svc, "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+	client := HttpService(&http.Transport{})
+
+	rsp := NewRequest(nil, "GET", "http://"+s.Listener().Addr().String()+"/known", nil).SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+	assert.EqualValues(t, size, rsp.ContentLength)
+	assert.Empty(t, rsp.TransferEncoding)
+	assert.Equal(t, "application/octet-stream", rsp.Header.Get("Content-Type"))
+	n, err := io.Copy(ioutil.Discard, rsp.Body)
+	require.NoError(t, err)
+	assert.EqualValues(t, size, n)
+	<-closed
+
+	rsp = NewRequest(nil, "GET", "http://"+s.Listener().Addr().String()+"/unknown", nil).SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+	assert.EqualValues(t, -1, rsp.ContentLength)
+	assert.Equal(t, []string{"chunked"}, rsp.TransferEncoding)
+	n, err = io.Copy(ioutil.Discard, rsp.Body)
+	require.NoError(t, err)
+	assert.EqualValues(t, size, n)
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = 0
+	}
+	return len(b), nil
+}
+
+type readCloser struct {
+	io.Reader
+	close func()
+}
+
+func (r readCloser) Close() error {
+	r.close()
+	return nil
+}
+
+func TestResponseTrailers(t *testing.T) {
+	t.Parallel()
+
+	svc := Service(func(req Request) Response {
+		rsp := req.Response(nil)
+		rsp.DeclareTrailers("x-checksum", "X-Elapsed")
+		if req.URL.Path == "/buffered" {
+			rsp.Write([]byte("abc"))
+			rsp.SetTrailer("X-Checksum", "buffered")
+			return rsp
+		}
+		body := Streamer()
+		rsp.Body = body
+		go func() {
+			defer body.Close()
+			body.Write([]byte("abc"))
+			rsp.SetTrailer("X-Checksum", "streamed")
+		}()
+		return rsp
+	})
+	s, err := Listen(svc, "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+
+	client := HttpService(&http.Transport{})
+	for _, path := range []string{"/buffered", "/streamed"} {
+		rsp := NewRequest(context.Background(), "GET", "http://"+s.Listener().Addr().String()+path, nil).
+			SendVia(client).Response()
+		require.NoError(t, rsp.Error)
+		// net/http moves the announcement into the keys of Trailer
+		assert.Contains(t, rsp.Trailer, "X-Checksum", path)
+		assert.Contains(t, rsp.Trailer, "X-Elapsed", path)
+		b, err := rsp.BodyBytes(true)
+		require.NoError(t, err)
+		assert.Equal(t, "abc", string(b))
+		assert.Equal(t, path[1:], rsp.Trailer.Get("X-Checksum"), path)
+		assert.Empty(t, rsp.Trailer.Get("X-Elapsed"))
+	}
+}
+
+// ptrMarshaler marshals itself only through a pointer, as json.Marshal does for the elements of slices.
+type ptrMarshaler struct {
+	s string
+}
+
+func (m *ptrMarshaler) MarshalJSON() ([]byte, error) {
+	return json.Marshal("marshalled " + m.s)
+}
+
+func TestResponseEncodeStream(t *testing.T) {
+	t.Parallel()
+
+	type item struct {
+		N int `json:"n"`
+	}
+	items := make([]item, 10000)
+	for i := range items {
+		items[i].N = i
+	}
+	cases := []interface{}{
+		items,
+		[2]string{"a", "<b>"},
+		[]byte("bytes"),
+		[]ptrMarshaler{{"a"}, {"b"}},
+		[]int(nil),
+		map[string]int{"a": 1},
+		nil}
+	for _, v := range cases {
+		req := NewRequest(context.Background(), "GET", "/", nil)
+		rsp := req.Response(nil)
+		rsp.EncodeStream(v)
+		assert.EqualValues(t, -1, rsp.ContentLength)
+		assert.Equal(t, "application/json", rsp.Header.Get("Content-Type"))
+		b, err := rsp.BodyBytes(true)
+		require.NoError(t, err)
+		expected, _ := json.Marshal(v)
+		assert.JSONEq(t, string(expected), string(b))
+	}
+
+	// Values which can't be encoded fail the body
+	rsp := NewResponse(Request{})
+	rsp.EncodeStream([]interface{}{1, make(chan int)})
+	_, err := rsp.BodyBytes(true)
+	assert.Error(t, err)
+}
@@ -0,0 +1,321 @@
+package libhttp
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/4thel00z/libhttp/libhttpmetrics"
+	"github.com/monzo/terrors"
+)
+
+// A RetryOption configures a RetryFilter.
+type RetryOption func(*retryOptions)
+
+type retryOptions struct {
+	maxAttempts  int
+	baseDelay    time.Duration
+	maxDelay     time.Duration
+	maxBodyBytes int64
+	methods      map[string]bool
+	retryable    func(Response) bool
+	budget       *RetryBudget
+	retries      libhttpmetrics.Counter
+}
+
+// RetryMaxAttempts sets the maximum number of times a request is sent, including the first. The default is 3.
+func RetryMaxAttempts(n int) RetryOption {
+	return func(o *retryOptions) {
+		o.maxAttempts = n
+	}
+}
+
+// RetryBackoff sets the delay before the first retry, which doubles for each retry after that up to max. Delays are
+// jittered, by up to half, so that clients which failed together don't retry together. The default is 50ms, up to
+// 5s.
+func RetryBackoff(base, max time.Duration) RetryOption {
+	return func(o *retryOptions) {
+		o.baseDelay = base
+		o.maxDelay = max
+	}
+}
+
+// RetryMethods allows requests with the passed methods to be retried, in addition to the idempotent methods (GET,
+// HEAD, OPTIONS, TRACE, PUT and DELETE). Requests with other methods can also opt in individually, by having an
+// Idempotency-Key header.
+func RetryMethods(methods ...string) RetryOption {
+	return func(o *retryOptions) {
+		for _, m := range methods {
+			o.methods[m] = true
+		}
+	}
+}
+
+// RetryIf sets the function which decides whether a response should be retried. By default, requests are retried if
+// they failed without a response (because the connection failed, say) or with a 429, 502, 503 or 504 status.
+func RetryIf(f func(Response) bool) RetryOption {
+	return func(o *retryOptions) {
+		o.retryable = f
+	}
+}
+
+// RetryMaxBodyBytes sets the largest request body which is retried; the default is 1MiB. Bodies are held in memory so
+// that they can be sent again, and requests with larger bodies are only sent once.
+func RetryMaxBodyBytes(n int64) RetryOption {
+	return func(o *retryOptions) {
+		o.maxBodyBytes = n
+	}
+}
+
+// RetryUsingBudget limits retries with the passed budget, which may be shared between several filters (all those
+// sending requests to a given upstream, for example).
+func RetryUsingBudget(b *RetryBudget) RetryOption {
+	return func(o *retryOptions) {
+		o.budget = b
+	}
+}
+
+// A RetryBudget limits the proportion of requests which are retried, so that retries don't multiply the load on an
+// upstream which is failing. It is safe for concurrent use.
+type RetryBudget struct {
+	m       sync.Mutex
+	ratio   float64
+	balance float64
+	max     float64
+	minimum *tokenBucket
+}
+
+// NewRetryBudget returns a budget which allows retries of up to ratio of the requests sent (0.1 allows a retry for
+// every 10 requests, averaged over roughly the last 1000), plus minPerSecond retries a second regardless, so that
+// clients which send few requests can still retry.
+func NewRetryBudget(ratio float64, minPerSecond int) *RetryBudget {
+	b := &RetryBudget{
+		ratio: ratio,
+		max:   ratio * 1000}
+	if minPerSecond > 0 {
+		b.minimum = newTokenBucket(float64(minPerSecond), minPerSecond)
+	}
+	return b
+}
+
+// deposit accrues credit for a request.
+func (b *RetryBudget) deposit() {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.balance += b.ratio
+	if b.balance > b.max {
+		b.balance = b.max
+	}
+}
+
+// withdraw spends credit for a retry, returning false if there is none.
+func (b *RetryBudget) withdraw() bool {
+	b.m.Lock()
+	if b.balance >= 1 {
+		b.balance--
+		b.m.Unlock()
+		return true
+	}
+	b.m.Unlock()
+	return b.minimum != nil && b.minimum.take(1)
+}
+
+// RetryFilter returns a client Filter which retries requests which fail with errors that are likely to be transient,
+// with exponential backoff:
+//
+//  client := libhttp.NewClient(libhttp.WithClientFilters(libhttp.ErrorFilter, libhttp.RetryFilter(
+//      libhttp.RetryMaxAttempts(4),
+//      libhttp.RetryUsingBudget(libhttp.NewRetryBudget(0.1, 10)))))
+//
+// Only requests which are safe to repeat are retried (see RetryMethods). A Retry-After header in the response is
+// honoured, if it doesn't ask for a longer delay than the backoff's maximum (in which case the response is returned
+// without retrying). Retries stop when the request's context is done, or if they would be made after its deadline.
+//
+// Request bodies are buffered in memory, so that they can be sent with each attempt (see RetryMaxBodyBytes).
+func RetryFilter(opts ...RetryOption) Filter {
+	o := retryOptions{
+		maxAttempts:  3,
+		baseDelay:    50 * time.Millisecond,
+		maxDelay:     5 * time.Second,
+		maxBodyBytes: 1 << 20,
+		methods:      idempotentMethods(),
+		retryable:    retryableResponse}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return func(req Request, svc Service) Response {
+		if o.maxAttempts <= 1 || (!o.methods[req.Method] && req.Header.Get("Idempotency-Key") == "") {
+			return svc(req)
+		}
+		body, ok, err := replayableBody(&req, o.maxBodyBytes)
+		switch {
+		case err != nil:
+			return Response{
+				Request: &req,
+				Error:   terrors.Wrap(err, nil)}
+		case !ok:
+			return svc(req) // can't be retried
+		}
+		if o.budget != nil {
+			o.budget.deposit()
+		}
+
+		for attempt := 1; ; attempt++ {
+			rsp := svc(withReplayedBody(req, body))
+			if attempt >= o.maxAttempts || !o.retryable(rsp) || (req.Context != nil && req.Err() != nil) {
+				return rsp
+			}
+
+			delay := o.backoff(attempt)
+			if d, ok := retryAfter(rsp); ok {
+				if d > o.maxDelay {
+					return rsp
+				}
+				if d > delay {
+					delay = d
+				}
+			}
+			if req.Context != nil {
+				if deadline, ok := req.Deadline(); ok && time.Until(deadline) < delay {
+					return rsp
+				}
+			}
+			if o.budget != nil && !o.budget.withdraw() {
+				return rsp
+			}
+
+			if o.retries != nil {
+				o.retries.Add(1, req.Method, req.URL.Host)
+			}
+			discardResponse(rsp)
+			if err := sleepContext(req, delay); err != nil {
+				return Response{
+					Request: &req,
+					Error:   terrors.Wrap(err, nil)}
+			}
+		}
+	}
+}
+
+// idempotentMethods returns the set of methods which are safe to repeat.
+func idempotentMethods() map[string]bool {
+	return map[string]bool{
+		http.MethodGet:     true,
+		http.MethodHead:    true,
+		http.MethodOptions: true,
+		http.MethodTrace:   true,
+		http.MethodPut:     true,
+		http.MethodDelete:  true}
+}
+
+// replayableBody buffers the request's body so that it can be sent more than once, returning false if it's larger
+// than limit.
+func replayableBody(req *Request, limit int64) ([]byte, bool, error) {
+	if req.Body == nil {
+		return nil, true, nil
+	}
+	b, err := req.BufferBody(limit)
+	switch {
+	case terrors.PrefixMatches(err, terrors.ErrBadRequest, "body_too_large"):
+		return nil, false, nil
+	case err != nil:
+		return nil, false, err
+	}
+	return b, true, nil
+}
+
+// withReplayedBody returns a copy of the request with its own reader over a body buffered by replayableBody.
+func withReplayedBody(req Request, body []byte) Request {
+	switch {
+	case req.Body == nil:
+	case len(body) == 0:
+		req.Body = http.NoBody
+	default:
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+	}
+	return req
+}
+
+// backoff returns the delay before the passed retry, with jitter.
+func (o retryOptions) backoff(attempt int) time.Duration {
+	d := o.baseDelay
+	for i := 1; i < attempt && d < o.maxDelay; i++ {
+		d *= 2
+	}
+	if d > o.maxDelay {
+		d = o.maxDelay
+	}
+	if d <= 1 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// retryableResponse is the default test for whether a response should be retried.
+func retryableResponse(rsp Response) bool {
+	if rsp.Response == nil {
+		return rsp.Error != nil
+	}
+	switch rsp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter returns the delay requested by a response's Retry-After header, which may be a number of seconds or a
+// date.
+func retryAfter(rsp Response) (time.Duration, bool) {
+	if rsp.Response == nil {
+		return 0, false
+	}
+	v := rsp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+// discardResponse releases a response which won't be used, reading a little of its body so that the connection can be
+// reused.
+func discardResponse(rsp Response) {
+	if rsp.Response == nil || rsp.Body == nil {
+		return
+	}
+	io.CopyN(ioutil.Discard, rsp.Body, 4096)
+	rsp.Body.Close()
+}
+
+// sleepContext waits for d, or until the request's context is done.
+func sleepContext(req Request, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	var done <-chan struct{}
+	if req.Context != nil {
+		done = req.Done()
+	}
+	select {
+	case <-t.C:
+		return nil
+	case <-done:
+		return req.Err()
+	}
+}
@@ -0,0 +1,134 @@
+package libhttp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryFilter(t *testing.T) {
+	t.Parallel()
+	// flaky fails with the passed statuses in turn, then succeeds; the bodies it received are recorded
+	flaky := func(bodies *[]string, statuses ...int) Service {
+		return func(req Request) Response {
+			b, err := req.BodyBytes(true)
+			require.NoError(t, err)
+			*bodies = append(*bodies, string(b))
+			if len(*bodies) <= len(statuses) {
+				rsp := req.Response(nil)
+				rsp.StatusCode = statuses[len(*bodies)-1]
+				if rsp.StatusCode == http.StatusTooManyRequests {
+					rsp.Header.Set("Retry-After", "60")
+				}
+				return rsp
+			}
+			return req.Response("ok")
+		}
+	}
+	retry := RetryFilter(RetryBackoff(time.Millisecond, 10*time.Millisecond))
+
+	// Idempotent requests are retried, with their bodies
+	var bodies []string
+	svc := flaky(&bodies, http.StatusServiceUnavailable, http.StatusBadGateway).Filter(retry)
+	req := NewRequest(context.Background(), "PUT", "/", "body")
+	rsp := svc(req)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Equal(t, []string{"\"body\"\n", "\"body\"\n", "\"body\"\n"}, bodies)
+
+	// …up to the maximum number of attempts
+	bodies = nil
+	svc = flaky(&bodies, 503, 503, 503, 503).Filter(retry)
+	rsp = svc(NewRequest(context.Background(), "GET", "/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rsp.StatusCode)
+	assert.Len(t, bodies, 3)
+
+	// Other requests aren't retried, unless they have an idempotency key…
+	bodies = nil
+	svc = flaky(&bodies, 503).Filter(retry)
+	req = NewRequest(context.Background(), "POST", "/", nil)
+	assert.Equal(t, http.StatusServiceUnavailable, svc(req).StatusCode)
+	assert.Len(t, bodies, 1)
+	bodies = nil
+	req.Header.Set("Idempotency-Key", "abc")
+	assert.Equal(t, http.StatusOK, svc(req).StatusCode)
+	assert.Len(t, bodies, 2)
+
+	// …or the method is allowed
+	bodies = nil
+	svc = flaky(&bodies, 503).Filter(RetryFilter(RetryMethods("POST"), RetryBackoff(time.Millisecond, time.Millisecond)))
+	assert.Equal(t, http.StatusOK, svc(NewRequest(context.Background(), "POST", "/", nil)).StatusCode)
+
+	// Responses which aren't transient failures aren't retried
+	bodies = nil
+	svc = flaky(&bodies, http.StatusInternalServerError).Filter(retry)
+	assert.Equal(t, http.StatusInternalServerError, svc(NewRequest(context.Background(), "GET", "/", nil)).StatusCode)
+
+	// Nor are those asking to be retried later than the maximum delay
+	bodies = nil
+	svc = flaky(&bodies, http.StatusTooManyRequests).Filter(retry)
+	assert.Equal(t, http.StatusTooManyRequests, svc(NewRequest(context.Background(), "GET", "/", nil)).StatusCode)
+
+	// Errors without a response are retried
+	attempts := 0
+	svc = Service(func(req Request) Response {
+		attempts++
+		if attempts == 1 {
+			return Response{Error: errors.New("connection reset")}
+		}
+		return req.Response(nil)
+	}).Filter(retry)
+	rsp = svc(NewRequest(context.Background(), "GET", "/", nil))
+	assert.NoError(t, rsp.Error)
+	assert.Equal(t, 2, attempts)
+
+	// Bodies which are too large are sent once
+	bodies = nil
+	svc = flaky(&bodies, 503).Filter(RetryFilter(RetryMaxBodyBytes(2)))
+	assert.Equal(t, http.StatusServiceUnavailable, svc(NewRequest(context.Background(), "PUT", "/", "body")).StatusCode)
+	assert.Equal(t, []string{"\"body\"\n"}, bodies)
+
+	// Retries stop if the context's deadline would pass
+	bodies = nil
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	svc = flaky(&bodies, 503).Filter(RetryFilter(RetryBackoff(time.Second, time.Second)))
+	start := time.Now()
+	assert.Equal(t, http.StatusServiceUnavailable, svc(NewRequest(ctx, "GET", "/", nil)).StatusCode)
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+}
+
+func TestRetryBudget(t *testing.T) {
+	t.Parallel()
+	attempts := 0
+	svc := Service(func(req Request) Response {
+		attempts++
+		rsp := req.Response(nil)
+		rsp.StatusCode = http.StatusServiceUnavailable
+		return rsp
+	})
+
+	// With no credit, nothing is retried
+	budget := NewRetryBudget(0.5, 0)
+	retried := svc.Filter(RetryFilter(RetryBackoff(0, 0), RetryUsingBudget(budget)))
+	retried(NewRequest(context.Background(), "GET", "/", nil))
+	assert.Equal(t, 1, attempts)
+
+	// Each request earns half a retry, so the next request can be retried once
+	attempts = 0
+	retried(NewRequest(context.Background(), "GET", "/", nil))
+	assert.Equal(t, 2, attempts)
+
+	// The minimum allows retries regardless
+	attempts = 0
+	retried = svc.Filter(RetryFilter(RetryBackoff(0, 0), RetryUsingBudget(NewRetryBudget(0, 2))))
+	retried(NewRequest(context.Background(), "GET", "/", nil))
+	assert.Equal(t, 3, attempts)
+	attempts = 0
+	retried(NewRequest(context.Background(), "GET", "/", nil))
+	assert.Equal(t, 1, attempts)
+}
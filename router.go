@@ -1,21 +1,17 @@
 package libhttp
 
 import (
-	"context"
 	"fmt"
+	"net/url"
 	"regexp"
 	"strings"
 
 	"github.com/monzo/terrors"
 )
 
-// We use a custom type to guarantee we won't get a collision with another package. Using an anonymous struct type
-// directly means we'd get a collision with any other package that does the same.
-// https://play.golang.org/p/MxhRiL37R-9
-type routerContextKeyType struct{}
-
 var (
-	routerContextKey   = routerContextKeyType{}
+	routerContextKey   = NewContextKey("router", (*Router)(nil))
+	operationKey       = NewContextKey("operation", "")
 	routerComponentsRe = regexp.MustCompile(`(?:^|/)(\*\w*|:\w+)`)
 )
 
@@ -34,14 +30,13 @@ func (e routerEntry) String() string {
 // parameters from paths.
 type Router struct {
 	entries []routerEntry
+	names   map[string]string // route name → pattern
 }
 
 // RouterForRequest returns a pointer to the Router that successfully dispatched the request, or nil.
 func RouterForRequest(r Request) *Router {
-	if v := r.Context.Value(routerContextKey); v != nil {
-		return v.(*Router)
-	}
-	return nil
+	v, _ := Value(r, routerContextKey)
+	return v.(*Router)
 }
 
 func (r *Router) compile(pattern string) *regexp.Regexp {
@@ -86,6 +81,64 @@ func (r *Router) Register(method, pattern string, svc Service) {
 		re:      re})
 }
 
+// RegisterNamed associates a Service with a method and path, as Register does, and names the route so that URLs for it
+// can be built with URL (and requests redirected to it with Request.RedirectToRoute).
+func (r *Router) RegisterNamed(name, method, pattern string, svc Service) {
+	r.Register(method, pattern, svc)
+	if r.names == nil {
+		r.names = map[string]string{}
+	}
+	r.names[name] = pattern
+}
+
+// URL builds the path of the named route, substituting the passed parameters (given as name, value pairs) into its
+// pattern:
+//  r.RegisterNamed("user", "GET", "/users/:id", svc)
+//  r.URL("user", "id", "42") // "/users/42"
+//
+// Values of :name parameters are escaped as a single path component; those of *residual components may contain
+// slashes.
+func (r Router) URL(name string, params ...string) (string, error) {
+	pattern, ok := r.names[name]
+	if !ok {
+		return "", fmt.Errorf("no route named %q", name)
+	}
+	if len(params)%2 != 0 {
+		return "", fmt.Errorf("odd number of parameters building URL for route %q", name)
+	}
+	values := make(map[string]string, len(params)/2)
+	for i := 0; i < len(params); i += 2 {
+		values[params[i]] = params[i+1]
+	}
+
+	path, pos := ``, 0
+	for _, m := range routerComponentsRe.FindAllStringSubmatchIndex(pattern, -1) {
+		path += pattern[pos:m[2]]
+		pos = m[3]
+		sigil, param := pattern[m[2]], pattern[m[2]+1:m[3]]
+		if sigil == '*' && param == "" { // bare residual: matches anything, including nothing
+			continue
+		}
+		v, ok := values[param]
+		if !ok {
+			return "", fmt.Errorf("missing parameter %q building URL for route %q", param, name)
+		}
+		if sigil == ':' {
+			if v == "" {
+				return "", fmt.Errorf("empty parameter %q building URL for route %q", param, name)
+			}
+			path += url.PathEscape(v)
+		} else {
+			segments := strings.Split(v, "/")
+			for i, seg := range segments {
+				segments[i] = url.PathEscape(seg)
+			}
+			path += strings.Join(segments, "/")
+		}
+	}
+	return path + pattern[pos:], nil
+}
+
 // lookup is the internal version of Lookup, but it extracts path parameters into the passed map (and skips it if the
 // map is nil)
 func (r Router) lookup(method, path string, params map[string]string) (Service, string, bool) {
@@ -123,7 +176,7 @@ func (r Router) Serve() Service {
 			rsp.Error = terrors.NotFound("no_handler", txt, nil)
 			return rsp
 		}
-		req.Context = context.WithValue(req.Context, routerContextKey, &r)
+		req = SetValue(req, routerContextKey, &r)
 		rsp := svc(req)
 		if rsp.Request == nil {
 			rsp.Request = &req
@@ -132,6 +185,33 @@ func (r Router) Serve() Service {
 	}
 }
 
+// SetOperation names the operation the request performs, for endpoints which serve many operations on one route (like
+// GraphQL or JSON-RPC endpoints). Metrics, access logs and error reports then record the request's route as the
+// route's pattern and the operation, separated by "#" (like "/graphql#GetUser"), so the operations can be told apart.
+// For filters to see the name, the Service must respond to the returned request (with its Response method, say).
+func SetOperation(req Request, name string) Request {
+	return SetValue(req, operationKey, name)
+}
+
+// Operation returns the name of the operation the request performs, if it was set with SetOperation.
+func Operation(req Request) string {
+	v, _ := Value(req, operationKey)
+	return v.(string)
+}
+
+// routeOf returns the route of a request, as recorded by metrics and logs: the pattern of the Router route which
+// handled it, if any, and the name of its operation, if it was set.
+func routeOf(req Request) string {
+	route := ""
+	if router, ok := Value(req, routerContextKey); ok {
+		route = router.(*Router).Pattern(req)
+	}
+	if op := Operation(req); op != "" {
+		route += "#" + op
+	}
+	return route
+}
+
 // Pattern returns the registered pattern which matches the given request.
 func (r Router) Pattern(req Request) string {
 	_, pattern, _ := r.lookup(req.Method, req.URL.Path, nil)
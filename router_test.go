@@ -126,3 +126,25 @@ func TestRouterSetsRequest(t *testing.T) {
 	req.Context = rsp.Request.Context
 	assert.Equal(t, req, *rsp.Request)
 }
+
+func TestSetOperation(t *testing.T) {
+	t.Parallel()
+
+	router := Router{}
+	router.POST("/rpc", func(req Request) Response {
+		req = SetOperation(req, req.URL.Query().Get("op"))
+		return req.Response(Operation(req))
+	})
+	out := &lockedBuffer{}
+	svc := router.Serve().Filter(AccessLogFilter(out, AccessLogFormat(LogFormatJSON), AccessLogFields("route")))
+
+	ctx := context.Background()
+	for _, op := range []string{"getUser", ""} {
+		rsp := svc(NewRequest(ctx, "POST", "/rpc?op="+op, nil))
+		require.NoError(t, rsp.Error)
+		var body string
+		require.NoError(t, rsp.Decode(&body))
+		assert.Equal(t, op, body)
+	}
+	assert.Equal(t, "{\"route\":\"/rpc#getUser\"}\n{\"route\":\"/rpc\"}\n", out.String())
+}
@@ -0,0 +1,49 @@
+package libhttp
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/monzo/slog"
+)
+
+var errServerStopped = errors.New("libhttp: server stopped unexpectedly")
+
+// Run starts a HTTP server on the passed address (chosen as in Listen) and blocks until the context is cancelled or
+// the process receives SIGINT or SIGTERM. The server is then stopped gracefully, waiting up to the shutdown timeout
+// (see WithShutdownTimeout) for in-flight requests to complete.
+//
+// A nil error is returned if the server was shut down as requested; otherwise the error reports why the server could
+// not be started or why it stopped.
+func Run(ctx context.Context, svc Service, addr string, opts ...ServerOption) error {
+	o := newServerOptions(opts)
+	srv, err := Listen(svc, addr, opts...)
+	if err != nil {
+		return err
+	}
+	slog.Info(ctx, "👋  Listening on %v", srv.Listener().Addr())
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	select {
+	case err := <-srv.ErrChan():
+		if err == nil {
+			err = errServerStopped
+		}
+		return err
+	case sig := <-sigs:
+		slog.Info(ctx, "☠️  Received %v; shutting down", sig)
+	case <-ctx.Done():
+		slog.Info(ctx, "☠️  Shutting down")
+	}
+
+	stopCtx, cancel := context.WithTimeout(context.Background(), o.shutdownTimeout)
+	defer cancel()
+	srv.Stop(stopCtx)
+	return nil
+}
@@ -0,0 +1,35 @@
+package libhttp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- Run(ctx, Service(func(req Request) Response {
+			return req.Response(nil)
+		}), "localhost:0", WithShutdownTimeout(time.Second))
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "Run did not return after its context was cancelled")
+	}
+
+	// A bad address should be reported
+	assert.Error(t, Run(context.Background(), Service(func(req Request) Response {
+		return req.Response(nil)
+	}), "localhost:-1"))
+}
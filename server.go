@@ -4,13 +4,17 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
-	"log"
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/deckarep/golang-set"
 	"github.com/monzo/slog"
 )
 
@@ -19,8 +23,27 @@ type Server struct {
 	srv            *http.Server
 	shuttingDown   chan struct{}
 	shutdownOnce   sync.Once
-	shutdownFuncs  []func(context.Context)
+	shutdownHooks  []shutdownHook
+	shutdownErrs   []error
 	shutdownFuncsM sync.Mutex
+	activeM        sync.Mutex
+	active         int64         // guarded by activeM
+	idle           chan struct{} // closed when there are no active requests; guarded by activeM
+	panicHooks     []func(context.Context, interface{}, []byte)
+	panicHooksM    sync.Mutex
+	abortOnPanic   bool
+	serveErr       chan error // receives the error that serving failed with, if any; closed when serving ends
+	hijackedConns  mapset.Set // of *hijackedConn
+	started        time.Time
+	connsM         sync.Mutex
+	conns          map[net.Conn]http.ConnState // open connections, guarded by connsM
+}
+
+// shutdownHook is a function run when the server is stopped.
+type shutdownHook struct {
+	name     string
+	priority int
+	f        func(context.Context) error
 }
 
 // Listener returns the network listener that this server is active on.
@@ -28,6 +51,30 @@ func (s *Server) Listener() net.Listener {
 	return s.l
 }
 
+// ErrChan returns a channel which receives the error that the server failed with, if it stops serving for any reason
+// other than being stopped. The channel is closed once the server is no longer serving, so a receive on it yields
+// nil after a normal shutdown.
+func (s *Server) ErrChan() <-chan error {
+	return s.serveErr
+}
+
+// serve runs the passed serving function in the background. If it fails, the error is logged and made available from
+// ErrChan, and the server is stopped.
+func (s *Server) serve(f func() error) {
+	go func() {
+		defer close(s.serveErr)
+		err := f()
+		if err != nil && err != http.ErrServerClosed {
+			slog.Error(nil, "HTTP server error: %v", err)
+			s.serveErr <- err
+			// Stopping with an already-closed context means we go immediately to "forceful" mode
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			s.Stop(ctx)
+		}
+	}()
+}
+
 // Done returns a channel that will be closed when the server begins to shutdown. The server may still be draining its
 // connections at the time the channel is closed.
 func (s *Server) Done() <-chan struct{} {
@@ -36,12 +83,15 @@ func (s *Server) Done() <-chan struct{} {
 
 // Stop shuts down the server, returning when there are no more connections still open. Graceful shutdown will be
 // attempted until the passed context expires, at which time all connections will be forcibly terminated.
+//
+// Shutdown hooks registered with OnShutdown are run while the server drains; any errors they return are available
+// from ShutdownErrors once Stop returns.
 func (s *Server) Stop(ctx context.Context) {
 	s.shutdownFuncsM.Lock()
 	defer s.shutdownFuncsM.Unlock()
 	s.shutdownOnce.Do(func() {
 		close(s.shuttingDown)
-		// Shut down the HTTP server in parallel to calling any custom shutdown functions
+		// Shut down the HTTP server in parallel to running any shutdown hooks
 		wg := sync.WaitGroup{}
 		wg.Add(1)
 		go func() {
@@ -54,99 +104,260 @@ func (s *Server) Stop(ctx context.Context) {
 				}
 			}
 		}()
-		for _, f := range s.shutdownFuncs {
-			f := f // capture range variable
+		s.shutdownErrs = s.runShutdownHooks(ctx)
+		wg.Wait()
+	})
+}
+
+// runShutdownHooks runs the server's shutdown hooks in ascending priority order. Hooks which share a priority are run
+// concurrently, and all of them must complete before those of the next priority are started.
+func (s *Server) runShutdownHooks(ctx context.Context) []error {
+	hooks := append([]shutdownHook(nil), s.shutdownHooks...)
+	sort.SliceStable(hooks, func(i, j int) bool {
+		return hooks[i].priority < hooks[j].priority
+	})
+
+	var errs []error
+	errsM := sync.Mutex{}
+	for i := 0; i < len(hooks); {
+		j := i
+		wg := sync.WaitGroup{}
+		for ; j < len(hooks) && hooks[j].priority == hooks[i].priority; j++ {
+			h := hooks[j]
 			wg.Add(1)
 			go func() {
 				defer wg.Done()
-				f(ctx)
+				defer func() {
+					if v := recover(); v != nil {
+						s.panicked(ctx, v, nil, 0)
+						errsM.Lock()
+						errs = append(errs, fmt.Errorf("shutdown hook %s panicked: %v", h.name, v))
+						errsM.Unlock()
+					}
+				}()
+				if err := h.f(ctx); err != nil {
+					slog.Warn(ctx, "Shutdown hook %s failed: %v", h.name, err)
+					errsM.Lock()
+					errs = append(errs, fmt.Errorf("shutdown hook %s: %w", h.name, err))
+					errsM.Unlock()
+				}
 			}()
 		}
 		wg.Wait()
-	})
+		i = j
+	}
+	return errs
 }
 
-// addShutdownFunc registers a function that will be called when the server is stopped. The function is expected to try
+// OnShutdown registers a function that will be called when the server is stopped. The function is expected to try
 // to shutdown gracefully until the context expires, at which time it should terminate its work forcefully.
-func (s *Server) addShutdownFunc(f func(context.Context)) {
+//
+// Hooks are run in ascending order of priority (those with the same priority run concurrently), while the server
+// drains its connections. Errors are collected and can be retrieved with ShutdownErrors.
+func (s *Server) OnShutdown(name string, priority int, f func(context.Context) error) {
 	s.shutdownFuncsM.Lock()
 	defer s.shutdownFuncsM.Unlock()
-	s.shutdownFuncs = append(s.shutdownFuncs, f)
+	s.shutdownHooks = append(s.shutdownHooks, shutdownHook{
+		name:     name,
+		priority: priority,
+		f:        f})
 }
 
-// Serve starts a HTTP server, binding the passed Service to the passed listener.
-func Serve(svc Service, l net.Listener) (*Server, error) {
+// ShutdownErrors returns the errors returned by shutdown hooks when the server was stopped. It blocks while the server
+// is stopping.
+func (s *Server) ShutdownErrors() []error {
+	s.shutdownFuncsM.Lock()
+	defer s.shutdownFuncsM.Unlock()
+	return s.shutdownErrs
+}
+
+// addShutdownFunc registers an internal function that will be called when the server is stopped. The function is
+// expected to try to shutdown gracefully until the context expires, at which time it should terminate its work
+// forcefully.
+func (s *Server) addShutdownFunc(f func(context.Context)) {
+	s.OnShutdown("internal", 0, func(ctx context.Context) error {
+		f(ctx)
+		return nil
+	})
+}
+
+// newServer constructs a Server on the passed listener, returning it along with the handler which it should use to
+// serve the passed Service.
+func newServer(svc Service, l net.Listener, opts []ServerOption) (*Server, http.Handler) {
+	o := newServerOptions(opts)
+	if o.bandwidth != (BandwidthLimits{}) {
+		l = RateLimitListener(l, o.bandwidth)
+	}
 	s := &Server{
-		l:            l,
-		shuttingDown: make(chan struct{})}
+		l:             l,
+		shuttingDown:  make(chan struct{}),
+		idle:          make(chan struct{}),
+		serveErr:      make(chan error, 1),
+		hijackedConns: mapset.NewSet(),
+		started:       time.Now(),
+		conns:         map[net.Conn]http.ConnState{},
+		abortOnPanic:  o.abortOnPanic}
+	close(s.idle)
+	s.addShutdownFunc(s.drainHijacked)
+	svc = svc.Filter(writtenResponseFilter).Filter(s.recoverFilter)
+	if o.metrics != nil {
+		svc = svc.Filter(serverMetricsFilter(o.metrics))
+	}
+	if o.meters != nil {
+		svc = svc.Filter(serverMeterFilter(o.meters.Meter(meterScope)))
+	}
+	if len(o.hooks) > 0 {
+		svc = svc.Filter(serverHooksFilter(o.hooks))
+		for _, h := range o.hooks {
+			s.OnPanic(h.OnPanic)
+		}
+	}
 	svc = svc.Filter(func(req Request, svc Service) Response {
 		req.server = s
 		return svc(req)
 	})
+	h := s.recoverHandler(HttpHandler(svc))
+	return s, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		// Requests are tracked until their response has been fully written, including any streamed body
+		s.requestStarted()
+		defer s.requestFinished()
+		h.ServeHTTP(rw, r)
+	})
+}
+
+func (s *Server) requestStarted() {
+	s.activeM.Lock()
+	defer s.activeM.Unlock()
+	if s.active == 0 {
+		s.idle = make(chan struct{})
+	}
+	s.active++
+}
+
+func (s *Server) requestFinished() {
+	s.activeM.Lock()
+	defer s.activeM.Unlock()
+	s.active--
+	if s.active == 0 {
+		close(s.idle)
+	}
+}
+
+// Started returns when the server was created.
+func (s *Server) Started() time.Time {
+	return s.started
+}
+
+// trackConn records the state of the server's connections.
+func (s *Server) trackConn(c net.Conn, state http.ConnState) {
+	s.connsM.Lock()
+	defer s.connsM.Unlock()
+	switch state {
+	case http.StateClosed, http.StateHijacked:
+		delete(s.conns, c)
+	default:
+		s.conns[c] = state
+	}
+}
+
+// OpenConnections returns the number of connections open to the server, by state: "new", "active" and "idle" for
+// connections being served, and "hijacked" for those which have been hijacked (see Request.Hijack).
+func (s *Server) OpenConnections() map[string]int {
+	counts := map[string]int{}
+	s.connsM.Lock()
+	for _, state := range s.conns {
+		counts[state.String()]++
+	}
+	s.connsM.Unlock()
+	if n := s.hijackedConns.Cardinality(); n > 0 {
+		counts["hijacked"] = n
+	}
+	return counts
+}
+
+// ActiveRequests returns the number of requests that the server is currently handling.
+func (s *Server) ActiveRequests() int64 {
+	s.activeM.Lock()
+	defer s.activeM.Unlock()
+	return s.active
+}
+
+// WaitIdle blocks until the server has no requests in flight, or the passed context expires (in which case the
+// context's error is returned). New requests may of course arrive as soon as it returns; to stop accepting requests,
+// use Stop.
+func (s *Server) WaitIdle(ctx context.Context) error {
+	s.activeM.Lock()
+	idle := s.idle
+	s.activeM.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Serve starts a HTTP server, binding the passed Service to the passed listener.
+func Serve(svc Service, l net.Listener, opts ...ServerOption) (*Server, error) {
+	s, h := newServer(svc, l, opts)
 	s.srv = &http.Server{
-		Handler:        HttpHandler(svc),
-		MaxHeaderBytes: http.DefaultMaxHeaderBytes}
-	go func() {
-		err := s.srv.Serve(l)
-		if err != nil && err != http.ErrServerClosed {
-			slog.Error(nil, "HTTP server error: %v", err)
-			// Stopping with an already-closed context means we go immediately to "forceful" mode
-			ctx, cancel := context.WithCancel(context.Background())
-			cancel()
-			s.Stop(ctx)
-		}
-	}()
+		Handler:        h,
+		MaxHeaderBytes: http.DefaultMaxHeaderBytes,
+		ConnState:      s.trackConn}
+	s.serve(func() error {
+		return s.srv.Serve(s.l)
+	})
 	return s, nil
 }
 
-// Serve starts a HTTPS server, binding the passed Service to the passed listener.
-func ServeTLS(svc Service, l net.Listener, certFile, keyFile string, cfg *tls.Config, ) (*Server, error) {
-	s := &Server{
-		l:            l,
-		shuttingDown: make(chan struct{})}
-	svc = svc.Filter(func(req Request, svc Service) Response {
-		req.server = s
-		return svc(req)
-	})
+// ServeTLS starts a HTTPS server, binding the passed Service to the passed listener.
+//
+// cfg may be one of the TLSProfile presets (optionally modified); if it is nil, TLSProfileIntermediate is used. Only
+// HTTP/1 is served; to serve HTTP/2 (for gRPC, say), use Serve with a TLS listener which negotiates "h2".
+func ServeTLS(svc Service, l net.Listener, certFile, keyFile string, cfg *tls.Config, opts ...ServerOption) (*Server, error) {
+	s, h := newServer(svc, l, opts)
 	if cfg == nil {
-		cfg = &tls.Config{
-			MinVersion:               tls.VersionTLS12,
-			CurvePreferences:         []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256},
-			PreferServerCipherSuites: true,
-			CipherSuites: []uint16{
-				tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
-				tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
-				tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
-				tls.TLS_RSA_WITH_AES_256_CBC_SHA,
-			},
+		cfg = TLSProfileIntermediate()
+	}
+
+	// Load the certificate ourselves rather than leaving it to net/http, so that if its issuer runs an OCSP responder
+	// we can staple (and keep refreshing) its response, as we do for certificates configured in cfg
+	cfg = cfg.Clone()
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
 		}
+		cfg.Certificates = append(cfg.Certificates, cert)
+		certFile, keyFile = "", ""
+	}
+	staplers := newOCSPStaplers()
+	cfg.GetCertificate = staplers.getCertificate(cfg)
+	s.addShutdownFunc(staplers.shutdown)
+	if o := newServerOptions(opts); o.clientCAs != nil {
+		cfg = o.clientCAs.tlsConfig(cfg)
 	}
 
 	s.srv = &http.Server{
-		Handler:        HttpHandler(svc),
+		Handler:        h,
 		MaxHeaderBytes: http.DefaultMaxHeaderBytes,
+		ConnState:      s.trackConn,
 		TLSConfig:      cfg,
 		TLSNextProto:   make(map[string]func(*http.Server, *tls.Conn, http.Handler), 0),
 	}
 
-	go func() {
-		err := s.srv.ServeTLS(l, certFile, keyFile)
-		if err != nil && err != http.ErrServerClosed {
-			slog.Error(nil, "HTTP server error: %v", err)
-			// Stopping with an already-closed context means we go immediately to "forceful" mode
-			ctx, cancel := context.WithCancel(context.Background())
-			cancel()
-			s.Stop(ctx)
-		}
-	}()
+	s.serve(func() error {
+		return s.srv.ServeTLS(s.l, certFile, keyFile)
+	})
 	return s, nil
 }
 
-func Listen(svc Service, addr string) (*Server, error) {
-	// Determine on which address to listen, choosing in order one of:
-	// 1. The passed addr
-	// 2. PORT variable (listening on all interfaces)
-	// 3. Random, available port, on the loopback interface only
+// listenTCP listens on the passed TCP address, applying any listener options. If addr is empty, the address is
+// chosen in order from:
+// 1. LISTEN_ADDR variable
+// 2. PORT variable (listening on all interfaces)
+// 3. Random, available port
+func listenTCP(addr string, o serverOptions) (net.Listener, error) {
 	if addr == "" {
 		if _addr := os.Getenv("LISTEN_ADDR"); _addr != "" {
 			addr = _addr
@@ -156,87 +367,101 @@ func Listen(svc Service, addr string) (*Server, error) {
 			addr = ":0"
 		}
 	}
-	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	lc := net.ListenConfig{
+		Control: o.listenControl}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+// Listen starts a HTTP server on the passed TCP address, binding the passed Service to it.
+func Listen(svc Service, addr string, opts ...ServerOption) (*Server, error) {
+	l, err := listenTCP(addr, newServerOptions(opts))
 	if err != nil {
 		return nil, err
 	}
+	return Serve(svc, l, opts...)
+}
 
-	l, err := net.ListenTCP("tcp", tcpAddr)
+// ListenTLS starts a HTTPS server on the passed TCP address, binding the passed Service to it.
+func ListenTLS(svc Service, addr, certFile, keyFile string, cfg *tls.Config, opts ...ServerOption) (*Server, error) {
+	l, err := listenTCP(addr, newServerOptions(opts))
 	if err != nil {
 		return nil, err
 	}
-	return Serve(svc, l)
+	return ServeTLS(svc, l, certFile, keyFile, cfg, opts...)
 }
 
-func ListenTLS(svc Service, addr, certFile, keyFile string, cfg *tls.Config, ) (*Server, error) {
-	// Determine on which address to listen, choosing in order one of:
-	// 1. The passed addr
-	// 2. PORT variable (listening on all interfaces)
-	// 3. Random, available port, on the loopback interface only
-	if addr == "" {
-		if _addr := os.Getenv("LISTEN_ADDR"); _addr != "" {
-			addr = _addr
-		} else if port, err := strconv.Atoi(os.Getenv("PORT")); err == nil && port >= 0 {
-			addr = fmt.Sprintf(":%d", port)
+// unixSocketSeq distinguishes the sockets generated for servers within the same process.
+var unixSocketSeq int64
+
+// listenUnix listens on the passed unix socket path. If path is empty, the LISTEN_PATH variable is used, or failing
+// that a uniquely-named socket is created in the temporary directory.
+func listenUnix(path string) (*net.UnixListener, error) {
+	if path == "" {
+		if _path := os.Getenv("LISTEN_PATH"); _path != "" {
+			path = _path
 		} else {
-			addr = ":0"
+			n := atomic.AddInt64(&unixSocketSeq, 1)
+			path = filepath.Join(os.TempDir(), fmt.Sprintf("libhttp-%d-%d.sock", os.Getpid(), n))
 		}
 	}
-	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
-	if err != nil {
-		return nil, err
-	}
-
-	l, err := net.ListenTCP("tcp", tcpAddr)
-	if err != nil {
-		return nil, err
-	}
-	return ServeTLS(svc, l, certFile, keyFile, cfg)
+	return net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
 }
 
-func ListenUnix(svc Service, path string) (*Server, error, func()) {
-	// Determine on which address to listen, choosing in order one of:
-	// 1. The passed addr
-	// 2. PORT variable (listening on all interfaces)
-	// 3. Random, available port, on the loopback interface only
-	if path == "" {
-		if _addr := os.Getenv("LISTEN_PATH"); _addr != "" {
-			path = _addr
-		} else {
-			path = os.TempDir()
-			log.Printf("Serving on %s\n", path)
+// removeSocketFunc returns a shutdown function which removes the socket file of the passed listener.
+func removeSocketFunc(l *net.UnixListener) func(context.Context) error {
+	path := l.Addr().String()
+	return func(context.Context) error {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
 		}
+		return nil
 	}
+}
 
-	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+// ListenUnix starts a HTTP server listening on the unix socket at the passed path, binding the passed Service to it.
+// If path is empty, the LISTEN_PATH variable is used, or failing that a uniquely-named socket is created in the
+// temporary directory; the path chosen is available from the server's Listener.
+//
+// The socket file is removed when the server is stopped. The returned function also removes it, and remains only for
+// compatibility.
+func ListenUnix(svc Service, path string, opts ...ServerOption) (*Server, error, func()) {
+	l, err := listenUnix(path)
 	if err != nil {
 		return nil, err, nil
 	}
-
-	server, err := Serve(svc, l)
-	return server, err, func() {
-		os.Remove(path)
+	slog.Info(nil, "Serving on %s", l.Addr())
+	remove := removeSocketFunc(l)
+	server, err := Serve(svc, l, opts...)
+	if err == nil {
+		server.OnShutdown("unix socket", 0, remove)
 	}
+	return server, err, func() { _ = remove(context.Background()) }
 }
 
-func ListenUnixTLS(svc Service, path, certFile, keyFile string, cfg *tls.Config, ) (*Server, error, func()) {
-	// Determine on which address to listen, choosing in order one of:
-	// 1. The passed addr
-	// 2. PORT variable (listening on all interfaces)
-	// 3. Random, available port, on the loopback interface only
-	if path == "" {
-		if _addr := os.Getenv("LISTEN_PATH"); _addr != "" {
-			path = _addr
-		} else {
-			path = os.TempDir()
-			log.Printf("Serving on %s\n", path)
-		}
+// ListenUnixTLS is like ListenUnix, but serves TLS in the manner of ServeTLS.
+func ListenUnixTLS(svc Service, path, certFile, keyFile string, cfg *tls.Config, opts ...ServerOption) (*Server, error, func()) {
+	l, err := listenUnix(path)
+	if err != nil {
+		return nil, err, nil
+	}
+	slog.Info(nil, "Serving on %s", l.Addr())
+	remove := removeSocketFunc(l)
+	server, err := ServeTLS(svc, l, certFile, keyFile, cfg, opts...)
+	if err == nil {
+		server.OnShutdown("unix socket", 0, remove)
 	}
+	return server, err, func() { _ = remove(context.Background()) }
+}
 
-	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+// ListenPipe starts a HTTP server on a Windows named pipe, binding the passed Service to it. The name may either be a
+// full pipe path (\\.\pipe\name) or just the name. Only local clients are accepted. The server is configured with
+// the passed options, as for Listen.
+//
+// On other platforms, an error is always returned.
+func ListenPipe(svc Service, name string, opts ...ServerOption) (*Server, error) {
+	l, err := listenPipe(name)
 	if err != nil {
-		return nil, err, nil
+		return nil, err
 	}
-	server, err := ServeTLS(svc, l, certFile, keyFile, cfg)
-	return server, err, func() { _ = os.Remove(path) }
+	return Serve(svc, l, opts...)
 }
@@ -0,0 +1,149 @@
+package libhttp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// A ServerGroup is a set of Servers which serve the same Service on several listeners (for example a TCP port and a
+// unix socket), and which are stopped together.
+type ServerGroup struct {
+	servers      []*Server
+	shuttingDown chan struct{}
+	shutdownOnce sync.Once
+}
+
+// Servers returns the individual Servers that make up the group, in the order their listeners were passed.
+func (g *ServerGroup) Servers() []*Server {
+	return g.servers
+}
+
+// Listeners returns the network listeners that the group is active on.
+func (g *ServerGroup) Listeners() []net.Listener {
+	ls := make([]net.Listener, len(g.servers))
+	for i, s := range g.servers {
+		ls[i] = s.Listener()
+	}
+	return ls
+}
+
+// Done returns a channel that will be closed when the group begins to shutdown. The servers may still be draining
+// their connections at the time the channel is closed.
+func (g *ServerGroup) Done() <-chan struct{} {
+	return g.shuttingDown
+}
+
+// Stop shuts down all servers in the group in parallel, returning when none of them have any more connections open.
+// Graceful shutdown will be attempted until the passed context expires, at which time all connections will be forcibly
+// terminated.
+func (g *ServerGroup) Stop(ctx context.Context) {
+	g.shutdownOnce.Do(func() {
+		close(g.shuttingDown)
+		wg := sync.WaitGroup{}
+		for _, s := range g.servers {
+			s := s // capture range variable
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.Stop(ctx)
+			}()
+		}
+		wg.Wait()
+	})
+}
+
+// ServeAll starts a HTTP server on each of the passed listeners, binding the same Service to all of them. If any of
+// the servers cannot be started, those that were started are stopped and the error is returned.
+func ServeAll(svc Service, listeners ...net.Listener) (*ServerGroup, error) {
+	return serveAll(svc, listeners, nil)
+}
+
+// serveAll is ServeAll, with options for each of the servers.
+func serveAll(svc Service, listeners []net.Listener, opts []ServerOption) (*ServerGroup, error) {
+	g := &ServerGroup{
+		servers:      make([]*Server, 0, len(listeners)),
+		shuttingDown: make(chan struct{})}
+	for _, l := range listeners {
+		s, err := Serve(svc, l, opts...)
+		if err != nil {
+			// Stopping with an already-closed context means we go immediately to "forceful" mode
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			g.Stop(ctx)
+			return nil, err
+		}
+		g.servers = append(g.servers, s)
+	}
+	return g, nil
+}
+
+// splitListenAddr splits a URL-like listen address such as tcp4://0.0.0.0:8080 or unix:///run/app.sock into its network
+// and address. Addresses without a scheme are TCP.
+func splitListenAddr(addr string) (network, address string, err error) {
+	i := strings.Index(addr, "://")
+	if i < 0 {
+		return "tcp", addr, nil
+	}
+	network, address = addr[:i], addr[i+len("://"):]
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	case "unix":
+		if address == "" {
+			return "", "", fmt.Errorf("no socket path in listen address %q", addr)
+		}
+	default:
+		return "", "", fmt.Errorf("unsupported network %q in listen address %q", network, addr)
+	}
+	return network, address, nil
+}
+
+// ListenAll starts a HTTP server on each of the passed addresses, binding the same Service to all of them, and
+// configuring each with the passed options. Addresses are URL-like, specifying the network to listen on:
+//
+//  g, err := libhttp.ListenAll(svc, []string{"tcp4://0.0.0.0:8080", "tcp6://[::]:8080", "unix:///run/app.sock"},
+//      libhttp.WithShutdownTimeout(10*time.Second))
+//
+// The supported networks are tcp, tcp4, tcp6 and unix; addresses without a scheme are TCP. A tcp6 listener only
+// accepts IPv6 connections, so it can share a port with a tcp4 listener. Unix socket files are removed when the group
+// is stopped.
+//
+// If any address can't be bound, no servers are started and the error is returned.
+func ListenAll(svc Service, addrs []string, opts ...ServerOption) (*ServerGroup, error) {
+	listeners := make([]net.Listener, 0, len(addrs))
+	closeAll := func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}
+	for _, addr := range addrs {
+		network, address, err := splitListenAddr(addr)
+		var l net.Listener
+		if err == nil {
+			if network == "unix" {
+				l, err = listenUnix(address)
+			} else {
+				l, err = net.Listen(network, address)
+			}
+		}
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+
+	g, err := serveAll(svc, listeners, opts)
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+	for _, s := range g.servers {
+		if ul, ok := s.Listener().(*net.UnixListener); ok {
+			s.OnShutdown("unix socket", 0, removeSocketFunc(ul))
+		}
+	}
+	return g, nil
+}
@@ -0,0 +1,95 @@
+package libhttp
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeAll(t *testing.T) {
+	t.Parallel()
+
+	svc := Service(func(req Request) Response {
+		return req.Response("ok")
+	}).Filter(ErrorFilter)
+
+	l1, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	l2, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	g, err := ServeAll(svc, l1, l2)
+	require.NoError(t, err)
+	require.Len(t, g.Servers(), 2)
+	assert.Equal(t, []net.Listener{l1, l2}, g.Listeners())
+
+	for _, l := range g.Listeners() {
+		req := NewRequest(context.Background(), "GET", fmt.Sprintf("http://%s", l.Addr()), nil)
+		rsp := req.SendVia(BareClient).Response()
+		require.NoError(t, rsp.Error)
+		assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	}
+
+	g.Stop(context.Background())
+	select {
+	case <-g.Done():
+	default:
+		assert.Fail(t, "group Done() channel not closed after Stop()")
+	}
+	for _, s := range g.Servers() {
+		select {
+		case <-s.Done():
+		default:
+			assert.Fail(t, "server not stopped with its group")
+		}
+	}
+}
+
+func TestListenAll(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "libhttp-listen-all")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "app.sock")
+
+	svc := Service(func(req Request) Response {
+		return req.Response("ok")
+	}).Filter(ErrorFilter)
+	hooks := &recordingHooks{}
+	g, err := ListenAll(svc, []string{"tcp4://127.0.0.1:0", "localhost:0", "unix://" + sock}, WithServerHooks(hooks))
+	require.NoError(t, err)
+	ls := g.Listeners()
+	require.Len(t, ls, 3)
+	assert.Equal(t, "tcp", ls[0].Addr().Network())
+	assert.Equal(t, "unix", ls[2].Addr().Network())
+	assert.Equal(t, sock, ls[2].Addr().String())
+
+	for _, l := range ls {
+		l := l
+		client := HttpService(&http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return net.Dial(l.Addr().Network(), l.Addr().String())
+			}})
+		rsp := NewRequest(context.Background(), "GET", "http://localhost/", nil).SendVia(client).Response()
+		require.NoError(t, rsp.Error)
+		assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	}
+	assert.Len(t, hooks.recorded(), 6) // the options apply to every server
+
+	g.Stop(context.Background())
+	_, err = os.Stat(sock)
+	assert.True(t, os.IsNotExist(err))
+
+	for _, addr := range []string{"udp://127.0.0.1:0", "unix://", "tcp://256.0.0.1:0"} {
+		_, err := ListenAll(svc, []string{"tcp://127.0.0.1:0", addr})
+		assert.Error(t, err, addr)
+	}
+}
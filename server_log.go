@@ -0,0 +1,66 @@
+package libhttp
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/monzo/slog"
+)
+
+// ErrorLogFilter logs the requests a server handles which fail: those whose responses have errors, at error level if
+// the error's status is 5xx and warning level otherwise, with the error and its status. Entries are sampled by s,
+// which may be nil to log every failure.
+//
+// It should be outside ErrorFilter, if that is used, so the status it logs is the one which is sent.
+func ErrorLogFilter(s *LogSampler) Filter {
+	return func(req Request, svc Service) Response {
+		rsp := svc(req)
+		if rsp.Error == nil {
+			return rsp
+		}
+		status := ErrorStatusCode(rsp.Error)
+		if rsp.Response != nil && rsp.StatusCode >= 400 {
+			status = rsp.StatusCode
+		}
+		if !s.Sample(status) {
+			return rsp
+		}
+		sev := slog.WarnSeverity
+		if status >= 500 {
+			sev = slog.ErrorSeverity
+		}
+		slog.Log(slog.Eventf(sev, req, "%s %s failed with %d: %v", req.Method, req.redactedURL(), status, rsp.Error,
+			map[string]string{
+				"method": req.Method,
+				"url":    req.redactedURL(),
+				"status": strconv.Itoa(status)}))
+		return rsp
+	}
+}
+
+// SlowRequestFilter logs, at warning level, the requests a server handles which take longer than threshold for the
+// service to respond to (not including sending a streamed body). Entries are sampled by s, which may be nil to log
+// every slow request.
+func SlowRequestFilter(threshold time.Duration, s *LogSampler) Filter {
+	return func(req Request, svc Service) Response {
+		start := time.Now()
+		rsp := svc(req)
+		elapsed := time.Since(start)
+		if elapsed <= threshold {
+			return rsp
+		}
+		status := 0
+		if rsp.Response != nil {
+			status = rsp.StatusCode
+		}
+		if !s.Sample(status) {
+			return rsp
+		}
+		slog.Warn(req, "%s %s was slow: %d in %v", req.Method, req.redactedURL(), status, elapsed, map[string]string{
+			"method":   req.Method,
+			"url":      req.redactedURL(),
+			"status":   strconv.Itoa(status),
+			"duration": elapsed.String()})
+		return rsp
+	}
+}
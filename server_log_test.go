@@ -0,0 +1,52 @@
+package libhttp
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/monzo/slog"
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestServerLogFilters isn't parallel, as it replaces the default logger.
+func TestServerLogFilters(t *testing.T) {
+	logger := &captureLogger{}
+	defer slog.SetDefaultLogger(slog.DefaultLogger())
+	slog.SetDefaultLogger(logger)
+
+	svc := Service(func(req Request) Response {
+		switch req.URL.Path {
+		case "/slow-logs":
+			time.Sleep(20 * time.Millisecond)
+			return req.Response(nil)
+		case "/missing-logs":
+			return Response{Error: terrors.NotFound("thing", "No such thing", nil)}
+		}
+		return Response{Error: terrors.InternalService("", "Oops", nil)}
+	})
+	sampler := NewLogSampler(SampleStatusClass(4, 0))
+	svc = svc.Filter(ErrorFilter).Filter(ErrorLogFilter(sampler)).Filter(SlowRequestFilter(10*time.Millisecond, nil))
+
+	ctx := context.Background()
+	for _, path := range []string{"/slow-logs", "/missing-logs", "/broken-logs?token=secret"} {
+		svc(NewRequest(ctx, "GET", "http://example.com"+path, nil))
+	}
+
+	var evs []slog.Event
+	for _, ev := range logger.events() {
+		if strings.Contains(ev.Message, "-logs") {
+			evs = append(evs, ev)
+		}
+	}
+	require.Len(t, evs, 2, "4xx errors weren't sampled")
+	assert.Equal(t, slog.WarnSeverity, evs[0].Severity)
+	assert.Contains(t, evs[0].Message, "GET http://example.com/slow-logs was slow: 200 in")
+	assert.Equal(t, slog.ErrorSeverity, evs[1].Severity)
+	assert.Contains(t, evs[1].Message, "GET http://example.com/broken-logs?token=REDACTED failed with 500")
+	assert.Equal(t, "500", evs[1].Metadata["status"])
+	assert.Equal(t, int64(1), sampler.Dropped())
+}
@@ -0,0 +1,202 @@
+package libhttp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerShutdownHooks(t *testing.T) {
+	t.Parallel()
+
+	s, err := Listen(Service(func(req Request) Response {
+		return req.Response(nil)
+	}), "localhost:0")
+	require.NoError(t, err)
+
+	order := make(chan string, 3)
+	s.OnShutdown("late", 10, func(ctx context.Context) error {
+		order <- "late"
+		return fmt.Errorf("late failed")
+	})
+	s.OnShutdown("early", -10, func(ctx context.Context) error {
+		order <- "early"
+		return nil
+	})
+	s.OnShutdown("middle", 0, func(ctx context.Context) error {
+		order <- "middle"
+		return nil
+	})
+	s.Stop(context.Background())
+	close(order)
+
+	var ran []string
+	for o := range order {
+		ran = append(ran, o)
+	}
+	assert.Equal(t, []string{"early", "middle", "late"}, ran)
+	errs := s.ShutdownErrors()
+	require.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Error(), "late failed")
+}
+
+func TestServerWaitIdle(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	s, err := Listen(Service(func(req Request) Response {
+		<-release
+		return req.Response(nil)
+	}), "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+	require.NoError(t, s.WaitIdle(context.Background()))
+
+	rspF := NewRequest(context.Background(), "GET", fmt.Sprintf("http://%s", s.Listener().Addr()), nil).
+		SendVia(BareClient)
+	for s.ActiveRequests() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.EqualValues(t, 1, s.ActiveRequests())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, s.WaitIdle(ctx))
+
+	close(release)
+	require.NoError(t, rspF.Response().Error)
+	require.NoError(t, s.WaitIdle(context.Background()))
+	assert.EqualValues(t, 0, s.ActiveRequests())
+}
+
+func TestServerOnPanic(t *testing.T) {
+	t.Parallel()
+
+	s, err := Listen(Service(func(req Request) Response {
+		panic("boom")
+	}), "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+	recovered := make(chan interface{}, 1)
+	s.OnPanic(func(ctx context.Context, v interface{}, stack []byte) {
+		assert.NotEmpty(t, stack)
+		recovered <- v
+	})
+
+	rsp := NewRequest(context.Background(), "GET", fmt.Sprintf("http://%s", s.Listener().Addr()), nil).
+		SendVia(HttpService(&http.Transport{}).Filter(ErrorFilter)).Response()
+	require.Error(t, rsp.Error)
+	assert.Equal(t, http.StatusInternalServerError, rsp.StatusCode)
+	// The panic value is only given to hooks, not to clients
+	assert.NotContains(t, rsp.Error.Error(), "boom")
+	select {
+	case v := <-recovered:
+		assert.Equal(t, "boom", v)
+	case <-time.After(time.Second):
+		assert.Fail(t, "panic hook not called")
+	}
+
+	// Panics in shutdown hooks are reported too
+	s.OnShutdown("panicky", 0, func(ctx context.Context) error {
+		panic("shutdown boom")
+	})
+	s.Stop(context.Background())
+	assert.Equal(t, "shutdown boom", <-recovered)
+	require.Len(t, s.ShutdownErrors(), 1)
+}
+
+func TestServerAbortOnPanic(t *testing.T) {
+	t.Parallel()
+
+	s, err := Listen(Service(func(req Request) Response {
+		panic("boom")
+	}), "localhost:0", WithAbortOnPanic())
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+	recovered := make(chan interface{}, 1)
+	s.OnPanic(func(ctx context.Context, v interface{}, stack []byte) {
+		recovered <- v
+	})
+
+	rsp := NewRequest(context.Background(), "GET", fmt.Sprintf("http://%s", s.Listener().Addr()), nil).
+		SendVia(HttpService(&http.Transport{})).Response()
+	require.Error(t, rsp.Error)
+	assert.Nil(t, rsp.Response)
+	assert.Equal(t, "boom", <-recovered)
+}
+
+func TestListenUnixCleanup(t *testing.T) {
+	t.Parallel()
+
+	s, err, cleanup := ListenUnix(Service(func(req Request) Response {
+		return req.Response("ok")
+	}), "")
+	require.NoError(t, err)
+	path := s.Listener().Addr().String()
+	assert.Contains(t, path, ".sock")
+	_, err = os.Stat(path)
+	require.NoError(t, err)
+
+	client := HttpService(&http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return net.Dial("unix", path)
+		}})
+	rsp := NewRequest(context.Background(), "GET", "http://localhost/", nil).SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+
+	s.Stop(context.Background())
+	assert.Empty(t, s.ShutdownErrors())
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+	cleanup() // still safe to call
+}
+
+// failingListener is a net.Listener whose Accept fails permanently
+type failingListener struct {
+	net.Listener
+}
+
+func (l failingListener) Accept() (net.Conn, error) {
+	return nil, fmt.Errorf("accept failed")
+}
+
+func TestServerErrChan(t *testing.T) {
+	t.Parallel()
+
+	svc := Service(func(req Request) Response {
+		return req.Response(nil)
+	})
+
+	// A server which fails reports why
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	s, err := Serve(svc, failingListener{l})
+	require.NoError(t, err)
+	select {
+	case err := <-s.ErrChan():
+		assert.EqualError(t, err, "accept failed")
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "serve error not reported")
+	}
+	<-s.Done()
+
+	// A server which is stopped normally reports nothing
+	s, err = Listen(svc, "localhost:0")
+	require.NoError(t, err)
+	s.Stop(context.Background())
+	select {
+	case err, ok := <-s.ErrChan():
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "ErrChan not closed after Stop")
+	}
+}
@@ -0,0 +1,319 @@
+package libhttp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/monzo/terrors"
+)
+
+const (
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	amzDateFormat   = "20060102T150405Z"
+)
+
+// canonicalRequest returns the canonical form of the request, as defined for AWS Signature Version 4, which the
+// signing filters sign, along with the (lower case, sorted) names of the headers it includes:
+//
+//  METHOD
+//  /canonical/path
+//  canonical=query&string=
+//  header:value
+//  (one line per signed header)
+//
+//  signed;headers
+//  payload hash
+//
+// Paths are URI-encoded twice if doubleEncode is set (as all AWS services except S3 require).
+func canonicalRequest(req Request, signHeader func(name string) bool, payloadHash string,
+	doubleEncode bool) (string, string) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{
+		"host": host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if !signHeader(name) {
+			continue
+		}
+		trimmed := make([]string, len(values))
+		for i, v := range values {
+			trimmed[i] = strings.Join(strings.Fields(v), " ")
+		}
+		headers[name] = strings.Join(trimmed, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(req.Method + "\n")
+	b.WriteString(canonicalPath(req.URL, doubleEncode) + "\n")
+	b.WriteString(canonicalQuery(req.URL.RawQuery) + "\n")
+	for _, name := range names {
+		b.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signed := strings.Join(names, ";")
+	b.WriteString("\n" + signed + "\n" + payloadHash)
+	return b.String(), signed
+}
+
+func canonicalPath(u *url.URL, doubleEncode bool) string {
+	path := u.EscapedPath()
+	if path == "" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if unescaped, err := url.PathUnescape(s); err == nil {
+			s = unescaped
+		}
+		s = uriEncode(s)
+		if doubleEncode {
+			s = uriEncode(s)
+		}
+		segments[i] = s
+	}
+	return strings.Join(segments, "/")
+}
+
+func canonicalQuery(rawQuery string) string {
+	var pairs []string
+	for _, kv := range strings.Split(rawQuery, "&") {
+		if kv == "" {
+			continue
+		}
+		parts := strings.SplitN(kv, "=", 2)
+		k, _ := url.QueryUnescape(parts[0])
+		v := ""
+		if len(parts) == 2 {
+			v, _ = url.QueryUnescape(parts[1])
+		}
+		pairs = append(pairs, uriEncode(k)+"="+uriEncode(v))
+	}
+	sort.Strings(pairs) // "=" sorts before all encoded characters, so this orders by key, then value
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode percent-encodes everything but the unreserved characters of RFC 3986.
+func uriEncode(s string) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&0xf])
+		}
+	}
+	return b.String()
+}
+
+// payloadHash returns the hex-encoded SHA-256 hash of the request's body, which is buffered so it can still be sent.
+func payloadHash(req *Request) (string, error) {
+	var b []byte
+	if req.Body != nil {
+		var err error
+		if b, err = req.BodyBytes(false); err != nil {
+			return "", terrors.Wrap(err, nil)
+		}
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// An HMACOption configures an HMACSigningFilter.
+type HMACOption func(*hmacOptions)
+
+type hmacOptions struct {
+	headers map[string]bool
+	now     func() time.Time
+}
+
+// HMACSignedHeaders adds headers to those which are signed (by default, Host, Content-Type, Content-Md5 and
+// X-Signature-Date). Headers which requests don't have are skipped.
+func HMACSignedHeaders(names ...string) HMACOption {
+	return func(o *hmacOptions) {
+		for _, name := range names {
+			o.headers[strings.ToLower(name)] = true
+		}
+	}
+}
+
+// HMACSigningFilter returns a client Filter which signs requests with HMAC-SHA256, using the passed secret. The
+// signature covers the method, path, query, signed headers and the SHA-256 hash of the body, canonicalised as for AWS
+// Signature Version 4 (so servers can verify it using any implementation of that canonicalisation), along with the
+// time of signing. Requests get the headers:
+//
+//  X-Signature-Date: 20240102T150405Z
+//  X-Content-Sha256: <hex-encoded SHA-256 hash of the body>
+//  Authorization: HMAC-SHA256 KeyId=<keyID>, SignedHeaders=content-type;host;x-signature-date, Signature=<hex>
+//
+// The signature is the HMAC of the string:
+//
+//  HMAC-SHA256
+//  <X-Signature-Date>
+//  <hex-encoded SHA-256 hash of the canonical request>
+//
+// The body is buffered in memory to be hashed.
+func HMACSigningFilter(keyID string, secret []byte, opts ...HMACOption) Filter {
+	o := hmacOptions{
+		headers: map[string]bool{
+			"content-type":     true,
+			"content-md5":      true,
+			"x-signature-date": true},
+		now: time.Now}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return func(req Request, svc Service) Response {
+		hash, err := payloadHash(&req)
+		if err != nil {
+			return Response{
+				Error: err}
+		}
+		date := o.now().UTC().Format(amzDateFormat)
+		req.Header.Set("X-Signature-Date", date)
+		req.Header.Set("X-Content-Sha256", hash)
+		canonical, signed := canonicalRequest(req, func(name string) bool {
+			return o.headers[name]
+		}, hash, false)
+		stringToSign := "HMAC-SHA256\n" + date + "\n" + sha256Hex(canonical)
+		req.Header.Set("Authorization", fmt.Sprintf("HMAC-SHA256 KeyId=%s, SignedHeaders=%s, Signature=%s",
+			keyID, signed, hex.EncodeToString(hmacSHA256(secret, stringToSign))))
+		return svc(req)
+	}
+}
+
+// AWSCredentials are the credentials a SigV4SigningFilter signs requests with. SessionToken is only needed for
+// temporary credentials.
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// A SigV4Option configures a SigV4SigningFilter.
+type SigV4Option func(*sigV4Options)
+
+type sigV4Options struct {
+	headers         map[string]bool
+	unsignedPayload bool
+	credentials     func() (AWSCredentials, error)
+	now             func() time.Time
+}
+
+// SigV4SignedHeaders adds headers to those which are signed (by default, Host, Content-Type, Content-Md5 and all
+// X-Amz-* headers). Headers which requests don't have are skipped.
+func SigV4SignedHeaders(names ...string) SigV4Option {
+	return func(o *sigV4Options) {
+		for _, name := range names {
+			o.headers[strings.ToLower(name)] = true
+		}
+	}
+}
+
+// SigV4UnsignedPayload leaves request bodies out of the signature, so they needn't be buffered to be hashed. This is
+// only accepted by some services (such as S3, over HTTPS).
+func SigV4UnsignedPayload() SigV4Option {
+	return func(o *sigV4Options) {
+		o.unsignedPayload = true
+	}
+}
+
+// SigV4CredentialsProvider sets a function which is called for the credentials to sign each request with, instead of
+// the fixed credentials passed to SigV4SigningFilter, so that temporary credentials can be refreshed. The function is
+// called concurrently, and should cache its credentials.
+func SigV4CredentialsProvider(f func() (AWSCredentials, error)) SigV4Option {
+	return func(o *sigV4Options) {
+		o.credentials = f
+	}
+}
+
+// SigV4SigningFilter returns a client Filter which signs requests with AWS Signature Version 4 for the passed region
+// and service (like "eu-west-1" and "execute-api"), so that AWS APIs, and others using the same scheme, can be called
+// directly:
+//
+//  client := libhttp.NewClient(libhttp.WithClientFilters(libhttp.SigV4SigningFilter(libhttp.AWSCredentials{
+//      AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+//      SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY")}, "eu-west-1", "execute-api")))
+//
+// Requests get X-Amz-Date and Authorization headers (and X-Amz-Security-Token, with temporary credentials). For S3,
+// X-Amz-Content-Sha256 is set as it requires. Unless SigV4UnsignedPayload is used, the body is buffered in memory to
+// be hashed.
+//
+// The filter should be applied after any filters which modify requests, or the signature won't match them.
+func SigV4SigningFilter(creds AWSCredentials, region, service string, opts ...SigV4Option) Filter {
+	o := sigV4Options{
+		headers: map[string]bool{
+			"content-type": true,
+			"content-md5":  true},
+		credentials: func() (AWSCredentials, error) {
+			return creds, nil
+		},
+		now: time.Now}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return func(req Request, svc Service) Response {
+		creds, err := o.credentials()
+		if err != nil {
+			return Response{
+				Error: terrors.Wrap(err, nil)}
+		}
+		hash := unsignedPayload
+		if !o.unsignedPayload {
+			if hash, err = payloadHash(&req); err != nil {
+				return Response{
+					Error: err}
+			}
+		}
+		now := o.now().UTC()
+		date := now.Format(amzDateFormat)
+		req.Header.Set("X-Amz-Date", date)
+		if creds.SessionToken != "" {
+			req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+		}
+		if service == "s3" || o.unsignedPayload {
+			req.Header.Set("X-Amz-Content-Sha256", hash)
+		}
+		canonical, signed := canonicalRequest(req, func(name string) bool {
+			return o.headers[name] || strings.HasPrefix(name, "x-amz-")
+		}, hash, service != "s3")
+
+		scope := now.Format("20060102") + "/" + region + "/" + service + "/aws4_request"
+		stringToSign := "AWS4-HMAC-SHA256\n" + date + "\n" + scope + "\n" + sha256Hex(canonical)
+		key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), now.Format("20060102"))
+		key = hmacSHA256(key, region)
+		key = hmacSHA256(key, service)
+		key = hmacSHA256(key, "aws4_request")
+		req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+			creds.AccessKeyID, scope, signed, hex.EncodeToString(hmacSHA256(key, stringToSign))))
+		return svc(req)
+	}
+}
@@ -0,0 +1,110 @@
+package libhttp
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// signedRequest returns the request as it is sent after passing through the filter.
+func signedRequest(t *testing.T, f Filter, req Request) Request {
+	var sent Request
+	rsp := Service(func(req Request) Response {
+		sent = req
+		return req.Response(nil)
+	}).Filter(f)(req)
+	require.NoError(t, rsp.Error)
+	return sent
+}
+
+func TestSigV4SigningFilter(t *testing.T) {
+	t.Parallel()
+	// The example from the AWS Signature Version 4 documentation
+	at := func(o *sigV4Options) {
+		o.now = func() time.Time {
+			return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+		}
+	}
+	creds := AWSCredentials{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	req := NewRequest(context.Background(), "GET", "https://iam.amazonaws.com/?Version=2010-05-08&Action=ListUsers", nil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+	canonical, signed := canonicalRequest(req, func(name string) bool {
+		return name == "content-type"
+	}, sha256Hex(""), true)
+	assert.Equal(t, "content-type;host", signed)
+	assert.True(t, strings.HasPrefix(canonical, "GET\n/\nAction=ListUsers&Version=2010-05-08\n"), canonical)
+
+	sent := signedRequest(t, SigV4SigningFilter(creds, "us-east-1", "iam", at), req)
+	assert.Equal(t, "20150830T123600Z", sent.Header.Get("X-Amz-Date"))
+	assert.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, "+
+		"SignedHeaders=content-type;host;x-amz-date, "+
+		"Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+		sent.Header.Get("Authorization"))
+
+	// Temporary credentials, and S3's payload header; the body is still sent
+	creds.SessionToken = "token"
+	req = NewRequest(context.Background(), "PUT", "https://bucket.s3.amazonaws.com/a b/c", "body")
+	sent = signedRequest(t, SigV4SigningFilter(creds, "us-east-1", "s3", at), req)
+	assert.Equal(t, "token", sent.Header.Get("X-Amz-Security-Token"))
+	assert.Equal(t, sha256Hex(`"body"`+"\n"), sent.Header.Get("X-Amz-Content-Sha256"))
+	assert.Contains(t, sent.Header.Get("Authorization"),
+		"SignedHeaders=content-type;host;x-amz-content-sha256;x-amz-date;x-amz-security-token,")
+	b, err := sent.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, `"body"`+"\n", string(b))
+
+	sent = signedRequest(t, SigV4SigningFilter(creds, "us-east-1", "s3", at, SigV4UnsignedPayload()), req)
+	assert.Equal(t, "UNSIGNED-PAYLOAD", sent.Header.Get("X-Amz-Content-Sha256"))
+}
+
+func TestCanonicalPath(t *testing.T) {
+	t.Parallel()
+	req := NewRequest(context.Background(), "GET", "https://example.com/documents%20and%20settings/a%2Fb", nil)
+	assert.Equal(t, "/documents%20and%20settings/a%2Fb", canonicalPath(req.URL, false))
+	assert.Equal(t, "/documents%2520and%2520settings/a%252Fb", canonicalPath(req.URL, true))
+	assert.Equal(t, "a=&b=1&b=2&c%20d=e", canonicalQuery("c+d=e&b=2&a&b=1"))
+}
+
+func TestHMACSigningFilter(t *testing.T) {
+	t.Parallel()
+	secret := []byte("secret")
+	f := HMACSigningFilter("key-1", secret, HMACSignedHeaders("X-Request-Id"), func(o *hmacOptions) {
+		o.now = func() time.Time {
+			return time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+		}
+	})
+	req := NewRequest(context.Background(), "POST", "https://api.example.com/v1/payments?b=2&a=1",
+		map[string]int{"amount": 100})
+	req.Header.Set("X-Request-Id", "abc")
+	req.Header.Set("X-Unsigned", "xyz")
+	sent := signedRequest(t, f, req)
+
+	body, err := sent.BodyBytes(false)
+	require.NoError(t, err)
+	assert.Equal(t, sha256Hex(string(body)), sent.Header.Get("X-Content-Sha256"))
+	assert.Equal(t, "20240102T150405Z", sent.Header.Get("X-Signature-Date"))
+
+	// Verify the signature as a server would
+	canonical := strings.Join([]string{
+		"POST",
+		"/v1/payments",
+		"a=1&b=2",
+		"content-type:application/json",
+		"host:api.example.com",
+		"x-request-id:abc",
+		"x-signature-date:20240102T150405Z",
+		"",
+		"content-type;host;x-request-id;x-signature-date",
+		sha256Hex(string(body))}, "\n")
+	mac := hmacSHA256(secret, "HMAC-SHA256\n20240102T150405Z\n"+sha256Hex(canonical))
+	assert.Equal(t, "HMAC-SHA256 KeyId=key-1, SignedHeaders=content-type;host;x-request-id;x-signature-date, "+
+		"Signature="+hex.EncodeToString(mac), sent.Header.Get("Authorization"))
+}
@@ -0,0 +1,185 @@
+package libhttp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultSSEHeartbeat = 15 * time.Second
+
+// ErrSSEClosed is returned when sending on an SSEWriter whose stream has ended.
+var ErrSSEClosed = errors.New("libhttp: event stream closed")
+
+// An SSEOption configures a Server-Sent Events stream.
+type SSEOption func(*sseOptions)
+
+type sseOptions struct {
+	heartbeat time.Duration
+	retry     time.Duration
+}
+
+// SSEHeartbeat sets how often a comment is sent on an otherwise idle stream, to stop proxies timing it out and to
+// detect clients which have gone away. The default is 15 seconds; zero or less disables heartbeats.
+func SSEHeartbeat(d time.Duration) SSEOption {
+	return func(o *sseOptions) {
+		o.heartbeat = d
+	}
+}
+
+// SSERetry tells clients how long to wait before reconnecting if the stream is interrupted.
+func SSERetry(d time.Duration) SSEOption {
+	return func(o *sseOptions) {
+		o.retry = d
+	}
+}
+
+// An SSEWriter sends Server-Sent Events (the text/event-stream format read by EventSource) to a client. It is safe for
+// concurrent use.
+type SSEWriter struct {
+	body        *streamer
+	w           *bufio.Writer
+	m           sync.Mutex
+	done        chan struct{}
+	closeOnce   sync.Once
+	lastEventID string
+}
+
+// SSE starts a Server-Sent Events stream in response to the request. The returned Response should be returned from the
+// Service, and events sent to the client from another goroutine:
+//
+//	func events(req libhttp.Request) libhttp.Response {
+//	    sse, rsp := req.SSE()
+//	    go func() {
+//	        defer sse.Close()
+//	        for msg := range subscribe(req, sse.LastEventID()) {
+//	            if err := sse.Send("message", msg.ID, msg.Body); err != nil {
+//	                return
+//	            }
+//	        }
+//	    }()
+//	    return rsp
+//	}
+//
+// The stream ends (and Done is closed) when the writer is closed, the client disconnects, or the server begins to
+// shut down.
+func (r Request) SSE(opts ...SSEOption) (*SSEWriter, Response) {
+	o := sseOptions{
+		heartbeat: defaultSSEHeartbeat}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	body := Streamer().(*streamer)
+	s := &SSEWriter{
+		body:        body,
+		w:           bufio.NewWriter(body),
+		done:        make(chan struct{}),
+		lastEventID: r.Header.Get("Last-Event-ID")}
+
+	rsp := r.Response(nil)
+	rsp.Body = body
+	rsp.ContentLength = -1
+	rsp.Header.Set("Content-Type", "text/event-stream")
+	rsp.Header.Set("Cache-Control", "no-cache")
+	rsp.Header.Set("X-Accel-Buffering", "no") // stop nginx buffering the stream
+
+	if o.retry > 0 {
+		// Nothing can be written to the client until the Response has been returned, so this is buffered until then
+		fmt.Fprintf(s.w, "retry: %d\n\n", o.retry/time.Millisecond)
+	}
+
+	var reqDone, serverDone <-chan struct{}
+	if r.Context != nil {
+		reqDone = r.Done()
+	}
+	if r.server != nil {
+		serverDone = r.server.Done()
+	}
+	go func() {
+		s.write("")
+		var heartbeat <-chan time.Time
+		if o.heartbeat > 0 {
+			t := time.NewTicker(o.heartbeat)
+			defer t.Stop()
+			heartbeat = t.C
+		}
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-reqDone:
+				s.Close()
+			case <-serverDone:
+				s.Close()
+			case <-heartbeat:
+				s.write(":\n\n")
+			}
+		}
+	}()
+	return s, rsp
+}
+
+// LastEventID returns the ID of the last event the client received before reconnecting, from its Last-Event-ID
+// header; the stream should resume after it. It is empty if this is not a reconnection.
+func (s *SSEWriter) LastEventID() string {
+	return s.lastEventID
+}
+
+// Send sends an event to the client. event and id may be empty: an event with no type is a "message" event, and one
+// with no ID leaves the client's last event ID unchanged. Multi-line data is sent as multiple data fields, which the
+// client joins back together.
+func (s *SSEWriter) Send(event, id, data string) error {
+	if strings.ContainsAny(event, "\r\n") || strings.ContainsAny(id, "\r\n\x00") {
+		return fmt.Errorf("libhttp: invalid event type %q or ID %q", event, id)
+	}
+	b := &strings.Builder{}
+	if event != "" {
+		fmt.Fprintf(b, "event: %s\n", event)
+	}
+	if id != "" {
+		fmt.Fprintf(b, "id: %s\n", id)
+	}
+	data = strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(data)
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+// write sends raw text to the client, ending the stream if that fails.
+func (s *SSEWriter) write(text string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	select {
+	case <-s.done:
+		return ErrSSEClosed
+	default:
+	}
+	_, err := s.w.WriteString(text)
+	if err == nil {
+		err = s.w.Flush()
+	}
+	if err != nil {
+		s.Close()
+		return ErrSSEClosed
+	}
+	return nil
+}
+
+// Done returns a channel which is closed when the stream ends.
+func (s *SSEWriter) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close ends the stream. Any Send which is blocked writing to the client fails.
+func (s *SSEWriter) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.body.Close()
+	})
+	return nil
+}
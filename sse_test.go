@@ -0,0 +1,104 @@
+package libhttp
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSSE(t *testing.T) {
+	t.Parallel()
+
+	closed := make(chan struct{})
+	svc := Service(func(req Request) Response {
+		sse, rsp := req.SSE(SSEHeartbeat(10*time.Millisecond), SSERetry(time.Second))
+		go func() {
+			defer close(closed)
+			sse.Send("greeting", "1", "hello\nworld")
+			sse.Send("", "", "resumed after "+sse.LastEventID())
+			<-sse.Done()
+			assert.Equal(t, ErrSSEClosed, sse.Send("late", "", ""))
+		}()
+		return rsp
+	})
+	s, err := Listen(svc, "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := NewRequest(ctx, "GET", "http://"+s.Listener().Addr().String(), nil)
+	req.Header.Set("Last-Event-ID", "41")
+	rsp := req.SendVia(HttpService(&http.Transport{})).Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, "text/event-stream", rsp.Header.Get("Content-Type"))
+	assert.Equal(t, "no-cache", rsp.Header.Get("Cache-Control"))
+
+	r := bufio.NewReader(rsp.Body)
+	readEvent := func() string {
+		var lines []string
+		for {
+			line, err := r.ReadString('\n')
+			require.NoError(t, err)
+			if line == "\n" {
+				return strings.Join(lines, "")
+			}
+			lines = append(lines, line)
+		}
+	}
+	assert.Equal(t, "retry: 1000\n", readEvent())
+	assert.Equal(t, "event: greeting\nid: 1\ndata: hello\ndata: world\n", readEvent())
+	assert.Equal(t, "data: resumed after 41\n", readEvent())
+	assert.Equal(t, ":\n", readEvent()) // heartbeat
+
+	// The stream ends when the client goes away
+	cancel()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream wasn't closed when the client disconnected")
+	}
+}
+
+func TestSSEServerStop(t *testing.T) {
+	t.Parallel()
+
+	svc := Service(func(req Request) Response {
+		sse, rsp := req.SSE()
+		go sse.Send("", "", "hi")
+		return rsp
+	})
+	s, err := Listen(svc, "localhost:0")
+	require.NoError(t, err)
+
+	req := NewRequest(context.Background(), "GET", "http://"+s.Listener().Addr().String(), nil)
+	rsp := req.SendVia(HttpService(&http.Transport{})).Response()
+	require.NoError(t, rsp.Error)
+	r := bufio.NewReader(rsp.Body)
+	line, err := r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "data: hi\n", line)
+
+	// Stopping the server ends the stream, letting it drain
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go s.Stop(ctx)
+	r.ReadString('\n')
+	_, err = r.ReadString('\n')
+	assert.Error(t, err)
+	assert.NoError(t, s.WaitIdle(ctx))
+}
+
+func TestSSEInvalidFields(t *testing.T) {
+	t.Parallel()
+
+	sse, _ := NewRequest(context.Background(), "GET", "/", nil).SSE()
+	defer sse.Close()
+	assert.Error(t, sse.Send("a\nb", "", "data"))
+	assert.Error(t, sse.Send("", "1\r", "data"))
+}
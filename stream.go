@@ -0,0 +1,105 @@
+package libhttp
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/monzo/slog"
+)
+
+// A StreamWriter writes the body of a streaming response. Writes are buffered until Flush is called (or the buffer
+// fills), when they are sent to the client as a chunk.
+type StreamWriter interface {
+	io.Writer
+	// Flush sends everything written so far to the client.
+	Flush() error
+	// Context is the request's context, which is cancelled if the client goes away.
+	Context() context.Context
+}
+
+type streamWriter struct {
+	ctx  context.Context
+	w    *bufio.Writer
+	body *streamer
+}
+
+func (s *streamWriter) Write(b []byte) (int, error) {
+	if err := s.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return s.w.Write(b)
+}
+
+func (s *streamWriter) Flush() error {
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
+
+func (s *streamWriter) Context() context.Context {
+	return s.ctx
+}
+
+// Stream returns a Response whose body is produced incrementally by f, which is called in its own goroutine once the
+// Response has been returned. The body is sent with chunked transfer encoding (or HTTP/2 data frames) as it's flushed:
+//
+//	func tail(req libhttp.Request) libhttp.Response {
+//	    rsp := req.Stream(func(w libhttp.StreamWriter) error {
+//	        for line := range follow(w.Context()) {
+//	            fmt.Fprintln(w, line)
+//	            if err := w.Flush(); err != nil {
+//	                return err // the client went away
+//	            }
+//	        }
+//	        return nil
+//	    })
+//	    rsp.Header.Set("Content-Type", "text/plain")
+//	    return rsp
+//	}
+//
+// The status code and headers are sent before the body, so can't be changed by f. If f returns an error (or panics),
+// the response can only be aborted: the connection is reset, so the client sees a failure rather than a truncated
+// body.
+func (r Request) Stream(f func(w StreamWriter) error) Response {
+	body := Streamer().(*streamer)
+	rsp := r.Response(nil)
+	rsp.Body = body
+	rsp.ContentLength = -1
+
+	var ctx context.Context = r
+	if r.Context == nil {
+		ctx = context.Background()
+	}
+	w := &streamWriter{
+		ctx:  ctx,
+		w:    bufio.NewWriter(body),
+		body: body}
+	go func() {
+		err := func() (err error) {
+			defer func() {
+				if v := recover(); v != nil {
+					if r.server != nil {
+						r.server.panicked(ctx, v, &r.Request, 0)
+					}
+					err = fmt.Errorf("panic: %v", v)
+				}
+			}()
+			if err := f(w); err != nil {
+				return err
+			}
+			return w.w.Flush()
+		}()
+		if err != nil {
+			if ctx.Err() == nil && err != io.ErrClosedPipe {
+				slog.Warn(ctx, "Streaming response failed: %v", err)
+			}
+			body.pipeW.CloseWithError(err)
+			return
+		}
+		body.Close()
+	}()
+	return rsp
+}
@@ -0,0 +1,101 @@
+package libhttp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStream(t *testing.T) {
+	t.Parallel()
+
+	next := make(chan struct{})
+	cancelled := make(chan error, 1)
+	svc := Service(func(req Request) Response {
+		rsp := req.Stream(func(w StreamWriter) error {
+			for i := 0; ; i++ {
+				fmt.Fprintf(w, "line %d\n", i)
+				if err := w.Flush(); err != nil {
+					cancelled <- err
+					return err
+				}
+				select {
+				case <-next:
+				case <-w.Context().Done():
+				}
+			}
+		})
+		rsp.Header.Set("Content-Type", "text/plain")
+		return rsp
+	})
+	s, err := Listen(svc, "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := NewRequest(ctx, "GET", "http://"+s.Listener().Addr().String(), nil)
+	rsp := req.SendVia(HttpService(&http.Transport{})).Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, "text/plain", rsp.Header.Get("Content-Type"))
+
+	// Each flushed line arrives before the next is produced
+	r := bufio.NewReader(rsp.Body)
+	for i := 0; i < 3; i++ {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("line %d\n", i), line)
+		next <- struct{}{}
+	}
+
+	cancel()
+	select {
+	case err := <-cancelled:
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream wasn't cancelled when the client disconnected")
+	}
+}
+
+func TestStreamError(t *testing.T) {
+	t.Parallel()
+
+	svc := Service(func(req Request) Response {
+		if req.URL.Path == "/panic" {
+			return req.Stream(func(w StreamWriter) error {
+				w.Write([]byte("partial"))
+				w.Flush()
+				panic("boom")
+			})
+		}
+		return req.Stream(func(w StreamWriter) error {
+			w.Write([]byte("partial"))
+			w.Flush()
+			return errors.New("failed")
+		})
+	})
+	s, err := Listen(svc, "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+	panics := make(chan interface{}, 1)
+	s.OnPanic(func(ctx context.Context, v interface{}, stack []byte) {
+		panics <- v
+	})
+
+	for _, path := range []string{"/error", "/panic"} {
+		req := NewRequest(context.Background(), "GET", "http://"+s.Listener().Addr().String()+path, nil)
+		rsp := req.SendVia(HttpService(&http.Transport{})).Response()
+		require.NoError(t, rsp.Error)
+		b, err := ioutil.ReadAll(rsp.Body)
+		assert.Error(t, err, path) // the response is aborted rather than appearing complete
+		assert.Equal(t, "partial", string(b))
+	}
+	assert.Equal(t, "boom", <-panics)
+}
@@ -0,0 +1,59 @@
+package libhttp
+
+import "crypto/tls"
+
+// The TLS profiles below follow Mozilla's server-side TLS recommendations
+// (https://wiki.mozilla.org/Security/Server_Side_TLS). Each call returns a fresh tls.Config, so callers are free to
+// modify the result (for example to add certificates) before passing it to ServeTLS or ListenTLS.
+
+// TLSProfileModern returns a configuration for services whose clients all support TLS 1.3. Cipher suites aren't
+// configurable under TLS 1.3; Go only offers AEAD suites with forward secrecy.
+func TLSProfileModern() *tls.Config {
+	return &tls.Config{
+		MinVersion:       tls.VersionTLS13,
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384}}
+}
+
+// TLSProfileIntermediate returns a general-purpose configuration, accepting TLS 1.2 (with forward-secret AEAD cipher
+// suites only) and TLS 1.3. This is the default used by ServeTLS.
+func TLSProfileIntermediate() *tls.Config {
+	return &tls.Config{
+		MinVersion:       tls.VersionTLS12,
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305}}
+}
+
+// TLSProfileOld returns a configuration for services which must remain reachable by very old clients (down to TLS
+// 1.0). It includes CBC-mode and non-forward-secret RSA key exchange suites, and should only be used when that
+// compatibility is really needed.
+func TLSProfileOld() *tls.Config {
+	return &tls.Config{
+		MinVersion:               tls.VersionTLS10,
+		CurvePreferences:         []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+		PreferServerCipherSuites: true,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+			tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_RSA_WITH_AES_128_CBC_SHA256,
+			tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+			tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+			tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA}}
+}
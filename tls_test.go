@@ -0,0 +1,32 @@
+package libhttp
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTLSProfiles(t *testing.T) {
+	t.Parallel()
+
+	assert.EqualValues(t, tls.VersionTLS13, TLSProfileModern().MinVersion)
+
+	// The intermediate profile must only offer forward-secret AEAD suites
+	intermediate := TLSProfileIntermediate()
+	assert.EqualValues(t, tls.VersionTLS12, intermediate.MinVersion)
+	insecure := map[uint16]bool{}
+	for _, cs := range tls.InsecureCipherSuites() {
+		insecure[cs.ID] = true
+	}
+	for _, id := range intermediate.CipherSuites {
+		assert.False(t, insecure[id], "insecure suite %s", tls.CipherSuiteName(id))
+		assert.NotContains(t, tls.CipherSuiteName(id), "CBC")
+		assert.NotContains(t, tls.CipherSuiteName(id), "TLS_RSA_")
+	}
+
+	// Each call must return an independent config
+	a, b := TLSProfileOld(), TLSProfileOld()
+	a.CipherSuites[0] = 0
+	assert.NotEqual(t, a.CipherSuites[0], b.CipherSuites[0])
+}
@@ -0,0 +1,186 @@
+package libhttp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	uuid "github.com/nu7hatch/gouuid"
+)
+
+// traceKey carries the TraceContext of the request being served, set by TraceFilter.
+var traceKey = NewContextKey("trace", TraceContext{})
+
+// A TraceContext identifies the distributed trace a request is part of, and the span (the unit of work) within it,
+// along with the request's ID. It is propagated in W3C Trace Context (traceparent and tracestate) and Zipkin B3
+// headers, and the request ID in X-Request-ID, by default (see TracePropagator).
+type TraceContext struct {
+	TraceID      string // 32 lowercase hex digits
+	SpanID       string // 16 lowercase hex digits
+	ParentSpanID string // the span which caused this one, if known
+	Sampled      bool   // whether the trace is being recorded
+	TraceState   string // vendor-specific state from the tracestate header, passed on untouched
+	RequestID    string
+}
+
+// TraceFilter is a server Filter which makes the trace of each request available to the service (see
+// TraceFromContext), continuing the caller's trace from the request's headers if it sent one, or starting a new
+// (sampled) trace if not. The request is given a new span, a child of the caller's. Its request ID is taken from the
+// X-Request-ID header, or generated, and set on the response so callers can quote it.
+//
+// Traces are extracted using DefaultTracePropagators; TraceFilterWith uses other formats. Clients created with
+// WithTracePropagation pass the trace on to downstream services when they are sent requests using the served request
+// as their context.
+func TraceFilter(req Request, svc Service) Response {
+	return traceFilter(DefaultTracePropagators, req, svc)
+}
+
+// TraceFilterWith returns a TraceFilter which extracts traces using the propagators, in order of preference, in place
+// of DefaultTracePropagators.
+func TraceFilterWith(propagators ...TracePropagator) Filter {
+	chain := TracePropagators(propagators)
+	return func(req Request, svc Service) Response {
+		return traceFilter(chain, req, svc)
+	}
+}
+
+func traceFilter(propagators TracePropagators, req Request, svc Service) Response {
+	tc, ok := propagators.Extract(req.Header)
+	if ok {
+		tc = tc.child()
+	} else {
+		tc = TraceContext{
+			TraceID:   randomHex(16),
+			SpanID:    randomHex(8),
+			Sampled:   true,
+			RequestID: tc.RequestID}
+	}
+	if tc.RequestID == "" {
+		if id, err := uuid.NewV4(); err == nil {
+			tc.RequestID = id.String()
+		}
+	}
+	rsp := svc(SetValue(req, traceKey, tc))
+	if tc.RequestID != "" {
+		if rsp.Response == nil {
+			rsp.Response = newHTTPResponse(req)
+		}
+		if rsp.Header.Get("X-Request-ID") == "" {
+			rsp.Header.Set("X-Request-ID", tc.RequestID)
+		}
+	}
+	return rsp
+}
+
+// TraceFromContext returns the trace of the request being served in ctx: that set by TraceFilter, or if the filter
+// isn't in use, the trace in the headers of the served Request when ctx is one (or a Request whose context is one).
+func TraceFromContext(ctx context.Context) (TraceContext, bool) {
+	if ctx == nil {
+		return TraceContext{}, false
+	}
+	if tc, ok := ctx.Value(traceKey).(TraceContext); ok {
+		return tc, true
+	}
+	for {
+		var req *Request
+		switch c := ctx.(type) {
+		case Request:
+			req = &c
+		case *Request:
+			req = c
+		case sendContext:
+			ctx = c.original
+			continue
+		default:
+			return TraceContext{}, false
+		}
+		if req.rw != nil {
+			tc, ok := ParseTraceHeaders(req.Header)
+			return tc, ok && tc.TraceID != ""
+		}
+		ctx = req.Context
+	}
+}
+
+// ParseTraceHeaders returns the trace context in the headers, using DefaultTracePropagators: by default, from
+// traceparent and tracestate if present, or else from the b3 header or the X-B3-TraceId, X-B3-SpanId and X-B3-Sampled
+// headers. It returns false if there is no valid trace, though the X-Request-ID header's value is returned regardless.
+func ParseTraceHeaders(h http.Header) (TraceContext, bool) {
+	return DefaultTracePropagators.Extract(h)
+}
+
+// SetHeaders sets the headers which propagate the trace context in h, using DefaultTracePropagators. By default, the
+// W3C and both B3 formats are set, so the trace is followed whichever a downstream service understands.
+func (t TraceContext) SetHeaders(h http.Header) {
+	DefaultTracePropagators.Inject(t, h)
+}
+
+// child returns the context of a new span within the trace, caused by t's span.
+func (t TraceContext) child() TraceContext {
+	t.ParentSpanID = t.SpanID
+	t.SpanID = randomHex(8)
+	return t
+}
+
+// WithTracePropagation makes the client pass the trace of the request being served on to downstream services: for
+// requests whose context is (or derives from) a served request, it sets the trace headers for a new span, a child of
+// the served request's, and the served request's X-Request-ID. Requests which already have trace headers are sent
+// unchanged. The headers are set by the propagators, or DefaultTracePropagators if none are passed.
+func WithTracePropagation(propagators ...TracePropagator) ClientOption {
+	return func(o *clientOptions) {
+		o.tracing = true
+		o.propagators = propagators
+	}
+}
+
+// tracePropagationFilter returns a filter which sets the trace headers on requests, from their contexts.
+func tracePropagationFilter(propagators TracePropagators) Filter {
+	return func(req Request, svc Service) Response {
+		chain := propagators
+		if len(chain) == 0 {
+			chain = DefaultTracePropagators
+		}
+		if chain.present(req.Header) {
+			return svc(req)
+		}
+		tc, ok := TraceFromContext(req.Context)
+		if !ok {
+			return svc(req)
+		}
+		req.Header = req.Header.Clone()
+		if req.Header == nil {
+			req.Header = http.Header{}
+		}
+		if req.Header.Get("X-Request-ID") != "" {
+			tc.RequestID = ""
+		}
+		chain.Inject(tc.child(), req.Header)
+		return svc(req)
+	}
+}
+
+// padTraceID extends a 64-bit B3 trace ID to 128 bits.
+func padTraceID(id string) string {
+	if len(id) == 16 {
+		return strings.Repeat("0", 16) + id
+	}
+	return id
+}
+
+// isHex returns whether s is n hex digits.
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// randomHex returns n random bytes, hex-encoded.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,203 @@
+package libhttp
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// A TracePropagator extracts trace contexts from, and injects them into, request headers in one format.
+type TracePropagator interface {
+	// Extract returns the trace context in the headers, and whether there is a valid trace. Propagators of request
+	// IDs only return a context with its RequestID set, and false.
+	Extract(h http.Header) (TraceContext, bool)
+	// Inject sets the headers which propagate the trace context in h.
+	Inject(tc TraceContext, h http.Header)
+	// Fields returns the names of the headers whose presence means a request already carries a trace.
+	Fields() []string
+}
+
+// The built-in propagators.
+var (
+	// W3CPropagator propagates traces in the W3C Trace Context traceparent and tracestate headers.
+	W3CPropagator TracePropagator = w3cPropagator{}
+	// B3SinglePropagator propagates traces in Zipkin's single b3 header.
+	B3SinglePropagator TracePropagator = b3SinglePropagator{}
+	// B3MultiPropagator propagates traces in Zipkin's X-B3-TraceId, X-B3-SpanId, X-B3-ParentSpanId and X-B3-Sampled
+	// headers.
+	B3MultiPropagator TracePropagator = b3MultiPropagator{}
+	// RequestIDPropagator propagates the request ID in the X-Request-ID header, unchanged, so that a request can be
+	// followed through each of the services it passes through.
+	RequestIDPropagator TracePropagator = requestIDPropagator{}
+)
+
+// DefaultTracePropagators are the propagators used by TraceFilter, WithTracePropagation, ParseTraceHeaders and
+// TraceContext.SetHeaders, unless others are given. It can be changed, but only before use; access is not
+// synchronised.
+var DefaultTracePropagators = TracePropagators{W3CPropagator, B3SinglePropagator, B3MultiPropagator,
+	RequestIDPropagator}
+
+// TracePropagators is a chain of propagators, which is itself a TracePropagator: the trace is extracted by the first
+// propagator, in order, which finds one (and the request ID by the first which finds that), and injected by all of
+// them.
+type TracePropagators []TracePropagator
+
+// Extract implements TracePropagator.
+func (p TracePropagators) Extract(h http.Header) (TraceContext, bool) {
+	var found TraceContext
+	ok, requestID := false, ""
+	for _, prop := range p {
+		tc, tok := prop.Extract(h)
+		if requestID == "" {
+			requestID = tc.RequestID
+		}
+		if tok && !ok {
+			found, ok = tc, true
+		}
+	}
+	if found.RequestID == "" {
+		found.RequestID = requestID
+	}
+	return found, ok
+}
+
+// Inject implements TracePropagator.
+func (p TracePropagators) Inject(tc TraceContext, h http.Header) {
+	for _, prop := range p {
+		prop.Inject(tc, h)
+	}
+}
+
+// Fields implements TracePropagator.
+func (p TracePropagators) Fields() []string {
+	var fields []string
+	for _, prop := range p {
+		fields = append(fields, prop.Fields()...)
+	}
+	return fields
+}
+
+// present returns whether the headers already carry a trace in one of the chain's formats.
+func (p TracePropagators) present(h http.Header) bool {
+	for _, f := range p.Fields() {
+		if h.Get(f) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+type w3cPropagator struct{}
+
+func (w3cPropagator) Extract(h http.Header) (TraceContext, bool) {
+	parts := strings.Split(strings.TrimSpace(h.Get("traceparent")), "-")
+	if len(parts) < 4 || !isHex(parts[0], 2) || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) ||
+		!isHex(parts[3], 2) {
+		return TraceContext{}, false
+	}
+	flags, _ := hex.DecodeString(parts[3])
+	return validTrace(TraceContext{
+		TraceID:    parts[1],
+		SpanID:     parts[2],
+		Sampled:    flags[0]&1 == 1,
+		TraceState: strings.Join(h.Values("tracestate"), ",")})
+}
+
+func (w3cPropagator) Inject(tc TraceContext, h http.Header) {
+	flags := "00"
+	if tc.Sampled {
+		flags = "01"
+	}
+	h.Set("traceparent", "00-"+tc.TraceID+"-"+tc.SpanID+"-"+flags)
+	if tc.TraceState != "" {
+		h.Set("tracestate", tc.TraceState)
+	}
+}
+
+func (w3cPropagator) Fields() []string { return []string{"traceparent"} }
+
+type b3SinglePropagator struct{}
+
+func (b3SinglePropagator) Extract(h http.Header) (TraceContext, bool) {
+	// traceid-spanid[-sampled[-parentspanid]], or just the sampling decision
+	parts := strings.Split(strings.TrimSpace(h.Get("b3")), "-")
+	if len(parts) < 2 {
+		return TraceContext{}, false
+	}
+	tc := TraceContext{
+		TraceID: padTraceID(parts[0]),
+		SpanID:  parts[1],
+		Sampled: len(parts) < 3 || parts[2] == "1" || parts[2] == "d"}
+	if len(parts) > 3 {
+		tc.ParentSpanID = parts[3]
+	}
+	return validTrace(tc)
+}
+
+func (b3SinglePropagator) Inject(tc TraceContext, h http.Header) {
+	sampled := "0"
+	if tc.Sampled {
+		sampled = "1"
+	}
+	v := tc.TraceID + "-" + tc.SpanID + "-" + sampled
+	if tc.ParentSpanID != "" {
+		v += "-" + tc.ParentSpanID
+	}
+	h.Set("b3", v)
+}
+
+func (b3SinglePropagator) Fields() []string { return []string{"b3"} }
+
+type b3MultiPropagator struct{}
+
+func (b3MultiPropagator) Extract(h http.Header) (TraceContext, bool) {
+	if h.Get("X-B3-TraceId") == "" {
+		return TraceContext{}, false
+	}
+	s := h.Get("X-B3-Sampled")
+	return validTrace(TraceContext{
+		TraceID:      padTraceID(h.Get("X-B3-TraceId")),
+		SpanID:       h.Get("X-B3-SpanId"),
+		ParentSpanID: h.Get("X-B3-ParentSpanId"),
+		Sampled:      s == "" || s == "1" || s == "true" || h.Get("X-B3-Flags") == "1"})
+}
+
+func (b3MultiPropagator) Inject(tc TraceContext, h http.Header) {
+	h.Set("X-B3-TraceId", tc.TraceID)
+	h.Set("X-B3-SpanId", tc.SpanID)
+	if tc.ParentSpanID != "" {
+		h.Set("X-B3-ParentSpanId", tc.ParentSpanID)
+	}
+	if tc.Sampled {
+		h.Set("X-B3-Sampled", "1")
+	} else {
+		h.Set("X-B3-Sampled", "0")
+	}
+}
+
+func (b3MultiPropagator) Fields() []string { return []string{"X-B3-TraceId"} }
+
+type requestIDPropagator struct{}
+
+func (requestIDPropagator) Extract(h http.Header) (TraceContext, bool) {
+	return TraceContext{
+		RequestID: h.Get("X-Request-ID")}, false
+}
+
+func (requestIDPropagator) Inject(tc TraceContext, h http.Header) {
+	if tc.RequestID != "" {
+		h.Set("X-Request-ID", tc.RequestID)
+	}
+}
+
+func (requestIDPropagator) Fields() []string { return nil }
+
+// validTrace normalises the IDs of an extracted trace context, and returns whether they are valid.
+func validTrace(tc TraceContext) (TraceContext, bool) {
+	tc.TraceID, tc.SpanID = strings.ToLower(tc.TraceID), strings.ToLower(tc.SpanID)
+	if !isHex(tc.TraceID, 32) || !isHex(tc.SpanID, 16) || tc.TraceID == strings.Repeat("0", 32) ||
+		tc.SpanID == strings.Repeat("0", 16) {
+		return TraceContext{}, false
+	}
+	return tc, true
+}
@@ -0,0 +1,112 @@
+package libhttp
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTracePropagatorsRoundTrip(t *testing.T) {
+	t.Parallel()
+	tc := TraceContext{
+		TraceID:      "4bf92f3577b34da6a3ce929d0e0e4736",
+		SpanID:       "00f067aa0ba902b7",
+		ParentSpanID: "e457b5a2e4d86bd1",
+		Sampled:      true,
+		RequestID:    "req-1"}
+	for name, p := range map[string]TracePropagator{
+		"w3c":       W3CPropagator,
+		"b3 single": B3SinglePropagator,
+		"b3 multi":  B3MultiPropagator} {
+		h := http.Header{}
+		p.Inject(tc, h)
+		got, ok := p.Extract(h)
+		require.True(t, ok, name)
+		assert.Equal(t, tc.TraceID, got.TraceID, name)
+		assert.Equal(t, tc.SpanID, got.SpanID, name)
+		assert.True(t, got.Sampled, name)
+		assert.Empty(t, got.RequestID, name)
+		assert.Empty(t, h.Get("X-Request-ID"), name)
+		for _, f := range p.Fields() {
+			assert.NotEmpty(t, h.Get(f), name)
+		}
+	}
+
+	h := http.Header{}
+	B3SinglePropagator.Inject(tc, h)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1-e457b5a2e4d86bd1", h.Get("b3"))
+
+	h = http.Header{}
+	RequestIDPropagator.Inject(tc, h)
+	assert.Equal(t, http.Header{"X-Request-Id": {"req-1"}}, h)
+	got, ok := RequestIDPropagator.Extract(h)
+	assert.False(t, ok)
+	assert.Equal(t, "req-1", got.RequestID)
+}
+
+func TestTracePropagatorsChain(t *testing.T) {
+	t.Parallel()
+	chain := TracePropagators{B3MultiPropagator, W3CPropagator, RequestIDPropagator}
+	h := http.Header{
+		"Traceparent":  {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		"X-B3-Traceid": {"80f198ee56343ba864fe8b2a57d3eff7"},
+		"X-B3-Spanid":  {"e457b5a2e4d86bd1"},
+		"X-Request-Id": {"req-1"}}
+	tc, ok := chain.Extract(h)
+	require.True(t, ok)
+	assert.Equal(t, "80f198ee56343ba864fe8b2a57d3eff7", tc.TraceID, "the first propagator which finds a trace wins")
+	assert.Equal(t, "req-1", tc.RequestID)
+
+	// An invalid trace in one format falls back to the next
+	h.Set("X-B3-TraceId", "nonsense")
+	tc, ok = chain.Extract(h)
+	require.True(t, ok)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", tc.TraceID)
+
+	out := http.Header{}
+	chain.Inject(tc, out)
+	assert.NotEmpty(t, out.Get("traceparent"))
+	assert.NotEmpty(t, out.Get("X-B3-TraceId"))
+	assert.Empty(t, out.Get("b3"))
+	assert.Equal(t, "req-1", out.Get("X-Request-ID"))
+	assert.Equal(t, []string{"X-B3-TraceId", "traceparent"}, chain.Fields())
+}
+
+func TestTraceFilterWithPropagators(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	var got http.Header
+	backend, err := Listen(Service(func(req Request) Response {
+		got = req.Header.Clone()
+		return req.Response(nil)
+	}), "localhost:0")
+	require.NoError(t, err)
+	defer backend.Stop(ctx)
+
+	client := NewClient(WithTracePropagation(B3SinglePropagator, RequestIDPropagator))
+	frontend := Service(func(req Request) Response {
+		return NewRequest(req, "GET", "http://"+backend.Listener().Addr().String(), nil).SendVia(client).Response()
+	}).Filter(TraceFilterWith(B3SinglePropagator, RequestIDPropagator))
+	s, err := Listen(frontend, "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(ctx)
+
+	req := NewRequest(ctx, "GET", "http://"+s.Listener().Addr().String(), nil)
+	req.Header.Set("b3", "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("X-Request-ID", "req-1")
+	rsp := req.Send().Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, "req-1", rsp.Header.Get("X-Request-ID"))
+
+	require.NotNil(t, got)
+	assert.Empty(t, got.Get("traceparent"), "only the configured formats are propagated")
+	assert.Empty(t, got.Get("X-B3-TraceId"))
+	assert.Equal(t, "req-1", got.Get("X-Request-ID"))
+	downstream, ok := B3SinglePropagator.Extract(got)
+	require.True(t, ok)
+	assert.Equal(t, "80f198ee56343ba864fe8b2a57d3eff7", downstream.TraceID, "the b3 trace was continued")
+}
@@ -0,0 +1,160 @@
+package libhttp
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTraceHeaders(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name   string
+		header http.Header
+		want   TraceContext
+		ok     bool
+	}{
+		{"traceparent", http.Header{
+			"Traceparent":  {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+			"Tracestate":   {"congo=t61rcWkgMzE"},
+			"X-Request-Id": {"req-1"}},
+			TraceContext{
+				TraceID:    "4bf92f3577b34da6a3ce929d0e0e4736",
+				SpanID:     "00f067aa0ba902b7",
+				Sampled:    true,
+				TraceState: "congo=t61rcWkgMzE",
+				RequestID:  "req-1"}, true},
+		{"traceparent unsampled", http.Header{
+			"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"}},
+			TraceContext{
+				TraceID: "4bf92f3577b34da6a3ce929d0e0e4736",
+				SpanID:  "00f067aa0ba902b7"}, true},
+		{"traceparent zero trace", http.Header{
+			"Traceparent": {"00-00000000000000000000000000000000-00f067aa0ba902b7-01"}},
+			TraceContext{}, false},
+		{"traceparent bad version", http.Header{
+			"Traceparent": {"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}},
+			TraceContext{}, false},
+		{"b3 single", http.Header{
+			"B3": {"80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1-05e3ac9a4f6e3b90"}},
+			TraceContext{
+				TraceID:      "80f198ee56343ba864fe8b2a57d3eff7",
+				SpanID:       "e457b5a2e4d86bd1",
+				ParentSpanID: "05e3ac9a4f6e3b90",
+				Sampled:      true}, true},
+		{"b3 multi 64-bit", http.Header{
+			"X-B3-Traceid": {"a3ce929d0e0e4736"},
+			"X-B3-Spanid":  {"00f067aa0ba902b7"},
+			"X-B3-Sampled": {"0"}},
+			TraceContext{
+				TraceID: "0000000000000000a3ce929d0e0e4736",
+				SpanID:  "00f067aa0ba902b7"}, true},
+		{"request id only", http.Header{
+			"X-Request-Id": {"req-2"}},
+			TraceContext{
+				RequestID: "req-2"}, false}}
+	for _, c := range cases {
+		tc, ok := ParseTraceHeaders(c.header)
+		assert.Equal(t, c.ok, ok, c.name)
+		assert.Equal(t, c.want, tc, c.name)
+	}
+}
+
+func TestTracePropagation(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	backend, err := Listen(Service(func(req Request) Response {
+		return req.Response(map[string]string{
+			"traceparent":  req.Header.Get("traceparent"),
+			"b3trace":      req.Header.Get("X-B3-TraceId"),
+			"b3parent":     req.Header.Get("X-B3-ParentSpanId"),
+			"x-request-id": req.Header.Get("X-Request-ID")})
+	}), "localhost:0")
+	require.NoError(t, err)
+	defer backend.Stop(ctx)
+	backendURL := "http://" + backend.Listener().Addr().String()
+
+	client := NewClient(WithTracePropagation(), WithRequestTimeout(10*time.Second))
+	var served TraceContext
+	frontendSvc := Service(func(req Request) Response {
+		served, _ = TraceFromContext(req)
+		return NewRequest(req, "GET", backendURL, nil).SendVia(client).Response()
+	})
+	frontend, err := Listen(frontendSvc.Filter(TraceFilter), "localhost:0")
+	require.NoError(t, err)
+	defer frontend.Stop(ctx)
+	frontendURL := "http://" + frontend.Listener().Addr().String()
+
+	// Continuing the caller's trace
+	req := NewRequest(ctx, "GET", frontendURL, nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("X-Request-ID", "req-1")
+	rsp := req.Send().Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, "req-1", rsp.Header.Get("X-Request-ID"))
+	got := map[string]string{}
+	require.NoError(t, rsp.Decode(&got))
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", served.TraceID)
+	assert.Equal(t, "00f067aa0ba902b7", served.ParentSpanID)
+	downstream, ok := ParseTraceHeaders(http.Header{"Traceparent": {got["traceparent"]}})
+	require.True(t, ok)
+	assert.Equal(t, served.TraceID, downstream.TraceID)
+	assert.NotEqual(t, served.SpanID, downstream.SpanID)
+	assert.Equal(t, served.TraceID, got["b3trace"])
+	assert.Equal(t, served.SpanID, got["b3parent"])
+	assert.Equal(t, "req-1", got["x-request-id"])
+
+	// Starting a new one
+	rsp = NewRequest(ctx, "GET", frontendURL, nil).Send().Response()
+	require.NoError(t, rsp.Error)
+	got = map[string]string{}
+	require.NoError(t, rsp.Decode(&got))
+	assert.NotEmpty(t, rsp.Header.Get("X-Request-ID"))
+	assert.Equal(t, rsp.Header.Get("X-Request-ID"), got["x-request-id"])
+	assert.Len(t, served.TraceID, 32)
+	assert.Equal(t, served.TraceID, got["b3trace"])
+
+	// Requests not made on behalf of a served request are sent unchanged
+	rsp = NewRequest(ctx, "GET", backendURL, nil).SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+	got = map[string]string{}
+	require.NoError(t, rsp.Decode(&got))
+	assert.Equal(t, "", got["traceparent"])
+	assert.Equal(t, "", got["x-request-id"])
+}
+
+func TestTraceFromContextWithoutFilter(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	backend, err := Listen(Service(func(req Request) Response {
+		return req.Response(req.Header.Get("X-B3-TraceId"))
+	}), "localhost:0")
+	require.NoError(t, err)
+	defer backend.Stop(ctx)
+	backendURL := "http://" + backend.Listener().Addr().String()
+
+	client := NewClient(WithTracePropagation(), WithRequestTimeout(10*time.Second))
+	var tc TraceContext
+	var ok bool
+	s, err := Listen(Service(func(req Request) Response {
+		tc, ok = TraceFromContext(NewRequest(req, "GET", "http://example.com", nil))
+		return NewRequest(req, "GET", backendURL, nil).SendVia(client).Response()
+	}), "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(ctx)
+
+	req := NewRequest(ctx, "GET", "http://"+s.Listener().Addr().String(), nil)
+	req.Header.Set("b3", "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1")
+	rsp := req.Send().Response()
+	require.NoError(t, rsp.Error)
+	require.True(t, ok)
+	assert.Equal(t, "e457b5a2e4d86bd1", tc.SpanID)
+	var downstream string
+	require.NoError(t, rsp.Decode(&downstream))
+	assert.Equal(t, "80f198ee56343ba864fe8b2a57d3eff7", downstream)
+}
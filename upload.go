@@ -0,0 +1,116 @@
+package libhttp
+
+import (
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+const uploadProgressInterval = 100 * time.Millisecond // the most often progress callbacks are made
+
+// SetBodyReader makes the request's body stream from the reader as the request is sent, rather than being buffered
+// in memory, for uploads of large files and the like. If length is known (the size of a file, say), it is sent as the
+// Content-Length, and the reader must produce exactly that many bytes; if length is negative, the body is sent with
+// chunked transfer encoding. If the reader is an io.Closer, it is closed once the body has been sent.
+//
+//  f, err := os.Open("backup.tar.gz")
+//  ...
+//  fi, _ := f.Stat()
+//  req := libhttp.NewRequest(ctx, "PUT", "https://storage.example.com/backups/1", nil)
+//  req.SetBodyReader(f, fi.Size())
+//  req.Header.Set("Content-Type", "application/gzip")
+//
+// Filters which may send requests more than once (like RetryFilter) buffer bodies up to a limit, and only send larger
+// ones once.
+func (r *Request) SetBodyReader(body io.Reader, length int64) {
+	rc, ok := body.(io.ReadCloser)
+	if !ok {
+		rc = ioutil.NopCloser(body)
+	}
+	r.Body = rc
+	r.ContentLength = length
+	if length < 0 {
+		r.ContentLength = -1
+	}
+	if length == 0 {
+		rc.Close()
+		r.Body = &bufCloser{}
+	}
+}
+
+// UploadProgress describes how much of a request's body has been sent.
+type UploadProgress struct {
+	Sent           int64         // bytes
+	Total          int64         // bytes, or -1 if the length of the body isn't known
+	Elapsed        time.Duration // since the body started to be sent
+	BytesPerSecond float64       // the average throughput so far
+	Done           bool          // whether the whole body has been sent
+}
+
+// WithUploadProgress returns a copy of the request which calls f as its body is sent, to report progress (to a user,
+// or in logs). f is called at most every 100ms while the body is sent, and then once it has all been sent (with Done
+// set). It is called by the goroutine sending the body, so it shouldn't block.
+//
+// The progress reported is of the body being read for sending, so it runs slightly ahead of what the server has
+// received, by the size of the client's buffers.
+func (r Request) WithUploadProgress(f func(UploadProgress)) Request {
+	if r.Body == nil {
+		return r
+	}
+	r.Body = &progressReader{
+		ReadCloser: r.Body,
+		total:      r.ContentLength,
+		f:          f}
+	return r
+}
+
+// progressReader reports the progress of reading a body.
+type progressReader struct {
+	io.ReadCloser
+	total int64
+	f     func(UploadProgress)
+
+	m        sync.Mutex // bodies can be closed concurrently with reads
+	start    time.Time
+	reported time.Time
+	sent     int64
+	done     bool
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.ReadCloser.Read(b)
+	p.m.Lock()
+	defer p.m.Unlock()
+	now := time.Now()
+	if p.start.IsZero() {
+		p.start = now
+	}
+	p.sent += int64(n)
+	switch {
+	case p.done:
+	case err == io.EOF || (p.total >= 0 && p.sent >= p.total):
+		p.done = true
+		p.report(now)
+	case now.Sub(p.reported) >= uploadProgressInterval:
+		p.report(now)
+	}
+	return n, err
+}
+
+// report calls the callback with the progress so far. p.m must be held.
+func (p *progressReader) report(now time.Time) {
+	p.reported = now
+	progress := UploadProgress{
+		Sent:    p.sent,
+		Total:   p.total,
+		Elapsed: now.Sub(p.start),
+		Done:    p.done}
+	if progress.Elapsed > 0 {
+		progress.BytesPerSecond = float64(p.sent) / progress.Elapsed.Seconds()
+	}
+	if p.total < 0 {
+		progress.Total = -1
+	}
+	p.f(progress)
+}
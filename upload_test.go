@@ -0,0 +1,89 @@
+package libhttp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// slowReader reads from r, pausing before each read.
+type slowReader struct {
+	r     io.Reader
+	pause time.Duration
+}
+
+func (s slowReader) Read(b []byte) (int, error) {
+	time.Sleep(s.pause)
+	if len(b) > 64*1024 {
+		b = b[:64*1024]
+	}
+	return s.r.Read(b)
+}
+
+func TestStreamingUpload(t *testing.T) {
+	t.Parallel()
+	s, err := Listen(Service(func(req Request) Response {
+		b, err := ioutil.ReadAll(req.Body)
+		require.NoError(t, err)
+		return req.Response(map[string]interface{}{
+			"length":  len(b),
+			"header":  req.ContentLength,
+			"chunked": len(req.TransferEncoding) > 0 && req.TransferEncoding[0] == "chunked"})
+	}), "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+	url := "http://" + s.Listener().Addr().String()
+	data := bytes.Repeat([]byte("x"), 1<<20)
+
+	upload := func(length int64) (map[string]interface{}, []UploadProgress) {
+		var m sync.Mutex
+		var progress []UploadProgress
+		req := NewRequest(context.Background(), "PUT", url, nil)
+		req.SetBodyReader(slowReader{bytes.NewReader(data), 10 * time.Millisecond}, length)
+		req = req.WithUploadProgress(func(p UploadProgress) {
+			m.Lock()
+			defer m.Unlock()
+			progress = append(progress, p)
+		})
+		rsp := req.SendVia(NewClient()).Response()
+		require.NoError(t, rsp.Error)
+		body := map[string]interface{}{}
+		require.NoError(t, rsp.Decode(&body))
+		m.Lock()
+		defer m.Unlock()
+		return body, progress
+	}
+
+	body, progress := upload(int64(len(data)))
+	assert.Equal(t, map[string]interface{}{
+		"length":  float64(len(data)),
+		"header":  float64(len(data)),
+		"chunked": false}, body)
+	require.True(t, len(progress) > 2, "%d progress reports", len(progress))
+	last := progress[len(progress)-1]
+	assert.True(t, last.Done)
+	assert.EqualValues(t, len(data), last.Sent)
+	assert.EqualValues(t, len(data), last.Total)
+	assert.True(t, last.BytesPerSecond > 0)
+	for i := 1; i < len(progress); i++ {
+		assert.True(t, progress[i].Sent >= progress[i-1].Sent)
+		assert.False(t, progress[i-1].Done)
+	}
+
+	body, progress = upload(-1)
+	assert.Equal(t, map[string]interface{}{
+		"length":  float64(len(data)),
+		"header":  float64(-1),
+		"chunked": true}, body)
+	last = progress[len(progress)-1]
+	assert.True(t, last.Done)
+	assert.EqualValues(t, len(data), last.Sent)
+	assert.EqualValues(t, -1, last.Total)
+}
@@ -0,0 +1,324 @@
+package libhttp
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/monzo/terrors"
+	"github.com/monzo/terrors/proto"
+)
+
+const (
+	// validationErrCode is the code (beneath bad_request) of errors returned when input fails validation
+	validationErrCode = "validation"
+	// fieldParamPrefix prefixes the names of invalid fields in the params of validation errors
+	fieldParamPrefix = "field:"
+)
+
+// A Validatable type checks its own validity. Bind calls Validate on its target after populating it, so types can
+// implement checks which can't be expressed with validate tags.
+//
+// Validate may return a ValidationError to report problems with particular fields; any other error is treated as a
+// bad request.
+type Validatable interface {
+	Validate() error
+}
+
+// A FieldError describes why the value of a field is invalid.
+type FieldError struct {
+	Field  string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// ValidationError returns a bad request error reporting the passed invalid fields. ErrorFilter serialises these errors
+// as RFC 7807 problem details, listing the fields under "invalid-params".
+func ValidationError(fields ...FieldError) *terrors.Error {
+	params := make(map[string]string, len(fields))
+	reasons := make([]string, 0, len(fields))
+	for _, f := range fields {
+		params[fieldParamPrefix+f.Field] = f.Reason
+		reasons = append(reasons, fmt.Sprintf("%s %s", f.Field, f.Reason))
+	}
+	return terrors.BadRequest(validationErrCode, "Invalid input: "+strings.Join(reasons, "; "), params)
+}
+
+// FieldErrors returns the invalid fields reported by a validation error (including one received from a downstream
+// service), sorted by field name. It returns nil if the error is not a validation error.
+func FieldErrors(err error) []FieldError {
+	if err == nil || !terrors.PrefixMatches(err, terrors.ErrBadRequest, validationErrCode) {
+		return nil
+	}
+	terr := terrors.Wrap(err, nil).(*terrors.Error)
+	var fields []FieldError
+	for k, v := range terr.Params {
+		if strings.HasPrefix(k, fieldParamPrefix) {
+			fields = append(fields, FieldError{
+				Field:  strings.TrimPrefix(k, fieldParamPrefix),
+				Reason: v})
+		}
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Field < fields[j].Field
+	})
+	return fields
+}
+
+// Validate checks the struct pointed to by v against the rules in the validate tags on its fields, and then (if it
+// implements Validatable) its Validate method. The rules are comma-separated:
+//
+//  required    the field must not be its zero value
+//  min=n       numbers must be at least n; strings, slices and maps must have at least n characters or elements
+//  max=n       numbers must be at most n; strings, slices and maps must have at most n characters or elements
+//  oneof=a b c the field's value must be one of those listed
+//
+// Fields are named in errors by their json, form, path, query or header tag (whichever is present first), or by their
+// Go name. Bind validates its target automatically.
+//
+// Tags are parsed the first time a type is validated; if any are invalid (naming an unknown rule, say), Validate
+// returns an internal service error for that type. CheckValidateTags reports such errors up front.
+func Validate(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	var fields []FieldError
+	if rv.Kind() == reflect.Struct {
+		var err error
+		if fields, err = validateStruct(rv); err != nil {
+			return terrors.InternalService("invalid_validate_tag", err.Error(), nil)
+		}
+	}
+	if val, ok := v.(Validatable); ok {
+		if err := val.Validate(); err != nil {
+			fe := FieldErrors(err)
+			if fe == nil {
+				return terrors.WrapWithCode(err, nil, terrors.ErrBadRequest)
+			}
+			fields = append(fields, fe...)
+		}
+	}
+	if len(fields) > 0 {
+		return ValidationError(fields...)
+	}
+	return nil
+}
+
+// CheckValidateTags checks that the validate tags of the struct pointed to by v (or of its type) are valid, so that
+// mistakes can be caught when a service is set up (or in tests) rather than when it is first called:
+//
+//  if err := libhttp.CheckValidateTags(&createUserRequest{}); err != nil {
+//      panic(err)
+//  }
+func CheckValidateTags(v interface{}) error {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+	_, err := validatedFields(t)
+	return err
+}
+
+func validateStruct(v reflect.Value) ([]FieldError, error) {
+	vfs, err := validatedFields(v.Type())
+	if err != nil {
+		return nil, err
+	}
+	var fields []FieldError
+	for _, vf := range vfs {
+		fv := v.FieldByIndex(vf.index)
+		for _, rule := range vf.rules {
+			if reason := rule.check(fv); reason != "" {
+				fields = append(fields, FieldError{
+					Field:  vf.name,
+					Reason: reason})
+				break
+			}
+		}
+	}
+	return fields, nil
+}
+
+// A validatedField is a struct field with validate rules.
+type validatedField struct {
+	index []int
+	name  string
+	rules []validateRule
+}
+
+type validatedFieldsResult struct {
+	fields []validatedField
+	err    error
+}
+
+// validatedFieldsCache caches the parsed rules of each struct type: reflect.Type → validatedFieldsResult
+var validatedFieldsCache sync.Map
+
+// validatedFields returns the fields of a struct type which have validate rules, parsing their tags the first time the
+// type is seen. It returns an error if any of the tags are invalid.
+func validatedFields(t reflect.Type) ([]validatedField, error) {
+	if r, ok := validatedFieldsCache.Load(t); ok {
+		return r.(validatedFieldsResult).fields, r.(validatedFieldsResult).err
+	}
+	fields, err := parseValidatedFields(t, nil)
+	validatedFieldsCache.Store(t, validatedFieldsResult{fields, err})
+	return fields, err
+}
+
+func parseValidatedFields(t reflect.Type, index []int) ([]validatedField, error) {
+	var fields []validatedField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		fIndex := append(append([]int(nil), index...), i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			embedded, err := parseValidatedFields(f.Type, fIndex)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, embedded...)
+			continue
+		}
+		tag, ok := f.Tag.Lookup("validate")
+		if !ok || f.PkgPath != "" {
+			continue
+		}
+		vf := validatedField{
+			index: fIndex,
+			name:  fieldName(f)}
+		for _, rule := range strings.Split(tag, ",") {
+			r, err := parseValidateRule(strings.TrimSpace(rule))
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %v", t, f.Name, err)
+			}
+			vf.rules = append(vf.rules, r)
+		}
+		fields = append(fields, vf)
+	}
+	return fields, nil
+}
+
+// fieldName returns the name by which a field is known to clients.
+func fieldName(f reflect.StructField) string {
+	for _, tag := range []string{"json", "form", "path", "query", "header"} {
+		if name := strings.Split(f.Tag.Get(tag), ",")[0]; name != "" && name != "-" {
+			return name
+		}
+	}
+	return f.Name
+}
+
+// A validateRule is a parsed rule from a validate tag.
+type validateRule struct {
+	name, arg string
+	limit     float64  // of min and max rules
+	options   []string // of oneof rules
+}
+
+func parseValidateRule(rule string) (validateRule, error) {
+	r := validateRule{
+		name: rule}
+	if i := strings.IndexByte(rule, '='); i >= 0 {
+		r.name, r.arg = rule[:i], rule[i+1:]
+	}
+	switch r.name {
+	case "required":
+	case "min", "max":
+		limit, err := strconv.ParseFloat(r.arg, 64)
+		if err != nil {
+			return r, fmt.Errorf("invalid validate rule %q", rule)
+		}
+		r.limit = limit
+	case "oneof":
+		r.options = strings.Fields(r.arg)
+		if len(r.options) == 0 {
+			return r, fmt.Errorf("invalid validate rule %q", rule)
+		}
+	default:
+		return r, fmt.Errorf("unknown validate rule %q", rule)
+	}
+	return r, nil
+}
+
+// check checks the rule against a value, returning the reason it fails or an empty string.
+func (r validateRule) check(v reflect.Value) string {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			if r.name == "required" {
+				return "is required"
+			}
+			return ""
+		} else if r.name == "required" {
+			return "" // a pointer distinguishes an explicit zero value from one which is missing
+		}
+		v = v.Elem()
+	}
+
+	switch r.name {
+	case "required":
+		if v.IsZero() {
+			return "is required"
+		}
+	case "min", "max":
+		n, unit := 0.0, ""
+		switch v.Kind() {
+		case reflect.String:
+			n, unit = float64(len([]rune(v.String()))), "characters"
+		case reflect.Slice, reflect.Array, reflect.Map:
+			n, unit = float64(v.Len()), "elements"
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n = float64(v.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			n = float64(v.Uint())
+		case reflect.Float32, reflect.Float64:
+			n = v.Float()
+		default:
+			return ""
+		}
+		switch {
+		case r.name == "min" && n < r.limit && unit != "":
+			return fmt.Sprintf("must have at least %s %s", r.arg, unit)
+		case r.name == "min" && n < r.limit:
+			return fmt.Sprintf("must be at least %s", r.arg)
+		case r.name == "max" && n > r.limit && unit != "":
+			return fmt.Sprintf("must have at most %s %s", r.arg, unit)
+		case r.name == "max" && n > r.limit:
+			return fmt.Sprintf("must be at most %s", r.arg)
+		}
+	case "oneof":
+		s := fmt.Sprint(v.Interface())
+		for _, option := range r.options {
+			if s == option {
+				return ""
+			}
+		}
+		return fmt.Sprintf("must be one of: %s", strings.Join(r.options, ", "))
+	}
+	return ""
+}
+
+// problemDetails is the body of a validation error response: an RFC 7807 problem document which is also a valid
+// serialised terror, so that libhttp clients see the original error.
+type problemDetails struct {
+	*terrorsproto.Error
+	Type          string       `json:"type"`
+	Title         string       `json:"title"`
+	Status        int          `json:"status"`
+	Detail        string       `json:"detail"`
+	InvalidParams []FieldError `json:"invalid-params"`
+}
+
+func newProblemDetails(terr *terrors.Error) problemDetails {
+	return problemDetails{
+		Error:         terrors.Marshal(terr),
+		Type:          "about:blank",
+		Title:         "Bad Request",
+		Status:        ErrorStatusCode(terr),
+		Detail:        terr.Message,
+		InvalidParams: FieldErrors(terr)}
+}
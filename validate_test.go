@@ -0,0 +1,115 @@
+package libhttp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type validateTestInput struct {
+	Name  string   `json:"name" validate:"required,max=5"`
+	Kind  string   `json:"kind" validate:"oneof=a b"`
+	Count *int     `query:"count" validate:"required,min=1"`
+	Tags  []string `validate:"max=2"`
+}
+
+func (v validateTestInput) Validate() error {
+	if v.Name == "admin" {
+		return ValidationError(FieldError{Field: "name", Reason: "is reserved"})
+	}
+	return nil
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	zero, one := 0, 1
+	assert.NoError(t, Validate(&validateTestInput{Name: "bob", Kind: "a", Count: &one}))
+	assert.Equal(t, []FieldError{
+		{Field: "Tags", Reason: "must have at most 2 elements"},
+		{Field: "count", Reason: "must be at least 1"},
+		{Field: "kind", Reason: "must be one of: a, b"},
+		{Field: "name", Reason: "must have at most 5 characters"}},
+		FieldErrors(Validate(&validateTestInput{Name: "robert", Kind: "c", Count: &zero, Tags: []string{"x", "y", "z"}})))
+	assert.Equal(t, []FieldError{
+		{Field: "count", Reason: "is required"},
+		{Field: "name", Reason: "is reserved"}},
+		FieldErrors(Validate(&validateTestInput{Name: "admin", Kind: "b"})))
+}
+
+func TestValidateInvalidTags(t *testing.T) {
+	t.Parallel()
+
+	type unknownRule struct {
+		Name string `validate:"required,email"`
+	}
+	type invalidArg struct {
+		Age int `validate:"min=ten"`
+	}
+	type embedded struct {
+		Kind string `validate:"oneof="`
+	}
+	type invalidEmbedded struct {
+		embedded
+	}
+
+	// Invalid tags are errors, rather than panics, whether they're found up front or when validating
+	assert.EqualError(t, CheckValidateTags(&unknownRule{}),
+		`libhttp.unknownRule.Name: unknown validate rule "email"`)
+	assert.EqualError(t, CheckValidateTags(invalidArg{}),
+		`libhttp.invalidArg.Age: invalid validate rule "min=ten"`)
+	assert.EqualError(t, CheckValidateTags(&invalidEmbedded{}),
+		`libhttp.embedded.Kind: invalid validate rule "oneof="`)
+	assert.NoError(t, CheckValidateTags(&validateTestInput{}))
+	for _, v := range []interface{}{&unknownRule{Name: "bob"}, &invalidArg{}, &invalidEmbedded{}} {
+		var err error
+		require.NotPanics(t, func() { err = Validate(v) })
+		require.Error(t, err)
+		assert.Equal(t, http.StatusInternalServerError, ErrorStatusCode(err))
+		assert.Empty(t, FieldErrors(err))
+	}
+}
+
+func TestValidationErrorResponse(t *testing.T) {
+	t.Parallel()
+
+	svc := Service(func(req Request) Response {
+		input := validateTestInput{}
+		if err := Bind(req, &input); err != nil {
+			return Response{Error: err}
+		}
+		return req.Response(input)
+	}).Filter(ErrorFilter)
+	s, err := Listen(svc, "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+
+	url := fmt.Sprintf("http://%s/?count=0", s.Listener().Addr())
+	req := NewRequest(context.Background(), "POST", url, map[string]string{"kind": "a"})
+	rsp := req.SendVia(HttpService(&http.Transport{})).Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+	assert.Equal(t, "application/problem+json", rsp.Header.Get("Content-Type"))
+	b, err := rsp.BodyBytes(false)
+	require.NoError(t, err)
+	problem := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(b, &problem))
+	assert.Equal(t, "Bad Request", problem["title"])
+	assert.EqualValues(t, 400, problem["status"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"name": "count", "reason": "must be at least 1"},
+		map[string]interface{}{"name": "name", "reason": "is required"}}, problem["invalid-params"])
+
+	// Clients using ErrorFilter receive the original error
+	req = NewRequest(context.Background(), "POST", url, map[string]string{"kind": "a"})
+	rsp = req.SendVia(HttpService(&http.Transport{}).Filter(ErrorFilter)).Response()
+	require.Error(t, rsp.Error)
+	assert.Equal(t, []FieldError{
+		{Field: "count", Reason: "must be at least 1"},
+		{Field: "name", Reason: "is required"}}, FieldErrors(rsp.Error))
+}
@@ -0,0 +1,319 @@
+package libhttp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/monzo/terrors"
+)
+
+// vcrRedacted replaces scrubbed values in cassettes.
+const vcrRedacted = "REDACTED"
+
+// A VCROption configures a VCR.
+type VCROption func(*vcrOptions)
+
+type vcrOptions struct {
+	record, replayOnly bool
+	headers            []string
+	query              []string
+	scrubBody          func([]byte) []byte
+	matchBody          bool
+}
+
+// VCRRecord makes the VCR record interactions even if its cassette exists already, replacing it (to refresh a
+// cassette after the API has changed, say).
+func VCRRecord() VCROption {
+	return func(o *vcrOptions) {
+		o.record = true
+	}
+}
+
+// VCRReplayOnly makes NewVCR fail if the cassette doesn't exist, rather than recording it, so tests run in CI can't
+// reach the network.
+func VCRReplayOnly() VCROption {
+	return func(o *vcrOptions) {
+		o.replayOnly = true
+	}
+}
+
+// VCRScrubHeaders adds headers whose values are replaced with "REDACTED" in the cassette, in requests and responses.
+// Headers registered with RegisterSensitiveHeader (Authorization, cookies and token headers, amongst others) are always
+// scrubbed, as are usernames and passwords in URLs.
+func VCRScrubHeaders(names ...string) VCROption {
+	return func(o *vcrOptions) {
+		o.headers = append(o.headers, names...)
+	}
+}
+
+// VCRScrubQuery adds query parameters whose values are replaced with "REDACTED" in the cassette (API keys passed in
+// URLs, say). Parameters registered with RegisterSensitiveParam are always scrubbed. Requests are matched to recorded
+// interactions after scrubbing, so the real values needn't be known when replaying.
+func VCRScrubQuery(names ...string) VCROption {
+	return func(o *vcrOptions) {
+		o.query = append(o.query, names...)
+	}
+}
+
+// VCRScrubBody sets a function which scrubs secrets from request and response bodies before they are saved in the
+// cassette. It is passed a copy of the body, and returns the body to save.
+func VCRScrubBody(f func(body []byte) []byte) VCROption {
+	return func(o *vcrOptions) {
+		o.scrubBody = f
+	}
+}
+
+// VCRMatchBody makes requests only match recorded interactions with the same (scrubbed) body, as well as the same
+// method and URL.
+func VCRMatchBody() VCROption {
+	return func(o *vcrOptions) {
+		o.matchBody = true
+	}
+}
+
+// A VCR is an http.RoundTripper which records the interactions (requests and their responses) of a client with real
+// services in a file, called a cassette, and replays them in later runs, so integration tests against third-party
+// APIs are deterministic and don't need the network or credentials:
+//
+//  vcr, err := libhttp.NewVCR("testdata/payments.json", nil, libhttp.VCRScrubQuery("sig"))
+//  require.NoError(t, err)
+//  defer vcr.Save()
+//  client := libhttp.NewClient(libhttp.WithRoundTripper(vcr))
+//
+// If the cassette exists, the VCR replays it: each request is responded to with the first unused interaction which
+// has the same method and URL, and requests with no such interaction fail. Otherwise, it records: requests are sent
+// with the next RoundTripper, and the interactions are written to the cassette by Save. Secrets are scrubbed from
+// the interactions before they are saved; see the options.
+type VCR struct {
+	path      string
+	next      http.RoundTripper
+	o         vcrOptions
+	recording bool
+	m         sync.Mutex
+	cassette  []*vcrInteraction
+	used      []bool
+}
+
+type vcrInteraction struct {
+	Request  vcrMessage `json:"request"`
+	Response vcrMessage `json:"response"`
+}
+
+type vcrMessage struct {
+	Method     string      `json:"method,omitempty"`
+	URL        string      `json:"url,omitempty"`
+	StatusCode int         `json:"status_code,omitempty"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+	BodyBase64 bool        `json:"body_base64,omitempty"` // for bodies which aren't valid UTF-8
+}
+
+// NewVCR returns a VCR using the cassette at path, which sends requests with next when recording (the package's
+// RoundTripper if nil).
+func NewVCR(path string, next http.RoundTripper, opts ...VCROption) (*VCR, error) {
+	v := &VCR{
+		path: path,
+		next: next}
+	if v.next == nil {
+		v.next = RoundTripper
+	}
+	for _, opt := range opts {
+		opt(&v.o)
+	}
+	if v.o.record {
+		v.recording = true
+		return v, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err) && !v.o.replayOnly:
+		v.recording = true
+		return v, nil
+	case err != nil:
+		return nil, terrors.Wrap(err, nil)
+	}
+	if err := json.Unmarshal(b, &v.cassette); err != nil {
+		return nil, terrors.Wrap(err, map[string]string{
+			"path": path})
+	}
+	v.used = make([]bool, len(v.cassette))
+	return v, nil
+}
+
+// Recording returns whether the VCR is recording interactions, rather than replaying them.
+func (v *VCR) Recording() bool {
+	return v.recording
+}
+
+// RoundTrip replays or records an interaction for the request.
+func (v *VCR) RoundTrip(r *http.Request) (*http.Response, error) {
+	body, err := readAndRestore(&r.Body)
+	if err != nil {
+		return nil, terrors.Wrap(err, nil)
+	}
+	req := v.message(r.Header, body)
+	req.Method = r.Method
+	req.URL = v.scrubURL(r.URL)
+
+	if !v.recording {
+		return v.replay(r, req)
+	}
+
+	rsp, err := v.next.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	rspBody, err := readAndRestore(&rsp.Body)
+	if err != nil {
+		return nil, terrors.Wrap(err, nil)
+	}
+	recorded := v.message(rsp.Header, rspBody)
+	recorded.StatusCode = rsp.StatusCode
+	v.m.Lock()
+	v.cassette = append(v.cassette, &vcrInteraction{
+		Request:  req,
+		Response: recorded})
+	v.m.Unlock()
+	return rsp, nil
+}
+
+// replay responds to the request with the first unused matching interaction.
+func (v *VCR) replay(r *http.Request, req vcrMessage) (*http.Response, error) {
+	v.m.Lock()
+	defer v.m.Unlock()
+	for i, in := range v.cassette {
+		if v.used[i] || in.Request.Method != req.Method || in.Request.URL != req.URL ||
+			(v.o.matchBody && (in.Request.Body != req.Body || in.Request.BodyBase64 != req.BodyBase64)) {
+			continue
+		}
+		v.used[i] = true
+		body := []byte(in.Response.Body)
+		if in.Response.BodyBase64 {
+			body, _ = base64.StdEncoding.DecodeString(in.Response.Body)
+		}
+		header := in.Response.Header.Clone()
+		if header == nil {
+			header = http.Header{}
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", in.Response.StatusCode, http.StatusText(in.Response.StatusCode)),
+			StatusCode:    in.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          ioutil.NopCloser(bytes.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       r}, nil
+	}
+	return nil, terrors.PreconditionFailed("vcr_no_interaction", fmt.Sprintf("No recorded interaction in %s for %s %s",
+		v.path, req.Method, req.URL), nil)
+}
+
+// Save writes the recorded interactions to the cassette, if the VCR is recording. The file is replaced atomically.
+func (v *VCR) Save() error {
+	if !v.recording {
+		return nil
+	}
+	v.m.Lock()
+	b, err := json.MarshalIndent(v.cassette, "", "  ")
+	v.m.Unlock()
+	if err != nil {
+		return terrors.Wrap(err, nil)
+	}
+	if err := os.MkdirAll(filepath.Dir(v.path), 0755); err != nil {
+		return terrors.Wrap(err, nil)
+	}
+	f, err := ioutil.TempFile(filepath.Dir(v.path), filepath.Base(v.path)+".*")
+	if err != nil {
+		return terrors.Wrap(err, nil)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return terrors.Wrap(err, nil)
+	}
+	if err := f.Close(); err != nil {
+		return terrors.Wrap(err, nil)
+	}
+	return terrors.Wrap(os.Rename(f.Name(), v.path), nil)
+}
+
+// message returns a scrubbed copy of the headers and body.
+func (v *VCR) message(h http.Header, body []byte) vcrMessage {
+	m := vcrMessage{
+		Header: h.Clone()}
+	for name := range m.Header {
+		if isSensitiveHeader(name) {
+			m.Header.Set(name, vcrRedacted)
+		}
+	}
+	for _, name := range v.o.headers {
+		if _, ok := m.Header[http.CanonicalHeaderKey(name)]; ok {
+			m.Header.Set(name, vcrRedacted)
+		}
+	}
+	if v.o.scrubBody != nil && len(body) > 0 {
+		body = v.o.scrubBody(append([]byte(nil), body...))
+	}
+	if utf8.Valid(body) {
+		m.Body = string(body)
+	} else {
+		m.Body, m.BodyBase64 = base64.StdEncoding.EncodeToString(body), true
+	}
+	return m
+}
+
+// scrubURL returns the URL with any userinfo (which is sent as an Authorization header) and the values of scrubbed
+// query parameters replaced.
+func (v *VCR) scrubURL(u *url.URL) string {
+	scrubbed := *u
+	if u.User != nil {
+		scrubbed.User = url.User(vcrRedacted)
+	}
+	if u.RawQuery != "" {
+		q := u.Query()
+		scrub := false
+		for name := range q {
+			if isSensitiveParam(name) {
+				q.Set(name, vcrRedacted)
+				scrub = true
+			}
+		}
+		for _, name := range v.o.query {
+			if _, ok := q[name]; ok {
+				q.Set(name, vcrRedacted)
+				scrub = true
+			}
+		}
+		if scrub {
+			scrubbed.RawQuery = q.Encode()
+		}
+	}
+	return scrubbed.String()
+}
+
+// readAndRestore reads a body, replacing it with a reader over what was read.
+func readAndRestore(body *io.ReadCloser) ([]byte, error) {
+	if *body == nil || *body == http.NoBody {
+		return nil, nil
+	}
+	b, err := ioutil.ReadAll(*body)
+	(*body).Close()
+	if err != nil {
+		return nil, err
+	}
+	*body = ioutil.NopCloser(bytes.NewReader(b))
+	return b, nil
+}
@@ -0,0 +1,131 @@
+package libhttp
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVCR(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cassettes", "api.json")
+	calls := 0
+	s, err := Listen(Service(func(req Request) Response {
+		calls++
+		b, _ := req.BodyBytes(true)
+		rsp := req.Response(map[string]string{
+			"path":  req.URL.Path,
+			"body":  string(b),
+			"token": "secret-token"})
+		rsp.Header.Set("Set-Cookie", "session=secret-session")
+		return rsp
+	}), "localhost:0")
+	require.NoError(t, err)
+	base := "http://" + s.Listener().Addr().String()
+
+	opts := []VCROption{
+		VCRScrubQuery("sig"),
+		VCRScrubBody(func(b []byte) []byte { return bytes.Replace(b, []byte("secret-token"), []byte("xxx"), -1) })}
+	send := func(client Service, method, path string, body interface{}) map[string]string {
+		req := NewRequest(ctx, method, base+path, body)
+		req.Header.Set("Authorization", "Bearer secret-bearer")
+		req.Header.Set("X-Auth-Token", "secret-auth")
+		req.Header.Set("X-Csrf-Token", "secret-csrf")
+		rsp := req.SendVia(client).Response()
+		require.NoError(t, rsp.Error)
+		v := map[string]string{}
+		require.NoError(t, rsp.Decode(&v))
+		return v
+	}
+
+	// Record
+	vcr, err := NewVCR(path, nil, opts...)
+	require.NoError(t, err)
+	assert.True(t, vcr.Recording())
+	client := NewClient(WithRoundTripper(vcr))
+	assert.Equal(t, "secret-token", send(client, "GET", "/a?api_key=k1&access_token=t1&sig=s1&x=1", nil)["token"])
+	send(client, "POST", "/b", map[string]string{"n": "1"})
+	send(client, "GET", "/a?api_key=k1&access_token=t1&sig=s1&x=1", nil)
+	userBase := "http://secret-user:secret-password@" + s.Listener().Addr().String()
+	require.NoError(t, NewRequest(ctx, "GET", userBase+"/c", nil).SendVia(client).Response().Error)
+	require.NoError(t, vcr.Save())
+	assert.Equal(t, 4, calls)
+	s.Stop(ctx)
+
+	b, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	for _, secret := range []string{
+		"secret-token", "secret-session", "secret-bearer", "secret-auth", "secret-csrf", "k1", "t1", "s1",
+		"secret-user", "secret-password"} {
+		assert.NotContains(t, string(b), secret)
+	}
+
+	// Replay, with the server gone and a different key
+	vcr, err = NewVCR(path, nil, opts...)
+	require.NoError(t, err)
+	assert.False(t, vcr.Recording())
+	client = NewClient(WithRoundTripper(vcr))
+	rsp := send(client, "GET", "/a?api_key=k2&access_token=t2&sig=s2&x=1", nil)
+	assert.Equal(t, "/a", rsp["path"])
+	assert.Equal(t, "xxx", rsp["token"])
+	assert.Equal(t, `{"n":"1"}`+"\n", send(client, "POST", "/b", nil)["body"])
+	send(client, "GET", "/a?api_key=k2&access_token=t2&sig=s2&x=1", nil)
+	userBase = strings.Replace(userBase, "secret-user:secret-password", "other:password", 1)
+	require.NoError(t, NewRequest(ctx, "GET", userBase+"/c", nil).SendVia(client).Response().Error)
+	assert.Equal(t, 4, calls)
+
+	// All the interactions have been used
+	r := NewRequest(ctx, "GET", base+"/a?api_key=k2&access_token=t2&sig=s2&x=1", nil).SendVia(client).Response()
+	require.Error(t, r.Error)
+	assert.Contains(t, r.Error.Error(), "No recorded interaction")
+}
+
+func TestVCRMatchBody(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "api.json")
+	s, err := Listen(Service(func(req Request) Response {
+		b, _ := req.BodyBytes(true)
+		return req.Response(string(b))
+	}), "localhost:0")
+	require.NoError(t, err)
+	url := "http://" + s.Listener().Addr().String() + "/echo"
+
+	vcr, err := NewVCR(path, nil, VCRMatchBody())
+	require.NoError(t, err)
+	client := NewClient(WithRoundTripper(vcr))
+	for _, body := range []string{"one", "two"} {
+		require.NoError(t, NewRequest(ctx, "POST", url, body).SendVia(client).Response().Error)
+	}
+	require.NoError(t, vcr.Save())
+	s.Stop(ctx)
+
+	vcr, err = NewVCR(path, nil, VCRMatchBody())
+	require.NoError(t, err)
+	client = NewClient(WithRoundTripper(vcr))
+	for _, body := range []string{"two", "one"} {
+		rsp := NewRequest(ctx, "POST", url, body).SendVia(client).Response()
+		require.NoError(t, rsp.Error)
+		var got string
+		require.NoError(t, rsp.Decode(&got))
+		assert.Contains(t, got, body)
+	}
+}
+
+func TestVCRReplayOnly(t *testing.T) {
+	t.Parallel()
+	_, err := NewVCR(filepath.Join(t.TempDir(), "missing.json"), nil, VCRReplayOnly())
+	require.Error(t, err)
+
+	vcr, err := NewVCR(filepath.Join(t.TempDir(), "missing.json"), http.DefaultTransport, VCRRecord())
+	require.NoError(t, err)
+	assert.True(t, vcr.Recording())
+}